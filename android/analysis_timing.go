@@ -0,0 +1,131 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"time"
+)
+
+// This file implements a watchdog for pathologically slow calls to GenerateAndroidBuildActions.  When
+// SOONG_MODULE_ANALYSIS_THRESHOLD is set to a duration (for example "500ms"), the analysis of every module is
+// timed, and any module that takes longer than the threshold is reported along with the time that was spent
+// globbing and visiting dependencies.  If SOONG_MODULE_ANALYSIS_THRESHOLD_ERROR is also set to true, which is
+// intended for CI builds, slow modules are reported as errors instead of warnings.
+
+var moduleAnalysisThresholdKey = NewOnceKey("moduleAnalysisThreshold")
+
+type moduleAnalysisThreshold struct {
+	threshold time.Duration
+	isError   bool
+}
+
+func (c *config) moduleAnalysisThreshold() moduleAnalysisThreshold {
+	return c.Once(moduleAnalysisThresholdKey, func() interface{} {
+		ret := moduleAnalysisThreshold{}
+		if s := c.Getenv("SOONG_MODULE_ANALYSIS_THRESHOLD"); s != "" {
+			threshold, err := time.ParseDuration(s)
+			if err != nil || threshold < 0 {
+				c.Warningf("ignoring invalid SOONG_MODULE_ANALYSIS_THRESHOLD %q, must be a positive duration like \"500ms\"", s)
+			} else {
+				ret.threshold = threshold
+			}
+		}
+		ret.isError = c.IsEnvTrue("SOONG_MODULE_ANALYSIS_THRESHOLD_ERROR")
+		return ret
+	}).(moduleAnalysisThreshold)
+}
+
+// analysisTiming accumulates the time spent in the expensive parts of a single call to
+// GenerateAndroidBuildActions.  The time spent globbing in the callbacks of a dependency visit is
+// only accounted as glob time.
+type analysisTiming struct {
+	glob     time.Duration
+	depVisit time.Duration
+
+	// depth tracks nested dependency visits, for example a VisitDirectDeps inside a WalkDeps callback,
+	// so that only the outermost visit is accounted.
+	depth int
+}
+
+// beginGlob and endGlob add the time spent globbing to the glob time.  The analysisTiming is only set
+// when a threshold is configured, so callers check it before timing anything to keep the untimed
+// path free of clock reads:
+//
+//	if t := b.analysisTiming; t != nil {
+//		defer t.endGlob(t.beginGlob())
+//	}
+func (t *analysisTiming) beginGlob() time.Time {
+	return time.Now()
+}
+
+func (t *analysisTiming) endGlob(start time.Time) {
+	t.glob += time.Since(start)
+}
+
+// depVisitStart records the state of the analysisTiming at the start of a dependency visit.
+type depVisitStart struct {
+	nested bool
+	time   time.Time
+	glob   time.Duration
+}
+
+// beginDepVisit and endDepVisit add the time spent in a dependency visit, including the time spent in
+// the visit callbacks, to the dependency visiting time.  They are used like beginGlob and endGlob.
+func (t *analysisTiming) beginDepVisit() depVisitStart {
+	t.depth++
+	if t.depth > 1 {
+		return depVisitStart{nested: true}
+	}
+	return depVisitStart{time: time.Now(), glob: t.glob}
+}
+
+func (t *analysisTiming) endDepVisit(start depVisitStart) {
+	t.depth--
+	if !start.nested {
+		t.depVisit += time.Since(start.time) - (t.glob - start.glob)
+	}
+}
+
+// generateAndroidBuildActionsWithTiming calls GenerateAndroidBuildActions on the module, and reports the
+// module if it took longer than the configured threshold.
+func (m *ModuleBase) generateAndroidBuildActionsWithTiming(ctx *moduleContext) {
+	threshold := ctx.Config().moduleAnalysisThreshold()
+	if threshold.threshold == 0 {
+		m.module.GenerateAndroidBuildActions(ctx)
+		return
+	}
+
+	ctx.analysisTiming = &analysisTiming{}
+	start := time.Now()
+	m.module.GenerateAndroidBuildActions(ctx)
+	total := time.Since(start)
+	timing := ctx.analysisTiming
+	ctx.analysisTiming = nil
+
+	if total <= threshold.threshold {
+		return
+	}
+
+	msg := fmt.Sprintf("analysis took %s, exceeding SOONG_MODULE_ANALYSIS_THRESHOLD of %s "+
+		"(glob: %s, visiting dependencies: %s, other: %s)",
+		total, threshold.threshold, timing.glob, timing.depVisit, total-timing.glob-timing.depVisit)
+
+	if threshold.isError {
+		ctx.ModuleErrorf("%s", msg)
+	} else {
+		ctx.ModuleWarningf("%s", msg)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+type analysisTimingTestModule struct {
+	ModuleBase
+}
+
+func analysisTimingTestModuleFactory() Module {
+	module := &analysisTimingTestModule{}
+	InitAndroidModule(module)
+	return module
+}
+
+func (m *analysisTimingTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	ctx.Glob("*.txt", nil)
+	ctx.VisitDirectDeps(func(Module) {})
+}
+
+func TestModuleAnalysisThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		err     string
+		warning string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name: "warning",
+			env: map[string]string{
+				"SOONG_MODULE_ANALYSIS_THRESHOLD": "1ns",
+			},
+			warning: `^module "foo": analysis took .*, exceeding SOONG_MODULE_ANALYSIS_THRESHOLD of 1ns \(glob: .*, visiting dependencies: .*, other: .*\)$`,
+		},
+		{
+			name: "invalid",
+			env: map[string]string{
+				"SOONG_MODULE_ANALYSIS_THRESHOLD": "fast",
+			},
+			warning: `^ignoring invalid SOONG_MODULE_ANALYSIS_THRESHOLD "fast"`,
+		},
+		{
+			name: "not exceeded",
+			env: map[string]string{
+				"SOONG_MODULE_ANALYSIS_THRESHOLD":       "1h",
+				"SOONG_MODULE_ANALYSIS_THRESHOLD_ERROR": "true",
+			},
+		},
+		{
+			name: "error",
+			env: map[string]string{
+				"SOONG_MODULE_ANALYSIS_THRESHOLD":       "1ns",
+				"SOONG_MODULE_ANALYSIS_THRESHOLD_ERROR": "true",
+			},
+			err: `module "foo": analysis took .*, exceeding SOONG_MODULE_ANALYSIS_THRESHOLD of 1ns \(glob: .*, visiting dependencies: .*, other: .*\)`,
+		},
+	}
+
+	buildDir, err := ioutil.TempDir("", "soong_analysis_timing_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := TestConfig(buildDir, test.env)
+			ctx := NewTestContext()
+			ctx.RegisterModuleType("test", ModuleFactoryAdaptor(analysisTimingTestModuleFactory))
+			ctx.MockFileSystem(map[string][]byte{
+				"Android.bp": []byte(`test { name: "foo" }`),
+				"a.txt":      nil,
+			})
+			ctx.Register()
+
+			_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+			FailIfErrored(t, errs)
+			_, errs = ctx.PrepareBuildActions(config)
+			if test.err == "" {
+				FailIfErrored(t, errs)
+			} else {
+				FailIfNoMatchingErrors(t, test.err, errs)
+			}
+
+			warnings := config.Warnings()
+			if test.warning == "" {
+				if len(warnings) > 0 {
+					t.Errorf("expected no warnings, got %q", warnings)
+				}
+			} else if len(warnings) != 1 || !regexp.MustCompile(test.warning).MatchString(warnings[0]) {
+				t.Errorf("expected a warning matching %q, got %q", test.warning, warnings)
+			}
+		})
+	}
+}
+
+func TestAnalysisTimingGlobInDepVisit(t *testing.T) {
+	timing := &analysisTiming{}
+
+	func() {
+		defer timing.endDepVisit(timing.beginDepVisit())
+		func() {
+			defer timing.endDepVisit(timing.beginDepVisit())
+			func() {
+				defer timing.endGlob(timing.beginGlob())
+				time.Sleep(20 * time.Millisecond)
+			}()
+		}()
+	}()
+
+	if timing.glob < 20*time.Millisecond {
+		t.Errorf("expected at least 20ms of glob time, got %s", timing.glob)
+	}
+	if timing.depVisit >= 10*time.Millisecond {
+		t.Errorf("expected the glob time not to be accounted as dependency visiting time, got %s", timing.depVisit)
+	}
+	if timing.depth != 0 {
+		t.Errorf("expected depth 0 after the visits, got %d", timing.depth)
+	}
+}
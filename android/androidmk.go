@@ -275,6 +275,8 @@ func AndroidMkSingleton() Singleton {
 type androidMkSingleton struct{}
 
 func (c *androidMkSingleton) GenerateBuildActions(ctx SingletonContext) {
+	checkRequiredHiddenModules(ctx)
+
 	if !ctx.Config().EmbeddedInMake() {
 		return
 	}
@@ -305,6 +307,50 @@ func (c *androidMkSingleton) GenerateBuildActions(ctx SingletonContext) {
 	})
 }
 
+// checkRequiredHiddenModules reports modules that require a module of which every variant for the same class of
+// OS is hidden from Make by skip_install, no_androidmk or HideFromMake, as nothing would be installed for it.
+func checkRequiredHiddenModules(ctx SingletonContext) {
+	type requiredModule struct {
+		name    string
+		osClass OsClass
+	}
+
+	// Whether all the enabled variants of a module for a class of OS are hidden from Make.
+	hidden := make(map[requiredModule]bool)
+	ctx.VisitAllModules(func(module Module) {
+		if !module.Enabled() {
+			return
+		}
+		key := requiredModule{ctx.ModuleName(module), module.Os().Class}
+		if allHidden, ok := hidden[key]; ok {
+			hidden[key] = allHidden && module.IsHideFromMake()
+		} else {
+			hidden[key] = module.IsHideFromMake()
+		}
+	})
+
+	ctx.VisitAllModules(func(module Module) {
+		if !module.Enabled() || module.IsHideFromMake() {
+			return
+		}
+		base := module.base()
+		check := func(property string, names []string, osClass OsClass) {
+			for _, name := range names {
+				if hidden[requiredModule{name, osClass}] {
+					ctx.ModuleErrorf(module, "%s: %q is not installed or exported to Make, "+
+						"because it sets skip_install or no_androidmk", property, name)
+				}
+			}
+		}
+		check("required", base.commonProperties.Required, module.Os().Class)
+		if module.Os().Class == Device {
+			check("host_required", base.commonProperties.Host_required, Host)
+		} else {
+			check("target_required", base.commonProperties.Target_required, Device)
+		}
+	})
+}
+
 func translateAndroidMk(ctx SingletonContext, mkFile string, mods []blueprint.Module) error {
 	buf := &bytes.Buffer{}
 
@@ -488,6 +534,7 @@ func shouldSkipAndroidMkProcessing(module *ModuleBase) bool {
 
 	return !module.Enabled() ||
 		module.commonProperties.SkipInstall ||
+		module.commonProperties.HideFromMake ||
 		// Make does not understand LinuxBionic
 		module.Os() == LinuxBionic
 }
@@ -0,0 +1,115 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+func testHiddenFromMake(t *testing.T, bp string) (*TestContext, []error) {
+	t.Helper()
+
+	config := TestArchConfig(buildDir, nil)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(fileContextsTestModuleFactory))
+	ctx.RegisterSingletonType("androidmk", SingletonFactoryAdaptor(AndroidMkSingleton))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	if len(errs) > 0 {
+		return ctx, errs
+	}
+	_, errs = ctx.PrepareBuildActions(config)
+	return ctx, errs
+}
+
+func TestHiddenFromMake(t *testing.T) {
+	ctx, errs := testHiddenFromMake(t, `
+		test {
+			name: "foo",
+			host_supported: true,
+			target: {
+				host: {
+					skip_install: true,
+				},
+			},
+		}
+
+		test {
+			name: "bar",
+			host_supported: true,
+			target: {
+				android: {
+					no_androidmk: true,
+				},
+			},
+		}
+	`)
+	FailIfErrored(t, errs)
+
+	device := ctx.ModuleForTests("foo", "android_arm64_armv8-a").Module().base()
+	if device.IsSkipInstall() || device.IsHideFromMake() || shouldSkipAndroidMkProcessing(device) {
+		t.Errorf("expected the device variant of foo to be installed and exported to Make")
+	}
+
+	host := ctx.ModuleForTests("foo", BuildOs.String()+"_x86_64").Module().base()
+	if !host.IsSkipInstall() || !host.IsHideFromMake() || !shouldSkipAndroidMkProcessing(host) {
+		t.Errorf("expected the host variant of foo to be neither installed nor exported to Make")
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_arm64_armv8-a").Module().base()
+	if bar.IsSkipInstall() || !bar.IsHideFromMake() {
+		t.Errorf("expected the device variant of bar to be installed but not exported to Make")
+	}
+}
+
+func TestRequiredHiddenFromMake(t *testing.T) {
+	_, errs := testHiddenFromMake(t, `
+		test {
+			name: "foo",
+			required: ["bar"],
+		}
+
+		test {
+			name: "bar",
+			skip_install: true,
+		}
+	`)
+	FailIfNoMatchingErrors(t, `required: "bar" is not installed or exported to Make`, errs)
+
+	_, errs = testHiddenFromMake(t, `
+		test {
+			name: "foo",
+			host_supported: true,
+			host_required: ["bar"],
+		}
+
+		test {
+			name: "bar",
+			host_supported: true,
+			target: {
+				host: {
+					no_androidmk: true,
+				},
+			},
+		}
+	`)
+	FailIfNoMatchingErrors(t, `host_required: "bar" is not installed or exported to Make`, errs)
+}
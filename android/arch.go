@@ -557,6 +557,11 @@ func newArch(name, multilib string) ArchType {
 	return archType
 }
 
+// ArchTypeList returns the list of all supported architecture types.
+func ArchTypeList() []ArchType {
+	return append([]ArchType(nil), archTypeList...)
+}
+
 func (a ArchType) String() string {
 	return a.Name
 }
@@ -589,6 +594,21 @@ var BuildOs = func() OsType {
 	}
 }()
 
+// BuildArch is the architecture of the machine running the build.  Only the host tools that have
+// variants for it, like the linux_bionic arm64 tools, use it; the host targets are still set by
+// the product variables.  It is Common on architectures that none of the host tools have variants
+// for, which then use the default host tools.
+var BuildArch = func() ArchType {
+	switch runtime.GOARCH {
+	case "amd64":
+		return X86_64
+	case "arm64":
+		return Arm64
+	default:
+		return Common
+	}
+}()
+
 var (
 	osTypeList      []OsType
 	commonTargetMap = make(map[string]Target)
@@ -603,7 +623,7 @@ var (
 
 	osArchTypeMap = map[OsType][]ArchType{
 		Linux:       []ArchType{X86, X86_64},
-		LinuxBionic: []ArchType{X86_64},
+		LinuxBionic: []ArchType{Arm64, X86_64},
 		Darwin:      []ArchType{X86_64},
 		Windows:     []ArchType{X86, X86_64},
 		Android:     []ArchType{Arm, Arm64, Mips, Mips64, X86, X86_64},
@@ -1436,6 +1456,12 @@ func decodeTargetProductVariables(config *config) (map[OsType][]Target, error) {
 		addTarget(LinuxBionic, "x86_64", nil, nil, nil, NativeBridgeDisabled)
 	}
 
+	// The arm64 host tools are cross-compiled on x86 build machines too, so that they are
+	// available to builds on arm64 machines that share the output.
+	if Bool(config.Host_bionic_arm64) {
+		addTarget(LinuxBionic, "arm64", nil, nil, nil, NativeBridgeDisabled)
+	}
+
 	if String(variables.CrossHost) != "" {
 		crossHostOs := osByName(*variables.CrossHost)
 		if crossHostOs == NoOsType {
@@ -0,0 +1,212 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// The artifact metadata registry collects the metadata that modules publish about their final, installed
+// artifacts, like the package name, version and certificate digests of an app, into a single file for the
+// tools that check the images after the build, like the OTA configuration and the verification of
+// preloaded apps:
+//
+//   $OUT_DIR/soong/artifact_metadata.json
+//
+// Modules publish the metadata by implementing ArtifactMetadataProvider, which is called after
+// GenerateAndroidBuildActions has installed the artifacts.  Publishing the metadata may read files, so it
+// is only done when SOONG_GENERATE_ARTIFACT_METADATA is set to true.  The file is built by the
+// soong_artifact_metadata phony target.
+
+func init() {
+	RegisterSingletonType("artifact_metadata", ArtifactMetadataSingletonFactory)
+}
+
+const (
+	envVariableGenerateArtifactMetadata = "SOONG_GENERATE_ARTIFACT_METADATA"
+	artifactMetadataJsonFileName        = "artifact_metadata.json"
+
+	// ArtifactMetadataSchemaVersion is the version of the format of artifact_metadata.json.  It must be
+	// incremented when a field is removed or its meaning changes, adding a field doesn't need a new version.
+	ArtifactMetadataSchemaVersion = 1
+
+	// ArtifactMetadataBuildNumber is replaced by the build number in the fields of ArtifactMetadata when
+	// artifact_metadata.json is built, as the build number is only known then.
+	ArtifactMetadataBuildNumber = "@BUILD_NUMBER@"
+
+	// artifactMetadataCertificateDigestsPrefix starts the placeholder that is replaced by the digests in an
+	// ArtifactMetadata.CertificateDigestsFile when artifact_metadata.json is built.
+	artifactMetadataCertificateDigestsPrefix = "@CERTIFICATE_DIGESTS:"
+)
+
+// artifactMetadataResolveRule substitutes the values that are only known when the build runs into the template of
+// artifact_metadata.json, with one sed expression per value in $expressions.
+var artifactMetadataResolveRule = pctx.AndroidStaticRule("artifactMetadataResolve",
+	blueprint.RuleParams{
+		Command: `sed $expressions $in > $out`,
+	},
+	"expressions")
+
+// ArtifactMetadata is the metadata of an installed artifact.
+type ArtifactMetadata struct {
+	// The name of the module that installs the artifact.
+	Module string `json:"module"`
+
+	// The kind of the artifact, e.g. apk.
+	Kind string `json:"kind"`
+
+	// The path the artifact is installed to.  Paths in the product out directory are relative to it and
+	// start with a '/', e.g. /system/priv-app/Foo/Foo.apk.
+	Path string `json:"path"`
+
+	PackageName string `json:"package_name,omitempty"`
+	VersionCode string `json:"version_code,omitempty"`
+	VersionName string `json:"version_name,omitempty"`
+
+	// The SHA-256 digests of the certificates the artifact is signed with, in lowercase hex.
+	CertificateDigests []string `json:"certificate_digests,omitempty"`
+
+	// A file built by the module that lists the SHA-256 digests of the certificates the artifact is signed
+	// with, one per line, for artifacts whose certificates are only known when the build runs, like presigned
+	// APKs.  The digests are added to CertificateDigests when artifact_metadata.json is built.
+	CertificateDigestsFile Path `json:"-"`
+}
+
+// artifactMetadataFile is the format of artifact_metadata.json.
+type artifactMetadataFile struct {
+	SchemaVersion int                `json:"schema_version"`
+	Artifacts     []ArtifactMetadata `json:"artifacts"`
+}
+
+// ArtifactMetadataProvider is implemented by modules that publish metadata about the artifacts they install.
+// ArtifactMetadata is called after GenerateAndroidBuildActions for the variants that are enabled and
+// installed, and the Module and Path fields that it leaves empty are filled in from the module and the files
+// it installed.
+type ArtifactMetadataProvider interface {
+	ArtifactMetadata(ctx ModuleContext) []ArtifactMetadata
+}
+
+func generateArtifactMetadata(config Config) bool {
+	return config.IsEnvTrue(envVariableGenerateArtifactMetadata)
+}
+
+// recordArtifactMetadata calls the ArtifactMetadataProvider of the module, if it has one, after its build
+// actions have been generated.
+func (m *ModuleBase) recordArtifactMetadata(ctx *moduleContext) {
+	if !generateArtifactMetadata(ctx.Config()) || m.IsSkipInstall() {
+		return
+	}
+	provider, ok := m.module.(ArtifactMetadataProvider)
+	if !ok {
+		return
+	}
+
+	productOut := PathForOutput(ctx, "target", "product", ctx.Config().DeviceName()).String()
+	for _, metadata := range provider.ArtifactMetadata(ctx) {
+		if metadata.Module == "" {
+			metadata.Module = ctx.ModuleName()
+		}
+		if metadata.Path == "" && len(ctx.installFiles) > 0 {
+			metadata.Path = ctx.installFiles[0].String()
+		}
+		metadata.Path = metadataInstallPath(ctx, productOut, metadata.Path)
+		if metadata.CertificateDigestsFile != nil {
+			metadata.CertificateDigests = append(metadata.CertificateDigests,
+				artifactMetadataCertificateDigestsPrefix+metadata.CertificateDigestsFile.String()+"@")
+		}
+		m.artifactMetadata = append(m.artifactMetadata, metadata)
+	}
+}
+
+func ArtifactMetadataSingletonFactory() Singleton {
+	return &artifactMetadataSingleton{}
+}
+
+type artifactMetadataSingleton struct{}
+
+func (s *artifactMetadataSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if !generateArtifactMetadata(ctx.Config()) {
+		return
+	}
+
+	file := artifactMetadataFile{
+		SchemaVersion: ArtifactMetadataSchemaVersion,
+		Artifacts:     []ArtifactMetadata{},
+	}
+	ctx.VisitAllModules(func(module Module) {
+		if module.Enabled() {
+			file.Artifacts = append(file.Artifacts, module.base().artifactMetadata...)
+		}
+	})
+
+	sort.SliceStable(file.Artifacts, func(i, j int) bool {
+		return file.Artifacts[i].Path < file.Artifacts[j].Path
+	})
+
+	buf, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal artifact metadata: %s", err)
+		return
+	}
+
+	var expressions []string
+	var deps Paths
+	content := string(buf)
+	if strings.Contains(content, ArtifactMetadataBuildNumber) {
+		// Only depend on the build number, which changes with every build, when the metadata uses it.
+		buildNumberFile := ctx.Config().BuildNumberFile(ctx)
+		expressions = append(expressions, `-e "s|`+ArtifactMetadataBuildNumber+`|$$(cat `+
+			buildNumberFile.String()+`)|g"`)
+		deps = append(deps, buildNumberFile)
+	}
+	for _, artifact := range file.Artifacts {
+		if artifact.CertificateDigestsFile == nil {
+			continue
+		}
+		// Replace the quoted placeholder with the quoted digests, separated by commas.
+		digestsFile := artifact.CertificateDigestsFile.String()
+		expressions = append(expressions, `-e "s|\"`+artifactMetadataCertificateDigestsPrefix+digestsFile+
+			`@\"|$$(sed -e 's/.*/"&"/' `+digestsFile+` | paste -s -d , -)|"`)
+		deps = append(deps, artifact.CertificateDigestsFile)
+	}
+
+	path := PathForOutput(ctx, artifactMetadataJsonFileName)
+	if len(expressions) > 0 {
+		template := PathForOutput(ctx, artifactMetadataJsonFileName+".in")
+		WriteFileRule(ctx, template, content)
+		ctx.Build(pctx, BuildParams{
+			Rule:        artifactMetadataResolveRule,
+			Description: "artifact metadata",
+			Input:       template,
+			Implicits:   deps,
+			Output:      path,
+			Args: map[string]string{
+				"expressions": strings.Join(expressions, " "),
+			},
+		})
+	} else {
+		WriteFileRule(ctx, path, content)
+	}
+
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_artifact_metadata"),
+		Input:  path,
+	})
+}
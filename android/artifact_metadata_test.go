@@ -0,0 +1,199 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type artifactMetadataTestModule struct {
+	ModuleBase
+	properties struct {
+		Version   *string
+		Presigned *bool
+	}
+}
+
+func artifactMetadataTestModuleFactory() Module {
+	m := &artifactMetadataTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *artifactMetadataTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: out,
+	})
+	ctx.InstallFile(PathForModuleInstall(ctx, "app"), ctx.ModuleName()+".apk", out)
+}
+
+func (m *artifactMetadataTestModule) ArtifactMetadata(ctx ModuleContext) []ArtifactMetadata {
+	metadata := ArtifactMetadata{
+		Kind:        "apk",
+		PackageName: "com.android." + ctx.ModuleName(),
+		VersionCode: String(m.properties.Version),
+	}
+	if Bool(m.properties.Presigned) {
+		digests := PathForModuleOut(ctx, "certificate_digests.txt")
+		ctx.Build(pctx, BuildParams{
+			Rule:   Touch,
+			Output: digests,
+		})
+		metadata.CertificateDigestsFile = digests
+	}
+	return []ArtifactMetadata{metadata}
+}
+
+const artifactMetadataTestBp = `
+	test {
+		name: "foo",
+		version: "2",
+	}
+
+	test {
+		name: "bar",
+		skip_install: true,
+	}
+
+	test {
+		name: "baz",
+		enabled: false,
+	}
+`
+
+func testArtifactMetadata(t *testing.T, env map[string]string, bp string) (*TestContext, Config) {
+	t.Helper()
+
+	config := TestArchConfig(buildDir, env)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(artifactMetadataTestModuleFactory))
+	ctx.RegisterSingletonType("artifact_metadata", SingletonFactoryAdaptor(ArtifactMetadataSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	return ctx, config
+}
+
+func TestArtifactMetadata(t *testing.T) {
+	ctx, _ := testArtifactMetadata(t, map[string]string{"SOONG_GENERATE_ARTIFACT_METADATA": "true"},
+		artifactMetadataTestBp)
+
+	content := ContentFromFileRuleForTests(t, ctx.SingletonForTests("artifact_metadata").Output("artifact_metadata.json"))
+	var file artifactMetadataFile
+	if err := json.Unmarshal([]byte(content), &file); err != nil {
+		t.Fatal(err)
+	}
+
+	want := artifactMetadataFile{
+		SchemaVersion: ArtifactMetadataSchemaVersion,
+		Artifacts: []ArtifactMetadata{
+			{
+				Module:      "foo",
+				Kind:        "apk",
+				Path:        "/system/app/foo.apk",
+				PackageName: "com.android.foo",
+				VersionCode: "2",
+			},
+		},
+	}
+	if !reflect.DeepEqual(file, want) {
+		t.Errorf("want %#v, got %#v", want, file)
+	}
+}
+
+func TestArtifactMetadataBuildNumber(t *testing.T) {
+	ctx, config := testArtifactMetadata(t, map[string]string{"SOONG_GENERATE_ARTIFACT_METADATA": "true"}, `
+		test {
+			name: "foo",
+			version: "`+ArtifactMetadataBuildNumber+`",
+		}
+	`)
+
+	singleton := ctx.SingletonForTests("artifact_metadata")
+	metadata := singleton.Output("artifact_metadata.json")
+	if metadata.Rule != artifactMetadataResolveRule {
+		t.Fatalf("expected artifact_metadata.json to be built by %v, got %v", artifactMetadataResolveRule,
+			metadata.Rule)
+	}
+	buildNumberFile := config.BuildNumberFile(PathContextForTesting(config, nil))
+	if g, w := metadata.Args["expressions"], "$$(cat "+buildNumberFile.String()+")"; !strings.Contains(g, w) {
+		t.Errorf("expected the build number to be read from %q, got %q", w, g)
+	}
+	if g, w := metadata.Implicits.Strings(), []string{buildNumberFile.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected implicits %q, got %q", w, g)
+	}
+
+	var file artifactMetadataFile
+	content := ContentFromFileRuleForTests(t, singleton.Output("artifact_metadata.json.in"))
+	if err := json.Unmarshal([]byte(content), &file); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := file.Artifacts[0].VersionCode, ArtifactMetadataBuildNumber; g != w {
+		t.Errorf("expected version code %q in the template, got %q", w, g)
+	}
+}
+
+func TestArtifactMetadataCertificateDigestsFile(t *testing.T) {
+	ctx, _ := testArtifactMetadata(t, map[string]string{"SOONG_GENERATE_ARTIFACT_METADATA": "true"}, `
+		test {
+			name: "foo",
+			presigned: true,
+		}
+	`)
+
+	digests := ctx.ModuleForTests("foo", "android_arm64_armv8-a").Output("certificate_digests.txt").Output
+	placeholder := artifactMetadataCertificateDigestsPrefix + digests.String() + "@"
+
+	singleton := ctx.SingletonForTests("artifact_metadata")
+	metadata := singleton.Output("artifact_metadata.json")
+	if g, w := metadata.Implicits.Strings(), []string{digests.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected implicits %q, got %q", w, g)
+	}
+	if g, w := metadata.Args["expressions"], "s|\\\""+placeholder+"\\\"|"; !strings.Contains(g, w) {
+		t.Errorf("expected the placeholder %q to be replaced, got %q", placeholder, g)
+	}
+
+	var file artifactMetadataFile
+	content := ContentFromFileRuleForTests(t, singleton.Output("artifact_metadata.json.in"))
+	if err := json.Unmarshal([]byte(content), &file); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := file.Artifacts[0].CertificateDigests, []string{placeholder}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected certificate digests %q in the template, got %q", w, g)
+	}
+}
+
+func TestArtifactMetadataDisabled(t *testing.T) {
+	ctx, _ := testArtifactMetadata(t, nil, artifactMetadataTestBp)
+
+	if metadata := ctx.SingletonForTests("artifact_metadata").MaybeOutput("artifact_metadata.json"); metadata.Rule != nil {
+		t.Errorf("expected artifact_metadata.json not to be written, got rule %v", metadata.Rule)
+	}
+}
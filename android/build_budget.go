@@ -0,0 +1,219 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// "build budget" rules for the build system.
+//
+// A build budget caps the number of build actions, or the number of declared outputs of those actions, that
+// the modules in a directory subtree can generate, so that a project that generates an unreasonable amount of
+// code or rules fails analysis instead of slowly degrading the build time of the whole tree.  The sizes of the
+// outputs are not known during analysis, so the number of declared outputs stands in for them.
+//
+// The budgets are set by the product with PRODUCT_BUILD_BUDGETS, a list of <path prefix>:<max actions>:<max outputs>,
+// where an empty or 0 limit is not checked.
+//
+// Each module variant counts the actions it builds, including install rules.  After all modules have been
+// analyzed, the totals of the modules in each budgeted subtree are compared against its limits, and an exceeded
+// budget is reported with the modules that contributed the most to it.
+
+func init() {
+	RegisterSingletonType("build_budget", buildBudgetSingletonFactory)
+}
+
+// buildBudgetsFromConfig parses the PRODUCT_BUILD_BUDGETS.
+func buildBudgetsFromConfig(config Config) ([]*buildBudget, error) {
+	var budgets []*buildBudget
+	for _, s := range config.BuildBudgets() {
+		split := strings.Split(s, ":")
+		if len(split) != 3 || split[0] == "" {
+			return nil, fmt.Errorf("invalid build budget %q in PRODUCT_BUILD_BUDGETS should be "+
+				"<path prefix>:<max actions>:<max outputs>", s)
+		}
+		var limits [2]int
+		for i, limit := range split[1:] {
+			if limit == "" {
+				continue
+			}
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid limit %q of build budget %q in PRODUCT_BUILD_BUDGETS", limit, s)
+			}
+			limits[i] = n
+		}
+		budgets = append(budgets, budget().in(split[0]).maxActions(limits[0]).maxOutputs(limits[1]).
+			because("it is set in PRODUCT_BUILD_BUDGETS"))
+	}
+	return budgets, nil
+}
+
+// The number of modules listed when a budget is exceeded.
+const buildBudgetReportedModules = 5
+
+type buildBudget struct {
+	// User string for why this is a thing.
+	reason string
+
+	paths []string
+
+	// Limits, or 0 for no limit.
+	actions int
+	outputs int
+}
+
+func budget() *buildBudget {
+	return &buildBudget{}
+}
+
+func (b *buildBudget) in(path ...string) *buildBudget {
+	b.paths = append(b.paths, cleanPaths(path)...)
+	return b
+}
+
+func (b *buildBudget) maxActions(actions int) *buildBudget {
+	b.actions = actions
+	return b
+}
+
+func (b *buildBudget) maxOutputs(outputs int) *buildBudget {
+	b.outputs = outputs
+	return b
+}
+
+func (b *buildBudget) because(reason string) *buildBudget {
+	b.reason = reason
+	return b
+}
+
+func (b *buildBudget) appliesToPath(dir string) bool {
+	return hasAnyPrefix(dir, b.paths)
+}
+
+func (b *buildBudget) String() string {
+	s := "build budget"
+	for _, v := range b.paths {
+		s += " dir:" + v + "*"
+	}
+	if b.actions > 0 {
+		s += fmt.Sprintf(" max_actions:%d", b.actions)
+	}
+	if b.outputs > 0 {
+		s += fmt.Sprintf(" max_outputs:%d", b.outputs)
+	}
+	if len(b.reason) != 0 {
+		s += " which is limited because " + b.reason
+	}
+	return s
+}
+
+// buildBudgetUsage is the number of build actions and declared outputs of a module, summed over its variants.
+type buildBudgetUsage struct {
+	module  string
+	actions int
+	outputs int
+}
+
+// countBuildActions records a build action of the module for the build budgets.
+func (m *ModuleBase) countBuildActions(params BuildParams) {
+	m.buildActionCount++
+	if params.Output != nil {
+		m.buildOutputCount++
+	}
+	if params.ImplicitOutput != nil {
+		m.buildOutputCount++
+	}
+	m.buildOutputCount += len(params.Outputs) + len(params.ImplicitOutputs)
+}
+
+func buildBudgetSingletonFactory() Singleton {
+	return &buildBudgetSingleton{}
+}
+
+type buildBudgetSingleton struct{}
+
+func (s *buildBudgetSingleton) GenerateBuildActions(ctx SingletonContext) {
+	buildBudgets, err := buildBudgetsFromConfig(ctx.Config())
+	if err != nil {
+		ctx.Errorf("%s", err)
+		return
+	}
+	if len(buildBudgets) == 0 {
+		return
+	}
+
+	usages := make(map[string]*buildBudgetUsage)
+	var modules []string
+	moduleDirs := make(map[string]string)
+	ctx.VisitAllModules(func(module Module) {
+		name := "//" + ctx.ModuleDir(module) + ":" + ctx.ModuleName(module)
+		usage, ok := usages[name]
+		if !ok {
+			usage = &buildBudgetUsage{module: name}
+			usages[name] = usage
+			modules = append(modules, name)
+			moduleDirs[name] = ctx.ModuleDir(module) + "/"
+		}
+		usage.actions += module.base().buildActionCount
+		usage.outputs += module.base().buildOutputCount
+	})
+
+	for _, b := range buildBudgets {
+		var total buildBudgetUsage
+		var contributors []*buildBudgetUsage
+		for _, name := range modules {
+			if !b.appliesToPath(moduleDirs[name]) {
+				continue
+			}
+			usage := usages[name]
+			total.actions += usage.actions
+			total.outputs += usage.outputs
+			contributors = append(contributors, usage)
+		}
+
+		var exceeded []string
+		if b.actions > 0 && total.actions > b.actions {
+			exceeded = append(exceeded, fmt.Sprintf("%d actions", total.actions))
+		}
+		if b.outputs > 0 && total.outputs > b.outputs {
+			exceeded = append(exceeded, fmt.Sprintf("%d outputs", total.outputs))
+		}
+		if len(exceeded) == 0 {
+			continue
+		}
+
+		sort.SliceStable(contributors, func(i, j int) bool {
+			if contributors[i].actions != contributors[j].actions {
+				return contributors[i].actions > contributors[j].actions
+			}
+			return contributors[i].outputs > contributors[j].outputs
+		})
+		if len(contributors) > buildBudgetReportedModules {
+			contributors = contributors[:buildBudgetReportedModules]
+		}
+		var largest []string
+		for _, c := range contributors {
+			largest = append(largest, fmt.Sprintf("%s (%d actions, %d outputs)", c.module, c.actions, c.outputs))
+		}
+
+		ctx.Errorf("%s exceeded with %s, largest modules: %s", b.String(), strings.Join(exceeded, " and "),
+			strings.Join(largest, ", "))
+	}
+}
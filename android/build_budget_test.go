@@ -0,0 +1,139 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"strconv"
+	"testing"
+)
+
+type budgetTestModule struct {
+	ModuleBase
+	properties struct {
+		Actions *int64
+		Outputs *int64
+	}
+}
+
+func budgetTestModuleFactory() Module {
+	m := &budgetTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *budgetTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	for i := int64(0); i < *m.properties.Actions; i++ {
+		var outputs WritablePaths
+		for j := int64(0); j < *m.properties.Outputs; j++ {
+			outputs = append(outputs, PathForModuleOut(ctx, strconv.FormatInt(i, 10), strconv.FormatInt(j, 10)))
+		}
+		ctx.Build(pctx, BuildParams{
+			Rule:    Touch,
+			Outputs: outputs,
+		})
+	}
+}
+
+func TestBuildBudget(t *testing.T) {
+	fs := map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "root",
+				actions: 10,
+				outputs: 10,
+			}
+		`),
+		"generated/Android.bp": []byte(`
+			test {
+				name: "small",
+				actions: 2,
+				outputs: 1,
+			}
+
+			test {
+				name: "large",
+				actions: 4,
+				outputs: 3,
+			}
+		`),
+	}
+
+	tests := []struct {
+		name          string
+		budgets       []string
+		expectedError string
+	}{
+		{
+			name:    "no budgets",
+			budgets: nil,
+		},
+		{
+			name:    "within budget",
+			budgets: []string{"generated:6:14"},
+		},
+		{
+			name:    "actions exceeded",
+			budgets: []string{"generated:5:"},
+			expectedError: `build budget dir:generated/\* max_actions:5 which is limited because it is set in ` +
+				`PRODUCT_BUILD_BUDGETS exceeded with 6 actions, largest modules: ` +
+				`//generated:large \(4 actions, 12 outputs\), //generated:small \(2 actions, 2 outputs\)`,
+		},
+		{
+			name:    "outputs exceeded",
+			budgets: []string{"generated:0:13"},
+			expectedError: `build budget dir:generated/\* max_outputs:13 which is limited because it is set in ` +
+				`PRODUCT_BUILD_BUDGETS exceeded with 14 outputs`,
+		},
+		{
+			name:          "both exceeded",
+			budgets:       []string{"generated:1:1"},
+			expectedError: `exceeded with 6 actions and 14 outputs`,
+		},
+		{
+			name:          "malformed",
+			budgets:       []string{"generated:1"},
+			expectedError: `invalid build budget "generated:1" in PRODUCT_BUILD_BUDGETS`,
+		},
+		{
+			name:          "malformed limit",
+			budgets:       []string{"generated:many:1"},
+			expectedError: `invalid limit "many" of build budget "generated:many:1" in PRODUCT_BUILD_BUDGETS`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := TestConfig(buildDir, nil)
+			config.TestProductVariables.BuildBudgets = test.budgets
+
+			ctx := NewTestContext()
+			ctx.RegisterModuleType("test", ModuleFactoryAdaptor(budgetTestModuleFactory))
+			ctx.RegisterSingletonType("build_budget", SingletonFactoryAdaptor(buildBudgetSingletonFactory))
+			ctx.Register()
+			ctx.MockFileSystem(fs)
+
+			_, errs := ctx.ParseFileList(".", []string{"Android.bp", "generated/Android.bp"})
+			FailIfErrored(t, errs)
+			_, errs = ctx.PrepareBuildActions(config)
+
+			if test.expectedError == "" {
+				FailIfErrored(t, errs)
+			} else {
+				FailIfNoMatchingErrors(t, test.expectedError, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+// This singleton writes the build number file returned by Config.BuildNumberFile when Make doesn't pass the one
+// it writes, so that the rules that read the build number have an input that is produced by the build.
+
+import (
+	"github.com/google/blueprint"
+)
+
+func init() {
+	RegisterSingletonType("build_number", buildNumberSingletonFactory)
+}
+
+const defaultBuildNumberFileName = "soong_build_number.txt"
+
+// Without Make the build number only comes from the environment, it defaults to the "eng" prefix that Make uses
+// for the build numbers of engineering builds.  It is read when the rule runs instead of during analysis, which
+// would rerun the analysis whenever it changes.  The rule runs on every build, and restat keeps the rules that
+// read the file from running again when the build number didn't change.
+var buildNumberRule = pctx.AndroidStaticRule("buildNumber",
+	blueprint.RuleParams{
+		Command: `echo "$${BUILD_NUMBER:-eng}" > $out.tmp && ` +
+			`if cmp -s $out.tmp $out ; then rm $out.tmp ; else mv $out.tmp $out ; fi`,
+		Restat: true,
+	})
+
+func buildNumberSingletonFactory() Singleton {
+	return &buildNumberSingleton{}
+}
+
+type buildNumberSingleton struct{}
+
+func (s *buildNumberSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if String(ctx.Config().productVariables.BuildNumberFile) != "" {
+		return
+	}
+
+	// A phony target without inputs is never up to date, depending on it runs the rule on every build.
+	force := PathForPhony(ctx, "soong_build_number_force")
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: force,
+	})
+
+	ctx.Build(pctx, BuildParams{
+		Rule:        buildNumberRule,
+		Description: "build number",
+		Implicit:    force,
+		Output:      ctx.Config().BuildNumberFile(ctx),
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildNumberFile(t *testing.T) {
+	testCases := []struct {
+		name            string
+		buildNumberFile *string
+	}{
+		{
+			name:            "from make",
+			buildNumberFile: stringPtr("build_number.txt"),
+		},
+		{
+			name: "default",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := TestConfig(buildDir, map[string]string{"BUILD_NUMBER": "123456"})
+			config.TestProductVariables.BuildNumberFile = test.buildNumberFile
+
+			ctx := NewTestContext()
+			ctx.RegisterSingletonType("build_number", SingletonFactoryAdaptor(buildNumberSingletonFactory))
+			ctx.Register()
+			ctx.MockFileSystem(map[string][]byte{"Android.bp": nil})
+
+			_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+			FailIfErrored(t, errs)
+			_, errs = ctx.PrepareBuildActions(config)
+			FailIfErrored(t, errs)
+
+			file := config.BuildNumberFile(PathContextForTesting(config, nil))
+			write := ctx.SingletonForTests("build_number").MaybeOutput(file.String())
+			if test.buildNumberFile != nil {
+				if write.Rule != nil {
+					t.Errorf("expected the build number file written by Make not to be written")
+				}
+				if g, w := file.Rel(), *test.buildNumberFile; g != w {
+					t.Errorf("expected build number file %q, got %q", w, g)
+				}
+				return
+			}
+			// The build number is read from the environment when the rule runs, not during analysis.
+			if write.Rule != buildNumberRule {
+				t.Errorf("expected the build number file to be written by %q, got %q", buildNumberRule, write.Rule)
+			}
+			if strings.Contains(write.RuleParams.Command, "123456") {
+				t.Errorf("expected the build number not to be read during analysis, got %q", write.RuleParams.Command)
+			}
+			if write.Implicit == nil || write.Implicit.String() != "soong_build_number_force" {
+				t.Errorf("expected the build number file to be written on every build, got implicit %q", write.Implicit)
+			}
+		})
+	}
+}
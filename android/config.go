@@ -42,6 +42,10 @@ const productVariablesFileName = "soong.variables"
 type FileConfigurableOptions struct {
 	Mega_device *bool `json:",omitempty"`
 	Host_bionic *bool `json:",omitempty"`
+
+	// Build the host tools that support it for linux_bionic arm64, and use them in place of the
+	// linux-x86 tools when the build runs on an arm64 machine.
+	Host_bionic_arm64 *bool `json:",omitempty"`
 }
 
 func (f *FileConfigurableOptions) SetDefaultConfig() {
@@ -112,6 +116,12 @@ type config struct {
 
 	stopBefore bootstrap.StopBefore
 
+	visibilityWarnings bool
+
+	moduleGraphFile string
+
+	parseErrorsAnnotator func(errs []error)
+
 	OncePer
 }
 
@@ -216,6 +226,7 @@ func TestConfig(buildDir string, env map[string]string) Config {
 			AAPTPreferredConfig:         stringPtr("xhdpi"),
 			AAPTCharacteristics:         stringPtr("nosdcard"),
 			AAPTPrebuiltDPI:             []string{"xhdpi", "xxhdpi"},
+			BuildNumberFile:             stringPtr("build_number.txt"),
 		},
 
 		buildDir:     buildDir,
@@ -367,6 +378,10 @@ func NewConfig(srcDir, buildDir string) (Config, error) {
 		return Config{}, err
 	}
 
+	if err := config.checkJavaWerrorPolicies(); err != nil {
+		return Config{}, err
+	}
+
 	return Config{config}, nil
 }
 
@@ -394,6 +409,36 @@ func (c *config) SetStopBefore(stopBefore bootstrap.StopBefore) {
 
 var _ bootstrap.ConfigStopBefore = (*config)(nil)
 
+// SetVisibilityWarnings makes dependencies on modules that are not visible warnings instead of errors,
+// as if SOONG_VISIBILITY_WARNINGS was set to true.
+func (c *config) SetVisibilityWarnings() {
+	c.visibilityWarnings = true
+}
+
+// SetModuleGraphFile makes the module_graph singleton write the module graph to file.
+func (c *config) SetModuleGraphFile(file string) {
+	c.moduleGraphFile = file
+}
+
+// ModuleGraphFile returns the file the module graph is written to, or an empty string if it isn't
+// written.
+func (c *config) ModuleGraphFile() string {
+	return c.moduleGraphFile
+}
+
+// SetParseErrorsAnnotator sets the function that AnnotateParseErrors passes the parse errors to.
+func (c *config) SetParseErrorsAnnotator(annotate func(errs []error)) {
+	c.parseErrorsAnnotator = annotate
+}
+
+// AnnotateParseErrors is called by bootstrap.Main with the errors of parsing the Blueprints files before it
+// prints them, so that they can be replaced with more helpful errors without parsing the files again.
+func (c *config) AnnotateParseErrors(errs []error) {
+	if c.parseErrorsAnnotator != nil {
+		c.parseErrorsAnnotator(errs)
+	}
+}
+
 func (c *config) BlueprintToolLocation() string {
 	return filepath.Join(c.buildDir, "host", c.PrebuiltOS(), "bin")
 }
@@ -404,6 +449,23 @@ func (c *config) HostToolPath(ctx PathContext, tool string) Path {
 	return PathForOutput(ctx, "host", c.PrebuiltOS(), "bin", tool)
 }
 
+// HostNativeToolPath returns the path to a host tool built for the architecture of the build machine, which
+// is the linux_bionic arm64 variant of the tool when UseHostBionicArm64Tools returns true, so the tool must be
+// enabled for linux_bionic.  The Go tools built by the bootstrap already run natively, as they are built by the
+// Go toolchain that runs soong_build, and use HostToolPath.
+func (c *config) HostNativeToolPath(ctx PathContext, tool string) Path {
+	return PathForOutput(ctx, "host", c.HostNativeOS(), "bin", tool)
+}
+
+// HostNativeOS returns the name of the directory of the host tools built for the architecture of the build
+// machine in the host output directory.
+func (c *config) HostNativeOS() string {
+	if c.UseHostBionicArm64Tools() {
+		return "linux_bionic-arm64"
+	}
+	return c.PrebuiltOS()
+}
+
 // HostSystemTool looks for non-hermetic tools from the system we're running on.
 // Generally shouldn't be used, but useful to find the XCode SDK, etc.
 func (c *config) HostSystemTool(name string) string {
@@ -418,6 +480,12 @@ func (c *config) HostSystemTool(name string) string {
 	return name
 }
 
+// UseHostBionicArm64Tools returns true if the host tools that are built for linux_bionic arm64 are
+// used in place of the linux-x86 tools, because the build is running on an arm64 machine.
+func (c *config) UseHostBionicArm64Tools() bool {
+	return BuildArch == Arm64 && Bool(c.Host_bionic_arm64)
+}
+
 // PrebuiltOS returns the name of the host OS used in prebuilts directories
 func (c *config) PrebuiltOS() string {
 	switch runtime.GOOS {
@@ -430,6 +498,15 @@ func (c *config) PrebuiltOS() string {
 	}
 }
 
+// PrebuiltSdkToolsOS returns the name of the directory of the prebuilt host tools of the build machine in
+// prebuilts/sdk/tools, which are used by unbundled builds in place of the tools built from source.
+func (c *config) PrebuiltSdkToolsOS() string {
+	if c.UseHostBionicArm64Tools() {
+		return runtime.GOOS + "-arm64"
+	}
+	return runtime.GOOS
+}
+
 // GoRoot returns the path to the root directory of the Go toolchain.
 func (c *config) GoRoot() string {
 	return fmt.Sprintf("%s/prebuilts/go/%s", c.srcDir, c.PrebuiltOS())
@@ -505,6 +582,17 @@ func (c *config) BuildNumberFromFile() string {
 	return String(c.productVariables.BuildNumberFromFile)
 }
 
+// BuildNumberFile returns the path to the file containing the build number.  The build number changes with every
+// build, so rules that embed it must read the file when they run and list it as a dependency instead of reading it
+// during analysis, which would rerun the analysis and make the outputs of the rules nondeterministic.  When Make
+// doesn't pass the file it writes, the file is written by the build_number singleton.
+func (c *config) BuildNumberFile(ctx PathContext) OutputPath {
+	if file := String(c.productVariables.BuildNumberFile); file != "" {
+		return PathForOutput(ctx, file)
+	}
+	return PathForOutput(ctx, defaultBuildNumberFileName)
+}
+
 // DeviceName returns the name of the current device target
 // TODO: take an AndroidModuleContext to select the device name for multi-device builds
 func (c *config) DeviceName() string {
@@ -575,6 +663,15 @@ func (c *config) AppsDefaultVersionName() string {
 	return String(c.productVariables.AppsDefaultVersionName)
 }
 
+// AppsVersionCodeOffset returns the offset added to the version_code of apps, so that a branch can build apps that
+// upgrade over the same apps built by an earlier branch without changing every version_code.
+func (c *config) AppsVersionCodeOffset() int {
+	if c.productVariables.AppsVersionCodeOffset == nil {
+		return 0
+	}
+	return *c.productVariables.AppsVersionCodeOffset
+}
+
 // Codenames that are active in the current lunch target.
 func (c *config) PlatformVersionActiveCodenames() []string {
 	return c.productVariables.Platform_version_active_codenames
@@ -612,10 +709,15 @@ func (c *config) ProductAAPTPrebuiltDPI() []string {
 	return c.productVariables.AAPTPrebuiltDPI
 }
 
+// DefaultAppCertificateDir returns the directory that the certificate names used by app certificate properties, like
+// "platform", are found in.  It is the directory of PRODUCT_DEFAULT_DEV_CERTIFICATE if it is set, otherwise
+// PRODUCT_DEFAULT_APP_CERTIFICATE_DIR switches it from the test keys to a product specific key directory.
 func (c *config) DefaultAppCertificateDir(ctx PathContext) SourcePath {
 	defaultCert := String(c.productVariables.DefaultAppCertificate)
 	if defaultCert != "" {
 		return PathForSource(ctx, filepath.Dir(defaultCert))
+	} else if dir := String(c.productVariables.DefaultAppCertificateDir); dir != "" {
+		return PathForSource(ctx, dir)
 	} else {
 		return PathForSource(ctx, "build/make/target/product/security")
 	}
@@ -751,6 +853,27 @@ func (c *config) RunErrorProne() bool {
 	return c.IsEnvTrue("RUN_ERROR_PRONE")
 }
 
+// DefaultJavaHeaderJar returns how the header jars of java modules that don't set header_jar are
+// generated, or an empty string to use the default of the module.
+func (c *config) DefaultJavaHeaderJar() string {
+	if c.IsEnvFalse("TURBINE_ENABLED") {
+		return "javac"
+	}
+	return c.Getenv("SOONG_JAVA_HEADER_JAR")
+}
+
+// XrefCorpusName returns the Kythe corpus of the cross-references extracted for code search, set
+// with XREF_CORPUS.
+func (c *config) XrefCorpusName() string {
+	return c.Getenv("XREF_CORPUS")
+}
+
+// EmitXrefRules returns true if the compilations of the modules should be extracted for the Kythe
+// indexer.
+func (c *config) EmitXrefRules() bool {
+	return c.XrefCorpusName() != ""
+}
+
 // Returns true if -source 1.9 -target 1.9 is being passed to javac
 func (c *config) TargetOpenJDK9() bool {
 	return c.targetOpenJDK9
@@ -799,6 +922,26 @@ func (c *config) EnforceRROForModule(name string) bool {
 	return false
 }
 
+// EnforceRROOverlayable returns true if overlaying a resource that the target doesn't declare overlayable
+// fails the build.  Otherwise it is only reported as a warning, while products migrate their overlays.
+func (c *config) EnforceRROOverlayable() bool {
+	return Bool(c.productVariables.EnforceRROOverlayable)
+}
+
+// MaxVariantDirLength returns the length above which the names of the variant directories in the
+// intermediates directory are replaced by a hash, or 0 if they are never hashed.
+func (c *config) MaxVariantDirLength() int {
+	if c.productVariables.MaxVariantDirLength == nil {
+		return 0
+	}
+	return *c.productVariables.MaxVariantDirLength
+}
+
+// BuildBudgets returns the PRODUCT_BUILD_BUDGETS, of the form <path prefix>:<max actions>:<max outputs>.
+func (c *config) BuildBudgets() []string {
+	return c.productVariables.BuildBudgets
+}
+
 func (c *config) EnforceRROExcludedOverlay(path string) bool {
 	excluded := c.productVariables.EnforceRROExcludedOverlays
 	if excluded != nil {
@@ -835,6 +978,16 @@ func (c *config) DexpreoptGlobalConfig() string {
 	return String(c.productVariables.DexpreoptGlobalConfig)
 }
 
+// DexpreoptInSoong returns true if the dexpreopt configuration should be constructed by Soong from the product
+// variables instead of being read from the dexpreopt.config file written by Make.
+func (c *config) DexpreoptInSoong() bool {
+	return Bool(c.productVariables.DexpreoptInSoong)
+}
+
+func (c *config) RuntimeApexJars() []string {
+	return c.productVariables.RuntimeApexJars
+}
+
 func (c *config) FrameworksBaseDirExists(ctx PathContext) bool {
 	return ExistentPathForSource(ctx, "frameworks", "base").Valid()
 }
@@ -979,6 +1132,55 @@ func (c *deviceConfig) OverridePackageNameFor(name string) string {
 	return name
 }
 
+// OverrideVersionCodeFor returns the version code that PRODUCT_VERSION_CODE_OVERRIDES sets for the app module
+// name, which replaces both the version_code property and the AppsVersionCodeOffset.
+func (c *deviceConfig) OverrideVersionCodeFor(name string) (versionCode string, overridden bool) {
+	return findOverrideValue(c.config.productVariables.VersionCodeOverrides, name,
+		"invalid override rule %q in PRODUCT_VERSION_CODE_OVERRIDES should be <module_name>:<version_code>")
+}
+
+// OverrideVersionNameFor returns the version name that PRODUCT_VERSION_NAME_OVERRIDES sets for the app module
+// name, which replaces the version_name property.
+func (c *deviceConfig) OverrideVersionNameFor(name string) (versionName string, overridden bool) {
+	return findOverrideValue(c.config.productVariables.VersionNameOverrides, name,
+		"invalid override rule %q in PRODUCT_VERSION_NAME_OVERRIDES should be <module_name>:<version_name>")
+}
+
+// JavaWerrorWarningsFor returns the javac warnings that the PRODUCT_JAVA_WERROR_POLICIES turn into errors for the
+// modules in dir.  Each policy is of the form <path prefix>:<warning>[,<warning>...], and applies to the modules in
+// the directory of the prefix and its subdirectories.
+func (c *deviceConfig) JavaWerrorWarningsFor(dir string) []string {
+	var warnings []string
+	for _, policy := range c.config.productVariables.JavaWerrorPolicies {
+		split, valid := splitJavaWerrorPolicy(policy)
+		if !valid {
+			// Malformed policies are reported when the config is loaded.
+			continue
+		}
+		prefix := strings.TrimSuffix(split[0], "/")
+		if dir == prefix || strings.HasPrefix(dir, prefix+"/") {
+			warnings = append(warnings, strings.Split(split[1], ",")...)
+		}
+	}
+	return FirstUniqueStrings(warnings)
+}
+
+// checkJavaWerrorPolicies returns an error for the first malformed policy in PRODUCT_JAVA_WERROR_POLICIES.
+func (c *config) checkJavaWerrorPolicies() error {
+	for _, policy := range c.productVariables.JavaWerrorPolicies {
+		if _, valid := splitJavaWerrorPolicy(policy); !valid {
+			return fmt.Errorf("invalid java werror policy %q in PRODUCT_JAVA_WERROR_POLICIES should be "+
+				"<path prefix>:<warning>[,<warning>...]", policy)
+		}
+	}
+	return nil
+}
+
+func splitJavaWerrorPolicy(policy string) (split []string, valid bool) {
+	split = strings.Split(policy, ":")
+	return split, len(split) == 2 && split[0] != "" && split[1] != ""
+}
+
 func findOverrideValue(overrides []string, name string, errorMsg string) (newValue string, overridden bool) {
 	if overrides == nil || len(overrides) == 0 {
 		return "", false
@@ -1107,6 +1309,11 @@ func (c *config) MissingUsesLibraries() []string {
 	return c.productVariables.MissingUsesLibraries
 }
 
+// DefaultTestSuites returns the compatibility suites that test modules without test_suites are installed into.
+func (c *config) DefaultTestSuites() []string {
+	return c.productVariables.DefaultTestSuites
+}
+
 func (c *deviceConfig) BoardVndkRuntimeDisable() bool {
 	return Bool(c.config.productVariables.BoardVndkRuntimeDisable)
 }
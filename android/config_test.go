@@ -17,8 +17,11 @@ package android
 import (
 	"fmt"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/google/blueprint/proptools"
 )
 
 func validateConfigAnnotations(configurable jsonConfigurable) (err error) {
@@ -91,3 +94,80 @@ func TestMissingVendorConfig(t *testing.T) {
 		t.Errorf("Expected false")
 	}
 }
+
+func TestJavaWerrorWarningsFor(t *testing.T) {
+	config := TestConfig(buildDir, nil)
+	config.TestProductVariables.JavaWerrorPolicies = []string{
+		"vendor/acme/:deprecation,unchecked",
+		"vendor:deprecation,rawtypes",
+	}
+	deviceConfig := DeviceConfig{config.deviceConfig}
+
+	testCases := []struct {
+		dir  string
+		want []string
+	}{
+		{dir: "vendor/acme", want: []string{"deprecation", "unchecked", "rawtypes"}},
+		{dir: "vendor/acme/app", want: []string{"deprecation", "unchecked", "rawtypes"}},
+		{dir: "vendor/acmeold", want: []string{"deprecation", "rawtypes"}},
+		{dir: "vendorx", want: nil},
+		{dir: ".", want: nil},
+	}
+
+	for _, testCase := range testCases {
+		if got := deviceConfig.JavaWerrorWarningsFor(testCase.dir); !reflect.DeepEqual(got, testCase.want) {
+			t.Errorf("JavaWerrorWarningsFor(%q): expected %q, got %q", testCase.dir, testCase.want, got)
+		}
+	}
+
+	if err := config.checkJavaWerrorPolicies(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	config.TestProductVariables.JavaWerrorPolicies = append(config.TestProductVariables.JavaWerrorPolicies,
+		"vendor/acme")
+	want := `invalid java werror policy "vendor/acme" in PRODUCT_JAVA_WERROR_POLICIES should be ` +
+		`<path prefix>:<warning>[,<warning>...]`
+	if err := config.checkJavaWerrorPolicies(); err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestUseHostBionicArm64Tools(t *testing.T) {
+	defer func(buildArch ArchType) { BuildArch = buildArch }(BuildArch)
+
+	testCases := []struct {
+		buildArch       ArchType
+		hostBionicArm64 bool
+		want            bool
+	}{
+		{buildArch: X86_64, hostBionicArm64: false, want: false},
+		{buildArch: X86_64, hostBionicArm64: true, want: false},
+		{buildArch: Arm64, hostBionicArm64: false, want: false},
+		{buildArch: Arm64, hostBionicArm64: true, want: true},
+	}
+
+	for _, testCase := range testCases {
+		config := TestConfig(buildDir, nil)
+		config.Host_bionic_arm64 = proptools.BoolPtr(testCase.hostBionicArm64)
+		BuildArch = testCase.buildArch
+
+		if got := config.UseHostBionicArm64Tools(); got != testCase.want {
+			t.Errorf("UseHostBionicArm64Tools() on %s with host_bionic_arm64 %v: expected %v, got %v",
+				testCase.buildArch, testCase.hostBionicArm64, testCase.want, got)
+		}
+
+		ctx := PathContextForTesting(config, nil)
+		wantTool, wantSdkToolsOS := "host/"+config.PrebuiltOS()+"/bin/aapt2", runtime.GOOS
+		if testCase.want {
+			wantTool, wantSdkToolsOS = "host/linux_bionic-arm64/bin/aapt2", runtime.GOOS+"-arm64"
+		}
+		if got, _ := MaybeRel(ctx, buildDir, config.HostNativeToolPath(ctx, "aapt2").String()); got != wantTool {
+			t.Errorf("HostNativeToolPath() on %s with host_bionic_arm64 %v: expected %q, got %q",
+				testCase.buildArch, testCase.hostBionicArm64, wantTool, got)
+		}
+		if got := config.PrebuiltSdkToolsOS(); got != wantSdkToolsOS {
+			t.Errorf("PrebuiltSdkToolsOS() on %s with host_bionic_arm64 %v: expected %q, got %q",
+				testCase.buildArch, testCase.hostBionicArm64, wantSdkToolsOS, got)
+		}
+	}
+}
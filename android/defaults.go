@@ -15,6 +15,9 @@
 package android
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 )
@@ -64,12 +67,16 @@ func InitDefaultableModule(module DefaultableModule) {
 type DefaultsModuleBase struct {
 	DefaultableModuleBase
 	defaultProperties []interface{}
+
+	setPropertiesOnce sync.Once
+	setProperties     []string
 }
 
 type Defaults interface {
 	Defaultable
 	isDefaults() bool
 	properties() []interface{}
+	propertiesSet() []string
 }
 
 func (d *DefaultsModuleBase) isDefaults() bool {
@@ -80,6 +87,16 @@ func (d *DefaultsModuleBase) properties() []interface{} {
 	return d.defaultableProperties
 }
 
+// propertiesSet returns the paths of the properties set in the defaults module.  They are computed once
+// for all of the modules that use the defaults, which are mutated before the defaults module itself so
+// that its properties don't include those of its own defaults yet.
+func (d *DefaultsModuleBase) propertiesSet() []string {
+	d.setPropertiesOnce.Do(func() {
+		d.setProperties = setPropertyNames(d.defaultableProperties)
+	})
+	return d.setProperties
+}
+
 func (d *DefaultsModuleBase) GenerateAndroidBuildActions(ctx ModuleContext) {
 }
 
@@ -102,6 +119,24 @@ var _ Defaults = (*DefaultsModuleBase)(nil)
 func (defaultable *DefaultableModuleBase) applyDefaults(ctx TopDownMutatorContext,
 	defaultsList []Defaults) {
 
+	// Record which defaults module set each property that isn't set directly on the module so that
+	// property errors can name it.  The first defaults module in the list that sets a property takes
+	// precedence.
+	seen := make(map[string]bool)
+	for _, defaults := range defaultsList {
+		for _, name := range defaults.propertiesSet() {
+			if !seen[name] && !ctx.ContainsProperty(name) {
+				ctx.Module().base().setPropertyOrigin(name,
+					fmt.Sprintf("inherited from defaults module %q", ctx.OtherModuleName(defaults)))
+			}
+			seen[name] = true
+		}
+	}
+
+	if _, ok := ctx.Module().(Defaults); !ok {
+		recordVisibilityOrigins(ctx, defaultsList)
+	}
+
 	for _, defaults := range defaultsList {
 		for _, prop := range defaultable.defaultableProperties {
 			for _, def := range defaults.properties() {
@@ -118,6 +153,63 @@ func (defaultable *DefaultableModuleBase) applyDefaults(ctx TopDownMutatorContex
 			}
 		}
 	}
+
+	if _, ok := ctx.Module().(Defaults); !ok && !defaultable.hasCommonProperties(ctx.Module()) {
+		// A module type that called InitDefaultableModule before InitAndroidModule doesn't have the
+		// common properties among its defaultable properties.  Visibility is still inherited from
+		// the defaults so that it can't be bypassed by the order of the init calls.
+		for _, defaults := range defaultsList {
+			if cp := defaultsCommonProperties(defaults); cp != nil && cp.Visibility != nil {
+				base := ctx.Module().base()
+				base.commonProperties.Visibility = append(append([]string(nil), cp.Visibility...),
+					base.commonProperties.Visibility...)
+			}
+		}
+	}
+}
+
+func (defaultable *DefaultableModuleBase) hasCommonProperties(module Module) bool {
+	for _, prop := range defaultable.defaultableProperties {
+		if prop == &module.base().commonProperties {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultsCommonProperties returns the common properties of a defaults module, which hold its
+// visibility.
+func defaultsCommonProperties(defaults Defaults) *commonProperties {
+	for _, prop := range defaults.properties() {
+		if cp, ok := prop.(*commonProperties); ok {
+			return cp
+		}
+	}
+	return nil
+}
+
+// recordVisibilityOrigins records which defaults module each inherited visibility rule comes from,
+// before the defaults are prepended to the visibility set directly on the module, so that the
+// visibility errors can tell the two apart.
+func recordVisibilityOrigins(ctx TopDownMutatorContext, defaultsList []Defaults) {
+	base := ctx.Module().base()
+	seen := make(map[string]bool)
+	for _, rule := range base.commonProperties.Visibility {
+		seen[rule] = true
+	}
+	for _, defaults := range defaultsList {
+		cp := defaultsCommonProperties(defaults)
+		if cp == nil {
+			continue
+		}
+		for _, rule := range cp.Visibility {
+			if !seen[rule] {
+				seen[rule] = true
+				base.commonProperties.VisibilityOrigins = append(base.commonProperties.VisibilityOrigins,
+					visibilityOrigin{rule, ctx.OtherModuleName(defaults)})
+			}
+		}
+	}
 }
 
 func RegisterDefaultsPreArchMutators(ctx RegisterMutatorsContext) {
@@ -15,6 +15,7 @@
 package android
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/google/blueprint/proptools"
@@ -22,6 +23,7 @@ import (
 
 type defaultsTestProperties struct {
 	Foo []string
+	Bar *string
 }
 
 type defaultsTestModule struct {
@@ -31,6 +33,15 @@ type defaultsTestModule struct {
 }
 
 func (d *defaultsTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	for i, foo := range d.properties.Foo {
+		if foo == "invalid" {
+			ctx.PropertyErrorf(IndexedProperty("foo", i), "invalid value %q", foo)
+		}
+	}
+	if String(d.properties.Bar) == "invalid" {
+		ctx.PropertyErrorf("bar", "invalid value %q", String(d.properties.Bar))
+	}
+
 	ctx.Build(pctx, BuildParams{
 		Rule:   Touch,
 		Output: PathForModuleOut(ctx, "out"),
@@ -114,3 +125,112 @@ func TestDefaultsAllowMissingDependencies(t *testing.T) {
 	// TODO: missing transitive defaults is currently not handled
 	_ = missingTransitiveDefaults
 }
+
+func TestDefaultsPropertyErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		err  string
+	}{
+		{
+			name: "list index",
+			bp: `
+				test {
+					name: "foo",
+					foo: ["valid", "invalid"],
+				}
+			`,
+			err: `module "foo": foo: item 1: invalid value "invalid"$`,
+		},
+		{
+			name: "set on module",
+			bp: `
+				defaults {
+					name: "defaults",
+					bar: "valid",
+				}
+
+				test {
+					name: "foo",
+					defaults: ["defaults"],
+					bar: "invalid",
+				}
+			`,
+			err: `module "foo": bar: invalid value "invalid"$`,
+		},
+		{
+			name: "inherited from defaults",
+			bp: `
+				defaults {
+					name: "defaults",
+					bar: "invalid",
+				}
+
+				test {
+					name: "foo",
+					defaults: ["defaults"],
+				}
+			`,
+			err: `module "foo": bar: invalid value "invalid" \(inherited from defaults module "defaults"\)$`,
+		},
+		{
+			name: "inherited list from defaults",
+			bp: `
+				defaults {
+					name: "defaults",
+					foo: ["invalid"],
+				}
+
+				test {
+					name: "foo",
+					defaults: ["defaults"],
+				}
+			`,
+			err: `module "foo": foo: item 0: invalid value "invalid" \(inherited from defaults module "defaults"\)$`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := TestConfig(buildDir, nil)
+
+			ctx := NewTestContext()
+			ctx.RegisterModuleType("test", ModuleFactoryAdaptor(defaultsTestModuleFactory))
+			ctx.RegisterModuleType("defaults", ModuleFactoryAdaptor(defaultsTestDefaultsFactory))
+			ctx.PreArchMutators(RegisterDefaultsPreArchMutators)
+			ctx.Register()
+
+			ctx.MockFileSystem(map[string][]byte{
+				"Android.bp": []byte(test.bp),
+			})
+
+			_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+			FailIfErrored(t, errs)
+			_, errs = ctx.PrepareBuildActions(config)
+			FailIfNoMatchingErrors(t, test.err, errs)
+		})
+	}
+}
+
+func TestSetPropertyNames(t *testing.T) {
+	type nested struct {
+		Baz *bool
+		Qux []string
+	}
+	props := &struct {
+		Foo    *string
+		Bar    []string
+		Unset  *string
+		Nested nested
+		Ptr    *nested
+	}{
+		Foo:    proptools.StringPtr("foo"),
+		Nested: nested{Qux: []string{"qux"}},
+		Ptr:    &nested{Baz: proptools.BoolPtr(false)},
+	}
+
+	want := []string{"foo", "nested.qux", "ptr.baz"}
+	if got := setPropertyNames([]interface{}{props}); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -15,8 +15,12 @@
 package android
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/google/blueprint"
 	_ "github.com/google/blueprint/bootstrap"
+	"github.com/google/blueprint/proptools"
 )
 
 var (
@@ -97,6 +101,14 @@ var (
 		},
 		"content")
 
+	// writeFile is used by WriteFileRule, which escapes the content for ninja, the shell and echo -e.
+	writeFile = pctx.AndroidStaticRule("writeFile",
+		blueprint.RuleParams{
+			Command:     `/bin/bash -c 'echo -e -n "$$0" > $out' $content`,
+			Description: "writing file $out",
+		},
+		"content")
+
 	// Used only when USE_GOMA=true is set, to restrict non-goma jobs to the local parallelism value
 	localPool = blueprint.NewBuiltinPool("local_pool")
 )
@@ -104,3 +116,61 @@ var (
 func init() {
 	pctx.Import("github.com/google/blueprint/bootstrap")
 }
+
+// The content of a file written by a single writeFile rule is passed on the command line, which is limited
+// to 128KiB per argument.  Leave room for the escaping.
+const writeFileRuleShardSize = 100 * 1024
+
+// echoEscaper escapes the backslashes in the content so that they aren't interpreted by echo -e, and
+// replaces the newlines with \n.
+var echoEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\n", `\n`,
+)
+
+var echoUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\n`, "\n",
+)
+
+// WriteFileRule creates a ninja rule that writes content to outputFile.  The content is written at build
+// time, so unlike files written by soong_build itself the file is only rewritten when its content
+// changes, it is removed by a clean build and it is a proper dependency of the rules that read it.
+// Large contents are written in shards that are concatenated.
+func WriteFileRule(ctx BuilderContext, outputFile WritablePath, content string) {
+	if len(content) <= writeFileRuleShardSize {
+		buildWriteFileRule(ctx, outputFile, content)
+		return
+	}
+
+	rel, _ := MaybeRel(ctx, PathForOutput(ctx).String(), outputFile.String())
+	var shards Paths
+	for i := 0; len(content) > 0; i++ {
+		n := len(content)
+		if n > writeFileRuleShardSize {
+			n = writeFileRuleShardSize
+		}
+		shard := PathForOutput(ctx, fmt.Sprintf("%s.%d", rel, i))
+		buildWriteFileRule(ctx, shard, content[:n])
+		shards = append(shards, shard)
+		content = content[n:]
+	}
+	ctx.Build(pctx, BuildParams{
+		Rule:        Cat,
+		Description: "concatenate " + outputFile.Base(),
+		Inputs:      shards,
+		Output:      outputFile,
+	})
+}
+
+func buildWriteFileRule(ctx BuilderContext, outputFile WritablePath, content string) {
+	content = "'" + strings.Replace(echoEscaper.Replace(content), "'", `'\''`, -1) + "'"
+	ctx.Build(pctx, BuildParams{
+		Rule:        writeFile,
+		Description: "write " + outputFile.Base(),
+		Output:      outputFile,
+		Args: map[string]string{
+			"content": proptools.NinjaEscape(content),
+		},
+	})
+}
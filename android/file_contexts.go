@@ -0,0 +1,118 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The selinux_label property labels the files a device module installs, so that a module that needs a
+// special label doesn't also need an edit of the file_contexts of the sepolicy in Make.  The singleton
+// below collects the labeled files into a file_contexts fragment per partition:
+//
+//   $OUT_DIR/soong/file_contexts/<partition>_file_contexts
+//
+// and reports the files that modules label differently.  The fragments are listed in
+// SOONG_FILE_CONTEXTS_FRAGMENTS as <partition>:<fragment> pairs for the sepolicy build in Make to
+// append to the file_contexts of each partition.
+
+func init() {
+	RegisterSingletonType("file_contexts", fileContextsSingletonFactory)
+}
+
+var selinuxLabelRegexp = regexp.MustCompile(`^u:object_r:[a-zA-Z0-9_]+:s0(:[a-z0-9,.]+)?$`)
+
+// checkSelinuxLabel reports a selinux_label that the sepolicy build wouldn't accept.
+func checkSelinuxLabel(ctx ModuleContext, label *string) {
+	if label != nil && !selinuxLabelRegexp.MatchString(*label) {
+		ctx.PropertyErrorf("selinux_label", "invalid label %q, expected u:object_r:<type>:s0", *label)
+	}
+}
+
+// recordFileContextsPath collects the on-device path of a file installed by a module with a
+// selinux_label.  The paths are collected even when Make installs the files instead of Soong, and
+// the files installed by host variants are ignored.
+func (m *moduleContext) recordFileContextsPath(fullInstallPath OutputPath) {
+	base := m.module.base()
+	if base.commonProperties.Selinux_label == nil || m.Host() {
+		return
+	}
+	base.fileContextsPaths = append(base.fileContextsPaths, InstallPathToOnDevicePath(m, fullInstallPath))
+}
+
+func fileContextsSingletonFactory() Singleton {
+	return &fileContextsSingleton{}
+}
+
+type fileContextsSingleton struct {
+	fragments []string
+}
+
+// fileContextsEntry is the label of an installed file and the module that labeled it.
+type fileContextsEntry struct {
+	label  string
+	module string
+}
+
+func (s *fileContextsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	// The labeled files of each partition, keyed by their on-device path.
+	partitions := make(map[string]map[string]fileContextsEntry)
+
+	ctx.VisitAllModules(func(module Module) {
+		if !module.Enabled() {
+			return
+		}
+		base := module.base()
+		label := String(base.commonProperties.Selinux_label)
+		for _, path := range base.fileContextsPaths {
+			partition := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+			if partitions[partition] == nil {
+				partitions[partition] = make(map[string]fileContextsEntry)
+			}
+			if existing, ok := partitions[partition][path]; ok && existing.label != label {
+				ctx.Errorf("%s is labeled %s by %s and %s by %s", path, existing.label, existing.module,
+					label, ctx.ModuleName(module))
+				continue
+			}
+			partitions[partition][path] = fileContextsEntry{label, ctx.ModuleName(module)}
+		}
+	})
+
+	s.fragments = nil
+	for _, partition := range SortedStringKeys(partitions) {
+		entries := partitions[partition]
+		paths := make([]string, 0, len(entries))
+		for path := range entries {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		var lines []string
+		for _, path := range paths {
+			lines = append(lines, fmt.Sprintf("%s %s", regexp.QuoteMeta(path), entries[path].label))
+		}
+
+		fragment := PathForOutput(ctx, "file_contexts", partition+"_file_contexts")
+		WriteFileRule(ctx, fragment, strings.Join(lines, "\n")+"\n")
+		s.fragments = append(s.fragments, partition+":"+fragment.String())
+	}
+}
+
+func (s *fileContextsSingleton) MakeVars(ctx MakeVarsContext) {
+	ctx.Strict("SOONG_FILE_CONTEXTS_FRAGMENTS", strings.Join(s.fragments, " "))
+}
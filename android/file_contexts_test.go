@@ -0,0 +1,145 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+type fileContextsTestModule struct {
+	ModuleBase
+	properties struct {
+		Stem *string
+	}
+}
+
+func fileContextsTestModuleFactory() Module {
+	m := &fileContextsTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, HostAndDeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *fileContextsTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: out,
+	})
+	stem := ctx.ModuleName()
+	if m.properties.Stem != nil {
+		stem = *m.properties.Stem
+	}
+	installed := ctx.InstallFile(PathForModuleInstall(ctx, "bin"), stem, out)
+	ctx.InstallSymlink(PathForModuleInstall(ctx, "bin"), ctx.ModuleName()+"-link", installed)
+}
+
+func testFileContexts(t *testing.T, bp string) (*TestContext, []error) {
+	t.Helper()
+
+	config := TestArchConfig(buildDir, nil)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(fileContextsTestModuleFactory))
+	ctx.RegisterSingletonType("file_contexts", SingletonFactoryAdaptor(fileContextsSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	if len(errs) > 0 {
+		return ctx, errs
+	}
+	_, errs = ctx.PrepareBuildActions(config)
+	return ctx, errs
+}
+
+func TestFileContexts(t *testing.T) {
+	ctx, errs := testFileContexts(t, `
+		test {
+			name: "foo",
+			selinux_label: "u:object_r:foo_exec:s0",
+		}
+
+		test {
+			name: "bar",
+			vendor: true,
+			selinux_label: "u:object_r:bar_exec:s0",
+		}
+
+		test {
+			name: "host",
+			host_supported: true,
+			selinux_label: "u:object_r:host_exec:s0",
+		}
+
+		test {
+			name: "unlabeled",
+		}
+	`)
+	FailIfErrored(t, errs)
+
+	singleton := ctx.SingletonForTests("file_contexts")
+
+	testCases := []struct {
+		fragment string
+		content  string
+	}{
+		{
+			fragment: "file_contexts/system_file_contexts",
+			content: "/system/bin/foo u:object_r:foo_exec:s0\n" +
+				"/system/bin/foo-link u:object_r:foo_exec:s0\n" +
+				"/system/bin/host u:object_r:host_exec:s0\n" +
+				"/system/bin/host-link u:object_r:host_exec:s0\n",
+		},
+		{
+			fragment: "file_contexts/vendor_file_contexts",
+			content: "/vendor/bin/bar u:object_r:bar_exec:s0\n" +
+				"/vendor/bin/bar-link u:object_r:bar_exec:s0\n",
+		},
+	}
+	for _, test := range testCases {
+		if g := ContentFromFileRuleForTests(t, singleton.Output(test.fragment)); g != test.content {
+			t.Errorf("expected %s content %q, got %q", test.fragment, test.content, g)
+		}
+	}
+}
+
+func TestFileContextsErrors(t *testing.T) {
+	_, errs := testFileContexts(t, `
+		test {
+			name: "foo",
+			stem: "tool",
+			selinux_label: "u:object_r:foo_exec:s0",
+		}
+
+		test {
+			name: "bar",
+			stem: "tool",
+			selinux_label: "u:object_r:bar_exec:s0",
+		}
+	`)
+	FailIfNoMatchingErrors(t, `/system/bin/tool is labeled u:object_r:(foo|bar)_exec:s0 by (foo|bar) and`, errs)
+
+	_, errs = testFileContexts(t, `
+		test {
+			name: "foo",
+			selinux_label: "foo_exec",
+		}
+	`)
+	FailIfNoMatchingErrors(t, `selinux_label: invalid label "foo_exec"`, errs)
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/blueprint"
+)
+
+// This singleton writes the intermediates directories whose variant name was replaced by a hash because it was longer
+// than MaxVariantDirLength to $OUT_DIR/soong/hashed_variant_dirs.json, along with the module variant that owns each
+// of them, so that tools that find a hashed path in the build output can resolve it back to its module.  It is built
+// by the soong_hashed_variant_dirs phony target.
+
+func init() {
+	RegisterSingletonType("hashed_variant_dirs", hashedVariantDirsSingletonFactory)
+}
+
+const hashedVariantDirsJsonFileName = "hashed_variant_dirs.json"
+
+// HashedVariantDir is an intermediates directory written to hashed_variant_dirs.json.
+type HashedVariantDir struct {
+	// The hashed directory, relative to the intermediates directory, e.g.
+	// packages/apps/Foo/Foo/0123456789abcdef0123456789abcdef.
+	Path string `json:"path"`
+
+	// The module variant that writes its intermediates to the directory.
+	Module  string `json:"module"`
+	Variant string `json:"variant"`
+	Dir     string `json:"dir"`
+}
+
+func hashedVariantDirsSingletonFactory() Singleton {
+	return &hashedVariantDirsSingleton{}
+}
+
+type hashedVariantDirsSingleton struct{}
+
+func (s *hashedVariantDirsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if ctx.Config().MaxVariantDirLength() == 0 {
+		return
+	}
+
+	var hashed []HashedVariantDir
+	ctx.VisitAllModules(func(module Module) {
+		variant := ctx.ModuleSubDir(module)
+		dir := variantDir(ctx.Config(), variant)
+		if dir == variant {
+			return
+		}
+		hashed = append(hashed, HashedVariantDir{
+			Path:    filepath.Join(ctx.ModuleDir(module), ctx.ModuleName(module), dir),
+			Module:  ctx.ModuleName(module),
+			Variant: variant,
+			Dir:     ctx.ModuleDir(module),
+		})
+	})
+
+	sort.Slice(hashed, func(i, j int) bool {
+		return hashed[i].Path < hashed[j].Path
+	})
+
+	buf, err := json.MarshalIndent(hashed, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal hashed variant dirs: %s", err)
+		return
+	}
+
+	file := PathForOutput(ctx, hashedVariantDirsJsonFileName)
+	WriteFileRule(ctx, file, string(buf))
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_hashed_variant_dirs"),
+		Input:  file,
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHashedVariantDirs(t *testing.T) {
+	config := TestArchConfig(buildDir, nil)
+	config.TestProductVariables.MaxVariantDirLength = intPtr(16)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(metadataTestModuleFactory))
+	ctx.RegisterSingletonType("hashed_variant_dirs", SingletonFactoryAdaptor(hashedVariantDirsSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "foo",
+			}
+		`),
+		"vendor/Android.bp": []byte(`
+			test {
+				name: "bar",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "vendor/Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	variant := "android_arm64_armv8-a"
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(variant)))
+
+	foo := ctx.ModuleForTests("foo", variant)
+	if g, w := foo.Output("foo").Output.String(), filepath.Join(buildDir, ".intermediates", "foo", hash, "foo"); g != w {
+		t.Errorf("expected output %q, got %q", w, g)
+	}
+
+	content := ContentFromFileRuleForTests(t, ctx.SingletonForTests("hashed_variant_dirs").Output(hashedVariantDirsJsonFileName))
+	var hashed []HashedVariantDir
+	if err := json.Unmarshal([]byte(content), &hashed); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HashedVariantDir{
+		{
+			Path:    filepath.Join("foo", hash),
+			Module:  "foo",
+			Variant: variant,
+			Dir:     ".",
+		},
+		{
+			Path:    filepath.Join("vendor", "bar", hash),
+			Module:  "bar",
+			Variant: variant,
+			Dir:     "vendor",
+		},
+	}
+	if !reflect.DeepEqual(hashed, want) {
+		t.Errorf("want %#v, got %#v", want, hashed)
+	}
+}
+
+func TestVariantDir(t *testing.T) {
+	config := TestConfig(buildDir, nil)
+
+	if g, w := variantDir(config, "android_arm64_armv8-a"), "android_arm64_armv8-a"; g != w {
+		t.Errorf("expected %q without MaxVariantDirLength, got %q", w, g)
+	}
+
+	config.TestProductVariables.MaxVariantDirLength = intPtr(21)
+	if g, w := variantDir(config, "android_arm64_armv8-a"), "android_arm64_armv8-a"; g != w {
+		t.Errorf("expected %q at MaxVariantDirLength, got %q", w, g)
+	}
+	if g, w := variantDir(config, "android_arm64_armv8-a_xhdpi"), fmt.Sprintf("%x", md5.Sum([]byte("android_arm64_armv8-a_xhdpi"))); g != w {
+		t.Errorf("expected %q above MaxVariantDirLength, got %q", w, g)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/google/blueprint"
+)
+
+// This singleton writes every install destination that Soong contributes, and the module that installs it, to
+// $OUT_DIR/soong/installed_files.json.  Comparing the file from a previous build with the current one gives the
+// exact list of files that were installed by modules that have since been removed, renamed or moved, so that an
+// install-clean step can remove only those instead of relying on the broad installclean heuristics in Make.  The
+// device files that Make installs for Soong modules when Soong is embedded in it are listed too.  It is built by
+// the soong_installed_files phony target.
+
+func init() {
+	RegisterSingletonType("installed_files", installedFilesSingletonFactory)
+}
+
+const installedFilesJsonFileName = "installed_files.json"
+
+// InstalledFile is an install destination written to installed_files.json.
+type InstalledFile struct {
+	// The installed path.  Paths in the product out directory are relative to it and start with a '/', e.g.
+	// /system/app/Foo/Foo.apk, other paths are relative to the top of the source tree.
+	Path string `json:"path"`
+
+	// The module that installs the file.
+	Module  string `json:"module"`
+	Variant string `json:"variant,omitempty"`
+	Dir     string `json:"dir"`
+}
+
+func installedFilesSingletonFactory() Singleton {
+	return &installedFilesSingleton{}
+}
+
+type installedFilesSingleton struct{}
+
+func (s *installedFilesSingleton) GenerateBuildActions(ctx SingletonContext) {
+	productOut := PathForOutput(ctx, "target", "product", ctx.Config().DeviceName()).String()
+
+	seen := make(map[string]bool)
+	var installed []InstalledFile
+	ctx.VisitAllModules(func(module Module) {
+		if !module.Enabled() {
+			return
+		}
+
+		for _, file := range module.base().installPaths {
+			path := metadataInstallPath(ctx, productOut, file.String())
+			// Symlinks and identical variants may install the same path, only list it once.
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			installed = append(installed, InstalledFile{
+				Path:    path,
+				Module:  ctx.ModuleName(module),
+				Variant: ctx.ModuleSubDir(module),
+				Dir:     ctx.ModuleDir(module),
+			})
+		}
+	})
+
+	sort.Slice(installed, func(i, j int) bool {
+		return installed[i].Path < installed[j].Path
+	})
+
+	buf, err := json.MarshalIndent(installed, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal installed files: %s", err)
+		return
+	}
+
+	file := PathForOutput(ctx, installedFilesJsonFileName)
+	WriteFileRule(ctx, file, string(buf))
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_installed_files"),
+		Input:  file,
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInstalledFiles(t *testing.T) {
+	// The device files are installed by Make when Soong is embedded in it, but they are still listed.
+	t.Run("soong", func(t *testing.T) {
+		testInstalledFiles(t, false)
+	})
+	t.Run("embedded in make", func(t *testing.T) {
+		testInstalledFiles(t, true)
+	})
+}
+
+func testInstalledFiles(t *testing.T, inMake bool) {
+	config := TestArchConfig(buildDir, nil)
+	config.inMake = inMake
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(metadataTestModuleFactory))
+	ctx.RegisterSingletonType("installed_files", SingletonFactoryAdaptor(installedFilesSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "foo",
+			}
+		`),
+		"vendor/Android.bp": []byte(`
+			test {
+				name: "bar",
+			}
+
+			test {
+				name: "baz",
+				enabled: false,
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "vendor/Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	content := ContentFromFileRuleForTests(t, ctx.SingletonForTests("installed_files").Output(installedFilesJsonFileName))
+	var installed []InstalledFile
+	if err := json.Unmarshal([]byte(content), &installed); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []InstalledFile{
+		{
+			Path:    "/system/bin/bar",
+			Module:  "bar",
+			Variant: "android_arm64_armv8-a",
+			Dir:     "vendor",
+		},
+		{
+			Path:    "/system/bin/foo",
+			Module:  "foo",
+			Variant: "android_arm64_armv8-a",
+			Dir:     ".",
+		},
+	}
+	if !reflect.DeepEqual(installed, want) {
+		t.Errorf("want %#v, got %#v", want, installed)
+	}
+}
@@ -86,6 +86,11 @@ type BaseModuleContext interface {
 	VisitDepsDepthFirstIf(pred func(Module) bool, visit func(Module))
 	WalkDeps(visit func(Module, Module) bool)
 	WalkDepsBlueprint(visit func(blueprint.Module, blueprint.Module) bool)
+	// WalkDepsWithTags is like WalkDeps, but also passes visit the dependency tag of the edge from
+	// parent to child, so that it can prune the walk by tag, and whether it is the first time child is
+	// visited, so that it can avoid walking the dependencies of a module reachable through several
+	// paths more than once.
+	WalkDepsWithTags(visit func(child, parent Module, tag blueprint.DependencyTag, firstVisit bool) bool)
 	// GetWalkPath is supposed to be called in visit function passed in WalkDeps()
 	// and returns a top-down dependency path from a start module to current child module.
 	GetWalkPath() []Module
@@ -95,6 +100,9 @@ type BaseModuleContext interface {
 	ModuleErrorf(fmt string, args ...interface{})
 	PropertyErrorf(property, fmt string, args ...interface{})
 	Failed() bool
+	// ModuleWarningf records a warning about the module that soong_build prints once analysis has
+	// finished.
+	ModuleWarningf(fmt string, args ...interface{})
 
 	// GlobWithDeps returns a list of files that match the specified pattern but do not match any
 	// of the patterns in excludes.  It also adds efficient dependencies to rerun the primary
@@ -174,6 +182,18 @@ type ModuleContext interface {
 
 	GetMissingDependencies() []string
 	Namespace() blueprint.Namespace
+
+	// SetProvider sets the value of a provider of the module, which must have the type the provider
+	// was created with.  It can be read by the modules that depend on the module and by singletons.
+	SetProvider(key *ProviderKey, value interface{})
+	// Provider returns the value of a provider of the module, or nil if it hasn't been set.
+	Provider(key *ProviderKey) interface{}
+	// OtherModuleProvider returns the value of a provider of a direct dependency of the module, or
+	// nil if the dependency hasn't set it.  It panics if m is not a direct dependency of the module.
+	OtherModuleProvider(m blueprint.Module, key *ProviderKey) interface{}
+	// OtherModuleHasProvider returns true if a direct dependency of the module has set a provider.
+	// It panics if m is not a direct dependency of the module.
+	OtherModuleHasProvider(m blueprint.Module, key *ProviderKey) bool
 }
 
 type Module interface {
@@ -193,6 +213,9 @@ type Module interface {
 	InstallInSanitizerDir() bool
 	InstallInRecovery() bool
 	SkipInstall()
+	IsSkipInstall() bool
+	HideFromMake()
+	IsHideFromMake() bool
 	ExportedToMake() bool
 	NoticeFile() OptionalPath
 
@@ -213,6 +236,20 @@ type commonProperties struct {
 	// emit build rules for this module
 	Enabled *bool `android:"arch_variant"`
 
+	// vendor soong config variables that must hold for this module to be enabled, each of the form
+	// <namespace>:<variable> (the variable is true), !<namespace>:<variable> (the variable is not true) or
+	// <namespace>:<variable>=<value> (the variable is set to the value).  It is an error if the product doesn't
+	// declare the namespace or the variable.
+	Enabled_if_soong_config []string
+
+	// The conditions of enabled_if_soong_config that did not hold and disabled this module.
+	Soong_config_disabled_by []string `blueprint:"mutated"`
+
+	// The SELinux label of the files installed by the module, like u:object_r:foo_exec:s0.  The
+	// files are added to the file_contexts of the partition they are installed to.  Ignored for the
+	// host variants of the module.
+	Selinux_label *string
+
 	// Controls the visibility of this module to other modules. Allowable values are one or more of
 	// these formats:
 	//
@@ -264,6 +301,9 @@ type commonProperties struct {
 	// vendor who owns this module
 	Owner *string
 
+	// the licenses of the sources of this module, as SPDX license identifiers like Apache-2.0
+	Licenses []string
+
 	// whether this module is specific to an SoC (System-On-a-Chip). When set to true,
 	// it is installed into /vendor (or /system/vendor if vendor partition does not exist).
 	// Use `soc_specific` instead for better meaning.
@@ -310,6 +350,16 @@ type commonProperties struct {
 	// names of other modules to install on target if this module is installed
 	Target_required []string `android:"arch_variant"`
 
+	// If set to true, this variant of the module is only built as an intermediate of other modules, for
+	// example an app that is only embedded in another app.  It is neither installed nor exported to Make,
+	// and no other module can require it.
+	Skip_install *bool `android:"arch_variant"`
+
+	// If set to true, this variant of the module is not exported to Make, so that Make modules can't
+	// depend on it and no other module can require it.  Unlike skip_install, its files are still
+	// installed when Soong installs the modules.
+	No_androidmk *bool `android:"arch_variant"`
+
 	// relative path to a file to include in the list of notices for the device
 	Notice *string `android:"path"`
 
@@ -340,11 +390,18 @@ type commonProperties struct {
 	HostOrDeviceSupported HostOrDeviceSupported `blueprint:"mutated"`
 	ArchSpecific          bool                  `blueprint:"mutated"`
 
-	SkipInstall bool `blueprint:"mutated"`
+	SkipInstall  bool `blueprint:"mutated"`
+	HideFromMake bool `blueprint:"mutated"`
 
 	NamespaceExportedToMake bool `blueprint:"mutated"`
 
 	MissingDeps []string `blueprint:"mutated"`
+
+	// Where the properties that were not set directly on the module were set, see PropertyErrorf.
+	PropertyOrigins []propertyOrigin `blueprint:"mutated"`
+
+	// The defaults modules that the rules of the visibility property were inherited from.
+	VisibilityOrigins []visibilityOrigin `blueprint:"mutated"`
 }
 
 type hostAndDeviceProperties struct {
@@ -509,15 +566,40 @@ type ModuleBase struct {
 
 	noAddressSanitizer bool
 	installFiles       Paths
+	// The on-device paths of the installed files when selinux_label is set
+	fileContextsPaths []string
 	checkbuildFiles    Paths
 	noticeFile         OptionalPath
 
+	// The paths the module installs to, including the device files that Make installs when Soong is
+	// embedded in it, for installed_files.json
+	installPaths Paths
+
+	// The values of the providers set by the module, see SetProvider.
+	providers map[*ProviderKey]interface{}
+
+	// The direct dependencies and their tags, collected once before GenerateAndroidBuildActions for
+	// the provider checks, module-info.json, the module metadata and the module graph.
+	directDeps []directDep
+
+	// The metadata of the installed artifacts published through ArtifactMetadataProvider
+	artifactMetadata []ArtifactMetadata
+
+	// The number of build actions and of their declared outputs, checked against the build budgets
+	buildActionCount int
+	buildOutputCount int
+
 	// Used by buildTargetSingleton to create checkbuild and per-directory build targets
 	// Only set on the final variant of each module
 	installTarget    WritablePath
 	checkbuildTarget WritablePath
 	blueprintDir     string
 
+	// Used by buildTargetSingleton to install the modules required by the module, and the names of
+	// the required modules of all the variants.  Only set on the final variant of each module.
+	requiredTarget WritablePath
+	requiredNames  []string
+
 	hooks hooks
 
 	registerProps []interface{}
@@ -658,6 +740,11 @@ func (m *ModuleBase) ProductServicesSpecific() bool {
 }
 
 func (m *ModuleBase) Enabled() bool {
+	// The enabled property is arch variant, the conditions of enabled_if_soong_config are checked here so that
+	// target or arch specific values of enabled can't enable a module they disabled.
+	if len(m.commonProperties.Soong_config_disabled_by) > 0 {
+		return false
+	}
 	if m.commonProperties.Enabled == nil {
 		return !m.Os().DefaultDisabled
 	}
@@ -668,6 +755,24 @@ func (m *ModuleBase) SkipInstall() {
 	m.commonProperties.SkipInstall = true
 }
 
+// IsSkipInstall returns true if the module is not installed, because SkipInstall was called on it, e.g. for a
+// prebuilt that is not preferred over its source module.
+func (m *ModuleBase) IsSkipInstall() bool {
+	return m.commonProperties.SkipInstall
+}
+
+// HideFromMake keeps the module from being exported to Make, without affecting whether it is installed.  A variant
+// that is only built as an intermediate of other modules calls both SkipInstall and HideFromMake.
+func (m *ModuleBase) HideFromMake() {
+	m.commonProperties.HideFromMake = true
+}
+
+// IsHideFromMake returns true if the module is not exported to Make, because HideFromMake was called on it or
+// because it sets skip_install or no_androidmk.
+func (m *ModuleBase) IsHideFromMake() bool {
+	return m.commonProperties.HideFromMake
+}
+
 func (m *ModuleBase) ExportedToMake() bool {
 	return m.commonProperties.NamespaceExportedToMake
 }
@@ -715,13 +820,30 @@ func (m *ModuleBase) NoticeFile() OptionalPath {
 	return m.noticeFile
 }
 
+// requiredModuleNames returns the names of the modules that are installed along with this variant of the module,
+// following the LOCAL_REQUIRED_MODULES, LOCAL_HOST_REQUIRED_MODULES and LOCAL_TARGET_REQUIRED_MODULES semantics of
+// Make: host_required only applies to device modules and target_required only applies to host modules.
+func (m *ModuleBase) requiredModuleNames() []string {
+	required := m.commonProperties.Required
+	if m.Os().Class == Device {
+		required = append(CopyOf(required), m.commonProperties.Host_required...)
+	} else {
+		required = append(CopyOf(required), m.commonProperties.Target_required...)
+	}
+	return required
+}
+
 func (m *ModuleBase) generateModuleTarget(ctx ModuleContext) {
 	allInstalledFiles := Paths{}
 	allCheckbuildFiles := Paths{}
+	var allRequired []string
 	ctx.VisitAllModuleVariants(func(module Module) {
 		a := module.base()
 		allInstalledFiles = append(allInstalledFiles, a.installFiles...)
 		allCheckbuildFiles = append(allCheckbuildFiles, a.checkbuildFiles...)
+		if a.Enabled() {
+			allRequired = append(allRequired, a.requiredModuleNames()...)
+		}
 	})
 
 	var deps Paths
@@ -754,7 +876,25 @@ func (m *ModuleBase) generateModuleTarget(ctx ModuleContext) {
 		m.checkbuildTarget = name
 	}
 
-	if len(deps) > 0 {
+	// When Soong is embedded in Make, Make installs the required modules.  Otherwise building a module
+	// also installs the modules it requires, through a <module>-required target that buildTargetSingleton
+	// creates once the install targets of all the modules are known.
+	if !ctx.Config().EmbeddedInMake() {
+		var required []string
+		for _, name := range FirstUniqueStrings(allRequired) {
+			if name != ctx.ModuleName() {
+				required = append(required, name)
+			}
+		}
+		if len(required) > 0 {
+			name := PathForPhony(ctx, namespacePrefix+ctx.ModuleName()+"-required")
+			deps = append(deps, name)
+			m.requiredTarget = name
+			m.requiredNames = required
+		}
+	}
+
+	if len(deps) > 0 || !ctx.Config().EmbeddedInMake() {
 		suffix := ""
 		if ctx.Config().EmbeddedInMake() {
 			suffix = "-soong"
@@ -899,15 +1039,27 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 			ctx.PropertyErrorf("dist.suffix", "Suffix may not contain a '/' character.")
 		}
 	}
+	checkSelinuxLabel(ctx, m.commonProperties.Selinux_label)
+	m.collectDirectDeps(ctx)
+
+	if Bool(m.commonProperties.Skip_install) {
+		m.SkipInstall()
+		m.HideFromMake()
+	}
+	if Bool(m.commonProperties.No_androidmk) {
+		m.HideFromMake()
+	}
 
 	if m.Enabled() {
-		m.module.GenerateAndroidBuildActions(ctx)
+		m.generateAndroidBuildActionsWithTiming(ctx)
 		if ctx.Failed() {
 			return
 		}
 
 		m.installFiles = append(m.installFiles, ctx.installFiles...)
+		m.installPaths = append(m.installPaths, ctx.installPaths...)
 		m.checkbuildFiles = append(m.checkbuildFiles, ctx.checkbuildFiles...)
+		m.recordArtifactMetadata(ctx)
 
 		notice := proptools.StringDefault(m.commonProperties.Notice, "NOTICE")
 		if module := SrcIsModule(notice); module != "" {
@@ -936,6 +1088,19 @@ func (m *ModuleBase) GenerateBuildActions(blueprintCtx blueprint.ModuleContext)
 	m.variables = ctx.variables
 }
 
+type directDep struct {
+	module blueprint.Module
+	tag    blueprint.DependencyTag
+}
+
+// collectDirectDeps records the direct dependencies of the module and their tags, which are not
+// available to singletons.
+func (m *ModuleBase) collectDirectDeps(ctx ModuleContext) {
+	ctx.VisitDirectDepsBlueprint(func(dep blueprint.Module) {
+		m.directDeps = append(m.directDeps, directDep{dep, ctx.OtherModuleDependencyTag(dep)})
+	})
+}
+
 type baseModuleContext struct {
 	blueprint.BaseModuleContext
 	target        Target
@@ -948,6 +1113,8 @@ type baseModuleContext struct {
 	walkPath []Module
 
 	strictVisitDeps bool // If true, enforce that all dependencies are enabled
+
+	analysisTiming *analysisTiming // Non-nil if SOONG_MODULE_ANALYSIS_THRESHOLD is set
 }
 
 type moduleContext struct {
@@ -955,6 +1122,7 @@ type moduleContext struct {
 	baseModuleContext
 	installDeps     Paths
 	installFiles    Paths
+	installPaths    Paths
 	checkbuildFiles Paths
 	module          Module
 
@@ -962,6 +1130,10 @@ type moduleContext struct {
 	buildParams []BuildParams
 	ruleParams  map[blueprint.Rule]blueprint.RuleParams
 	variables   map[string]string
+
+	// The direct dependencies whose providers the module can read, built from the directDeps of the
+	// module on the first read of a provider, see OtherModuleProvider.
+	providerDeps map[blueprint.Module]bool
 }
 
 func (m *moduleContext) ninjaError(params BuildParams, err error) (PackageContext, BuildParams) {
@@ -1056,6 +1228,8 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 		m.buildParams = append(m.buildParams, params)
 	}
 
+	m.module.base().countBuildActions(params)
+
 	m.bp.Build(pctx.PackageContext, convertBuildParams(params))
 }
 
@@ -1153,10 +1327,16 @@ func (b *baseModuleContext) GetDirectDep(name string) (blueprint.Module, bluepri
 }
 
 func (b *baseModuleContext) VisitDirectDepsBlueprint(visit func(blueprint.Module)) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.VisitDirectDeps(visit)
 }
 
 func (b *baseModuleContext) VisitDirectDeps(visit func(Module)) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.VisitDirectDeps(func(module blueprint.Module) {
 		if aModule := b.validateAndroidModule(module, b.strictVisitDeps); aModule != nil {
 			visit(aModule)
@@ -1165,6 +1345,9 @@ func (b *baseModuleContext) VisitDirectDeps(visit func(Module)) {
 }
 
 func (b *baseModuleContext) VisitDirectDepsWithTag(tag blueprint.DependencyTag, visit func(Module)) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.VisitDirectDeps(func(module blueprint.Module) {
 		if aModule := b.validateAndroidModule(module, b.strictVisitDeps); aModule != nil {
 			if b.BaseModuleContext.OtherModuleDependencyTag(aModule) == tag {
@@ -1175,6 +1358,9 @@ func (b *baseModuleContext) VisitDirectDepsWithTag(tag blueprint.DependencyTag,
 }
 
 func (b *baseModuleContext) VisitDirectDepsIf(pred func(Module) bool, visit func(Module)) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.VisitDirectDepsIf(
 		// pred
 		func(module blueprint.Module) bool {
@@ -1191,6 +1377,9 @@ func (b *baseModuleContext) VisitDirectDepsIf(pred func(Module) bool, visit func
 }
 
 func (b *baseModuleContext) VisitDepsDepthFirst(visit func(Module)) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.VisitDepsDepthFirst(func(module blueprint.Module) {
 		if aModule := b.validateAndroidModule(module, b.strictVisitDeps); aModule != nil {
 			visit(aModule)
@@ -1199,6 +1388,9 @@ func (b *baseModuleContext) VisitDepsDepthFirst(visit func(Module)) {
 }
 
 func (b *baseModuleContext) VisitDepsDepthFirstIf(pred func(Module) bool, visit func(Module)) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.VisitDepsDepthFirstIf(
 		// pred
 		func(module blueprint.Module) bool {
@@ -1215,10 +1407,16 @@ func (b *baseModuleContext) VisitDepsDepthFirstIf(pred func(Module) bool, visit
 }
 
 func (b *baseModuleContext) WalkDepsBlueprint(visit func(blueprint.Module, blueprint.Module) bool) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.BaseModuleContext.WalkDeps(visit)
 }
 
 func (b *baseModuleContext) WalkDeps(visit func(Module, Module) bool) {
+	if t := b.analysisTiming; t != nil {
+		defer t.endDepVisit(t.beginDepVisit())
+	}
 	b.walkPath = []Module{b.Module()}
 	b.BaseModuleContext.WalkDeps(func(child, parent blueprint.Module) bool {
 		childAndroidModule, _ := child.(Module)
@@ -1236,6 +1434,18 @@ func (b *baseModuleContext) WalkDeps(visit func(Module, Module) bool) {
 	})
 }
 
+func (b *baseModuleContext) WalkDepsWithTags(visit func(child, parent Module, tag blueprint.DependencyTag,
+	firstVisit bool) bool) {
+
+	visited := make(map[Module]bool)
+	b.WalkDeps(func(child, parent Module) bool {
+		firstVisit := !visited[child]
+		visited[child] = true
+		// OtherModuleDependencyTag returns the tag of the edge being visited during a walk.
+		return visit(child, parent, b.OtherModuleDependencyTag(child), firstVisit)
+	})
+}
+
 func (b *baseModuleContext) GetWalkPath() []Module {
 	return b.walkPath
 }
@@ -1403,6 +1613,7 @@ func (m *moduleContext) installFile(installPath OutputPath, name string, srcPath
 
 	fullInstallPath := installPath.Join(m, name)
 	m.module.base().hooks.runInstallHooks(m, fullInstallPath, false)
+	m.recordFileContextsPath(fullInstallPath)
 
 	if !m.skipInstall(fullInstallPath) {
 
@@ -1430,6 +1641,7 @@ func (m *moduleContext) installFile(installPath OutputPath, name string, srcPath
 
 		m.installFiles = append(m.installFiles, fullInstallPath)
 	}
+	m.recordInstallPath(fullInstallPath)
 	m.checkbuildFiles = append(m.checkbuildFiles, srcPath)
 	return fullInstallPath
 }
@@ -1437,6 +1649,7 @@ func (m *moduleContext) installFile(installPath OutputPath, name string, srcPath
 func (m *moduleContext) InstallSymlink(installPath OutputPath, name string, srcPath OutputPath) OutputPath {
 	fullInstallPath := installPath.Join(m, name)
 	m.module.base().hooks.runInstallHooks(m, fullInstallPath, true)
+	m.recordFileContextsPath(fullInstallPath)
 
 	if !m.skipInstall(fullInstallPath) {
 
@@ -1458,6 +1671,7 @@ func (m *moduleContext) InstallSymlink(installPath OutputPath, name string, srcP
 		m.installFiles = append(m.installFiles, fullInstallPath)
 		m.checkbuildFiles = append(m.checkbuildFiles, srcPath)
 	}
+	m.recordInstallPath(fullInstallPath)
 	return fullInstallPath
 }
 
@@ -1466,6 +1680,7 @@ func (m *moduleContext) InstallSymlink(installPath OutputPath, name string, srcP
 func (m *moduleContext) InstallAbsoluteSymlink(installPath OutputPath, name string, absPath string) OutputPath {
 	fullInstallPath := installPath.Join(m, name)
 	m.module.base().hooks.runInstallHooks(m, fullInstallPath, true)
+	m.recordFileContextsPath(fullInstallPath)
 
 	if !m.skipInstall(fullInstallPath) {
 		m.Build(pctx, BuildParams{
@@ -1480,9 +1695,23 @@ func (m *moduleContext) InstallAbsoluteSymlink(installPath OutputPath, name stri
 
 		m.installFiles = append(m.installFiles, fullInstallPath)
 	}
+	m.recordInstallPath(fullInstallPath)
 	return fullInstallPath
 }
 
+// recordInstallPath records a path the module installs to for installed_files.json.  When Soong is
+// embedded in Make the device files are installed by Make instead, but they are still installed.
+func (m *moduleContext) recordInstallPath(fullInstallPath OutputPath) {
+	base := m.module.base()
+	if base.commonProperties.SkipInstall || !base.commonProperties.NamespaceExportedToMake {
+		return
+	}
+	if m.skipInstall(fullInstallPath) && base.IsHideFromMake() {
+		return
+	}
+	m.installPaths = append(m.installPaths, fullInstallPath)
+}
+
 func (m *moduleContext) CheckbuildFile(srcPath Path) {
 	m.checkbuildFiles = append(m.checkbuildFiles, srcPath)
 }
@@ -1634,6 +1863,9 @@ func (m *moduleContext) TargetRequiredModuleNames() []string {
 }
 
 func (b *baseModuleContext) Glob(globPattern string, excludes []string) Paths {
+	if t := b.analysisTiming; t != nil {
+		defer t.endGlob(t.beginGlob())
+	}
 	ret, err := b.GlobWithDeps(globPattern, excludes)
 	if err != nil {
 		b.ModuleErrorf("glob: %s", err.Error())
@@ -1642,6 +1874,9 @@ func (b *baseModuleContext) Glob(globPattern string, excludes []string) Paths {
 }
 
 func (b *baseModuleContext) GlobFiles(globPattern string, excludes []string) Paths {
+	if t := b.analysisTiming; t != nil {
+		defer t.endGlob(t.beginGlob())
+	}
 	ret, err := b.GlobWithDeps(globPattern, excludes)
 	if err != nil {
 		b.ModuleErrorf("glob: %s", err.Error())
@@ -1664,6 +1899,49 @@ func parentDir(dir string) string {
 
 type buildTargetSingleton struct{}
 
+// buildRequiredTargets creates the <module>-required targets that install the modules required by a module,
+// and the modules that they require in turn.  Only the names of modules defined in Soong that install files
+// resolve to install targets, names that only exist in Make are skipped.  The install targets of all the
+// transitively required modules are order-only dependencies of the target, instead of the phony targets of
+// the required modules, so that modules that require each other don't create a dependency cycle.
+func buildRequiredTargets(ctx SingletonContext) {
+	installTargets := make(map[string]Paths)
+	requiredNames := make(map[string][]string)
+	var requiring []*ModuleBase
+	ctx.VisitAllModules(func(module Module) {
+		name := ctx.ModuleName(module)
+		if installTarget := module.base().installTarget; installTarget != nil {
+			installTargets[name] = append(installTargets[name], installTarget)
+		}
+		if module.base().requiredTarget != nil {
+			requiredNames[name] = append(requiredNames[name], module.base().requiredNames...)
+			requiring = append(requiring, module.base())
+		}
+	})
+
+	for _, m := range requiring {
+		var orderOnly Paths
+		visited := make(map[string]bool)
+		queue := CopyOf(m.requiredNames)
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			if visited[name] {
+				continue
+			}
+			visited[name] = true
+			orderOnly = append(orderOnly, installTargets[name]...)
+			queue = append(queue, requiredNames[name]...)
+		}
+
+		ctx.Build(pctx, BuildParams{
+			Rule:      blueprint.Phony,
+			Output:    m.requiredTarget,
+			OrderOnly: orderOnly,
+		})
+	}
+}
+
 func (c *buildTargetSingleton) GenerateBuildActions(ctx SingletonContext) {
 	var checkbuildDeps Paths
 
@@ -1706,6 +1984,8 @@ func (c *buildTargetSingleton) GenerateBuildActions(ctx SingletonContext) {
 		return
 	}
 
+	buildRequiredTargets(ctx)
+
 	// Ensure ancestor directories are in modulesInDir
 	dirs := SortedStringKeys(modulesInDir)
 	for _, dir := range dirs {
@@ -0,0 +1,228 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// This singleton writes the module graph, once all of the mutators have run, to the file passed to
+// soong_build with -module_graph, for IDEs and analysis tools.  Unlike module_metadata.json it
+// covers every variant of every module, including the disabled ones, and the dependencies between
+// the variants with their tags.
+
+func init() {
+	RegisterSingletonType("module_graph", moduleGraphSingletonFactory)
+}
+
+// ModuleGraphNode is a module variant in the module graph.
+type ModuleGraphNode struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Variant   string `json:"variant,omitempty"`
+	Dir       string `json:"dir"`
+	Blueprint string `json:"blueprint"`
+
+	Enabled bool `json:"enabled"`
+	// The os and arch of the variant, e.g. android_arm64, for the modules that have arch variants.
+	Target string `json:"target,omitempty"`
+	// The kind of partition the module is specific to, e.g. soc-specific.
+	Kind  string `json:"kind"`
+	Owner string `json:"owner,omitempty"`
+	// Whether the variant is installed in the recovery partition instead of Kind's.
+	Recovery bool `json:"recovery,omitempty"`
+	// Whether the variant is not installed, or not exported to Make.
+	SkipInstall  bool `json:"skip_install,omitempty"`
+	HideFromMake bool `json:"hide_from_make,omitempty"`
+
+	Licenses       []string `json:"licenses,omitempty"`
+	Visibility     []string `json:"visibility,omitempty"`
+	Required       []string `json:"required,omitempty"`
+	HostRequired   []string `json:"host_required,omitempty"`
+	TargetRequired []string `json:"target_required,omitempty"`
+
+	// The files the module produces that other modules can reference with ":<name>", and the paths
+	// it installs to.  Installed paths in the product out directory are relative to it and start
+	// with a '/', like in module_metadata.json.
+	Outputs   []string `json:"outputs,omitempty"`
+	Installed []string `json:"installed,omitempty"`
+
+	Deps []ModuleGraphDep `json:"deps,omitempty"`
+}
+
+// ModuleGraphDep is a dependency of a module variant on another module variant.
+type ModuleGraphDep struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant,omitempty"`
+	// The dependency tag, formatted as <package>.<type>{<field>:<value> ...} with the fields that have
+	// a string, bool or number value, or empty for dependencies without a tag.
+	Tag string `json:"tag,omitempty"`
+}
+
+func moduleGraphSingletonFactory() Singleton {
+	return &moduleGraphSingleton{}
+}
+
+type moduleGraphSingleton struct{}
+
+func (s *moduleGraphSingleton) GenerateBuildActions(ctx SingletonContext) {
+	file := ctx.Config().ModuleGraphFile()
+	if file == "" {
+		return
+	}
+
+	productOut := PathForOutput(ctx, "target", "product", ctx.Config().DeviceName()).String()
+
+	var nodes []ModuleGraphNode
+	ctx.VisitAllModules(func(module Module) {
+		base := module.base()
+		node := ModuleGraphNode{
+			Name:      ctx.ModuleName(module),
+			Type:      ctx.ModuleType(module),
+			Variant:   ctx.ModuleSubDir(module),
+			Dir:       ctx.ModuleDir(module),
+			Blueprint: ctx.BlueprintFile(module),
+			Enabled:   module.Enabled(),
+			Kind:      moduleGraphKind(base).String(),
+			Owner:     base.Owner(),
+
+			Recovery:     module.InstallInRecovery(),
+			SkipInstall:  base.IsSkipInstall(),
+			HideFromMake: base.IsHideFromMake(),
+
+			Licenses:       base.commonProperties.Licenses,
+			Visibility:     base.commonProperties.Visibility,
+			Required:       base.commonProperties.Required,
+			HostRequired:   base.commonProperties.Host_required,
+			TargetRequired: base.commonProperties.Target_required,
+		}
+		if target := module.Target(); target.Os.Name != "" {
+			node.Target = target.Os.String() + "_" + target.Arch.ArchType.String()
+		}
+
+		if node.Enabled {
+			if producer, ok := module.(OutputFileProducer); ok {
+				// Not every module type produces files for the default tag.
+				outputs, _ := producer.OutputFiles("")
+				node.Outputs = outputs.Strings()
+			}
+			for _, installed := range base.filesToInstall() {
+				node.Installed = append(node.Installed, metadataInstallPath(ctx, productOut, installed.String()))
+			}
+		}
+
+		for _, dep := range base.directDeps {
+			node.Deps = append(node.Deps, ModuleGraphDep{
+				Name:    ctx.ModuleName(dep.module),
+				Variant: ctx.ModuleSubDir(dep.module),
+				Tag:     moduleGraphTag(dep.tag),
+			})
+		}
+
+		nodes = append(nodes, node)
+	})
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Dir != nodes[j].Dir {
+			return nodes[i].Dir < nodes[j].Dir
+		}
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].Variant < nodes[j].Variant
+	})
+
+	buf, err := json.MarshalIndent(nodes, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal module graph: %s", err)
+		return
+	}
+	if err := ioutil.WriteFile(file, buf, 0666); err != nil {
+		ctx.Errorf("failed to write %s: %s", file, err)
+	}
+}
+
+// moduleGraphKind returns the kind of a module like determineModuleKind, without reporting the
+// conflicting properties again.
+func moduleGraphKind(m *ModuleBase) moduleKind {
+	switch {
+	case m.ProductSpecific():
+		return productSpecificModule
+	case m.ProductServicesSpecific():
+		return productServicesSpecificModule
+	case m.DeviceSpecific():
+		return deviceSpecificModule
+	case m.SocSpecific():
+		return socSpecificModule
+	default:
+		return platformModule
+	}
+}
+
+// moduleGraphTag formats a dependency tag with its type name and the fields that have a string, bool
+// or number value.  Tags that are pointers are formatted as the value they point to, and fields that
+// are pointers, embedded or of any other kind are skipped, so that the output doesn't depend on
+// addresses.
+func moduleGraphTag(tag blueprint.DependencyTag) string {
+	if tag == nil {
+		return ""
+	}
+	v := reflect.Indirect(reflect.ValueOf(tag))
+	if !v.IsValid() {
+		return fmt.Sprintf("%T", tag)
+	}
+	if v.Kind() != reflect.Struct {
+		if value, ok := moduleGraphTagValue(v); ok {
+			return fmt.Sprintf("%s(%s)", v.Type(), value)
+		}
+		return v.Type().String()
+	}
+
+	var fields []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous {
+			continue
+		}
+		if value, ok := moduleGraphTagValue(v.Field(i)); ok {
+			fields = append(fields, field.Name+":"+value)
+		}
+	}
+	return v.Type().String() + "{" + strings.Join(fields, " ") + "}"
+}
+
+// moduleGraphTagValue formats a value of a dependency tag if it is a string, a bool or a number.  It
+// doesn't use Interface() so that it also works for unexported fields.
+func moduleGraphTagValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String()), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	}
+	return "", false
+}
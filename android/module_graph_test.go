@@ -0,0 +1,155 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+type graphTestDepTag struct {
+	blueprint.BaseDependencyTag
+	name string
+}
+
+type graphTestModule struct {
+	ModuleBase
+	properties struct {
+		Deps []string
+	}
+}
+
+func graphTestModuleFactory() Module {
+	m := &graphTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *graphTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), graphTestDepTag{name: "lib"}, m.properties.Deps...)
+}
+
+func (m *graphTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: out,
+	})
+	ctx.InstallFile(PathForModuleInstall(ctx, "bin"), ctx.ModuleName(), out)
+}
+
+func TestModuleGraph(t *testing.T) {
+	file := filepath.Join(buildDir, "module_graph.json")
+	config := TestArchConfig(buildDir, nil)
+	config.SetModuleGraphFile(file)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(graphTestModuleFactory))
+	ctx.RegisterSingletonType("module_graph", SingletonFactoryAdaptor(moduleGraphSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"foo/Android.bp": []byte(`
+			test {
+				name: "foo",
+				deps: ["bar"],
+				owner: "acme",
+				licenses: ["Apache-2.0"],
+				visibility: ["//visibility:public"],
+				required: ["baz"],
+			}
+
+			test {
+				name: "bar",
+				vendor: true,
+			}
+
+			test {
+				name: "baz",
+				enabled: false,
+				recovery: true,
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"foo/Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []ModuleGraphNode
+	if err := json.Unmarshal(buf, &nodes); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ModuleGraphNode{
+		{
+			Name:      "bar",
+			Type:      "test",
+			Variant:   "android_arm64_armv8-a",
+			Dir:       "foo",
+			Blueprint: "foo/Android.bp",
+			Enabled:   true,
+			Target:    "android_arm64",
+			Kind:      "soc-specific",
+			Installed: []string{"/vendor/bin/bar"},
+		},
+		{
+			Name:      "baz",
+			Type:      "test",
+			Variant:   "android_arm64_armv8-a",
+			Dir:       "foo",
+			Blueprint: "foo/Android.bp",
+			Target:    "android_arm64",
+			Kind:      "platform",
+			Recovery:  true,
+		},
+		{
+			Name:       "foo",
+			Type:       "test",
+			Variant:    "android_arm64_armv8-a",
+			Dir:        "foo",
+			Blueprint:  "foo/Android.bp",
+			Enabled:    true,
+			Target:     "android_arm64",
+			Kind:       "platform",
+			Owner:      "acme",
+			Licenses:   []string{"Apache-2.0"},
+			Visibility: []string{"//visibility:public"},
+			Required:   []string{"baz"},
+			Installed:  []string{"/system/bin/foo"},
+			Deps: []ModuleGraphDep{
+				{
+					Name:    "bar",
+					Variant: "android_arm64_armv8-a",
+					Tag:     `android.graphTestDepTag{name:"lib"}`,
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("want %#v, got %#v", want, nodes)
+	}
+}
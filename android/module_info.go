@@ -0,0 +1,170 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/google/blueprint"
+)
+
+// This singleton writes $OUT_DIR/soong/module-info.json in the format of the module-info.json that
+// Make writes to the product out directory, so that test infrastructure like atest can find the
+// Soong modules, including those that are not exported to Make, without running Kati.  It is
+// built by the soong_module_info phony target.
+
+func init() {
+	RegisterSingletonType("module_info", moduleInfoSingletonFactory)
+}
+
+const moduleInfoJsonFileName = "module-info.json"
+
+// ModuleInfoJSON is the entry of a module in module-info.json, merged from all of its variants.
+type ModuleInfoJSON struct {
+	// The LOCAL_MODULE_CLASS of the module in Make, e.g. NATIVE_TESTS.
+	Class []string `json:"class"`
+	// The directory of the module, relative to the top of the source tree.
+	Path                []string `json:"path"`
+	Installed           []string `json:"installed"`
+	ModuleName          string   `json:"module_name"`
+	TestConfig          []string `json:"test_config"`
+	CompatibilitySuites []string `json:"compatibility_suites"`
+	Dependencies        []string `json:"dependencies"`
+
+	// The runtime options of the test, which only Soong writes.
+	IsUnitTest      string   `json:"is_unit_test,omitempty"`
+	TestOptionsTags []string `json:"test_options_tags,omitempty"`
+	TestTimeout     string   `json:"test_timeout,omitempty"`
+	TestShards      string   `json:"test_shards,omitempty"`
+}
+
+// MakeClassProvider is set by modules to the class they have in Make, e.g. EXECUTABLES, which is
+// listed in module-info.json.
+var MakeClassProvider = NewProvider("MakeClass", "")
+
+// TestInfo is the data of a test module for module-info.json.
+type TestInfo struct {
+	// The tradefed config of the test, generated or handwritten, if it has one.
+	Config Path
+	// The compatibility suites the test is in.
+	Suites []string
+
+	// The runtime options of the test, from the test_options property of the module types that
+	// have it.
+	Timeout  string
+	UnitTest bool
+	Tags     []string
+	Shards   int64
+}
+
+// TestInfoProvider is set by test modules to their TestInfo.
+var TestInfoProvider = NewProvider("TestInfo", TestInfo{})
+
+func moduleInfoSingletonFactory() Singleton {
+	return &moduleInfoSingleton{}
+}
+
+type moduleInfoSingleton struct{}
+
+func (s *moduleInfoSingleton) GenerateBuildActions(ctx SingletonContext) {
+	infos := make(map[string]*ModuleInfoJSON)
+	ctx.VisitAllModules(func(module Module) {
+		if !module.Enabled() {
+			return
+		}
+
+		name := ctx.ModuleName(module)
+		info := infos[name]
+		if info == nil {
+			info = &ModuleInfoJSON{ModuleName: name}
+			infos[name] = info
+		}
+
+		if class := moduleInfoClass(ctx, module); class != "" {
+			info.Class = append(info.Class, class)
+		}
+		info.Path = append(info.Path, ctx.ModuleDir(module))
+		info.Installed = append(info.Installed, module.base().filesToInstall().Strings()...)
+		for _, dep := range module.base().directDeps {
+			info.Dependencies = append(info.Dependencies, ctx.ModuleName(dep.module))
+		}
+		if ctx.ModuleHasProvider(module, TestInfoProvider) {
+			test := ctx.ModuleProvider(module, TestInfoProvider).(TestInfo)
+			if test.Config != nil {
+				info.TestConfig = append(info.TestConfig, test.Config.String())
+			}
+			info.CompatibilitySuites = append(info.CompatibilitySuites, test.Suites...)
+			if test.UnitTest {
+				info.IsUnitTest = "true"
+			}
+			info.TestOptionsTags = append(info.TestOptionsTags, test.Tags...)
+			if test.Timeout != "" {
+				info.TestTimeout = test.Timeout
+			}
+			if test.Shards > 0 {
+				info.TestShards = strconv.FormatInt(test.Shards, 10)
+			}
+		}
+	})
+
+	for _, info := range infos {
+		info.Class = moduleInfoList(info.Class)
+		info.Path = moduleInfoList(info.Path)
+		info.Installed = moduleInfoList(info.Installed)
+		info.TestConfig = moduleInfoList(info.TestConfig)
+		info.CompatibilitySuites = moduleInfoList(info.CompatibilitySuites)
+		info.Dependencies = moduleInfoList(info.Dependencies)
+		sort.Strings(info.Dependencies)
+		info.TestOptionsTags = FirstUniqueStrings(info.TestOptionsTags)
+	}
+
+	buf, err := json.MarshalIndent(infos, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal module info: %s", err)
+		return
+	}
+
+	file := PathForOutput(ctx, moduleInfoJsonFileName)
+	WriteFileRule(ctx, file, string(buf))
+
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_module_info"),
+		Input:  file,
+	})
+}
+
+// moduleInfoClass returns the class a module has in Make, or an empty string if it has none.  The
+// class is known for the modules that are not hidden from Make, whether or not their namespace is
+// exported to Make.
+func moduleInfoClass(ctx SingletonContext, module Module) string {
+	base := module.base()
+	if base.IsSkipInstall() || base.IsHideFromMake() || module.Os() == LinuxBionic ||
+		!ctx.ModuleHasProvider(module, MakeClassProvider) {
+		return ""
+	}
+	return ctx.ModuleProvider(module, MakeClassProvider).(string)
+}
+
+// moduleInfoList removes the duplicates from a list, and returns an empty list instead of nil so
+// that it is written as [] like in the module-info.json written by Make.
+func moduleInfoList(list []string) []string {
+	if len(list) == 0 {
+		return []string{}
+	}
+	return FirstUniqueStrings(list)
+}
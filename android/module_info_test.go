@@ -0,0 +1,167 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type moduleInfoTestModule struct {
+	ModuleBase
+	properties struct {
+		Deps         []string
+		Test_config  *string
+		Test_suites  []string
+		Test_options struct {
+			Timeout   *string
+			Unit_test *bool
+			Tags      []string
+			Shards    *int64
+		}
+	}
+}
+
+func moduleInfoTestModuleFactory() Module {
+	m := &moduleInfoTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, HostAndDeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *moduleInfoTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+}
+
+func (m *moduleInfoTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: out,
+	})
+	ctx.InstallFile(PathForModuleInstall(ctx, "bin"), ctx.ModuleName(), out)
+
+	if m.properties.Test_config != nil {
+		options := m.properties.Test_options
+		info := TestInfo{
+			Config:   PathForModuleSrc(ctx, *m.properties.Test_config),
+			Suites:   m.properties.Test_suites,
+			Timeout:  String(options.Timeout),
+			UnitTest: Bool(options.Unit_test),
+			Tags:     options.Tags,
+		}
+		if options.Shards != nil {
+			info.Shards = *options.Shards
+		}
+		ctx.SetProvider(TestInfoProvider, info)
+		ctx.SetProvider(MakeClassProvider, "NATIVE_TESTS")
+	} else {
+		ctx.SetProvider(MakeClassProvider, "EXECUTABLES")
+	}
+}
+
+func TestModuleInfo(t *testing.T) {
+	config := TestArchConfig(buildDir, nil)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(moduleInfoTestModuleFactory))
+	ctx.RegisterSingletonType("module_info", SingletonFactoryAdaptor(moduleInfoSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"foo/Android.bp": []byte(`
+			test {
+				name: "foo_test",
+				host_supported: true,
+				deps: ["bar"],
+				test_config: "AndroidTest.xml",
+				test_suites: ["device-tests"],
+				test_options: {
+					timeout: "5m",
+					unit_test: true,
+					tags: ["smoke"],
+					shards: 2,
+				},
+			}
+
+			test {
+				name: "disabled",
+				enabled: false,
+			}
+		`),
+		"foo/AndroidTest.xml": nil,
+		"bar/Android.bp": []byte(`
+			test {
+				name: "bar",
+				host_supported: true,
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"foo/Android.bp", "bar/Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	content := ContentFromFileRuleForTests(t, ctx.SingletonForTests("module_info").Output("module-info.json"))
+	var infos map[string]ModuleInfoJSON
+	if err := json.Unmarshal([]byte(content), &infos); err != nil {
+		t.Fatal(err)
+	}
+
+	// The order of the installed files depends on the order of the variants.
+	for _, info := range infos {
+		sort.Strings(info.Installed)
+	}
+	installed := func(name string, variants ...string) []string {
+		var ret []string
+		for _, variant := range variants {
+			ret = append(ret, ctx.ModuleForTests(name, variant).Module().base().filesToInstall().Strings()...)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	hostVariant := BuildOs.String() + "_x86_64"
+	want := map[string]ModuleInfoJSON{
+		"bar": {
+			Class:               []string{"EXECUTABLES"},
+			Path:                []string{"bar"},
+			Installed:           installed("bar", "android_arm64_armv8-a", hostVariant),
+			ModuleName:          "bar",
+			TestConfig:          []string{},
+			CompatibilitySuites: []string{},
+			Dependencies:        []string{},
+		},
+		"foo_test": {
+			Class:               []string{"NATIVE_TESTS"},
+			Path:                []string{"foo"},
+			Installed:           installed("foo_test", "android_arm64_armv8-a", hostVariant),
+			ModuleName:          "foo_test",
+			TestConfig:          []string{"foo/AndroidTest.xml"},
+			CompatibilitySuites: []string{"device-tests"},
+			Dependencies:        []string{"bar"},
+			IsUnitTest:          "true",
+			TestOptionsTags:     []string{"smoke"},
+			TestTimeout:         "5m",
+			TestShards:          "2",
+		},
+	}
+	if !reflect.DeepEqual(infos, want) {
+		t.Errorf("want %#v, got %#v", want, infos)
+	}
+}
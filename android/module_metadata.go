@@ -0,0 +1,135 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/google/blueprint"
+)
+
+// This singleton writes the metadata of every module variant that was collected during analysis
+// to $OUT_DIR/soong/module_metadata.json, where it can be queried by soong_query without
+// regenerating or grepping the ninja files.  It is built by the soong_module_metadata phony target.
+// Collecting the metadata has a cost for every module, so it is only done when
+// SOONG_COLLECT_MODULE_METADATA is set to true.
+
+func init() {
+	RegisterSingletonType("module_metadata", moduleMetadataSingletonFactory)
+}
+
+const (
+	envVariableCollectModuleMetadata = "SOONG_COLLECT_MODULE_METADATA"
+	moduleMetadataJsonFileName       = "module_metadata.json"
+)
+
+// ModuleMetadata is the metadata of a module variant written to module_metadata.json.
+type ModuleMetadata struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Variant string `json:"variant,omitempty"`
+	Dir     string `json:"dir"`
+
+	// The paths the module installs to.  Paths in the product out directory are relative to it and
+	// start with a '/', e.g. /system/priv-app/Foo/Foo.apk, other paths are relative to the top of the
+	// source tree.
+	Installed []string `json:"installed,omitempty"`
+
+	// The names of the direct dependencies of the module.
+	Deps []string `json:"deps,omitempty"`
+
+	// The certificate an app is signed with, or PRESIGNED.
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// ModuleMetadataProvider is implemented by modules that have metadata that is not common to all
+// modules.
+type ModuleMetadataProvider interface {
+	ModuleMetadata(metadata *ModuleMetadata)
+}
+
+func collectModuleMetadata(config Config) bool {
+	return config.IsEnvTrue(envVariableCollectModuleMetadata)
+}
+
+func moduleMetadataSingletonFactory() Singleton {
+	return &moduleMetadataSingleton{}
+}
+
+type moduleMetadataSingleton struct{}
+
+func (s *moduleMetadataSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if !collectModuleMetadata(ctx.Config()) {
+		return
+	}
+
+	productOut := PathForOutput(ctx, "target", "product", ctx.Config().DeviceName()).String()
+
+	var metadata []ModuleMetadata
+	ctx.VisitAllModules(func(module Module) {
+		if !module.Enabled() {
+			return
+		}
+
+		m := ModuleMetadata{
+			Name:    ctx.ModuleName(module),
+			Type:    ctx.ModuleType(module),
+			Variant: ctx.ModuleSubDir(module),
+			Dir:     ctx.ModuleDir(module),
+		}
+		for _, dep := range module.base().directDeps {
+			m.Deps = append(m.Deps, ctx.ModuleName(dep.module))
+		}
+		m.Deps = FirstUniqueStrings(m.Deps)
+		for _, installed := range module.base().filesToInstall() {
+			m.Installed = append(m.Installed, metadataInstallPath(ctx, productOut, installed.String()))
+		}
+		if provider, ok := module.(ModuleMetadataProvider); ok {
+			provider.ModuleMetadata(&m)
+		}
+		metadata = append(metadata, m)
+	})
+
+	sort.SliceStable(metadata, func(i, j int) bool {
+		if metadata[i].Name != metadata[j].Name {
+			return metadata[i].Name < metadata[j].Name
+		}
+		return metadata[i].Variant < metadata[j].Variant
+	})
+
+	buf, err := json.MarshalIndent(metadata, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal module metadata: %s", err)
+		return
+	}
+
+	file := PathForOutput(ctx, moduleMetadataJsonFileName)
+	WriteFileRule(ctx, file, string(buf))
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_module_metadata"),
+		Input:  file,
+	})
+}
+
+// metadataInstallPath returns an install path relative to the product out directory with a leading
+// '/' if it is inside it, or unchanged otherwise.
+func metadataInstallPath(ctx PathContext, productOut, installed string) string {
+	if rel, isRel := MaybeRel(ctx, productOut, installed); isRel {
+		return "/" + rel
+	}
+	return installed
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type metadataTestModule struct {
+	ModuleBase
+	properties struct {
+		Deps []string
+	}
+}
+
+func metadataTestModuleFactory() Module {
+	m := &metadataTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, DeviceSupported, MultilibFirst)
+	return m
+}
+
+func (m *metadataTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, m.properties.Deps...)
+}
+
+func (m *metadataTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, ctx.ModuleName())
+	ctx.Build(pctx, BuildParams{
+		Rule:   Touch,
+		Output: out,
+	})
+	ctx.InstallFile(PathForModuleInstall(ctx, "bin"), ctx.ModuleName(), out)
+}
+
+func TestModuleMetadata(t *testing.T) {
+	config := TestArchConfig(buildDir, map[string]string{"SOONG_COLLECT_MODULE_METADATA": "true"})
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(metadataTestModuleFactory))
+	ctx.RegisterSingletonType("module_metadata", SingletonFactoryAdaptor(moduleMetadataSingletonFactory))
+	ctx.Register()
+
+	bp := `
+		test {
+			name: "foo",
+			deps: ["bar"],
+		}
+
+		test {
+			name: "bar",
+		}
+	`
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	content := ContentFromFileRuleForTests(t, ctx.SingletonForTests("module_metadata").Output("module_metadata.json"))
+	var metadata []ModuleMetadata
+	if err := json.Unmarshal([]byte(content), &metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ModuleMetadata{
+		{
+			Name:      "bar",
+			Type:      "test",
+			Variant:   "android_arm64_armv8-a",
+			Dir:       ".",
+			Installed: []string{"/system/bin/bar"},
+		},
+		{
+			Name:      "foo",
+			Type:      "test",
+			Variant:   "android_arm64_armv8-a",
+			Dir:       ".",
+			Installed: []string{"/system/bin/foo"},
+			Deps:      []string{"bar"},
+		},
+	}
+	if !reflect.DeepEqual(metadata, want) {
+		t.Errorf("want %#v, got %#v", want, metadata)
+	}
+}
@@ -14,7 +14,13 @@
 
 package android
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
 
 func TestSrcIsModule(t *testing.T) {
 	type args struct {
@@ -139,3 +145,100 @@ func TestSrcIsModuleWithTag(t *testing.T) {
 		})
 	}
 }
+
+type walkDepsTestModule struct {
+	ModuleBase
+	props struct {
+		Deps          []string
+		Excluded_deps []string
+	}
+
+	visits []string
+}
+
+var walkDepsTag = dependencyTag{name: "walk"}
+var excludedWalkDepsTag = dependencyTag{name: "excluded"}
+
+func walkDepsTestModuleFactory() Module {
+	m := &walkDepsTestModule{}
+	m.AddProperties(&m.props)
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *walkDepsTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), walkDepsTag, m.props.Deps...)
+	ctx.AddDependency(ctx.Module(), excludedWalkDepsTag, m.props.Excluded_deps...)
+}
+
+func (m *walkDepsTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	ctx.WalkDepsWithTags(func(child, parent Module, tag blueprint.DependencyTag, firstVisit bool) bool {
+		if tag == excludedWalkDepsTag {
+			return false
+		}
+		m.visits = append(m.visits, fmt.Sprintf("%s->%s first=%t", ctx.OtherModuleName(parent),
+			ctx.OtherModuleName(child), firstVisit))
+		return firstVisit
+	})
+}
+
+func TestWalkDepsWithTags(t *testing.T) {
+	bp := `
+		test {
+			name: "a",
+			deps: ["b", "c"],
+			excluded_deps: ["x"],
+		}
+
+		test {
+			name: "b",
+			deps: ["d"],
+		}
+
+		test {
+			name: "c",
+			deps: ["d"],
+		}
+
+		test {
+			name: "d",
+			deps: ["e"],
+		}
+
+		test {
+			name: "e",
+		}
+
+		test {
+			name: "x",
+			deps: ["e"],
+		}
+	`
+
+	config := TestConfig(buildDir, nil)
+
+	ctx := NewTestContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(walkDepsTestModuleFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	a := ctx.ModuleForTests("a", "").Module().(*walkDepsTestModule)
+	want := []string{
+		"a->b first=true",
+		"b->d first=true",
+		"d->e first=true",
+		"a->c first=true",
+		"c->d first=false",
+	}
+	if !reflect.DeepEqual(a.visits, want) {
+		t.Errorf("want visits %q, got %q", want, a.visits)
+	}
+}
@@ -15,6 +15,8 @@
 package android
 
 import (
+	"fmt"
+
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 )
@@ -25,6 +27,7 @@ import (
 //   run Pre-deps mutators
 //   run depsMutator
 //   run PostDeps mutators
+//   run FinalDeps mutators (CreateVariations but no AddDependency)
 //   continue on to GenerateAndroidBuildActions
 
 func registerMutatorsToContext(ctx *blueprint.Context, mutators []*mutator) {
@@ -41,7 +44,7 @@ func registerMutatorsToContext(ctx *blueprint.Context, mutators []*mutator) {
 	}
 }
 
-func registerMutators(ctx *blueprint.Context, preArch, preDeps, postDeps []RegisterMutatorFunc) {
+func registerMutators(ctx *blueprint.Context, preArch, preDeps, postDeps, finalDeps []RegisterMutatorFunc) {
 	mctx := &registerMutatorsContext{}
 
 	register := func(funcs []RegisterMutatorFunc) {
@@ -58,11 +61,15 @@ func registerMutators(ctx *blueprint.Context, preArch, preDeps, postDeps []Regis
 
 	register(postDeps)
 
+	mctx.finalPhase = true
+	register(finalDeps)
+
 	registerMutatorsToContext(ctx, mctx.mutators)
 }
 
 type registerMutatorsContext struct {
-	mutators []*mutator
+	mutators   []*mutator
+	finalPhase bool
 }
 
 type RegisterMutatorsContext interface {
@@ -79,6 +86,8 @@ var preArch = []RegisterMutatorFunc{
 	registerVisibilityRuleChecker,
 	RegisterDefaultsPreArchMutators,
 	registerVisibilityRuleGatherer,
+	registerPropertyRuleEnforcer,
+	registerSoongConfigEnabledMutator,
 }
 
 func registerArchMutator(ctx RegisterMutatorsContext) {
@@ -98,26 +107,44 @@ var postDeps = []RegisterMutatorFunc{
 	RegisterOverridePostDepsMutators,
 }
 
+var finalDeps = []RegisterMutatorFunc{}
+
 func PreArchMutators(f RegisterMutatorFunc) {
+	checkGlobalRegistration("pre-arch mutators")
 	preArch = append(preArch, f)
 }
 
 func PreDepsMutators(f RegisterMutatorFunc) {
+	checkGlobalRegistration("pre-deps mutators")
 	preDeps = append(preDeps, f)
 }
 
 func PostDepsMutators(f RegisterMutatorFunc) {
+	checkGlobalRegistration("post-deps mutators")
 	postDeps = append(postDeps, f)
 }
 
+// FinalDepsMutators registers mutators that run after all the post-deps mutators, when the dependencies
+// between modules are final, so that they can observe the complete dependency graph, e.g. to collect the
+// modules that end up in an apex.  They can create variations but can't add or replace dependencies.
+func FinalDepsMutators(f RegisterMutatorFunc) {
+	checkGlobalRegistration("final-deps mutators")
+	finalDeps = append(finalDeps, f)
+}
+
 type TopDownMutator func(TopDownMutatorContext)
 
 type TopDownMutatorContext interface {
 	BaseModuleContext
 
+	AppendProperties(...interface{})
+	PrependProperties(...interface{})
+
 	Rename(name string)
 
 	CreateModule(blueprint.ModuleFactory, ...interface{})
+
+	Namespace() blueprint.Namespace
 }
 
 type topDownMutatorContext struct {
@@ -141,19 +168,33 @@ type BottomUpMutatorContext interface {
 	AddFarVariationDependencies([]blueprint.Variation, blueprint.DependencyTag, ...string)
 	AddInterVariantDependency(tag blueprint.DependencyTag, from, to blueprint.Module)
 	ReplaceDependencies(string)
+
+	// AliasVariation makes the variation of the module named variationName the one that dependencies
+	// that don't specify a variation for the current mutator resolve to, so that a module type can
+	// split into new variations without breaking the modules that depend on it with
+	// AddVariationDependencies.  It must be called after CreateVariations.
+	AliasVariation(variationName string)
+
+	// CreateAliasVariation makes dependencies on the variation aliasName of the current mutator
+	// resolve to the variation toName, so that a variation that has been split into finer grained
+	// variations can still be depended on by its old name, e.g. "android_common".
+	CreateAliasVariation(aliasName, toName string)
 }
 
 type bottomUpMutatorContext struct {
 	bp blueprint.BottomUpMutatorContext
 	baseModuleContext
+	finalPhase bool
 }
 
 func (x *registerMutatorsContext) BottomUp(name string, m BottomUpMutator) MutatorHandle {
+	finalPhase := x.finalPhase
 	f := func(ctx blueprint.BottomUpMutatorContext) {
 		if a, ok := ctx.Module().(Module); ok {
 			actx := &bottomUpMutatorContext{
 				bp:                ctx,
 				baseModuleContext: a.base().baseModuleContextFactory(ctx),
+				finalPhase:        finalPhase,
 			}
 			m(actx)
 		}
@@ -239,11 +280,20 @@ func (b *bottomUpMutatorContext) Rename(name string) {
 	b.bp.Rename(name)
 }
 
+// checkNotFinalPhase panics if a mutator in the final deps phase tries to change the dependencies.
+func (b *bottomUpMutatorContext) checkNotFinalPhase(method string) {
+	if b.finalPhase {
+		panic(fmt.Errorf("%s is not allowed in the final deps phase", method))
+	}
+}
+
 func (b *bottomUpMutatorContext) AddDependency(module blueprint.Module, tag blueprint.DependencyTag, name ...string) {
+	b.checkNotFinalPhase("AddDependency")
 	b.bp.AddDependency(module, tag, name...)
 }
 
 func (b *bottomUpMutatorContext) AddReverseDependency(module blueprint.Module, tag blueprint.DependencyTag, name string) {
+	b.checkNotFinalPhase("AddReverseDependency")
 	b.bp.AddReverseDependency(module, tag, name)
 }
 
@@ -262,19 +312,31 @@ func (b *bottomUpMutatorContext) SetDependencyVariation(variation string) {
 func (b *bottomUpMutatorContext) AddVariationDependencies(variations []blueprint.Variation, tag blueprint.DependencyTag,
 	names ...string) {
 
+	b.checkNotFinalPhase("AddVariationDependencies")
 	b.bp.AddVariationDependencies(variations, tag, names...)
 }
 
 func (b *bottomUpMutatorContext) AddFarVariationDependencies(variations []blueprint.Variation,
 	tag blueprint.DependencyTag, names ...string) {
 
+	b.checkNotFinalPhase("AddFarVariationDependencies")
 	b.bp.AddFarVariationDependencies(variations, tag, names...)
 }
 
 func (b *bottomUpMutatorContext) AddInterVariantDependency(tag blueprint.DependencyTag, from, to blueprint.Module) {
+	b.checkNotFinalPhase("AddInterVariantDependency")
 	b.bp.AddInterVariantDependency(tag, from, to)
 }
 
 func (b *bottomUpMutatorContext) ReplaceDependencies(name string) {
+	b.checkNotFinalPhase("ReplaceDependencies")
 	b.bp.ReplaceDependencies(name)
 }
+
+func (b *bottomUpMutatorContext) AliasVariation(variationName string) {
+	b.bp.AliasVariation(variationName)
+}
+
+func (b *bottomUpMutatorContext) CreateAliasVariation(aliasName, toName string) {
+	b.bp.CreateAliasVariation(aliasName, toName)
+}
@@ -20,6 +20,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 )
 
@@ -97,3 +98,128 @@ func TestMutatorAddMissingDependencies(t *testing.T) {
 		t.Errorf("want foo missing deps %q, got %q", w, g)
 	}
 }
+
+func TestFinalDepsPhase(t *testing.T) {
+	bp := `
+		mock_library {
+			name: "foo",
+		}
+
+		mock_library {
+			name: "bar",
+		}
+	`
+
+	testFinalDeps := func(postDeps, finalDeps RegisterMutatorFunc) []error {
+		config := TestConfig(buildDir, nil)
+
+		ctx := NewTestContext()
+		ctx.RegisterModuleType("mock_library", ModuleFactoryAdaptor(newMockLibraryModule))
+		ctx.PostDepsMutators(postDeps)
+		ctx.FinalDepsMutators(finalDeps)
+		ctx.Register()
+
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+		if len(errs) > 0 {
+			return errs
+		}
+		_, errs = ctx.PrepareBuildActions(config)
+		return errs
+	}
+
+	addDep := func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "foo" {
+			ctx.AddDependency(ctx.Module(), nil, "bar")
+		}
+	}
+
+	var finalDeps []string
+	errs := testFinalDeps(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("add_dep", addDep)
+	}, func(ctx RegisterMutatorsContext) {
+		ctx.TopDown("observe_deps", func(ctx TopDownMutatorContext) {
+			if ctx.ModuleName() == "foo" {
+				ctx.VisitDirectDeps(func(dep Module) {
+					finalDeps = append(finalDeps, ctx.OtherModuleName(dep))
+				})
+			}
+		})
+	})
+	FailIfErrored(t, errs)
+	if w := []string{"bar"}; !reflect.DeepEqual(finalDeps, w) {
+		t.Errorf("want final deps of foo %q, got %q", w, finalDeps)
+	}
+
+	errs = testFinalDeps(func(RegisterMutatorsContext) {}, func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("add_dep", addDep)
+	})
+	FailIfNoMatchingErrors(t, "AddDependency is not allowed in the final deps phase", errs)
+}
+
+func TestAliasVariations(t *testing.T) {
+	bp := `
+		mock_library {
+			name: "foo",
+		}
+
+		mock_library {
+			name: "bar",
+			deps: ["foo"],
+		}
+
+		mock_library {
+			name: "baz",
+		}
+	`
+
+	config := TestConfig(buildDir, nil)
+
+	ctx := NewTestContext()
+	ctx.RegisterModuleType("mock_library", ModuleFactoryAdaptor(newMockLibraryModule))
+	ctx.PreDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("split", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "foo" {
+				ctx.CreateVariations("a", "b")
+				ctx.AliasVariation("a")
+				ctx.CreateAliasVariation("common", "b")
+			}
+		})
+	})
+	ctx.PostDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("common_dep", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "baz" {
+				ctx.AddVariationDependencies([]blueprint.Variation{{Mutator: "split", Variation: "common"}},
+					dependencyTag{name: "common"}, "foo")
+			}
+		})
+	})
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	deps := func(name string) []blueprint.Module {
+		var ret []blueprint.Module
+		ctx.VisitDirectDeps(ctx.ModuleForTests(name, "").Module(), func(dep blueprint.Module) {
+			ret = append(ret, dep)
+		})
+		return ret
+	}
+
+	if g, w := deps("bar"), []blueprint.Module{ctx.ModuleForTests("foo", "a").Module()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected bar to depend on the aliased variant of foo")
+	}
+	if g, w := deps("baz"), []blueprint.Module{ctx.ModuleForTests("foo", "b").Module()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected baz to depend on the variant of foo aliased as common")
+	}
+}
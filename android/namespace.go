@@ -103,6 +103,7 @@ func (r *NameResolver) newNamespace(path string) *Namespace {
 	namespace := NewNamespace(path)
 
 	namespace.exportToKati = r.namespaceExportFilter(namespace)
+	namespace.resolver = r
 
 	return namespace
 }
@@ -346,6 +347,9 @@ type Namespace struct {
 	exportToKati bool
 
 	moduleContainer blueprint.NameInterface
+
+	// the resolver that created this namespace, used to look up other namespaces by directory
+	resolver *NameResolver
 }
 
 func NewNamespace(path string) *Namespace {
@@ -33,6 +33,10 @@ import (
 // A module is disallowed if all of the following are true:
 // - it is in one of the "in" paths
 // - it is not in one of the "notIn" paths
+// - it is of one of the "moduleType" types and none of the "notModuleType" types
+// - the variant is a device variant for "onlyDevice" rules, or a host variant
+//   for "onlyHost" rules
+// - the variant is of one of the "arch" arches and none of the "notArch" arches
 // - it has all "with" properties matched
 // - - values are matched in their entirety
 // - - nil is interpreted as an empty string
@@ -40,6 +44,12 @@ import (
 // - - if the property is a list, any of the values in the list being matches
 //     counts as a match
 // - it has none of the "without" properties matched (same rules as above)
+// - it directly depends on one of the "dependsOn" modules, if any are listed
+// - it directly depends on a module of one of the "dependsOnModuleType" types,
+//   if any are listed
+//
+// The dependency constraints are checked after the other constraints, so that the
+// paths, module types and properties of a rule act as exemptions to them.
 
 func registerNeverallowMutator(ctx RegisterMutatorsContext) {
 	ctx.BottomUp("neverallow", neverallowMutator).Parallel()
@@ -52,6 +62,8 @@ func createNeverAllows() []*rule {
 	rules = append(rules, createTrebleRules()...)
 	rules = append(rules, createLibcoreRules()...)
 	rules = append(rules, createJavaDeviceForHostRules()...)
+	rules = append(rules, createPresignedAppRules()...)
+	rules = append(rules, createArtRules()...)
 	return rules
 }
 
@@ -126,6 +138,39 @@ func createJavaDeviceForHostRules() []*rule {
 	}
 }
 
+func createPresignedAppRules() []*rule {
+	// Projects whose apps are built from source but signed by an external signing step after the build.
+	presignedAppProjectsWhitelist := []string{
+		"vendor",
+	}
+
+	return []*rule{
+		neverallow().
+			notIn(presignedAppProjectsWhitelist...).
+			moduleType("android_app", "android_test", "android_test_helper_app", "override_android_app").
+			with("certificate", "PRESIGNED").
+			because("apps built from source can only be PRESIGNED in whitelisted projects, " +
+				"use android_app_import for presigned prebuilt apks"),
+	}
+}
+
+func createArtRules() []*rule {
+	// Projects outside of ART that already depend on the debug runtime libraries.  No new projects should be added,
+	// the existing ones are to be moved to the release libraries or into ART.
+	artDebugLibrariesProjectsWhitelist := []string{
+		"art",
+		"external/oj-libjdwp",
+		"libcore",
+	}
+
+	return []*rule{
+		neverallow().
+			notIn(artDebugLibrariesProjectsWhitelist...).
+			dependsOn("libartd", "libartd-compiler").
+			because("the debug runtime libraries are only used by ART's own debug builds and tests"),
+	}
+}
+
 func neverallowMutator(ctx BottomUpMutatorContext) {
 	m, ok := ctx.Module().(Module)
 	if !ok {
@@ -144,10 +189,21 @@ func neverallowMutator(ctx BottomUpMutatorContext) {
 			continue
 		}
 
+		if !n.appliesToVariant(ctx.Os().Class, ctx.Arch().ArchType) {
+			continue
+		}
+
 		if !n.appliesToProperties(properties) {
 			continue
 		}
 
+		if n.hasDependencyConstraints() {
+			if dep := n.violatingDependency(ctx); dep != "" {
+				ctx.ModuleErrorf("violates %s: depends on %q", n.String(), dep)
+			}
+			continue
+		}
+
 		ctx.ModuleErrorf("violates " + n.String())
 	}
 }
@@ -167,8 +223,15 @@ type rule struct {
 	moduleTypes       []string
 	unlessModuleTypes []string
 
+	osClasses    []OsClass
+	arches       []ArchType
+	unlessArches []ArchType
+
 	props       []ruleProperty
 	unlessProps []ruleProperty
+
+	depNames       []string
+	depModuleTypes []string
 }
 
 func neverallow() *rule {
@@ -195,6 +258,28 @@ func (r *rule) notModuleType(types ...string) *rule {
 	return r
 }
 
+// onlyDevice restricts the rule to the device variants of modules.
+func (r *rule) onlyDevice() *rule {
+	r.osClasses = append(r.osClasses, Device)
+	return r
+}
+
+// onlyHost restricts the rule to the host variants of modules, including the host cross variants.
+func (r *rule) onlyHost() *rule {
+	r.osClasses = append(r.osClasses, Host, HostCross)
+	return r
+}
+
+func (r *rule) arch(arches ...ArchType) *rule {
+	r.arches = append(r.arches, arches...)
+	return r
+}
+
+func (r *rule) notArch(arches ...ArchType) *rule {
+	r.unlessArches = append(r.unlessArches, arches...)
+	return r
+}
+
 func (r *rule) with(properties, value string) *rule {
 	r.props = append(r.props, ruleProperty{
 		fields: fieldNamesForProperties(properties),
@@ -211,6 +296,19 @@ func (r *rule) without(properties, value string) *rule {
 	return r
 }
 
+// dependsOn restricts the rule to modules that directly depend on one of the named modules.
+func (r *rule) dependsOn(names ...string) *rule {
+	r.depNames = append(r.depNames, names...)
+	return r
+}
+
+// dependsOnModuleType restricts the rule to modules that directly depend on a module of one of
+// the module types.
+func (r *rule) dependsOnModuleType(types ...string) *rule {
+	r.depModuleTypes = append(r.depModuleTypes, types...)
+	return r
+}
+
 func (r *rule) because(reason string) *rule {
 	r.reason = reason
 	return r
@@ -230,12 +328,27 @@ func (r *rule) String() string {
 	for _, v := range r.unlessModuleTypes {
 		s += " -type:" + v
 	}
+	for _, v := range r.osClasses {
+		s += " class:" + strings.Replace(v.String(), " ", "_", -1)
+	}
+	for _, v := range r.arches {
+		s += " arch:" + v.String()
+	}
+	for _, v := range r.unlessArches {
+		s += " -arch:" + v.String()
+	}
 	for _, v := range r.props {
 		s += " " + strings.Join(v.fields, ".") + "=" + v.value
 	}
 	for _, v := range r.unlessProps {
 		s += " -" + strings.Join(v.fields, ".") + "=" + v.value
 	}
+	for _, v := range r.depNames {
+		s += " dep:" + v
+	}
+	for _, v := range r.depModuleTypes {
+		s += " dep_type:" + v
+	}
 	if len(r.reason) != 0 {
 		s += " which is restricted because " + r.reason
 	}
@@ -252,12 +365,41 @@ func (r *rule) appliesToModuleType(moduleType string) bool {
 	return (len(r.moduleTypes) == 0 || InList(moduleType, r.moduleTypes)) && !InList(moduleType, r.unlessModuleTypes)
 }
 
+func (r *rule) appliesToVariant(class OsClass, arch ArchType) bool {
+	includeClass := len(r.osClasses) == 0 || osClassInList(class, r.osClasses)
+	includeArch := len(r.arches) == 0 || archTypeInList(arch, r.arches)
+	excludeArch := archTypeInList(arch, r.unlessArches)
+	return includeClass && includeArch && !excludeArch
+}
+
 func (r *rule) appliesToProperties(properties []interface{}) bool {
 	includeProps := hasAllProperties(properties, r.props)
 	excludeProps := hasAnyProperty(properties, r.unlessProps)
 	return includeProps && !excludeProps
 }
 
+func (r *rule) hasDependencyConstraints() bool {
+	return len(r.depNames) > 0 || len(r.depModuleTypes) > 0
+}
+
+// violatingDependency returns the name of the first direct dependency of the module that matches
+// the dependency constraints of the rule, or an empty string if there is none.  Prebuilts match
+// the name of the module they replace.
+func (r *rule) violatingDependency(ctx BottomUpMutatorContext) string {
+	dep := ""
+	ctx.VisitDirectDeps(func(m Module) {
+		if dep != "" {
+			return
+		}
+		name := ctx.OtherModuleName(m)
+		if InList(strings.TrimPrefix(name, "prebuilt_"), r.depNames) ||
+			InList(ctx.OtherModuleType(m), r.depModuleTypes) {
+			dep = name
+		}
+	})
+	return dep
+}
+
 // assorted utils
 
 func cleanPaths(paths []string) []string {
@@ -276,6 +418,24 @@ func fieldNamesForProperties(propertyNames string) []string {
 	return names
 }
 
+func osClassInList(class OsClass, list []OsClass) bool {
+	for _, v := range list {
+		if v == class {
+			return true
+		}
+	}
+	return false
+}
+
+func archTypeInList(arch ArchType, list []ArchType) bool {
+	for _, v := range list {
+		if v == arch {
+			return true
+		}
+	}
+	return false
+}
+
 func hasAnyPrefix(s string, prefixes []string) bool {
 	for _, prefix := range prefixes {
 		if strings.HasPrefix(s, prefix) {
@@ -20,6 +20,7 @@ import (
 
 var neverallowTests = []struct {
 	name          string
+	rules         []*rule
 	fs            map[string][]byte
 	expectedError string
 }{
@@ -146,6 +147,38 @@ var neverallowTests = []struct {
 		},
 		expectedError: "java_device_for_host can only be used in whitelisted projects",
 	},
+	// Presigned app rule tests
+	{
+		name: "PRESIGNED android_app in vendor",
+		fs: map[string][]byte{
+			"vendor/Blueprints": []byte(`
+				android_app {
+					name: "presigned_app",
+					certificate: "PRESIGNED",
+				}`),
+		},
+	},
+	{
+		name: "PRESIGNED android_app outside vendor",
+		fs: map[string][]byte{
+			"Blueprints": []byte(`
+				android_app {
+					name: "presigned_app",
+					certificate: "PRESIGNED",
+				}`),
+		},
+		expectedError: "apps built from source can only be PRESIGNED in whitelisted projects",
+	},
+	{
+		name: "signed android_app outside vendor",
+		fs: map[string][]byte{
+			"Blueprints": []byte(`
+				android_app {
+					name: "signed_app",
+					certificate: "platform",
+				}`),
+		},
+	},
 	// Libcore rule tests
 	{
 		name: "sdk_version: \"none\" inside core libraries",
@@ -178,13 +211,147 @@ var neverallowTests = []struct {
 				}`),
 		},
 	},
+	// Variant rule tests
+	{
+		name: "device variant of cc_binary using platform headers in vendor",
+		rules: []*rule{
+			neverallow().
+				in("vendor").
+				moduleType("cc_binary").
+				onlyDevice().
+				with("header_libs", "libplatform_headers"),
+		},
+		fs: map[string][]byte{
+			"vendor/Blueprints": []byte(`
+				cc_binary {
+					name: "vendor_tool",
+					host_supported: true,
+					header_libs: ["libplatform_headers"],
+				}`),
+		},
+		expectedError: `module "vendor_tool" variant "android_arm64_armv8-a": violates neverallow dir:vendor/\* type:cc_binary class:device`,
+	},
+	{
+		name: "host variant of cc_binary using platform headers in vendor",
+		rules: []*rule{
+			neverallow().
+				in("vendor").
+				moduleType("cc_binary").
+				onlyDevice().
+				with("header_libs", "libplatform_headers"),
+		},
+		fs: map[string][]byte{
+			"vendor/Blueprints": []byte(`
+				cc_binary {
+					name: "vendor_tool",
+					host_supported: true,
+					device_supported: false,
+					header_libs: ["libplatform_headers"],
+				}`),
+		},
+	},
+	{
+		name: "arch",
+		rules: []*rule{
+			neverallow().
+				moduleType("cc_binary").
+				arch(Arm),
+		},
+		fs: map[string][]byte{
+			"Blueprints": []byte(`
+				cc_binary {
+					name: "tool",
+				}`),
+		},
+		expectedError: `module "tool" variant "android_arm_armv7-a-neon": violates neverallow type:cc_binary arch:arm`,
+	},
+	{
+		name: "notArch",
+		rules: []*rule{
+			neverallow().
+				moduleType("cc_binary").
+				onlyDevice().
+				notArch(Arm, Arm64),
+		},
+		fs: map[string][]byte{
+			"Blueprints": []byte(`
+				cc_binary {
+					name: "tool",
+					host_supported: true,
+				}`),
+		},
+	},
+	// ART rule tests
+	{
+		name: "libartd dependency inside art",
+		fs: map[string][]byte{
+			"art/Blueprints": []byte(`
+				cc_library {
+					name: "libartd",
+				}
+
+				cc_library {
+					name: "libart-debug-tool",
+					shared_libs: ["libartd"],
+				}`),
+		},
+	},
+	{
+		name: "libartd dependency outside art",
+		fs: map[string][]byte{
+			"art/Blueprints": []byte(`
+				cc_library {
+					name: "libartd",
+				}`),
+			"Blueprints": []byte(`
+				cc_library {
+					name: "libfoo",
+					shared_libs: ["libartd"],
+				}`),
+		},
+		expectedError: `module "libfoo": violates neverallow -dir:art/\* -dir:external/oj-libjdwp/\* -dir:libcore/\* ` +
+			`dep:libartd dep:libartd-compiler .*: depends on "libartd"`,
+	},
+	{
+		name: "libartd dependency in whitelisted project",
+		fs: map[string][]byte{
+			"art/Blueprints": []byte(`
+				cc_library {
+					name: "libartd",
+				}`),
+			"libcore/Blueprints": []byte(`
+				cc_library {
+					name: "libcore-debug-test",
+					shared_libs: ["libartd"],
+				}`),
+		},
+	},
+	{
+		name: "libart dependency outside art",
+		fs: map[string][]byte{
+			"art/Blueprints": []byte(`
+				cc_library {
+					name: "libart",
+				}`),
+			"Blueprints": []byte(`
+				cc_library {
+					name: "libfoo",
+					shared_libs: ["libart"],
+				}`),
+		},
+	},
 }
 
 func TestNeverallow(t *testing.T) {
-	config := TestConfig(buildDir, nil)
+	config := TestArchConfig(buildDir, nil)
 
 	for _, test := range neverallowTests {
 		t.Run(test.name, func(t *testing.T) {
+			if test.rules != nil {
+				defer func(rules []*rule) { neverallows = rules }(neverallows)
+				neverallows = test.rules
+			}
+
 			_, errs := testNeverallow(t, config, test.fs)
 
 			if test.expectedError == "" {
@@ -197,11 +364,13 @@ func TestNeverallow(t *testing.T) {
 }
 
 func testNeverallow(t *testing.T, config Config, fs map[string][]byte) (*TestContext, []error) {
-	ctx := NewTestContext()
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("cc_binary", ModuleFactoryAdaptor(newMockCcBinaryModule))
 	ctx.RegisterModuleType("cc_library", ModuleFactoryAdaptor(newMockCcLibraryModule))
 	ctx.RegisterModuleType("java_library", ModuleFactoryAdaptor(newMockJavaLibraryModule))
 	ctx.RegisterModuleType("java_library_host", ModuleFactoryAdaptor(newMockJavaLibraryModule))
 	ctx.RegisterModuleType("java_device_for_host", ModuleFactoryAdaptor(newMockJavaLibraryModule))
+	ctx.RegisterModuleType("android_app", ModuleFactoryAdaptor(newMockAndroidAppModule))
 	ctx.PostDepsMutators(registerNeverallowMutator)
 	ctx.Register()
 
@@ -217,6 +386,7 @@ func testNeverallow(t *testing.T, config Config, fs map[string][]byte) (*TestCon
 }
 
 type mockCcLibraryProperties struct {
+	Shared_libs      []string
 	Vendor_available *bool
 
 	Vndk struct {
@@ -248,9 +418,32 @@ func newMockCcLibraryModule() Module {
 	return m
 }
 
+func (p *mockCcLibraryModule) DepsMutator(ctx BottomUpMutatorContext) {
+	ctx.AddDependency(ctx.Module(), nil, p.properties.Shared_libs...)
+}
+
 func (p *mockCcLibraryModule) GenerateAndroidBuildActions(ModuleContext) {
 }
 
+type mockCcBinaryProperties struct {
+	Header_libs []string
+}
+
+type mockCcBinaryModule struct {
+	ModuleBase
+	properties mockCcBinaryProperties
+}
+
+func newMockCcBinaryModule() Module {
+	m := &mockCcBinaryModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidArchModule(m, HostAndDeviceSupported, MultilibBoth)
+	return m
+}
+
+func (p *mockCcBinaryModule) GenerateAndroidBuildActions(ModuleContext) {
+}
+
 type mockJavaLibraryProperties struct {
 	Libs        []string
 	Sdk_version *string
@@ -270,3 +463,22 @@ func newMockJavaLibraryModule() Module {
 
 func (p *mockJavaLibraryModule) GenerateAndroidBuildActions(ModuleContext) {
 }
+
+type mockAndroidAppProperties struct {
+	Certificate *string
+}
+
+type mockAndroidAppModule struct {
+	ModuleBase
+	properties mockAndroidAppProperties
+}
+
+func newMockAndroidAppModule() Module {
+	m := &mockAndroidAppModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	return m
+}
+
+func (p *mockAndroidAppModule) GenerateAndroidBuildActions(ModuleContext) {
+}
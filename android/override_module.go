@@ -28,6 +28,7 @@ package android
 // module based on it.
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/google/blueprint"
@@ -144,6 +145,11 @@ func (b *OverridableModuleBase) override(ctx BaseModuleContext, o OverrideModule
 	if b.overridesProperty != nil {
 		*b.overridesProperty = append(*b.overridesProperty, ctx.ModuleName())
 	}
+	// Record that the properties set by the override module come from it so that property errors
+	// can name it.
+	for _, name := range setPropertyNames(o.getOverridingProperties()) {
+		ctx.Module().base().setPropertyOrigin(name, fmt.Sprintf("set by override module %q", o.Name()))
+	}
 	for _, p := range b.overridableProperties {
 		for _, op := range o.getOverridingProperties() {
 			if proptools.TypeEqual(p, op) {
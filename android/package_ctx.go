@@ -179,6 +179,23 @@ func (p PackageContext) HostBinToolPath(ctx PackageVarContext, path string) Path
 	return PathForOutput(ctx, "host", ctx.Config().PrebuiltOS(), "bin", path)
 }
 
+// HostNativeBinToolVariable returns a Variable whose value is the path to a host tool in the bin
+// directory for host targets, built for the architecture of the build machine.  It is the
+// linux_bionic arm64 variant of the tool when Config.UseHostBionicArm64Tools returns true, so the
+// tool must be enabled for linux_bionic.  The Go tools built by the bootstrap already run natively
+// and use HostBinToolVariable.  It may only be called during a Go package's
+// initialization - either from the init() function or as part of a package-scoped variable's
+// initialization.
+func (p PackageContext) HostNativeBinToolVariable(name, path string) blueprint.Variable {
+	return p.VariableFunc(name, func(ctx PackageVarContext) string {
+		return p.HostNativeBinToolPath(ctx, path).String()
+	})
+}
+
+func (p PackageContext) HostNativeBinToolPath(ctx PackageVarContext, path string) Path {
+	return ctx.Config().HostNativeToolPath(ctx, path)
+}
+
 // HostJNIToolVariable returns a Variable whose value is the path to a host tool
 // in the lib directory for host targets. It may only be called during a Go
 // package's initialization - either from the init() function or as part of a
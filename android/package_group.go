@@ -0,0 +1,196 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"sync"
+)
+
+func init() {
+	RegisterModuleType("package_group", PackageGroupFactory)
+}
+
+type packageGroupProperties struct {
+	// The packages that are members of the group, in the form of the package rules of the
+	// visibility property, like //some/package, //project:__subpackages__ or
+	// //vendor/foo:__namespace__.
+	Packages []string
+
+	// Other package_group modules whose members are also members of this group, like
+	// //other/package:group or :group for a group in the same package.
+	Includes []string
+}
+
+type packageGroupModule struct {
+	ModuleBase
+	properties packageGroupProperties
+}
+
+// package_group defines a named list of packages that visibility rules can reference as
+// //<package>:<name>, so that the packages that may use a set of modules can be maintained in a
+// single place.
+func PackageGroupFactory() Module {
+	module := &packageGroupModule{}
+	module.AddProperties(&module.properties)
+	InitAndroidModule(module)
+	return module
+}
+
+func (g *packageGroupModule) GenerateAndroidBuildActions(ModuleContext) {
+}
+
+// A packageGroup is a package_group module parsed by the visibility rule gatherer.
+type packageGroup struct {
+	rule     *compiledRule
+	includes []qualifiedModuleName
+}
+
+var packageGroupMapKey = NewOnceKey("packageGroupMap")
+
+// The map from the qualifiedModuleName of a package_group module to its *packageGroup.
+func packageGroupMap(config Config) *sync.Map {
+	return config.Once(packageGroupMapKey, func() interface{} {
+		return &sync.Map{}
+	}).(*sync.Map)
+}
+
+// A groupRule is a visibility rule that matches the modules in the packages of a package_group.
+// The groups are only looked up when the rule is matched, after the visibility rule gatherer has
+// parsed all the package_group modules.
+type groupRule struct {
+	group  qualifiedModuleName
+	groups *sync.Map
+}
+
+func (r groupRule) matches(m qualifiedModuleName) bool {
+	return groupMatches(r.groups, r.group, m, make(map[qualifiedModuleName]bool))
+}
+
+func (r groupRule) String() string {
+	return r.group.String()
+}
+
+// groupMatches returns true if m is in the packages of group or of the groups it includes.  Each
+// group is only visited once, so that a cycle of includes, which is reported by the enforcer of
+// the package_group modules, doesn't recurse forever.
+func groupMatches(groups *sync.Map, group qualifiedModuleName, m qualifiedModuleName,
+	visited map[qualifiedModuleName]bool) bool {
+
+	if visited[group] {
+		return false
+	}
+	visited[group] = true
+
+	g, ok := groups.Load(group)
+	if !ok {
+		return false
+	}
+	if g.(*packageGroup).rule.matches(m) {
+		return true
+	}
+	for _, include := range g.(*packageGroup).includes {
+		if groupMatches(groups, include, m, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// gatherPackageGroup parses the properties of a package_group module and stores it in the
+// package group map.
+func gatherPackageGroup(ctx BottomUpMutatorContext, g *packageGroupModule, qualified qualifiedModuleName) {
+	rules := make(compositeRule, 0, len(g.properties.Packages))
+	for _, v := range g.properties.Packages {
+		ok, pkg, name := splitRule(ctx, v, qualified.pkg)
+		if !ok || pkg == "visibility" {
+			ctx.PropertyErrorf("packages", "invalid package %q must match //<package>, "+
+				"//<package>:__pkg__, //<package>:__subpackages__ or //<package>:__namespace__", v)
+			continue
+		}
+		switch name {
+		case "__pkg__":
+			rules = append(rules, packageRule{pkg})
+		case "__subpackages__":
+			rules = append(rules, subpackagesRule{pkg})
+		case "__namespace__":
+			rules = append(rules, namespaceRule{pkg})
+		default:
+			ctx.PropertyErrorf("packages", "invalid package %q, use includes to add the packages of "+
+				"another package_group", v)
+		}
+	}
+
+	var includes []qualifiedModuleName
+	for _, v := range g.properties.Includes {
+		ok, pkg, name := splitRule(ctx, v, qualified.pkg)
+		if !ok || pkg == "visibility" || isPackageRuleName(name) {
+			ctx.PropertyErrorf("includes", "invalid package_group %q must match //<package>:<name> "+
+				"or :<name>", v)
+			continue
+		}
+		includes = append(includes, qualifiedModuleName{pkg: pkg, name: name})
+	}
+
+	packageGroupMap(ctx.Config()).Store(qualified, &packageGroup{
+		rule:     compiledVisibilityRule(ctx.Config(), rules),
+		includes: includes,
+	})
+}
+
+// checkPackageGroupIncludes reports the includes of a package_group that aren't package_group
+// modules, and includes that make the group include itself.
+func checkPackageGroupIncludes(ctx TopDownMutatorContext, qualified qualifiedModuleName) {
+	groups := packageGroupMap(ctx.Config())
+	g, ok := groups.Load(qualified)
+	if !ok {
+		return
+	}
+
+	for _, include := range g.(*packageGroup).includes {
+		if _, ok := groups.Load(include); !ok {
+			ctx.PropertyErrorf("includes", "%s is not a package_group module", include)
+		} else if includesGroup(groups, include, qualified, make(map[qualifiedModuleName]bool)) {
+			ctx.PropertyErrorf("includes", "%s includes %s", include, qualified)
+		}
+	}
+}
+
+// includesGroup returns true if group includes target directly or through other groups.
+func includesGroup(groups *sync.Map, group, target qualifiedModuleName,
+	visited map[qualifiedModuleName]bool) bool {
+
+	if group == target {
+		return true
+	}
+	if visited[group] {
+		return false
+	}
+	visited[group] = true
+
+	g, ok := groups.Load(group)
+	if !ok {
+		return false
+	}
+	for _, include := range g.(*packageGroup).includes {
+		if includesGroup(groups, include, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPackageRuleName(name string) bool {
+	return name == "__pkg__" || name == "__subpackages__" || name == "__namespace__"
+}
@@ -17,6 +17,7 @@ package android
 import (
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/google/blueprint/proptools"
 )
@@ -65,29 +66,75 @@ func pathPropertiesForPropertyStruct(ctx BottomUpMutatorContext, ps interface{})
 	var ret []string
 
 	for _, i := range pathPropertyIndexes {
-		sv := fieldByIndex(v, i)
-		if !sv.IsValid() {
-			continue
+		ret = append(ret, pathPropertyValues(v, i)...)
+	}
+
+	return ret
+}
+
+// pathPropertyValues returns the values of the property tagged with android:"path" at index i of the property
+// struct v.
+func pathPropertyValues(v reflect.Value, i []int) []string {
+	sv := fieldByIndex(v, i)
+	if !sv.IsValid() {
+		return nil
+	}
+
+	if sv.Kind() == reflect.Ptr {
+		if sv.IsNil() {
+			return nil
 		}
+		sv = sv.Elem()
+	}
+	switch sv.Kind() {
+	case reflect.String:
+		return []string{sv.String()}
+	case reflect.Slice:
+		return sv.Interface().([]string)
+	default:
+		panic(fmt.Errorf(`field %s in type %s has tag android:"path" but is not a string or slice of strings, it is a %s`,
+			v.Type().FieldByIndex(i).Name, v.Type(), sv.Type()))
+	}
+}
 
-		if sv.Kind() == reflect.Ptr {
-			if sv.IsNil() {
-				continue
-			}
-			sv = sv.Elem()
+// pathPropertiesReferencing returns the names of the properties of m tagged with android:"path" that reference the
+// module dep with ":module" syntax, like "srcs" or "dist.targets", for error messages about the reference.
+func pathPropertiesReferencing(m Module, dep string) []string {
+	var names []string
+	for _, ps := range m.base().generalProperties {
+		v := reflect.ValueOf(ps)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct || v.IsNil() {
+			continue
 		}
-		switch sv.Kind() {
-		case reflect.String:
-			ret = append(ret, sv.String())
-		case reflect.Slice:
-			ret = append(ret, sv.Interface().([]string)...)
-		default:
-			panic(fmt.Errorf(`field %s in type %s has tag android:"path" but is not a string or slice of strings, it is a %s`,
-				v.Type().FieldByIndex(i).Name, v.Type(), sv.Type()))
+		v = v.Elem()
+
+		for _, i := range pathPropertyIndexesForPropertyStruct(ps) {
+			for _, s := range pathPropertyValues(v, i) {
+				if module, _ := SrcIsModuleWithTag(s); module == dep {
+					names = append(names, propertyNameForIndex(v.Type(), i))
+					break
+				}
+			}
 		}
 	}
+	return FirstUniqueStrings(names)
+}
 
-	return ret
+// propertyNameForIndex returns the name of the property at index i of the property struct type t, skipping the
+// embedded structs that don't add a level to the property names.
+func propertyNameForIndex(t reflect.Type, i []int) string {
+	var names []string
+	for _, x := range i {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		field := t.Field(x)
+		if !field.Anonymous {
+			names = append(names, proptools.PropertyNameForField(field.Name))
+		}
+		t = field.Type
+	}
+	return strings.Join(names, ".")
 }
 
 // fieldByIndex is like reflect.Value.FieldByIndex, but returns an invalid reflect.Value when traversing a nil pointer
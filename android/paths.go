@@ -15,6 +15,7 @@
 package android
 
 import (
+	"crypto/md5"
 	"fmt"
 	"path/filepath"
 	"reflect"
@@ -978,7 +979,20 @@ type ModuleOutPath struct {
 var _ Path = ModuleOutPath{}
 
 func pathForModule(ctx ModuleContext) OutputPath {
-	return PathForOutput(ctx, ".intermediates", ctx.ModuleDir(), ctx.ModuleName(), ctx.ModuleSubDir())
+	return PathForOutput(ctx, ".intermediates", ctx.ModuleDir(), ctx.ModuleName(),
+		variantDir(ctx.Config(), ctx.ModuleSubDir()))
+}
+
+// variantDir returns the name of the directory of a module variant in the intermediates directory.
+// Variant names that combine many mutators, like the override, dpi and arch variants of apps, can
+// push the output paths over the path length limits of some filesystems.  When MaxVariantDirLength
+// is set, longer variant names are replaced by their md5 hash, and hashed_variant_dirs.json maps
+// the hashed directories back to their modules.
+func variantDir(config Config, variant string) string {
+	if max := config.MaxVariantDirLength(); max > 0 && len(variant) > max {
+		return fmt.Sprintf("%x", md5.Sum([]byte(variant)))
+	}
+	return variant
 }
 
 // PathForVndkRefAbiDump returns an OptionalPath representing the path of the
@@ -1114,7 +1128,11 @@ func PathForModuleInstall(ctx ModuleInstallPathContext, pathComponents ...string
 			outPaths = []string{"host", "linux-x86"}
 		case LinuxBionic:
 			// TODO: should this be a separate top level, or shared with linux-x86?
-			outPaths = []string{"host", "linux_bionic-x86"}
+			if ctx.Arch().ArchType == Arm64 {
+				outPaths = []string{"host", "linux_bionic-arm64"}
+			} else {
+				outPaths = []string{"host", "linux_bionic-x86"}
+			}
 		default:
 			outPaths = []string{"host", ctx.Os().String() + "-x86"}
 		}
@@ -249,6 +249,16 @@ func TestPathForModuleInstall(t *testing.T) {
 			in:  []string{"bin", "my_test"},
 			out: "host/linux-x86/bin/my_test",
 		},
+		{
+			name: "linux_bionic arm64 binary",
+			ctx: &moduleInstallPathContextImpl{
+				baseModuleContext: baseModuleContext{
+					target: Target{Os: LinuxBionic, Arch: Arch{ArchType: Arm64}},
+				},
+			},
+			in:  []string{"bin", "my_test"},
+			out: "host/linux_bionic-arm64/bin/my_test",
+		},
 
 		{
 			name: "system binary",
@@ -293,6 +303,17 @@ func TestPathForModuleInstall(t *testing.T) {
 			in:  []string{"bin", "my_test"},
 			out: "target/product/test_device/product/bin/my_test",
 		},
+		{
+			name: "recovery binary",
+			ctx: &moduleInstallPathContextImpl{
+				baseModuleContext: baseModuleContext{
+					target: deviceTarget,
+				},
+				inRecovery: true,
+			},
+			in:  []string{"bin", "my_test"},
+			out: "target/product/test_device/recovery/root/system/bin/my_test",
+		},
 		{
 			name: "product_services binary",
 			ctx: &moduleInstallPathContextImpl{
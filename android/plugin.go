@@ -0,0 +1,219 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Plugin registration API.
+//
+// Go packages outside of build/soong that are compiled into soong_build with pluginFor: ["soong_build"] must
+// register their module types, singletons and mutators through a Plugin instead of the global Register* functions,
+// which give them unrestricted access to the internal ordering of the build:
+//
+//   func init() {
+//       p := android.RegisterPlugin("acme")
+//       p.RegisterModuleType("acme_firmware", firmwareFactory)
+//       p.PostDepsMutators(func(ctx android.RegisterMutatorsContext) {
+//           ctx.BottomUp("firmware_deps", firmwareDepsMutator).Parallel()
+//       })
+//   }
+//
+// The registrations of a plugin are namespaced and checked when the context is registered:
+//  - module types must be prefixed with the name of the plugin followed by an underscore, and must not collide with
+//    the module types registered by Soong or by other plugins.
+//  - singletons and mutators are renamed to <plugin>:<name>, so they can't collide with each other.
+//  - mutators can only be registered in the pre-deps phase, after the arch mutator, and in the post-deps and final
+//    deps phases.
+//    They run after all the mutators Soong registers in the same phase, so they see the result of the load hooks,
+//    defaults, prebuilts, visibility and neverallow mutators, and can't change their inputs.
+// A plugin that violates the policy, or that calls the global Register* functions, fails soong_build with an error
+// listing the violations.
+
+var plugins []*Plugin
+
+var validPluginName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// globalRegistrationViolations are the registrations through the global Register* functions by packages that are
+// not part of Soong.
+var globalRegistrationViolations []error
+
+// checkGlobalRegistration records a violation of the plugin policy when the caller of a global Register* function
+// is not part of Soong.  It must be called directly by the Register* function.
+func checkGlobalRegistration(what string) {
+	// Skip checkGlobalRegistration and the Register* function.
+	pkg, _, ok := callerName(3)
+	if !ok {
+		return
+	}
+	if err := globalRegistrationViolation(what, pkg); err != nil {
+		globalRegistrationViolations = append(globalRegistrationViolations, err)
+	}
+}
+
+// globalRegistrationViolation returns an error if the package that registered what with a global Register*
+// function is not a package of Soong or Blueprint.
+func globalRegistrationViolation(what, pkg string) error {
+	if pkg == "main" || strings.HasPrefix(pkg, "android/soong/") ||
+		strings.HasPrefix(pkg, "github.com/google/blueprint") {
+		return nil
+	}
+	return fmt.Errorf("%s registered by package %q with a global Register function must be registered "+
+		"with android.RegisterPlugin", what, pkg)
+}
+
+// Plugin collects the registrations of a soong_build plugin.
+type Plugin struct {
+	name string
+
+	moduleTypes []moduleType
+	singletons  []singleton
+	preDeps     []RegisterMutatorFunc
+	postDeps    []RegisterMutatorFunc
+	finalDeps   []RegisterMutatorFunc
+}
+
+// RegisterPlugin returns the Plugin to register the module types, singletons and mutators of a soong_build plugin
+// with.  It must be called from an init function.
+func RegisterPlugin(name string) *Plugin {
+	p := newPlugin(name)
+	plugins = append(plugins, p)
+	return p
+}
+
+func newPlugin(name string) *Plugin {
+	return &Plugin{name: name}
+}
+
+// Name returns the name of the plugin.
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// RegisterModuleType registers a module type, whose name must be prefixed with the name of the plugin.
+func (p *Plugin) RegisterModuleType(name string, factory ModuleFactory) {
+	p.moduleTypes = append(p.moduleTypes, moduleType{name, factory})
+}
+
+// RegisterSingletonType registers a singleton that runs after the singletons registered by Soong.
+func (p *Plugin) RegisterSingletonType(name string, factory SingletonFactory) {
+	p.singletons = append(p.singletons, singleton{p.namespaced(name), SingletonFactoryAdaptor(factory)})
+}
+
+// PreDepsMutators registers mutators that run after the arch mutator and the pre-deps mutators registered by Soong.
+func (p *Plugin) PreDepsMutators(f RegisterMutatorFunc) {
+	p.preDeps = append(p.preDeps, p.namespacedMutators(f))
+}
+
+// PostDepsMutators registers mutators that run after the post-deps mutators registered by Soong.
+func (p *Plugin) PostDepsMutators(f RegisterMutatorFunc) {
+	p.postDeps = append(p.postDeps, p.namespacedMutators(f))
+}
+
+// FinalDepsMutators registers mutators that run after the final deps mutators registered by Soong.
+func (p *Plugin) FinalDepsMutators(f RegisterMutatorFunc) {
+	p.finalDeps = append(p.finalDeps, p.namespacedMutators(f))
+}
+
+func (p *Plugin) namespaced(name string) string {
+	return p.name + ":" + name
+}
+
+// namespacedMutators wraps a RegisterMutatorFunc so that the mutators it registers are namespaced with the name of
+// the plugin.
+func (p *Plugin) namespacedMutators(f RegisterMutatorFunc) RegisterMutatorFunc {
+	return func(ctx RegisterMutatorsContext) {
+		f(&pluginRegisterMutatorsContext{ctx, p})
+	}
+}
+
+type pluginRegisterMutatorsContext struct {
+	ctx    RegisterMutatorsContext
+	plugin *Plugin
+}
+
+func (c *pluginRegisterMutatorsContext) TopDown(name string, m TopDownMutator) MutatorHandle {
+	return c.ctx.TopDown(c.plugin.namespaced(name), m)
+}
+
+func (c *pluginRegisterMutatorsContext) BottomUp(name string, m BottomUpMutator) MutatorHandle {
+	return c.ctx.BottomUp(c.plugin.namespaced(name), m)
+}
+
+// checkPlugins returns the violations of the plugin policy by the registered plugins.
+func checkPlugins(moduleTypes []moduleType, plugins []*Plugin) []error {
+	var errs []error
+
+	moduleTypeOwners := make(map[string]string)
+	for _, t := range moduleTypes {
+		moduleTypeOwners[t.name] = "soong"
+	}
+
+	pluginNames := make(map[string]bool)
+	for _, p := range plugins {
+		if !validPluginName.MatchString(p.name) {
+			errs = append(errs, fmt.Errorf("plugin name %q must match %s", p.name, validPluginName))
+		}
+		if pluginNames[p.name] {
+			errs = append(errs, fmt.Errorf("plugin %q is registered more than once", p.name))
+		}
+		pluginNames[p.name] = true
+
+		for _, t := range p.moduleTypes {
+			if !strings.HasPrefix(t.name, p.name+"_") {
+				errs = append(errs, fmt.Errorf("module type %q of plugin %q must be prefixed with %q",
+					t.name, p.name, p.name+"_"))
+			}
+			if owner, exists := moduleTypeOwners[t.name]; exists {
+				errs = append(errs, fmt.Errorf("module type %q of plugin %q is already registered by %s",
+					t.name, p.name, owner))
+			} else {
+				moduleTypeOwners[t.name] = fmt.Sprintf("plugin %q", p.name)
+			}
+		}
+	}
+
+	return errs
+}
+
+// registerPlugins registers the module types and singletons of the plugins, and returns their pre-deps,
+// post-deps and final deps mutators.
+func (ctx *Context) registerPlugins() (preDeps, postDeps, finalDeps []RegisterMutatorFunc) {
+	errs := append(checkPlugins(moduleTypes, plugins), globalRegistrationViolations...)
+	if len(errs) > 0 {
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		panic(fmt.Errorf("invalid soong_build plugins:\n  %s", strings.Join(msgs, "\n  ")))
+	}
+
+	for _, p := range plugins {
+		for _, t := range p.moduleTypes {
+			ctx.RegisterModuleType(t.name, ModuleFactoryAdaptor(t.factory))
+		}
+		for _, t := range p.singletons {
+			ctx.RegisterSingletonType(t.name, t.factory)
+		}
+		preDeps = append(preDeps, p.preDeps...)
+		postDeps = append(postDeps, p.postDeps...)
+		finalDeps = append(finalDeps, p.finalDeps...)
+	}
+
+	return preDeps, postDeps, finalDeps
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckPlugins(t *testing.T) {
+	pluginWithModuleTypes := func(name string, types ...string) *Plugin {
+		p := newPlugin(name)
+		for _, t := range types {
+			p.RegisterModuleType(t, nil)
+		}
+		return p
+	}
+
+	builtins := []moduleType{{name: "cc_library"}, {name: "acme_library"}}
+
+	tests := []struct {
+		name    string
+		plugins []*Plugin
+		errs    []string
+	}{
+		{
+			name: "valid",
+			plugins: []*Plugin{
+				pluginWithModuleTypes("acme", "acme_firmware"),
+				pluginWithModuleTypes("other", "other_firmware"),
+			},
+		},
+		{
+			name:    "invalid name",
+			plugins: []*Plugin{pluginWithModuleTypes("Acme")},
+			errs:    []string{`plugin name "Acme" must match ^[a-z][a-z0-9_]*$`},
+		},
+		{
+			name: "registered twice",
+			plugins: []*Plugin{
+				pluginWithModuleTypes("acme"),
+				pluginWithModuleTypes("acme"),
+			},
+			errs: []string{`plugin "acme" is registered more than once`},
+		},
+		{
+			name:    "module type without prefix",
+			plugins: []*Plugin{pluginWithModuleTypes("acme", "firmware")},
+			errs:    []string{`module type "firmware" of plugin "acme" must be prefixed with "acme_"`},
+		},
+		{
+			name:    "module type registered by soong",
+			plugins: []*Plugin{pluginWithModuleTypes("acme", "acme_library")},
+			errs:    []string{`module type "acme_library" of plugin "acme" is already registered by soong`},
+		},
+		{
+			name: "module type registered by another plugin",
+			plugins: []*Plugin{
+				pluginWithModuleTypes("acme", "acme_firmware"),
+				pluginWithModuleTypes("acme_ext", "acme_firmware"),
+			},
+			errs: []string{
+				`module type "acme_firmware" of plugin "acme_ext" must be prefixed with "acme_ext_"`,
+				`module type "acme_firmware" of plugin "acme_ext" is already registered by plugin "acme"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var errs []string
+			for _, err := range checkPlugins(builtins, test.plugins) {
+				errs = append(errs, err.Error())
+			}
+			if !reflect.DeepEqual(errs, test.errs) {
+				t.Errorf("want errors %q, got %q", test.errs, errs)
+			}
+		})
+	}
+}
+
+func TestGlobalRegistrationViolation(t *testing.T) {
+	tests := []struct {
+		pkg string
+		err string
+	}{
+		{pkg: "android/soong/java"},
+		{pkg: "android/soong/java/config"},
+		{pkg: "github.com/google/blueprint/bootstrap"},
+		{pkg: "main"},
+		{
+			pkg: "vendor/acme/soong",
+			err: `module type "acme_firmware" registered by package "vendor/acme/soong" with a global Register ` +
+				`function must be registered with android.RegisterPlugin`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pkg, func(t *testing.T) {
+			err := globalRegistrationViolation(`module type "acme_firmware"`, test.pkg)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("want no error, got %q", err)
+				}
+			} else if err == nil || err.Error() != test.err {
+				t.Errorf("want error %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	// The registrations of the Soong packages linked into this test don't violate the policy.
+	if len(globalRegistrationViolations) > 0 {
+		t.Errorf("want no global registration violations, got %q", globalRegistrationViolations)
+	}
+}
+
+func TestPluginMutators(t *testing.T) {
+	p := newPlugin("acme")
+	p.PreDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.BottomUp("firmware", func(BottomUpMutatorContext) {}).Parallel()
+	})
+	p.PostDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.TopDown("firmware_deps", func(TopDownMutatorContext) {})
+	})
+	p.FinalDepsMutators(func(ctx RegisterMutatorsContext) {
+		ctx.TopDown("firmware_contents", func(TopDownMutatorContext) {})
+	})
+
+	mctx := &registerMutatorsContext{}
+	for _, f := range append(append(p.preDeps, p.postDeps...), p.finalDeps...) {
+		f(mctx)
+	}
+
+	var names []string
+	for _, m := range mctx.mutators {
+		names = append(names, m.name)
+	}
+	if want := []string{"acme:firmware", "acme:firmware_deps", "acme:firmware_contents"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("want mutators %q, got %q", want, names)
+	}
+	if !mctx.mutators[0].parallel {
+		t.Errorf("expected acme:firmware to be parallel")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// Property errors are reported by blueprint at the position of the property in the Android.bp file.
+// Blueprint only knows the positions of the properties that are set directly on the module, keyed by
+// their nested property path (e.g. dpi_variants.xhdpi.apk), so errors about properties that were
+// inherited from a defaults module or squashed in from an override module are reported at the
+// position of the module instead.  To point at the right place anyway the origin of those properties
+// is recorded while they are applied, and PropertyErrorf appends it to the error message.
+//
+// PropertyErrorf also accepts a property path with a trailing list index (e.g. srcs[2]).  The error
+// is reported at the position of the list property, and the index is included in the message.
+
+var propertyIndexRegexp = regexp.MustCompile(`^(.+)\[([0-9]+)\]$`)
+
+// splitPropertyIndex splits a trailing list index from a property path, returning the property path
+// and the index, or an empty string if there is no index.
+func splitPropertyIndex(property string) (string, string) {
+	if matches := propertyIndexRegexp.FindStringSubmatch(property); matches != nil {
+		return matches[1], matches[2]
+	}
+	return property, ""
+}
+
+// IndexedProperty returns the path of the element at index i of a list property, for use with
+// PropertyErrorf.
+func IndexedProperty(property string, i int) string {
+	return fmt.Sprintf("%s[%d]", property, i)
+}
+
+func (b *baseModuleContext) PropertyErrorf(property, format string, args ...interface{}) {
+	property, index := splitPropertyIndex(property)
+	if index != "" {
+		format = "item " + index + ": " + format
+	}
+
+	if m := b.Module(); m != nil {
+		if origin := m.base().propertyOrigin(property); origin != "" {
+			format += " (" + origin + ")"
+		}
+	}
+
+	b.BaseModuleContext.PropertyErrorf(property, format, args...)
+}
+
+type propertyOrigin struct {
+	Property string
+	Origin   string
+}
+
+// setPropertyOrigin records that the value of a property was set by another module.  It is stored in
+// the properties of the module so that it is copied into the variants of the module.
+func (m *ModuleBase) setPropertyOrigin(property, origin string) {
+	m.commonProperties.PropertyOrigins = append(m.commonProperties.PropertyOrigins,
+		propertyOrigin{property, origin})
+}
+
+// propertyOrigin returns a description of where the value of a property, or of the closest enclosing
+// property struct, was set if it was not set directly on the module.
+func (m *ModuleBase) propertyOrigin(property string) string {
+	for {
+		// Later origins replace earlier ones.
+		for i := len(m.commonProperties.PropertyOrigins) - 1; i >= 0; i-- {
+			if o := m.commonProperties.PropertyOrigins[i]; o.Property == property {
+				return o.Origin
+			}
+		}
+		i := strings.LastIndex(property, ".")
+		if i < 0 {
+			return ""
+		}
+		property = property[:i]
+	}
+}
+
+// setPropertyNames returns the paths of all the properties that are set to a non-zero value in a list
+// of property structs.
+func setPropertyNames(props []interface{}) []string {
+	var names []string
+	for _, p := range props {
+		names = appendSetPropertyNames(names, "", reflect.ValueOf(p))
+	}
+	return names
+}
+
+func appendSetPropertyNames(names []string, prefix string, v reflect.Value) []string {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return names
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return names
+	}
+
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || proptools.HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+
+		name := prefix
+		if !field.Anonymous {
+			name += proptools.PropertyNameForField(field.Name)
+		}
+
+		fieldValue := v.Field(i)
+		switch fieldValue.Kind() {
+		case reflect.Struct, reflect.Interface:
+			names = appendSetPropertyNames(names, prefixForNested(name, field), fieldValue)
+		case reflect.Ptr:
+			if fieldValue.Type().Elem().Kind() == reflect.Struct {
+				names = appendSetPropertyNames(names, prefixForNested(name, field), fieldValue)
+			} else if !fieldValue.IsNil() {
+				names = append(names, name)
+			}
+		case reflect.Slice:
+			if fieldValue.Len() > 0 {
+				names = append(names, name)
+			}
+		case reflect.String:
+			if fieldValue.String() != "" {
+				names = append(names, name)
+			}
+		case reflect.Bool:
+			if fieldValue.Bool() {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func prefixForNested(name string, field reflect.StructField) string {
+	if field.Anonymous {
+		return name
+	}
+	return name + "."
+}
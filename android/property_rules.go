@@ -0,0 +1,231 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Property rules are the counterpart of the neverallow rules: instead of disallowing modules that
+// match them, they require the modules they apply to to set a property, or to only set it to some
+// values, e.g. that the modules in //external declare the licenses of their sources.
+//
+// A property rule applies to a module if all of the following are true:
+// - it is in one of the "in" paths, if any are listed
+// - it is not in one of the "notIn" paths
+// - it is of one of the "moduleType" types, if any are listed, and none of the
+//   "notModuleType" types
+//
+// and the module violates it if the "require" property isn't set, or if any of its values isn't
+// one of the "oneOf" values, if any are listed.  Nested properties are separated with a '.'.
+//
+// The rules are checked once per module after the defaults have been applied, before the arch
+// mutator, so arch and target specific values of the properties are not checked.
+
+func registerPropertyRuleEnforcer(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("propertyRuleEnforcer", propertyRuleEnforcer).Parallel()
+}
+
+var propertyRules = createPropertyRules()
+
+func createPropertyRules() []*propertyRule {
+	// No rules are enforced by default yet.  A rule can only be added once every module it applies to
+	// complies with it, e.g. require("licenses").in("external") once the modules in //external declare
+	// their licenses.
+	return nil
+}
+
+func propertyRuleEnforcer(ctx BottomUpMutatorContext) {
+	m, ok := ctx.Module().(Module)
+	if !ok {
+		return
+	}
+	// The properties of a defaults module are only checked in the modules that use it.
+	if _, ok := m.(Defaults); ok {
+		return
+	}
+
+	checkPropertyRules(ctx, m, propertyRules)
+}
+
+func checkPropertyRules(ctx BottomUpMutatorContext, m Module, rules []*propertyRule) {
+	dir := ctx.ModuleDir() + "/"
+	properties := m.GetProperties()
+
+	for _, r := range rules {
+		if !r.appliesTo(dir, ctx.ModuleType()) {
+			continue
+		}
+
+		values, set := propertyValues(properties, r.fields)
+		if !set {
+			ctx.PropertyErrorf(r.property, "must be set for modules %s", r)
+			continue
+		}
+		if len(r.values) > 0 {
+			for _, v := range values {
+				if !InList(v, r.values) {
+					ctx.PropertyErrorf(r.property, "%q is not allowed for modules %s", v, r)
+				}
+			}
+		}
+	}
+}
+
+type propertyRule struct {
+	// User string for why this is a thing.
+	reason string
+
+	property string
+	fields   []string
+	values   []string
+
+	paths       []string
+	unlessPaths []string
+
+	moduleTypes       []string
+	unlessModuleTypes []string
+}
+
+// require returns a rule that requires a property to be set.
+func require(property string) *propertyRule {
+	return &propertyRule{
+		property: property,
+		fields:   fieldNamesForProperties(property),
+	}
+}
+
+// oneOf requires every value of the property to be one of values.
+func (r *propertyRule) oneOf(values ...string) *propertyRule {
+	r.values = append(r.values, values...)
+	return r
+}
+
+func (r *propertyRule) in(path ...string) *propertyRule {
+	r.paths = append(r.paths, cleanPaths(path)...)
+	return r
+}
+
+func (r *propertyRule) notIn(path ...string) *propertyRule {
+	r.unlessPaths = append(r.unlessPaths, cleanPaths(path)...)
+	return r
+}
+
+func (r *propertyRule) moduleType(types ...string) *propertyRule {
+	r.moduleTypes = append(r.moduleTypes, types...)
+	return r
+}
+
+func (r *propertyRule) notModuleType(types ...string) *propertyRule {
+	r.unlessModuleTypes = append(r.unlessModuleTypes, types...)
+	return r
+}
+
+func (r *propertyRule) because(reason string) *propertyRule {
+	r.reason = reason
+	return r
+}
+
+// String describes the modules the rule applies to, the values it allows and why, for error
+// messages.
+func (r *propertyRule) String() string {
+	var s []string
+	if len(r.paths) > 0 {
+		s = append(s, "in "+strings.Join(propertyRulePaths(r.paths), ", "))
+	}
+	if len(r.unlessPaths) > 0 {
+		s = append(s, "outside of "+strings.Join(propertyRulePaths(r.unlessPaths), ", "))
+	}
+	if len(r.moduleTypes) > 0 {
+		s = append(s, "of type "+strings.Join(r.moduleTypes, ", "))
+	}
+	if len(r.unlessModuleTypes) > 0 {
+		s = append(s, "not of type "+strings.Join(r.unlessModuleTypes, ", "))
+	}
+	if len(s) == 0 {
+		s = append(s, "in all packages")
+	}
+	if len(r.values) > 0 {
+		s = append(s, fmt.Sprintf("(allowed values: %s)", strings.Join(r.values, ", ")))
+	}
+	if r.reason != "" {
+		s = append(s, "because "+r.reason)
+	}
+	return strings.Join(s, " ")
+}
+
+func propertyRulePaths(paths []string) []string {
+	ret := make([]string, len(paths))
+	for i, path := range paths {
+		ret[i] = "//" + strings.TrimSuffix(path, "/")
+	}
+	return ret
+}
+
+func (r *propertyRule) appliesTo(dir, moduleType string) bool {
+	return (len(r.paths) == 0 || hasAnyPrefix(dir, r.paths)) && !hasAnyPrefix(dir, r.unlessPaths) &&
+		(len(r.moduleTypes) == 0 || InList(moduleType, r.moduleTypes)) &&
+		!InList(moduleType, r.unlessModuleTypes)
+}
+
+// propertyValues returns the values of a property of a module and whether it is set.  A property is
+// set if it is a non-nil pointer to a bool or an int, a non-empty string or a non-empty list.
+func propertyValues(properties []interface{}, fields []string) ([]string, bool) {
+	for _, propertyStruct := range properties {
+		value := reflect.ValueOf(propertyStruct).Elem()
+		for _, field := range fields {
+			if !value.IsValid() || value.Kind() != reflect.Struct {
+				value = reflect.Value{}
+				break
+			}
+			value = value.FieldByName(field)
+		}
+		if !value.IsValid() {
+			continue
+		}
+
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.String:
+			if value.String() != "" {
+				return []string{value.String()}, true
+			}
+		case reflect.Bool:
+			return []string{strconv.FormatBool(value.Bool())}, true
+		case reflect.Int, reflect.Int64:
+			return []string{strconv.FormatInt(value.Int(), 10)}, true
+		case reflect.Slice:
+			slice, ok := value.Interface().([]string)
+			if !ok {
+				panic("Can only handle slice of string")
+			}
+			if len(slice) > 0 {
+				return slice, true
+			}
+		default:
+			panic("Can't handle type: " + value.Kind().String())
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,175 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"testing"
+)
+
+var externalLicensesRules = []*propertyRule{
+	require("licenses").
+		in("external").
+		because("the licenses of third party code must be known to include its notices in the " +
+			"products that install it"),
+}
+
+var propertyRuleTests = []struct {
+	name           string
+	rules          []*propertyRule
+	fs             map[string][]byte
+	expectedErrors []string
+}{
+	{
+		name:  "licenses required in external",
+		rules: externalLicensesRules,
+		fs: map[string][]byte{
+			"external/foo/Blueprints": []byte(`
+				mock_library {
+					name: "libfoo",
+				}
+
+				mock_library {
+					name: "libfoo_licensed",
+					licenses: ["BSD-3-Clause"],
+				}`),
+			"frameworks/Blueprints": []byte(`
+				mock_library {
+					name: "libframeworks",
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libfoo": licenses: must be set for modules in //external because the licenses of` +
+				` third party code must be known`,
+		},
+	},
+	{
+		name:  "licenses inherited from defaults",
+		rules: externalLicensesRules,
+		fs: map[string][]byte{
+			"external/foo/Blueprints": []byte(`
+				mock_defaults {
+					name: "foo_defaults",
+					licenses: ["MIT"],
+				}
+
+				mock_library {
+					name: "libfoo",
+					defaults: ["foo_defaults"],
+				}`),
+		},
+	},
+	{
+		name: "allowed values",
+		rules: []*propertyRule{
+			require("owner").
+				in("vendor").
+				notIn("vendor/shared").
+				oneOf("acme", "initech").
+				because("vendor modules must be owned by a known vendor"),
+		},
+		fs: map[string][]byte{
+			"vendor/acme/Blueprints": []byte(`
+				mock_library {
+					name: "libacme",
+					owner: "acme",
+				}
+
+				mock_library {
+					name: "libunknown",
+					owner: "unknown",
+				}
+
+				mock_library {
+					name: "libunowned",
+				}`),
+			"vendor/shared/Blueprints": []byte(`
+				mock_library {
+					name: "libshared",
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libunknown": owner: "unknown" is not allowed for modules in //vendor outside of` +
+				` //vendor/shared \(allowed values: acme, initech\) because vendor modules must be owned`,
+			`module "libunowned": owner: must be set for modules in //vendor outside of //vendor/shared`,
+		},
+	},
+	{
+		name: "module types",
+		rules: []*propertyRule{
+			require("licenses").moduleType("mock_library").notModuleType("mock_defaults"),
+		},
+		fs: map[string][]byte{
+			"Blueprints": []byte(`
+				mock_defaults {
+					name: "defaults",
+				}
+
+				mock_library {
+					name: "libexample",
+				}
+
+				filegroup {
+					name: "fg",
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libexample": licenses: must be set for modules of type mock_library not of type` +
+				` mock_defaults$`,
+		},
+	},
+}
+
+func TestPropertyRules(t *testing.T) {
+	for _, test := range propertyRuleTests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(rules []*propertyRule) { propertyRules = rules }(propertyRules)
+			propertyRules = test.rules
+
+			errs := testPropertyRules(test.fs)
+			if test.expectedErrors == nil {
+				FailIfErrored(t, errs)
+				return
+			}
+			for _, expectedError := range test.expectedErrors {
+				FailIfNoMatchingErrors(t, expectedError, errs)
+			}
+			if len(errs) != len(test.expectedErrors) {
+				t.Errorf("expected %d errors, found %d: %q", len(test.expectedErrors), len(errs), errs)
+			}
+		})
+	}
+}
+
+func testPropertyRules(fs map[string][]byte) []error {
+	config := TestArchConfig(buildDir, nil)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("mock_library", ModuleFactoryAdaptor(newMockLibraryModule))
+	ctx.RegisterModuleType("mock_defaults", ModuleFactoryAdaptor(defaultsFactory))
+	ctx.RegisterModuleType("filegroup", ModuleFactoryAdaptor(FileGroupFactory))
+	ctx.PreArchMutators(RegisterDefaultsPreArchMutators)
+	ctx.PreArchMutators(registerPropertyRuleEnforcer)
+	ctx.Register()
+
+	ctx.MockFileSystem(fs)
+
+	_, errs := ctx.ParseBlueprintsFiles(".")
+	if len(errs) > 0 {
+		return errs
+	}
+
+	_, errs = ctx.PrepareBuildActions(config)
+	return errs
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/blueprint"
+)
+
+// Providers let a module publish typed data to the modules that depend on it and to singletons,
+// without them having to type assert the module to an interface that its module type, and every
+// other module type that provides the same data, implements:
+//
+//   var ExportedJarsProvider = android.NewProvider("ExportedJars", android.Paths(nil))
+//
+//   func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+//       ...
+//       ctx.SetProvider(ExportedJarsProvider, j.exportedJars)
+//   }
+//
+//   ctx.VisitDirectDeps(func(dep android.Module) {
+//       if ctx.OtherModuleHasProvider(dep, ExportedJarsProvider) {
+//           jars = append(jars, ctx.OtherModuleProvider(dep, ExportedJarsProvider).(android.Paths)...)
+//       }
+//   })
+//
+// A provider is set by GenerateAndroidBuildActions, so it can only be read by the modules that
+// directly depend on the module, whose GenerateAndroidBuildActions run after it, and by singletons.
+// Reading the provider of any other module panics, its GenerateAndroidBuildActions may not have run
+// yet.
+
+// A ProviderKey identifies a type of data that modules provide.  It is created once with
+// NewProvider, usually in a package level variable.
+type ProviderKey struct {
+	name string
+	typ  reflect.Type
+}
+
+// NewProvider returns the key of a provider named name whose values have the type of zero.
+func NewProvider(name string, zero interface{}) *ProviderKey {
+	return &ProviderKey{name: name, typ: reflect.TypeOf(zero)}
+}
+
+func (p *ProviderKey) String() string {
+	return p.name
+}
+
+func (m *moduleContext) SetProvider(key *ProviderKey, value interface{}) {
+	if typ := reflect.TypeOf(value); typ != key.typ {
+		panic(fmt.Errorf("provider %s must be set to a %s, got a %s", key, key.typ, typ))
+	}
+	base := m.module.base()
+	if base.providers == nil {
+		base.providers = make(map[*ProviderKey]interface{})
+	}
+	base.providers[key] = value
+}
+
+func (m *moduleContext) Provider(key *ProviderKey) interface{} {
+	return m.module.base().providers[key]
+}
+
+func (m *moduleContext) OtherModuleProvider(module blueprint.Module, key *ProviderKey) interface{} {
+	m.checkProviderDep(module, key)
+	return moduleProvider(module, key)
+}
+
+func (m *moduleContext) OtherModuleHasProvider(module blueprint.Module, key *ProviderKey) bool {
+	m.checkProviderDep(module, key)
+	return moduleHasProvider(module, key)
+}
+
+// checkProviderDep panics if module is not a direct dependency.  The dependencies were collected
+// before GenerateAndroidBuildActions, so the check doesn't have to visit them while the module is
+// visiting its dependencies itself.
+func (m *moduleContext) checkProviderDep(module blueprint.Module, key *ProviderKey) {
+	if m.providerDeps == nil {
+		m.providerDeps = make(map[blueprint.Module]bool)
+		for _, dep := range m.module.base().directDeps {
+			m.providerDeps[dep.module] = true
+		}
+	}
+	if m.providerDeps[module] {
+		return
+	}
+	panic(fmt.Errorf("module %q read provider %s of %q, which is not a direct dependency",
+		m.ModuleName(), key, m.OtherModuleName(module)))
+}
+
+func (s *singletonContextAdaptor) ModuleProvider(module blueprint.Module, key *ProviderKey) interface{} {
+	return moduleProvider(module, key)
+}
+
+func (s *singletonContextAdaptor) ModuleHasProvider(module blueprint.Module, key *ProviderKey) bool {
+	return moduleHasProvider(module, key)
+}
+
+func moduleProvider(module blueprint.Module, key *ProviderKey) interface{} {
+	if m, ok := module.(Module); ok {
+		return m.base().providers[key]
+	}
+	return nil
+}
+
+func moduleHasProvider(module blueprint.Module, key *ProviderKey) bool {
+	if m, ok := module.(Module); ok {
+		_, ok := m.base().providers[key]
+		return ok
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testProvider = NewProvider("test", []string(nil))
+
+// providerTestModules maps the names of the test modules to the modules, so that they can try to read
+// the providers of modules they don't depend on.
+var providerTestModules = make(map[string]*providerTestModule)
+
+type providerTestModule struct {
+	ModuleBase
+	props struct {
+		Deps     []string
+		Provides []string
+		Reads    []string
+	}
+
+	fromDeps []string
+}
+
+func providerTestModuleFactory() Module {
+	m := &providerTestModule{}
+	m.AddProperties(&m.props)
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *providerTestModule) DepsMutator(ctx BottomUpMutatorContext) {
+	providerTestModules[ctx.ModuleName()] = m
+	ctx.AddDependency(ctx.Module(), nil, m.props.Deps...)
+}
+
+func (m *providerTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	if m.props.Provides != nil {
+		ctx.SetProvider(testProvider, m.props.Provides)
+		if !reflect.DeepEqual(ctx.Provider(testProvider), m.props.Provides) {
+			ctx.ModuleErrorf("Provider returned %q", ctx.Provider(testProvider))
+		}
+	}
+
+	ctx.VisitDirectDeps(func(dep Module) {
+		if ctx.OtherModuleHasProvider(dep, testProvider) {
+			m.fromDeps = append(m.fromDeps, ctx.OtherModuleProvider(dep, testProvider).([]string)...)
+		} else if ctx.OtherModuleProvider(dep, testProvider) != nil {
+			ctx.ModuleErrorf("unset provider of %q is not nil", ctx.OtherModuleName(dep))
+		}
+	})
+
+	for _, name := range m.props.Reads {
+		ctx.OtherModuleProvider(providerTestModules[name], testProvider)
+	}
+}
+
+type providerTestSingleton struct {
+	provided map[string][]string
+}
+
+func (s *providerTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	s.provided = make(map[string][]string)
+	ctx.VisitAllModules(func(m Module) {
+		if ctx.ModuleHasProvider(m, testProvider) {
+			s.provided[ctx.ModuleName(m)] = ctx.ModuleProvider(m, testProvider).([]string)
+		}
+	})
+}
+
+func TestProviders(t *testing.T) {
+	bp := `
+		test {
+			name: "a",
+			deps: ["b", "c", "d"],
+		}
+
+		test {
+			name: "b",
+			provides: ["b1", "b2"],
+		}
+
+		test {
+			name: "c",
+			provides: ["c1"],
+		}
+
+		test {
+			name: "d",
+		}
+	`
+
+	config := TestConfig(buildDir, nil)
+
+	singleton := &providerTestSingleton{}
+
+	ctx := NewTestContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(providerTestModuleFactory))
+	ctx.RegisterSingletonType("providers", SingletonFactoryAdaptor(func() Singleton { return singleton }))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	a := ctx.ModuleForTests("a", "").Module().(*providerTestModule)
+	if want := []string{"b1", "b2", "c1"}; !reflect.DeepEqual(a.fromDeps, want) {
+		t.Errorf("want %q from the dependencies, got %q", want, a.fromDeps)
+	}
+
+	want := map[string][]string{
+		"b": {"b1", "b2"},
+		"c": {"c1"},
+	}
+	if !reflect.DeepEqual(singleton.provided, want) {
+		t.Errorf("want %q provided to the singleton, got %q", want, singleton.provided)
+	}
+}
+
+func TestOtherModuleProviderNotDependency(t *testing.T) {
+	bp := `
+		test {
+			name: "a",
+			deps: ["b"],
+			reads: ["c"],
+		}
+
+		test {
+			name: "b",
+			deps: ["c"],
+		}
+
+		test {
+			name: "c",
+			provides: ["c1"],
+		}
+	`
+
+	config := TestConfig(buildDir, nil)
+
+	ctx := NewTestContext()
+	ctx.RegisterModuleType("test", ModuleFactoryAdaptor(providerTestModuleFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfNoMatchingErrors(t, `module "a" read provider test of "c", which is not a direct dependency`, errs)
+}
+
+func TestSetProviderWrongType(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected SetProvider to panic")
+		}
+	}()
+
+	m := providerTestModuleFactory()
+	ctx := &moduleContext{module: m}
+	ctx.SetProvider(testProvider, "not a []string")
+}
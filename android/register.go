@@ -15,6 +15,8 @@
 package android
 
 import (
+	"fmt"
+
 	"github.com/google/blueprint"
 )
 
@@ -66,23 +68,29 @@ func SingletonFactoryAdaptor(factory SingletonFactory) blueprint.SingletonFactor
 }
 
 func RegisterModuleType(name string, factory ModuleFactory) {
+	checkGlobalRegistration(fmt.Sprintf("module type %q", name))
 	moduleTypes = append(moduleTypes, moduleType{name, factory})
 }
 
 func RegisterSingletonType(name string, factory SingletonFactory) {
+	checkGlobalRegistration(fmt.Sprintf("singleton %q", name))
 	singletons = append(singletons, singleton{name, SingletonFactoryAdaptor(factory)})
 }
 
 func RegisterPreSingletonType(name string, factory SingletonFactory) {
+	checkGlobalRegistration(fmt.Sprintf("pre-singleton %q", name))
 	preSingletons = append(preSingletons, singleton{name, SingletonFactoryAdaptor(factory)})
 }
 
 type Context struct {
 	*blueprint.Context
+
+	// The module types that have each property, recorded by RegisterModuleType.
+	moduleTypeProperties moduleTypeProperties
 }
 
 func NewContext() *Context {
-	return &Context{blueprint.NewContext()}
+	return &Context{Context: blueprint.NewContext()}
 }
 
 func (ctx *Context) Register() {
@@ -98,7 +106,13 @@ func (ctx *Context) Register() {
 		ctx.RegisterSingletonType(t.name, t.factory)
 	}
 
-	registerMutators(ctx.Context, preArch, preDeps, postDeps)
+	pluginPreDeps, pluginPostDeps, pluginFinalDeps := ctx.registerPlugins()
+
+	// Mutators of plugins run after the mutators of Soong in the same phase.
+	registerMutators(ctx.Context, preArch,
+		append(append([]RegisterMutatorFunc(nil), preDeps...), pluginPreDeps...),
+		append(append([]RegisterMutatorFunc(nil), postDeps...), pluginPostDeps...),
+		append(append([]RegisterMutatorFunc(nil), finalDeps...), pluginFinalDeps...))
 
 	// Register makevars after other singletons so they can export values through makevars
 	ctx.RegisterSingletonType("makevars", SingletonFactoryAdaptor(makeVarsSingletonFunc))
@@ -112,5 +126,10 @@ func ModuleTypeFactories() map[string]ModuleFactory {
 	for _, t := range moduleTypes {
 		ret[t.name] = t.factory
 	}
+	for _, p := range plugins {
+		for _, t := range p.moduleTypes {
+			ret[t.name] = t.factory
+		}
+	}
 	return ret
 }
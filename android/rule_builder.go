@@ -478,6 +478,14 @@ func (c *RuleBuilderCommand) Tool(path Path) *RuleBuilderCommand {
 	return c.Text(path.String())
 }
 
+// FlagWithTool adds the specified flag and tool path to the command line, with no separator between them, for a tool
+// that is passed to another tool or script instead of run directly.  The path will be also added to the dependencies
+// returned by RuleBuilder.Tools.
+func (c *RuleBuilderCommand) FlagWithTool(flag string, path Path) *RuleBuilderCommand {
+	c.tools = append(c.tools, path)
+	return c.Text(flag + path.String())
+}
+
 // Input adds the specified input path to the command line.  The path will also be added to the dependencies returned by
 // RuleBuilder.Inputs.
 func (c *RuleBuilderCommand) Input(path Path) *RuleBuilderCommand {
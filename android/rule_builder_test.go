@@ -503,3 +503,62 @@ func TestRuleBuilder_Build(t *testing.T) {
 			"cp bar "+outFile, outFile, outFile+".d", true, nil)
 	})
 }
+
+type testRuleBuilderToolModule struct {
+	ModuleBase
+}
+
+func testRuleBuilderToolFactory() Module {
+	module := &testRuleBuilderToolModule{}
+	InitAndroidModule(module)
+	return module
+}
+
+func (t *testRuleBuilderToolModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	out := PathForModuleOut(ctx, ctx.ModuleName())
+
+	rule := NewRuleBuilder()
+	rule.Command().
+		Tool(PathForSource(ctx, "cp")).
+		FlagWithTool("--tool=", PathForSource(ctx, "tool")).
+		Input(PathForSource(ctx, "a")).
+		Output(out)
+	rule.Build(pctx, ctx, "rule", "desc")
+}
+
+func TestRuleBuilder_FlagWithTool(t *testing.T) {
+	bp := `
+		rule_builder_tool_test {
+			name: "foo",
+		}
+	`
+
+	config := TestConfig(buildDir, nil)
+	ctx := NewTestContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+		"a":          nil,
+		"cp":         nil,
+		"tool":       nil,
+	})
+	ctx.RegisterModuleType("rule_builder_tool_test", ModuleFactoryAdaptor(testRuleBuilderToolFactory))
+	ctx.Register()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	FailIfErrored(t, errs)
+	_, errs = ctx.PrepareBuildActions(config)
+	FailIfErrored(t, errs)
+
+	params := ctx.ModuleForTests("foo", "").Rule("rule")
+	outDir := filepath.Join(buildDir, ".intermediates", "foo")
+
+	if g, w := params.RuleParams.Command, "cp --tool=tool a "+filepath.Join(outDir, "foo"); g != w {
+		t.Errorf("\nwant RuleParams.Command = %q\n                      got %q", w, g)
+	}
+	if g, w := params.RuleParams.CommandDeps, []string{"cp", "tool"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("want RuleParams.CommandDeps = %q, got %q", w, g)
+	}
+	if g, w := params.Implicits.Strings(), []string{"a"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("want Implicits = %q, got %q", w, g)
+	}
+}
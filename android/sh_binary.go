@@ -103,6 +103,7 @@ func (s *ShBinary) Installable() bool {
 }
 
 func (s *ShBinary) GenerateAndroidBuildActions(ctx ModuleContext) {
+	ctx.SetProvider(MakeClassProvider, "EXECUTABLES")
 	s.sourceFilePath = PathForModuleSrc(ctx, String(s.properties.Src))
 	filename := String(s.properties.Filename)
 	filename_from_src := Bool(s.properties.Filename_from_src)
@@ -148,6 +149,13 @@ func (s *ShTest) GenerateAndroidBuildActions(ctx ModuleContext) {
 	s.ShBinary.GenerateAndroidBuildActions(ctx)
 
 	s.data = PathsForModuleSrc(ctx, s.testProperties.Data)
+
+	info := TestInfo{Suites: s.testProperties.Test_suites}
+	if testConfig := OptionalPathForModuleSrc(ctx, s.testProperties.Test_config); testConfig.Valid() {
+		info.Config = testConfig.Path()
+	}
+	ctx.SetProvider(TestInfoProvider, info)
+	ctx.SetProvider(MakeClassProvider, "NATIVE_TESTS")
 }
 
 func (s *ShTest) AndroidMkEntries() AndroidMkEntries {
@@ -30,6 +30,11 @@ type SingletonContext interface {
 	ModuleType(module blueprint.Module) string
 	BlueprintFile(module blueprint.Module) string
 
+	// ModuleProvider returns the value of a provider of a module, or nil if it hasn't been set.
+	ModuleProvider(module blueprint.Module, key *ProviderKey) interface{}
+	// ModuleHasProvider returns true if a module has set a provider.
+	ModuleHasProvider(module blueprint.Module, key *ProviderKey) bool
+
 	ModuleErrorf(module blueprint.Module, format string, args ...interface{})
 	Errorf(format string, args ...interface{})
 	Failed() bool
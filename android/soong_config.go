@@ -0,0 +1,141 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// The enabled_if_soong_config property disables a module unless the vendor soong config variables of the product,
+// which are declared with SOONG_CONFIG_NAMESPACES and SOONG_CONFIG_<namespace> in Make, meet its conditions.  A
+// condition that names a namespace or variable the product doesn't declare is an error instead of a condition that
+// never holds, so that a typo doesn't silently change which modules are built.
+//
+// The modules disabled by each unmet condition are written to $OUT_DIR/soong/soong_config_disabled_modules.json,
+// which is built by the soong_config_disabled_modules phony target.
+
+func init() {
+	RegisterSingletonType("soong_config_disabled_modules", soongConfigDisabledModulesSingletonFactory)
+}
+
+const soongConfigDisabledModulesJsonFileName = "soong_config_disabled_modules.json"
+
+func registerSoongConfigEnabledMutator(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("soong_config_enabled", soongConfigEnabledMutator).Parallel()
+}
+
+func soongConfigEnabledMutator(ctx BottomUpMutatorContext) {
+	m, ok := ctx.Module().(Module)
+	if !ok {
+		return
+	}
+	base := m.base()
+
+	var disabledBy []string
+	for _, condition := range base.commonProperties.Enabled_if_soong_config {
+		holds, err := evalSoongConfigCondition(ctx.Config(), condition)
+		if err != nil {
+			ctx.PropertyErrorf("enabled_if_soong_config", "%s", err)
+			continue
+		}
+		if !holds {
+			disabledBy = append(disabledBy, condition)
+		}
+	}
+
+	// ModuleBase.Enabled returns false for the modules that have unmet conditions.
+	base.commonProperties.Soong_config_disabled_by = disabledBy
+}
+
+// evalSoongConfigCondition returns whether a condition of the enabled_if_soong_config property holds.  A condition is
+// one of <namespace>:<variable>, which holds if the variable is true, !<namespace>:<variable>, which holds if it
+// isn't, or <namespace>:<variable>=<value>, which holds if the variable is set to the value.
+func evalSoongConfigCondition(config Config, condition string) (bool, error) {
+	s := condition
+	negated := strings.HasPrefix(s, "!")
+	s = strings.TrimPrefix(s, "!")
+
+	var value string
+	hasValue := false
+	if split := strings.SplitN(s, "=", 2); len(split) == 2 {
+		s, value, hasValue = split[0], split[1], true
+	}
+
+	split := strings.Split(s, ":")
+	if len(split) != 2 || split[0] == "" || split[1] == "" || (negated && hasValue) {
+		return false, fmt.Errorf("invalid condition %q, expected <namespace>:<variable>, !<namespace>:<variable> "+
+			"or <namespace>:<variable>=<value>", condition)
+	}
+	namespace, variable := split[0], split[1]
+
+	vars, ok := config.productVariables.VendorVars[namespace]
+	if !ok {
+		return false, fmt.Errorf("soong config namespace %q in %q is not in SOONG_CONFIG_NAMESPACES",
+			namespace, condition)
+	}
+	vendorConfig := vendorConfig(vars)
+	if !vendorConfig.IsSet(variable) {
+		return false, fmt.Errorf("soong config variable %q in %q is not in SOONG_CONFIG_%s",
+			variable, condition, namespace)
+	}
+
+	if hasValue {
+		return vendorConfig.String(variable) == value, nil
+	}
+	return vendorConfig.Bool(variable) != negated, nil
+}
+
+func soongConfigDisabledModulesSingletonFactory() Singleton {
+	return &soongConfigDisabledModulesSingleton{}
+}
+
+type soongConfigDisabledModulesSingleton struct{}
+
+func (s *soongConfigDisabledModulesSingleton) GenerateBuildActions(ctx SingletonContext) {
+	// Map of unmet conditions to the modules they disabled.
+	disabled := make(map[string][]string)
+	ctx.VisitAllModules(func(module Module) {
+		name := "//" + ctx.ModuleDir(module) + ":" + ctx.ModuleName(module)
+		for _, condition := range module.base().commonProperties.Soong_config_disabled_by {
+			if !InList(name, disabled[condition]) {
+				disabled[condition] = append(disabled[condition], name)
+			}
+		}
+	})
+
+	for _, modules := range disabled {
+		sort.Strings(modules)
+	}
+
+	// json.Marshal sorts the keys of maps.
+	buf, err := json.MarshalIndent(disabled, "", "\t")
+	if err != nil {
+		ctx.Errorf("failed to marshal soong config disabled modules: %s", err)
+		return
+	}
+
+	file := PathForOutput(ctx, soongConfigDisabledModulesJsonFileName)
+	WriteFileRule(ctx, file, string(buf))
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_config_disabled_modules"),
+		Input:  file,
+	})
+}
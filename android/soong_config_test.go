@@ -0,0 +1,158 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func testSoongConfig(t *testing.T, bp string) (*TestContext, []error) {
+	t.Helper()
+
+	config := TestArchConfig(buildDir, nil)
+	config.TestProductVariables.VendorVars = map[string]map[string]string{
+		"acme": {
+			"feature":  "true",
+			"disabled": "",
+			"board":    "rocket",
+		},
+	}
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("test_module", ModuleFactoryAdaptor(newTestModule))
+	ctx.RegisterModuleType("test_arch_module", ModuleFactoryAdaptor(metadataTestModuleFactory))
+	ctx.PreArchMutators(registerSoongConfigEnabledMutator)
+	ctx.RegisterSingletonType("soong_config_disabled_modules",
+		SingletonFactoryAdaptor(soongConfigDisabledModulesSingletonFactory))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(bp),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp")
+	if len(errs) > 0 {
+		return ctx, errs
+	}
+	_, errs = ctx.PrepareBuildActions(config)
+	return ctx, errs
+}
+
+func TestSoongConfigEnabled(t *testing.T) {
+	ctx, errs := testSoongConfig(t, `
+		test_module {
+			name: "unconditional",
+		}
+
+		test_module {
+			name: "feature",
+			enabled_if_soong_config: ["acme:feature"],
+		}
+
+		test_module {
+			name: "not_feature",
+			enabled_if_soong_config: ["!acme:feature"],
+		}
+
+		test_module {
+			name: "disabled",
+			enabled_if_soong_config: ["acme:disabled"],
+		}
+
+		test_module {
+			name: "board",
+			enabled_if_soong_config: ["acme:board=rocket", "!acme:disabled"],
+		}
+
+		test_module {
+			name: "other_board",
+			enabled_if_soong_config: ["acme:feature", "acme:board=sled"],
+		}
+
+		test_arch_module {
+			name: "target_enabled",
+			enabled_if_soong_config: ["acme:disabled"],
+			target: {
+				android: {
+					enabled: true,
+				},
+			},
+		}
+	`)
+	FailIfErrored(t, errs)
+
+	testCases := []struct {
+		name    string
+		enabled bool
+	}{
+		{"unconditional", true},
+		{"feature", true},
+		{"not_feature", false},
+		{"disabled", false},
+		{"board", true},
+		{"other_board", false},
+	}
+	for _, test := range testCases {
+		if g := ctx.ModuleForTests(test.name, "").Module().Enabled(); g != test.enabled {
+			t.Errorf("expected %s enabled %t, got %t", test.name, test.enabled, g)
+		}
+	}
+
+	// The arch variant enabled property can't enable a module that an unmet condition disabled.
+	if ctx.ModuleForTests("target_enabled", "android_arm64_armv8-a").Module().Enabled() {
+		t.Errorf("expected target_enabled to be disabled")
+	}
+
+	content := ContentFromFileRuleForTests(t,
+		ctx.SingletonForTests("soong_config_disabled_modules").Output(soongConfigDisabledModulesJsonFileName))
+	var disabled map[string][]string
+	if err := json.Unmarshal([]byte(content), &disabled); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"!acme:feature":   {"//.:not_feature"},
+		"acme:disabled":   {"//.:disabled", "//.:target_enabled"},
+		"acme:board=sled": {"//.:other_board"},
+	}
+	if !reflect.DeepEqual(disabled, want) {
+		t.Errorf("want %q, got %q", want, disabled)
+	}
+}
+
+func TestSoongConfigEnabledErrors(t *testing.T) {
+	testCases := []struct {
+		condition string
+		err       string
+	}{
+		{"acme", `invalid condition "acme"`},
+		{"!acme:feature=true", `invalid condition "!acme:feature=true"`},
+		{"acmee:feature", `soong config namespace "acmee" in "acmee:feature" is not in SOONG_CONFIG_NAMESPACES`},
+		{"acme:featuer", `soong config variable "featuer" in "acme:featuer" is not in SOONG_CONFIG_acme`},
+	}
+	for _, test := range testCases {
+		t.Run(test.condition, func(t *testing.T) {
+			_, errs := testSoongConfig(t, `
+				test_module {
+					name: "foo",
+					enabled_if_soong_config: ["`+test.condition+`"],
+				}
+			`)
+			FailIfNoMatchingErrors(t, `enabled_if_soong_config: `+regexp.QuoteMeta(test.err), errs)
+		})
+	}
+}
@@ -31,7 +31,7 @@ func NewTestContext() *TestContext {
 
 	nameResolver := NewNameResolver(namespaceExportFilter)
 	ctx := &TestContext{
-		Context:      &Context{blueprint.NewContext()},
+		Context:      &Context{Context: blueprint.NewContext()},
 		NameResolver: nameResolver,
 	}
 
@@ -52,8 +52,8 @@ func NewTestArchContext() *TestContext {
 
 type TestContext struct {
 	*Context
-	preArch, preDeps, postDeps []RegisterMutatorFunc
-	NameResolver               *NameResolver
+	preArch, preDeps, postDeps, finalDeps []RegisterMutatorFunc
+	NameResolver                          *NameResolver
 }
 
 func (ctx *TestContext) PreArchMutators(f RegisterMutatorFunc) {
@@ -68,8 +68,12 @@ func (ctx *TestContext) PostDepsMutators(f RegisterMutatorFunc) {
 	ctx.postDeps = append(ctx.postDeps, f)
 }
 
+func (ctx *TestContext) FinalDepsMutators(f RegisterMutatorFunc) {
+	ctx.finalDeps = append(ctx.finalDeps, f)
+}
+
 func (ctx *TestContext) Register() {
-	registerMutators(ctx.Context.Context, ctx.preArch, ctx.preDeps, ctx.postDeps)
+	registerMutators(ctx.Context.Context, ctx.preArch, ctx.preDeps, ctx.postDeps, ctx.finalDeps)
 
 	ctx.RegisterSingletonType("env", SingletonFactoryAdaptor(EnvSingleton))
 }
@@ -245,6 +249,11 @@ func (m TestingModule) Module() Module {
 	return m.module
 }
 
+// Provider returns the value of a provider of the module, or nil if it hasn't been set.
+func (m TestingModule) Provider(key *ProviderKey) interface{} {
+	return moduleProvider(m.module, key)
+}
+
 // MaybeRule finds a call to ctx.Build with BuildParams.Rule set to a rule with the given name.  Returns an empty
 // BuildParams if no rule is found.
 func (m TestingModule) MaybeRule(rule string) TestingBuildParams {
@@ -382,3 +391,16 @@ func AndroidMkEntriesForTest(t *testing.T, config Config, bpPath string, mod blu
 	entries.fillInEntries(config, bpPath, mod)
 	return entries
 }
+
+// ContentFromFileRuleForTests returns the content of a file written by WriteFileRule.
+func ContentFromFileRuleForTests(t *testing.T, params TestingBuildParams) string {
+	t.Helper()
+	if params.Rule != writeFile {
+		t.Errorf("expected the file to be written by WriteFileRule, got rule %q", params.Rule)
+		return ""
+	}
+	content := strings.Replace(params.Args["content"], "$$", "$", -1)
+	content = strings.TrimSuffix(strings.TrimPrefix(content, "'"), "'")
+	content = strings.Replace(content, `'\''`, "'", -1)
+	return echoUnescaper.Replace(content)
+}
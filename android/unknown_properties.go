@@ -0,0 +1,205 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/proptools"
+)
+
+// Blueprint reports a property that the module type of a module doesn't have with an error that only names the
+// property.  That is enough for a typo, but a property copied from a module of another type, like dex_preopt from an
+// android_app to a java_library, is easier to fix when the error says which module types do have it.
+//
+// The module types that have each property are recorded as the module types are registered.  The parse errors are
+// annotated by the ParseBlueprintsFiles and ParseFileList methods of Context, and for bootstrap.Main, which parses
+// the Blueprints files with the Blueprint context directly, by the AnnotateParseErrors hook of the config.
+
+// moduleTypeProperties maps the top-level property names to the sorted names of the module types that have them.
+type moduleTypeProperties map[string][]string
+
+// maxListedModuleTypes is the number of module types listed in an unrecognized property error before the rest are
+// only counted.
+const maxListedModuleTypes = 5
+
+var unrecognizedPropertyRegexp = regexp.MustCompile(`^unrecognized property "([^".]+)"$`)
+
+// RegisterModuleType registers a module type like blueprint.Context.RegisterModuleType, and records the top-level
+// properties of the module type for the unrecognized property errors.
+func (ctx *Context) RegisterModuleType(name string, factory blueprint.ModuleFactory) {
+	if ctx.moduleTypeProperties == nil {
+		ctx.moduleTypeProperties = make(moduleTypeProperties)
+	}
+	_, props := factory()
+	ctx.moduleTypeProperties.add(name, props)
+	ctx.Context.RegisterModuleType(name, factory)
+}
+
+// add records the top-level properties of the property structs of a module type.
+func (p moduleTypeProperties) add(moduleType string, props []interface{}) {
+	seen := make(map[string]bool)
+	for _, prop := range props {
+		for _, property := range topLevelPropertyNames(reflect.ValueOf(prop)) {
+			if !seen[property] {
+				seen[property] = true
+				moduleTypes := append(p[property], moduleType)
+				sort.Strings(moduleTypes)
+				p[property] = moduleTypes
+			}
+		}
+	}
+}
+
+// topLevelPropertyNames returns the names of the properties that can be set in a property struct, including the ones
+// of embedded structs.
+func topLevelPropertyNames(v reflect.Value) []string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var names []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous {
+			names = append(names, topLevelPropertyNames(v.Field(i))...)
+			continue
+		}
+		if field.PkgPath != "" || proptools.HasTag(field, "blueprint", "mutated") {
+			continue
+		}
+		names = append(names, proptools.PropertyNameForField(field.Name))
+	}
+	return names
+}
+
+// AnnotateUnknownPropertyErrors replaces the unrecognized property errors in errs with errors that list the module
+// types that have the property.
+func (ctx *Context) AnnotateUnknownPropertyErrors(errs []error) {
+	ctx.moduleTypeProperties.annotateErrors(errs)
+}
+
+// annotateErrors replaces the unrecognized property errors in errs with errors that list the module types that have
+// the property.  Each Blueprints file with an unrecognized property is parsed again once, to find the type of the
+// module that sets it.
+func (p moduleTypeProperties) annotateErrors(errs []error) {
+	files := make(map[string]*parser.File)
+	for i, err := range errs {
+		if bpErr, ok := err.(*blueprint.BlueprintError); ok {
+			if annotated := p.annotate(bpErr, files); annotated != nil {
+				errs[i] = annotated
+			}
+		}
+	}
+}
+
+func (p moduleTypeProperties) annotate(err *blueprint.BlueprintError, files map[string]*parser.File) *blueprint.BlueprintError {
+	match := unrecognizedPropertyRegexp.FindStringSubmatch(err.Err.Error())
+	if match == nil {
+		return nil
+	}
+	property := match[1]
+	moduleTypes := p[property]
+	if len(moduleTypes) == 0 {
+		return nil
+	}
+
+	listed := moduleTypes
+	if len(listed) > maxListedModuleTypes {
+		listed = listed[:maxListedModuleTypes]
+	}
+	msg := fmt.Sprintf("unrecognized property %q, it is valid for %s", property, strings.Join(listed, ", "))
+	if len(moduleTypes) > len(listed) {
+		msg += fmt.Sprintf(" and %d other module types", len(moduleTypes)-len(listed))
+	}
+
+	file, parsed := files[err.Pos.Filename]
+	if !parsed {
+		file = parseBlueprintsFile(err.Pos.Filename)
+		files[err.Pos.Filename] = file
+	}
+	if moduleType := moduleTypeAt(file, err.Pos); moduleType != "" {
+		msg += " but not " + moduleType
+	}
+
+	return &blueprint.BlueprintError{
+		Err: errors.New(msg),
+		Pos: err.Pos,
+	}
+}
+
+// moduleTypeAt returns the type of the module of file defined at pos, or "" if there is none.
+func moduleTypeAt(file *parser.File, pos scanner.Position) string {
+	if file == nil {
+		return ""
+	}
+	notAfter := func(a, b scanner.Position) bool {
+		return a.Line < b.Line || a.Line == b.Line && a.Column <= b.Column
+	}
+	for _, def := range file.Defs {
+		if module, ok := def.(*parser.Module); ok {
+			if notAfter(module.LBracePos, pos) && notAfter(pos, module.RBracePos) {
+				return module.Type
+			}
+		}
+	}
+	return ""
+}
+
+// parseBlueprintsFile parses a Blueprints file without evaluating it, or returns nil if it can't be parsed.
+func parseBlueprintsFile(filename string) *parser.File {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	file, errs := parser.Parse(filename, f, parser.NewScope(nil))
+	if len(errs) > 0 {
+		return nil
+	}
+	return file
+}
+
+// ParseBlueprintsFiles parses the Blueprints files like blueprint.Context.ParseBlueprintsFiles, and adds the module
+// types that have a property to the errors for unrecognized properties.
+func (ctx *Context) ParseBlueprintsFiles(rootFile string) (deps []string, errs []error) {
+	deps, errs = ctx.Context.ParseBlueprintsFiles(rootFile)
+	ctx.AnnotateUnknownPropertyErrors(errs)
+	return deps, errs
+}
+
+// ParseFileList parses the Blueprints files like blueprint.Context.ParseFileList, and adds the module types that have
+// a property to the errors for unrecognized properties.
+func (ctx *Context) ParseFileList(rootDir string, filePaths []string) (deps []string, errs []error) {
+	deps, errs = ctx.Context.ParseFileList(rootDir, filePaths)
+	ctx.AnnotateUnknownPropertyErrors(errs)
+	return deps, errs
+}
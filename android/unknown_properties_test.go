@@ -0,0 +1,141 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"github.com/google/blueprint"
+)
+
+type unknownPropertiesTestModule struct {
+	ModuleBase
+	properties struct {
+		Dex_preopt struct {
+			Enabled *bool
+		}
+		Installed bool `blueprint:"mutated"`
+	}
+}
+
+func unknownPropertiesTestModuleFactory() Module {
+	m := &unknownPropertiesTestModule{}
+	m.AddProperties(&m.properties)
+	InitAndroidModule(m)
+	return m
+}
+
+func (m *unknownPropertiesTestModule) GenerateAndroidBuildActions(ctx ModuleContext) {}
+
+func TestModuleTypeProperties(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("test_library", ModuleFactoryAdaptor(unknownPropertiesTestModuleFactory))
+	ctx.RegisterModuleType("test_other", ModuleFactoryAdaptor(newTestModule))
+	ctx.RegisterModuleType("test_app", ModuleFactoryAdaptor(unknownPropertiesTestModuleFactory))
+	p := ctx.moduleTypeProperties
+
+	if g, w := p["dex_preopt"], []string{"test_app", "test_library"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected dex_preopt module types %q, got %q", w, g)
+	}
+	if g, w := p["name"], []string{"test_app", "test_library", "test_other"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected name module types %q, got %q", w, g)
+	}
+	if g := p["installed"]; g != nil {
+		t.Errorf("expected mutated property installed not to be listed, got %q", g)
+	}
+}
+
+func TestUnrecognizedPropertyParseErrors(t *testing.T) {
+	ctx := NewTestContext()
+	ctx.RegisterModuleType("test_app", ModuleFactoryAdaptor(unknownPropertiesTestModuleFactory))
+	ctx.RegisterModuleType("test_other", ModuleFactoryAdaptor(newTestModule))
+	ctx.Register()
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test_other {
+				name: "foo",
+				dex_preopt: {
+					enabled: false,
+				},
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp")
+	FailIfNoMatchingErrors(t, `unrecognized property "dex_preopt", it is valid for test_app`, errs)
+}
+
+func TestUnrecognizedPropertyErrors(t *testing.T) {
+	bp := `
+		test_library {
+			name: "foo",
+			dex_preopt: {
+				enabled: false,
+			},
+		}
+	`
+	file := filepath.Join(buildDir, "unknown_properties.bp")
+	if err := ioutil.WriteFile(file, []byte(bp), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	p := moduleTypeProperties{
+		"dex_preopt": []string{"android_app", "android_test"},
+		"many":       []string{"a", "b", "c", "d", "e", "f", "g"},
+	}
+
+	unrecognized := func(property string, pos scanner.Position) error {
+		return &blueprint.BlueprintError{
+			Err: errors.New(`unrecognized property "` + property + `"`),
+			Pos: pos,
+		}
+	}
+	pos := scanner.Position{Filename: file, Offset: strings.Index(bp, "dex_preopt"), Line: 4, Column: 4}
+
+	errs := []error{
+		unrecognized("dex_preopt", pos),
+		unrecognized("dex_preopt", scanner.Position{Filename: filepath.Join(buildDir, "missing.bp")}),
+		unrecognized("many", scanner.Position{}),
+		unrecognized("typo", pos),
+		unrecognized("dex_preopt.typo", pos),
+		errors.New("other error"),
+	}
+	p.annotateErrors(errs)
+
+	want := []string{
+		`unrecognized property "dex_preopt", it is valid for android_app, android_test but not test_library`,
+		`unrecognized property "dex_preopt", it is valid for android_app, android_test`,
+		`unrecognized property "many", it is valid for a, b, c, d, e and 2 other module types`,
+		`unrecognized property "typo"`,
+		`unrecognized property "dex_preopt.typo"`,
+		`other error`,
+	}
+	for i, err := range errs {
+		g := err.Error()
+		if bpErr, ok := err.(*blueprint.BlueprintError); ok {
+			g = bpErr.Err.Error()
+		}
+		if g != want[i] {
+			t.Errorf("expected error %q, got %q", want[i], g)
+		}
+	}
+}
@@ -138,6 +138,7 @@ type productVariables struct {
 	BuildId             *string `json:",omitempty"`
 	BuildNumberFromFile *string `json:",omitempty"`
 	DateFromFile        *string `json:",omitempty"`
+	BuildNumberFile     *string `json:",omitempty"`
 
 	Platform_version_name                     *string  `json:",omitempty"`
 	Platform_sdk_version                      *int     `json:",omitempty"`
@@ -186,15 +187,18 @@ type productVariables struct {
 	ProductResourceOverlays    []string `json:",omitempty"`
 	EnforceRROTargets          []string `json:",omitempty"`
 	EnforceRROExcludedOverlays []string `json:",omitempty"`
+	EnforceRROOverlayable      *bool    `json:",omitempty"`
 
 	AAPTCharacteristics *string  `json:",omitempty"`
 	AAPTConfig          []string `json:",omitempty"`
 	AAPTPreferredConfig *string  `json:",omitempty"`
 	AAPTPrebuiltDPI     []string `json:",omitempty"`
 
-	DefaultAppCertificate *string `json:",omitempty"`
+	DefaultAppCertificate    *string `json:",omitempty"`
+	DefaultAppCertificateDir *string `json:",omitempty"`
 
 	AppsDefaultVersionName *string `json:",omitempty"`
+	AppsVersionCodeOffset  *int    `json:",omitempty"`
 
 	Allow_missing_dependencies       *bool `json:",omitempty"`
 	Unbundled_build                  *bool `json:",omitempty"`
@@ -216,6 +220,8 @@ type productVariables struct {
 
 	Check_elf_files *bool `json:",omitempty"`
 
+	MaxVariantDirLength *int `json:",omitempty"`
+
 	UncompressPrivAppDex             *bool    `json:",omitempty"`
 	ModulesLoadedByPrivilegedModules []string `json:",omitempty"`
 
@@ -288,11 +294,19 @@ type productVariables struct {
 
 	FlattenApex *bool `json:",omitempty"`
 
-	DexpreoptGlobalConfig *string `json:",omitempty"`
+	DexpreoptGlobalConfig *string  `json:",omitempty"`
+	DexpreoptInSoong      *bool    `json:",omitempty"`
+	RuntimeApexJars       []string `json:",omitempty"`
 
 	ManifestPackageNameOverrides []string `json:",omitempty"`
 	CertificateOverrides         []string `json:",omitempty"`
 	PackageNameOverrides         []string `json:",omitempty"`
+	VersionCodeOverrides         []string `json:",omitempty"`
+	VersionNameOverrides         []string `json:",omitempty"`
+
+	JavaWerrorPolicies []string `json:",omitempty"`
+
+	BuildBudgets []string `json:",omitempty"`
 
 	EnforceSystemCertificate          *bool    `json:",omitempty"`
 	EnforceSystemCertificateWhitelist []string `json:",omitempty"`
@@ -308,6 +322,8 @@ type productVariables struct {
 	TargetFSConfigGen []string `json:",omitempty"`
 
 	MissingUsesLibraries []string `json:",omitempty"`
+
+	DefaultTestSuites []string `json:",omitempty"`
 }
 
 func boolPtr(v bool) *bool {
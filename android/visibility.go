@@ -17,6 +17,7 @@ package android
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -28,7 +29,10 @@ import (
 //   create visibilityRule structures and store them in a map keyed by the module's
 //   qualifiedModuleName instance, i.e. //<pkg>:<name>. The map is stored in the context rather
 //   than a global variable for testing. Each test has its own Config so they do not share a map
-//   and so can be run in parallel.
+//   and so can be run in parallel. The rules are canonicalized and compiled into a compiledRule
+//   that is shared between all the modules whose visibility resolves to the same set of rules.
+//   package_group modules are parsed in the same stage and stored in a separate map, so that
+//   the rules referencing them can look up their packages in the second stage.
 //
 // * Second stage works top down and iterates over all the deps for each module. If the dep is in
 //   the same package then it is automatically visible. Otherwise, for each dep it first extracts
@@ -36,8 +40,21 @@ import (
 //   publicly visible. Otherwise, it calls the visibility rule to check that the module can see
 //   the dependency. If it cannot then an error is reported.
 //
-// TODO(b/130631145) - Make visibility work properly with prebuilts.
-// TODO(b/130796911) - Make visibility work properly with defaults.
+// The deps include the ones that the path deps mutator adds for ":module" references in srcs,
+// data and the other properties tagged with android:"path", so the files of a module are exactly
+// as visible as the module.  The error about such a dep is reported on the properties that
+// contain the reference.
+//
+// While visibility is rolled out, the violations can be reported as warnings instead of errors, see
+// visibility_baseline.go.
+//
+// A prebuilt that doesn't specify its own visibility inherits the visibility of the source module
+// with the same name in the first stage, so a dependency that is replaced with the prebuilt is
+// checked against the same rules as a dependency on the source module.
+//
+// The visibility of defaults modules is prepended to the visibility of the modules that use them
+// by the defaults mutator, which also records the defaults module each inherited rule came from
+// so that the errors about the combined rules can point at them.
 
 // Patterns for the values that can be specified in visibility property.
 const (
@@ -55,6 +72,11 @@ type qualifiedModuleName struct {
 
 	// The name of the module.
 	name string
+
+	// The path of the soong_namespace that contains the module.  It is only set for the module that
+	// depends on another module, to match __namespace__ rules, and not in the keys of the
+	// visibility rule map.
+	namespace string
 }
 
 func (q qualifiedModuleName) String() string {
@@ -82,18 +104,26 @@ type visibilityRule interface {
 // defaults expansion to make that work. No non-private visibility rules are allowed in a
 // compositeRule containing a privateRule.
 //
+// An exclusionRule removes packages from the ones matched by the other rules, so it is only allowed
+// together with other non-private rules.
+//
 // This array will only be [] if all the rules are invalid and will behave as if visibility was
 // ["//visibility:private"].
 type compositeRule []visibilityRule
 
-// A compositeRule matches if and only if any of its rules matches.
+// A compositeRule matches if and only if any of its rules matches and none of its exclusions do.
 func (c compositeRule) matches(m qualifiedModuleName) bool {
+	matched := false
 	for _, r := range c {
-		if r.matches(m) {
-			return true
+		if _, ok := r.(exclusionRule); ok {
+			if r.matches(m) {
+				return false
+			}
+		} else if r.matches(m) {
+			matched = true
 		}
 	}
-	return false
+	return matched
 }
 
 func (r compositeRule) String() string {
@@ -136,6 +166,21 @@ func (r subpackagesRule) String() string {
 	return fmt.Sprintf("//%s:__subpackages__", r.pkgPrefix)
 }
 
+// A namespaceRule is a visibility rule that matches modules in the soong_namespace declared in a
+// specific package.  Unlike a subpackagesRule on the same package it doesn't match the modules in
+// the namespaces nested in it, and it matches every package of the namespace however deep it is.
+type namespaceRule struct {
+	namespace string
+}
+
+func (r namespaceRule) matches(m qualifiedModuleName) bool {
+	return m.namespace == r.namespace
+}
+
+func (r namespaceRule) String() string {
+	return fmt.Sprintf("//%s:__namespace__", r.namespace)
+}
+
 // visibilityRule for //visibility:public
 type publicRule struct{}
 
@@ -158,15 +203,258 @@ func (r privateRule) String() string {
 	return "//visibility:private"
 }
 
+// An exclusionRule is a visibility rule like -//foo/tests or -//foo/tests:__subpackages__ that
+// removes the packages matched by a packageRule or a subpackagesRule from the packages matched by
+// the other rules of a compositeRule.  It matches the modules that it excludes.
+type exclusionRule struct {
+	rule visibilityRule
+}
+
+func (r exclusionRule) matches(m qualifiedModuleName) bool {
+	return r.rule.matches(m)
+}
+
+func (r exclusionRule) String() string {
+	return "-" + r.rule.String()
+}
+
+// pkg returns the excluded package, or the root of the excluded packages.
+func (r exclusionRule) pkg() string {
+	if s, ok := r.rule.(subpackagesRule); ok {
+		return s.pkgPrefix
+	}
+	return r.rule.(packageRule).pkg
+}
+
+// splitExclusion returns the rule expression of a visibility rule without the '-' of an exclusion,
+// and whether it is an exclusion.
+func splitExclusion(ruleExpression string) (string, bool) {
+	if strings.HasPrefix(ruleExpression, "-") {
+		return ruleExpression[1:], true
+	}
+	return ruleExpression, false
+}
+
+// canonicalizeRules returns an equivalent compositeRule with duplicate rules removed, rules that are
+// implied by other rules removed, and the remaining rules sorted, so that lists of rules that allow the
+// same packages produce the same compositeRule.
+func canonicalizeRules(rules compositeRule) compositeRule {
+	var subpackages, packages, namespaces []string
+	var groups, exclusions compositeRule
+	public := false
+	for _, r := range rules {
+		switch r := r.(type) {
+		case publicRule:
+			public = true
+		case packageRule:
+			packages = append(packages, r.pkg)
+		case subpackagesRule:
+			subpackages = append(subpackages, r.pkgPrefix)
+		case namespaceRule:
+			namespaces = append(namespaces, r.namespace)
+		case groupRule:
+			groups = append(groups, r)
+		case exclusionRule:
+			exclusions = append(exclusions, r)
+		}
+	}
+
+	// Exclusions are only deduplicated, like group rules below.
+	exclusions = sortedUniqueRules(exclusions)
+
+	if public {
+		return append(compositeRule{publicRule{}}, exclusions...)
+	}
+
+	if len(subpackages) == 0 && len(packages) == 0 && len(namespaces) == 0 && len(groups) == 0 {
+		// Either empty or only contains //visibility:private.
+		if len(rules) > 0 {
+			return compositeRule{privateRule{}}
+		}
+		return compositeRule{}
+	}
+
+	// Sorting puts every package before its subpackages, so each subpackages rule only needs to
+	// be compared against the rules that were kept before it.
+	sort.Strings(subpackages)
+	trie := &packageTrie{}
+	canonical := make(compositeRule, 0, len(rules))
+	for _, pkg := range subpackages {
+		if !trie.matches(pkg) {
+			trie.insert(pkg)
+			canonical = append(canonical, subpackagesRule{pkg})
+		}
+	}
+
+	packages = FirstUniqueStrings(packages)
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		if !trie.matches(pkg) {
+			canonical = append(canonical, packageRule{pkg})
+		}
+	}
+
+	// A namespace can contain packages outside of any subpackages rule, so namespace rules are only
+	// deduplicated.
+	namespaces = FirstUniqueStrings(namespaces)
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		canonical = append(canonical, namespaceRule{namespace})
+	}
+
+	// The packages of a group are only known once all the package_group modules have been
+	// gathered, so group rules are only deduplicated too.
+	canonical = append(canonical, sortedUniqueRules(groups)...)
+
+	return append(canonical, exclusions...)
+}
+
+// sortedUniqueRules returns the rules sorted by their string form without duplicates.
+func sortedUniqueRules(rules compositeRule) compositeRule {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].String() < rules[j].String()
+	})
+	unique := make(compositeRule, 0, len(rules))
+	for i, r := range rules {
+		if i == 0 || r.String() != rules[i-1].String() {
+			unique = append(unique, r)
+		}
+	}
+	return unique
+}
+
+// A packageTrie stores package prefixes by path component, and matches any package that is equal
+// to or a subpackage of one of the stored prefixes.
+type packageTrie struct {
+	children map[string]*packageTrie
+	terminal bool
+}
+
+func (t *packageTrie) insert(pkg string) {
+	node := t
+	for _, component := range strings.Split(pkg, "/") {
+		child := node.children[component]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*packageTrie)
+			}
+			child = &packageTrie{}
+			node.children[component] = child
+		}
+		node = child
+	}
+	node.terminal = true
+}
+
+// matches returns true if pkg or any of its ancestors was inserted into the trie.
+func (t *packageTrie) matches(pkg string) bool {
+	node := t
+	for _, component := range strings.Split(pkg, "/") {
+		node = node.children[component]
+		if node == nil {
+			return false
+		}
+		if node.terminal {
+			return true
+		}
+	}
+	return false
+}
+
+// A compiledRule is a canonical compositeRule compiled into a form that can be matched without
+// iterating over each of the atomic rules.
+type compiledRule struct {
+	rules       compositeRule
+	public      bool
+	packages    map[string]bool
+	subpackages packageTrie
+	namespaces  map[string]bool
+	groups      []groupRule
+
+	excludedPackages    map[string]bool
+	excludedSubpackages packageTrie
+}
+
+func compileRules(rules compositeRule) *compiledRule {
+	c := &compiledRule{
+		rules:            rules,
+		packages:         make(map[string]bool),
+		namespaces:       make(map[string]bool),
+		excludedPackages: make(map[string]bool),
+	}
+	for _, r := range rules {
+		switch r := r.(type) {
+		case publicRule:
+			c.public = true
+		case packageRule:
+			c.packages[r.pkg] = true
+		case subpackagesRule:
+			c.subpackages.insert(r.pkgPrefix)
+		case namespaceRule:
+			c.namespaces[r.namespace] = true
+		case groupRule:
+			c.groups = append(c.groups, r)
+		case exclusionRule:
+			switch e := r.rule.(type) {
+			case packageRule:
+				c.excludedPackages[e.pkg] = true
+			case subpackagesRule:
+				c.excludedSubpackages.insert(e.pkgPrefix)
+			}
+		}
+	}
+	return c
+}
+
+func (c *compiledRule) matches(m qualifiedModuleName) bool {
+	if c.excludedPackages[m.pkg] || c.excludedSubpackages.matches(m.pkg) {
+		return false
+	}
+	if c.public || c.packages[m.pkg] || c.subpackages.matches(m.pkg) ||
+		(m.namespace != "" && c.namespaces[m.namespace]) {
+		return true
+	}
+	for _, group := range c.groups {
+		if group.matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledRule) String() string {
+	return c.rules.String()
+}
+
 var visibilityRuleMap = NewOnceKey("visibilityRuleMap")
+var compiledVisibilityRuleMap = NewOnceKey("compiledVisibilityRuleMap")
 
-// The map from qualifiedModuleName to visibilityRule.
-func moduleToVisibilityRuleMap(ctx BaseModuleContext) *sync.Map {
-	return ctx.Config().Once(visibilityRuleMap, func() interface{} {
+// The map from qualifiedModuleName to the compiled visibilityRule.
+func moduleToVisibilityRuleMap(config Config) *sync.Map {
+	return config.Once(visibilityRuleMap, func() interface{} {
 		return &sync.Map{}
 	}).(*sync.Map)
 }
 
+// The map from the string form of a canonical compositeRule to its compiledRule.
+func compiledVisibilityRules(config Config) *sync.Map {
+	return config.Once(compiledVisibilityRuleMap, func() interface{} {
+		return &sync.Map{}
+	}).(*sync.Map)
+}
+
+// compiledVisibilityRule returns the compiledRule for rules, sharing it with any other module whose
+// rules have the same canonical form.
+func compiledVisibilityRule(config Config, rules compositeRule) *compiledRule {
+	canonical := canonicalizeRules(rules)
+	key := canonical.String()
+	if rule, ok := compiledVisibilityRules(config).Load(key); ok {
+		return rule.(*compiledRule)
+	}
+	rule, _ := compiledVisibilityRules(config).LoadOrStore(key, compileRules(canonical))
+	return rule.(*compiledRule)
+}
+
 // The rule checker needs to be registered before defaults expansion to correctly check that
 // //visibility:xxx isn't combined with other packages in the same list in any one module.
 func registerVisibilityRuleChecker(ctx RegisterMutatorsContext) {
@@ -214,8 +502,17 @@ func checkRules(ctx BottomUpMutatorContext, currentPkg string, visibility []stri
 		return
 	}
 
+	// Exclusions don't count as rules that //visibility:public and //visibility:private can't be
+	// mixed with, they are checked against the other rules after defaults expansion.
 	for _, v := range visibility {
-		ok, pkg, name := splitRule(ctx, v, currentPkg)
+		if _, exclusion := splitExclusion(v); exclusion {
+			ruleCount--
+		}
+	}
+
+	for i, v := range visibility {
+		expression, exclusion := splitExclusion(v)
+		ok, pkg, name := splitRule(ctx, expression, currentPkg)
 		if !ok {
 			// Visibility rule is invalid so ignore it. Keep going rather than aborting straight away to
 			// ensure all the rules on this module are checked.
@@ -226,22 +523,47 @@ func checkRules(ctx BottomUpMutatorContext, currentPkg string, visibility []stri
 			continue
 		}
 
+		if exclusion {
+			if pkg == "visibility" || (name != "__pkg__" && name != "__subpackages__") {
+				ctx.PropertyErrorf("visibility", "invalid exclusion %q must match -//<package>, "+
+					"-//<package>:__pkg__ or -//<package>:__subpackages__", v)
+			}
+			// Excluding packages in //vendor doesn't make a module visible to them.
+			continue
+		}
+
 		if pkg == "visibility" {
 			switch name {
 			case "private", "public":
 			case "legacy_public":
 				ctx.PropertyErrorf("visibility", "//visibility:legacy_public must not be used")
 				continue
+			case "override":
+				// It doesn't create a rule, so it doesn't count as one of the rules that
+				// //visibility:public and //visibility:private can't be mixed with.
+				ruleCount--
 			default:
 				ctx.PropertyErrorf("visibility", "unrecognized visibility rule %q", v)
 				continue
 			}
-			if ruleCount != 1 {
+			if name == "override" {
+				if i != 0 {
+					ctx.PropertyErrorf("visibility",
+						"%q may only be used at the start of the visibility rules", v)
+				}
+				continue
+			} else if ruleCount != 1 {
 				ctx.PropertyErrorf("visibility", "cannot mix %q with any other visibility rules", v)
 				continue
 			}
 		}
 
+		if name == "__namespace__" && !namespaceDeclaredAt(ctx, pkg) {
+			ctx.PropertyErrorf("visibility",
+				"%q refers to //%s, which doesn't declare a soong_namespace", v, pkg)
+			continue
+		}
+
 		// If the current directory is not in the vendor tree then there are some additional
 		// restrictions on the rules.
 		if !isAncestor("vendor", currentPkg) {
@@ -255,6 +577,18 @@ func checkRules(ctx BottomUpMutatorContext, currentPkg string, visibility []stri
 	}
 }
 
+// Returns true if a soong_namespace is declared in the pkg directory. If the module's namespace
+// wasn't created by a NameResolver there is nothing to check against so any directory is accepted.
+func namespaceDeclaredAt(ctx BaseModuleContext, pkg string) bool {
+	namespace, ok := ctx.Namespace().(*Namespace)
+	if !ok || namespace.resolver == nil {
+		return true
+	}
+	// Lookups of directories without a namespace cache the enclosing one, so compare the path.
+	declared, found := namespace.resolver.namespaceAt(pkg)
+	return found && declared.Path == pkg
+}
+
 // Gathers the flattened visibility rules after defaults expansion, parses the visibility
 // properties, stores them in a map by qualifiedModuleName for retrieval during enforcement.
 //
@@ -267,25 +601,58 @@ func visibilityRuleGatherer(ctx BottomUpMutatorContext) {
 
 	qualified := createQualifiedModuleName(ctx)
 
+	if g, ok := m.(*packageGroupModule); ok {
+		gatherPackageGroup(ctx, g, qualified)
+	}
+
 	visibility := m.base().commonProperties.Visibility
 	if visibility != nil {
 		rule := parseRules(ctx, qualified.pkg, visibility)
 		if rule != nil {
-			moduleToVisibilityRuleMap(ctx).Store(qualified, rule)
+			compiled := compiledVisibilityRule(ctx.Config(), rule)
+			moduleToVisibilityRuleMap(ctx.Config()).Store(qualified, compiled)
+			inheritPrebuiltVisibility(ctx, qualified, compiled)
 		}
 	}
 }
 
+// inheritPrebuiltVisibility stores the visibility rule of a source module for its prebuilts that
+// don't specify their own visibility.  The source module's package is added to the rule, as the
+// prebuilt may be defined in another package than the modules that could depend on the source
+// module because they were in its package.
+func inheritPrebuiltVisibility(ctx BottomUpMutatorContext, source qualifiedModuleName, rule *compiledRule) {
+	ctx.VisitDirectDepsWithTag(prebuiltDepTag, func(prebuilt Module) {
+		if prebuilt.base().commonProperties.Visibility != nil {
+			return
+		}
+		qualified := qualifiedModuleName{pkg: ctx.OtherModuleDir(prebuilt), name: ctx.OtherModuleName(prebuilt)}
+		rules := append(compositeRule{packageRule{source.pkg}}, rule.rules...)
+		moduleToVisibilityRuleMap(ctx.Config()).Store(qualified, compiledVisibilityRule(ctx.Config(), rules))
+	})
+}
+
 func parseRules(ctx BottomUpMutatorContext, currentPkg string, visibility []string) compositeRule {
 	rules := make(compositeRule, 0, len(visibility))
+	var exclusions []exclusionRule
 	hasPrivateRule := false
 	hasNonPrivateRule := false
 	for _, v := range visibility {
-		ok, pkg, name := splitRule(ctx, v, currentPkg)
+		expression, exclusion := splitExclusion(v)
+		ok, pkg, name := splitRule(ctx, expression, currentPkg)
 		if !ok {
 			continue
 		}
 
+		if exclusion {
+			// Invalid exclusions have been reported by the rule checker.
+			if pkg != "visibility" && name == "__pkg__" {
+				exclusions = append(exclusions, exclusionRule{packageRule{pkg}})
+			} else if pkg != "visibility" && name == "__subpackages__" {
+				exclusions = append(exclusions, exclusionRule{subpackagesRule{pkg}})
+			}
+			continue
+		}
+
 		var r visibilityRule
 		isPrivateRule := false
 		if pkg == "visibility" {
@@ -295,6 +662,14 @@ func parseRules(ctx BottomUpMutatorContext, currentPkg string, visibility []stri
 				isPrivateRule = true
 			case "public":
 				r = publicRule{}
+			case "override":
+				// The defaults are prepended to the visibility of the module, so the rules before
+				// the override are the ones inherited from defaults, which it replaces.
+				rules = rules[:0]
+				exclusions = nil
+				hasPrivateRule = false
+				hasNonPrivateRule = false
+				continue
 			}
 		} else {
 			switch name {
@@ -302,8 +677,10 @@ func parseRules(ctx BottomUpMutatorContext, currentPkg string, visibility []stri
 				r = packageRule{pkg}
 			case "__subpackages__":
 				r = subpackagesRule{pkg}
+			case "__namespace__":
+				r = namespaceRule{pkg}
 			default:
-				continue
+				r = groupRule{qualifiedModuleName{pkg: pkg, name: name}, packageGroupMap(ctx.Config())}
 			}
 		}
 
@@ -318,13 +695,69 @@ func parseRules(ctx BottomUpMutatorContext, currentPkg string, visibility []stri
 
 	if hasPrivateRule && hasNonPrivateRule {
 		ctx.PropertyErrorf("visibility",
-			"cannot mix \"//visibility:private\" with any other visibility rules")
+			"cannot mix \"//visibility:private\" with any other visibility rules%s",
+			inheritedVisibilityDescription(ctx.Module().base(), visibility))
 		return compositeRule{privateRule{}}
 	}
 
+	for _, e := range exclusions {
+		if checkExclusion(ctx, rules, e, hasPrivateRule, visibility) {
+			rules = append(rules, e)
+		}
+	}
+
 	return rules
 }
 
+// checkExclusion reports an exclusion that conflicts with the other rules of a visibility list: one
+// that is combined with //visibility:private or with no other rule, one that excludes all of the
+// packages of another rule, or one that doesn't exclude any package allowed by the other rules.
+// It returns true if the exclusion is valid.
+func checkExclusion(ctx BottomUpMutatorContext, rules compositeRule, e exclusionRule, hasPrivateRule bool,
+	visibility []string) bool {
+
+	inherited := inheritedVisibilityDescription(ctx.Module().base(), visibility)
+	if hasPrivateRule {
+		ctx.PropertyErrorf("visibility", "cannot mix \"//visibility:private\" with exclusion %q%s", e, inherited)
+		return false
+	}
+	if len(rules) == 0 {
+		ctx.PropertyErrorf("visibility", "exclusion %q has no visibility rule to exclude packages from%s",
+			e, inherited)
+		return false
+	}
+
+	// Only the packages of package and subpackages rules are known here, the packages of namespace and
+	// group rules could include the excluded ones.
+	excludesAny := false
+	for _, r := range rules {
+		switch r := r.(type) {
+		case publicRule, namespaceRule, groupRule:
+			excludesAny = true
+		case packageRule:
+			if e.matches(qualifiedModuleName{pkg: r.pkg}) {
+				ctx.PropertyErrorf("visibility", "exclusion %q excludes all of the packages of %q%s", e, r,
+					inherited)
+				return false
+			}
+		case subpackagesRule:
+			if e.matches(qualifiedModuleName{pkg: r.pkgPrefix}) {
+				ctx.PropertyErrorf("visibility", "exclusion %q excludes all of the packages of %q%s", e, r,
+					inherited)
+				return false
+			}
+			if r.matches(qualifiedModuleName{pkg: e.pkg()}) {
+				excludesAny = true
+			}
+		}
+	}
+	if !excludesAny {
+		ctx.PropertyErrorf("visibility", "exclusion %q doesn't exclude any of the packages allowed by the "+
+			"other visibility rules%s", e, inherited)
+	}
+	return excludesAny
+}
+
 func isAllowedFromOutsideVendor(pkg string, name string) bool {
 	if pkg == "vendor" {
 		if name == "__subpackages__" {
@@ -364,14 +797,30 @@ func visibilityRuleEnforcer(ctx TopDownMutatorContext) {
 	}
 
 	qualified := createQualifiedModuleName(ctx)
+	moduleToVisibilityRule := moduleToVisibilityRuleMap(ctx.Config())
+
+	// The package_group modules referenced by the visibility rules of this module and included by
+	// a package_group can only be checked once all of them have been gathered.
+	if rule, ok := moduleToVisibilityRule.Load(qualified); ok {
+		for _, group := range rule.(*compiledRule).groups {
+			if _, ok := group.groups.Load(group.group); !ok {
+				ctx.PropertyErrorf("visibility", "%s is not a package_group module", group.group)
+			}
+		}
+	}
+	if _, ok := ctx.Module().(*packageGroupModule); ok {
+		checkPackageGroupIncludes(ctx, qualified)
+	}
 
-	moduleToVisibilityRule := moduleToVisibilityRuleMap(ctx)
+	if namespace, ok := ctx.Namespace().(*Namespace); ok {
+		qualified.namespace = namespace.Path
+	}
 
 	// Visit all the dependencies making sure that this module has access to them all.
 	ctx.VisitDirectDeps(func(dep Module) {
 		depName := ctx.OtherModuleName(dep)
 		depDir := ctx.OtherModuleDir(dep)
-		depQualified := qualifiedModuleName{depDir, depName}
+		depQualified := qualifiedModuleName{pkg: depDir, name: depName}
 
 		// Targets are always visible to other targets in their own package.
 		if depQualified.pkg == qualified.pkg {
@@ -380,16 +829,83 @@ func visibilityRuleEnforcer(ctx TopDownMutatorContext) {
 
 		rule, ok := moduleToVisibilityRule.Load(depQualified)
 		if ok {
-			if !rule.(compositeRule).matches(qualified) {
-				ctx.ModuleErrorf("depends on %s which is not visible to this module", depQualified)
+			if !rule.(visibilityRule).matches(qualified) {
+				reportVisibilityViolation(ctx, qualified, depQualified, func() {
+					inherited := inheritedVisibilityDescription(dep.base(), dep.base().commonProperties.Visibility)
+					// Report a ":module" reference on the properties that contain it.
+					var properties []string
+					if _, isPathDep := ctx.OtherModuleDependencyTag(dep).(sourceOrOutputDependencyTag); isPathDep {
+						properties = pathPropertiesReferencing(ctx.Module().(Module), depName)
+					}
+					for _, property := range properties {
+						ctx.PropertyErrorf(property, "references %s which is not visible to this module%s",
+							depQualified, inherited)
+					}
+					if len(properties) == 0 {
+						ctx.ModuleErrorf("depends on %s which is not visible to this module%s", depQualified,
+							inherited)
+					}
+				})
 			}
 		}
 	})
 }
 
+// A visibilityOrigin records the defaults module that a visibility rule of a module was inherited
+// from.
+type visibilityOrigin struct {
+	Rule     string
+	Defaults string
+}
+
+// inheritedVisibilityDescription describes the rules of a visibility list that a module inherited
+// from defaults modules, for error messages, or returns an empty string if all the rules were set
+// on the module itself.  The rules before a //visibility:override are ignored, they are replaced.
+func inheritedVisibilityDescription(m *ModuleBase, visibility []string) string {
+	for i := IndexList("//visibility:override", visibility); i >= 0; i = IndexList("//visibility:override", visibility) {
+		visibility = visibility[i+1:]
+	}
+
+	var inherited []string
+	for _, origin := range m.commonProperties.VisibilityOrigins {
+		if InList(origin.Rule, visibility) {
+			inherited = append(inherited, fmt.Sprintf("%q from defaults module %q", origin.Rule, origin.Defaults))
+		}
+	}
+	if len(inherited) == 0 {
+		return ""
+	}
+	return " (inherited " + strings.Join(inherited, ", ") + ")"
+}
+
+// EffectiveVisibilityRules returns the visibility rules that are enforced for a module, in their
+// canonical form, so that they can be written into the visibility property of a generated
+// Android.bp file, e.g. of an sdk snapshot.  They include the rules inherited from defaults
+// modules, and for a prebuilt the rules inherited from its source module.  A module without
+// visibility rules is returned as ["//visibility:public"].  The rules are only known after the
+// visibility rule gatherer has run, before the arch mutator.
+func EffectiveVisibilityRules(ctx BaseModuleContext, module Module) []string {
+	qualified := qualifiedModuleName{pkg: ctx.OtherModuleDir(module), name: ctx.OtherModuleName(module)}
+	rule, ok := moduleToVisibilityRuleMap(ctx.Config()).Load(qualified)
+	if !ok {
+		return []string{publicRule{}.String()}
+	}
+
+	rules := rule.(*compiledRule).rules
+	if len(rules) == 0 {
+		// A visibility list that is empty after a //visibility:override doesn't match any module.
+		return []string{privateRule{}.String()}
+	}
+	visibility := make([]string, 0, len(rules))
+	for _, r := range rules {
+		visibility = append(visibility, r.String())
+	}
+	return visibility
+}
+
 func createQualifiedModuleName(ctx BaseModuleContext) qualifiedModuleName {
 	moduleName := ctx.ModuleName()
 	dir := ctx.ModuleDir()
-	qualified := qualifiedModuleName{dir, moduleName}
+	qualified := qualifiedModuleName{pkg: dir, name: moduleName}
 	return qualified
 }
@@ -0,0 +1,184 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
+)
+
+// This file implements the modes used to roll out visibility incrementally across a large tree.  When
+// SOONG_VISIBILITY_WARNINGS is set to true, a dependency on a module that is not visible is reported as a
+// warning instead of an error.  When SOONG_VISIBILITY_BASELINE is set to a file in the source tree, the
+// violations listed in the file are reported as warnings and any other violation is still an error, so
+// that no new violations are added while the existing ones are fixed.
+//
+// In both modes, every violation is written to $OUT_DIR/soong/visibility_violations.txt, which is built by the
+// soong_visibility_violations phony target, one per line:
+//
+//   //<package>:<module> -> //<package of dependency>:<dependency>
+//
+// The baseline file has the same format, so the file written by a build can be used as the baseline.
+// Blank lines and lines starting with '#' are ignored in the baseline.
+
+func init() {
+	RegisterSingletonType("visibility_violations", visibilityViolationsSingletonFactory)
+}
+
+const visibilityViolationsFileName = "visibility_violations.txt"
+
+var visibilityViolationModeKey = NewOnceKey("visibilityViolationMode")
+
+type visibilityViolationMode struct {
+	warnings bool
+	baseline map[string]bool
+	// The error reading the baseline, if any.
+	err error
+}
+
+// enabled returns true if violations are collected instead of all being reported as errors.
+func (m visibilityViolationMode) enabled() bool {
+	return m.warnings || m.baseline != nil
+}
+
+// visibilityViolationModeContext is the subset of BaseModuleContext and SingletonContext needed to read the
+// baseline.
+type visibilityViolationModeContext interface {
+	Config() Config
+	Fs() pathtools.FileSystem
+	AddNinjaFileDeps(deps ...string)
+}
+
+func getVisibilityViolationMode(ctx visibilityViolationModeContext) visibilityViolationMode {
+	return ctx.Config().Once(visibilityViolationModeKey, func() interface{} {
+		mode := visibilityViolationMode{
+			warnings: ctx.Config().IsEnvTrue("SOONG_VISIBILITY_WARNINGS") || ctx.Config().visibilityWarnings,
+		}
+		if file := ctx.Config().Getenv("SOONG_VISIBILITY_BASELINE"); file != "" {
+			baseline, err := readVisibilityBaseline(ctx, file)
+			if err != nil {
+				mode.err = fmt.Errorf("failed to read SOONG_VISIBILITY_BASELINE %q: %s", file, err)
+				return mode
+			}
+			ctx.AddNinjaFileDeps(file)
+			mode.baseline = baseline
+		}
+		return mode
+	}).(visibilityViolationMode)
+}
+
+func readVisibilityBaseline(ctx visibilityViolationModeContext, file string) (map[string]bool, error) {
+	r, err := ctx.Fs().Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	baseline := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		baseline[line] = true
+	}
+	return baseline, scanner.Err()
+}
+
+var visibilityViolationsKey = NewOnceKey("visibilityViolations")
+
+// visibilityViolations is the set of the violations found by the visibility rule enforcer.
+type visibilityViolations struct {
+	sync.Mutex
+	violations map[string]bool
+}
+
+func getVisibilityViolations(config Config) *visibilityViolations {
+	return config.Once(visibilityViolationsKey, func() interface{} {
+		return &visibilityViolations{violations: make(map[string]bool)}
+	}).(*visibilityViolations)
+}
+
+// reportVisibilityViolation reports that module depends on dep although dep is not visible to it.
+// report is called to report the violation as an error, unless the violation mode turns it into a
+// warning.
+func reportVisibilityViolation(ctx TopDownMutatorContext, module, dep qualifiedModuleName, report func()) {
+	mode := getVisibilityViolationMode(ctx)
+	if mode.err != nil {
+		// The violation can't be checked against the baseline.
+		ctx.ModuleErrorf("%s", mode.err)
+		report()
+		return
+	}
+	if !mode.enabled() {
+		report()
+		return
+	}
+
+	violation := module.String() + " -> " + dep.String()
+	violations := getVisibilityViolations(ctx.Config())
+	violations.Lock()
+	reported := violations.violations[violation]
+	violations.violations[violation] = true
+	violations.Unlock()
+
+	if !mode.warnings && !mode.baseline[violation] {
+		report()
+	} else if !reported {
+		// Only warn once for the variants of the module.
+		ctx.ModuleWarningf("depends on %s which is not visible to it", dep)
+	}
+}
+
+func visibilityViolationsSingletonFactory() Singleton {
+	return &visibilityViolationsSingleton{}
+}
+
+type visibilityViolationsSingleton struct{}
+
+func (s *visibilityViolationsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	mode := getVisibilityViolationMode(ctx)
+	if mode.err != nil {
+		ctx.Errorf("%s", mode.err)
+		return
+	}
+	if !mode.enabled() {
+		return
+	}
+
+	violations := getVisibilityViolations(ctx.Config())
+	violations.Lock()
+	list := SortedStringKeys(violations.violations)
+	violations.Unlock()
+
+	content := strings.Join(list, "\n")
+	if len(list) > 0 {
+		content += "\n"
+	}
+
+	file := PathForOutput(ctx, visibilityViolationsFileName)
+	WriteFileRule(ctx, file, content)
+	ctx.Build(pctx, BuildParams{
+		Rule:   blueprint.Phony,
+		Output: PathForPhony(ctx, "soong_visibility_violations"),
+		Input:  file,
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"reflect"
+	"testing"
+)
+
+var visibilityViolationsTestFs = map[string][]byte{
+	"top/Blueprints": []byte(`
+		mock_library {
+			name: "libexample",
+			visibility: ["//visibility:private"],
+		}
+		mock_library {
+			name: "libprivate",
+			visibility: ["//visibility:private"],
+		}`),
+	"other/Blueprints": []byte(`
+		mock_library {
+			name: "libother",
+			deps: ["libexample"],
+		}`),
+	"new/Blueprints": []byte(`
+		mock_library {
+			name: "libnew",
+			deps: ["libprivate"],
+		}`),
+	"visibility_baseline.txt": []byte(`
+		# Existing violations
+		//other:libother -> //top:libexample
+	`),
+}
+
+func TestVisibilityWarnings(t *testing.T) {
+	config := TestArchConfig(buildDir, map[string]string{
+		"SOONG_VISIBILITY_WARNINGS": "true",
+	})
+	ctx, errs := testVisibilityWithConfig(config, visibilityViolationsTestFs)
+	FailIfErrored(t, errs)
+
+	want := "//new:libnew -> //top:libprivate\n" +
+		"//other:libother -> //top:libexample\n"
+	violations := ctx.SingletonForTests("visibility_violations").Output(visibilityViolationsFileName)
+	if g := ContentFromFileRuleForTests(t, violations); g != want {
+		t.Errorf("expected violations %q, got %q", want, g)
+	}
+
+	wantWarnings := []string{
+		`module "libnew": depends on //top:libprivate which is not visible to it`,
+		`module "libother": depends on //top:libexample which is not visible to it`,
+	}
+	if g := config.Warnings(); !reflect.DeepEqual(g, wantWarnings) {
+		t.Errorf("expected warnings %q, got %q", wantWarnings, g)
+	}
+}
+
+func TestVisibilityViolationsDisabled(t *testing.T) {
+	ctx, errs := testVisibility(buildDir, map[string][]byte{
+		"top/Blueprints": []byte(`
+			mock_library {
+				name: "libexample",
+			}`),
+	})
+	FailIfErrored(t, errs)
+
+	violations := ctx.SingletonForTests("visibility_violations").MaybeOutput(visibilityViolationsFileName)
+	if violations.Rule != nil {
+		t.Errorf("expected no %s without a violation mode, got rule %v", visibilityViolationsFileName, violations.Rule)
+	}
+}
+
+func TestVisibilityBaselineUnreadable(t *testing.T) {
+	_, errs := testVisibilityWithEnv(buildDir, map[string]string{
+		"SOONG_VISIBILITY_BASELINE": "missing_baseline.txt",
+	}, visibilityViolationsTestFs)
+	FailIfNoMatchingErrors(t, `failed to read SOONG_VISIBILITY_BASELINE "missing_baseline.txt"`, errs)
+}
+
+func TestVisibilityBaseline(t *testing.T) {
+	_, errs := testVisibilityWithEnv(buildDir, map[string]string{
+		"SOONG_VISIBILITY_BASELINE": "visibility_baseline.txt",
+	}, visibilityViolationsTestFs)
+	FailIfNoMatchingErrors(t, `module "libnew" variant "android_common": depends on //top:libprivate which is not`+
+		` visible to this module`, errs)
+	if len(errs) != 1 {
+		t.Errorf("expected only the violation missing from the baseline to be an error, got %q", errs)
+	}
+}
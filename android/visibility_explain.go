@@ -0,0 +1,174 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// A VisibilityExplanation describes whether a module is visible to another module and why, for
+// soong_build -explain_visibility.
+type VisibilityExplanation struct {
+	// The qualified names of the module that depends on To and of the module it depends on, in the
+	// form //<package>:<name>.
+	From string
+	To   string
+
+	// The canonical visibility rules of To, see EffectiveVisibilityRules.
+	Rules []string
+
+	// The description of the rules To inherited from defaults modules, if any.
+	Inherited string
+
+	// True if From and To are in the same package, where the visibility rules don't apply.
+	SamePackage bool
+
+	// The rule of To that matches From, or empty if To is not visible to From.
+	Matched string
+
+	// The exclusion of To that excludes the package of From, if any.
+	Excluded string
+
+	// The rule to add to the visibility of To to make it visible to From, or empty if it is
+	// already visible or excluded.
+	Suggestion string
+}
+
+func (e VisibilityExplanation) String() string {
+	lines := []string{
+		fmt.Sprintf("%s -> %s", e.From, e.To),
+		fmt.Sprintf("visibility of %s: [%s]%s", e.To, strings.Join(e.Rules, ", "), e.Inherited),
+	}
+	switch {
+	case e.SamePackage:
+		lines = append(lines, "visible: modules are always visible to the modules in their own package")
+	case e.Matched != "":
+		lines = append(lines, "visible: matched by "+e.Matched)
+	case e.Excluded != "":
+		lines = append(lines, "not visible: excluded by "+e.Excluded,
+			fmt.Sprintf("remove %q from the visibility of %s", e.Excluded, e.To))
+	case len(e.Rules) == 1 && e.Rules[0] == privateRule{}.String():
+		lines = append(lines, "not visible: no rule matches "+e.From,
+			fmt.Sprintf("replace %q with %q in the visibility of %s", e.Rules[0], e.Suggestion, e.To))
+	default:
+		lines = append(lines, "not visible: no rule matches "+e.From,
+			fmt.Sprintf("add %q to the visibility of %s", e.Suggestion, e.To))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ExplainVisibility explains whether the module to is visible to the module from, once the
+// visibility rule gatherer has run.  Each module is either a name or a //<package>:<name>
+// reference, and must name a single module.  The resolver is used to find the soong_namespace of
+// from, for the __namespace__ rules.
+func ExplainVisibility(ctx *Context, config Config, resolver *NameResolver, from, to string) (VisibilityExplanation, error) {
+	_, fromQualified, err := findVisibilityModule(ctx, from)
+	if err != nil {
+		return VisibilityExplanation{}, err
+	}
+	toModule, toQualified, err := findVisibilityModule(ctx, to)
+	if err != nil {
+		return VisibilityExplanation{}, err
+	}
+	if namespace := resolver.findNamespace(fromQualified.pkg); namespace != nil {
+		fromQualified.namespace = namespace.Path
+	}
+
+	e := VisibilityExplanation{
+		From:        fromQualified.String(),
+		To:          toQualified.String(),
+		SamePackage: fromQualified.pkg == toQualified.pkg,
+		Rules:       []string{publicRule{}.String()},
+	}
+
+	var rules compositeRule
+	if rule, ok := moduleToVisibilityRuleMap(config).Load(toQualified); ok {
+		rules = rule.(*compiledRule).rules
+		e.Rules = make([]string, 0, len(rules))
+		for _, r := range rules {
+			e.Rules = append(e.Rules, r.String())
+		}
+		if len(rules) == 0 {
+			e.Rules = []string{privateRule{}.String()}
+		}
+		e.Inherited = inheritedVisibilityDescription(toModule.base(), toModule.base().commonProperties.Visibility)
+	} else {
+		rules = compositeRule{publicRule{}}
+	}
+
+	if e.SamePackage {
+		return e, nil
+	}
+	for _, r := range rules {
+		if _, ok := r.(exclusionRule); ok && r.matches(fromQualified) {
+			e.Excluded = r.String()
+			return e, nil
+		}
+	}
+	for _, r := range rules {
+		if _, ok := r.(exclusionRule); !ok && r.matches(fromQualified) {
+			e.Matched = r.String()
+			return e, nil
+		}
+	}
+	e.Suggestion = packageRule{fromQualified.pkg}.String()
+	return e, nil
+}
+
+// findVisibilityModule returns a variant of the module that a name or a //<package>:<name>
+// reference refers to, and its qualified name.
+func findVisibilityModule(ctx *Context, ref string) (Module, qualifiedModuleName, error) {
+	pkg, name := "", ref
+	if strings.HasPrefix(ref, "//") {
+		matches := visibilityRuleRegexp.FindStringSubmatch(ref)
+		if matches == nil || matches[2] == "" {
+			return nil, qualifiedModuleName{}, fmt.Errorf("invalid module %q, expected <name> or //<package>:<name>", ref)
+		}
+		pkg, name = matches[1], matches[2]
+	}
+
+	found := make(map[qualifiedModuleName]Module)
+	ctx.VisitAllModules(func(m blueprint.Module) {
+		module, ok := m.(Module)
+		if !ok || ctx.ModuleName(m) != name || (pkg != "" && ctx.ModuleDir(m) != pkg) {
+			return
+		}
+		qualified := qualifiedModuleName{pkg: ctx.ModuleDir(m), name: name}
+		if _, ok := found[qualified]; !ok {
+			found[qualified] = module
+		}
+	})
+
+	switch len(found) {
+	case 0:
+		return nil, qualifiedModuleName{}, fmt.Errorf("no module %q", ref)
+	case 1:
+		for qualified, module := range found {
+			return module, qualified, nil
+		}
+	}
+
+	var candidates []string
+	for qualified := range found {
+		candidates = append(candidates, qualified.String())
+	}
+	sort.Strings(candidates)
+	return nil, qualifiedModuleName{}, fmt.Errorf("module %q is ambiguous, use one of %s", ref,
+		strings.Join(candidates, ", "))
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExplainVisibility(t *testing.T) {
+	config := TestArchConfig(buildDir, nil)
+	config.SetVisibilityWarnings()
+
+	ctx, errs := testVisibilityWithConfig(config, map[string][]byte{
+		"top/Blueprints": []byte(`
+			mock_defaults {
+				name: "defaults",
+				visibility: ["//other/sub"],
+			}
+
+			mock_library {
+				name: "libpublic",
+			}
+
+			mock_library {
+				name: "libprivate",
+				visibility: ["//visibility:private"],
+			}
+
+			mock_library {
+				name: "libexample",
+				defaults: ["defaults"],
+				visibility: ["//some:__subpackages__"],
+			}`),
+		"some/nested/Blueprints": []byte(`
+			mock_library {
+				name: "libnested",
+				deps: ["libexample"],
+			}`),
+		"other/Blueprints": []byte(`
+			mock_library {
+				name: "libother",
+				deps: ["libexample", "libprivate"],
+			}`),
+	})
+	FailIfErrored(t, errs)
+
+	testCases := []struct {
+		from, to string
+		want     VisibilityExplanation
+	}{
+		{
+			from: "libnested",
+			to:   "//top:libexample",
+			want: VisibilityExplanation{
+				From:      "//some/nested:libnested",
+				To:        "//top:libexample",
+				Rules:     []string{"//some:__subpackages__", "//other/sub:__pkg__"},
+				Inherited: ` (inherited "//other/sub" from defaults module "defaults")`,
+				Matched:   "//some:__subpackages__",
+			},
+		},
+		{
+			from: "libother",
+			to:   "//top:libexample",
+			want: VisibilityExplanation{
+				From:       "//other:libother",
+				To:         "//top:libexample",
+				Rules:      []string{"//some:__subpackages__", "//other/sub:__pkg__"},
+				Inherited:  ` (inherited "//other/sub" from defaults module "defaults")`,
+				Suggestion: "//other:__pkg__",
+			},
+		},
+		{
+			from: "libother",
+			to:   "libprivate",
+			want: VisibilityExplanation{
+				From:       "//other:libother",
+				To:         "//top:libprivate",
+				Rules:      []string{"//visibility:private"},
+				Suggestion: "//other:__pkg__",
+			},
+		},
+		{
+			from: "libother",
+			to:   "libpublic",
+			want: VisibilityExplanation{
+				From:    "//other:libother",
+				To:      "//top:libpublic",
+				Rules:   []string{"//visibility:public"},
+				Matched: "//visibility:public",
+			},
+		},
+		{
+			from: "//top:libprivate",
+			to:   "//top:libexample",
+			want: VisibilityExplanation{
+				From:        "//top:libprivate",
+				To:          "//top:libexample",
+				Rules:       []string{"//some:__subpackages__", "//other/sub:__pkg__"},
+				Inherited:   ` (inherited "//other/sub" from defaults module "defaults")`,
+				SamePackage: true,
+			},
+		},
+	}
+	for _, test := range testCases {
+		got, err := ExplainVisibility(ctx.Context, config, ctx.NameResolver, test.from, test.to)
+		if err != nil {
+			t.Errorf("%s -> %s: unexpected error %s", test.from, test.to, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s -> %s: expected %#v, got %#v", test.from, test.to, test.want, got)
+		}
+	}
+
+	want := "//other:libother -> //top:libprivate\n" +
+		"visibility of //top:libprivate: [//visibility:private]\n" +
+		"not visible: no rule matches //other:libother\n" +
+		`replace "//visibility:private" with "//other:__pkg__" in the visibility of //top:libprivate`
+	if got, _ := ExplainVisibility(ctx.Context, config, ctx.NameResolver, "libother", "libprivate"); got.String() != want {
+		t.Errorf("expected explanation:\n%s\ngot:\n%s", want, got)
+	}
+
+	if _, err := ExplainVisibility(ctx.Context, config, ctx.NameResolver, "libother", "//other:libexample"); err == nil ||
+		err.Error() != `no module "//other:libexample"` {
+		t.Errorf("expected no module //other:libexample, got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package android
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/blueprint"
@@ -608,7 +610,8 @@ var visibilityTests = []struct {
 		},
 		expectedErrors: []string{
 			`module "libexample": visibility: cannot mix "//visibility:private"` +
-				` with any other visibility rules`,
+				` with any other visibility rules \(inherited "//namespace" from defaults module` +
+				` "libexample_defaults"\)`,
 		},
 	},
 	{
@@ -627,7 +630,8 @@ var visibilityTests = []struct {
 		},
 		expectedErrors: []string{
 			`module "libexample": visibility: cannot mix "//visibility:private"` +
-				` with any other visibility rules`,
+				` with any other visibility rules \(inherited "//visibility:private" from defaults module` +
+				` "libexample_defaults"\)`,
 		},
 	},
 	{
@@ -658,6 +662,434 @@ var visibilityTests = []struct {
 				` visible to this module`,
 		},
 	},
+	{
+		name: "//visibility:override narrows defaults",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_defaults {
+					name: "libexample_defaults",
+					visibility: ["//outsider", "//other"],
+				}
+				mock_library {
+					name: "libexample",
+					visibility: ["//visibility:override", "//other"],
+					defaults: ["libexample_defaults"],
+				}`),
+			"other/Blueprints": []byte(`
+				mock_library {
+					name: "libother",
+					deps: ["libexample"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "liboutsider" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+		},
+	},
+	{
+		name: "//visibility:override with //visibility:private",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_defaults {
+					name: "libexample_defaults",
+					visibility: ["//outsider"],
+				}
+				mock_library {
+					name: "libexample",
+					visibility: ["//visibility:override", "//visibility:private"],
+					defaults: ["libexample_defaults"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "liboutsider" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+		},
+	},
+	{
+		name: "//visibility:override not first",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//other", "//visibility:override"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libexample": visibility: "//visibility:override" may only be used at the start` +
+				` of the visibility rules`,
+		},
+	},
+	{
+		name: "defaults visibility of a module type with its own defaultable properties",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_defaults {
+					name: "libexample_defaults",
+					visibility: ["//friend"],
+				}
+				mock_library_defaultable_first {
+					name: "libexample",
+					defaults: ["libexample_defaults"],
+				}`),
+			"friend/Blueprints": []byte(`
+				mock_library {
+					name: "libfriend",
+					deps: ["libexample"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "liboutsider" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module \(inherited "//friend" from defaults module "libexample_defaults"\)`,
+		},
+	},
+	{
+		name: "inherited visibility in errors",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_defaults {
+					name: "libexample_defaults",
+					visibility: ["//friend"],
+				}
+				mock_library {
+					name: "libexample",
+					visibility: ["//other"],
+					defaults: ["libexample_defaults"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "liboutsider" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module \(inherited "//friend" from defaults module "libexample_defaults"\)$`,
+		},
+	},
+	{
+		name: "prebuilt inherits visibility",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//friend"],
+				}
+				mock_library {
+					name: "libsamepackage",
+					deps: ["libexample"],
+				}`),
+			"prebuilts/Blueprints": []byte(`
+				mock_prebuilt {
+					name: "libexample",
+					prefer: true,
+					srcs: ["libexample.jar"],
+				}`),
+			"friend/Blueprints": []byte(`
+				mock_library {
+					name: "libfriend",
+					deps: ["libexample"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "liboutsider" variant "android_common": depends on //prebuilts:prebuilt_libexample` +
+				` which is not visible to this module`,
+		},
+	},
+	{
+		name: "prebuilt with its own visibility",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//friend"],
+				}`),
+			"prebuilts/Blueprints": []byte(`
+				mock_prebuilt {
+					name: "libexample",
+					prefer: true,
+					srcs: ["libexample.jar"],
+					visibility: ["//outsider"],
+				}`),
+			"friend/Blueprints": []byte(`
+				mock_library {
+					name: "libfriend",
+					deps: ["libexample"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libfriend" variant "android_common": depends on //prebuilts:prebuilt_libexample` +
+				` which is not visible to this module`,
+		},
+	},
+	{
+		name: "package_group",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//groups:friends"],
+				}`),
+			"groups/Blueprints": []byte(`
+				package_group {
+					name: "friends",
+					packages: ["//friend"],
+					includes: [":more_friends"],
+				}
+				package_group {
+					name: "more_friends",
+					packages: ["//other:__subpackages__"],
+				}`),
+			"friend/Blueprints": []byte(`
+				mock_library {
+					name: "libfriend",
+					deps: ["libexample"],
+				}`),
+			"other/nested/Blueprints": []byte(`
+				mock_library {
+					name: "libnested",
+					deps: ["libexample"],
+				}`),
+			"outsider/Blueprints": []byte(`
+				mock_library {
+					name: "liboutsider",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "liboutsider" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+		},
+	},
+	{
+		name: "package_group: unknown group",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//groups:missing"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libexample" variant "android_common": visibility: //groups:missing is not a` +
+				` package_group module`,
+		},
+	},
+	{
+		name: "package_group: invalid",
+		fs: map[string][]byte{
+			"groups/Blueprints": []byte(`
+				package_group {
+					name: "a",
+					packages: ["//visibility:public", "//top:other_group"],
+					includes: [":b", "//top"],
+				}
+				package_group {
+					name: "b",
+					includes: [":a", ":c"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "a": packages: invalid package "//visibility:public"`,
+			`module "a": packages: invalid package "//top:other_group", use includes`,
+			`module "a": includes: invalid package_group "//top"`,
+			`module "a": includes: //groups:b includes //groups:a`,
+			`module "b": includes: //groups:a includes //groups:b`,
+			`module "b": includes: //groups:c is not a package_group module`,
+		},
+	},
+	{
+		name: "path dependency: private filegroup",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				filegroup {
+					name: "fg",
+					visibility: ["//visibility:private"],
+				}
+				mock_library {
+					name: "libexample",
+					srcs: [":fg"],
+				}`),
+			"other/Blueprints": []byte(`
+				mock_library {
+					name: "libother",
+					srcs: [":fg"],
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libother" variant "android_common": srcs: references //top:fg which is not visible` +
+				` to this module`,
+		},
+	},
+	{
+		name: "exclusion: excluded subtree",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//project:__subpackages__", "-//project/tests:__subpackages__", "-//project/internal"],
+				}`),
+			"project/Blueprints": []byte(`
+				mock_library {
+					name: "libproject",
+					deps: ["libexample"],
+				}`),
+			"project/internal/deeper/Blueprints": []byte(`
+				mock_library {
+					name: "libdeeper",
+					deps: ["libexample"],
+				}`),
+			"project/internal/Blueprints": []byte(`
+				mock_library {
+					name: "libinternal",
+					deps: ["libexample"],
+				}`),
+			"project/tests/unit/Blueprints": []byte(`
+				mock_library {
+					name: "libunittests",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libinternal" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+			`module "libunittests" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+		},
+	},
+	{
+		name: "exclusion: public",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//visibility:public", "-//other"],
+				}`),
+			"other/Blueprints": []byte(`
+				mock_library {
+					name: "libother",
+					deps: ["libexample"],
+				}`),
+			"other/nested/Blueprints": []byte(`
+				mock_library {
+					name: "libnested",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libother" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+		},
+	},
+	{
+		name: "exclusion: inherited from defaults",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_defaults {
+					name: "libexample_defaults",
+					visibility: ["-//project/tests"],
+				}
+				mock_library {
+					name: "libexample",
+					defaults: ["libexample_defaults"],
+					visibility: ["//project:__subpackages__"],
+				}`),
+			"project/tests/Blueprints": []byte(`
+				mock_library {
+					name: "libtests",
+					deps: ["libexample"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libtests" variant "android_common": depends on //top:libexample which is not` +
+				` visible to this module`,
+		},
+	},
+	{
+		name: "exclusion: invalid",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libexample",
+					visibility: ["//project:__subpackages__", "-//visibility:public", "-//project:libfoo"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libexample": visibility: invalid exclusion "-//visibility:public"`,
+			`module "libexample": visibility: invalid exclusion "-//project:libfoo"`,
+		},
+	},
+	{
+		name: "exclusion: conflicts",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				mock_library {
+					name: "libprivate",
+					visibility: ["//visibility:private", "-//project"],
+				}
+				mock_library {
+					name: "libexcluded",
+					visibility: ["//project/tests", "//other", "-//project:__subpackages__"],
+				}
+				mock_library {
+					name: "libunrelated",
+					visibility: ["//project:__subpackages__", "-//other/tests"],
+				}
+				mock_library {
+					name: "libonly",
+					visibility: ["-//project"],
+				}`),
+		},
+		expectedErrors: []string{
+			`module "libprivate": visibility: cannot mix "//visibility:private" with exclusion "-//project:__pkg__"`,
+			`module "libexcluded": visibility: exclusion "-//project:__subpackages__" excludes all of the` +
+				` packages of "//project/tests:__pkg__"`,
+			`module "libunrelated": visibility: exclusion "-//other/tests:__pkg__" doesn't exclude any of` +
+				` the packages allowed by the other visibility rules`,
+			`module "libonly": visibility: exclusion "-//project:__pkg__" has no visibility rule to exclude` +
+				` packages from`,
+		},
+	},
+	{
+		name: "path dependency: visible filegroup",
+		fs: map[string][]byte{
+			"top/Blueprints": []byte(`
+				filegroup {
+					name: "fg",
+					visibility: ["//other"],
+				}`),
+			"other/Blueprints": []byte(`
+				mock_library {
+					name: "libother",
+					srcs: [":fg"],
+				}`),
+		},
+	},
 }
 
 func TestVisibility(t *testing.T) {
@@ -687,17 +1119,31 @@ func TestVisibility(t *testing.T) {
 }
 
 func testVisibility(buildDir string, fs map[string][]byte) (*TestContext, []error) {
+	return testVisibilityWithEnv(buildDir, nil, fs)
+}
+
+func testVisibilityWithEnv(buildDir string, env map[string]string, fs map[string][]byte) (*TestContext, []error) {
 
 	// Create a new config per test as visibility information is stored in the config.
-	config := TestArchConfig(buildDir, nil)
+	return testVisibilityWithConfig(TestArchConfig(buildDir, env), fs)
+}
 
+func testVisibilityWithConfig(config Config, fs map[string][]byte) (*TestContext, []error) {
 	ctx := NewTestArchContext()
 	ctx.RegisterModuleType("mock_library", ModuleFactoryAdaptor(newMockLibraryModule))
 	ctx.RegisterModuleType("mock_defaults", ModuleFactoryAdaptor(defaultsFactory))
+	ctx.RegisterModuleType("package_group", ModuleFactoryAdaptor(PackageGroupFactory))
+	ctx.RegisterModuleType("mock_prebuilt", ModuleFactoryAdaptor(newMockPrebuiltModule))
+	ctx.RegisterModuleType("mock_library_defaultable_first", ModuleFactoryAdaptor(newMockLibraryDefaultableFirstModule))
+	ctx.RegisterModuleType("filegroup", ModuleFactoryAdaptor(FileGroupFactory))
+	ctx.PreArchMutators(RegisterPrebuiltsPreArchMutators)
 	ctx.PreArchMutators(registerVisibilityRuleChecker)
 	ctx.PreArchMutators(RegisterDefaultsPreArchMutators)
 	ctx.PreArchMutators(registerVisibilityRuleGatherer)
+	ctx.PostDepsMutators(registerPathDepsMutator)
+	ctx.PostDepsMutators(RegisterPrebuiltsPostDepsMutators)
 	ctx.PostDepsMutators(registerVisibilityRuleEnforcer)
+	ctx.RegisterSingletonType("visibility_violations", SingletonFactoryAdaptor(visibilityViolationsSingletonFactory))
 	ctx.Register()
 
 	ctx.MockFileSystem(fs)
@@ -713,12 +1159,15 @@ func testVisibility(buildDir string, fs map[string][]byte) (*TestContext, []erro
 
 type mockLibraryProperties struct {
 	Deps []string
+	Srcs []string `android:"path"`
 }
 
 type mockLibraryModule struct {
 	ModuleBase
 	DefaultableModuleBase
 	properties mockLibraryProperties
+
+	effectiveVisibility []string
 }
 
 func newMockLibraryModule() Module {
@@ -729,6 +1178,16 @@ func newMockLibraryModule() Module {
 	return m
 }
 
+// newMockLibraryDefaultableFirstModule is a module type that calls InitDefaultableModule before
+// InitAndroidArchModule, so that its common properties aren't defaultable.
+func newMockLibraryDefaultableFirstModule() Module {
+	m := &mockLibraryModule{}
+	m.AddProperties(&m.properties)
+	InitDefaultableModule(m)
+	InitAndroidArchModule(m, HostAndDeviceSupported, MultilibCommon)
+	return m
+}
+
 type dependencyTag struct {
 	blueprint.BaseDependencyTag
 	name string
@@ -738,7 +1197,35 @@ func (j *mockLibraryModule) DepsMutator(ctx BottomUpMutatorContext) {
 	ctx.AddVariationDependencies(nil, dependencyTag{name: "mockdeps"}, j.properties.Deps...)
 }
 
-func (p *mockLibraryModule) GenerateAndroidBuildActions(ModuleContext) {
+func (p *mockLibraryModule) GenerateAndroidBuildActions(ctx ModuleContext) {
+	p.effectiveVisibility = EffectiveVisibilityRules(ctx, p)
+}
+
+type mockPrebuiltModule struct {
+	ModuleBase
+	prebuilt   Prebuilt
+	properties struct {
+		Srcs []string
+	}
+}
+
+func newMockPrebuiltModule() Module {
+	m := &mockPrebuiltModule{}
+	m.AddProperties(&m.properties)
+	InitPrebuiltModule(m, &m.properties.Srcs)
+	InitAndroidArchModule(m, HostAndDeviceSupported, MultilibCommon)
+	return m
+}
+
+func (p *mockPrebuiltModule) Name() string {
+	return p.prebuilt.Name(p.ModuleBase.Name())
+}
+
+func (p *mockPrebuiltModule) Prebuilt() *Prebuilt {
+	return &p.prebuilt
+}
+
+func (p *mockPrebuiltModule) GenerateAndroidBuildActions(ModuleContext) {
 }
 
 type mockDefaults struct {
@@ -751,3 +1238,269 @@ func defaultsFactory() Module {
 	InitDefaultsModule(m)
 	return m
 }
+
+func TestEffectiveVisibilityRules(t *testing.T) {
+	ctx, errs := testVisibility(buildDir, map[string][]byte{
+		"top/Blueprints": []byte(`
+			mock_defaults {
+				name: "defaults",
+				visibility: ["//other:__subpackages__", "//top/nested"],
+			}
+
+			mock_library {
+				name: "public",
+			}
+
+			mock_library {
+				name: "private",
+				visibility: ["//visibility:private"],
+			}
+
+			mock_library {
+				name: "defaulted",
+				defaults: ["defaults"],
+				visibility: ["//other/sub", ":__subpackages__"],
+			}`),
+	})
+	FailIfErrored(t, errs)
+
+	testCases := []struct {
+		name string
+		want []string
+	}{
+		{"public", []string{"//visibility:public"}},
+		{"private", []string{"//visibility:private"}},
+		{"defaulted", []string{"//other:__subpackages__", "//top:__subpackages__"}},
+	}
+	for _, test := range testCases {
+		m := ctx.ModuleForTests(test.name, "android_common").Module().(*mockLibraryModule)
+		if !reflect.DeepEqual(m.effectiveVisibility, test.want) {
+			t.Errorf("expected effective visibility of %s %q, got %q", test.name, test.want, m.effectiveVisibility)
+		}
+	}
+}
+
+func TestCanonicalizeVisibilityRules(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rules    compositeRule
+		expected string
+	}{
+		{
+			name:     "empty",
+			rules:    compositeRule{},
+			expected: "[]",
+		},
+		{
+			name:     "private",
+			rules:    compositeRule{privateRule{}},
+			expected: "[//visibility:private]",
+		},
+		{
+			name:     "public overrides packages",
+			rules:    compositeRule{packageRule{"top"}, publicRule{}},
+			expected: "[//visibility:public]",
+		},
+		{
+			name:     "duplicates",
+			rules:    compositeRule{packageRule{"top"}, subpackagesRule{"other"}, packageRule{"top"}, subpackagesRule{"other"}},
+			expected: "[//other:__subpackages__, //top:__pkg__]",
+		},
+		{
+			name: "implied by subpackages",
+			rules: compositeRule{packageRule{"top/nested"}, subpackagesRule{"top/nested/deeper"},
+				subpackagesRule{"top"}, packageRule{"top"}},
+			expected: "[//top:__subpackages__]",
+		},
+		{
+			name:     "sibling prefixes",
+			rules:    compositeRule{packageRule{"top-other"}, subpackagesRule{"top"}, subpackagesRule{"top-nested"}},
+			expected: "[//top:__subpackages__, //top-nested:__subpackages__, //top-other:__pkg__]",
+		},
+		{
+			name:     "namespaces",
+			rules:    compositeRule{namespaceRule{"vendor/b"}, subpackagesRule{"vendor"}, namespaceRule{"vendor/a"}, namespaceRule{"vendor/b"}},
+			expected: "[//vendor:__subpackages__, //vendor/a:__namespace__, //vendor/b:__namespace__]",
+		},
+		{
+			name: "groups",
+			rules: compositeRule{groupRule{group: qualifiedModuleName{pkg: "groups", name: "b"}}, packageRule{"top"},
+				groupRule{group: qualifiedModuleName{pkg: "groups", name: "a"}},
+				groupRule{group: qualifiedModuleName{pkg: "groups", name: "b"}}},
+			expected: "[//top:__pkg__, //groups:a, //groups:b]",
+		},
+		{
+			name: "exclusions",
+			rules: compositeRule{exclusionRule{subpackagesRule{"top/tests"}}, subpackagesRule{"top"},
+				exclusionRule{packageRule{"top/internal"}}, exclusionRule{subpackagesRule{"top/tests"}}},
+			expected: "[//top:__subpackages__, -//top/internal:__pkg__, -//top/tests:__subpackages__]",
+		},
+		{
+			name:     "public with exclusions",
+			rules:    compositeRule{packageRule{"top"}, exclusionRule{packageRule{"other"}}, publicRule{}},
+			expected: "[//visibility:public, -//other:__pkg__]",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if got := canonicalizeRules(test.rules).String(); got != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompiledVisibilityRule(t *testing.T) {
+	config := TestConfig(buildDir, nil)
+
+	rule := compiledVisibilityRule(config, compositeRule{subpackagesRule{"top/nested"}, packageRule{"other"}})
+	shared := compiledVisibilityRule(config, compositeRule{packageRule{"other"}, subpackagesRule{"top/nested"},
+		packageRule{"top/nested/deeper"}})
+	if rule != shared {
+		t.Errorf("expected equivalent rules %s and %s to be shared", rule, shared)
+	}
+
+	testCases := []struct {
+		pkg     string
+		matches bool
+	}{
+		{"other", true},
+		{"other/nested", false},
+		{"top", false},
+		{"top/nested", true},
+		{"top/nested/deeper", true},
+		{"top/nested-other", false},
+	}
+
+	for _, test := range testCases {
+		if got := rule.matches(qualifiedModuleName{pkg: test.pkg, name: "libexample"}); got != test.matches {
+			t.Errorf("expected %s to match //%s %v, got %v", rule, test.pkg, test.matches, got)
+		}
+	}
+}
+
+func TestCompiledVisibilityRuleExclusions(t *testing.T) {
+	config := TestConfig(buildDir, nil)
+
+	rules := compositeRule{subpackagesRule{"top"}, exclusionRule{subpackagesRule{"top/tests"}},
+		exclusionRule{packageRule{"top/internal"}}}
+	rule := compiledVisibilityRule(config, rules)
+
+	testCases := []struct {
+		pkg     string
+		matches bool
+	}{
+		{"top", true},
+		{"top/nested", true},
+		{"top/tests", false},
+		{"top/tests/unit", false},
+		{"top/tests-other", true},
+		{"top/internal", false},
+		{"top/internal/deeper", true},
+	}
+
+	for _, test := range testCases {
+		m := qualifiedModuleName{pkg: test.pkg, name: "libexample"}
+		if got := rule.matches(m); got != test.matches {
+			t.Errorf("expected %s to match //%s %v, got %v", rule, test.pkg, test.matches, got)
+		}
+		if got := rules.matches(m); got != test.matches {
+			t.Errorf("expected uncompiled %s to match //%s %v, got %v", rules, test.pkg, test.matches, got)
+		}
+	}
+}
+
+func testNamespaceVisibility(fs map[string][]byte) []error {
+	config := TestArchConfig(buildDir, nil)
+
+	ctx := NewTestArchContext()
+	ctx.RegisterModuleType("mock_library", ModuleFactoryAdaptor(newMockLibraryModule))
+	ctx.RegisterModuleType("soong_namespace", ModuleFactoryAdaptor(NamespaceFactory))
+	ctx.PreArchMutators(RegisterNamespaceMutator)
+	ctx.PreArchMutators(registerVisibilityRuleChecker)
+	ctx.PreArchMutators(registerVisibilityRuleGatherer)
+	ctx.PostDepsMutators(registerVisibilityRuleEnforcer)
+	ctx.Register()
+
+	ctx.MockFileSystem(fs)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp")
+	if len(errs) > 0 {
+		return errs
+	}
+
+	_, errs = ctx.PrepareBuildActions(config)
+	return errs
+}
+
+func TestNamespaceVisibility(t *testing.T) {
+	errs := testNamespaceVisibility(map[string][]byte{
+		"Android.bp": nil,
+		"vendor/lib/Android.bp": []byte(`
+			soong_namespace {
+			}
+			mock_library {
+				name: "libexample",
+				visibility: ["//vendor/apps:__namespace__"],
+			}`),
+		"vendor/apps/Android.bp": []byte(`
+			soong_namespace {
+			}`),
+		"vendor/apps/Foo/Android.bp": []byte(`
+			mock_library {
+				name: "libfoo",
+				deps: ["//vendor/lib:libexample"],
+			}`),
+		"vendor/apps/nested/Android.bp": []byte(`
+			soong_namespace {
+			}
+			mock_library {
+				name: "libnested",
+				deps: ["//vendor/lib:libexample"],
+			}`),
+		"vendor/other/Android.bp": []byte(`
+			mock_library {
+				name: "libother",
+				deps: ["//vendor/lib:libexample"],
+			}`),
+	})
+
+	FailIfNoMatchingErrors(t, `module "libnested" variant "android_common": depends on //vendor/lib:libexample which is not visible`, errs)
+	FailIfNoMatchingErrors(t, `module "libother" variant "android_common": depends on //vendor/lib:libexample which is not visible`, errs)
+	for _, err := range errs {
+		if strings.Contains(err.Error(), `"libfoo"`) {
+			t.Errorf("expected libfoo in the //vendor/apps namespace to see libexample, got %s", err)
+		}
+	}
+}
+
+func TestNamespaceVisibilityUnknownNamespace(t *testing.T) {
+	errs := testNamespaceVisibility(map[string][]byte{
+		"Android.bp": nil,
+		"vendor/lib/Android.bp": []byte(`
+			soong_namespace {
+			}
+			mock_library {
+				name: "libexample",
+				visibility: [
+					"//vendor/apps:__namespace__",
+					"//vendor/apps/Foo:__namespace__",
+				],
+			}`),
+		"vendor/apps/Android.bp": []byte(`
+			soong_namespace {
+			}`),
+		"vendor/apps/Foo/Android.bp": []byte(`
+			mock_library {
+				name: "libfoo",
+			}`),
+	})
+
+	FailIfNoMatchingErrors(t, `module "libexample": visibility: "//vendor/apps/Foo:__namespace__" refers to //vendor/apps/Foo, which doesn't declare a soong_namespace`, errs)
+	for _, err := range errs {
+		if strings.Contains(err.Error(), `"//vendor/apps:__namespace__"`) {
+			t.Errorf("expected //vendor/apps:__namespace__ to be accepted, got %s", err)
+		}
+	}
+}
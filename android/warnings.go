@@ -0,0 +1,61 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Warnings reported during analysis are collected in the config instead of being printed by the
+// goroutines that report them, so that soong_build can print them once analysis has finished,
+// sorted and without duplicates, and so that tests can check them.
+
+var warningsKey = NewOnceKey("warnings")
+
+type warningsLog struct {
+	sync.Mutex
+	warnings []string
+}
+
+func (c *config) warningsLog() *warningsLog {
+	return c.Once(warningsKey, func() interface{} {
+		return &warningsLog{}
+	}).(*warningsLog)
+}
+
+// Warningf records a warning that is not specific to a module.
+func (c *config) Warningf(format string, args ...interface{}) {
+	log := c.warningsLog()
+	log.Lock()
+	defer log.Unlock()
+	log.warnings = append(log.warnings, fmt.Sprintf(format, args...))
+}
+
+// Warnings returns the warnings recorded so far, sorted and without duplicates.
+func (c *config) Warnings() []string {
+	log := c.warningsLog()
+	log.Lock()
+	defer log.Unlock()
+	warnings := FirstUniqueStrings(CopyOf(log.warnings))
+	sort.Strings(warnings)
+	return warnings
+}
+
+// ModuleWarningf records a warning about the current module.
+func (b *baseModuleContext) ModuleWarningf(format string, args ...interface{}) {
+	b.Config().Warningf("module %q: %s", b.ModuleName(), fmt.Sprintf(format, args...))
+}
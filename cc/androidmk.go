@@ -181,6 +181,35 @@ func (library *libraryDecorator) androidMkWriteAdditionalDependenciesForSourceAb
 	}
 }
 
+// makeClass returns the class that the AndroidMk method of the linker of the module sets, or an empty string if
+// it sets none, e.g. for objects.  It is listed in module-info.json.
+func (c *Module) makeClass() string {
+	switch library := c.linker.(type) {
+	case *testBinary, *benchmarkDecorator:
+		return "NATIVE_TESTS"
+	case *binaryDecorator, *prebuiltBinaryLinker:
+		return "EXECUTABLES"
+	case *toolchainLibraryDecorator:
+		return "STATIC_LIBRARIES"
+	case *stubDecorator, *llndkStubDecorator, *vndkPrebuiltLibraryDecorator, *ndkPrebuiltStlLinker,
+		*vendorPublicLibraryStubDecorator:
+		return "SHARED_LIBRARIES"
+	case interface {
+		static() bool
+		shared() bool
+		header() bool
+	}:
+		if library.static() {
+			return "STATIC_LIBRARIES"
+		} else if library.shared() {
+			return "SHARED_LIBRARIES"
+		} else if library.header() {
+			return "HEADER_LIBRARIES"
+		}
+	}
+	return ""
+}
+
 func (library *libraryDecorator) AndroidMk(ctx AndroidMkContext, ret *android.AndroidMkData) {
 	if library.static() {
 		ret.Class = "STATIC_LIBRARIES"
@@ -425,6 +425,14 @@ func (c *Module) OutputFile() android.OptionalPath {
 	return c.outputFile
 }
 
+// Toc returns the table of contents of a shared library, the list of its dynamic symbols, if the module has one.
+func (c *Module) Toc() android.OptionalPath {
+	if library, ok := c.linker.(libraryInterface); ok {
+		return library.toc()
+	}
+	return android.OptionalPath{}
+}
+
 func (c *Module) UnstrippedOutputFile() android.Path {
 	if c.linker != nil {
 		return c.linker.unstrippedOutputFilePath()
@@ -1045,6 +1053,12 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 		}
 	}
 
+	if !c.Properties.HideFromMake && c.IsForPlatform() {
+		if class := c.makeClass(); class != "" {
+			ctx.SetProvider(android.MakeClassProvider, class)
+		}
+	}
+
 	if c.installable() {
 		c.installer.install(ctx, c.outputFile.Path())
 		if ctx.Failed() {
@@ -0,0 +1,127 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"android/soong/android"
+)
+
+// The linux_bionic arm64 toolchain builds host tools that run natively on arm64 build machines.  It
+// shares the flags of the x86_64 linux_bionic toolchain, except for the ones that select the
+// architecture.
+
+var (
+	linuxBionicArm64Cflags = []string{
+		// Tell clang where the gcc toolchain is
+		"--gcc-toolchain=${LinuxBionicArm64GccRoot}",
+	}
+
+	linuxBionicArm64Ldflags = []string{
+		"-Wl,--fix-cortex-a53-843419",
+
+		// Use the device gcc toolchain
+		"--gcc-toolchain=${LinuxBionicArm64GccRoot}",
+	}
+)
+
+func init() {
+	pctx.StaticVariable("LinuxBionicArm64Cflags", strings.Join(linuxBionicArm64Cflags, " "))
+	pctx.StaticVariable("LinuxBionicArm64Ldflags", strings.Join(linuxBionicArm64Ldflags, " "))
+
+	pctx.StaticVariable("LinuxBionicArm64IncludeFlags", bionicHeaders("arm64"))
+
+	// Use the device gcc toolchain for now
+	pctx.StaticVariable("LinuxBionicArm64GccRoot", "${Arm64GccRoot}")
+}
+
+type toolchainLinuxBionicArm64 struct {
+	toolchain64Bit
+}
+
+func (t *toolchainLinuxBionicArm64) Name() string {
+	return "arm64"
+}
+
+func (t *toolchainLinuxBionicArm64) GccRoot() string {
+	return "${config.LinuxBionicArm64GccRoot}"
+}
+
+func (t *toolchainLinuxBionicArm64) GccTriple() string {
+	return "aarch64-linux-android"
+}
+
+func (t *toolchainLinuxBionicArm64) GccVersion() string {
+	return "4.9"
+}
+
+func (t *toolchainLinuxBionicArm64) IncludeFlags() string {
+	return "${config.LinuxBionicArm64IncludeFlags}"
+}
+
+func (t *toolchainLinuxBionicArm64) ClangTriple() string {
+	// TODO: we don't have a triple yet b/31393676
+	return "aarch64-linux-android"
+}
+
+func (t *toolchainLinuxBionicArm64) ClangCflags() string {
+	// The x86_64 flags that aren't architecture specific
+	return "${config.LinuxBionicCflags} ${config.LinuxBionicArm64Cflags}"
+}
+
+func (t *toolchainLinuxBionicArm64) ClangCppflags() string {
+	return ""
+}
+
+func (t *toolchainLinuxBionicArm64) ClangLdflags() string {
+	return "${config.LinuxBionicLdflags} ${config.LinuxBionicArm64Ldflags}"
+}
+
+func (t *toolchainLinuxBionicArm64) ClangLldflags() string {
+	return "${config.LinuxBionicLldflags} ${config.LinuxBionicArm64Ldflags}"
+}
+
+func (t *toolchainLinuxBionicArm64) ToolchainClangCflags() string {
+	return "-march=armv8-a" +
+		// TODO: We're not really android, but we don't have a triple yet b/31393676
+		" -U__ANDROID__"
+}
+
+func (t *toolchainLinuxBionicArm64) ToolchainClangLdflags() string {
+	return ""
+}
+
+func (t *toolchainLinuxBionicArm64) AvailableLibraries() []string {
+	return nil
+}
+
+func (t *toolchainLinuxBionicArm64) Bionic() bool {
+	return true
+}
+
+func (toolchainLinuxBionicArm64) LibclangRuntimeLibraryArch() string {
+	return "aarch64"
+}
+
+var toolchainLinuxBionicArm64Singleton Toolchain = &toolchainLinuxBionicArm64{}
+
+func linuxBionicArm64ToolchainFactory(arch android.Arch) Toolchain {
+	return toolchainLinuxBionicArm64Singleton
+}
+
+func init() {
+	registerToolchainFactory(android.LinuxBionic, android.Arm64, linuxBionicArm64ToolchainFactory)
+}
@@ -62,6 +62,10 @@ type TestBinaryProperties struct {
 	// should be installed with the module.
 	Test_config_template *string `android:"path,arch_variant"`
 
+	// if set to false, don't generate a test config when test_config is not set and there is no
+	// AndroidTest.xml in the module directory.  Defaults to true.
+	Auto_gen_config *bool
+
 	// Test options.
 	Test_options TestOptions
 
@@ -289,7 +293,11 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 	}
 
 	test.testConfig = tradefed.AutoGenNativeTestConfig(ctx, test.Properties.Test_config,
-		test.Properties.Test_config_template, test.Properties.Test_suites, configs)
+		test.Properties.Test_config_template, test.Properties.Test_suites, configs, test.Properties.Auto_gen_config)
+	ctx.SetProvider(android.TestInfoProvider, android.TestInfo{
+		Config: test.testConfig,
+		Suites: test.Properties.Test_suites,
+	})
 
 	test.binaryDecorator.baseInstaller.dir = "nativetest"
 	test.binaryDecorator.baseInstaller.dir64 = "nativetest64"
@@ -377,6 +385,10 @@ type BenchmarkProperties struct {
 	// should be installed with the module.
 	Test_config_template *string `android:"path,arch_variant"`
 
+	// if set to false, don't generate a test config when test_config is not set and there is no
+	// AndroidTest.xml in the module directory.  Defaults to true.
+	Auto_gen_config *bool
+
 	// Add RootTargetPreparer to auto generated test config. This guarantees the test to run
 	// with root permission.
 	Require_root *bool
@@ -417,7 +429,12 @@ func (benchmark *benchmarkDecorator) install(ctx ModuleContext, file android.Pat
 		configs = append(configs, tradefed.Preparer{"com.android.tradefed.targetprep.RootTargetPreparer"})
 	}
 	benchmark.testConfig = tradefed.AutoGenNativeBenchmarkTestConfig(ctx, benchmark.Properties.Test_config,
-		benchmark.Properties.Test_config_template, benchmark.Properties.Test_suites, configs)
+		benchmark.Properties.Test_config_template, benchmark.Properties.Test_suites, configs,
+		benchmark.Properties.Auto_gen_config)
+	ctx.SetProvider(android.TestInfoProvider, android.TestInfo{
+		Config: benchmark.testConfig,
+		Suites: benchmark.Properties.Test_suites,
+	})
 
 	benchmark.binaryDecorator.baseInstaller.dir = filepath.Join("benchmarktest", ctx.ModuleName())
 	benchmark.binaryDecorator.baseInstaller.dir64 = filepath.Join("benchmarktest64", ctx.ModuleName())
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/google/blueprint/bootstrap"
 
@@ -26,11 +27,17 @@ import (
 )
 
 var (
-	docFile string
+	docFile           string
+	explainVisibility string
+	moduleGraphFile   string
 )
 
 func init() {
 	flag.StringVar(&docFile, "soong_docs", "", "build documentation file to output")
+	flag.StringVar(&explainVisibility, "explain_visibility", "",
+		"<from>,<to>: explain whether module <to> is visible to module <from> instead of building")
+	flag.StringVar(&moduleGraphFile, "module_graph", "",
+		"JSON file to write the module graph to, with every variant of every module and its dependencies")
 }
 
 func newNameResolver(config android.Config) *android.NameResolver {
@@ -64,20 +71,54 @@ func main() {
 		os.Exit(1)
 	}
 
+	configuration.SetParseErrorsAnnotator(ctx.AnnotateUnknownPropertyErrors)
+
+	if moduleGraphFile != "" {
+		configuration.SetModuleGraphFile(moduleGraphFile)
+	}
+
 	if docFile != "" {
 		configuration.SetStopBefore(bootstrap.StopBeforePrepareBuildActions)
 	}
 
-	ctx.SetNameInterface(newNameResolver(configuration))
+	var from, to string
+	if explainVisibility != "" {
+		modules := strings.Split(explainVisibility, ",")
+		if len(modules) != 2 {
+			fmt.Fprintf(os.Stderr, "-explain_visibility expects <from>,<to>, got %q\n", explainVisibility)
+			os.Exit(1)
+		}
+		from, to = modules[0], modules[1]
+		// The dependencies on modules that are not visible would stop the build before they can be
+		// explained.
+		configuration.SetVisibilityWarnings()
+		configuration.SetStopBefore(bootstrap.StopBeforePrepareBuildActions)
+	}
+
+	nameResolver := newNameResolver(configuration)
+	ctx.SetNameInterface(nameResolver)
 
 	ctx.SetAllowMissingDependencies(configuration.AllowMissingDependencies())
 
 	bootstrap.Main(ctx.Context, configuration, configuration.ConfigFileName, configuration.ProductVariablesFileName)
 
+	for _, warning := range configuration.Warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+
 	if docFile != "" {
 		if err := writeDocs(ctx, docFile); err != nil {
 			fmt.Fprintf(os.Stderr, "%s", err)
 			os.Exit(1)
 		}
 	}
+
+	if explainVisibility != "" {
+		explanation, err := android.ExplainVisibility(ctx, configuration, nameResolver, from, to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(explanation)
+	}
 }
@@ -0,0 +1,196 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// soong_query answers questions about the modules in the build, like which modules install to a
+// directory, which apps are signed with a certificate or which modules depend on a module, from
+// the module metadata that is built by the soong_module_metadata target when the build is run with
+// SOONG_COLLECT_MODULE_METADATA=true.  It doesn't regenerate or read any ninja files.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	metadataFile = flag.String("metadata", "", "module metadata file (defaults to $OUT_DIR/soong/module_metadata.json)")
+	transitive   = flag.Bool("transitive", false, "include transitive reverse dependencies in rdeps queries")
+)
+
+// moduleMetadata matches android.ModuleMetadata.
+type moduleMetadata struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Variant     string   `json:"variant,omitempty"`
+	Dir         string   `json:"dir"`
+	Installed   []string `json:"installed,omitempty"`
+	Deps        []string `json:"deps,omitempty"`
+	Certificate string   `json:"certificate,omitempty"`
+}
+
+type query struct {
+	name  string
+	usage string
+	run   func(modules []moduleMetadata, arg string) []moduleMetadata
+}
+
+var queries = []query{
+	{"module", "the variants of the module <arg>", queryModule},
+	{"installed", "the modules that install files to or under the path <arg>, e.g. /system/priv-app", queryInstalled},
+	{"certificate", "the apps that are signed with the certificate <arg>, either a path or a name like platform", queryCertificate},
+	{"rdeps", "the modules that depend on the module <arg>", func(modules []moduleMetadata, name string) []moduleMetadata {
+		return queryReverseDeps(modules, name, *transitive)
+	}},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: soong_query [-metadata <file>] [-transitive] <query> <arg>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Prints the metadata of the matching modules as JSON.  Queries:")
+	for _, q := range queries {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", q.name, q.usage)
+	}
+	fmt.Fprintln(os.Stderr, "")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+	}
+
+	var run func([]moduleMetadata, string) []moduleMetadata
+	for _, q := range queries {
+		if q.name == flag.Arg(0) {
+			run = q.run
+		}
+	}
+	if run == nil {
+		fmt.Fprintf(os.Stderr, "unknown query %q\n", flag.Arg(0))
+		usage()
+	}
+
+	file := *metadataFile
+	if file == "" {
+		outDir := os.Getenv("OUT_DIR")
+		if outDir == "" {
+			outDir = "out"
+		}
+		file = filepath.Join(outDir, "soong", "module_metadata.json")
+	}
+
+	modules, err := readMetadata(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "build soong_module_metadata with SOONG_COLLECT_MODULE_METADATA=true to generate it")
+		os.Exit(1)
+	}
+
+	buf, err := json.MarshalIndent(run(modules, flag.Arg(1)), "", "\t")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(buf))
+}
+
+func readMetadata(file string) ([]moduleMetadata, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var modules []moduleMetadata
+	if err := json.Unmarshal(buf, &modules); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", file, err)
+	}
+	return modules, nil
+}
+
+func filter(modules []moduleMetadata, pred func(moduleMetadata) bool) []moduleMetadata {
+	ret := []moduleMetadata{}
+	for _, m := range modules {
+		if pred(m) {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+func queryModule(modules []moduleMetadata, name string) []moduleMetadata {
+	return filter(modules, func(m moduleMetadata) bool {
+		return m.Name == name
+	})
+}
+
+func queryInstalled(modules []moduleMetadata, path string) []moduleMetadata {
+	path = filepath.Clean(path)
+	return filter(modules, func(m moduleMetadata) bool {
+		for _, installed := range m.Installed {
+			if installed == path || strings.HasPrefix(installed, path+"/") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func queryCertificate(modules []moduleMetadata, cert string) []moduleMetadata {
+	cert = strings.TrimSuffix(cert, ".x509.pem")
+	return filter(modules, func(m moduleMetadata) bool {
+		return m.Certificate != "" && (m.Certificate == cert || filepath.Base(m.Certificate) == cert)
+	})
+}
+
+func queryReverseDeps(modules []moduleMetadata, name string, transitive bool) []moduleMetadata {
+	targets := map[string]bool{name: true}
+	matched := make(map[int]bool)
+	for {
+		found := false
+		for i, m := range modules {
+			if matched[i] {
+				continue
+			}
+			for _, dep := range m.Deps {
+				if targets[dep] {
+					matched[i] = true
+					found = true
+					if transitive {
+						targets[m.Name] = true
+					}
+					break
+				}
+			}
+		}
+		if !found || !transitive {
+			break
+		}
+	}
+
+	ret := []moduleMetadata{}
+	for i, m := range modules {
+		if matched[i] {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
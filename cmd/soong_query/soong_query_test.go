@@ -0,0 +1,146 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testModules = []moduleMetadata{
+	{
+		Name:        "Settings",
+		Type:        "android_app",
+		Variant:     "android_common",
+		Installed:   []string{"/system/priv-app/Settings/Settings.apk"},
+		Deps:        []string{"SettingsLib", "libjni"},
+		Certificate: "build/make/target/product/security/platform",
+	},
+	{
+		Name:        "Camera",
+		Type:        "android_app_import",
+		Variant:     "android_common",
+		Installed:   []string{"/system/app/Camera/Camera.apk"},
+		Certificate: "PRESIGNED",
+	},
+	{
+		Name:    "SettingsLib",
+		Type:    "android_library",
+		Variant: "android_common",
+		Deps:    []string{"libjni"},
+	},
+	{
+		Name:    "SettingsTests",
+		Type:    "android_test",
+		Variant: "android_common",
+		Deps:    []string{"Settings"},
+	},
+	{
+		Name:      "libjni",
+		Type:      "cc_library_shared",
+		Variant:   "android_arm64_armv8-a_core_shared",
+		Installed: []string{"/system/lib64/libjni.so"},
+	},
+}
+
+func names(modules []moduleMetadata) []string {
+	ret := []string{}
+	for _, m := range modules {
+		ret = append(ret, m.Name)
+	}
+	return ret
+}
+
+func TestQueries(t *testing.T) {
+	testCases := []struct {
+		name string
+		run  func([]moduleMetadata, string) []moduleMetadata
+		arg  string
+		want []string
+	}{
+		{
+			name: "module",
+			run:  queryModule,
+			arg:  "libjni",
+			want: []string{"libjni"},
+		},
+		{
+			name: "installed directory",
+			run:  queryInstalled,
+			arg:  "/system/priv-app",
+			want: []string{"Settings"},
+		},
+		{
+			name: "installed directory with trailing slash",
+			run:  queryInstalled,
+			arg:  "/system/app/",
+			want: []string{"Camera"},
+		},
+		{
+			name: "installed file",
+			run:  queryInstalled,
+			arg:  "/system/lib64/libjni.so",
+			want: []string{"libjni"},
+		},
+		{
+			name: "installed prefix is not a directory",
+			run:  queryInstalled,
+			arg:  "/system/lib",
+			want: []string{},
+		},
+		{
+			name: "certificate name",
+			run:  queryCertificate,
+			arg:  "platform",
+			want: []string{"Settings"},
+		},
+		{
+			name: "certificate path",
+			run:  queryCertificate,
+			arg:  "build/make/target/product/security/platform.x509.pem",
+			want: []string{"Settings"},
+		},
+		{
+			name: "presigned",
+			run:  queryCertificate,
+			arg:  "PRESIGNED",
+			want: []string{"Camera"},
+		},
+		{
+			name: "rdeps",
+			run: func(modules []moduleMetadata, name string) []moduleMetadata {
+				return queryReverseDeps(modules, name, false)
+			},
+			arg:  "libjni",
+			want: []string{"Settings", "SettingsLib"},
+		},
+		{
+			name: "transitive rdeps",
+			run: func(modules []moduleMetadata, name string) []moduleMetadata {
+				return queryReverseDeps(modules, name, true)
+			},
+			arg:  "libjni",
+			want: []string{"Settings", "SettingsLib", "SettingsTests"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			if got := names(test.run(testModules, test.arg)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
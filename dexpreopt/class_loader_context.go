@@ -0,0 +1,230 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dexpreopt
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"android/soong/android"
+)
+
+// AnySdkVersion is the SDK version of class loader contexts that are used regardless of the targetSdkVersion of the
+// module being dexpreopted.
+const AnySdkVersion int = 10000
+
+// ClassLoaderContext is the class loader context of a <uses-library>: the dex jar of the library and the class
+// loader contexts of the shared libraries that it uses itself.
+type ClassLoaderContext struct {
+	// The name of the library, as used in <uses-library> tags.
+	Name string
+
+	// The path to the dex jar of the library in the build.
+	Host android.Path
+
+	// The path to the dex jar of the library on the device.
+	Device string
+
+	// The class loader contexts of the shared libraries used by the library.
+	Subcontexts []*ClassLoaderContext
+}
+
+// ClassLoaderContextMap maps an SDK version to the class loader contexts of the libraries that are added to the class
+// loader of a module when its targetSdkVersion is lower than the SDK version.  The contexts that are always added are
+// stored under AnySdkVersion.
+type ClassLoaderContextMap map[int][]*ClassLoaderContext
+
+// AddContext adds the class loader context of a library for an SDK version.  A library that is already in the
+// contexts for the SDK version is not added again.
+func (clcMap ClassLoaderContextMap) AddContext(sdkVer int, lib string, hostPath android.Path, devicePath string,
+	subcontexts []*ClassLoaderContext) error {
+
+	if hostPath == nil {
+		return fmt.Errorf("unknown build path to <uses-library> %q", lib)
+	}
+	if devicePath == "" {
+		return fmt.Errorf("unknown install path to <uses-library> %q", lib)
+	}
+
+	for _, clc := range clcMap[sdkVer] {
+		if clc.Name == lib {
+			return nil
+		}
+	}
+
+	clcMap[sdkVer] = append(clcMap[sdkVer], &ClassLoaderContext{
+		Name:        lib,
+		Host:        hostPath,
+		Device:      devicePath,
+		Subcontexts: subcontexts,
+	})
+	return nil
+}
+
+// AddContextMap adds the class loader contexts of another map, for example the shared libraries exported by a
+// dependency.
+func (clcMap ClassLoaderContextMap) AddContextMap(otherMap ClassLoaderContextMap) {
+	for sdkVer, clcs := range otherMap {
+		for _, clc := range clcs {
+			// The contexts in otherMap have already been checked for valid paths.
+			clcMap.AddContext(sdkVer, clc.Name, clc.Host, clc.Device, clc.Subcontexts)
+		}
+	}
+}
+
+// UsesLibs returns the names of the libraries whose contexts are used regardless of the targetSdkVersion.
+func (clcMap ClassLoaderContextMap) UsesLibs() []string {
+	var libs []string
+	for _, clc := range clcMap[AnySdkVersion] {
+		libs = append(libs, clc.Name)
+	}
+	return libs
+}
+
+// sortedSdkVersions returns the SDK versions in the map in increasing order, AnySdkVersion is last.
+func (clcMap ClassLoaderContextMap) sortedSdkVersions() []int {
+	var versions []int
+	for sdkVer := range clcMap {
+		versions = append(versions, sdkVer)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// hostPaths returns the paths in the build of all the libraries in the map, including nested ones.
+func (clcMap ClassLoaderContextMap) hostPaths() android.Paths {
+	var paths android.Paths
+	for _, sdkVer := range clcMap.sortedSdkVersions() {
+		paths = append(paths, hostPathsRec(clcMap[sdkVer])...)
+	}
+	return android.FirstUniquePaths(paths)
+}
+
+func hostPathsRec(clcs []*ClassLoaderContext) android.Paths {
+	var paths android.Paths
+	for _, clc := range clcs {
+		paths = append(paths, clc.Host)
+		paths = append(paths, hostPathsRec(clc.Subcontexts)...)
+	}
+	return paths
+}
+
+// String returns the class loader context of a library in the dex2oat format, for example
+// PCL[/system/framework/foo.jar]{PCL[/system/framework/bar.jar]}, using either host or device paths.
+func (clc *ClassLoaderContext) String(host bool) string {
+	path := clc.Device
+	if host {
+		path = clc.Host.String()
+	}
+
+	var subcontexts []string
+	for _, sub := range clc.Subcontexts {
+		subcontexts = append(subcontexts, sub.String(host))
+	}
+
+	s := "PCL[" + path + "]"
+	if len(subcontexts) > 0 {
+		s += "{" + strings.Join(subcontexts, "#") + "}"
+	}
+	return s
+}
+
+// sdkVersionArg returns the SDK version as it is passed to construct_context.
+func sdkVersionArg(sdkVer int) string {
+	if sdkVer == AnySdkVersion {
+		return "any"
+	}
+	return strconv.Itoa(sdkVer)
+}
+
+// jsonClassLoaderContext is the JSON form of a ClassLoaderContext in the module dexpreopt.config written by Make.
+type jsonClassLoaderContext struct {
+	Name        string
+	Host        string
+	Device      string
+	Subcontexts []*jsonClassLoaderContext
+}
+
+func constructClassLoaderContextMap(ctx android.PathContext,
+	jsonMap map[int][]*jsonClassLoaderContext) ClassLoaderContextMap {
+
+	clcMap := make(ClassLoaderContextMap)
+	for sdkVer, jsonClcs := range jsonMap {
+		clcMap[sdkVer] = constructClassLoaderContexts(ctx, jsonClcs)
+	}
+	return clcMap
+}
+
+// Libraries that Make adds to the class loader contexts of the modules that target an older SDK than the SDK
+// version of the library, because they used to be in the default classpath.
+var makeCompatLibraries = []struct {
+	sdkVer int
+	name   string
+	device string
+}{
+	{28, "org.apache.http.legacy", "/system/framework/org.apache.http.legacy.impl.jar"},
+	{29, "android.hidl.manager-V1.0-java", "/system/framework/android.hidl.manager-V1.0-java.jar"},
+	{29, "android.hidl.base-V1.0-java", "/system/framework/android.hidl.base-V1.0-java.jar"},
+}
+
+// libraryPathsToClassLoaderContexts converts the <uses-library> names and the paths of the libraries in the module
+// dexpreopt.config written by Make into class loader contexts.  Make doesn't know the libraries used by the
+// libraries, so the contexts aren't nested.  If the libraries are listed in a file generated at build time all the
+// libraries with known paths are added, and construct_context picks the ones in the file.
+func libraryPathsToClassLoaderContexts(ctx android.PathContext, usesLibs, optionalUsesLibs []string,
+	libraryPaths map[string]string, fromFile bool) (ClassLoaderContextMap, error) {
+
+	clcMap := make(ClassLoaderContextMap)
+	libs := append(append([]string(nil), usesLibs...), optionalUsesLibs...)
+	if fromFile {
+		libs = nil
+		for lib, path := range libraryPaths {
+			if path != "" {
+				libs = append(libs, lib)
+			}
+		}
+		sort.Strings(libs)
+	}
+	for _, lib := range libs {
+		err := clcMap.AddContext(AnySdkVersion, lib, constructPath(ctx, libraryPaths[lib]),
+			"/system/framework/"+lib+".jar", nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, compat := range makeCompatLibraries {
+		err := clcMap.AddContext(compat.sdkVer, compat.name, constructPath(ctx, libraryPaths[compat.name]),
+			compat.device, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return clcMap, nil
+}
+
+func constructClassLoaderContexts(ctx android.PathContext, jsonClcs []*jsonClassLoaderContext) []*ClassLoaderContext {
+	var clcs []*ClassLoaderContext
+	for _, jsonClc := range jsonClcs {
+		clcs = append(clcs, &ClassLoaderContext{
+			Name:        jsonClc.Name,
+			Host:        constructPath(ctx, jsonClc.Host),
+			Device:      jsonClc.Device,
+			Subcontexts: constructClassLoaderContexts(ctx, jsonClc.Subcontexts),
+		})
+	}
+	return clcs
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dexpreopt
+
+import (
+	"reflect"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestClassLoaderContext(t *testing.T) {
+	ctx := android.PathContextForTesting(android.TestConfig("out", nil), nil)
+
+	// bar uses baz, foo uses bar.
+	baz := ClassLoaderContextMap{}
+	if err := baz.AddContext(AnySdkVersion, "baz", android.PathForOutput(ctx, "baz.jar"),
+		"/system/framework/baz.jar", nil); err != nil {
+		t.Fatal(err)
+	}
+	bar := ClassLoaderContextMap{}
+	if err := bar.AddContext(AnySdkVersion, "bar", android.PathForOutput(ctx, "bar.jar"),
+		"/product/framework/bar.jar", baz[AnySdkVersion]); err != nil {
+		t.Fatal(err)
+	}
+
+	clcMap := ClassLoaderContextMap{}
+	clcMap.AddContext(AnySdkVersion, "foo", android.PathForOutput(ctx, "foo.jar"), "/system/framework/foo.jar", nil)
+	clcMap.AddContextMap(bar)
+	clcMap.AddContext(28, "org.apache.http.legacy", android.PathForOutput(ctx, "http.jar"),
+		"/system/framework/org.apache.http.legacy.impl.jar", nil)
+	// Adding a library again is ignored.
+	clcMap.AddContext(AnySdkVersion, "foo", android.PathForOutput(ctx, "other.jar"), "/system/framework/other.jar", nil)
+
+	if got, want := clcMap.UsesLibs(), []string{"foo", "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UsesLibs() = %q, want %q", got, want)
+	}
+
+	if got, want := clcMap.sortedSdkVersions(), []int{28, AnySdkVersion}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortedSdkVersions() = %v, want %v", got, want)
+	}
+
+	if got, want := clcMap.hostPaths().Strings(), []string{"out/http.jar", "out/foo.jar", "out/bar.jar",
+		"out/baz.jar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("hostPaths() = %q, want %q", got, want)
+	}
+
+	barClc := clcMap[AnySdkVersion][1]
+	if got, want := barClc.String(true), "PCL[out/bar.jar]{PCL[out/baz.jar]}"; got != want {
+		t.Errorf("host context = %q, want %q", got, want)
+	}
+	if got, want := barClc.String(false), "PCL[/product/framework/bar.jar]{PCL[/system/framework/baz.jar]}"; got != want {
+		t.Errorf("target context = %q, want %q", got, want)
+	}
+}
+
+func TestClassLoaderContextErrors(t *testing.T) {
+	ctx := android.PathContextForTesting(android.TestConfig("out", nil), nil)
+
+	clcMap := ClassLoaderContextMap{}
+	if err := clcMap.AddContext(AnySdkVersion, "foo", nil, "/system/framework/foo.jar", nil); err == nil {
+		t.Errorf("expected error for missing build path")
+	}
+	if err := clcMap.AddContext(AnySdkVersion, "foo", android.PathForOutput(ctx, "foo.jar"), "", nil); err == nil {
+		t.Errorf("expected error for missing install path")
+	}
+	if len(clcMap) != 0 {
+		t.Errorf("expected no contexts to be added, got %v", clcMap)
+	}
+}
+
+func TestLibraryPathsToClassLoaderContexts(t *testing.T) {
+	ctx := android.PathContextForTesting(android.TestConfig("out", nil), nil)
+
+	libraryPaths := map[string]string{
+		"foo":                            "out/foo.jar",
+		"bar":                            "out/bar.jar",
+		"org.apache.http.legacy":         "out/http.jar",
+		"android.hidl.manager-V1.0-java": "out/manager.jar",
+		"android.hidl.base-V1.0-java":    "out/base.jar",
+	}
+
+	clcMap, err := libraryPathsToClassLoaderContexts(ctx, []string{"foo"}, []string{"bar"}, libraryPaths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clcMap.UsesLibs(), []string{"foo", "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UsesLibs() = %q, want %q", got, want)
+	}
+	if got, want := clcMap[28][0].String(false), "PCL[/system/framework/org.apache.http.legacy.impl.jar]"; got != want {
+		t.Errorf("context for SDK 28 = %q, want %q", got, want)
+	}
+	if got, want := len(clcMap[29]), 2; got != want {
+		t.Errorf("want %d contexts for SDK 29, got %d", want, got)
+	}
+
+	// With a uses libraries file all the libraries with known paths are added.
+	clcMap, err = libraryPathsToClassLoaderContexts(ctx, nil, nil, libraryPaths, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clcMap.UsesLibs(), []string{"android.hidl.base-V1.0-java", "android.hidl.manager-V1.0-java",
+		"bar", "foo", "org.apache.http.legacy"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UsesLibs() = %q, want %q", got, want)
+	}
+
+	if _, err := libraryPathsToClassLoaderContexts(ctx, []string{"baz"}, nil, libraryPaths, false); err == nil {
+		t.Errorf("expected error for a library without a path")
+	}
+}
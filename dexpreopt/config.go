@@ -116,10 +116,15 @@ type ModuleConfig struct {
 	ProfileClassListing  android.OptionalPath
 	ProfileIsTextListing bool
 
-	EnforceUsesLibraries         bool
-	PresentOptionalUsesLibraries []string
-	UsesLibraries                []string
-	LibraryPaths                 map[string]android.Path
+	EnforceUsesLibraries bool
+
+	// The class loader contexts of the shared libraries used by the module, and of the compatibility libraries
+	// that are added for modules with an old targetSdkVersion.
+	ClassLoaderContexts ClassLoaderContextMap
+
+	// A file listing the <uses-library> names of the module, one per line, that is generated at build time.  If
+	// set, only the ClassLoaderContexts for AnySdkVersion of the libraries listed in the file are used.
+	UsesLibrariesFile android.Path
 
 	Archs               []android.ArchType
 	DexPreoptImages     []android.Path
@@ -159,14 +164,6 @@ func constructPaths(ctx android.PathContext, paths []string) android.Paths {
 	return ret
 }
 
-func constructPathMap(ctx android.PathContext, paths map[string]string) map[string]android.Path {
-	ret := map[string]android.Path{}
-	for key, path := range paths {
-		ret[key] = constructPath(ctx, path)
-	}
-	return ret
-}
-
 func constructWritablePath(ctx android.PathContext, path string) android.WritablePath {
 	if path == "" {
 		return nil
@@ -233,11 +230,17 @@ func LoadModuleConfig(ctx android.PathContext, path string) (ModuleConfig, error
 		DexPath                     string
 		ManifestPath                string
 		ProfileClassListing         string
-		LibraryPaths                map[string]string
+		ClassLoaderContexts         map[int][]*jsonClassLoaderContext
+		UsesLibrariesFile           string
 		DexPreoptImages             []string
 		PreoptBootClassPathDexFiles []string
 		StripInputPath              string
 		StripOutputPath             string
+
+		// The libraries of the module as they are written by Make, which are converted to ClassLoaderContexts.
+		UsesLibraries                []string
+		PresentOptionalUsesLibraries []string
+		LibraryPaths                 map[string]string
 	}
 
 	config := ModuleJSONConfig{}
@@ -252,7 +255,16 @@ func LoadModuleConfig(ctx android.PathContext, path string) (ModuleConfig, error
 	config.ModuleConfig.DexPath = constructPath(ctx, config.DexPath)
 	config.ModuleConfig.ManifestPath = constructPath(ctx, config.ManifestPath)
 	config.ModuleConfig.ProfileClassListing = android.OptionalPathForPath(constructPath(ctx, config.ProfileClassListing))
-	config.ModuleConfig.LibraryPaths = constructPathMap(ctx, config.LibraryPaths)
+	config.ModuleConfig.ClassLoaderContexts = constructClassLoaderContextMap(ctx, config.ClassLoaderContexts)
+	config.ModuleConfig.UsesLibrariesFile = constructPath(ctx, config.UsesLibrariesFile)
+	if len(config.ClassLoaderContexts) == 0 && len(config.LibraryPaths) > 0 {
+		clcMap, err := libraryPathsToClassLoaderContexts(ctx, config.UsesLibraries,
+			config.PresentOptionalUsesLibraries, config.LibraryPaths, config.ModuleConfig.UsesLibrariesFile != nil)
+		if err != nil {
+			return config.ModuleConfig, err
+		}
+		config.ModuleConfig.ClassLoaderContexts = clcMap
+	}
 	config.ModuleConfig.DexPreoptImages = constructPaths(ctx, config.DexPreoptImages)
 	config.ModuleConfig.PreoptBootClassPathDexFiles = constructPaths(ctx, config.PreoptBootClassPathDexFiles)
 	config.ModuleConfig.StripInputPath = constructPath(ctx, config.StripInputPath)
@@ -334,7 +346,7 @@ func GlobalConfigForTests(ctx android.PathContext) GlobalConfig {
 			SoongZip:         android.PathForTesting("soong_zip"),
 			Zip2zip:          android.PathForTesting("zip2zip"),
 			ManifestCheck:    android.PathForTesting("manifest_check"),
-			ConstructContext: android.PathForTesting("construct_context.sh"),
+			ConstructContext: android.PathForTesting("construct_context"),
 		},
 	}
 }
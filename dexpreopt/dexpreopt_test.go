@@ -33,9 +33,8 @@ func testModuleConfig(ctx android.PathContext) ModuleConfig {
 		ProfileClassListing:             android.OptionalPath{},
 		ProfileIsTextListing:            false,
 		EnforceUsesLibraries:            false,
-		PresentOptionalUsesLibraries:    nil,
-		UsesLibraries:                   nil,
-		LibraryPaths:                    nil,
+		ClassLoaderContexts:             nil,
+		UsesLibrariesFile:               nil,
 		Archs:                           []android.ArchType{android.Arm},
 		DexPreoptImages:                 android.Paths{android.PathForTesting("system/framework/arm/boot.art")},
 		DexPreoptImagesDeps:             []android.Paths{android.Paths{}},
@@ -129,6 +128,49 @@ func TestDexPreoptProfile(t *testing.T) {
 	}
 }
 
+func TestDexPreoptUsesLibrariesFile(t *testing.T) {
+	ctx := android.PathContextForTesting(android.TestConfig("out", nil), nil)
+	global, module := GlobalConfigForTests(ctx), testModuleConfig(ctx)
+
+	module.EnforceUsesLibraries = true
+	module.UsesLibrariesFile = android.PathForOutput(ctx, "test/uses_libraries.txt")
+	module.ClassLoaderContexts = ClassLoaderContextMap{}
+	module.ClassLoaderContexts.AddContext(AnySdkVersion, "foo", android.PathForOutput(ctx, "foo/foo.jar"),
+		"/system/framework/foo.jar", nil)
+
+	rule, err := GenerateDexpreoptRule(ctx, global, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !inPaths(module.UsesLibrariesFile, rule.Inputs()) {
+		t.Errorf("want inputs to contain %q, got %q", module.UsesLibrariesFile, rule.Inputs())
+	}
+	if !inPaths(android.PathForOutput(ctx, "foo/foo.jar"), rule.Inputs()) {
+		t.Errorf("want inputs to contain %q, got %q", "out/foo/foo.jar", rule.Inputs())
+	}
+
+	commands := strings.Join(rule.Commands(), "\n")
+	for _, want := range []string{
+		`--uses-libraries-file out/test/uses_libraries.txt`,
+		`--host-context-for-sdk any foo 'PCL[out/foo/foo.jar]'`,
+		`--target-context-for-sdk any foo 'PCL[/system/framework/foo.jar]'`,
+	} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("want commands to contain:\n   %v\ngot:\n   %v", want, commands)
+		}
+	}
+}
+
+func inPaths(path android.Path, paths android.Paths) bool {
+	for _, p := range paths {
+		if p.String() == path.String() {
+			return true
+		}
+	}
+	return false
+}
+
 func TestStripDex(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -163,6 +163,7 @@ func (g *Module) DepsMutator(ctx android.BottomUpMutatorContext) {
 }
 
 func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "FAKE")
 	g.subName = ctx.ModuleSubDir()
 
 	if len(g.properties.Export_include_dirs) > 0 {
@@ -147,10 +147,126 @@ var fileListToFileRule = pctx.AndroidStaticRule("fileListToFile",
 		RspfileContent: "$in",
 	})
 
+// AaptLinkFlags are the flags of an aapt2 link step.  The flags that the build derives from properties are kept
+// structured until the link rule is written, so that the steps that add to them later, like splits, can inspect
+// and adjust them instead of parsing a joined command line.
+type AaptLinkFlags struct {
+	manifest         android.Path
+	assetDirs        android.Paths
+	minSdkVersion    string
+	targetSdkVersion string
+	versionCode      string
+	versionName      string
+	staticLib        bool
+	splits           []aaptSplitFlag
+
+	// The other flags, in the order they were added.
+	flags []string
+}
+
+// aaptSplitFlag is a configuration split that aapt2 writes into a separate package.
+type aaptSplitFlag struct {
+	name string
+	path android.WritablePath
+}
+
+// Manifest returns the path of the manifest passed with --manifest.
+func (f *AaptLinkFlags) Manifest() android.Path {
+	return f.manifest
+}
+
+// AssetDirs returns the asset directories passed with -A.
+func (f *AaptLinkFlags) AssetDirs() android.Paths {
+	return f.assetDirs
+}
+
+// MinSdkVersion returns the value of --min-sdk-version, or an empty string if it is not set.
+func (f *AaptLinkFlags) MinSdkVersion() string {
+	return f.minSdkVersion
+}
+
+// TargetSdkVersion returns the value of --target-sdk-version, or an empty string if it is not set.
+func (f *AaptLinkFlags) TargetSdkVersion() string {
+	return f.targetSdkVersion
+}
+
+// VersionCode returns the value of --version-code, or an empty string if it is not set.
+func (f *AaptLinkFlags) VersionCode() string {
+	return f.versionCode
+}
+
+// VersionName returns the ninja escaped value of --version-name, or an empty string if it is not set.
+func (f *AaptLinkFlags) VersionName() string {
+	return f.versionName
+}
+
+// StaticLib returns true if aapt2 links a static library package.
+func (f *AaptLinkFlags) StaticLib() bool {
+	return f.staticLib
+}
+
+// Splits returns the configurations passed with --split.
+func (f *AaptLinkFlags) Splits() []string {
+	var names []string
+	for _, s := range f.splits {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// Flags returns the flags that aren't modeled by the other accessors, like the aaptflags property, -I and
+// --product.
+func (f *AaptLinkFlags) Flags() []string {
+	return f.flags
+}
+
+// AddFlags appends flags that aren't modeled by the other fields.
+func (f *AaptLinkFlags) AddFlags(flags ...string) {
+	f.flags = append(f.flags, flags...)
+}
+
+// Strings returns the flags to pass to aapt2 link.
+func (f *AaptLinkFlags) Strings() []string {
+	flags := append([]string(nil), f.flags...)
+	if f.manifest != nil {
+		flags = append(flags, "--manifest", f.manifest.String())
+	}
+	for _, dir := range f.assetDirs {
+		flags = append(flags, "-A", dir.String())
+	}
+	if f.minSdkVersion != "" {
+		flags = append(flags, "--min-sdk-version", f.minSdkVersion)
+	}
+	if f.targetSdkVersion != "" {
+		flags = append(flags, "--target-sdk-version", f.targetSdkVersion)
+	}
+	if f.versionCode != "" {
+		flags = append(flags, "--version-code", f.versionCode)
+	}
+	if f.versionName != "" {
+		flags = append(flags, "--version-name", f.versionName)
+	}
+	if f.staticLib {
+		flags = append(flags, "--static-lib")
+	}
+	for _, s := range f.splits {
+		flags = append(flags, "--split", s.path.String()+":"+s.name)
+	}
+	return flags
+}
+
+func (f *AaptLinkFlags) splitPackages() android.WritablePaths {
+	var paths android.WritablePaths
+	for _, s := range f.splits {
+		paths = append(paths, s.path)
+	}
+	return paths
+}
+
 func aapt2Link(ctx android.ModuleContext,
 	packageRes, genJar, proguardOptions, rTxt, extraPackages android.WritablePath,
-	flags []string, deps android.Paths,
-	compiledRes, compiledOverlay android.Paths, splitPackages android.WritablePaths) {
+	flags *AaptLinkFlags, deps android.Paths,
+	compiledRes, compiledOverlay android.Paths) {
 
 	genDir := android.PathForModuleGen(ctx, "aapt2", "R")
 
@@ -185,7 +301,7 @@ func aapt2Link(ctx android.ModuleContext,
 		inFlags = append(inFlags, "-R", "@"+overlayFileList.String())
 	}
 
-	implicitOutputs := append(splitPackages, proguardOptions, genJar, rTxt, extraPackages)
+	implicitOutputs := append(flags.splitPackages(), proguardOptions, genJar, rTxt, extraPackages)
 
 	ctx.Build(pctx, android.BuildParams{
 		Rule:            aapt2LinkRule,
@@ -194,7 +310,7 @@ func aapt2Link(ctx android.ModuleContext,
 		Output:          packageRes,
 		ImplicitOutputs: implicitOutputs,
 		Args: map[string]string{
-			"flags":           strings.Join(flags, " "),
+			"flags":           strings.Join(flags.Strings(), " "),
 			"inFlags":         strings.Join(inFlags, " "),
 			"proguardOptions": proguardOptions.String(),
 			"genDir":          genDir.String(),
@@ -219,3 +335,57 @@ func aapt2Convert(ctx android.ModuleContext, out android.WritablePath, in androi
 		Description: "convert to proto",
 	})
 }
+
+// Restat the output so that the modules compiled against the R classes are not rebuilt when the
+// generated classes are unchanged.
+var resourceProcessorBusyBoxRule = pctx.AndroidStaticRule("resourceProcessorBusyBox",
+	blueprint.RuleParams{
+		Command: `${config.JavaCmd} ${config.JavaVmFlags} -cp ${config.ResourceProcessorBusyBox} ` +
+			`com.google.devtools.build.android.ResourceProcessorBusyBox --tool=GENERATE_BINARY_R -- ` +
+			`--primaryRTxt $rTxt --primaryManifest $manifest $libraries --classJarOutput $out.tmp && ` +
+			`if cmp -s $out.tmp $out ; then rm $out.tmp ; else mv $out.tmp $out ; fi`,
+		CommandDeps: []string{"${config.ResourceProcessorBusyBox}"},
+		Restat:      true,
+	}, "rTxt", "manifest", "libraries")
+
+// resourceProcessorBusyBoxGenerateBinaryR generates a jar of R classes with non-final fields from the R.txt file
+// produced by aapt2 link, for the package declared in the manifest, and for the packages of libraries.
+func resourceProcessorBusyBoxGenerateBinaryR(ctx android.ModuleContext, rTxt, manifest android.Path,
+	libraries []resourceProcessorDep, rJar android.WritablePath) {
+
+	implicits := android.Paths{rTxt, manifest}
+	var libraryFlags []string
+	for _, lib := range libraries {
+		libraryFlags = append(libraryFlags, "--library "+lib.rTxt.String()+","+lib.manifest.String())
+		implicits = append(implicits, lib.rTxt, lib.manifest)
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        resourceProcessorBusyBoxRule,
+		Description: "busybox R.jar",
+		Implicits:   implicits,
+		Output:      rJar,
+		Args: map[string]string{
+			"rTxt":      rTxt.String(),
+			"manifest":  manifest.String(),
+			"libraries": strings.Join(libraryFlags, " "),
+		},
+	})
+}
+
+var removeRJavaRule = pctx.AndroidStaticRule("removeRJava",
+	blueprint.RuleParams{
+		Command:     `${config.Zip2ZipCmd} -i $in -o $out -x '**/R.java'`,
+		CommandDeps: []string{"${config.Zip2ZipCmd}"},
+	})
+
+// removeRJava copies the sources generated by aapt2 link without the R.java sources, for the modules that compile
+// against the R classes generated by the resource processor.
+func removeRJava(ctx android.ModuleContext, outputFile android.WritablePath, srcJar android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        removeRJavaRule,
+		Description: "remove R.java",
+		Input:       srcJar,
+		Output:      outputFile,
+	})
+}
@@ -17,6 +17,7 @@ package java
 import (
 	"android/soong/android"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/google/blueprint"
@@ -27,9 +28,56 @@ type AndroidLibraryDependency interface {
 	Dependency
 	ExportPackage() android.Path
 	ExportedProguardFlagFiles() android.Paths
-	ExportedRRODirs() []rroDir
 	ExportedStaticPackages() android.Paths
 	ExportedManifests() android.Paths
+	ExportedAssets() android.Paths
+	ExportedResourceApis() android.Paths
+}
+
+// ExportedRRODirsProvider is set by the modules with resources to the directories of their own and their static
+// libraries' resources that are overlaid by the product or the device, in aapt2 order, which become the RRO
+// dirs of the apps that include them as static libraries.
+var ExportedRRODirsProvider = android.NewProvider("ExportedRRODirs", []rroDir(nil))
+
+// resourceProcessorDep is the R.txt file of a package of resources and the manifest that declares its package name,
+// from which the resource processor generates the R classes of the package.
+type resourceProcessorDep struct {
+	rTxt     android.Path
+	manifest android.Path
+}
+
+// ExportedResourceProcessorDepsProvider is set by the modules with resources that can be static libraries to the
+// resourceProcessorDeps of their own and their static libraries' packages, so that the android_library modules
+// that use the resource processor can generate the R classes of the packages of their static libraries, which
+// are missing from the classes of the libraries that use the resource processor themselves.
+var ExportedResourceProcessorDepsProvider = android.NewProvider("ExportedResourceProcessorDeps",
+	[]resourceProcessorDep(nil))
+
+// staticResourceProcessorDeps returns the resourceProcessorDeps exported by the static libraries of the module.
+func staticResourceProcessorDeps(ctx android.ModuleContext) []resourceProcessorDep {
+	var deps []resourceProcessorDep
+	ctx.VisitDirectDepsWithTag(staticLibTag, func(module android.Module) {
+		if !ctx.OtherModuleHasProvider(module, ExportedResourceProcessorDepsProvider) {
+			return
+		}
+		exportedDeps := ctx.OtherModuleProvider(module, ExportedResourceProcessorDepsProvider).([]resourceProcessorDep)
+	outer:
+		for _, d := range exportedDeps {
+			for _, e := range deps {
+				if d.rTxt == e.rTxt {
+					continue outer
+				}
+			}
+			deps = append(deps, d)
+		}
+	})
+	return deps
+}
+
+// AaptLinkFlagsProvider is implemented by the modules that link their resources with aapt2, to expose the
+// flags of the link step to tests and to other modules.
+type AaptLinkFlagsProvider interface {
+	AaptLinkFlags() *AaptLinkFlags
 }
 
 func init() {
@@ -46,9 +94,23 @@ type androidLibraryProperties struct {
 }
 
 type aaptProperties struct {
-	// flags passed to aapt when creating the apk
+	// flags passed to aapt when creating the apk.  Flags that the build sets from other properties, like
+	// --version-code or --min-sdk-version, are not allowed.
 	Aaptflags []string
 
+	// the version code of the package.  The AppsVersionCodeOffset of the product is added to it.  Defaults to the
+	// platform SDK version.
+	Version_code *int64
+
+	// the version name of the package.  Defaults to the default version name of apps in the product.
+	Version_name *string
+
+	// list of values to replace placeholders in the attributes of AndroidManifest.xml with, as <name>=<value>.
+	// Each ${<name>} in the manifest is replaced by its value, so per-product variants of an app, like a different
+	// label, can share a manifest.  ${product_characteristics} is replaced by the AAPT characteristics of the
+	// product unless it is listed here too.
+	Manifest_values []string
+
 	// include all resource configurations, not just the product-configured
 	// ones.
 	Aapt_include_all_resources *bool
@@ -68,10 +130,32 @@ type aaptProperties struct {
 
 	// path to AndroidManifest.xml.  If unset, defaults to "AndroidManifest.xml".
 	Manifest *string `android:"path"`
+
+	// If true, don't merge the manifests of static library dependencies into the manifest of this module,
+	// matching the behavior of modules built with the old ignore-library-manifests default.  Defaults to false.
+	Dont_merge_manifests *bool
+
+	// If true, compile the sources of an android_library against R classes generated by the resource processor
+	// from the R.txt file of the library instead of the R.java sources generated by aapt2.  The generated fields
+	// are not constants, so only the R classes have to be regenerated when resource IDs change, and the classes
+	// of the library don't depend on the R.java sources of the resource link step.  Defaults to false.
+	Use_resource_processor *bool
+
+	// path to a checked-in file listing the resources declared public or overlayable in the
+	// resource directories of the module.  If set, the build fails when a resource is added to or
+	// removed from them without updating the file.
+	Checked_in_api_lint *string `android:"path"`
+
+	// list of resources, as <type>/<name> like string/app_name, that the static android_library dependencies of
+	// this module may define with different values.  Any other resource that two of them define with different
+	// values for the same configuration is an error, as the value would depend on the order of the libraries.
+	Allowed_resource_conflicts []string
 }
 
 type aapt struct {
 	aaptSrcJar              android.Path
+	rJar                    android.Path
+	manifestSrcJar          android.Path
 	exportPackage           android.Path
 	manifestPath            android.Path
 	transitiveManifestPaths android.Paths
@@ -80,15 +164,25 @@ type aapt struct {
 	rTxt                    android.Path
 	extraAaptPackagesFile   android.Path
 	mergedManifestFile      android.Path
+	manifestMergerReport    android.Path
+	assetPackages           android.Paths
+	resourceFiles           android.Paths
+	resourceApiFile         android.WritablePath
+	exportedResourceApis    android.Paths
 	isLibrary               bool
 	useEmbeddedNativeLibs   bool
 	useEmbeddedDex          bool
+	versionNameBuildNumber  bool
 	usesNonSdkApis          bool
+	testOnly                bool
+	overrideMinSdkVersion   bool
+	loggingParent           string
 	sdkLibraries            []string
 	hasNoCode               bool
 
 	splitNames []string
 	splits     []split
+	linkFlags  *AaptLinkFlags
 
 	aaptProperties aaptProperties
 }
@@ -99,40 +193,63 @@ type split struct {
 	path   android.Path
 }
 
+// AaptLinkFlags returns the flags of the aapt2 link step of the module.
+func (a *aapt) AaptLinkFlags() *AaptLinkFlags {
+	return a.linkFlags
+}
+
 func (a *aapt) ExportPackage() android.Path {
 	return a.exportPackage
 }
 
-func (a *aapt) ExportedRRODirs() []rroDir {
-	return a.rroDirs
+// ExportedResourceApis returns the resource API files of the module and its static libraries, which list
+// the resources that runtime resource overlays of an app including them are allowed to overlay.
+func (a *aapt) ExportedResourceApis() android.Paths {
+	return a.exportedResourceApis
 }
 
 func (a *aapt) ExportedManifests() android.Paths {
 	return a.transitiveManifestPaths
 }
 
-func (a *aapt) aapt2Flags(ctx android.ModuleContext, sdkContext sdkContext, manifestPath android.Path) (flags []string,
-	deps android.Paths, resDirs, overlayDirs []globbedResourceDir, rroDirs []rroDir, resZips android.Paths) {
+// ExportedAssets returns the zip files containing the assets of the library and its static library dependencies,
+// in the order they should be merged.
+func (a *aapt) ExportedAssets() android.Paths {
+	return a.assetPackages
+}
+
+// deniedAaptflags are the aapt2 link flags that the build sets itself, mapped to the properties that control them.
+// Setting them in aaptflags too would pass conflicting values to aapt2.
+var deniedAaptflags = map[string]string{
+	"--manifest":           "manifest",
+	"--min-sdk-version":    "min_sdk_version",
+	"--target-sdk-version": "target_sdk_version",
+	"--version-code":       "version_code",
+	"--version-name":       "version_name",
+}
+
+func (a *aapt) aapt2Flags(ctx android.ModuleContext, sdkContext sdkContext, manifestPath android.Path) (
+	linkFlags *AaptLinkFlags, deps android.Paths, resDirs, overlayDirs []globbedResourceDir, rroDirs []rroDir, resZips android.Paths,
+	assetDirs []globbedResourceDir) {
 
-	hasVersionCode := false
-	hasVersionName := false
 	for _, f := range a.aaptProperties.Aaptflags {
-		if strings.HasPrefix(f, "--version-code") {
-			hasVersionCode = true
-		} else if strings.HasPrefix(f, "--version-name") {
-			hasVersionName = true
+		if fields := strings.Fields(f); len(fields) > 0 {
+			if property, denied := deniedAaptflags[fields[0]]; denied {
+				ctx.PropertyErrorf("aaptflags", "%s is set by the build, use the %s property instead", fields[0],
+					property)
+			}
 		}
 	}
 
-	var linkFlags []string
+	linkFlags = &AaptLinkFlags{}
 
 	// Flags specified in Android.bp
-	linkFlags = append(linkFlags, a.aaptProperties.Aaptflags...)
+	linkFlags.AddFlags(a.aaptProperties.Aaptflags...)
 
-	linkFlags = append(linkFlags, "--no-static-lib-packages")
+	linkFlags.AddFlags("--no-static-lib-packages")
 
 	// Find implicit or explicit asset and resource dirs
-	assetDirs := android.PathsWithOptionalDefaultForModuleSrc(ctx, a.aaptProperties.Asset_dirs, "assets")
+	assetDirPaths := android.PathsWithOptionalDefaultForModuleSrc(ctx, a.aaptProperties.Asset_dirs, "assets")
 	resourceDirs := android.PathsWithOptionalDefaultForModuleSrc(ctx, a.aaptProperties.Resource_dirs, "res")
 	resourceZips := android.PathsForModuleSrc(ctx, a.aaptProperties.Resource_zips)
 
@@ -150,28 +267,46 @@ func (a *aapt) aapt2Flags(ctx android.ModuleContext, sdkContext sdkContext, mani
 	}
 
 	var assetFiles android.Paths
-	for _, dir := range assetDirs {
-		assetFiles = append(assetFiles, androidResourceGlob(ctx, dir)...)
+	for _, dir := range assetDirPaths {
+		files := androidResourceGlob(ctx, dir)
+		assetDirs = append(assetDirs, globbedResourceDir{
+			dir:   dir,
+			files: files,
+		})
+		assetFiles = append(assetFiles, files...)
 	}
 
-	linkFlags = append(linkFlags, "--manifest "+manifestPath.String())
+	linkFlags.manifest = manifestPath
 	linkDeps = append(linkDeps, manifestPath)
 
-	linkFlags = append(linkFlags, android.JoinWithPrefix(assetDirs.Strings(), "-A "))
+	linkFlags.assetDirs = assetDirPaths
 	linkDeps = append(linkDeps, assetFiles...)
 
 	// SDK version flags
 	minSdkVersion := sdkVersionOrDefault(ctx, sdkContext.minSdkVersion())
 
-	linkFlags = append(linkFlags, "--min-sdk-version "+minSdkVersion)
-	linkFlags = append(linkFlags, "--target-sdk-version "+minSdkVersion)
+	linkFlags.minSdkVersion = minSdkVersion
+	linkFlags.targetSdkVersion = minSdkVersion
 
 	// Version code
-	if !hasVersionCode {
-		linkFlags = append(linkFlags, "--version-code", ctx.Config().PlatformSdkVersion())
+	if versionCode, overridden := ctx.DeviceConfig().OverrideVersionCodeFor(ctx.ModuleName()); overridden {
+		// The product override variable has a priority over the version_code property.
+		if _, err := strconv.ParseInt(versionCode, 10, 64); err != nil {
+			ctx.ModuleErrorf("invalid version code %q in PRODUCT_VERSION_CODE_OVERRIDES", versionCode)
+		}
+		linkFlags.versionCode = versionCode
+	} else if a.aaptProperties.Version_code != nil {
+		versionCode := *a.aaptProperties.Version_code + int64(ctx.Config().AppsVersionCodeOffset())
+		linkFlags.versionCode = strconv.FormatInt(versionCode, 10)
+	} else {
+		linkFlags.versionCode = ctx.Config().PlatformSdkVersion()
 	}
 
-	if !hasVersionName {
+	if versionName, overridden := ctx.DeviceConfig().OverrideVersionNameFor(ctx.ModuleName()); overridden {
+		linkFlags.versionName = proptools.NinjaEscape(versionName)
+	} else if a.aaptProperties.Version_name != nil {
+		linkFlags.versionName = proptools.NinjaEscape(*a.aaptProperties.Version_name)
+	} else {
 		var versionName string
 		if ctx.ModuleName() == "framework-res" {
 			// Some builds set AppsDefaultVersionName() to include the build number ("O-123456").  aapt2 copies the
@@ -182,10 +317,33 @@ func (a *aapt) aapt2Flags(ctx android.ModuleContext, sdkContext sdkContext, mani
 			versionName = ctx.Config().AppsDefaultVersionName()
 		}
 		versionName = proptools.NinjaEscape(versionName)
-		linkFlags = append(linkFlags, "--version-name ", versionName)
+		if a.versionNameBuildNumber {
+			buildNumberFile := ctx.Config().BuildNumberFile(ctx)
+			versionName = proptools.NinjaEscape(ctx.Config().PlatformVersionName()) +
+				"-$$(cat " + buildNumberFile.String() + ")"
+			linkDeps = append(linkDeps, buildNumberFile)
+		}
+		linkFlags.versionName = versionName
 	}
 
-	return linkFlags, linkDeps, resDirs, overlayDirs, rroDirs, resourceZips
+	return linkFlags, linkDeps, resDirs, overlayDirs, rroDirs, resourceZips, assetDirs
+}
+
+// manifestValues returns the <name>=<value> replacements of the placeholders in the manifest, with the product
+// characteristics first so that the manifest_values property can replace them.
+func (a *aapt) manifestValues(ctx android.ModuleContext) []string {
+	var values []string
+	if characteristics := ctx.Config().ProductAAPTCharacteristics(); characteristics != "" {
+		values = append(values, "product_characteristics="+characteristics)
+	}
+	for _, value := range a.aaptProperties.Manifest_values {
+		if strings.Index(value, "=") <= 0 {
+			ctx.PropertyErrorf("manifest_values", "invalid value %q, expected <name>=<value>", value)
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
 }
 
 func (a *aapt) deps(ctx android.BottomUpMutatorContext, sdkDep sdkDep) {
@@ -196,20 +354,22 @@ func (a *aapt) deps(ctx android.BottomUpMutatorContext, sdkDep sdkDep) {
 
 func (a *aapt) buildActions(ctx android.ModuleContext, sdkContext sdkContext, extraLinkFlags ...string) {
 
-	transitiveStaticLibs, transitiveStaticLibManifests, staticRRODirs, libDeps, libFlags, sdkLibraries :=
-		aaptLibs(ctx, sdkContext)
+	transitiveStaticLibs, transitiveStaticLibManifests, staticRRODirs, staticAssetPackages, libDeps, libFlags,
+		sdkLibraries := aaptLibs(ctx, sdkContext)
 
 	// App manifest file
 	manifestFile := proptools.StringDefault(a.aaptProperties.Manifest, "AndroidManifest.xml")
 	manifestSrcPath := android.PathForModuleSrc(ctx, manifestFile)
 
 	manifestPath := manifestFixer(ctx, manifestSrcPath, sdkContext, sdkLibraries,
-		a.isLibrary, a.useEmbeddedNativeLibs, a.usesNonSdkApis, a.useEmbeddedDex, a.hasNoCode)
+		a.isLibrary, a.useEmbeddedNativeLibs, a.usesNonSdkApis, a.useEmbeddedDex, a.hasNoCode, a.testOnly,
+		a.overrideMinSdkVersion, a.loggingParent, a.manifestValues(ctx))
 
 	a.transitiveManifestPaths = append(android.Paths{manifestPath}, transitiveStaticLibManifests...)
 
-	if len(transitiveStaticLibManifests) > 0 {
-		a.mergedManifestFile = manifestMerger(ctx, manifestPath, transitiveStaticLibManifests, a.isLibrary)
+	if len(transitiveStaticLibManifests) > 0 && !Bool(a.aaptProperties.Dont_merge_manifests) {
+		a.mergedManifestFile, a.manifestMergerReport = manifestMerger(ctx, manifestPath,
+			transitiveStaticLibManifests, a.isLibrary)
 		if !a.isLibrary {
 			// Only use the merged manifest for applications.  For libraries, the transitive closure of manifests
 			// will be propagated to the final application and merged there.  The merged manifest for libraries is
@@ -220,15 +380,15 @@ func (a *aapt) buildActions(ctx android.ModuleContext, sdkContext sdkContext, ex
 		a.mergedManifestFile = manifestPath
 	}
 
-	linkFlags, linkDeps, resDirs, overlayDirs, rroDirs, resZips := a.aapt2Flags(ctx, sdkContext, manifestPath)
+	linkFlags, linkDeps, resDirs, overlayDirs, rroDirs, resZips, assetDirs := a.aapt2Flags(ctx, sdkContext, manifestPath)
 
+	// The overlay directories of static libraries are checked when the libraries are built.
+	ownRRODirs := rroDirs
 	rroDirs = append(rroDirs, staticRRODirs...)
-	linkFlags = append(linkFlags, libFlags...)
+	linkFlags.AddFlags(libFlags...)
 	linkDeps = append(linkDeps, libDeps...)
-	linkFlags = append(linkFlags, extraLinkFlags...)
-	if a.isLibrary {
-		linkFlags = append(linkFlags, "--static-lib")
-	}
+	linkFlags.AddFlags(extraLinkFlags...)
+	linkFlags.staticLib = a.isLibrary
 
 	packageRes := android.PathForModuleOut(ctx, "package-res.apk")
 	srcJar := android.PathForModuleGen(ctx, "R.jar")
@@ -238,10 +398,29 @@ func (a *aapt) buildActions(ctx android.ModuleContext, sdkContext sdkContext, ex
 	extraPackages := android.PathForModuleOut(ctx, "extra_packages")
 
 	var compiledResDirs []android.Paths
+	var resourceFiles android.Paths
 	for _, dir := range resDirs {
+		resourceFiles = append(resourceFiles, dir.files...)
 		compiledResDirs = append(compiledResDirs, aapt2Compile(ctx, dir.dir, dir.files).Paths())
 	}
 
+	// Libraries always list their resource API, the apps including them may have overlays to check.
+	a.exportedResourceApis = staticResourceApis(ctx)
+	if a.aaptProperties.Checked_in_api_lint != nil || len(ownRRODirs) > 0 || a.isLibrary {
+		a.buildResourceApi(ctx, resourceFiles)
+	}
+
+	// Fail the link of the resources when the checks fail.
+	if a.aaptProperties.Checked_in_api_lint != nil {
+		linkDeps = append(linkDeps, a.checkResourceApi(ctx))
+	}
+	if len(ownRRODirs) > 0 {
+		linkDeps = append(linkDeps, a.checkRROOverlayable(ctx, ownRRODirs))
+	}
+	if staticLibs := staticResourceLibraries(ctx); len(staticLibs) > 1 {
+		linkDeps = append(linkDeps, a.checkStaticResourceConflicts(ctx, staticLibs))
+	}
+
 	for i, zip := range resZips {
 		flata := android.PathForModuleOut(ctx, fmt.Sprintf("reszip.%d.flata", i))
 		aapt2CompileZip(ctx, flata, zip, "")
@@ -276,14 +455,12 @@ func (a *aapt) buildActions(ctx android.ModuleContext, sdkContext sdkContext, ex
 		compiledOverlay = append(compiledOverlay, aapt2Compile(ctx, dir.dir, dir.files).Paths()...)
 	}
 
-	var splitPackages android.WritablePaths
 	var splits []split
 
 	for _, s := range a.splitNames {
 		suffix := strings.Replace(s, ",", "_", -1)
 		path := android.PathForModuleOut(ctx, "package_"+suffix+".apk")
-		linkFlags = append(linkFlags, "--split", path.String()+":"+s)
-		splitPackages = append(splitPackages, path)
+		linkFlags.splits = append(linkFlags.splits, aaptSplitFlag{name: s, path: path})
 		splits = append(splits, split{
 			name:   s,
 			suffix: suffix,
@@ -291,22 +468,122 @@ func (a *aapt) buildActions(ctx android.ModuleContext, sdkContext sdkContext, ex
 		})
 	}
 
-	aapt2Link(ctx, packageRes, srcJar, proguardOptionsFile, rTxt, extraPackages,
-		linkFlags, linkDeps, compiledRes, compiledOverlay, splitPackages)
+	var assetPackages android.Paths
+	if a.isLibrary {
+		// Libraries don't include their assets in their package, they are exported to the final app instead.
+		if len(assetDirs) > 0 {
+			assetPackage := android.PathForModuleOut(ctx, "assets.zip")
+			zipAssets(ctx, assetPackage, assetDirs)
+			assetPackages = append(assetPackages, assetPackage)
+		}
+		assetPackages = append(assetPackages, staticAssetPackages...)
+	}
+
+	if !a.isLibrary && len(staticAssetPackages) > 0 {
+		// Link into an intermediate package and then merge the assets exported by static libraries into it.
+		linkedPackageRes := android.PathForModuleOut(ctx, "aapt2", "package-res.apk")
+		aapt2Link(ctx, linkedPackageRes, srcJar, proguardOptionsFile, rTxt, extraPackages,
+			linkFlags, linkDeps, compiledRes, compiledOverlay)
+		mergeAssets(ctx, packageRes, linkedPackageRes, staticAssetPackages)
+	} else {
+		aapt2Link(ctx, packageRes, srcJar, proguardOptionsFile, rTxt, extraPackages,
+			linkFlags, linkDeps, compiledRes, compiledOverlay)
+	}
 
 	a.aaptSrcJar = srcJar
 	a.exportPackage = packageRes
+	a.assetPackages = android.FirstUniquePaths(assetPackages)
 	a.manifestPath = manifestPath
+	a.resourceFiles = resourceFiles
 	a.proguardOptionsFile = proguardOptionsFile
 	a.rroDirs = rroDirs
+	ctx.SetProvider(ExportedRRODirsProvider, rroDirs)
 	a.extraAaptPackagesFile = extraPackages
 	a.rTxt = rTxt
 	a.splits = splits
+	a.linkFlags = linkFlags
+
+	var staticProcessorDeps []resourceProcessorDep
+	if a.isLibrary {
+		staticProcessorDeps = staticResourceProcessorDeps(ctx)
+		ctx.SetProvider(ExportedResourceProcessorDepsProvider,
+			append([]resourceProcessorDep{{rTxt, manifestPath}}, staticProcessorDeps...))
+	}
+
+	if Bool(a.aaptProperties.Use_resource_processor) {
+		if !a.isLibrary {
+			ctx.PropertyErrorf("use_resource_processor", "is only supported by android_library modules")
+			return
+		}
+		// The R classes of the packages of the static libraries are generated too, the libraries that use the
+		// resource processor don't have them in their classes.
+		rJar := android.PathForModuleOut(ctx, "busybox", "R.jar")
+		resourceProcessorBusyBoxGenerateBinaryR(ctx, rTxt, manifestPath, staticProcessorDeps, rJar)
+		a.rJar = rJar
+
+		manifestSrcJar := android.PathForModuleGen(ctx, "Manifest.jar")
+		removeRJava(ctx, manifestSrcJar, srcJar)
+		a.manifestSrcJar = manifestSrcJar
+	}
+}
+
+var zipAssetsRule = pctx.AndroidStaticRule("zipAssets",
+	blueprint.RuleParams{
+		Command:     `${config.SoongZipCmd} -o $out -P assets $args`,
+		CommandDeps: []string{"${config.SoongZipCmd}"},
+	},
+	"args")
+
+// zipAssets packages the asset directories of a library into a zip file with the assets placed in the assets/
+// directory, ready to be merged into the package of the final app.
+func zipAssets(ctx android.ModuleContext, out android.WritablePath, assetDirs []globbedResourceDir) {
+	var args []string
+	var deps android.Paths
+	for _, dir := range assetDirs {
+		args = append(args, "-C "+dir.dir.String(), "-D "+dir.dir.String())
+		deps = append(deps, dir.files...)
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        zipAssetsRule,
+		Description: "zip assets",
+		Implicits:   deps,
+		Output:      out,
+		Args: map[string]string{
+			"args": strings.Join(args, " "),
+		},
+	})
+}
+
+// Merge the asset packages exported by the static libraries of an app into its resource package.  Conflicting
+// assets between libraries are an error, but assets in the app itself take precedence over assets in libraries.
+var mergeAssetsRule = pctx.AndroidStaticRule("mergeAssets",
+	blueprint.RuleParams{
+		Command: `${config.MergeZipsCmd} $out.tmp $assets && ` +
+			`${config.MergeZipsCmd} --ignore-duplicates $out $in $out.tmp && ` +
+			`rm -f $out.tmp`,
+		CommandDeps: []string{"${config.MergeZipsCmd}"},
+	},
+	"assets")
+
+func mergeAssets(ctx android.ModuleContext, out android.WritablePath, packageRes android.Path,
+	assetPackages android.Paths) {
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        mergeAssetsRule,
+		Description: "merge assets",
+		Input:       packageRes,
+		Implicits:   assetPackages,
+		Output:      out,
+		Args: map[string]string{
+			"assets": strings.Join(assetPackages.Strings(), " "),
+		},
+	})
 }
 
 // aaptLibs collects libraries from dependencies and sdk_version and converts them into paths
 func aaptLibs(ctx android.ModuleContext, sdkContext sdkContext) (transitiveStaticLibs, transitiveStaticLibManifests android.Paths,
-	staticRRODirs []rroDir, deps android.Paths, flags []string, sdkLibraries []string) {
+	staticRRODirs []rroDir, assetPackages, deps android.Paths, flags []string, sdkLibraries []string) {
 
 	var sharedLibs android.Paths
 
@@ -343,10 +620,16 @@ func aaptLibs(ctx android.ModuleContext, sdkContext sdkContext) (transitiveStati
 				transitiveStaticLibs = append(transitiveStaticLibs, aarDep.ExportedStaticPackages()...)
 				transitiveStaticLibs = append(transitiveStaticLibs, exportPackage)
 				transitiveStaticLibManifests = append(transitiveStaticLibManifests, aarDep.ExportedManifests()...)
+				assetPackages = append(assetPackages, aarDep.ExportedAssets()...)
 				sdkLibraries = append(sdkLibraries, aarDep.ExportedSdkLibs()...)
 
+				var exportedRRODirs []rroDir
+				if ctx.OtherModuleHasProvider(module, ExportedRRODirsProvider) {
+					exportedRRODirs = ctx.OtherModuleProvider(module, ExportedRRODirsProvider).([]rroDir)
+				}
+
 			outer:
-				for _, d := range aarDep.ExportedRRODirs() {
+				for _, d := range exportedRRODirs {
 					for _, e := range staticRRODirs {
 						if d.path == e.path {
 							continue outer
@@ -371,9 +654,27 @@ func aaptLibs(ctx android.ModuleContext, sdkContext sdkContext) (transitiveStati
 
 	transitiveStaticLibs = android.FirstUniquePaths(transitiveStaticLibs)
 	transitiveStaticLibManifests = android.FirstUniquePaths(transitiveStaticLibManifests)
+	assetPackages = android.FirstUniquePaths(assetPackages)
 	sdkLibraries = android.FirstUniqueStrings(sdkLibraries)
 
-	return transitiveStaticLibs, transitiveStaticLibManifests, staticRRODirs, deps, flags, sdkLibraries
+	return transitiveStaticLibs, transitiveStaticLibManifests, staticRRODirs, assetPackages, deps, flags, sdkLibraries
+}
+
+// aaptFrameworkRes returns the framework resource packages from sdk_version, which are enough to link resources
+// that only reference android: attributes, like the manifest of a config split.
+func aaptFrameworkRes(ctx android.ModuleContext, sdkContext sdkContext) android.Paths {
+	sdkDep := decodeSdkDep(ctx, sdkContext)
+	if sdkDep.useFiles {
+		return sdkDep.jars
+	}
+
+	var frameworkRes android.Paths
+	ctx.VisitDirectDepsWithTag(frameworkResTag, func(module android.Module) {
+		if aarDep, ok := module.(AndroidLibraryDependency); ok && aarDep.ExportPackage() != nil {
+			frameworkRes = append(frameworkRes, aarDep.ExportPackage())
+		}
+	})
+	return frameworkRes
 }
 
 type AndroidLibrary struct {
@@ -384,12 +685,7 @@ type AndroidLibrary struct {
 
 	aarFile android.WritablePath
 
-	exportedProguardFlagFiles android.Paths
-	exportedStaticPackages    android.Paths
-}
-
-func (a *AndroidLibrary) ExportedProguardFlagFiles() android.Paths {
-	return a.exportedProguardFlagFiles
+	exportedStaticPackages android.Paths
 }
 
 func (a *AndroidLibrary) ExportedStaticPackages() android.Paths {
@@ -407,6 +703,7 @@ func (a *AndroidLibrary) DepsMutator(ctx android.BottomUpMutatorContext) {
 }
 
 func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	a.aapt.isLibrary = true
 	a.aapt.sdkLibraries = a.exportedSdkLibs
 	a.aapt.buildActions(ctx, sdkContext(a))
@@ -421,7 +718,23 @@ func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 	a.Module.extraProguardFlagFiles = append(a.Module.extraProguardFlagFiles,
 		a.proguardOptionsFile)
 
-	a.Module.compile(ctx, a.aaptSrcJar)
+	a.linter.manifest = a.manifestPath
+	a.linter.resources = a.resourceFiles
+	if a.rJar != nil {
+		// The R classes are only compiled against, the final R classes of the package of the library are
+		// generated by aapt2 for the app that includes it.  Manifest.java is still compiled from the sources
+		// generated by aapt2.
+		a.Module.extraClasspathJars = append(a.Module.extraClasspathJars, a.rJar)
+		a.Module.compile(ctx, a.manifestSrcJar)
+	} else {
+		a.Module.compile(ctx, a.aaptSrcJar)
+	}
+
+	if a.dexJarFile != nil {
+		// With compile_dex the dex jar is only available through the ".dex.jar" output, the main output stays the
+		// classes jar that goes into the AAR and is used by static_libs dependents.
+		a.outputFile = a.implementationAndResourcesJar
+	}
 
 	a.aarFile = android.PathForModuleOut(ctx, ctx.ModuleName()+".aar")
 	var res android.Paths
@@ -432,13 +745,11 @@ func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 
 	ctx.VisitDirectDeps(func(m android.Module) {
 		if lib, ok := m.(AndroidLibraryDependency); ok && ctx.OtherModuleDependencyTag(m) == staticLibTag {
-			a.exportedProguardFlagFiles = append(a.exportedProguardFlagFiles, lib.ExportedProguardFlagFiles()...)
 			a.exportedStaticPackages = append(a.exportedStaticPackages, lib.ExportPackage())
 			a.exportedStaticPackages = append(a.exportedStaticPackages, lib.ExportedStaticPackages()...)
 		}
 	})
 
-	a.exportedProguardFlagFiles = android.FirstUniquePaths(a.exportedProguardFlagFiles)
 	a.exportedStaticPackages = android.FirstUniquePaths(a.exportedStaticPackages)
 }
 
@@ -448,6 +759,9 @@ func (a *AndroidLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 // compiled against the device bootclasspath, along with a `package-res.apk` file containing  Android resources compiled
 // with aapt2.  This module is not suitable for installing on a device, but can be used as a `static_libs` dependency of
 // an android_app module.
+//
+// If compile_dex is true, the `.class` files are also dexed, with desugaring for min_sdk_version, into a dex jar that
+// modules needing the dex code of the library, like apexes, can use as the `.dex.jar` output of the module.
 func AndroidLibraryFactory() android.Module {
 	module := &AndroidLibrary{}
 
@@ -455,11 +769,13 @@ func AndroidLibraryFactory() android.Module {
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
 		&module.aaptProperties,
 		&module.androidLibraryProperties)
 
 	module.androidLibraryProperties.BuildAAR = true
+	module.Module.linter.library = true
 
 	InitJavaModule(module, android.DeviceSupported)
 	return module
@@ -494,8 +810,11 @@ type AARImport struct {
 	exportPackage         android.WritablePath
 	extraAaptPackagesFile android.WritablePath
 	manifest              android.WritablePath
+	assetPackage          android.WritablePath
+	linkFlags             *AaptLinkFlags
 
 	exportedStaticPackages android.Paths
+	exportedAssetPackages  android.Paths
 }
 
 func (a *AARImport) sdkVersion() string {
@@ -518,6 +837,13 @@ func (a *AARImport) noFrameworkLibs() bool {
 }
 
 var _ AndroidLibraryDependency = (*AARImport)(nil)
+var _ AaptLinkFlagsProvider = (*AARImport)(nil)
+
+// AaptLinkFlags returns the flags of the aapt2 link step that turns the resources of the aar into a static
+// library package.
+func (a *AARImport) AaptLinkFlags() *AaptLinkFlags {
+	return a.linkFlags
+}
 
 func (a *AARImport) ExportPackage() android.Path {
 	return a.exportPackage
@@ -527,7 +853,8 @@ func (a *AARImport) ExportedProguardFlagFiles() android.Paths {
 	return android.Paths{a.proguardFlags}
 }
 
-func (a *AARImport) ExportedRRODirs() []rroDir {
+// ExportedResourceApis returns nil, the resources of an aar are not listed, so none of them can be overlaid.
+func (a *AARImport) ExportedResourceApis() android.Paths {
 	return nil
 }
 
@@ -539,6 +866,10 @@ func (a *AARImport) ExportedManifests() android.Paths {
 	return android.Paths{a.manifest}
 }
 
+func (a *AARImport) ExportedAssets() android.Paths {
+	return a.exportedAssetPackages
+}
+
 func (a *AARImport) Prebuilt() *android.Prebuilt {
 	return &a.prebuilt
 }
@@ -568,7 +899,15 @@ var unzipAAR = pctx.AndroidStaticRule("unzipAAR",
 	},
 	"outDir")
 
+// Extract the assets of an AAR into a zip file with the assets placed in the assets/ directory.
+var extractAARAssets = pctx.AndroidStaticRule("extractAARAssets",
+	blueprint.RuleParams{
+		Command:     `${config.Zip2ZipCmd} -i $in -o $out 'assets/**/*'`,
+		CommandDeps: []string{"${config.Zip2ZipCmd}"},
+	})
+
 func (a *AARImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	if len(a.properties.Aars) != 1 {
 		ctx.PropertyErrorf("aars", "exactly one aar is required")
 		return
@@ -598,6 +937,14 @@ func (a *AARImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		},
 	})
 
+	a.assetPackage = android.PathForModuleOut(ctx, "assets.zip")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        extractAARAssets,
+		Input:       aar,
+		Output:      a.assetPackage,
+		Description: "extract AAR assets",
+	})
+
 	compiledResDir := android.PathForModuleOut(ctx, "flat-res")
 	flata := compiledResDir.Join(ctx, "gen_res.flata")
 	aapt2CompileZip(ctx, flata, aar, "res")
@@ -610,29 +957,33 @@ func (a *AARImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	var linkDeps android.Paths
 
-	linkFlags := []string{
-		"--static-lib",
-		"--no-static-lib-packages",
-		"--auto-add-overlay",
+	linkFlags := &AaptLinkFlags{
+		manifest:  a.manifest,
+		staticLib: true,
 	}
-
-	linkFlags = append(linkFlags, "--manifest "+a.manifest.String())
+	linkFlags.AddFlags("--no-static-lib-packages", "--auto-add-overlay")
 	linkDeps = append(linkDeps, a.manifest)
 
-	transitiveStaticLibs, staticLibManifests, staticRRODirs, libDeps, libFlags, sdkLibraries :=
+	transitiveStaticLibs, staticLibManifests, staticRRODirs, staticAssetPackages, libDeps, libFlags, sdkLibraries :=
 		aaptLibs(ctx, sdkContext(a))
 
+	a.exportedAssetPackages = android.FirstUniquePaths(append(android.Paths{a.assetPackage}, staticAssetPackages...))
+
 	_ = staticLibManifests
 	_ = staticRRODirs
 	_ = sdkLibraries
 
 	linkDeps = append(linkDeps, libDeps...)
-	linkFlags = append(linkFlags, libFlags...)
+	linkFlags.AddFlags(libFlags...)
 
 	overlayRes := append(android.Paths{flata}, transitiveStaticLibs...)
 
 	aapt2Link(ctx, a.exportPackage, srcJar, proguardOptionsFile, rTxt, a.extraAaptPackagesFile,
-		linkFlags, linkDeps, nil, overlayRes, nil)
+		linkFlags, linkDeps, nil, overlayRes)
+	a.linkFlags = linkFlags
+
+	ctx.SetProvider(ExportedResourceProcessorDepsProvider,
+		append([]resourceProcessorDep{{rTxt, a.manifest}}, staticResourceProcessorDeps(ctx)...))
 }
 
 var _ Dependency = (*AARImport)(nil)
@@ -16,30 +16,19 @@ package java
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 )
 
-var manifestFixerRule = pctx.AndroidStaticRule("manifestFixer",
-	blueprint.RuleParams{
-		Command: `${config.ManifestFixerCmd} ` +
-			`--minSdkVersion ${minSdkVersion} ` +
-			`--targetSdkVersion ${targetSdkVersion} ` +
-			`--raise-min-sdk-version ` +
-			`$args $in $out`,
-		CommandDeps: []string{"${config.ManifestFixerCmd}"},
-	},
-	"minSdkVersion", "targetSdkVersion", "args")
-
 var manifestMergerRule = pctx.AndroidStaticRule("manifestMerger",
 	blueprint.RuleParams{
-		Command:     `${config.ManifestMergerCmd} $args --main $in $libs --out $out`,
+		Command:     `${config.ManifestMergerCmd} $args --main $in $libs --out $out --report $report`,
 		CommandDeps: []string{"${config.ManifestMergerCmd}"},
 	},
-	"args", "libs")
+	"args", "libs", "report")
 
 // These two libs are added as optional dependencies (<uses-library> with
 // android:required set to false). This is because they haven't existed in pre-P
@@ -53,7 +42,8 @@ var optionalUsesLibs = []string{
 
 // Uses manifest_fixer.py to inject minSdkVersion, etc. into an AndroidManifest.xml
 func manifestFixer(ctx android.ModuleContext, manifest android.Path, sdkContext sdkContext, sdkLibraries []string,
-	isLibrary, useEmbeddedNativeLibs, usesNonSdkApis, useEmbeddedDex, hasNoCode bool) android.Path {
+	isLibrary, useEmbeddedNativeLibs, usesNonSdkApis, useEmbeddedDex, hasNoCode, testOnly, overrideMinSdkVersion bool,
+	loggingParent string, manifestValues []string) android.Path {
 
 	var args []string
 	if isLibrary {
@@ -91,6 +81,22 @@ func manifestFixer(ctx android.ModuleContext, manifest android.Path, sdkContext
 		args = append(args, "--has-no-code")
 	}
 
+	if testOnly {
+		args = append(args, "--test-only")
+	}
+
+	if overrideMinSdkVersion {
+		args = append(args, "--override-min-sdk-version")
+	}
+
+	if loggingParent != "" {
+		args = append(args, "--logging-parent", proptools.ShellEscape(loggingParent))
+	}
+
+	for _, value := range manifestValues {
+		args = append(args, "--manifest-value", proptools.ShellEscape(value))
+	}
+
 	var deps android.Paths
 	targetSdkVersion := sdkVersionOrDefault(ctx, sdkContext.targetSdkVersion())
 	if targetSdkVersion == ctx.Config().PlatformSdkCodename() &&
@@ -98,29 +104,31 @@ func manifestFixer(ctx android.ModuleContext, manifest android.Path, sdkContext
 		!ctx.Config().UnbundledBuildUsePrebuiltSdks() &&
 		ctx.Config().IsEnvTrue("UNBUNDLED_BUILD_TARGET_SDK_WITH_API_FINGERPRINT") {
 		apiFingerprint := ApiFingerprintPath(ctx)
-		targetSdkVersion += fmt.Sprintf(".$$(cat %s)", apiFingerprint.String())
+		targetSdkVersion += fmt.Sprintf(".$(cat %s)", apiFingerprint.String())
 		deps = append(deps, apiFingerprint)
 	}
 
 	fixedManifest := android.PathForModuleOut(ctx, "manifest_fixer", "AndroidManifest.xml")
-	ctx.Build(pctx, android.BuildParams{
-		Rule:        manifestFixerRule,
-		Description: "fix manifest",
-		Input:       manifest,
-		Implicits:   deps,
-		Output:      fixedManifest,
-		Args: map[string]string{
-			"minSdkVersion":    sdkVersionOrDefault(ctx, sdkContext.minSdkVersion()),
-			"targetSdkVersion": targetSdkVersion,
-			"args":             strings.Join(args, " "),
-		},
-	})
+	rule := android.NewRuleBuilder()
+	rule.Command().
+		Tool(ctx.Config().HostToolPath(ctx, "manifest_fixer")).
+		FlagWithArg("--minSdkVersion ", sdkVersionOrDefault(ctx, sdkContext.minSdkVersion())).
+		FlagWithArg("--targetSdkVersion ", targetSdkVersion).
+		Flag("--raise-min-sdk-version").
+		Flags(args).
+		Input(manifest).
+		Implicits(deps).
+		Output(fixedManifest)
+	rule.Build(pctx, ctx, "manifestFixer", "fix manifest")
 
 	return fixedManifest
 }
 
+// manifestMerger merges the manifests of the static library dependencies into manifest, and returns the
+// merged manifest along with the merger's report, which records which manifest each element and attribute of
+// the merged manifest came from.
 func manifestMerger(ctx android.ModuleContext, manifest android.Path, staticLibManifests android.Paths,
-	isLibrary bool) android.Path {
+	isLibrary bool) (mergedManifest, report android.Path) {
 
 	var args string
 	if !isLibrary {
@@ -128,18 +136,21 @@ func manifestMerger(ctx android.ModuleContext, manifest android.Path, staticLibM
 		args = "--remove-tools-declarations"
 	}
 
-	mergedManifest := android.PathForModuleOut(ctx, "manifest_merger", "AndroidManifest.xml")
+	mergedManifestFile := android.PathForModuleOut(ctx, "manifest_merger", "AndroidManifest.xml")
+	reportFile := android.PathForModuleOut(ctx, "manifest_merger", "manifest-merger-report.txt")
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        manifestMergerRule,
-		Description: "merge manifest",
-		Input:       manifest,
-		Implicits:   staticLibManifests,
-		Output:      mergedManifest,
+		Rule:           manifestMergerRule,
+		Description:    "merge manifest",
+		Input:          manifest,
+		Implicits:      staticLibManifests,
+		Output:         mergedManifestFile,
+		ImplicitOutput: reportFile,
 		Args: map[string]string{
-			"libs": android.JoinWithPrefix(staticLibManifests.Strings(), "--libs "),
-			"args": args,
+			"libs":   android.JoinWithPrefix(staticLibManifests.Strings(), "--libs "),
+			"args":   args,
+			"report": reportFile.String(),
 		},
 	})
 
-	return mergedManifest
+	return mergedManifestFile, reportFile
 }
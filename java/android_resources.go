@@ -51,6 +51,7 @@ const (
 type rroDir struct {
 	path        android.Path
 	overlayType overlayType
+	files       android.Paths
 }
 
 type overlayGlobResult struct {
@@ -83,7 +84,7 @@ func overlayResourceGlob(ctx android.ModuleContext, dir android.Path) (res []glo
 			// exclusion list, ignore the overlay.  The list of ignored overlays will be
 			// passed to Make to be turned into an RRO package.
 			if rroEnabled && !ctx.Config().EnforceRROExcludedOverlay(overlayModuleDir.String()) {
-				rroDirs = append(rroDirs, rroDir{overlayModuleDir, data.overlayType})
+				rroDirs = append(rroDirs, rroDir{overlayModuleDir, data.overlayType, files})
 			} else {
 				res = append(res, globbedResourceDir{
 					dir:   overlayModuleDir,
@@ -121,6 +121,7 @@ func (j *Test) AndroidMk() android.AndroidMkData {
 	data := j.Library.AndroidMk()
 	data.Extra = append(data.Extra, func(w io.Writer, outputFile android.Path) {
 		testSuiteComponent(w, j.testProperties.Test_suites)
+		testOptionsComponent(w, j.testOptionsProperties.Test_options)
 		if j.testConfig != nil {
 			fmt.Fprintln(w, "LOCAL_FULL_TEST_CONFIG :=", j.testConfig.String())
 		}
@@ -178,6 +179,38 @@ func (prebuilt *DexImport) AndroidMk() android.AndroidMkData {
 	}
 }
 
+func (prebuilt *sdkLibraryImport) AndroidMk() android.AndroidMkData {
+	if prebuilt.dexJarFile == nil {
+		// Only the stubs libraries are exported to Make.
+		return android.AndroidMkData{
+			Disabled: true,
+		}
+	}
+	var required []string
+	if prebuilt.properties.Permissions_xml != nil {
+		required = append(required, prebuilt.xmlFileName())
+	}
+	return android.AndroidMkData{
+		Class:      "JAVA_LIBRARIES",
+		OutputFile: android.OptionalPathForPath(prebuilt.maybeStrippedDexJarFile),
+		Include:    "$(BUILD_SYSTEM)/soong_java_prebuilt.mk",
+		Required:   required,
+		Extra: []android.AndroidMkExtraFunc{
+			func(w io.Writer, outputFile android.Path) {
+				fmt.Fprintln(w, "LOCAL_SOONG_DEX_JAR :=", prebuilt.dexJarFile.String())
+				if len(prebuilt.publicApiStubsPath) > 0 {
+					// Modules in Make compile against the public stubs of the library.
+					fmt.Fprintln(w, "LOCAL_SOONG_HEADER_JAR :=", prebuilt.publicApiStubsPath.Strings()[0])
+					fmt.Fprintln(w, "LOCAL_SOONG_CLASSES_JAR :=", prebuilt.publicApiStubsPath.Strings()[0])
+				}
+				if len(prebuilt.dexpreopter.builtInstalled) > 0 {
+					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED :=", prebuilt.dexpreopter.builtInstalled)
+				}
+			},
+		},
+	}
+}
+
 func (prebuilt *AARImport) AndroidMk() android.AndroidMkData {
 	return android.AndroidMkData{
 		Class:      "JAVA_LIBRARIES",
@@ -244,8 +277,18 @@ func (binary *Binary) AndroidMk() android.AndroidMkData {
 }
 
 func (app *AndroidApp) AndroidMk() android.AndroidMkData {
+	// The recovery variants of apps that also have a core variant and the ramdisk variants need their own
+	// Make modules.
+	subName := ""
+	if app.installInRamdisk() {
+		subName = "." + appRamdiskVariation
+	} else if app.InstallInRecovery() && !app.onlyInImage() {
+		subName = "." + appRecoveryVariation
+	}
+
 	return android.AndroidMkData{
 		Class:      "APPS",
+		SubName:    subName,
 		OutputFile: android.OptionalPathForPath(app.outputFile),
 		Include:    "$(BUILD_SYSTEM)/soong_app_prebuilt.mk",
 		Extra: []android.AndroidMkExtraFunc{
@@ -253,7 +296,7 @@ func (app *AndroidApp) AndroidMk() android.AndroidMkData {
 				// TODO(jungjw): This, outputting two LOCAL_MODULE lines, works, but is not ideal. Find a better solution.
 				if app.Name() != app.installApkName {
 					fmt.Fprintln(w, "# Overridden by PRODUCT_PACKAGE_NAME_OVERRIDES")
-					fmt.Fprintln(w, "LOCAL_MODULE :=", app.installApkName)
+					fmt.Fprintln(w, "LOCAL_MODULE :=", app.installApkName+subName)
 				}
 				fmt.Fprintln(w, "LOCAL_SOONG_RESOURCE_EXPORT_PACKAGE :=", app.exportPackage.String())
 				if app.dexJarFile != nil {
@@ -282,6 +325,11 @@ func (app *AndroidApp) AndroidMk() android.AndroidMkData {
 					fmt.Fprintln(w, "LOCAL_NO_STANDARD_LIBRARIES := true")
 				}
 
+				if app.InstallInRecovery() || app.installInRamdisk() || app.characteristicsRRO {
+					fmt.Fprintln(w, "LOCAL_MODULE_PATH := $(OUT_DIR)/"+app.installDir.RelPathString())
+					fmt.Fprintln(w, "LOCAL_INSTALLED_MODULE_STEM :=", app.installApkName+".apk")
+				}
+
 				filterRRO := func(filter overlayType) android.Paths {
 					var paths android.Paths
 					for _, d := range app.rroDirs {
@@ -312,7 +360,11 @@ func (app *AndroidApp) AndroidMk() android.AndroidMkData {
 					fmt.Fprintln(w, "LOCAL_PRIVILEGED_MODULE := true")
 				}
 
-				fmt.Fprintln(w, "LOCAL_CERTIFICATE :=", app.certificate.Pem.String())
+				if app.presigned {
+					fmt.Fprintln(w, "LOCAL_CERTIFICATE := PRESIGNED")
+				} else {
+					fmt.Fprintln(w, "LOCAL_CERTIFICATE :=", app.certificate.Pem.String())
+				}
 				if overriddenPkgs := app.getOverriddenPackages(); len(overriddenPkgs) > 0 {
 					fmt.Fprintln(w, "LOCAL_OVERRIDES_PACKAGES :=", strings.Join(overriddenPkgs, " "))
 				}
@@ -327,6 +379,14 @@ func (app *AndroidApp) AndroidMk() android.AndroidMkData {
 					install := "$(LOCAL_MODULE_PATH)/" + strings.TrimSuffix(app.installApkName, ".apk") + split.suffix + ".apk"
 					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED +=", split.path.String()+":"+install)
 				}
+				for _, split := range app.abiSplits {
+					install := "$(LOCAL_MODULE_PATH)/" + app.installApkName + "_" + split.suffix + ".apk"
+					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED +=", split.path.String()+":"+install)
+				}
+				for _, metadata := range app.fsverityMetadata {
+					install := "$(LOCAL_MODULE_PATH)/" + metadata.Base()
+					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED +=", metadata.String()+":"+install)
+				}
 			},
 		},
 	}
@@ -343,10 +403,30 @@ func (a *AndroidApp) getOverriddenPackages() []string {
 	return overridden
 }
 
+func (a *AndroidAppImport) getOverriddenPackages() []string {
+	var overridden []string
+	if len(a.properties.Overrides) > 0 {
+		overridden = append(overridden, a.properties.Overrides...)
+	}
+	if a.BaseModuleName() != a.installApkName {
+		overridden = append(overridden, a.BaseModuleName())
+	}
+	return overridden
+}
+
+// Called for modules that export test_options to the test infrastructure.  Only the options that
+// Make reads are written.
+func testOptionsComponent(w io.Writer, options TestOptions) {
+	if Bool(options.Unit_test) {
+		fmt.Fprintln(w, "LOCAL_IS_UNIT_TEST := true")
+	}
+}
+
 func (a *AndroidTest) AndroidMk() android.AndroidMkData {
 	data := a.AndroidApp.AndroidMk()
 	data.Extra = append(data.Extra, func(w io.Writer, outputFile android.Path) {
-		testSuiteComponent(w, a.testProperties.Test_suites)
+		testSuiteComponent(w, a.testSuites)
+		testOptionsComponent(w, a.testOptionsProperties.Test_options)
 		if a.testConfig != nil {
 			fmt.Fprintln(w, "LOCAL_FULL_TEST_CONFIG :=", a.testConfig.String())
 		}
@@ -360,6 +440,7 @@ func (a *AndroidTestHelperApp) AndroidMk() android.AndroidMkData {
 	data := a.AndroidApp.AndroidMk()
 	data.Extra = append(data.Extra, func(w io.Writer, outputFile android.Path) {
 		testSuiteComponent(w, a.appTestHelperAppProperties.Test_suites)
+		testOptionsComponent(w, a.testOptionsProperties.Test_options)
 	})
 
 	return data
@@ -544,6 +625,19 @@ func (dstubs *Droidstubs) AndroidMk() android.AndroidMkData {
 						fmt.Fprintln(w, "droidcore: checkapi")
 					}
 				}
+				if dstubs.apiLintTimestamp != nil {
+					fmt.Fprintln(w, ".PHONY:", dstubs.Name()+"-api-lint")
+					fmt.Fprintln(w, dstubs.Name()+"-api-lint:", dstubs.apiLintTimestamp.String())
+
+					fmt.Fprintln(w, ".PHONY: checkapi")
+					fmt.Fprintln(w, "checkapi:", dstubs.Name()+"-api-lint")
+
+					fmt.Fprintln(w, ".PHONY: droidcore")
+					fmt.Fprintln(w, "droidcore: checkapi")
+
+					fmt.Fprintln(w, "$(call dist-for-goals,checkapi,"+
+						dstubs.apiLintReport.String()+":"+dstubs.Name()+"-api-lint-report.txt)")
+				}
 				if dstubs.checkNullabilityWarningsTimestamp != nil {
 					fmt.Fprintln(w, ".PHONY:", dstubs.Name()+"-check-nullability-warnings")
 					fmt.Fprintln(w, dstubs.Name()+"-check-nullability-warnings:",
@@ -589,6 +683,10 @@ func (app *AndroidAppImport) AndroidMk() android.AndroidMkData {
 		Include:    "$(BUILD_SYSTEM)/soong_app_prebuilt.mk",
 		Extra: []android.AndroidMkExtraFunc{
 			func(w io.Writer, outputFile android.Path) {
+				if app.BaseModuleName() != app.installApkName {
+					fmt.Fprintln(w, "# Overridden by PRODUCT_PACKAGE_NAME_OVERRIDES")
+					fmt.Fprintln(w, "LOCAL_MODULE :=", app.installApkName)
+				}
 				if Bool(app.properties.Privileged) {
 					fmt.Fprintln(w, "LOCAL_PRIVILEGED_MODULE := true")
 				}
@@ -597,25 +695,50 @@ func (app *AndroidAppImport) AndroidMk() android.AndroidMkData {
 				} else {
 					fmt.Fprintln(w, "LOCAL_CERTIFICATE := PRESIGNED")
 				}
-				if len(app.properties.Overrides) > 0 {
-					fmt.Fprintln(w, "LOCAL_OVERRIDES_PACKAGES :=", strings.Join(app.properties.Overrides, " "))
+				if overriddenPkgs := app.getOverriddenPackages(); len(overriddenPkgs) > 0 {
+					fmt.Fprintln(w, "LOCAL_OVERRIDES_PACKAGES :=", strings.Join(overriddenPkgs, " "))
 				}
 				if len(app.dexpreopter.builtInstalled) > 0 {
 					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED :=", app.dexpreopter.builtInstalled)
 				}
+				for _, split := range app.splits {
+					install := "$(LOCAL_MODULE_PATH)/" + app.installApkName + "_" + split.suffix + ".apk"
+					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED +=", split.path.String()+":"+install)
+				}
+				for _, metadata := range app.fsverityMetadata {
+					install := "$(LOCAL_MODULE_PATH)/" + metadata.Base()
+					fmt.Fprintln(w, "LOCAL_SOONG_BUILT_INSTALLED +=", metadata.String()+":"+install)
+				}
 			},
 		},
 	}
 }
 
+// checkTestData reports the data files of a test that can't be written to LOCAL_TEST_DATA, which
+// splits the path of each file into a prefix and its path relative to the module that provides it.
+func checkTestData(ctx android.ModuleContext, data android.Paths) {
+	for _, d := range data {
+		if !strings.HasSuffix(d.String(), d.Rel()) {
+			ctx.ModuleErrorf("data file %q does not end with %q", d.String(), d.Rel())
+		}
+	}
+}
+
 func androidMkWriteTestData(data android.Paths, ret *android.AndroidMkData) {
 	var testFiles []string
 	for _, d := range data {
-		testFiles = append(testFiles, d.String()+":"+d.Rel())
+		rel := d.Rel()
+		path := d.String()
+		if !strings.HasSuffix(path, rel) {
+			// Already reported by checkTestData.
+			continue
+		}
+		path = strings.TrimSuffix(path, rel)
+		testFiles = append(testFiles, path+":"+rel)
 	}
 	if len(testFiles) > 0 {
 		ret.Extra = append(ret.Extra, func(w io.Writer, outputFile android.Path) {
-			fmt.Fprintln(w, "LOCAL_COMPATIBILITY_SUPPORT_FILES := "+strings.Join(testFiles, " "))
+			fmt.Fprintln(w, "LOCAL_TEST_DATA := "+strings.Join(testFiles, " "))
 		})
 	}
 }
@@ -0,0 +1,98 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var (
+	// metalavaStubsFromText generates the stub sources of the API described by API signature files.
+	metalavaStubsFromText = pctx.AndroidStaticRule("metalavaStubsFromText",
+		blueprint.RuleParams{
+			Command: `rm -rf "$stubsDir" && mkdir -p "$stubsDir" && ` +
+				`${config.JavaCmd} ${config.JavaVmFlags} -jar ${config.MetalavaJar} -encoding UTF-8 ` +
+				`--source-files $in --no-banner --color --quiet --format=v2 --stubs $stubsDir && ` +
+				`${config.SoongZipCmd} -write_if_changed -jar -o $out -C $stubsDir -D $stubsDir`,
+			CommandDeps: []string{
+				"${config.JavaCmd}",
+				"${config.MetalavaJar}",
+				"${config.SoongZipCmd}",
+			},
+			Restat: true,
+		},
+		"stubsDir")
+)
+
+func init() {
+	android.RegisterModuleType("java_api_library", ApiLibraryFactory)
+}
+
+// java_api_library compiles the stubs of an API surface from its API signature files, like
+// api/current.txt, without the sources of the implementation.  Modules can compile against it with
+// libs like against the stubs generated from the sources by droidstubs.
+func ApiLibraryFactory() android.Module {
+	module := &ApiLibrary{}
+
+	module.AddProperties(
+		&module.Module.properties,
+		&module.Module.deviceProperties,
+		&module.apiLibraryProperties)
+
+	InitJavaModule(module, android.DeviceSupported)
+	return module
+}
+
+type ApiLibrary struct {
+	Library
+
+	apiLibraryProperties ApiLibraryProperties
+
+	stubsSrcJar android.WritablePath
+}
+
+type ApiLibraryProperties struct {
+	// list of API signature files to generate the stubs from.  The APIs of later files are added
+	// to the APIs of earlier files, e.g. ["api/current.txt", "api/system-current.txt"].
+	Api_files []string `android:"path"`
+}
+
+func (al *ApiLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(al.Module.properties.Srcs) > 0 {
+		ctx.PropertyErrorf("srcs", "java_api_library is compiled from api_files, it can't have srcs")
+	}
+
+	apiFiles := android.PathsForModuleSrc(ctx, al.apiLibraryProperties.Api_files)
+	if len(apiFiles) == 0 {
+		ctx.PropertyErrorf("api_files", "must not be empty")
+		return
+	}
+
+	al.stubsSrcJar = android.PathForModuleOut(ctx, "metalava", ctx.ModuleName()+"-stubs.srcjar")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        metalavaStubsFromText,
+		Description: "metalava stubs from text",
+		Output:      al.stubsSrcJar,
+		Inputs:      apiFiles,
+		Args: map[string]string{
+			"stubsDir": android.PathForModuleOut(ctx, "metalava", "stubsDir").String(),
+		},
+	})
+
+	al.Module.extraSrcJars = android.Paths{al.stubsSrcJar}
+	al.Module.compile(ctx, nil)
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApiLibrary(t *testing.T) {
+	ctx := testJava(t, `
+		java_api_library {
+			name: "foo.stubs.from-text",
+			api_files: ["api/current.txt", "api/system-current.txt"],
+			sdk_version: "none",
+			system_modules: "core-platform-api-stubs-system-modules",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+			libs: ["foo.stubs.from-text"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo.stubs.from-text", "android_common")
+	stubs := foo.Rule("metalavaStubsFromText")
+	if g, w := stubs.Inputs.Strings(), []string{"api/current.txt", "api/system-current.txt"}; strings.Join(g, " ") != strings.Join(w, " ") {
+		t.Errorf("expected stubs inputs %q, got %q", w, g)
+	}
+
+	javac := foo.Rule("javac")
+	if len(javac.Inputs) != 0 {
+		t.Errorf("expected no java sources, got %q", javac.Inputs)
+	}
+	if !strings.Contains(javac.Args["srcJars"], stubs.Output.String()) {
+		t.Errorf("expected javac srcjars %q to contain %q", javac.Args["srcJars"], stubs.Output.String())
+	}
+	if generated := foo.Module().(*ApiLibrary).generatedSrcJars; len(generated) != 0 {
+		t.Errorf("expected the stubs not to be compiled as aapt sources, got %q", generated)
+	}
+
+	fooHeaderJar := filepath.Join(buildDir, ".intermediates", "foo.stubs.from-text", "android_common",
+		"turbine-combined", "foo.stubs.from-text.jar")
+	if classpath := ctx.ModuleForTests("bar", "android_common").Rule("javac").Args["classpath"]; !strings.Contains(classpath, fooHeaderJar) {
+		t.Errorf("expected bar classpath %q to contain %q", classpath, fooHeaderJar)
+	}
+}
+
+func TestApiLibraryErrors(t *testing.T) {
+	testJavaError(t, `api_files: must not be empty`, `
+		java_api_library {
+			name: "foo",
+		}
+	`)
+
+	testJavaError(t, `srcs: java_api_library is compiled from api_files`, `
+		java_api_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_files: ["api/current.txt"],
+		}
+	`)
+}
@@ -19,18 +19,23 @@ package java
 import (
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 	"android/soong/cc"
+	"android/soong/dexpreopt"
 	"android/soong/tradefed"
 )
 
 var supportedDpis = [...]string{"Ldpi", "Mdpi", "Hdpi", "Xhdpi", "Xxhdpi", "Xxxhdpi"}
 var dpiVariantsStruct reflect.Type
+var archVariantsStruct reflect.Type
+var appAbiVariantsStruct reflect.Type
 
 func init() {
 	android.RegisterModuleType("android_app", AndroidAppFactory)
@@ -40,8 +45,12 @@ func init() {
 	android.RegisterModuleType("override_android_app", OverrideAndroidAppModuleFactory)
 	android.RegisterModuleType("android_app_import", AndroidAppImportFactory)
 
-	// Dynamically construct a struct for the dpi_variants property in android_app_import.
-	perDpiStruct := reflect.StructOf([]reflect.StructField{
+	android.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
+		ctx.BottomUp("app_image", appImageMutator).Parallel()
+	})
+
+	// Dynamically construct structs for the dpi_variants and arch properties in android_app_import.
+	perVariantStruct := reflect.StructOf([]reflect.StructField{
 		{
 			Name: "Apk",
 			Type: reflect.TypeOf((*string)(nil)),
@@ -51,10 +60,30 @@ func init() {
 	for i, dpi := range supportedDpis {
 		dpiVariantsFields[i] = reflect.StructField{
 			Name: string(dpi),
-			Type: perDpiStruct,
+			Type: perVariantStruct,
 		}
 	}
 	dpiVariantsStruct = reflect.StructOf(dpiVariantsFields)
+
+	archTypes := android.ArchTypeList()
+	archVariantsFields := make([]reflect.StructField, len(archTypes))
+	for i, archType := range archTypes {
+		archVariantsFields[i] = reflect.StructField{
+			Name: archType.Field,
+			Type: perVariantStruct,
+		}
+	}
+	archVariantsStruct = reflect.StructOf(archVariantsFields)
+
+	// Dynamically construct the struct for the per-ABI properties of android_app.
+	appAbiVariantsFields := make([]reflect.StructField, len(archTypes))
+	for i, archType := range archTypes {
+		appAbiVariantsFields[i] = reflect.StructField{
+			Name: archType.Field,
+			Type: reflect.TypeOf(appAbiProperties{}),
+		}
+	}
+	appAbiVariantsStruct = reflect.StructOf(appAbiVariantsFields)
 }
 
 // AndroidManifest.xml merging
@@ -73,6 +102,17 @@ type appProperties struct {
 	// normal apps.
 	Privileged *bool
 
+	// Make this app available when building for recovery.  The recovery variant is installed to the
+	// recovery image, and only embeds the recovery variants of the jni_libs of the primary arch.
+	Recovery_available *bool
+
+	// Make this app available when building for the ramdisk.  The ramdisk variant is installed to the
+	// ramdisk, and can't have jni_libs.
+	Ramdisk_available *bool
+
+	InRecovery bool `blueprint:"mutated"`
+	InRamdisk  bool `blueprint:"mutated"`
+
 	// list of resource labels to generate individual resource packages
 	Package_splits []string
 
@@ -86,6 +126,11 @@ type appProperties struct {
 	// list of native libraries that will be provided in or alongside the resulting jar
 	Jni_libs []string `android:"arch_variant"`
 
+	// If true, fail the build if a library in jni_libs exports neither JNI_OnLoad nor any Java_ native method
+	// symbol, for example because its version_script hides them, instead of failing when the app loads it.
+	// Defaults to false.
+	Check_jni_symbols *bool
+
 	// STL library to use for JNI libraries.
 	Stl *string `android:"arch_variant"`
 
@@ -93,22 +138,103 @@ type appProperties struct {
 	// flag so that they are used from inside the APK at runtime.  Defaults to true for android_test modules unless
 	// sdk_version or min_sdk_version is set to a version that doesn't support it (<23), defaults to false for other
 	// module types where the native libraries are generally preinstalled outside the APK.
-	Use_embedded_native_libs *bool
+	Use_embedded_native_libs *bool `android:"arch_variant"`
+
+	// If true, package the embedded JNI libraries of each ABI into a config split APK for that ABI, installed as
+	// <name>_config.<abi>.apk next to the base APK, instead of packaging the libraries of every ABI into the base
+	// APK.  Requires the JNI libraries to be embedded in the app.
+	Abi_splits *bool
 
 	// Store dex files uncompressed in the APK and set the android:useEmbeddedDex="true" manifest attribute so that
 	// they are used from inside the APK at runtime.
 	Use_embedded_dex *bool
 
+	Desugar_report struct {
+		// If true, write desugar_report.txt, which lists the default and static interface methods of the app that
+		// are desugared for its min_sdk_version, and the classes that desugaring needed but that are neither in the
+		// app nor on its classpath.  Defaults to false.
+		Enabled *bool
+
+		// If true, fail the build when desugaring needed classes that are neither in the app nor on its classpath,
+		// as the desugared classes would fail at runtime, instead of only printing a warning.  Defaults to false.
+		Error_on_missing_classes *bool
+	}
+
+	// If true, the version name of the app is the platform version name followed by the build number, e.g. "Q-123456",
+	// unless version_name is set.  The build number is read from the build number file when the resources of the
+	// app are linked, so it doesn't cause the build to be reanalyzed.
+	Version_name_with_build_number *bool
+
+	// If true, generate the fs-verity metadata of the installed APK and of its dexpreopt files, and install it next
+	// to them with a .fsv_meta suffix, for devices that enable fs-verity on the partition the app is installed to.
+	Fsverity_metadata *bool
+
+	// Store dex files uncompressed in the APK and zip-align them so that they can be used from inside the APK
+	// without being extracted.  If unset, dex files are stored uncompressed when the app is dexpreopted onto the
+	// system partition, when it is a privileged app and PRODUCT_UNCOMPRESS_PRIV_APP_DEX is set, or when
+	// use_embedded_dex is true.  Must not be false when use_embedded_dex is true.
+	Uncompress_dex *bool
+
+	// If set, adds a <meta-data android:name="android.content.pm.LOGGING_PARENT"> element with this value to the
+	// application element of the manifest, naming the package that logs on behalf of this app.
+	Logging_parent *string
+
+	// If true, sets android:testOnly="true" on the application element of the manifest.
+	Test_only *bool
+
+	// If true, replaces the minSdkVersion in the manifest with the value of min_sdk_version even if it is lower.
+	// By default the minSdkVersion in the manifest is only raised to the value of min_sdk_version.
+	Override_min_sdk_version *bool
+
+	// If true, the resources of the app are linked without the product characteristics, and a runtime resource
+	// overlay that contains the values of the resources for the PRODUCT_CHARACTERISTICS of the product, for example
+	// "tablet", is generated and installed to the product partition with the app.  This lets the same APK be used
+	// on every class of device.
+	Generate_product_characteristics_rro *bool
+
+	// If true, the app is updated independently of the platform, for example as part of a Mainline module.
+	// Updatable apps must set min_sdk_version to at least 24 so that they are verified with the APK signature
+	// scheme v2 or later, build against a stable SDK, and store their jni_libs uncompressed with
+	// use_embedded_native_libs.  Apps don't support apex_available, so an updatable app is not checked
+	// for a stable list of the APEXes it can be included in.
+	Updatable *bool
+
 	// Forces native libraries to always be packaged into the APK,
 	// Use_embedded_native_libs still selects whether they are stored uncompressed and aligned or compressed.
 	// True for android_test* modules.
 	AlwaysPackageNativeLibs bool `blueprint:"mutated"`
 }
 
+// appArchProperties contains the per-ABI properties of an android_app.  Apps are built as a single common variant
+// that packages the JNI libraries of every ABI, so these are applied to the matching ABI directory of the APK
+// instead of selecting a variant like arch_variant properties.
+type appArchProperties struct {
+	// A struct with an appAbiProperties field for each arch, see appAbiVariantsStruct.
+	Arch interface{}
+}
+
+type appAbiProperties struct {
+	// list of jni_libs that are not packaged for this ABI, for example because they have no port to it.
+	Exclude_jni_libs []string
+}
+
+// abiProperties returns the appAbiProperties for an arch.
+func (p *appArchProperties) abiProperties(archType android.ArchType) *appAbiProperties {
+	return reflect.ValueOf(p.Arch).Elem().FieldByName(archType.Field).Addr().Interface().(*appAbiProperties)
+}
+
+// jniLibsFor returns the jni_libs that are packaged for a target.
+func (a *AndroidApp) jniLibsFor(target android.Target) []string {
+	excluded := a.appArchProperties.abiProperties(target.Arch.ArchType).Exclude_jni_libs
+	return android.RemoveListFromList(a.appProperties.Jni_libs, excluded)
+}
+
 // android_app properties that can be overridden by override_android_app
 type overridableAppProperties struct {
 	// The name of a certificate in the default certificate directory, blank to use the default product certificate,
-	// or an android_app_certificate module name in the form ":module".
+	// or an android_app_certificate module name in the form ":module".  "PRESIGNED" skips signing the apk for apps
+	// that are signed outside the build, the zip-aligned unsigned apks are listed in the ".external_signing" output.
+	// It can only be used in the projects allowed by a neverallow rule.
 	Certificate *string
 
 	// the package name of this app. The package name in the manifest file is used if one was not given.
@@ -126,12 +252,43 @@ type AndroidApp struct {
 
 	appProperties appProperties
 
+	appArchProperties appArchProperties
+
 	overridableAppProperties overridableAppProperties
 
 	installJniLibs []jniLib
 
+	// the directory the APK is installed to
+	installDir android.OutputPath
+
+	// true for the runtime resource overlay generated for an app that sets generate_product_characteristics_rro,
+	// which is installed in the overlay directory instead of the app directory
+	characteristicsRRO bool
+
+	// true if the certificate is PRESIGNED, the app packages are then only zip-aligned and signed outside the build
+	presigned bool
+
+	// the certificate of the android_test that signs the app instead of its own certificate property,
+	// copied from the test when the app is generated
+	certificateFromTest *testCertificate
+
+	// the list of APKs to sign outside the build for a PRESIGNED app
+	externalSigningList android.Path
+
+	// the fs-verity metadata of the installed base and split APKs when fsverity_metadata is set
+	fsverityMetadata android.Paths
+
+	// how the installed APK was built, for the apk_provenance singleton
+	provenance *apkProvenance
+
+	// the signed config split APKs containing the embedded JNI libraries of each ABI when abi_splits is set
+	abiSplits []split
+
 	bundleFile android.Path
 
+	// srcjar containing all the sources generated while building the app
+	generatedSrcJar android.Path
+
 	// the install APK name is normally the same as the module name, but can be overridden with PRODUCT_PACKAGE_NAME_OVERRIDES.
 	installApkName string
 
@@ -148,10 +305,113 @@ func (a *AndroidApp) ExportedStaticPackages() android.Paths {
 
 var _ AndroidLibraryDependency = (*AndroidApp)(nil)
 
+func (a *AndroidApp) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case ".generated_srcjar":
+		if a.generatedSrcJar == nil {
+			return nil, nil
+		}
+		return android.Paths{a.generatedSrcJar}, nil
+	case ".manifest_merger_report":
+		if a.manifestMergerReport == nil {
+			return nil, nil
+		}
+		return android.Paths{a.manifestMergerReport}, nil
+	case ".external_signing":
+		if a.externalSigningList == nil {
+			return nil, nil
+		}
+		return android.Paths{a.externalSigningList}, nil
+	case ".apk":
+		return android.Paths{a.outputFile}, nil
+	default:
+		return a.Module.OutputFiles(tag)
+	}
+}
+
 type Certificate struct {
 	Pem, Key android.Path
 }
 
+func (a *AndroidApp) apkProvenance() *apkProvenance {
+	return a.provenance
+}
+
+func (a *AndroidApp) ModuleMetadata(metadata *android.ModuleMetadata) {
+	if a.presigned {
+		metadata.Certificate = "PRESIGNED"
+	} else if a.certificate.Pem != nil {
+		metadata.Certificate = strings.TrimSuffix(a.certificate.Pem.String(), ".x509.pem")
+	}
+}
+
+func (a *AndroidApp) InstallInRecovery() bool {
+	// The ramdisk variant of an app that is only installed to recovery is still installed to the ramdisk.
+	return !a.appProperties.InRamdisk && (a.appProperties.InRecovery || a.ModuleBase.InstallInRecovery())
+}
+
+func (a *AndroidApp) installInRamdisk() bool {
+	return a.appProperties.InRamdisk
+}
+
+// onlyInImage returns true if the app has no core variant, because it is only installed to the recovery
+// image.
+func (a *AndroidApp) onlyInImage() bool {
+	return a.ModuleBase.InstallInRecovery()
+}
+
+// installPath returns the install path of a file of the app.  The ramdisk isn't a partition that modules
+// install to, so the ramdisk variant is installed to ramdisk/system, which has the layout of the system
+// partition like recovery/root/system.
+func (a *AndroidApp) installPath(ctx android.ModuleContext, pathComponents ...string) android.OutputPath {
+	if a.installInRamdisk() {
+		ramdisk := android.PathForOutput(ctx, "target", "product", ctx.Config().DeviceName(), "ramdisk", "system")
+		return ramdisk.Join(ctx, pathComponents...)
+	}
+	return android.PathForModuleInstall(ctx, pathComponents...)
+}
+
+const (
+	appRecoveryVariation = "recovery"
+	appRamdiskVariation  = "ramdisk"
+)
+
+// appImageMutator creates the recovery and ramdisk variants of the apps that are available there.  The
+// core variant keeps an empty variation name, and the variations are local so that the dependencies on
+// an app still resolve to its core variant.
+func appImageMutator(ctx android.BottomUpMutatorContext) {
+	a, ok := ctx.Module().(*AndroidApp)
+	if !ok {
+		return
+	}
+
+	recoveryVariantNeeded := Bool(a.appProperties.Recovery_available) || a.ModuleBase.InstallInRecovery()
+	ramdiskVariantNeeded := Bool(a.appProperties.Ramdisk_available)
+	if !recoveryVariantNeeded && !ramdiskVariantNeeded {
+		return
+	}
+
+	var variants []string
+	if !a.onlyInImage() {
+		variants = append(variants, "")
+	}
+	if recoveryVariantNeeded {
+		variants = append(variants, appRecoveryVariation)
+	}
+	if ramdiskVariantNeeded {
+		variants = append(variants, appRamdiskVariation)
+	}
+	modules := ctx.CreateLocalVariations(variants...)
+	for i, v := range variants {
+		switch v {
+		case appRecoveryVariation:
+			modules[i].(*AndroidApp).appProperties.InRecovery = true
+		case appRamdiskVariation:
+			modules[i].(*AndroidApp).appProperties.InRamdisk = true
+		}
+	}
+}
+
 func (a *AndroidApp) DepsMutator(ctx android.BottomUpMutatorContext) {
 	a.Module.deps(ctx)
 
@@ -165,15 +425,25 @@ func (a *AndroidApp) DepsMutator(ctx android.BottomUpMutatorContext) {
 	}
 
 	embedJni := a.shouldEmbedJnis(ctx)
+	if a.installInRamdisk() && len(a.appProperties.Jni_libs) > 0 {
+		ctx.PropertyErrorf("jni_libs", "there are no native libraries in the ramdisk, the ramdisk variant can't have any")
+	}
 	for _, jniTarget := range ctx.MultiTargets() {
 		variation := []blueprint.Variation{
 			{Mutator: "arch", Variation: jniTarget.String()},
 			{Mutator: "link", Variation: "shared"},
 		}
+		if a.InstallInRecovery() {
+			// Native libraries are only built for the primary arch of the recovery image.
+			if jniTarget.Arch.ArchType != ctx.Config().DevicePrimaryArchType() {
+				continue
+			}
+			variation = append(variation, blueprint.Variation{Mutator: "image", Variation: "recovery"})
+		}
 		tag := &jniDependencyTag{
 			target: jniTarget,
 		}
-		ctx.AddFarVariationDependencies(variation, tag, a.appProperties.Jni_libs...)
+		ctx.AddFarVariationDependencies(variation, tag, a.jniLibsFor(jniTarget)...)
 		if String(a.appProperties.Stl) == "c++_shared" {
 			if embedJni {
 				ctx.AddFarVariationDependencies(variation, tag, "ndk_libc++_shared")
@@ -202,8 +472,10 @@ func (a *AndroidApp) OverridablePropertiesDepsMutator(ctx android.BottomUpMutato
 }
 
 func (a *AndroidApp) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "APPS")
 	a.aapt.useEmbeddedNativeLibs = a.useEmbeddedNativeLibs(ctx)
 	a.aapt.useEmbeddedDex = Bool(a.appProperties.Use_embedded_dex)
+	a.aapt.versionNameBuildNumber = Bool(a.appProperties.Version_name_with_build_number)
 	a.generateAndroidBuildActions(ctx)
 }
 
@@ -221,9 +493,16 @@ func (a *AndroidApp) useEmbeddedNativeLibs(ctx android.ModuleContext) bool {
 // Returns whether this module should have the dex file stored uncompressed in the APK.
 func (a *AndroidApp) shouldUncompressDex(ctx android.ModuleContext) bool {
 	if Bool(a.appProperties.Use_embedded_dex) {
+		if !BoolDefault(a.appProperties.Uncompress_dex, true) {
+			ctx.PropertyErrorf("uncompress_dex", "must not be false when use_embedded_dex is true")
+		}
 		return true
 	}
 
+	if a.appProperties.Uncompress_dex != nil {
+		return *a.appProperties.Uncompress_dex
+	}
+
 	if ctx.Config().UnbundledBuild() {
 		return false
 	}
@@ -237,12 +516,27 @@ func (a *AndroidApp) shouldUncompressDex(ctx android.ModuleContext) bool {
 }
 
 func (a *AndroidApp) shouldEmbedJnis(ctx android.BaseModuleContext) bool {
+	// There is no shared library directory to install the libraries of an app in the recovery image to.
 	return ctx.Config().UnbundledBuild() || Bool(a.appProperties.Use_embedded_native_libs) ||
-		a.appProperties.AlwaysPackageNativeLibs
+		a.appProperties.AlwaysPackageNativeLibs || a.InstallInRecovery()
+}
+
+// renamedManifestPackageName returns the package name the manifest package is renamed to, or an empty
+// string if it is not renamed.
+func (a *AndroidApp) renamedManifestPackageName(ctx android.ModuleContext) string {
+	manifestPackageName, overridden := ctx.DeviceConfig().OverrideManifestPackageNameFor(ctx.ModuleName())
+	if overridden {
+		// The product override variable has a priority over the package_name property.
+		return manifestPackageName
+	}
+	return String(a.overridableAppProperties.Package_name)
 }
 
 func (a *AndroidApp) aaptBuildActions(ctx android.ModuleContext) {
 	a.aapt.usesNonSdkApis = Bool(a.Module.deviceProperties.Platform_apis)
+	a.aapt.loggingParent = String(a.appProperties.Logging_parent)
+	a.aapt.testOnly = Bool(a.appProperties.Test_only)
+	a.aapt.overrideMinSdkVersion = Bool(a.appProperties.Override_min_sdk_version)
 
 	// Ask manifest_fixer to add or update the application element indicating this app has no code.
 	a.aapt.hasNoCode = !a.hasCode(ctx)
@@ -257,7 +551,9 @@ func (a *AndroidApp) aaptBuildActions(ctx android.ModuleContext) {
 			break
 		}
 	}
-	if !hasProduct && len(ctx.Config().ProductAAPTCharacteristics()) > 0 {
+	// The product characteristics are applied by the generated runtime resource overlay instead.
+	if !hasProduct && len(ctx.Config().ProductAAPTCharacteristics()) > 0 &&
+		!Bool(a.appProperties.Generate_product_characteristics_rro) {
 		aaptLinkFlags = append(aaptLinkFlags, "--product", ctx.Config().ProductAAPTCharacteristics())
 	}
 
@@ -273,12 +569,7 @@ func (a *AndroidApp) aaptBuildActions(ctx android.ModuleContext) {
 		}
 	}
 
-	manifestPackageName, overridden := ctx.DeviceConfig().OverrideManifestPackageNameFor(ctx.ModuleName())
-	if overridden || a.overridableAppProperties.Package_name != nil {
-		// The product override variable has a priority over the package_name property.
-		if !overridden {
-			manifestPackageName = *a.overridableAppProperties.Package_name
-		}
+	if manifestPackageName := a.renamedManifestPackageName(ctx); manifestPackageName != "" {
 		aaptLinkFlags = append(aaptLinkFlags, "--rename-manifest-package "+manifestPackageName)
 	}
 
@@ -294,17 +585,11 @@ func (a *AndroidApp) aaptBuildActions(ctx android.ModuleContext) {
 }
 
 func (a *AndroidApp) proguardBuildActions(ctx android.ModuleContext) {
-	var staticLibProguardFlagFiles android.Paths
-	ctx.VisitDirectDeps(func(m android.Module) {
-		if lib, ok := m.(AndroidLibraryDependency); ok && ctx.OtherModuleDependencyTag(m) == staticLibTag {
-			staticLibProguardFlagFiles = append(staticLibProguardFlagFiles, lib.ExportedProguardFlagFiles()...)
-		}
-	})
-
-	staticLibProguardFlagFiles = android.FirstUniquePaths(staticLibProguardFlagFiles)
-
-	a.Module.extraProguardFlagFiles = append(a.Module.extraProguardFlagFiles, staticLibProguardFlagFiles...)
-	a.Module.extraProguardFlagFiles = append(a.Module.extraProguardFlagFiles, a.proguardOptionsFile)
+	// The proguard flag files of the static_libs are collected by Module, only the keep rules
+	// generated by aapt2 for the classes referenced by the manifest and resources are added here.
+	if !Bool(a.deviceProperties.Optimize.No_aapt_flags) {
+		a.Module.extraProguardFlagFiles = append(a.Module.extraProguardFlagFiles, a.proguardOptionsFile)
+	}
 }
 
 func (a *AndroidApp) dexBuildActions(ctx android.ModuleContext) android.Path {
@@ -313,31 +598,63 @@ func (a *AndroidApp) dexBuildActions(ctx android.ModuleContext) android.Path {
 	if ctx.ModuleName() == "framework-res" {
 		// framework-res.apk is installed as system/framework/framework-res.apk
 		installDir = "framework"
+	} else if a.characteristicsRRO {
+		installDir = "overlay"
 	} else if Bool(a.appProperties.Privileged) {
 		installDir = filepath.Join("priv-app", a.installApkName)
 	} else {
 		installDir = filepath.Join("app", a.installApkName)
 	}
 
-	a.dexpreopter.installPath = android.PathForModuleInstall(ctx, installDir, a.installApkName+".apk")
+	a.dexpreopter.installPath = a.installPath(ctx, installDir, a.installApkName+".apk")
 	a.dexpreopter.isInstallable = Bool(a.properties.Installable)
+	a.dexpreopter.isInRamdisk = a.installInRamdisk()
 	a.dexpreopter.uncompressedDex = a.shouldUncompressDex(ctx)
 
 	a.dexpreopter.enforceUsesLibs = a.usesLibrary.enforceUsesLibraries()
-	a.dexpreopter.usesLibs = a.usesLibrary.usesLibraryProperties.Uses_libs
-	a.dexpreopter.optionalUsesLibs = a.usesLibrary.presentOptionalUsesLibs(ctx)
-	a.dexpreopter.libraryPaths = a.usesLibrary.usesLibraryPaths(ctx)
+	a.dexpreopter.classLoaderContexts = a.usesLibrary.classLoaderContexts(ctx, false)
 	a.dexpreopter.manifestFile = a.mergedManifestFile
 
 	a.deviceProperties.UncompressDex = a.dexpreopter.uncompressedDex
+	a.deviceProperties.DesugarReport = Bool(a.appProperties.Desugar_report.Enabled)
+	a.deviceProperties.DesugarReportErrors = Bool(a.appProperties.Desugar_report.Error_on_missing_classes)
 
 	if ctx.ModuleName() != "framework-res" {
+		a.linter.manifest = a.manifestPath
+		a.linter.resources = a.resourceFiles
 		a.Module.compile(ctx, a.aaptSrcJar)
 	}
 
 	return a.maybeStrippedDexJarFile
 }
 
+// checkJniLibs verifies that the exclude_jni_libs properties only exclude jni_libs, and that the JNI libraries
+// packaged for each ABI don't link against a jni_libs library that is excluded for that ABI, which would only fail
+// when the library is loaded at runtime.
+func (a *AndroidApp) checkJniLibs(ctx android.ModuleContext, jniLibs []jniLib) {
+	for _, archType := range android.ArchTypeList() {
+		property := "arch." + archType.Name + ".exclude_jni_libs"
+		excluded := a.appArchProperties.abiProperties(archType).Exclude_jni_libs
+		for _, lib := range excluded {
+			if !android.InList(lib, a.appProperties.Jni_libs) {
+				ctx.PropertyErrorf(property, "%q is not in jni_libs", lib)
+			}
+		}
+
+		for _, lib := range jniLibs {
+			if lib.target.Arch.ArchType != archType {
+				continue
+			}
+			for _, dep := range lib.sharedLibs {
+				if android.InList(dep, excluded) {
+					ctx.PropertyErrorf(property, "%q is needed by %q in %s", dep, lib.name,
+						targetToJniDir(lib.target))
+				}
+			}
+		}
+	}
+}
+
 func (a *AndroidApp) jniBuildActions(jniLibs []jniLib, ctx android.ModuleContext) android.WritablePath {
 	var jniJarFile android.WritablePath
 	if len(jniLibs) > 0 {
@@ -391,6 +708,8 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 	// Check if the install APK name needs to be overridden.
 	a.installApkName = ctx.DeviceConfig().OverridePackageNameFor(a.Name())
 
+	a.checkUpdatable(ctx)
+
 	// Process all building blocks, from AAPT to certificates.
 	a.aaptBuildActions(ctx)
 
@@ -403,26 +722,62 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 
 	dexJarFile := a.dexBuildActions(ctx)
 
+	// Package the generated sources so that they can be indexed by IDEs.
+	a.generatedSrcJar = a.buildGeneratedSrcJar(ctx)
+	if a.generatedSrcJar != nil {
+		ctx.CheckbuildFile(a.generatedSrcJar)
+	}
+
 	jniLibs, certificateDeps := collectAppDeps(ctx)
+	a.checkJniLibs(ctx, jniLibs)
 	jniJarFile := a.jniBuildActions(jniLibs, ctx)
+	if Bool(a.appProperties.Check_jni_symbols) {
+		apkDeps = append(apkDeps, CheckJniSymbols(ctx, jniLibs)...)
+	}
+	if Bool(a.appProperties.Abi_splits) && !a.shouldEmbedJnis(ctx) {
+		ctx.PropertyErrorf("abi_splits", "requires the JNI libraries to be embedded, set use_embedded_native_libs")
+	}
+
+	// A product certificate override signs the app in the build even if it is PRESIGNED.
+	a.presigned = a.getCertString(ctx) == "PRESIGNED"
+	if a.certificateFromTest != nil {
+		a.presigned = a.certificateFromTest.presigned
+	}
+	if a.presigned && len(certificateDeps) > 0 {
+		ctx.PropertyErrorf("additional_certificates", "can't be specified for PRESIGNED apps")
+	}
 
 	if ctx.Failed() {
 		return
 	}
 
-	certificates := processMainCert(a.ModuleBase, a.getCertString(ctx), certificateDeps, ctx)
-	a.certificate = certificates[0]
+	var certificates []Certificate
+	if a.certificateFromTest != nil && !a.presigned {
+		certificates = append([]Certificate{a.certificateFromTest.certificate}, certificateDeps...)
+		a.certificate = certificates[0]
+	} else if !a.presigned {
+		certificates = processMainCert(a.ModuleBase, a.getCertString(ctx), certificateDeps, ctx)
+		a.certificate = certificates[0]
+	}
 
 	// Build a final signed app package.
 	// TODO(jungjw): Consider changing this to installApkName.
 	packageFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".apk")
-	CreateAndSignAppPackage(ctx, packageFile, a.exportPackage, jniJarFile, dexJarFile, certificates, apkDeps)
+	var installDeps android.Paths
+	if Bool(a.appProperties.Abi_splits) && jniJarFile != nil {
+		// The JNI libraries go into the ABI splits, make sure none of them ended up in the base APK.
+		a.abiSplitBuildActions(ctx, jniLibs, certificates, apkDeps)
+		a.createAppPackage(ctx, packageFile, a.exportPackage, nil, dexJarFile, certificates, apkDeps)
+		installDeps = append(installDeps, CheckNoJniLibs(ctx, packageFile))
+	} else {
+		a.createAppPackage(ctx, packageFile, a.exportPackage, jniJarFile, dexJarFile, certificates, apkDeps)
+	}
 	a.outputFile = packageFile
 
 	for _, split := range a.aapt.splits {
 		// Sign the split APKs
 		packageFile := android.PathForModuleOut(ctx, ctx.ModuleName()+"_"+split.suffix+".apk")
-		CreateAndSignAppPackage(ctx, packageFile, split.path, nil, nil, certificates, apkDeps)
+		a.createAppPackage(ctx, packageFile, split.path, nil, nil, certificates, apkDeps)
 		a.extraOutputFiles = append(a.extraOutputFiles, packageFile)
 	}
 
@@ -435,17 +790,156 @@ func (a *AndroidApp) generateAndroidBuildActions(ctx android.ModuleContext) {
 	var installDir android.OutputPath
 	if ctx.ModuleName() == "framework-res" {
 		// framework-res.apk is installed as system/framework/framework-res.apk
-		installDir = android.PathForModuleInstall(ctx, "framework")
+		installDir = a.installPath(ctx, "framework")
+	} else if a.characteristicsRRO {
+		// Static runtime resource overlays are only scanned from the overlay directory.
+		installDir = a.installPath(ctx, "overlay")
 	} else if Bool(a.appProperties.Privileged) {
-		installDir = android.PathForModuleInstall(ctx, "priv-app", a.installApkName)
+		installDir = a.installPath(ctx, "priv-app", a.installApkName)
+	} else {
+		installDir = a.installPath(ctx, "app", a.installApkName)
+	}
+	a.installDir = installDir
+
+	installedApks := android.Paths{ctx.InstallFile(installDir, a.installApkName+".apk", a.outputFile, installDeps...)}
+	a.provenance = &apkProvenance{
+		apk:          a.outputFile,
+		installPath:  installDir.Join(ctx, a.installApkName+".apk"),
+		inputs:       android.Paths{a.exportPackage},
+		certificates: certificates,
+	}
+	for _, input := range []android.Path{dexJarFile, jniJarFile} {
+		if input != nil {
+			a.provenance.inputs = append(a.provenance.inputs, input)
+		}
+	}
+	for _, split := range a.aapt.splits {
+		installedApks = append(installedApks,
+			ctx.InstallFile(installDir, a.installApkName+"_"+split.suffix+".apk", split.path))
+	}
+	for _, split := range a.abiSplits {
+		installedApks = append(installedApks,
+			ctx.InstallFile(installDir, a.installApkName+"_"+split.suffix+".apk", split.path))
+	}
+	if Bool(a.appProperties.Fsverity_metadata) {
+		a.fsverityMetadata = fsverityBuildActions(ctx, &a.dexpreopter, installDir, installedApks)
+	}
+	a.dexpreopter.installBuiltFiles(ctx)
+	// Make installs links to the JNI libraries that aren't embedded in the app.  Without Make, install
+	// copies of them next to the app so that the <module>-install phony target is usable on its own.
+	for _, jniLib := range a.installJniLibs {
+		ctx.InstallFile(installDir.Join(ctx, "lib", jniLib.target.Arch.ArchType.String()), jniLib.path.Base(),
+			jniLib.path)
+	}
+
+	if a.presigned {
+		a.externalSigningList = a.buildExternalSigningList(ctx, installDir)
+	}
+}
+
+// createAppPackage builds an app package signed with certificates, or a zip-aligned unsigned one for a PRESIGNED
+// app that is signed outside the build.
+func (a *AndroidApp) createAppPackage(ctx android.ModuleContext, outputFile android.WritablePath,
+	packageFile, jniJarFile, dexJarFile android.Path, certificates []Certificate, deps android.Paths) {
+
+	if a.presigned {
+		CreateAndAlignAppPackage(ctx, outputFile, packageFile, jniJarFile, dexJarFile, deps)
 	} else {
-		installDir = android.PathForModuleInstall(ctx, "app", a.installApkName)
+		CreateAndSignAppPackage(ctx, outputFile, packageFile, jniJarFile, dexJarFile, certificates, deps)
 	}
+}
 
-	ctx.InstallFile(installDir, a.installApkName+".apk", a.outputFile)
+// buildExternalSigningList writes the list of unsigned APKs of a PRESIGNED app that the external signing step has
+// to sign, one "<built apk>:<install path>" line per APK, the format of LOCAL_SOONG_BUILT_INSTALLED.
+func (a *AndroidApp) buildExternalSigningList(ctx android.ModuleContext, installDir android.OutputPath) android.Path {
+	apks := []string{a.outputFile.String() + ":" + installDir.Join(ctx, a.installApkName+".apk").String()}
 	for _, split := range a.aapt.splits {
-		ctx.InstallFile(installDir, a.installApkName+"_"+split.suffix+".apk", split.path)
+		packageFile := android.PathForModuleOut(ctx, ctx.ModuleName()+"_"+split.suffix+".apk")
+		apks = append(apks, packageFile.String()+":"+
+			installDir.Join(ctx, a.installApkName+"_"+split.suffix+".apk").String())
 	}
+	for _, split := range a.abiSplits {
+		apks = append(apks, split.path.String()+":"+
+			installDir.Join(ctx, a.installApkName+"_"+split.suffix+".apk").String())
+	}
+
+	list := android.PathForModuleOut(ctx, "external_signing.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.WriteFile,
+		Description: "external signing list",
+		Output:      list,
+		Args: map[string]string{
+			"content": strings.Join(apks, "\\n"),
+		},
+	})
+	return list
+}
+
+// abiSplitBuildActions packages the JNI libraries of each ABI with the resources of a config split for that ABI
+// into a signed split APK.
+func (a *AndroidApp) abiSplitBuildActions(ctx android.ModuleContext, jniLibs []jniLib,
+	certificates []Certificate, deps android.Paths) {
+
+	var abis []string
+	jniLibsByAbi := make(map[string][]jniLib)
+	for _, lib := range jniLibs {
+		abi := lib.target.Arch.Abi[0]
+		if _, ok := jniLibsByAbi[abi]; !ok {
+			abis = append(abis, abi)
+		}
+		jniLibsByAbi[abi] = append(jniLibsByAbi[abi], lib)
+	}
+
+	frameworkRes := aaptFrameworkRes(ctx, sdkContext(a))
+
+	for _, abi := range abis {
+		// Split names can't contain '-', PackageManager matches config.arm64_v8a to the arm64-v8a ABI.
+		suffix := "config." + strings.Replace(abi, "-", "_", -1)
+
+		jniJarFile := android.PathForModuleOut(ctx, "abi_splits", abi, "jnilibs.zip")
+		TransformJniLibsToJar(ctx, jniJarFile, jniLibsByAbi[abi], a.useEmbeddedNativeLibs(ctx))
+
+		resPackageFile := android.PathForModuleOut(ctx, "abi_splits", abi, "package-res.apk")
+		BuildConfigSplitResources(ctx, resPackageFile, a.exportPackage, suffix, frameworkRes)
+
+		packageFile := android.PathForModuleOut(ctx, ctx.ModuleName()+"_"+suffix+".apk")
+		a.createAppPackage(ctx, packageFile, resPackageFile, jniJarFile, nil, certificates, deps)
+
+		a.abiSplits = append(a.abiSplits, split{name: suffix, suffix: suffix, path: packageFile})
+		a.extraOutputFiles = append(a.extraOutputFiles, packageFile)
+	}
+}
+
+// fsverityBuildActions generates the fs-verity metadata of the installed apks and installs it next to them.  The
+// metadata is generated from the installed files rather than the built ones, the apks of a PRESIGNED app are signed
+// outside the build.  The metadata of the dexpreopt files of the apk is added to the files installed by dexpreopt.
+// It returns the metadata of the apks, in the order of installedApks.
+func fsverityBuildActions(ctx android.ModuleContext, d *dexpreopter, installDir android.OutputPath,
+	installedApks android.Paths) android.Paths {
+
+	var apkMetadata android.Paths
+	for _, apk := range installedApks {
+		metadata := android.PathForModuleOut(ctx, "fsverity", apk.Base()+".fsv_meta")
+		GenerateFsverityMetadata(ctx, metadata, apk)
+		ctx.InstallFile(installDir, apk.Base()+".fsv_meta", metadata)
+		apkMetadata = append(apkMetadata, metadata)
+	}
+
+	var metadataInstalls android.RuleBuilderInstalls
+	for _, install := range d.builtInstalls {
+		metadata := android.PathForModuleOut(ctx, "fsverity", strings.TrimPrefix(install.To, "/")+".fsv_meta")
+		GenerateFsverityMetadata(ctx, metadata, install.From)
+		metadataInstalls = append(metadataInstalls, android.RuleBuilderInstall{
+			From: metadata,
+			To:   install.To + ".fsv_meta",
+		})
+	}
+	if len(metadataInstalls) > 0 {
+		d.builtInstalls = append(d.builtInstalls, metadataInstalls...)
+		d.builtInstalled = d.builtInstalls.String()
+	}
+
+	return apkMetadata
 }
 
 func collectAppDeps(ctx android.ModuleContext) ([]jniLib, []Certificate) {
@@ -461,9 +955,11 @@ func collectAppDeps(ctx android.ModuleContext) ([]jniLib, []Certificate) {
 				lib := dep.OutputFile()
 				if lib.Valid() {
 					jniLibs = append(jniLibs, jniLib{
-						name:   ctx.OtherModuleName(module),
-						path:   lib.Path(),
-						target: jniTag.target,
+						name:       ctx.OtherModuleName(module),
+						path:       lib.Path(),
+						target:     jniTag.target,
+						sharedLibs: dep.Properties.AndroidMkSharedLibs,
+						toc:        dep.Toc(),
 					})
 				} else {
 					ctx.ModuleErrorf("dependency %q missing output file", otherName)
@@ -501,13 +997,16 @@ func AndroidAppFactory() android.Module {
 	module.Module.properties.Instrument = true
 	module.Module.properties.Installable = proptools.BoolPtr(true)
 
+	module.appArchProperties.Arch = reflect.New(appAbiVariantsStruct).Interface()
 	module.AddProperties(
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
 		&module.aaptProperties,
 		&module.appProperties,
+		&module.appArchProperties,
 		&module.overridableAppProperties,
 		&module.usesLibrary.usesLibraryProperties)
 
@@ -523,7 +1022,71 @@ func AndroidAppFactory() android.Module {
 }
 
 type appTestProperties struct {
+	// the name of the android_app, java_library or java_sdk_library that the test instruments.  Its classes
+	// are on the classpath of the test, but are not linked into it.
 	Instrumentation_for *string
+
+	// if false, the test is not installed into the product's default test suites when test_suites is
+	// not set.  Defaults to true.
+	Use_default_test_suites *bool
+}
+
+// Runtime metadata for a test that is exported to the test infrastructure through module-info.json, so that
+// tests can be scheduled without parsing the test config.
+type TestOptions struct {
+	// the maximum time the test is expected to take, for example "10m" or "90s".
+	Timeout *string
+
+	// if set, the test is a unit test that does not depend on the state of the device.
+	Unit_test *bool
+
+	// a list of tags used by the test infrastructure to select or group tests.
+	Tags []string
+
+	// the number of shards the test can be split into.  The test config generated for the module passes it to
+	// the test runner as the shard count.
+	Shards *int64
+}
+
+type testOptionsProperties struct {
+	Test_options TestOptions
+}
+
+// validate reports errors for test_options values that can't be interpreted by the test infrastructure.
+func (o *TestOptions) validate(ctx android.ModuleContext) {
+	if o.Timeout != nil {
+		if d, err := time.ParseDuration(*o.Timeout); err != nil || d <= 0 {
+			ctx.PropertyErrorf("test_options.timeout", "must be a positive duration like \"10m\", got %q",
+				*o.Timeout)
+		}
+	}
+	if o.Shards != nil && *o.Shards < 1 {
+		ctx.PropertyErrorf("test_options.shards", "must be at least 1, got %d", *o.Shards)
+	}
+}
+
+// testInfo returns the TestInfo of a test with the options, through which they are listed in module-info.json.
+func (o *TestOptions) testInfo(config android.Path, suites []string) android.TestInfo {
+	info := android.TestInfo{
+		Config:   config,
+		Suites:   suites,
+		Timeout:  String(o.Timeout),
+		UnitTest: Bool(o.Unit_test),
+		Tags:     o.Tags,
+	}
+	if o.Shards != nil {
+		info.Shards = *o.Shards
+	}
+	return info
+}
+
+// tradefedConfigs returns the options that are added to an autogenerated test config.
+func (o *TestOptions) tradefedConfigs() []tradefed.Config {
+	var configs []tradefed.Config
+	if o.Shards != nil && *o.Shards > 1 {
+		configs = append(configs, tradefed.Option{"shard-count", strconv.FormatInt(*o.Shards, 10)})
+	}
+	return configs
 }
 
 type AndroidTest struct {
@@ -533,8 +1096,27 @@ type AndroidTest struct {
 
 	testProperties testProperties
 
+	testOptionsProperties testOptionsProperties
+
 	testConfig android.Path
 	data       android.Paths
+
+	// the test_suites of the module, or the product's default test suites if test_suites is not set
+	testSuites []string
+	// whether testSuites came from the product's default test suites
+	usesDefaultTestSuites bool
+}
+
+// setTestSuites uses the product's default test suites for tests that don't set test_suites, so that
+// new tests aren't silently left out of every suite.
+func (a *AndroidTest) setTestSuites(ctx android.ModuleContext) {
+	a.testSuites = a.testProperties.Test_suites
+	defaults := ctx.Config().DefaultTestSuites()
+	if len(a.testSuites) == 0 && len(defaults) > 0 &&
+		BoolDefault(a.appTestProperties.Use_default_test_suites, true) {
+		a.testSuites = defaults
+		a.usesDefaultTestSuites = true
+	}
 }
 
 func (a *AndroidTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
@@ -547,18 +1129,32 @@ func (a *AndroidTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	}
 	a.aapt.useEmbeddedNativeLibs = a.useEmbeddedNativeLibs(ctx)
 	a.aapt.useEmbeddedDex = Bool(a.appProperties.Use_embedded_dex)
+	a.aapt.versionNameBuildNumber = Bool(a.appProperties.Version_name_with_build_number)
 	a.generateAndroidBuildActions(ctx)
 
-	a.testConfig = tradefed.AutoGenInstrumentationTestConfig(ctx, a.testProperties.Test_config, a.testProperties.Test_config_template, a.manifestPath, a.testProperties.Test_suites)
+	a.setTestSuites(ctx)
+	a.testOptionsProperties.Test_options.validate(ctx)
+	a.testConfig = tradefed.AutoGenInstrumentationTestConfig(ctx, a.testProperties.Test_config, a.testProperties.Test_config_template,
+		a.manifestPath, a.testSuites, a.testOptionsProperties.Test_options.tradefedConfigs(),
+		a.testProperties.Auto_gen_config)
+	if testConfig := tradefed.HandwrittenTestConfig(ctx, a.testProperties.Test_config); testConfig != nil {
+		// Catch a handwritten test config that has drifted from the test apk at build time instead of
+		// when the test is run.
+		a.testConfig = tradefed.CheckInstrumentationTestConfig(ctx, testConfig, a.manifestPath,
+			a.installApkName+".apk")
+	}
 	a.data = android.PathsForModuleSrc(ctx, a.testProperties.Data)
+	checkTestData(ctx, a.data)
+	ctx.SetProvider(android.TestInfoProvider,
+		a.testOptionsProperties.Test_options.testInfo(a.testConfig, a.testSuites))
 }
 
 func (a *AndroidTest) DepsMutator(ctx android.BottomUpMutatorContext) {
 	a.AndroidApp.DepsMutator(ctx)
 	if a.appTestProperties.Instrumentation_for != nil {
-		// The android_app dependency listed in instrumentation_for needs to be added to the classpath for javac,
-		// but not added to the aapt2 link includes like a normal android_app or android_library dependency, so
-		// use instrumentationForTag instead of libTag.
+		// The android_app, java_library or java_sdk_library listed in instrumentation_for needs to be added to
+		// the classpath for javac, but not added to the aapt2 link includes like a normal android_app or
+		// android_library dependency, so use instrumentationForTag instead of libTag.
 		ctx.AddVariationDependencies(nil, instrumentationForTag, String(a.appTestProperties.Instrumentation_for))
 	}
 }
@@ -575,18 +1171,23 @@ func AndroidTestFactory() android.Module {
 	module.appProperties.Use_embedded_native_libs = proptools.BoolPtr(true)
 	module.appProperties.AlwaysPackageNativeLibs = true
 	module.Module.dexpreopter.isTest = true
+	module.Module.linter.test = true
 
+	module.appArchProperties.Arch = reflect.New(appAbiVariantsStruct).Interface()
 	module.AddProperties(
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
 		&module.aaptProperties,
 		&module.appProperties,
+		&module.appArchProperties,
 		&module.appTestProperties,
 		&module.overridableAppProperties,
 		&module.usesLibrary.usesLibraryProperties,
-		&module.testProperties)
+		&module.testProperties,
+		&module.testOptionsProperties)
 
 	android.InitAndroidMultiTargetsArchModule(module, android.DeviceSupported, android.MultilibCommon)
 	android.InitDefaultableModule(module)
@@ -597,12 +1198,62 @@ type appTestHelperAppProperties struct {
 	// list of compatibility suites (for example "cts", "vts") that the module should be
 	// installed into.
 	Test_suites []string `android:"arch_variant"`
+
+	// the name of the android_test that the helper app is installed with.  The helper app is signed
+	// with the certificate of the test, including a product certificate override of the test, so that
+	// the signatures of the two apps always match.  Can't be combined with certificate.  The helper
+	// app depends on the test to get its certificate, so the test can't list the helper app in its
+	// data, which would be a dependency cycle.  Put the helper app in the same test_suites as the test
+	// to install them together instead.
+	Certificate_from_test *string
+}
+
+var certificateFromTestTag = dependencyTag{name: "certificate-from-test"}
+
+// testCertificate is the certificate of an android_test, copied to the helper apps that are signed
+// with it.
+type testCertificate struct {
+	certificate Certificate
+	presigned   bool
 }
 
 type AndroidTestHelperApp struct {
 	AndroidApp
 
 	appTestHelperAppProperties appTestHelperAppProperties
+
+	testOptionsProperties testOptionsProperties
+}
+
+func (a *AndroidTestHelperApp) DepsMutator(ctx android.BottomUpMutatorContext) {
+	a.AndroidApp.DepsMutator(ctx)
+	if test := String(a.appTestHelperAppProperties.Certificate_from_test); test != "" {
+		if a.overridableAppProperties.Certificate != nil {
+			ctx.PropertyErrorf("certificate_from_test", "can't be combined with certificate")
+		}
+		ctx.AddVariationDependencies(nil, certificateFromTestTag, test)
+	}
+}
+
+func (a *AndroidTestHelperApp) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	// A product certificate override of the helper app itself still takes precedence.
+	if _, overridden := ctx.DeviceConfig().OverrideCertificateFor(ctx.ModuleName()); !overridden {
+		ctx.VisitDirectDepsWithTag(certificateFromTestTag, func(m android.Module) {
+			if test, ok := m.(*AndroidTest); ok {
+				a.certificateFromTest = &testCertificate{
+					certificate: test.certificate,
+					presigned:   test.presigned,
+				}
+			} else {
+				ctx.PropertyErrorf("certificate_from_test", "%q must be an android_test module",
+					ctx.OtherModuleName(m))
+			}
+		})
+	}
+	a.AndroidApp.GenerateAndroidBuildActions(ctx)
+	a.testOptionsProperties.Test_options.validate(ctx)
+	ctx.SetProvider(android.TestInfoProvider,
+		a.testOptionsProperties.Test_options.testInfo(nil, a.appTestHelperAppProperties.Test_suites))
 }
 
 // android_test_helper_app compiles sources and Android resources into an Android application package `.apk` file that
@@ -617,17 +1268,22 @@ func AndroidTestHelperAppFactory() android.Module {
 	module.appProperties.Use_embedded_native_libs = proptools.BoolPtr(true)
 	module.appProperties.AlwaysPackageNativeLibs = true
 	module.Module.dexpreopter.isTest = true
+	module.Module.linter.test = true
 
+	module.appArchProperties.Arch = reflect.New(appAbiVariantsStruct).Interface()
 	module.AddProperties(
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
 		&module.aaptProperties,
 		&module.appProperties,
+		&module.appArchProperties,
 		&module.appTestHelperAppProperties,
 		&module.overridableAppProperties,
-		&module.usesLibrary.usesLibraryProperties)
+		&module.usesLibrary.usesLibraryProperties,
+		&module.testOptionsProperties)
 
 	android.InitAndroidMultiTargetsArchModule(module, android.DeviceSupported, android.MultilibCommon)
 	android.InitDefaultableModule(module)
@@ -688,11 +1344,27 @@ type AndroidAppImport struct {
 	android.DefaultableModuleBase
 	prebuilt android.Prebuilt
 
-	properties AndroidAppImportProperties
+	properties     AndroidAppImportProperties
+	archProperties androidAppImportArchProperties
 
 	outputFile  android.Path
 	certificate *Certificate
 
+	// the name of the installed apk, which may be changed by PRODUCT_PACKAGE_NAME_OVERRIDES
+	installApkName string
+
+	// signed or aligned config split apks that are installed together with the base apk
+	splits []split
+
+	// the minimum SDK version to verify presigned apks against, or 0 to use the one in their manifest
+	presignedMinSdkVersion int
+
+	// the fs-verity metadata of the installed base and split apks when fsverity_metadata is set
+	fsverityMetadata android.Paths
+
+	// how the installed apk was built, for the apk_provenance singleton
+	provenance *apkProvenance
+
 	dexpreopter
 
 	usesLibrary usesLibrary
@@ -727,9 +1399,15 @@ type AndroidAppImportProperties struct {
 	Certificate *string
 
 	// Set this flag to true if the prebuilt apk is already signed. The certificate property must not
-	// be set for presigned modules.
+	// be set for presigned modules.  The signature of a presigned apk is verified with apksigner at
+	// build time.
 	Presigned *bool
 
+	// The minimum SDK version of the prebuilt apk, used to verify the signature of a presigned apk.
+	// Presigned apks with a min_sdk_version of 30 or higher must be signed with APK signature scheme
+	// v2 or later.  Defaults to the minSdkVersion in the manifest of the apk.
+	Min_sdk_version *string
+
 	// Specifies that this app should be installed to the priv-app directory,
 	// where the system will grant it additional privileges not available to
 	// normal apps.
@@ -741,35 +1419,82 @@ type AndroidAppImportProperties struct {
 	// binaries would be installed by default (in PRODUCT_PACKAGES) the other binary will be removed
 	// from PRODUCT_PACKAGES.
 	Overrides []string
+
+	// If true, generate the fs-verity metadata of the installed apk and of its dexpreopt files, and install it next
+	// to them with a .fsv_meta suffix.
+	Fsverity_metadata *bool
+
+	// List of config split apks, for example density or ABI specific splits, that are installed together
+	// with the base apk.  They are signed with the same certificate as the base apk, or zip aligned if it
+	// is presigned.  Config splits have no code, only the base apk is dexpreopted.  Each split is installed as
+	// <name>_<split file name without .apk>.apk.
+	Split_apks []string `android:"path"`
+
+	// If true, the <uses-library> tags of the prebuilt apk are read from its manifest at build time instead of
+	// being checked against uses_libs and optional_uses_libs, and only the libraries it uses are passed to
+	// dexpreopt.  uses_libs and optional_uses_libs list the libraries the apk may use, a required library of
+	// the manifest that isn't listed in either of them fails the build.
+	Extract_uses_libs *bool
 }
 
-func getApkPathForDpi(dpiVariantsValue reflect.Value, dpi string) string {
-	dpiField := dpiVariantsValue.FieldByName(proptools.FieldNameForProperty(dpi))
-	if !dpiField.IsValid() {
+type androidAppImportArchProperties struct {
+	// Per-architecture settings. This property makes it possible to specify a different source apk
+	// path for each primary device architecture, for example for prebuilt apps that embed native
+	// libraries for a single ABI.  A matching dpi_variants entry takes precedence.
+	//
+	// Example:
+	//
+	//     android_app_import {
+	//         name: "example_import",
+	//         apk: "prebuilts/example.apk",
+	//         arch: {
+	//             arm64: {
+	//                 apk: "prebuilts/example_arm64.apk",
+	//             },
+	//             x86_64: {
+	//                 apk: "prebuilts/example_x86_64.apk",
+	//             },
+	//         },
+	//         certificate: "PRESIGNED",
+	//     }
+	Arch interface{}
+}
+
+func getApkPathForVariant(variantsValue reflect.Value, variant string) string {
+	variantField := variantsValue.FieldByName(proptools.FieldNameForProperty(variant))
+	if !variantField.IsValid() {
 		return ""
 	}
-	apkValue := dpiField.FieldByName("Apk").Elem()
+	apkValue := variantField.FieldByName("Apk").Elem()
 	if apkValue.IsValid() {
 		return apkValue.String()
 	}
 	return ""
 }
 
-// Chooses a source APK path to use based on the module's per-DPI settings and the product config.
+// Chooses a source APK path to use based on the module's per-DPI and per-architecture settings and
+// the product config.
 func (a *AndroidAppImport) getSrcApkPath(ctx android.ModuleContext) string {
 	config := ctx.Config()
 	dpiVariantsValue := reflect.ValueOf(a.properties.Dpi_variants).Elem()
-	if !dpiVariantsValue.IsValid() {
-		return a.properties.Apk
-	}
-	// Match PRODUCT_AAPT_PREF_CONFIG first and then PRODUCT_AAPT_PREBUILT_DPI.
-	if config.ProductAAPTPreferredConfig() != "" {
-		if apk := getApkPathForDpi(dpiVariantsValue, config.ProductAAPTPreferredConfig()); apk != "" {
-			return apk
+	if dpiVariantsValue.IsValid() {
+		// Match PRODUCT_AAPT_PREF_CONFIG first and then PRODUCT_AAPT_PREBUILT_DPI.
+		if config.ProductAAPTPreferredConfig() != "" {
+			if apk := getApkPathForVariant(dpiVariantsValue, config.ProductAAPTPreferredConfig()); apk != "" {
+				return apk
+			}
+		}
+		for _, dpi := range config.ProductAAPTPrebuiltDPI() {
+			if apk := getApkPathForVariant(dpiVariantsValue, dpi); apk != "" {
+				return apk
+			}
 		}
 	}
-	for _, dpi := range config.ProductAAPTPrebuiltDPI() {
-		if apk := getApkPathForDpi(dpiVariantsValue, dpi); apk != "" {
+
+	// Match the primary device architecture.
+	archVariantsValue := reflect.ValueOf(a.archProperties.Arch).Elem()
+	if archVariantsValue.IsValid() {
+		if apk := getApkPathForVariant(archVariantsValue, config.DevicePrimaryArchType().Name); apk != "" {
 			return apk
 		}
 	}
@@ -778,26 +1503,56 @@ func (a *AndroidAppImport) getSrcApkPath(ctx android.ModuleContext) string {
 	return a.properties.Apk
 }
 
+// Returns the certificate to sign the app with, which may be changed by PRODUCT_CERTIFICATE_OVERRIDES.
+func (a *AndroidAppImport) getCertString(ctx android.BaseModuleContext) string {
+	certificate, overridden := ctx.DeviceConfig().OverrideCertificateFor(a.BaseModuleName())
+	if overridden {
+		return ":" + certificate
+	}
+	return String(a.properties.Certificate)
+}
+
 func (a *AndroidAppImport) DepsMutator(ctx android.BottomUpMutatorContext) {
-	cert := android.SrcIsModule(String(a.properties.Certificate))
+	cert := android.SrcIsModule(a.getCertString(ctx))
 	if cert != "" {
 		ctx.AddDependency(ctx.Module(), certificateTag, cert)
 	}
 
+	// With extract_uses_libs the <uses-library> tags are only known once the apk has been read, uses_libs and
+	// optional_uses_libs are then the libraries that can be passed to dexpreopt.
 	a.usesLibrary.deps(ctx, true)
 }
 
-func (a *AndroidAppImport) uncompressEmbeddedJniLibs(
-	ctx android.ModuleContext, inputPath android.Path, outputPath android.OutputPath) {
-	rule := android.NewRuleBuilder()
-	rule.Command().
-		Textf(`if (zipinfo %s 'lib/*.so' 2>/dev/null | grep -v ' stor ' >/dev/null) ; then`, inputPath).
-		Tool(ctx.Config().HostToolPath(ctx, "zip2zip")).
-		FlagWithInput("-i ", inputPath).
-		FlagWithOutput("-o ", outputPath).
-		FlagWithArg("-0 ", "'lib/**/*.so'").
-		Textf(`; else cp -f %s %s; fi`, inputPath, outputPath)
-	rule.Build(pctx, ctx, "uncompress-embedded-jni-libs", "Uncompress embedded JIN libs")
+// prebuiltApkAbis are the ABIs of the native libraries that can be embedded in a prebuilt apk.
+var prebuiltApkAbis = []string{"armeabi", "armeabi-v7a", "arm64-v8a", "x86", "x86_64", "mips", "mips64"}
+
+// unsupportedApkAbis returns the ABIs of prebuiltApkAbis that none of the device targets can run, whose
+// native libraries are removed from prebuilt apks.
+func unsupportedApkAbis(config android.Config) []string {
+	targets := config.Targets[android.Android]
+	if len(targets) == 0 {
+		return nil
+	}
+	var supported []string
+	for _, target := range targets {
+		if len(target.Arch.Abi) == 0 {
+			// Without the ABIs of every target it isn't safe to remove any of them.
+			return nil
+		}
+		supported = append(supported, target.Arch.Abi...)
+	}
+	// armeabi-v7a devices also run the libraries built for the older armeabi.
+	if android.InList("armeabi-v7a", supported) {
+		supported = append(supported, "armeabi")
+	}
+
+	var unsupported []string
+	for _, abi := range prebuiltApkAbis {
+		if !android.InList(abi, supported) {
+			unsupported = append(unsupported, abi)
+		}
+	}
+	return unsupported
 }
 
 // Returns whether this module should have the dex file stored uncompressed in the APK.
@@ -814,91 +1569,196 @@ func (a *AndroidAppImport) shouldUncompressDex(ctx android.ModuleContext) bool {
 	return shouldUncompressDex(ctx, &a.dexpreopter)
 }
 
-func (a *AndroidAppImport) uncompressDex(
-	ctx android.ModuleContext, inputPath android.Path, outputPath android.OutputPath) {
-	rule := android.NewRuleBuilder()
-	rule.Command().
-		Textf(`if (zipinfo %s '*.dex' 2>/dev/null | grep -v ' stor ' >/dev/null) ; then`, inputPath).
-		Tool(ctx.Config().HostToolPath(ctx, "zip2zip")).
-		FlagWithInput("-i ", inputPath).
-		FlagWithOutput("-o ", outputPath).
-		FlagWithArg("-0 ", "'classes*.dex'").
-		Textf(`; else cp -f %s %s; fi`, inputPath, outputPath)
-	rule.Build(pctx, ctx, "uncompress-dex", "Uncompress dex files")
-}
-
 func (a *AndroidAppImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	if String(a.properties.Certificate) == "" && !Bool(a.properties.Presigned) {
-		ctx.PropertyErrorf("certificate", "No certificate specified for prebuilt")
-	}
+	ctx.SetProvider(android.MakeClassProvider, "APPS")
 	if String(a.properties.Certificate) != "" && Bool(a.properties.Presigned) {
 		ctx.PropertyErrorf("certificate", "Certificate can't be specified for presigned modules")
 	}
 
+	// A product certificate override re-signs the app even if it was presigned.
+	certString := a.getCertString(ctx)
+	presigned := Bool(a.properties.Presigned) && certString == String(a.properties.Certificate)
+	if certString == "" && !presigned {
+		ctx.PropertyErrorf("certificate", "No certificate specified for prebuilt")
+	}
+
+	// Check if the install APK name needs to be overridden.
+	a.installApkName = ctx.DeviceConfig().OverridePackageNameFor(a.BaseModuleName())
+
 	_, certificates := collectAppDeps(ctx)
 
 	// TODO: LOCAL_EXTRACT_APK/LOCAL_EXTRACT_DPI_APK
-	// TODO: LOCAL_PACKAGE_SPLITS
 
 	var srcApk android.Path
 	srcApk = android.PathForModuleSrc(ctx, a.getSrcApkPath(ctx))
 
-	if a.usesLibrary.enforceUsesLibraries() {
+	if presigned {
+		if v := String(a.properties.Min_sdk_version); v != "" {
+			minSdkVersion, err := sdkVersionToNumber(ctx, v)
+			if err != nil {
+				ctx.PropertyErrorf("min_sdk_version", "%s", err)
+			}
+			a.presignedMinSdkVersion = minSdkVersion
+		}
+		srcApk = a.verifyPresigned(ctx, srcApk, ctx.ModuleName()+".apk")
+	} else if a.properties.Min_sdk_version != nil {
+		ctx.PropertyErrorf("min_sdk_version", "min_sdk_version can only be specified for presigned modules")
+	}
+
+	extractUsesLibs := Bool(a.properties.Extract_uses_libs)
+	classLoaderContexts := a.usesLibrary.classLoaderContexts(ctx, extractUsesLibs)
+
+	var usesLibsFile android.Path
+	if extractUsesLibs {
+		if !ctx.Config().UnbundledBuild() {
+			usesLibsFile = a.usesLibrary.extractUsesLibrariesAPK(ctx, srcApk, classLoaderContexts.UsesLibs())
+		}
+	} else if a.usesLibrary.enforceUsesLibraries() {
 		srcApk = a.usesLibrary.verifyUsesLibrariesAPK(ctx, srcApk)
 	}
 
 	// TODO: Install or embed JNI libraries
 
-	// Uncompress JNI libraries in the apk
-	jnisUncompressed := android.PathForModuleOut(ctx, "jnis-uncompressed", ctx.ModuleName()+".apk")
-	a.uncompressEmbeddedJniLibs(ctx, srcApk, jnisUncompressed.OutputPath)
-
-	installDir := android.PathForModuleInstall(ctx, "app", a.BaseModuleName())
-	a.dexpreopter.installPath = installDir.Join(ctx, a.BaseModuleName()+".apk")
+	installDir := android.PathForModuleInstall(ctx, "app", a.installApkName)
+	a.dexpreopter.installPath = installDir.Join(ctx, a.installApkName+".apk")
 	a.dexpreopter.isInstallable = true
-	a.dexpreopter.isPresignedPrebuilt = Bool(a.properties.Presigned)
+	a.dexpreopter.isPresignedPrebuilt = presigned
 	a.dexpreopter.uncompressedDex = a.shouldUncompressDex(ctx)
 
-	a.dexpreopter.enforceUsesLibs = a.usesLibrary.enforceUsesLibraries()
-	a.dexpreopter.usesLibs = a.usesLibrary.usesLibraryProperties.Uses_libs
-	a.dexpreopter.optionalUsesLibs = a.usesLibrary.presentOptionalUsesLibs(ctx)
-	a.dexpreopter.libraryPaths = a.usesLibrary.usesLibraryPaths(ctx)
+	a.dexpreopter.enforceUsesLibs = a.usesLibrary.enforceUsesLibraries() || usesLibsFile != nil
+	a.dexpreopter.usesLibsFile = usesLibsFile
+	a.dexpreopter.classLoaderContexts = classLoaderContexts
+
+	stripDex := a.dexpreopter.dexpreoptApk(ctx, srcApk)
 
-	dexOutput := a.dexpreopter.dexpreopt(ctx, jnisUncompressed)
-	if a.dexpreopter.uncompressedDex {
-		dexUncompressed := android.PathForModuleOut(ctx, "dex-uncompressed", ctx.ModuleName()+".apk")
-		a.uncompressDex(ctx, dexOutput, dexUncompressed.OutputPath)
-		dexOutput = dexUncompressed
+	// Uncompress the JNI libraries, strip or uncompress the dex files and remove the native libraries of
+	// the ABIs the device can't run in a single pass.  Presigned apps are aligned in the same pass, and
+	// keep the libraries of all ABIs since removing them would invalidate the signature.
+	processed := android.PathForModuleOut(ctx, "processed", ctx.ModuleName()+".apk")
+	var excludeAbis []string
+	if !presigned {
+		excludeAbis = unsupportedApkAbis(ctx.Config())
 	}
+	ProcessPrebuiltApk(ctx, processed, srcApk, excludeAbis, stripDex, a.dexpreopter.uncompressedDex, presigned)
 
-	// Sign or align the package
+	// Sign the package, presigned packages are used as processed
 	// TODO: Handle EXTERNAL
-	if !Bool(a.properties.Presigned) {
-		certificates = processMainCert(a.ModuleBase, *a.properties.Certificate, certificates, ctx)
+	if !presigned {
+		certificates = processMainCert(a.ModuleBase, certString, certificates, ctx)
 		if len(certificates) != 1 {
 			ctx.ModuleErrorf("Unexpected number of certificates were extracted: %q", certificates)
 		}
 		a.certificate = &certificates[0]
 		signed := android.PathForModuleOut(ctx, "signed", ctx.ModuleName()+".apk")
-		SignAppPackage(ctx, signed, dexOutput, certificates)
+		SignAppPackage(ctx, signed, processed, certificates)
 		a.outputFile = signed
 	} else {
-		alignedApk := android.PathForModuleOut(ctx, "zip-aligned", ctx.ModuleName()+".apk")
-		TransformZipAlign(ctx, alignedApk, dexOutput)
-		a.outputFile = alignedApk
+		a.outputFile = processed
 	}
 
+	a.splitBuildActions(ctx, installDir, presigned, certificates)
+
 	// TODO: Optionally compress the output apk.
 
-	ctx.InstallFile(installDir, a.BaseModuleName()+".apk", a.outputFile)
+	installedApks := android.Paths{ctx.InstallFile(installDir, a.installApkName+".apk", a.outputFile)}
+	a.provenance = &apkProvenance{
+		apk:         a.outputFile,
+		installPath: installDir.Join(ctx, a.installApkName+".apk"),
+		inputs:      android.Paths{android.PathForModuleSrc(ctx, a.getSrcApkPath(ctx))},
+	}
+	if !presigned {
+		a.provenance.certificates = certificates
+	}
+	for _, split := range a.splits {
+		installedApks = append(installedApks,
+			ctx.InstallFile(installDir, a.installApkName+"_"+split.suffix+".apk", split.path))
+	}
+	if Bool(a.properties.Fsverity_metadata) {
+		a.fsverityMetadata = fsverityBuildActions(ctx, &a.dexpreopter, installDir, installedApks)
+	}
+	a.dexpreopter.installBuiltFiles(ctx)
 
 	// TODO: androidmk converter jni libs
 }
 
+// splitBuildActions processes the split apks the same way as the base apk.
+func (a *AndroidAppImport) splitBuildActions(ctx android.ModuleContext, installDir android.OutputPath,
+	presigned bool, certificates []Certificate) {
+
+	// Resolve the entries one at a time so that errors can point at the entry that caused them.
+	for i, entry := range a.properties.Split_apks {
+		property := android.IndexedProperty("split_apks", i)
+		for _, splitApk := range android.PathsForModuleSrc(ctx, []string{entry}) {
+			a.splitBuildAction(ctx, property, installDir, splitApk, presigned, certificates)
+		}
+	}
+}
+
+func (a *AndroidAppImport) splitBuildAction(ctx android.ModuleContext, property string,
+	installDir android.OutputPath, splitApk android.Path, presigned bool, certificates []Certificate) {
+
+	if splitApk.Ext() != ".apk" {
+		ctx.PropertyErrorf(property, "split %q must be an .apk file", splitApk)
+		return
+	}
+	suffix := strings.TrimSuffix(splitApk.Base(), ".apk")
+	for _, s := range a.splits {
+		if s.suffix == suffix {
+			ctx.PropertyErrorf(property, "duplicate split %q", splitApk.Base())
+		}
+	}
+	name := ctx.ModuleName() + "_" + suffix + ".apk"
+
+	if presigned {
+		splitApk = a.verifyPresigned(ctx, splitApk, name)
+	}
+
+	processed := android.PathForModuleOut(ctx, "processed", name)
+	var excludeAbis []string
+	if !presigned {
+		excludeAbis = unsupportedApkAbis(ctx.Config())
+	}
+	// Config splits have no code, they are not dexpreopted and have no dex files to strip or uncompress.  The
+	// code of the app is dexpreopted from the base apk.
+	ProcessPrebuiltApk(ctx, processed, splitApk, excludeAbis, false, false, presigned)
+
+	var output android.WritablePath = processed
+	if !presigned {
+		output = android.PathForModuleOut(ctx, "signed", name)
+		SignAppPackage(ctx, output, processed, certificates)
+	}
+
+	a.splits = append(a.splits, split{
+		name:   suffix,
+		suffix: suffix,
+		path:   output,
+	})
+}
+
+// verifyPresigned checks the signature of a presigned apk before it is processed any further.  It returns the path
+// to a copy of the apk.
+func (a *AndroidAppImport) verifyPresigned(ctx android.ModuleContext, apk android.Path, name string) android.Path {
+	verified := android.PathForModuleOut(ctx, "verify_presigned", name)
+	VerifyPresignedApk(ctx, verified, apk, a.presignedMinSdkVersion)
+	return verified
+}
+
+func (a *AndroidAppImport) ModuleMetadata(metadata *android.ModuleMetadata) {
+	if a.certificate != nil {
+		metadata.Certificate = strings.TrimSuffix(a.certificate.Pem.String(), ".x509.pem")
+	} else {
+		metadata.Certificate = "PRESIGNED"
+	}
+}
+
 func (a *AndroidAppImport) Prebuilt() *android.Prebuilt {
 	return &a.prebuilt
 }
 
+func (a *AndroidAppImport) apkProvenance() *apkProvenance {
+	return a.provenance
+}
+
 func (a *AndroidAppImport) Name() string {
 	return a.prebuilt.Name(a.ModuleBase.Name())
 }
@@ -907,7 +1767,9 @@ func (a *AndroidAppImport) Name() string {
 func AndroidAppImportFactory() android.Module {
 	module := &AndroidAppImport{}
 	module.properties.Dpi_variants = reflect.New(dpiVariantsStruct).Interface()
+	module.archProperties.Arch = reflect.New(archVariantsStruct).Interface()
 	module.AddProperties(&module.properties)
+	module.AddProperties(&module.archProperties)
 	module.AddProperties(&module.dexpreoptProperties)
 	module.AddProperties(&module.usesLibrary.usesLibraryProperties)
 
@@ -946,13 +1808,10 @@ func (u *usesLibrary) deps(ctx android.BottomUpMutatorContext, hasFrameworkLibs
 		// creating a cyclic dependency:
 		//     e.g. framework-res -> org.apache.http.legacy -> ... -> framework-res.
 		if hasFrameworkLibs {
-			// dexpreopt/dexpreopt.go needs the paths to the dex jars of these libraries in case construct_context.sh needs
-			// to pass them to dex2oat.  Add them as a dependency so we can determine the path to the dex jar of each
+			// The dex jars of these libraries are in the class loader context of apps with an old targetSdkVersion,
+			// see compatUsesLibs.  Add them as a dependency so we can determine the path to the dex jar of each
 			// library to dexpreopt.
-			ctx.AddVariationDependencies(nil, usesLibTag,
-				"org.apache.http.legacy",
-				"android.hidl.base-V1.0-java",
-				"android.hidl.manager-V1.0-java")
+			ctx.AddVariationDependencies(nil, usesLibTag, android.SortedStringKeys(compatUsesLibs)...)
 		}
 	}
 }
@@ -964,29 +1823,50 @@ func (u *usesLibrary) presentOptionalUsesLibs(ctx android.BaseModuleContext) []s
 	return optionalUsesLibs
 }
 
-// usesLibraryPaths returns a map of module names of shared library dependencies to the paths to their dex jars.
-func (u *usesLibrary) usesLibraryPaths(ctx android.ModuleContext) map[string]android.Path {
-	usesLibPaths := make(map[string]android.Path)
+// compatUsesLibs are libraries whose classes were in the default classpath of apps with a targetSdkVersion lower than
+// sdkVer.  They are added to the class loader of those apps even without a <uses-library> tag.  location is the path
+// of the library on the device if it differs from its install location.
+var compatUsesLibs = map[string]struct {
+	sdkVer   int
+	location string
+}{
+	"org.apache.http.legacy":         {28, "/system/framework/org.apache.http.legacy.impl.jar"},
+	"android.hidl.base-V1.0-java":    {29, ""},
+	"android.hidl.manager-V1.0-java": {29, ""},
+}
+
+// classLoaderContexts returns the class loader contexts of the uses_libs and optional_uses_libs dependencies, and of
+// the compatibility libraries for apps with an old targetSdkVersion.  If allLibs is true every dependency is a
+// possible shared library, for apps whose <uses-library> tags are only known at build time.
+func (u *usesLibrary) classLoaderContexts(ctx android.ModuleContext, allLibs bool) dexpreopt.ClassLoaderContextMap {
+	clcMap := make(dexpreopt.ClassLoaderContextMap)
 
 	if !ctx.Config().UnbundledBuild() {
+		usesLibs := append(android.CopyOf(u.usesLibraryProperties.Uses_libs), u.presentOptionalUsesLibs(ctx)...)
+
 		ctx.VisitDirectDepsWithTag(usesLibTag, func(m android.Module) {
-			if lib, ok := m.(Dependency); ok {
-				if dexJar := lib.DexJar(); dexJar != nil {
-					usesLibPaths[ctx.OtherModuleName(m)] = dexJar
-				} else {
+			lib := ctx.OtherModuleName(m)
+			if dep, ok := m.(UsesLibraryDependency); ok {
+				if dep.DexJar() == nil {
 					ctx.ModuleErrorf("module %q in uses_libs or optional_uses_libs must produce a dex jar, does it have installable: true?",
-						ctx.OtherModuleName(m))
+						lib)
+					return
+				}
+				if allLibs || android.InList(lib, usesLibs) {
+					addClassLoaderContext(ctx, clcMap, dexpreopt.AnySdkVersion, lib, "", m)
+				}
+				if compat, ok := compatUsesLibs[lib]; ok && !android.InList(lib, usesLibs) {
+					addClassLoaderContext(ctx, clcMap, compat.sdkVer, lib, compat.location, m)
 				}
 			} else if ctx.Config().AllowMissingDependencies() {
-				ctx.AddMissingDependencies([]string{ctx.OtherModuleName(m)})
+				ctx.AddMissingDependencies([]string{lib})
 			} else {
-				ctx.ModuleErrorf("module %q in uses_libs or optional_uses_libs must be a java library",
-					ctx.OtherModuleName(m))
+				ctx.ModuleErrorf("module %q in uses_libs or optional_uses_libs must be a java library", lib)
 			}
 		})
 	}
 
-	return usesLibPaths
+	return clcMap
 }
 
 // enforceUsesLibraries returns true of <uses-library> tags should be checked against uses_libs and optional_uses_libs
@@ -1028,9 +1908,9 @@ func (u *usesLibrary) verifyUsesLibrariesAPK(ctx android.ModuleContext, apk andr
 	outputFile := android.PathForModuleOut(ctx, "verify_uses_libraries", apk.Base())
 
 	rule := android.NewRuleBuilder()
-	aapt := ctx.Config().HostToolPath(ctx, "aapt")
+	aapt := ctx.Config().HostNativeToolPath(ctx, "aapt")
 	rule.Command().
-		Textf("aapt_binary=%s", aapt.String()).Implicit(aapt).
+		FlagWithTool("aapt_binary=", aapt).
 		Textf(`uses_library_names="%s"`, strings.Join(u.usesLibraryProperties.Uses_libs, " ")).
 		Textf(`optional_uses_library_names="%s"`, strings.Join(u.usesLibraryProperties.Optional_uses_libs, " ")).
 		Tool(android.PathForSource(ctx, "build/make/core/verify_uses_libraries.sh")).Input(apk)
@@ -1040,3 +1920,26 @@ func (u *usesLibrary) verifyUsesLibrariesAPK(ctx android.ModuleContext, apk andr
 
 	return outputFile
 }
+
+// extractUsesLibrariesAPK reads the <uses-library> tags from the manifest of an APK and writes the ones that
+// dexpreopt can provide to a file, one per line.  It fails the build if the APK requires a library that is not
+// in knownLibs.  It returns the path to the file.
+func (u *usesLibrary) extractUsesLibrariesAPK(ctx android.ModuleContext, apk android.Path,
+	knownLibs []string) android.Path {
+	outputFile := android.PathForModuleOut(ctx, "extract_uses_libraries", "uses_libraries.txt")
+
+	rule := android.NewRuleBuilder()
+	cmd := rule.Command().Tool(ctx.Config().HostToolPath(ctx, "manifest_check")).
+		FlagWithTool("--aapt ", ctx.Config().HostNativeToolPath(ctx, "aapt2")).
+		FlagWithOutput("--extract-uses-libraries ", outputFile)
+
+	for _, lib := range knownLibs {
+		cmd.FlagWithArg("--known-uses-library ", lib)
+	}
+
+	cmd.Input(apk)
+
+	rule.Build(pctx, ctx, "extract_uses_libraries", "extract <uses-library>")
+
+	return outputFile
+}
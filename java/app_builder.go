@@ -20,6 +20,7 @@ package java
 
 import (
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/blueprint"
@@ -49,10 +50,15 @@ var (
 
 func init() {
 	pctx.SourcePathVariable("androidManifestMergerCmd", "prebuilts/devtools/tools/lib/manifest-merger.jar")
-	pctx.HostBinToolVariable("aaptCmd", "aapt")
+	pctx.HostNativeBinToolVariable("aaptCmd", "aapt")
+	pctx.HostBinToolVariable("apksignerCmd", "apksigner")
+	pctx.HostBinToolVariable("configSplitManifestCmd", "config_split_manifest")
+	pctx.HostNativeBinToolVariable("fsverityCmd", "fsverity")
+	pctx.HostNativeBinToolVariable("fsverityMetadataGeneratorCmd", "fsverity_metadata_generator")
 	pctx.HostJavaToolVariable("signapkCmd", "signapk.jar")
 	// TODO(ccross): this should come from the signapk dependencies, but we don't have any way
-	// to express host JNI dependencies yet.
+	// to express host JNI dependencies yet.  signapk runs in the glibc host JVM, so the library is
+	// the glibc one even when the native host tools are the linux_bionic arm64 ones.
 	pctx.HostJNIToolVariable("signapkJniLibrary", "libconscrypt_openjdk_jni")
 }
 
@@ -65,6 +71,23 @@ var combineApk = pctx.AndroidStaticRule("combineApk",
 func CreateAndSignAppPackage(ctx android.ModuleContext, outputFile android.WritablePath,
 	packageFile, jniJarFile, dexJarFile android.Path, certificates []Certificate, deps android.Paths) {
 
+	unsignedApk := createUnsignedAppPackage(ctx, outputFile, packageFile, jniJarFile, dexJarFile, deps)
+
+	SignAppPackage(ctx, outputFile, unsignedApk, certificates)
+}
+
+// CreateAndAlignAppPackage builds a zip-aligned but unsigned app package, for apps that are signed outside the build.
+func CreateAndAlignAppPackage(ctx android.ModuleContext, outputFile android.WritablePath,
+	packageFile, jniJarFile, dexJarFile android.Path, deps android.Paths) {
+
+	unsignedApk := createUnsignedAppPackage(ctx, outputFile, packageFile, jniJarFile, dexJarFile, deps)
+
+	TransformZipAlign(ctx, outputFile, unsignedApk)
+}
+
+func createUnsignedAppPackage(ctx android.ModuleContext, outputFile android.WritablePath,
+	packageFile, jniJarFile, dexJarFile android.Path, deps android.Paths) android.Path {
+
 	unsignedApkName := strings.TrimSuffix(outputFile.Base(), ".apk") + "-unsigned.apk"
 	unsignedApk := android.PathForModuleOut(ctx, unsignedApkName)
 
@@ -84,7 +107,7 @@ func CreateAndSignAppPackage(ctx android.ModuleContext, outputFile android.Writa
 		Implicits: deps,
 	})
 
-	SignAppPackage(ctx, outputFile, unsignedApk, certificates)
+	return unsignedApk
 }
 
 func SignAppPackage(ctx android.ModuleContext, signedApk android.WritablePath, unsignedApk android.Path, certificates []Certificate) {
@@ -239,6 +262,241 @@ func TransformJniLibsToJar(ctx android.ModuleContext, outputFile android.Writabl
 	})
 }
 
+var checkJniSymbols = pctx.AndroidStaticRule("checkJniSymbols",
+	blueprint.RuleParams{
+		// Undefined symbols are skipped, and symbols versioned by a version_script end in @VERSION.
+		Command: `rm -f $out && ` +
+			`if ! grep -v " UND " $in | grep -qE " (JNI_OnLoad|Java_[^ @]+)(@[^ ]*)?$$"; then ` +
+			`echo "error: JNI library $lib exports neither JNI_OnLoad nor any Java_ native method," ` +
+			`"check that its version_script and stripping keep them" >&2; exit 1; fi && ` +
+			`touch $out`,
+	},
+	"lib")
+
+// CheckJniSymbols verifies that each JNI library exports JNI_OnLoad or at least one Java_ native method, without
+// which the app can't call into it.  It returns stamp files to add as dependencies of the app package.
+func CheckJniSymbols(ctx android.ModuleContext, jniLibs []jniLib) android.Paths {
+	var stamps android.Paths
+	for _, lib := range jniLibs {
+		if !lib.toc.Valid() {
+			continue
+		}
+		stamp := android.PathForModuleOut(ctx, "jni_symbol_check", targetToJniDir(lib.target),
+			lib.path.Base()+".stamp")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        checkJniSymbols,
+			Description: "check jni symbols " + lib.path.Base(),
+			Input:       lib.toc.Path(),
+			Output:      stamp,
+			Args: map[string]string{
+				"lib": lib.path.Base(),
+			},
+		})
+		stamps = append(stamps, stamp)
+	}
+	return stamps
+}
+
+var configSplitResources = pctx.AndroidStaticRule("configSplitResources",
+	blueprint.RuleParams{
+		Command: `rm -f $out $out.AndroidManifest.xml && ` +
+			`${configSplitManifestCmd} --aapt ${config.Aapt2Cmd} --split $split $in $out.AndroidManifest.xml && ` +
+			`${config.Aapt2Cmd} link -o $out --manifest $out.AndroidManifest.xml $flags && ` +
+			`rm -f $out.AndroidManifest.xml`,
+		CommandDeps: []string{"${configSplitManifestCmd}", "${config.Aapt2Cmd}"},
+	},
+	"split", "flags")
+
+// BuildConfigSplitResources links the resource package of a config split without code of the app whose resource
+// package is basePackageFile, using the package name and version code from its manifest.
+func BuildConfigSplitResources(ctx android.ModuleContext, outputFile android.WritablePath,
+	basePackageFile android.Path, split string, frameworkRes android.Paths) {
+
+	var flags []string
+	for _, res := range frameworkRes {
+		flags = append(flags, "-I "+res.String())
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        configSplitResources,
+		Description: "config split resources " + split,
+		Input:       basePackageFile,
+		Implicits:   frameworkRes,
+		Output:      outputFile,
+		Args: map[string]string{
+			"split": split,
+			"flags": strings.Join(flags, " "),
+		},
+	})
+}
+
+var checkNoJniLibs = pctx.AndroidStaticRule("checkNoJniLibs",
+	blueprint.RuleParams{
+		// zipinfo exits with an error when no entry matches the pattern.
+		Command: `rm -f $out && ` +
+			`if zipinfo -1 $in 'lib/*' >/dev/null 2>&1; then ` +
+			`echo "error: $in contains native libraries, which must only be packaged in its ABI splits:" >&2; ` +
+			`zipinfo -1 $in 'lib/*' >&2; exit 1; fi && ` +
+			`touch $out`,
+	})
+
+// CheckNoJniLibs verifies that an apk doesn't contain any native libraries.  It returns a stamp file to add as a
+// dependency of the installed apk.
+func CheckNoJniLibs(ctx android.ModuleContext, apk android.Path) android.Path {
+	stamp := android.PathForModuleOut(ctx, "check_no_jni_libs", apk.Base()+".stamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkNoJniLibs,
+		Description: "check no jni libs " + apk.Base(),
+		Input:       apk,
+		Output:      stamp,
+	})
+	return stamp
+}
+
+var fsverityMetadata = pctx.AndroidStaticRule("fsverityMetadata",
+	blueprint.RuleParams{
+		Command: `${fsverityMetadataGeneratorCmd} --fsverity-path ${fsverityCmd} --signature none ` +
+			`--hash-alg sha256 --output $out $in`,
+		CommandDeps: []string{"${fsverityMetadataGeneratorCmd}", "${fsverityCmd}"},
+	})
+
+// GenerateFsverityMetadata builds the fs-verity metadata (.fsv_meta) file of a file installed on a partition with
+// fs-verity enabled.
+func GenerateFsverityMetadata(ctx android.ModuleContext, outputFile android.WritablePath, input android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        fsverityMetadata,
+		Description: "fs-verity metadata " + input.Base(),
+		Input:       input,
+		Output:      outputFile,
+	})
+}
+
 func targetToJniDir(target android.Target) string {
 	return filepath.Join("lib", target.Arch.Abi[0])
 }
+
+// Apps with a minimum SDK version at or above presignedApkV2MinSdk don't accept apks signed only with the v1 (JAR
+// signing) scheme.
+const presignedApkV2MinSdk = 30
+
+var verifyPresignedApk = pctx.AndroidStaticRule("verifyPresignedApk",
+	blueprint.RuleParams{
+		Command: `rm -f $out $out.verify && ` +
+			`${apksignerCmd} verify -v $flags $in > $out.verify && ` +
+			// Without a min_sdk_version property the one in the manifest of the apk applies, it defaults
+			// to 1 and is a codename for apps built against a preview SDK.
+			`min_sdk="$minSdkVersion" && ` +
+			`if [ -z "$$min_sdk" ]; then ` +
+			`min_sdk=$$(${aaptCmd} dump badging $in | sed -n "s/^sdkVersion:'\(.*\)'$$/\1/p"); fi && ` +
+			`min_sdk="$${min_sdk:-1}" && ` +
+			`if echo "$$min_sdk" | grep -q "[^0-9]" || [ "$$min_sdk" -ge ` + strconv.Itoa(presignedApkV2MinSdk) + ` ]; then ` +
+			`if ! grep -qE "^Verified using v[2-9] scheme.*: true$$" $out.verify; then ` +
+			`echo "error: presigned apk $in is only signed with APK signature scheme v1," ` +
+			`"which is not accepted for min_sdk_version $$min_sdk" >&2; exit 1; fi; fi && ` +
+			`rm -f $out.verify && cp -f $in $out`,
+		CommandDeps: []string{"${apksignerCmd}", "${aaptCmd}"},
+	},
+	"flags", "minSdkVersion")
+
+var apkCertificateDigests = pctx.AndroidStaticRule("apkCertificateDigests",
+	blueprint.RuleParams{
+		Command: `${apksignerCmd} verify --print-certs $in | ` +
+			`sed -n -e 's/^Signer #[0-9]* certificate SHA-256 digest: //p' > $out`,
+		CommandDeps: []string{"${apksignerCmd}"},
+	})
+
+// ApkCertificateDigests writes the SHA-256 digests of the certificates an apk is signed with to outputFile, one per
+// line, for apks that are signed before the build.
+func ApkCertificateDigests(ctx android.ModuleContext, outputFile android.WritablePath, apk android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        apkCertificateDigests,
+		Description: "certificate digests " + apk.Base(),
+		Input:       apk,
+		Output:      outputFile,
+	})
+}
+
+// VerifyPresignedApk checks the signature of a presigned apk with apksigner, so that a bad prebuilt fails the build
+// instead of failing to install.  A minSdkVersion of 0 uses the one in the manifest of the apk, which is read at
+// build time to check whether the apk has to be signed with APK signature scheme v2.  The verified apk is copied to
+// outputFile.
+func VerifyPresignedApk(ctx android.ModuleContext, outputFile android.WritablePath, apk android.Path,
+	minSdkVersion int) {
+
+	var flags []string
+	minSdk := ""
+	if minSdkVersion > 0 {
+		flags = append(flags, "--min-sdk-version "+strconv.Itoa(minSdkVersion))
+		minSdk = strconv.Itoa(minSdkVersion)
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        verifyPresignedApk,
+		Description: "verify presigned " + apk.Base(),
+		Input:       apk,
+		Output:      outputFile,
+		Args: map[string]string{
+			"flags":         strings.Join(flags, " "),
+			"minSdkVersion": minSdk,
+		},
+	})
+}
+
+var processPrebuiltApk = pctx.AndroidStaticRule("processPrebuiltApk",
+	blueprint.RuleParams{
+		// The apk is only rewritten when one of the transformations applies to it, a presigned apk that
+		// is already stored the way it is installed has to keep its signature.  The dex files are
+		// stripped whether they are compressed or not.
+		Command: `rm -f $out $out.tmp && ` +
+			`if [ -n "$stripDex" ] && zipinfo -1 $in 'classes*.dex' >/dev/null 2>&1 ; then ` +
+			`${config.Zip2ZipCmd} -i $in -o $out.tmp $flags -x 'classes*.dex' ; ` +
+			`elif [ -n "$uncompressDex" ] && (zipinfo $in 'classes*.dex' 2>/dev/null | grep -v ' stor ' >/dev/null) ; then ` +
+			`${config.Zip2ZipCmd} -i $in -o $out.tmp $flags -0 'classes*.dex' ; ` +
+			`elif (zipinfo $in 'lib/*.so' 2>/dev/null | grep -v ' stor ' >/dev/null) || ` +
+			`(for abi in $excludeAbis ; do zipinfo -1 $in "lib/$$abi/*" >/dev/null 2>&1 && exit 0 ; done ; exit 1) ; then ` +
+			`${config.Zip2ZipCmd} -i $in -o $out.tmp $flags ; ` +
+			`else cp -f $in $out.tmp ; fi && ` +
+			`if [ -n "$align" ] && ! ${config.ZipAlign} -c -p 4 $out.tmp >/dev/null ; then ` +
+			`${config.ZipAlign} -f -p 4 $out.tmp $out && rm -f $out.tmp ; ` +
+			`else mv -f $out.tmp $out ; fi`,
+		CommandDeps: []string{"${config.Zip2ZipCmd}", "${config.ZipAlign}"},
+	},
+	"flags", "stripDex", "uncompressDex", "excludeAbis", "align")
+
+// ProcessPrebuiltApk rewrites a prebuilt apk the way it is installed in a single zip2zip pass: the native
+// libraries are stored uncompressed, the native libraries of excludeAbis are removed, and the dex files are
+// either stripped or stored uncompressed.  If align is true the result is also zipaligned.
+func ProcessPrebuiltApk(ctx android.ModuleContext, outputFile android.WritablePath, apk android.Path,
+	excludeAbis []string, stripDex, uncompressDex, align bool) {
+
+	flags := []string{"-0 'lib/**/*.so'"}
+	for _, abi := range excludeAbis {
+		flags = append(flags, "-x 'lib/"+abi+"/**'")
+	}
+
+	stripDexArg, uncompressDexArg := "", ""
+	if stripDex {
+		stripDexArg = "true"
+	} else if uncompressDex {
+		uncompressDexArg = "true"
+	}
+
+	alignArg := ""
+	if align {
+		alignArg = "true"
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        processPrebuiltApk,
+		Description: "process prebuilt " + apk.Base(),
+		Input:       apk,
+		Output:      outputFile,
+		Args: map[string]string{
+			"flags":         strings.Join(flags, " "),
+			"stripDex":      stripDexArg,
+			"uncompressDex": uncompressDexArg,
+			"excludeAbis":   strings.Join(excludeAbis, " "),
+			"align":         alignArg,
+		},
+	})
+}
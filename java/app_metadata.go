@@ -0,0 +1,142 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+var _ android.ArtifactMetadataProvider = (*AndroidApp)(nil)
+var _ android.ArtifactMetadataProvider = (*AndroidAppImport)(nil)
+
+// ArtifactMetadata publishes the package name, version and certificate digests of the installed APK to
+// the artifact metadata registry.
+func (a *AndroidApp) ArtifactMetadata(ctx android.ModuleContext) []android.ArtifactMetadata {
+	if a.provenance == nil {
+		return nil
+	}
+
+	metadata := android.ArtifactMetadata{
+		Kind:        "apk",
+		Path:        a.provenance.installPath.String(),
+		PackageName: a.renamedManifestPackageName(ctx),
+	}
+	if metadata.PackageName == "" {
+		metadata.PackageName = a.sourceManifestPackageName(ctx)
+	}
+	if flags := a.AaptLinkFlags(); flags != nil {
+		metadata.VersionCode = flags.VersionCode()
+		if a.aapt.versionNameBuildNumber {
+			// The build number is only known when the metadata is built.
+			metadata.VersionName = ctx.Config().PlatformVersionName() + "-" + android.ArtifactMetadataBuildNumber
+		} else {
+			metadata.VersionName = strings.Replace(flags.VersionName(), "$$", "$", -1)
+		}
+	}
+	if !a.presigned {
+		for _, c := range a.provenance.certificates {
+			if digest := certificateDigest(ctx, c.Pem); digest != "" {
+				metadata.CertificateDigests = append(metadata.CertificateDigests, digest)
+			}
+		}
+	}
+	return []android.ArtifactMetadata{metadata}
+}
+
+// ArtifactMetadata publishes the certificate digests of the installed APK to the artifact metadata registry.  The
+// certificates of a presigned APK are read from its signature when the build runs.
+func (a *AndroidAppImport) ArtifactMetadata(ctx android.ModuleContext) []android.ArtifactMetadata {
+	if a.provenance == nil {
+		return nil
+	}
+
+	metadata := android.ArtifactMetadata{
+		Kind: "apk",
+		Path: a.provenance.installPath.String(),
+	}
+	if a.certificate != nil {
+		for _, c := range a.provenance.certificates {
+			if digest := certificateDigest(ctx, c.Pem); digest != "" {
+				metadata.CertificateDigests = append(metadata.CertificateDigests, digest)
+			}
+		}
+	} else {
+		digests := android.PathForModuleOut(ctx, "certificate_digests.txt")
+		ApkCertificateDigests(ctx, digests, a.outputFile)
+		metadata.CertificateDigestsFile = digests
+	}
+	return []android.ArtifactMetadata{metadata}
+}
+
+// sourceManifestPackageName returns the package attribute of the manifest of the app, or an empty string
+// if the manifest is generated by another module.
+func (a *AndroidApp) sourceManifestPackageName(ctx android.ModuleContext) string {
+	manifestFile := proptools.StringDefault(a.aaptProperties.Manifest, "AndroidManifest.xml")
+	if android.SrcIsModule(manifestFile) != "" {
+		return ""
+	}
+	manifest := android.PathForModuleSrc(ctx, manifestFile)
+
+	r, err := ctx.Fs().Open(manifest.String())
+	if err != nil {
+		ctx.ModuleErrorf("failed to read the package name of %s: %s", manifest, err)
+		return ""
+	}
+	defer r.Close()
+	ctx.AddNinjaFileDeps(manifest.String())
+
+	var root struct {
+		Package string `xml:"package,attr"`
+	}
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		ctx.ModuleErrorf("failed to read the package name of %s: %s", manifest, err)
+		return ""
+	}
+	return root.Package
+}
+
+// certificateDigest returns the SHA-256 digest of the DER encoding of a certificate in lowercase hex, which
+// is how apksigner prints the certificates an APK is signed with.
+func certificateDigest(ctx android.ModuleContext, certificate android.Path) string {
+	r, err := ctx.Fs().Open(certificate.String())
+	if err != nil {
+		ctx.ModuleErrorf("failed to read certificate %s: %s", certificate, err)
+		return ""
+	}
+	defer r.Close()
+	ctx.AddNinjaFileDeps(certificate.String())
+
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		ctx.ModuleErrorf("failed to read certificate %s: %s", certificate, err)
+		return ""
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		ctx.ModuleErrorf("certificate %s is not PEM encoded", certificate)
+		return ""
+	}
+	digest := sha256.Sum256(block.Bytes)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// This singleton generates a provenance statement for every APK installed by Soong, to support supply-chain
+// verification of preinstalled apps.  The statement of an APK is written to
+// $OUT_DIR/soong/apk_provenance/<install path>.json and records the digest of the final, signed APK, the digests of
+// the inputs it was built from, the fingerprints of the certificates it was signed with and the digests of the tools
+// that built it.  Hashing every APK has a cost, so the statements are only generated when
+// SOONG_GENERATE_APK_PROVENANCE is set to true, and are built by the apk-provenance phony target.
+
+func init() {
+	android.RegisterSingletonType("apk_provenance", apkProvenanceSingletonFactory)
+}
+
+const envVariableGenerateApkProvenance = "SOONG_GENERATE_APK_PROVENANCE"
+
+var apkProvenanceRule = pctx.AndroidStaticRule("apkProvenance",
+	blueprint.RuleParams{
+		Command: `${config.GenApkProvenanceCmd} --name $name --apk $in --install_path $installPath ` +
+			`$inputs $certificates $presigned ` +
+			`--tool aapt2=${config.Aapt2Cmd} --tool d8=${config.D8Cmd} --tool merge_zips=${config.MergeZipsCmd} ` +
+			`--tool signapk=$signapkCmd --tool zipalign=${config.ZipAlign} ` +
+			`--out $out`,
+		CommandDeps: []string{
+			"${config.GenApkProvenanceCmd}",
+			"${config.Aapt2Cmd}",
+			"${config.D8Cmd}",
+			"${config.MergeZipsCmd}",
+			"$signapkCmd",
+			"${config.ZipAlign}",
+		},
+	},
+	"name", "installPath", "inputs", "certificates", "presigned")
+
+// apkProvenance describes how an installed APK was built.
+type apkProvenance struct {
+	// The final APK, after signing or zip-aligning.
+	apk android.Path
+	// The path the APK is installed to.
+	installPath android.OutputPath
+	// The inputs the APK was built from.
+	inputs android.Paths
+	// The certificates the APK was signed with in the build, empty for a presigned APK.
+	certificates []Certificate
+}
+
+type apkProvenanceProvider interface {
+	apkProvenance() *apkProvenance
+}
+
+func generateApkProvenance(config android.Config) bool {
+	return config.IsEnvTrue(envVariableGenerateApkProvenance)
+}
+
+func apkProvenanceSingletonFactory() android.Singleton {
+	return &apkProvenanceSingleton{}
+}
+
+type apkProvenanceSingleton struct{}
+
+func (s *apkProvenanceSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !generateApkProvenance(ctx.Config()) {
+		return
+	}
+
+	var statements android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled() {
+			return
+		}
+		provider, ok := module.(apkProvenanceProvider)
+		if !ok || provider.apkProvenance() == nil {
+			return
+		}
+		p := provider.apkProvenance()
+
+		installPath := android.InstallPathToOnDevicePath(ctx, p.installPath)
+		statement := android.PathForOutput(ctx, "apk_provenance", strings.TrimPrefix(installPath, "/")+".json")
+
+		var inputs []string
+		for _, input := range p.inputs {
+			inputs = append(inputs, "--input "+input.String())
+		}
+		var certificates []string
+		var deps android.Paths
+		for _, c := range p.certificates {
+			certificates = append(certificates, "--certificate "+c.Pem.String())
+			deps = append(deps, c.Pem)
+		}
+		presigned := ""
+		if len(p.certificates) == 0 {
+			presigned = "--presigned"
+		}
+
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        apkProvenanceRule,
+			Description: "apk provenance " + installPath,
+			Input:       p.apk,
+			Implicits:   append(deps, p.inputs...),
+			Output:      statement,
+			Args: map[string]string{
+				"name":         ctx.ModuleName(module),
+				"installPath":  installPath,
+				"inputs":       strings.Join(inputs, " "),
+				"certificates": strings.Join(certificates, " "),
+				"presigned":    presigned,
+			},
+		})
+		statements = append(statements, statement)
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   android.Phony,
+		Output: android.PathForPhony(ctx, "apk-provenance"),
+		Inputs: statements,
+	})
+}
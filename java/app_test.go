@@ -15,10 +15,10 @@
 package java
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"reflect"
-	"regexp"
 	"sort"
 	"strings"
 	"testing"
@@ -197,6 +197,231 @@ func TestResourceDirs(t *testing.T) {
 	}
 }
 
+func TestCheckedInResourceApi(t *testing.T) {
+	config := testConfig(nil)
+	ctx := testAppContext(config, `
+		android_app {
+			name: "foo",
+			checked_in_api_lint: "res-api.txt",
+		}
+
+		android_app {
+			name: "bar",
+		}
+	`, map[string][]byte{
+		"res/values/public.xml": nil,
+		"res-api.txt":           nil,
+	})
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	resourceApi := foo.Rule("resourceApi")
+	inputs := resourceApi.Inputs.Strings()
+	if !android.InList("res/values/public.xml", inputs) || !android.InList("res/values-en-rUS/strings.xml", inputs) {
+		t.Errorf("expected resource api inputs %q to contain the values files", inputs)
+	}
+	if android.InList("res/layout/layout.xml", inputs) {
+		t.Errorf("expected resource api inputs %q not to contain res/layout/layout.xml", inputs)
+	}
+
+	check := foo.Output("check_resource_api.timestamp")
+	if g, w := check.Args["expected"], "res-api.txt"; g != w {
+		t.Errorf("expected the checked in resource api %q, got %q", w, g)
+	}
+	if g, w := check.Args["actual"], resourceApi.Output.String(); g != w {
+		t.Errorf("expected the generated resource api %q, got %q", w, g)
+	}
+
+	if link := foo.Rule("aapt2Link"); !android.InList(check.Output.String(), link.Implicits.Strings()) {
+		t.Errorf("expected aapt2 link implicits %q to contain %q", link.Implicits.Strings(), check.Output)
+	}
+
+	if ctx.ModuleForTests("bar", "android_common").MaybeRule("resourceApi").Rule != nil {
+		t.Errorf("expected no resource api check for bar")
+	}
+}
+
+func TestLibraryAssets(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			asset_dirs: ["assets_foo"],
+			static_libs: ["lib2"],
+		}
+
+		android_library {
+			name: "lib2",
+			srcs: ["a.java"],
+			asset_dirs: ["assets_lib2"],
+			static_libs: ["import", "lib1"],
+		}
+
+		android_library {
+			name: "lib1",
+			srcs: ["a.java"],
+			asset_dirs: ["assets_lib1"],
+		}
+
+		android_library_import {
+			name: "import",
+			aars: ["import.aar"],
+		}
+	`
+
+	config := testConfig(nil)
+	ctx := testAppContext(config, bp, map[string][]byte{
+		"assets_foo/a":  nil,
+		"assets_lib1/b": nil,
+		"assets_lib2/c": nil,
+		"import.aar":    nil,
+	})
+	run(t, ctx, config)
+
+	lib2 := ctx.ModuleForTests("lib2", "android_common")
+	lib1 := ctx.ModuleForTests("lib1", "android_common")
+	aarImport := ctx.ModuleForTests("import", "android_common")
+
+	expectedAssets := []string{
+		lib2.Output("assets.zip").Output.String(),
+		aarImport.Output("assets.zip").Output.String(),
+		lib1.Output("assets.zip").Output.String(),
+	}
+
+	if g, w := lib2.Module().(*AndroidLibrary).ExportedAssets().Strings(), expectedAssets; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected lib2 exported assets %q, got %q", w, g)
+	}
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	merge := foo.Output("package-res.apk")
+	if merge.Rule != mergeAssetsRule {
+		t.Fatalf("expected package-res.apk to be built by the mergeAssets rule, got %v", merge.Rule)
+	}
+	if g, w := merge.Input.String(), foo.Output("aapt2/package-res.apk").Output.String(); g != w {
+		t.Errorf("expected merged assets input %q, got %q", w, g)
+	}
+	if g, w := merge.Implicits.Strings(), expectedAssets; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected merged assets %q, got %q", w, g)
+	}
+
+	if assets := foo.Module().(*AndroidApp).ExportedAssets(); len(assets) > 0 {
+		t.Errorf("expected app to export no assets, got %q", assets)
+	}
+}
+
+func TestAndroidLibraryCompileDex(t *testing.T) {
+	ctx := testJava(t, `
+		android_library {
+			name: "lib",
+			srcs: ["a.java"],
+			min_sdk_version: "21",
+			compile_dex: true,
+		}
+
+		android_library {
+			name: "lib_nodex",
+			srcs: ["a.java"],
+		}
+	`)
+
+	lib := ctx.ModuleForTests("lib", "android_common")
+	module := lib.Module().(*AndroidLibrary)
+
+	d8 := lib.Rule("d8")
+	if !strings.Contains(d8.Args["d8Flags"], "--min-api 21") {
+		t.Errorf("expected d8 flags to contain --min-api 21, got %q", d8.Args["d8Flags"])
+	}
+
+	dexJars, err := module.OutputFiles(".dex.jar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := dexJars.Strings(), []string{module.DexJar().String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected .dex.jar output %q, got %q", w, g)
+	}
+
+	if g, w := module.outputFile.String(), module.implementationAndResourcesJar.String(); g != w {
+		t.Errorf("expected output file to be the classes jar %q, got %q", w, g)
+	}
+	if g, w := lib.Output("lib.aar").Args["classesJar"], module.implementationAndResourcesJar.String(); g != w {
+		t.Errorf("expected aar classes jar %q, got %q", w, g)
+	}
+
+	nodex := ctx.ModuleForTests("lib_nodex", "android_common").Module().(*AndroidLibrary)
+	if _, err := nodex.OutputFiles(".dex.jar"); err == nil {
+		t.Errorf("expected an error for the .dex.jar output without compile_dex")
+	}
+}
+
+func TestAndroidLibraryResourceProcessor(t *testing.T) {
+	ctx := testJava(t, `
+		android_library {
+			name: "lib",
+			srcs: ["a.java"],
+			static_libs: ["dep"],
+			use_resource_processor: true,
+		}
+
+		android_library {
+			name: "dep",
+			srcs: ["a.java"],
+			static_libs: ["transitive_dep"],
+			use_resource_processor: true,
+		}
+
+		android_library {
+			name: "transitive_dep",
+			srcs: ["a.java"],
+		}
+	`)
+
+	lib := ctx.ModuleForTests("lib", "android_common")
+	rJar := lib.Output("busybox/R.jar")
+	if g, w := rJar.Args["rTxt"], lib.Module().(*AndroidLibrary).rTxt.String(); g != w {
+		t.Errorf("expected busybox R.txt %q, got %q", w, g)
+	}
+
+	// The R classes of the packages of the transitive static libraries are generated too.
+	for _, dep := range []string{"dep", "transitive_dep"} {
+		depLib := ctx.ModuleForTests(dep, "android_common").Module().(*AndroidLibrary)
+		w := "--library " + depLib.rTxt.String() + "," + depLib.manifestPath.String()
+		if g := rJar.Args["libraries"]; !strings.Contains(g, w) {
+			t.Errorf("expected busybox libraries to contain %q, got %q", w, g)
+		}
+	}
+
+	aaptSrcJar := lib.Module().(*AndroidLibrary).aaptSrcJar.String()
+	manifestSrcJar := lib.Output("gen/Manifest.jar")
+	if g, w := manifestSrcJar.Input.String(), aaptSrcJar; g != w {
+		t.Errorf("expected Manifest.jar input %q, got %q", w, g)
+	}
+
+	javac := lib.Rule("javac")
+	srcJars := strings.Fields(javac.Args["srcJars"])
+	if android.InList(aaptSrcJar, srcJars) || !android.InList(manifestSrcJar.Output.String(), srcJars) {
+		t.Errorf("expected javac to compile %q instead of %q, got srcJars %q", manifestSrcJar.Output.String(),
+			aaptSrcJar, srcJars)
+	}
+	if !strings.Contains(javac.Args["classpath"], rJar.Output.String()) {
+		t.Errorf("expected javac classpath to contain %q, got %q", rJar.Output.String(), javac.Args["classpath"])
+	}
+
+	// The R classes of the library are generated with the final IDs for the app that includes it, the classes
+	// generated by the resource processor are not packaged.
+	if combined := lib.MaybeOutput("combined/lib.jar"); android.InList(rJar.Output.String(), combined.Inputs.Strings()) {
+		t.Errorf("expected combined jar inputs not to contain %q, got %q", rJar.Output.String(),
+			combined.Inputs.Strings())
+	}
+
+	testJavaError(t, `use_resource_processor: is only supported by android_library modules`, `
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			use_resource_processor: true,
+		}
+	`)
+}
+
 func TestAndroidResources(t *testing.T) {
 	testCases := []struct {
 		name                       string
@@ -417,7 +642,8 @@ func TestAndroidResources(t *testing.T) {
 					overlayFiles = resourceListToFiles(module, overlayList.Inputs.Strings())
 				}
 
-				for _, d := range module.Module().(AndroidLibraryDependency).ExportedRRODirs() {
+				exportedRRODirs, _ := module.Provider(ExportedRRODirsProvider).([]rroDir)
+				for _, d := range exportedRRODirs {
 					var prefix string
 					if d.overlayType == device {
 						prefix = "device:"
@@ -453,6 +679,287 @@ func TestAndroidResources(t *testing.T) {
 	}
 }
 
+func TestRROOverlayable(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			resource_dirs: ["foo/res"],
+			static_libs: ["lib"],
+		}
+
+		android_app {
+			name: "bar",
+			resource_dirs: ["bar/res"],
+		}
+
+		android_library {
+			name: "lib",
+			resource_dirs: ["lib/res"],
+		}
+	`
+	fs := map[string][]byte{
+		"foo/res/values/overlayable.xml":                        nil,
+		"bar/res/values/strings.xml":                            nil,
+		"lib/res/values/overlayable.xml":                        nil,
+		"device/vendor/blah/overlay/foo/res/values/strings.xml": nil,
+		"device/vendor/blah/overlay/foo/res/drawable/icon.png":  nil,
+		"device/vendor/blah/overlay/bar/res/values/strings.xml": nil,
+	}
+
+	for _, enforce := range []bool{false, true} {
+		config := testConfig(nil)
+		config.TestProductVariables.DeviceResourceOverlays = []string{"device/vendor/blah/overlay"}
+		config.TestProductVariables.EnforceRROTargets = []string{"foo"}
+		config.TestProductVariables.EnforceRROOverlayable = proptools.BoolPtr(enforce)
+
+		ctx := testAppContext(config, bp, fs)
+		run(t, ctx, config)
+
+		foo := ctx.ModuleForTests("foo", "android_common")
+		check := foo.Output("check_rro_overlayable.timestamp")
+		if g, w := check.Inputs.Strings(), []string{
+			"device/vendor/blah/overlay/foo/res/drawable/icon.png",
+			"device/vendor/blah/overlay/foo/res/values/strings.xml",
+		}; !reflect.DeepEqual(g, w) {
+			t.Errorf("expected overlay inputs %q, got %q", w, g)
+		}
+
+		fooApi := foo.Output("resource_api.txt").Output.String()
+		libApi := ctx.ModuleForTests("lib", "android_common").Output("resource_api.txt").Output.String()
+		for _, api := range []string{fooApi, libApi} {
+			if !strings.Contains(check.Args["flags"], "--target-api "+api) {
+				t.Errorf("expected check flags %q to contain target api %q", check.Args["flags"], api)
+			}
+		}
+		if g := strings.Contains(check.Args["flags"], "--soft"); g == enforce {
+			t.Errorf("expected --soft in check flags %q to be %v", check.Args["flags"], !enforce)
+		}
+
+		if link := foo.Rule("aapt2Link"); !android.InList(check.Output.String(), link.Implicits.Strings()) {
+			t.Errorf("expected aapt2 link implicits %q to contain %q", link.Implicits.Strings(), check.Output)
+		}
+
+		if ctx.ModuleForTests("bar", "android_common").MaybeOutput("check_rro_overlayable.timestamp").Rule != nil {
+			t.Errorf("expected no overlayable check for bar, which doesn't enforce RRO")
+		}
+	}
+}
+
+func TestStaticResourceConflicts(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["lib1", "lib2"],
+			allowed_resource_conflicts: ["string/app_name"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			static_libs: ["lib1"],
+		}
+
+		android_library {
+			name: "lib1",
+			srcs: ["a.java"],
+			static_libs: ["lib3", "lib4"],
+		}
+
+		android_library {
+			name: "lib2",
+			srcs: ["a.java"],
+		}
+
+		android_library {
+			name: "lib3",
+			srcs: ["a.java"],
+		}
+
+		android_library {
+			name: "lib4",
+			srcs: ["a.java"],
+		}
+	`)
+
+	packageRes := func(lib string) string {
+		return ctx.ModuleForTests(lib, "android_common").Output("package-res.apk").Output.String()
+	}
+
+	// The direct static libraries are compared, with the packages of their own static libraries.
+	foo := ctx.ModuleForTests("foo", "android_common")
+	check := foo.Output("check_resource_conflicts.timestamp")
+	if g, w := check.Inputs.Strings(), []string{packageRes("lib1"), packageRes("lib3"), packageRes("lib4"),
+		packageRes("lib2")}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected resource conflict check inputs %q, got %q", w, g)
+	}
+	if g, w := check.Args["libraries"], packageRes("lib1")+":"+packageRes("lib3")+","+packageRes("lib4")+" "+
+		packageRes("lib2")+":"; g != w {
+		t.Errorf("expected resource conflict check libraries %q, got %q", w, g)
+	}
+	if g, w := check.Args["flags"], "--allow string/app_name"; g != w {
+		t.Errorf("expected resource conflict check flags %q, got %q", w, g)
+	}
+	if link := foo.Rule("aapt2Link"); !android.InList(check.Output.String(), link.Implicits.Strings()) {
+		t.Errorf("expected aapt2 link implicits %q to contain %q", link.Implicits.Strings(), check.Output)
+	}
+
+	if ctx.ModuleForTests("bar", "android_common").MaybeOutput("check_resource_conflicts.timestamp").Rule != nil {
+		t.Errorf("expected no resource conflict check for bar, which has a single static library")
+	}
+	if ctx.ModuleForTests("lib1", "android_common").MaybeOutput("check_resource_conflicts.timestamp").Rule == nil {
+		t.Errorf("expected a resource conflict check for lib1, which has two static libraries")
+	}
+
+	testJavaError(t, `allowed_resource_conflicts: invalid resource "app_name", expected <type>/<name>`, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["lib1", "lib2"],
+			allowed_resource_conflicts: ["app_name"],
+		}
+
+		android_library {
+			name: "lib1",
+			srcs: ["a.java"],
+		}
+
+		android_library {
+			name: "lib2",
+			srcs: ["a.java"],
+		}
+	`)
+}
+
+func TestAppImageVariants(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			recovery_available: true,
+			ramdisk_available: true,
+			dex_preopt: {
+				enabled: true,
+			},
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			recovery: true,
+		}
+	`)
+
+	testCases := []struct {
+		name      string
+		variant   string
+		installed string
+		subName   string
+	}{
+		{"foo", "android_common", "system/app/foo/foo.apk", ""},
+		{"foo", "android_common_recovery", "recovery/root/system/app/foo/foo.apk", ".recovery"},
+		{"foo", "android_common_ramdisk", "ramdisk/system/app/foo/foo.apk", ".ramdisk"},
+		{"bar", "android_common_recovery", "recovery/root/system/app/bar/bar.apk", ""},
+	}
+
+	for _, test := range testCases {
+		variant := ctx.ModuleForTests(test.name, test.variant)
+		installed := filepath.Join(buildDir, "target/product/test_device", test.installed)
+		if !android.InList(installed, variant.AllOutputs()) {
+			t.Errorf("%s %s: can't find %q in output files.\nAll outputs:%v", test.name, test.variant,
+				installed, variant.AllOutputs())
+		}
+
+		app := variant.Module().(*AndroidApp)
+		if g := app.AndroidMk().SubName; g != test.subName {
+			t.Errorf("%s %s: expected Make module suffix %q, got %q", test.name, test.variant, test.subName, g)
+		}
+
+		preopted := variant.MaybeOutput("dexpreopt/oat/arm64/package.odex").Rule != nil
+		if w := test.variant == "android_common"; preopted != w {
+			t.Errorf("%s %s: expected dexpreopt %v, got %v", test.name, test.variant, w, preopted)
+		}
+	}
+
+	testJavaError(t, `jni_libs: there are no native libraries in the ramdisk`, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			jni_libs: ["libjni"],
+			ramdisk_available: true,
+		}
+
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+	`)
+}
+
+func TestRenamedAppImageVariants(t *testing.T) {
+	config := testConfig(nil)
+	config.TestProductVariables.PackageNameOverrides = []string{"foo:bar"}
+	ctx := testAppContext(config, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			recovery_available: true,
+		}
+	`, nil)
+	run(t, ctx, config)
+
+	for variant, makeName := range map[string]string{
+		"android_common":          "bar",
+		"android_common_recovery": "bar.recovery",
+	} {
+		var mk strings.Builder
+		for _, extra := range ctx.ModuleForTests("foo", variant).Module().(*AndroidApp).AndroidMk().Extra {
+			extra(&mk, nil)
+		}
+		if g, w := mk.String(), "LOCAL_MODULE := "+makeName+"\n"; !strings.Contains(g, w) {
+			t.Errorf("%s: expected androidmk to contain %q, got:\n%s", variant, w, g)
+		}
+	}
+}
+
+func TestAppInstallPhony(t *testing.T) {
+	ctx := testJava(t, cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			jni_libs: ["libjni"],
+			dex_preopt: {
+				enabled: true,
+			},
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	install := foo.Output("foo-install")
+
+	for _, installed := range []string{
+		"system/app/foo/foo.apk",
+		"system/app/foo/oat/arm64/foo.odex",
+		"system/app/foo/oat/arm64/foo.vdex",
+		"system/app/foo/lib/arm64/libjni.so",
+	} {
+		installed = filepath.Join(buildDir, "target/product/test_device", installed)
+		if !android.InList(installed, foo.AllOutputs()) {
+			t.Errorf("can't find %q in output files.\nAll outputs:%v", installed, foo.AllOutputs())
+		}
+		if !android.InList(installed, install.Implicits.Strings()) {
+			t.Errorf("expected foo-install implicits %q to contain %q", install.Implicits.Strings(), installed)
+		}
+	}
+}
+
 func TestAppSdkVersion(t *testing.T) {
 	testCases := []struct {
 		name                  string
@@ -520,17 +1027,10 @@ func TestAppSdkVersion(t *testing.T) {
 				run(t, ctx, config)
 
 				foo := ctx.ModuleForTests("foo", "android_common")
-				link := foo.Output("package-res.apk")
-				linkFlags := strings.Split(link.Args["flags"], " ")
-				min := android.IndexList("--min-sdk-version", linkFlags)
-				target := android.IndexList("--target-sdk-version", linkFlags)
+				linkFlags := foo.Module().(AaptLinkFlagsProvider).AaptLinkFlags()
 
-				if min == -1 || target == -1 || min == len(linkFlags)-1 || target == len(linkFlags)-1 {
-					t.Fatalf("missing --min-sdk-version or --target-sdk-version in link flags: %q", linkFlags)
-				}
-
-				gotMinSdkVersion := linkFlags[min+1]
-				gotTargetSdkVersion := linkFlags[target+1]
+				gotMinSdkVersion := linkFlags.MinSdkVersion()
+				gotTargetSdkVersion := linkFlags.TargetSdkVersion()
 
 				if gotMinSdkVersion != test.expectedMinSdkVersion {
 					t.Errorf("incorrect --min-sdk-version, expected %q got %q",
@@ -541,6 +1041,11 @@ func TestAppSdkVersion(t *testing.T) {
 					t.Errorf("incorrect --target-sdk-version, expected %q got %q",
 						test.expectedMinSdkVersion, gotTargetSdkVersion)
 				}
+
+				flags := foo.Output("package-res.apk").Args["flags"]
+				if w := "--min-sdk-version " + test.expectedMinSdkVersion; !strings.Contains(flags, w) {
+					t.Errorf("expected link flags %q to contain %q", flags, w)
+				}
 			})
 		}
 	}
@@ -858,12 +1363,1949 @@ func TestJNIPackaging(t *testing.T) {
 	}
 }
 
+func TestJNIExclude(t *testing.T) {
+	ctx := testJava(t, cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libjni64",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_test {
+			name: "test",
+			sdk_version: "core_platform",
+			compile_multilib: "both",
+			jni_libs: ["libjni", "libjni64"],
+			arch: {
+				arm: {
+					exclude_jni_libs: ["libjni64"],
+				},
+			},
+		}
+		`)
+
+	jniLibZip := ctx.ModuleForTests("test", "android_common").Output("jnilibs.zip")
+	libs := map[string][]string{}
+	var abi string
+	args := strings.Fields(jniLibZip.Args["jarArgs"])
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-P":
+			abi = filepath.Base(args[i+1])
+		case "-f":
+			libs[abi] = append(libs[abi], strings.TrimSuffix(filepath.Base(args[i+1]), ".so"))
+		}
+	}
+
+	expected := map[string][]string{
+		"arm64-v8a":   {"libjni", "libjni64"},
+		"armeabi-v7a": {"libjni"},
+	}
+	if !reflect.DeepEqual(libs, expected) {
+		t.Errorf("want jni libs %q, got %q", expected, libs)
+	}
+
+	testJavaError(t, `arch.arm.exclude_jni_libs: "libfoo" is not in jni_libs`,
+		cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_test {
+			name: "test",
+			sdk_version: "core_platform",
+			compile_multilib: "both",
+			jni_libs: ["libjni"],
+			arch: {
+				arm: {
+					exclude_jni_libs: ["libfoo"],
+				},
+			},
+		}
+		`)
+
+	testJavaError(t, `arch.arm.exclude_jni_libs: "libjni64" is needed by "libjni" in lib/armeabi-v7a`,
+		cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			shared_libs: ["libjni64"],
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		cc_library {
+			name: "libjni64",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_test {
+			name: "test",
+			sdk_version: "core_platform",
+			compile_multilib: "both",
+			jni_libs: ["libjni", "libjni64"],
+			arch: {
+				arm: {
+					exclude_jni_libs: ["libjni64"],
+				},
+			},
+		}
+		`)
+}
+
+func TestJNICheckSymbols(t *testing.T) {
+	ctx := testJava(t, cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_test {
+			name: "test",
+			sdk_version: "core_platform",
+			jni_libs: ["libjni"],
+			check_jni_symbols: true,
+		}
+
+		android_test {
+			name: "test_nocheck",
+			sdk_version: "core_platform",
+			jni_libs: ["libjni"],
+		}
+		`)
+
+	test := ctx.ModuleForTests("test", "android_common")
+	check := test.Output("jni_symbol_check/lib/arm64-v8a/libjni.so.stamp")
+	if g, w := check.Input.String(), "libjni.so.toc"; !strings.HasSuffix(g, w) {
+		t.Errorf("want jni symbol check input to end with %q, got %q", w, g)
+	}
+
+	apk := test.Output("test-unsigned.apk")
+	if !inList(check.Output.String(), apk.Implicits.Strings()) {
+		t.Errorf("want test-unsigned.apk implicits to contain %q, got %q", check.Output.String(),
+			apk.Implicits.Strings())
+	}
+
+	if rule := ctx.ModuleForTests("test_nocheck", "android_common").MaybeRule("checkJniSymbols"); rule.Rule != nil {
+		t.Errorf("jni symbols should not be checked without check_jni_symbols")
+	}
+}
+
+func TestAppVersionNameWithBuildNumber(t *testing.T) {
+	config := testConfig(nil)
+	config.TestProductVariables.Platform_version_name = proptools.StringPtr("Q")
+	ctx := testContext(config, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			version_name_with_build_number: true,
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			version_name_with_build_number: true,
+			version_name: "1.0",
+		}
+	`, nil)
+	run(t, ctx, config)
+
+	buildNumberFile := config.BuildNumberFile(android.PathContextForTesting(config, nil)).String()
+
+	foo := ctx.ModuleForTests("foo", "android_common").Rule("aapt2Link")
+	if w := "--version-name Q-$$(cat " + buildNumberFile + ")"; !strings.Contains(foo.Args["flags"], w) {
+		t.Errorf("expected foo aapt2 flags to contain %q, got %q", w, foo.Args["flags"])
+	}
+	if !android.InList(buildNumberFile, foo.Implicits.Strings()) {
+		t.Errorf("expected foo aapt2 link to depend on %q, got %q", buildNumberFile, foo.Implicits.Strings())
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common").Rule("aapt2Link")
+	if strings.Contains(bar.Args["flags"], buildNumberFile) {
+		t.Errorf("expected bar aapt2 flags not to read %q, got %q", buildNumberFile, bar.Args["flags"])
+	}
+}
+
+func TestAppVersion(t *testing.T) {
+	config := testConfig(nil)
+	versionCodeOffset := 1000
+	config.TestProductVariables.AppsVersionCodeOffset = &versionCodeOffset
+	config.TestProductVariables.AppsDefaultVersionName = proptools.StringPtr("default")
+	config.TestProductVariables.VersionCodeOverrides = []string{"baz:7"}
+	config.TestProductVariables.VersionNameOverrides = []string{"baz:2.0-product"}
+	ctx := testContext(config, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			version_code: 42,
+			version_name: "1.0",
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["a.java"],
+			version_code: 42,
+			version_name: "1.0",
+		}
+	`, nil)
+	run(t, ctx, config)
+
+	testCases := []struct {
+		name        string
+		versionCode string
+		versionName string
+	}{
+		{"foo", "--version-code " + config.PlatformSdkVersion(), "--version-name default"},
+		{"bar", "--version-code 1042", "--version-name 1.0"},
+		{"baz", "--version-code 7", "--version-name 2.0-product"},
+	}
+
+	for _, test := range testCases {
+		flags := ctx.ModuleForTests(test.name, "android_common").Rule("aapt2Link").Args["flags"]
+		for _, w := range []string{test.versionCode, test.versionName} {
+			if !strings.Contains(flags, w) {
+				t.Errorf("expected %s aapt2 flags to contain %q, got %q", test.name, w, flags)
+			}
+		}
+	}
+}
+
+func TestAppManifestValues(t *testing.T) {
+	config := testConfig(nil)
+	config.TestProductVariables.AAPTCharacteristics = proptools.StringPtr("tablet")
+	ctx := testContext(config, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			manifest_values: [
+				"brand=Foo Phone",
+				"product_characteristics=phone",
+			],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+	`, nil)
+	run(t, ctx, config)
+
+	testCases := []struct {
+		name string
+		args string
+	}{
+		{"foo", "--manifest-value product_characteristics=tablet --manifest-value 'brand=Foo Phone' " +
+			"--manifest-value product_characteristics=phone"},
+		{"bar", "--manifest-value product_characteristics=tablet"},
+	}
+
+	for _, test := range testCases {
+		args := ctx.ModuleForTests(test.name, "android_common").Rule("manifestFixer").RuleParams.Command
+		if !strings.Contains(args, test.args) {
+			t.Errorf("expected %s manifest_fixer args to contain %q, got %q", test.name, test.args, args)
+		}
+	}
+
+	testJavaError(t, `manifest_values: invalid value "=foo", expected <name>=<value>`, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			manifest_values: ["=foo"],
+		}
+	`)
+}
+
+func TestDeniedAaptflags(t *testing.T) {
+	testCases := []struct {
+		flag     string
+		property string
+	}{
+		{"--version-code 2", "version_code"},
+		{"--version-name 1.0", "version_name"},
+		{"--min-sdk-version 21", "min_sdk_version"},
+		{"--target-sdk-version 28", "target_sdk_version"},
+		{"--manifest foo.xml", "manifest"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.property, func(t *testing.T) {
+			flag := strings.Fields(test.flag)[0]
+			testJavaError(t, `aaptflags: `+flag+` is set by the build, use the `+test.property+` property instead`, `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					aaptflags: ["--auto-add-overlay", "`+test.flag+`"],
+				}
+			`)
+		})
+	}
+}
+
+func TestUpdatableApps(t *testing.T) {
+	testJava(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			updatable: true,
+			sdk_version: "current",
+			min_sdk_version: "29",
+		}
+	`)
+
+	testCases := []struct {
+		name       string
+		properties string
+		errors     []string
+	}{
+		{
+			name:       "no min_sdk_version",
+			properties: `sdk_version: "current"`,
+			errors:     []string{"min_sdk_version must be set"},
+		},
+		{
+			name:       "platform apis",
+			properties: `platform_apis: true, min_sdk_version: "29"`,
+			errors:     []string{"platform_apis must not be set"},
+		},
+		{
+			name:       "no sdk_version",
+			properties: `min_sdk_version: "29"`,
+			errors:     []string{`sdk_version must be set to a stable SDK, found ""`},
+		},
+		{
+			name:       "v1 signing",
+			properties: `sdk_version: "current", min_sdk_version: "21"`,
+			errors:     []string{`min_sdk_version must be at least 24 .* found "21"`},
+		},
+		{
+			name:       "compressed jni libs",
+			properties: `sdk_version: "current", min_sdk_version: "29", jni_libs: ["libjni"]`,
+			errors:     []string{"use_embedded_native_libs must be true"},
+		},
+		{
+			name:       "all",
+			properties: `platform_apis: true, jni_libs: ["libjni"]`,
+			errors: []string{
+				"min_sdk_version must be set",
+				"platform_apis must not be set",
+				"use_embedded_native_libs must be true",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			bp := cc.GatherRequiredDepsForTest(android.Android) + `
+				cc_library {
+					name: "libjni",
+					system_shared_libs: [],
+					stl: "none",
+				}
+
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					updatable: true,
+					` + test.properties + `,
+				}
+			`
+			for _, err := range test.errors {
+				testJavaError(t, `updatable: updatable apps must fix the following:(?s:.*)`+err, bp)
+			}
+		})
+	}
+}
+
+func TestGenerateProductCharacteristicsRRO(t *testing.T) {
+	config := testConfig(nil)
+	config.TestProductVariables.AAPTCharacteristics = proptools.StringPtr("tablet")
+	ctx := testContext(config, `
+		android_app {
+			name: "foo",
+			defaults: ["foo_defaults"],
+			srcs: ["a.java"],
+			generate_product_characteristics_rro: true,
+		}
+
+		java_defaults {
+			name: "foo_defaults",
+			resource_dirs: ["res", "overlay/res"],
+			static_libs: ["lib"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+
+		android_library {
+			name: "lib",
+			srcs: ["a.java"],
+		}
+
+		java_binary_host {
+			name: "characteristics_rro_manifest",
+			srcs: ["a.java"],
+		}
+
+		java_binary_host {
+			name: "characteristics_rro_resources",
+			srcs: ["a.java"],
+		}
+
+		java_binary_host {
+			name: "aapt2",
+			srcs: ["b.java"],
+		}
+	`, map[string][]byte{
+		"res/values/strings.xml":         nil,
+		"res/values-fr/strings.xml":      nil,
+		"res/layout/main.xml":            nil,
+		"overlay/res/values/strings.xml": nil,
+		"overlay/res/drawable/icon.xml":  nil,
+	})
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	if flags := foo.Rule("aapt2Link").Args["flags"]; strings.Contains(flags, "--product") {
+		t.Errorf("expected foo aapt2 flags not to contain --product, got %q", flags)
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	if flags := bar.Rule("aapt2Link").Args["flags"]; !strings.Contains(flags, "--product tablet") {
+		t.Errorf("expected bar aapt2 flags to contain %q, got %q", "--product tablet", flags)
+	}
+
+	manifest := ctx.ModuleForTests("foo__auto_generated_characteristics_rro_manifest", "").Rule("generator")
+	fooApk := foo.Module().(*AndroidApp).outputFile
+	if len(manifest.Inputs) != 1 || manifest.Inputs[0].String() != fooApk.String() {
+		t.Errorf("expected the overlay manifest to be generated from %q, got %q", fooApk, manifest.Inputs)
+	}
+
+	rro := ctx.ModuleForTests("foo__auto_generated_characteristics_rro", "android_common")
+	rroLink := rro.Rule("aapt2Link")
+	if flags := rroLink.Args["flags"]; !strings.Contains(flags, "--product tablet") {
+		t.Errorf("expected overlay aapt2 flags to contain %q, got %q", "--product tablet", flags)
+	}
+	if !rro.Module().(*AndroidApp).ProductSpecific() {
+		t.Errorf("expected the overlay to be installed on the product partition")
+	}
+	expectedInstallDir := filepath.Join(buildDir, "target/product/test_device/product/overlay")
+	if installDir := rro.Module().(*AndroidApp).installDir.String(); installDir != expectedInstallDir {
+		t.Errorf("expected the overlay to be installed in %q, got %q", expectedInstallDir, installDir)
+	}
+
+	// Only the values files of each resource directory are read, and each directory becomes a
+	// resource zip of the overlay.
+	resourceInputs := [][]string{
+		{"res/values-fr/strings.xml", "res/values/strings.xml"},
+		{"overlay/res/values/strings.xml"},
+	}
+	for i, want := range resourceInputs {
+		resources := ctx.ModuleForTests(fmt.Sprintf("foo__auto_generated_characteristics_rro_res_%d", i), "")
+		gen := resources.Rule("generator")
+		if got := gen.Inputs.Strings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("expected overlay resource inputs %q, got %q", want, got)
+		}
+		zip := rro.Output(fmt.Sprintf("reszip.%d.flata", i))
+		if zip.Input.String() != gen.Output.String() {
+			t.Errorf("expected overlay resource zip %d to be %q, got %q", i, gen.Output, zip.Input)
+		}
+	}
+	if compile := rro.MaybeRule("aapt2Compile"); compile.Rule != nil {
+		t.Errorf("expected the overlay to have no resource directories, got %q", compile.Inputs)
+	}
+
+	// The static libraries of the app are shared libraries of the overlay.
+	libPackage := ctx.ModuleForTests("lib", "android_common").Output("package-res.apk").Output
+	if flags := rroLink.Args["flags"]; !strings.Contains(flags, "-I "+libPackage.String()) {
+		t.Errorf("expected overlay aapt2 flags to contain %q, got %q", "-I "+libPackage.String(), flags)
+	}
+
+	if variants := ctx.ModuleVariantsForTests("bar__auto_generated_characteristics_rro"); len(variants) > 0 {
+		t.Errorf("expected no overlay for bar")
+	}
+}
+
+func TestJNIABISplits(t *testing.T) {
+	ctx := testJava(t, cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_test {
+			name: "test",
+			sdk_version: "core_platform",
+			compile_multilib: "both",
+			jni_libs: ["libjni"],
+			abi_splits: true,
+		}
+		`)
+
+	test := ctx.ModuleForTests("test", "android_common")
+
+	for _, abi := range []string{"arm64-v8a", "armeabi-v7a"} {
+		t.Run(abi, func(t *testing.T) {
+			jniLibZip := test.Output("abi_splits/" + abi + "/jnilibs.zip")
+			var abis []string
+			args := strings.Fields(jniLibZip.Args["jarArgs"])
+			for i := 0; i < len(args); i++ {
+				if args[i] == "-P" {
+					abis = append(abis, filepath.Base(args[i+1]))
+					i++
+				}
+			}
+			if !reflect.DeepEqual(abis, []string{abi}) {
+				t.Errorf("want abis %v, got %v", []string{abi}, abis)
+			}
+
+			split := "config." + strings.Replace(abi, "-", "_", -1)
+			res := test.Output("abi_splits/" + abi + "/package-res.apk")
+			if g, w := res.Args["split"], split; g != w {
+				t.Errorf("want split %q, got %q", w, g)
+			}
+
+			splitApk := test.Output("test_" + split + "-unsigned.apk")
+			if !reflect.DeepEqual(splitApk.Inputs.Strings(), []string{res.Output.String(), jniLibZip.Output.String()}) {
+				t.Errorf("want split apk inputs %q, got %q",
+					[]string{res.Output.String(), jniLibZip.Output.String()}, splitApk.Inputs.Strings())
+			}
+
+			test.Output("test_" + split + ".apk")
+		})
+	}
+
+	apk := test.Output("test-unsigned.apk")
+	for _, input := range apk.Inputs.Strings() {
+		if strings.HasSuffix(input, "jnilibs.zip") {
+			t.Errorf("want no jni libs in the base apk, got input %q", input)
+		}
+	}
+
+	check := test.Rule("checkNoJniLibs")
+	if g, w := check.Input.String(), "test.apk"; !strings.HasSuffix(g, w) {
+		t.Errorf("want native library check of %q, got %q", w, g)
+	}
+
+	testJavaError(t, `abi_splits: requires the JNI libraries to be embedded`, cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		android_app {
+			name: "app",
+			jni_libs: ["libjni"],
+			abi_splits: true,
+		}
+		`)
+}
+
+func TestAppRequired(t *testing.T) {
+	config := testConfig(nil)
+	ctx := testContext(config, cc.GatherRequiredDepsForTest(android.Android)+`
+		cc_library {
+			name: "libjni",
+			system_shared_libs: [],
+			stl: "none",
+		}
+
+		java_binary_host {
+			name: "helper",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "target_helper",
+			srcs: ["a.java"],
+		}
+
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			required: ["libjni", "bar", "make_only"],
+			host_required: ["helper"],
+			target_required: ["target_helper"],
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			required: ["foo"],
+		}
+		`, nil)
+	ctx.RegisterSingletonType("buildtarget", android.SingletonFactoryAdaptor(android.BuildTargetSingleton))
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	phony := foo.Output("foo")
+	implicits := phony.Implicits.Strings()
+	for _, w := range []string{"foo-required", "foo-install"} {
+		if !inList(w, implicits) {
+			t.Errorf("expected %q in phony target implicits %q", w, implicits)
+		}
+	}
+
+	// Only the install targets of the required modules defined in Soong are order-only dependencies of the
+	// required target, including the ones of the modules they require, and modules that require each other
+	// don't depend on each other's phony targets.
+	required := ctx.SingletonForTests("buildtarget").Output("foo-required")
+	orderOnly := required.OrderOnly.Strings()
+	for _, w := range []string{"libjni-install", "helper-install", "bar-install", "foo-install"} {
+		if !inList(w, orderOnly) {
+			t.Errorf("expected %q in required target order-only deps %q", w, orderOnly)
+		}
+	}
+	// target_required only applies to host modules.
+	for _, w := range []string{"target_helper-install", "make_only", "make_only-install", "bar"} {
+		if inList(w, orderOnly) {
+			t.Errorf("expected no %q in required target order-only deps %q", w, orderOnly)
+		}
+	}
+	if len(required.Implicits) > 0 || len(required.Inputs) > 0 {
+		t.Errorf("expected only order-only deps in required target, got %q", append(required.Implicits, required.Inputs...))
+	}
+}
+
 func TestCertificates(t *testing.T) {
 	testCases := []struct {
-		name                string
-		bp                  string
-		certificateOverride string
-		expected            string
+		name                  string
+		bp                    string
+		certificateOverride   string
+		defaultCertificateDir string
+		defaultCertificate    string
+		expected              string
+	}{
+		{
+			name: "default",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+				}
+			`,
+			certificateOverride: "",
+			expected:            "build/make/target/product/security/testkey.x509.pem build/make/target/product/security/testkey.pk8",
+		},
+		{
+			name: "module certificate property",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					certificate: ":new_certificate"
+				}
+
+				android_app_certificate {
+					name: "new_certificate",
+			    certificate: "cert/new_cert",
+				}
+			`,
+			certificateOverride: "",
+			expected:            "cert/new_cert.x509.pem cert/new_cert.pk8",
+		},
+		{
+			name: "path certificate property",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					certificate: "expiredkey"
+				}
+			`,
+			certificateOverride: "",
+			expected:            "build/make/target/product/security/expiredkey.x509.pem build/make/target/product/security/expiredkey.pk8",
+		},
+		{
+			name: "certificate overrides",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					certificate: "expiredkey"
+				}
+
+				android_app_certificate {
+					name: "new_certificate",
+			    certificate: "cert/new_cert",
+				}
+			`,
+			certificateOverride: "foo:new_certificate",
+			expected:            "cert/new_cert.x509.pem cert/new_cert.pk8",
+		},
+		{
+			name: "default certificate dir",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+				}
+			`,
+			defaultCertificateDir: "vendor/keys",
+			expected:              "vendor/keys/testkey.x509.pem vendor/keys/testkey.pk8",
+		},
+		{
+			name: "path certificate property with default certificate dir",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					certificate: "platform"
+				}
+			`,
+			defaultCertificateDir: "vendor/keys",
+			expected:              "vendor/keys/platform.x509.pem vendor/keys/platform.pk8",
+		},
+		{
+			name: "certificate overrides with default certificate dir",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					certificate: "platform"
+				}
+
+				android_app_certificate {
+					name: "new_certificate",
+					certificate: "cert/new_cert",
+				}
+			`,
+			certificateOverride:   "foo:new_certificate",
+			defaultCertificateDir: "vendor/keys",
+			expected:              "cert/new_cert.x509.pem cert/new_cert.pk8",
+		},
+		{
+			name: "default certificate takes precedence over default certificate dir",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+					certificate: "platform"
+				}
+			`,
+			defaultCertificateDir: "vendor/keys",
+			defaultCertificate:    "device/keys/devkey",
+			expected:              "device/keys/platform.x509.pem device/keys/platform.pk8",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(nil)
+			if test.certificateOverride != "" {
+				config.TestProductVariables.CertificateOverrides = []string{test.certificateOverride}
+			}
+			if test.defaultCertificateDir != "" {
+				config.TestProductVariables.DefaultAppCertificateDir = proptools.StringPtr(test.defaultCertificateDir)
+			}
+			if test.defaultCertificate != "" {
+				config.TestProductVariables.DefaultAppCertificate = proptools.StringPtr(test.defaultCertificate)
+			}
+			ctx := testAppContext(config, test.bp, nil)
+
+			run(t, ctx, config)
+			foo := ctx.ModuleForTests("foo", "android_common")
+
+			signapk := foo.Output("foo.apk")
+			signFlags := signapk.Args["certificates"]
+			if test.expected != signFlags {
+				t.Errorf("Incorrect signing flags, expected: %q, got: %q", test.expected, signFlags)
+			}
+		})
+	}
+}
+
+func TestTestHelperAppCertificateFromTest(t *testing.T) {
+	bp := `
+		android_test {
+			name: "test",
+			srcs: ["a.java"],
+			certificate: ":test_certificate",
+		}
+
+		android_test_helper_app {
+			name: "helper",
+			srcs: ["a.java"],
+			certificate_from_test: "test",
+		}
+
+		android_app_certificate {
+			name: "test_certificate",
+			certificate: "cert/test_cert",
+		}
+
+		android_app_certificate {
+			name: "new_certificate",
+			certificate: "cert/new_cert",
+		}
+	`
+
+	testCases := []struct {
+		name                string
+		certificateOverride string
+		expected            string
+	}{
+		{
+			name:     "test certificate",
+			expected: "cert/test_cert.x509.pem cert/test_cert.pk8",
+		},
+		{
+			name:                "test certificate override",
+			certificateOverride: "test:new_certificate",
+			expected:            "cert/new_cert.x509.pem cert/new_cert.pk8",
+		},
+		{
+			name:                "helper certificate override",
+			certificateOverride: "helper:new_certificate",
+			expected:            "cert/new_cert.x509.pem cert/new_cert.pk8",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(nil)
+			if test.certificateOverride != "" {
+				config.TestProductVariables.CertificateOverrides = []string{test.certificateOverride}
+			}
+			ctx := testAppContext(config, bp, nil)
+			run(t, ctx, config)
+
+			helper := ctx.ModuleForTests("helper", "android_common")
+			if g := helper.Output("helper.apk").Args["certificates"]; g != test.expected {
+				t.Errorf("expected helper signing flags %q, got %q", test.expected, g)
+			}
+		})
+	}
+
+	testJavaError(t, `certificate_from_test: can't be combined with certificate`, `
+		android_test {
+			name: "test",
+			srcs: ["a.java"],
+		}
+
+		android_test_helper_app {
+			name: "helper",
+			srcs: ["a.java"],
+			certificate: "platform",
+			certificate_from_test: "test",
+		}
+	`)
+
+	testJavaError(t, `certificate_from_test: "app" must be an android_test module`, `
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+		}
+
+		android_test_helper_app {
+			name: "helper",
+			srcs: ["a.java"],
+			certificate_from_test: "app",
+		}
+	`)
+}
+
+func TestPresignedApp(t *testing.T) {
+	ctx := testJava(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			certificate: "PRESIGNED",
+			package_splits: ["v4"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+
+	if rule := foo.MaybeRule("signapk"); rule.Rule != nil {
+		t.Errorf("expected PRESIGNED app not to be signed, got signapk of %q", rule.Output.String())
+	}
+
+	for _, apk := range []string{"foo.apk", "foo_v4.apk"} {
+		align := foo.Output(apk)
+		if align.Rule != zipalign {
+			t.Errorf("expected %s to be zip-aligned, got rule %v", apk, align.Rule)
+		}
+		unsignedApk := strings.TrimSuffix(apk, ".apk") + "-unsigned.apk"
+		if g, w := align.Input.String(), foo.Output(unsignedApk).Output.String(); g != w {
+			t.Errorf("expected %s to be aligned from %q, got %q", apk, w, g)
+		}
+	}
+
+	module := foo.Module().(*AndroidApp)
+	list, err := module.OutputFiles(".external_signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := foo.Output("external_signing.txt").Args["content"]
+	if len(list) != 1 || !strings.HasSuffix(list[0].String(), "external_signing.txt") {
+		t.Errorf("expected .external_signing output external_signing.txt, got %q", list)
+	}
+	for _, w := range []string{"foo.apk:", "/system/app/foo/foo.apk", "foo_v4.apk:", "/system/app/foo/foo_v4.apk"} {
+		if !strings.Contains(content, w) {
+			t.Errorf("expected external signing list to contain %q, got %q", w, content)
+		}
+	}
+
+	var metadata android.ModuleMetadata
+	module.ModuleMetadata(&metadata)
+	if g, w := metadata.Certificate, "PRESIGNED"; g != w {
+		t.Errorf("expected metadata certificate %q, got %q", w, g)
+	}
+
+	testJavaError(t, `additional_certificates: can't be specified for PRESIGNED apps`, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			certificate: "PRESIGNED",
+			additional_certificates: [":new_certificate"],
+		}
+
+		android_app_certificate {
+			name: "new_certificate",
+			certificate: "cert/new_cert",
+		}
+	`)
+}
+
+func TestFsverityMetadata(t *testing.T) {
+	ctx := testJava(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			package_splits: ["v4"],
+			certificate: "PRESIGNED",
+			fsverity_metadata: true,
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+
+		android_app_import {
+			name: "baz",
+			apk: "prebuilts/apk/app.apk",
+			split_apks: ["prebuilts/apk/config.xxhdpi.apk"],
+			presigned: true,
+			fsverity_metadata: true,
+		}
+	`)
+
+	testCases := []struct {
+		name string
+		apks []string
+	}{
+		{"foo", []string{"foo.apk", "foo_v4.apk"}},
+		{"baz", []string{"baz.apk", "baz_config.xxhdpi.apk"}},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			variant := ctx.ModuleForTests(test.name, "android_common")
+
+			for _, apk := range test.apks {
+				// The metadata has to match the installed apk, not the one built before it is signed.
+				installed := filepath.Join(buildDir, "target/product/test_device/system/app", test.name, apk)
+				metadata := variant.Output("fsverity/" + apk + ".fsv_meta")
+				if g, w := metadata.Input.String(), installed; g != w {
+					t.Errorf("expected fs-verity metadata of %q, got %q", w, g)
+				}
+				variant.Output(installed + ".fsv_meta")
+			}
+
+			var builtInstalled string
+			switch m := variant.Module().(type) {
+			case *AndroidApp:
+				builtInstalled = m.dexpreopter.builtInstalled
+			case *AndroidAppImport:
+				builtInstalled = m.dexpreopter.builtInstalled
+			}
+			for _, ext := range []string{".odex.fsv_meta", ".vdex.fsv_meta"} {
+				if !strings.Contains(builtInstalled, "/"+test.name+ext) {
+					t.Errorf("expected %s to be installed with the dexpreopt files, got %q", ext, builtInstalled)
+				}
+			}
+		})
+	}
+
+	if rule := ctx.ModuleForTests("bar", "android_common").MaybeRule("fsverityMetadata"); rule.Rule != nil {
+		t.Errorf("expected no fs-verity metadata without fsverity_metadata")
+	}
+}
+
+func TestApkProvenance(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_app_import {
+			name: "bar",
+			apk: "prebuilts/apk/app.apk",
+			presigned: true,
+		}
+	`
+
+	config := testConfig(map[string]string{"SOONG_GENERATE_APK_PROVENANCE": "true"})
+	ctx := testContext(config, bp, nil)
+	ctx.RegisterSingletonType("apk_provenance", android.SingletonFactoryAdaptor(apkProvenanceSingletonFactory))
+	run(t, ctx, config)
+
+	provenance := ctx.SingletonForTests("apk_provenance")
+
+	foo := ctx.ModuleForTests("foo", "android_common").Module().(*AndroidApp)
+	fooStatement := provenance.Output("apk_provenance/system/app/foo/foo.apk.json")
+	if g, w := fooStatement.Input.String(), foo.outputFile.String(); g != w {
+		t.Errorf("expected the statement of foo to be generated from %q, got %q", w, g)
+	}
+	if g, w := fooStatement.Args["installPath"], "/system/app/foo/foo.apk"; g != w {
+		t.Errorf("expected install path %q, got %q", w, g)
+	}
+	if g, w := fooStatement.Args["certificates"], "--certificate build/make/target/product/security/testkey.x509.pem"; g != w {
+		t.Errorf("expected certificates %q, got %q", w, g)
+	}
+	if g, w := fooStatement.Args["inputs"], "--input "+foo.exportPackage.String(); !strings.HasPrefix(g, w) {
+		t.Errorf("expected inputs to start with %q, got %q", w, g)
+	}
+	if fooStatement.Args["presigned"] != "" {
+		t.Errorf("expected foo not to be presigned")
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common").Module().(*AndroidAppImport)
+	barStatement := provenance.Output("apk_provenance/system/app/bar/bar.apk.json")
+	if g, w := barStatement.Input.String(), bar.outputFile.String(); g != w {
+		t.Errorf("expected the statement of bar to be generated from %q, got %q", w, g)
+	}
+	if g, w := barStatement.Args["inputs"], "--input prebuilts/apk/app.apk"; g != w {
+		t.Errorf("expected inputs %q, got %q", w, g)
+	}
+	if g, w := barStatement.Args["presigned"], "--presigned"; g != w {
+		t.Errorf("expected presigned flag %q, got %q", w, g)
+	}
+
+	phony := provenance.Output("apk-provenance")
+	if !android.InList(fooStatement.Output.String(), phony.Inputs.Strings()) ||
+		!android.InList(barStatement.Output.String(), phony.Inputs.Strings()) {
+		t.Errorf("expected apk-provenance to depend on all statements, got %q", phony.Inputs.Strings())
+	}
+}
+
+func TestApkProvenanceDisabled(t *testing.T) {
+	config := testConfig(nil)
+	ctx := testContext(config, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+	`, nil)
+	ctx.RegisterSingletonType("apk_provenance", android.SingletonFactoryAdaptor(apkProvenanceSingletonFactory))
+	run(t, ctx, config)
+
+	if rule := ctx.SingletonForTests("apk_provenance").MaybeRule("apkProvenance"); rule.Rule != nil {
+		t.Errorf("expected no provenance statements without SOONG_GENERATE_APK_PROVENANCE")
+	}
+}
+
+func TestAppArtifactMetadata(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			version_code: 3,
+			version_name: "1.0",
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			package_name: "com.android.bar",
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["a.java"],
+			package_name: "com.android.baz",
+			version_name_with_build_number: true,
+		}
+
+		android_app_import {
+			name: "qux",
+			apk: "prebuilts/apk/app.apk",
+			certificate: "testkey",
+		}
+
+		android_app_import {
+			name: "quux",
+			apk: "prebuilts/apk/app.apk",
+			presigned: true,
+		}
+	`
+
+	config := testConfig(map[string]string{"SOONG_GENERATE_ARTIFACT_METADATA": "true"})
+	ctx := testContext(config, bp, map[string][]byte{
+		"AndroidManifest.xml": []byte(`<manifest package="com.android.foo"></manifest>`),
+		"build/make/target/product/security/testkey.x509.pem": []byte(
+			"-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"),
+	})
+	ctx.RegisterSingletonType("artifact_metadata",
+		android.SingletonFactoryAdaptor(android.ArtifactMetadataSingletonFactory))
+	run(t, ctx, config)
+
+	// The version name of baz reads the build number, so the metadata is written to a template that the build
+	// number is substituted into.
+	singleton := ctx.SingletonForTests("artifact_metadata")
+	buildNumberFile := config.BuildNumberFile(android.PathContextForTesting(config, nil))
+	if g, w := singleton.Output("artifact_metadata.json").Implicits.Strings(),
+		buildNumberFile.String(); !inList(w, g) {
+		t.Errorf("expected artifact_metadata.json to depend on %q, got %q", w, g)
+	}
+	content := android.ContentFromFileRuleForTests(t, singleton.Output("artifact_metadata.json.in"))
+	var file struct {
+		SchemaVersion int                        `json:"schema_version"`
+		Artifacts     []android.ArtifactMetadata `json:"artifacts"`
+	}
+	if err := json.Unmarshal([]byte(content), &file); err != nil {
+		t.Fatal(err)
+	}
+
+	if file.SchemaVersion != android.ArtifactMetadataSchemaVersion {
+		t.Errorf("expected schema version %d, got %d", android.ArtifactMetadataSchemaVersion, file.SchemaVersion)
+	}
+
+	// The SHA-256 digest of the 3 zero bytes encoded by the certificate.
+	digest := "709e80c88487a2411e1ee4dfb9f22a861492d20c4765150c0c794abd70f8147c"
+
+	// The certificates of the presigned apk are read from its signature when the build runs.
+	quux := ctx.ModuleForTests("quux", "android_common")
+	quuxDigests := quux.Rule("apkCertificateDigests")
+	if g, w := quuxDigests.Input.String(), quux.Module().(*AndroidAppImport).outputFile.String(); g != w {
+		t.Errorf("expected the certificate digests of %q, got %q", w, g)
+	}
+	if !inList(quuxDigests.Output.String(), singleton.Output("artifact_metadata.json").Implicits.Strings()) {
+		t.Errorf("expected artifact_metadata.json to depend on %q", quuxDigests.Output.String())
+	}
+	want := []android.ArtifactMetadata{
+		{
+			Module:             "bar",
+			Kind:               "apk",
+			Path:               "/system/app/bar/bar.apk",
+			PackageName:        "com.android.bar",
+			VersionCode:        config.PlatformSdkVersion(),
+			VersionName:        config.AppsDefaultVersionName(),
+			CertificateDigests: []string{digest},
+		},
+		{
+			Module:             "baz",
+			Kind:               "apk",
+			Path:               "/system/app/baz/baz.apk",
+			PackageName:        "com.android.baz",
+			VersionCode:        config.PlatformSdkVersion(),
+			VersionName:        config.PlatformVersionName() + "-" + android.ArtifactMetadataBuildNumber,
+			CertificateDigests: []string{digest},
+		},
+		{
+			Module:             "foo",
+			Kind:               "apk",
+			Path:               "/system/app/foo/foo.apk",
+			PackageName:        "com.android.foo",
+			VersionCode:        "3",
+			VersionName:        "1.0",
+			CertificateDigests: []string{digest},
+		},
+		{
+			Module:             "quux",
+			Kind:               "apk",
+			Path:               "/system/app/quux/quux.apk",
+			CertificateDigests: []string{"@CERTIFICATE_DIGESTS:" + quuxDigests.Output.String() + "@"},
+		},
+		{
+			Module:             "qux",
+			Kind:               "apk",
+			Path:               "/system/app/qux/qux.apk",
+			CertificateDigests: []string{digest},
+		},
+	}
+	if !reflect.DeepEqual(file.Artifacts, want) {
+		t.Errorf("want %#v, got %#v", want, file.Artifacts)
+	}
+}
+
+func TestPackageNameOverride(t *testing.T) {
+	testCases := []struct {
+		name                string
+		bp                  string
+		packageNameOverride string
+		expected            []string
+	}{
+		{
+			name: "default",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+				}
+			`,
+			packageNameOverride: "",
+			expected: []string{
+				buildDir + "/.intermediates/foo/android_common/foo.apk",
+				buildDir + "/target/product/test_device/system/app/foo/foo.apk",
+			},
+		},
+		{
+			name: "overridden",
+			bp: `
+				android_app {
+					name: "foo",
+					srcs: ["a.java"],
+				}
+			`,
+			packageNameOverride: "foo:bar",
+			expected: []string{
+				// The package apk should be still be the original name for test dependencies.
+				buildDir + "/.intermediates/foo/android_common/foo.apk",
+				buildDir + "/target/product/test_device/system/app/bar/bar.apk",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(nil)
+			if test.packageNameOverride != "" {
+				config.TestProductVariables.PackageNameOverrides = []string{test.packageNameOverride}
+			}
+			ctx := testAppContext(config, test.bp, nil)
+
+			run(t, ctx, config)
+			foo := ctx.ModuleForTests("foo", "android_common")
+
+			outputs := foo.AllOutputs()
+			outputMap := make(map[string]bool)
+			for _, o := range outputs {
+				outputMap[o] = true
+			}
+			for _, e := range test.expected {
+				if _, exist := outputMap[e]; !exist {
+					t.Errorf("Can't find %q in output files.\nAll outputs:%v", e, outputs)
+				}
+			}
+		})
+	}
+}
+
+func TestInstrumentationTargetOverridden(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_test {
+			name: "bar",
+			instrumentation_for: "foo",
+		}
+		`
+	config := testConfig(nil)
+	config.TestProductVariables.ManifestPackageNameOverrides = []string{"foo:org.dandroid.bp"}
+	ctx := testAppContext(config, bp, nil)
+
+	run(t, ctx, config)
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	res := bar.Output("package-res.apk")
+	aapt2Flags := res.Args["flags"]
+	e := "--rename-instrumentation-target-package org.dandroid.bp"
+	if !strings.Contains(aapt2Flags, e) {
+		t.Errorf("target package renaming flag, %q is missing in aapt2 link flags, %q", e, aapt2Flags)
+	}
+}
+
+func TestInstrumentationForLibrary(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_sdk_library {
+			name: "baz",
+			srcs: ["a.java"],
+			api_packages: ["baz"],
+		}
+
+		android_test {
+			name: "bar",
+			srcs: ["b.java"],
+			instrumentation_for: "foo",
+		}
+
+		android_test {
+			name: "qux",
+			srcs: ["b.java"],
+			instrumentation_for: "baz",
+		}
+
+		android_test {
+			name: "quux",
+			srcs: ["b.java"],
+			instrumentation_for: "foo",
+			strict_java_deps: "error",
+		}
+
+		android_test {
+			name: "corge",
+			srcs: ["b.java"],
+			instrumentation_for: "baz",
+			strict_java_deps: "error",
+		}
+		`
+	config := testConfig(nil)
+	config.TestProductVariables.ManifestPackageNameOverrides = []string{"foo:org.dandroid.foo"}
+	ctx := testAppContext(config, bp, nil)
+
+	run(t, ctx, config)
+
+	testCases := []struct {
+		test, instrumented string
+	}{
+		{"bar", "foo"},
+		{"qux", "baz"},
+		// The classpath is replaced with the direct dependencies when strict deps are enforced.
+		{"quux", "foo"},
+		{"corge", "baz"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.test, func(t *testing.T) {
+			instrumented := ctx.ModuleForTests(test.instrumented, "android_common").Module().(Dependency)
+			implementationJar := instrumented.ImplementationJars()[0].String()
+
+			module := ctx.ModuleForTests(test.test, "android_common")
+			if classpath := module.Rule("javac").Args["classpath"]; !strings.Contains(classpath, implementationJar) {
+				t.Errorf("expected classpath %q to contain %q", classpath, implementationJar)
+			}
+			if combined := module.MaybeRule("combineJar"); android.InList(implementationJar, combined.Inputs.Strings()) {
+				t.Errorf("expected %q not to be linked statically into %q", implementationJar, combined.Output)
+			}
+		})
+	}
+
+	aapt2Flags := ctx.ModuleForTests("bar", "android_common").Output("package-res.apk").Args["flags"]
+	if e := "--rename-instrumentation-target-package org.dandroid.foo"; !strings.Contains(aapt2Flags, e) {
+		t.Errorf("target package renaming flag, %q is missing in aapt2 link flags, %q", e, aapt2Flags)
+	}
+
+	testJavaError(t, `instrumentation_for: ".*baz" has no implementation classes to instrument`, `
+		java_sdk_library_import {
+			name: "baz",
+			jars: ["a.jar"],
+		}
+
+		android_test {
+			name: "bar",
+			srcs: ["b.java"],
+			instrumentation_for: "baz",
+		}
+	`)
+}
+
+func TestAndroidTestData(t *testing.T) {
+	bp := `
+		filegroup {
+			name: "foo-data",
+			srcs: ["testdata/fg.txt"],
+		}
+
+		android_test {
+			name: "foo",
+			srcs: ["a.java"],
+			data: [
+				"testdata/data.txt",
+				":foo-data",
+			],
+		}
+		`
+	config := testConfig(nil)
+	ctx := testAppContext(config, bp, map[string][]byte{
+		"testdata/data.txt": nil,
+		"testdata/fg.txt":   nil,
+	})
+
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common").Module().(*AndroidTest)
+
+	expected := []string{"testdata/data.txt", "testdata/fg.txt"}
+	if g, w := foo.data.Strings(), expected; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected data %q, got %q", w, g)
+	}
+
+	var mk strings.Builder
+	for _, extra := range foo.AndroidMk().Extra {
+		extra(&mk, nil)
+	}
+	if g, w := mk.String(), "LOCAL_TEST_DATA := :testdata/data.txt :testdata/fg.txt\n"; !strings.Contains(g, w) {
+		t.Errorf("expected androidmk to contain %q, got:\n%s", w, g)
+	}
+}
+
+func TestAndroidTestOptions(t *testing.T) {
+	ctx := testJava(t, `
+		android_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				timeout: "10m",
+				unit_test: true,
+				tags: ["smoke", "presubmit"],
+				shards: 4,
+			},
+		}
+
+		android_test_helper_app {
+			name: "bar",
+			srcs: ["a.java"],
+			test_options: {
+				tags: ["helper"],
+			},
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	fooInfo := foo.Provider(android.TestInfoProvider).(android.TestInfo)
+	if fooInfo.Timeout != "10m" || !fooInfo.UnitTest || fooInfo.Shards != 4 {
+		t.Errorf("unexpected test info %#v", fooInfo)
+	}
+	if g, w := fooInfo.Tags, []string{"smoke", "presubmit"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected tags %q, got %q", w, g)
+	}
+	fooConfig := foo.Output("foo.config")
+	if g, w := fooConfig.Args["extraConfigs"], `'<option name="shard-count" value="4" />'`; g != w {
+		t.Errorf("expected test config extra configs %q, got %q", w, g)
+	}
+
+	barInfo := ctx.ModuleForTests("bar", "android_common").Provider(android.TestInfoProvider).(android.TestInfo)
+	if g, w := barInfo.Tags, []string{"helper"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected tags %q, got %q", w, g)
+	}
+
+	testJavaError(t, `test_options.timeout: must be a positive duration`, `
+		android_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				timeout: "forever",
+			},
+		}
+		`)
+
+	testJavaError(t, `test_options.shards: must be at least 1`, `
+		android_test_helper_app {
+			name: "foo",
+			srcs: ["a.java"],
+			test_options: {
+				shards: 0,
+			},
+		}
+		`)
+}
+
+func TestAndroidTestConfig(t *testing.T) {
+	bp := `
+		android_test {
+			name: "foo",
+			srcs: ["a.java"],
+			test_config: "foo/AndroidTest.xml",
+			package_name: "com.android.foo.tests",
+		}
+
+		android_test {
+			name: "bar",
+			srcs: ["a.java"],
+			auto_gen_config: false,
+		}
+
+		android_test {
+			name: "baz",
+			srcs: ["a.java"],
+		}
+
+		java_test {
+			name: "qux",
+			srcs: ["a.java"],
+			auto_gen_config: false,
+		}
+		`
+	config := testConfig(nil)
+	ctx := testAppContext(config, bp, map[string][]byte{
+		"foo/AndroidTest.xml": nil,
+	})
+
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	check := foo.Rule("checkInstrumentationTestConfig")
+	if g, w := check.Input.String(), "foo/AndroidTest.xml"; g != w {
+		t.Errorf("expected test config check input %q, got %q", w, g)
+	}
+	if g, w := check.Args["manifest"], foo.Module().(*AndroidTest).manifestPath.String(); g != w {
+		t.Errorf("expected test config check manifest %q, got %q", w, g)
+	}
+	if g, w := check.Args["apk"], "foo.apk"; g != w {
+		t.Errorf("expected test config check apk %q, got %q", w, g)
+	}
+	if g, w := foo.Module().(*AndroidTest).testConfig, check.Output; g != w {
+		t.Errorf("expected test config %q, got %q", w, g)
+	}
+
+	if bar := ctx.ModuleForTests("bar", "android_common").Module().(*AndroidTest); bar.testConfig != nil {
+		t.Errorf("expected no test config for bar, got %q", bar.testConfig)
+	}
+
+	baz := ctx.ModuleForTests("baz", "android_common")
+	if g, w := baz.Module().(*AndroidTest).testConfig, baz.Output("baz.config").Output; g != w {
+		t.Errorf("expected test config %q, got %q", w, g)
+	}
+	if check := baz.MaybeRule("checkInstrumentationTestConfig"); check.Rule != nil {
+		t.Errorf("expected no test config check for an autogenerated test config")
+	}
+
+	if qux := ctx.ModuleForTests("qux", "android_common").Module().(*Test); qux.testConfig != nil {
+		t.Errorf("expected no test config for qux, got %q", qux.testConfig)
+	}
+}
+
+func TestAndroidTestDefaultTestSuites(t *testing.T) {
+	bp := `
+		android_test {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		android_test {
+			name: "bar",
+			srcs: ["a.java"],
+			test_suites: ["cts"],
+		}
+
+		android_test {
+			name: "baz",
+			srcs: ["a.java"],
+			use_default_test_suites: false,
+		}
+		`
+
+	config := testConfig(nil)
+	config.TestProductVariables.DefaultTestSuites = []string{"device-tests"}
+	ctx := testAppContext(config, bp, nil)
+	run(t, ctx, config)
+
+	testCases := []struct {
+		name               string
+		expectedTestSuites []string
+		usesDefault        bool
+	}{
+		{
+			name:               "foo",
+			expectedTestSuites: []string{"device-tests"},
+			usesDefault:        true,
+		},
+		{
+			name:               "bar",
+			expectedTestSuites: []string{"cts"},
+		},
+		{
+			name: "baz",
+		},
+	}
+
+	for _, test := range testCases {
+		m := ctx.ModuleForTests(test.name, "android_common").Module().(*AndroidTest)
+		if !reflect.DeepEqual(m.testSuites, test.expectedTestSuites) {
+			t.Errorf("%s: expected test suites %q, got %q", test.name, test.expectedTestSuites, m.testSuites)
+		}
+		if m.usesDefaultTestSuites != test.usesDefault {
+			t.Errorf("%s: expected usesDefaultTestSuites %t, got %t", test.name, test.usesDefault,
+				m.usesDefaultTestSuites)
+		}
+	}
+
+	report := ctx.SingletonForTests("default_test_suites").Output("default_test_suites/default_test_suites.txt")
+	if g, w := report.Args["content"], "foo"; g != w {
+		t.Errorf("expected default test suites report %q, got %q", w, g)
+	}
+
+	// Without product default test suites nothing changes and no report is generated.
+	config = testConfig(nil)
+	ctx = testAppContext(config, bp, nil)
+	run(t, ctx, config)
+
+	if foo := ctx.ModuleForTests("foo", "android_common").Module().(*AndroidTest); foo.testSuites != nil {
+		t.Errorf("expected no test suites for foo, got %q", foo.testSuites)
+	}
+	if report := ctx.SingletonForTests("default_test_suites").MaybeOutput("default_test_suites/default_test_suites.txt"); report.Rule != nil {
+		t.Errorf("expected no default test suites report")
+	}
+}
+
+func TestOverrideAndroidApp(t *testing.T) {
+	ctx := testJava(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			certificate: "expiredkey",
+			overrides: ["qux"],
+		}
+
+		override_android_app {
+			name: "bar",
+			base: "foo",
+			certificate: ":new_certificate",
+		}
+
+		android_app_certificate {
+			name: "new_certificate",
+			certificate: "cert/new_cert",
+		}
+
+		override_android_app {
+			name: "baz",
+			base: "foo",
+			package_name: "org.dandroid.bp",
+		}
+		`)
+
+	expectedVariants := []struct {
+		moduleName  string
+		variantName string
+		apkName     string
+		apkPath     string
+		signFlag    string
+		overrides   []string
+		aaptFlag    string
+	}{
+		{
+			moduleName:  "foo",
+			variantName: "android_common",
+			apkPath:     "/target/product/test_device/system/app/foo/foo.apk",
+			signFlag:    "build/make/target/product/security/expiredkey.x509.pem build/make/target/product/security/expiredkey.pk8",
+			overrides:   []string{"qux"},
+			aaptFlag:    "",
+		},
+		{
+			moduleName:  "bar",
+			variantName: "android_common_bar",
+			apkPath:     "/target/product/test_device/system/app/bar/bar.apk",
+			signFlag:    "cert/new_cert.x509.pem cert/new_cert.pk8",
+			overrides:   []string{"qux", "foo"},
+			aaptFlag:    "",
+		},
+		{
+			moduleName:  "baz",
+			variantName: "android_common_baz",
+			apkPath:     "/target/product/test_device/system/app/baz/baz.apk",
+			signFlag:    "build/make/target/product/security/expiredkey.x509.pem build/make/target/product/security/expiredkey.pk8",
+			overrides:   []string{"qux", "foo"},
+			aaptFlag:    "--rename-manifest-package org.dandroid.bp",
+		},
+	}
+	for _, expected := range expectedVariants {
+		variant := ctx.ModuleForTests("foo", expected.variantName)
+
+		// Check the final apk name
+		outputs := variant.AllOutputs()
+		expectedApkPath := buildDir + expected.apkPath
+		found := false
+		for _, o := range outputs {
+			if o == expectedApkPath {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Can't find %q in output files.\nAll outputs:%v", expectedApkPath, outputs)
+		}
+
+		// Check the certificate paths
+		signapk := variant.Output("foo.apk")
+		signFlag := signapk.Args["certificates"]
+		if expected.signFlag != signFlag {
+			t.Errorf("Incorrect signing flags, expected: %q, got: %q", expected.signFlag, signFlag)
+		}
+
+		// Check if the overrides field values are correctly aggregated.
+		mod := variant.Module().(*AndroidApp)
+		if !reflect.DeepEqual(expected.overrides, mod.appProperties.Overrides) {
+			t.Errorf("Incorrect overrides property value, expected: %q, got: %q",
+				expected.overrides, mod.appProperties.Overrides)
+		}
+
+		// Check the package renaming flag, if exists.
+		res := variant.Output("package-res.apk")
+		aapt2Flags := res.Args["flags"]
+		if !strings.Contains(aapt2Flags, expected.aaptFlag) {
+			t.Errorf("package renaming flag, %q is missing in aapt2 link flags, %q", expected.aaptFlag, aapt2Flags)
+		}
+	}
+}
+
+func TestOverrideAndroidAppDependency(t *testing.T) {
+	ctx := testJava(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		override_android_app {
+			name: "bar",
+			base: "foo",
+			package_name: "org.dandroid.bp",
+		}
+
+		android_test {
+			name: "baz",
+			srcs: ["b.java"],
+			instrumentation_for: "foo",
+		}
+
+		android_test {
+			name: "qux",
+			srcs: ["b.java"],
+			instrumentation_for: "bar",
+		}
+		`)
+
+	// Verify baz, which depends on the overridden module foo, has the correct classpath javac arg.
+	javac := ctx.ModuleForTests("baz", "android_common").Rule("javac")
+	fooTurbine := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "turbine-combined", "foo.jar")
+	if !strings.Contains(javac.Args["classpath"], fooTurbine) {
+		t.Errorf("baz classpath %v does not contain %q", javac.Args["classpath"], fooTurbine)
+	}
+
+	// Verify qux, which depends on the overriding module bar, has the correct classpath javac arg.
+	javac = ctx.ModuleForTests("qux", "android_common").Rule("javac")
+	barTurbine := filepath.Join(buildDir, ".intermediates", "foo", "android_common_bar", "turbine-combined", "foo.jar")
+	if !strings.Contains(javac.Args["classpath"], barTurbine) {
+		t.Errorf("qux classpath %v does not contain %q", javac.Args["classpath"], barTurbine)
+	}
+}
+
+func TestAppGeneratedSrcJar(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java", "bar-doc/IFoo.aidl"],
+			plugins: ["bar"],
+			sdk_version: "current",
+		}
+
+		java_plugin {
+			name: "bar",
+			processor_class: "com.bar",
+			srcs: ["b.java"],
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+
+	javac := foo.Rule("javac")
+	annoSrcJar := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "javac", "anno.srcjar")
+	if !android.InList(annoSrcJar, javac.ImplicitOutputs.Strings()) {
+		t.Errorf("javac implicit outputs %v does not contain %q", javac.ImplicitOutputs, annoSrcJar)
+	}
+	if javac.Args["annoSrcJar"] != annoSrcJar {
+		t.Errorf("javac annoSrcJar %q != %q", javac.Args["annoSrcJar"], annoSrcJar)
+	}
+
+	javaSrcJar := foo.Output("gen-srcs/java.srcjar")
+	aidlJava := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "gen", "aidl", "bar-doc", "IFoo.java")
+	if !android.InList(aidlJava, javaSrcJar.Implicits.Strings()) {
+		t.Errorf("generated java srcjar inputs %v does not contain %q", javaSrcJar.Implicits, aidlJava)
+	}
+
+	generatedSrcJar := foo.Output("gen-srcs/foo-gen.srcjar")
+	expectedInputs := []string{
+		javaSrcJar.Output.String(),
+		filepath.Join(buildDir, ".intermediates", "foo", "android_common", "gen", "R.jar"),
+		annoSrcJar,
+	}
+	if !reflect.DeepEqual(expectedInputs, generatedSrcJar.Inputs.Strings()) {
+		t.Errorf("generated srcjar inputs, expected: %q, got: %q", expectedInputs, generatedSrcJar.Inputs.Strings())
+	}
+
+	outputFiles, err := foo.Module().(*AndroidApp).OutputFiles(".generated_srcjar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputFiles) != 1 || outputFiles[0] != generatedSrcJar.Output {
+		t.Errorf("expected .generated_srcjar output %q, got %q", generatedSrcJar.Output, outputFiles)
+	}
+}
+
+func TestAppManifestMerger(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["lib"],
+			sdk_version: "current",
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			static_libs: ["lib"],
+			sdk_version: "current",
+			dont_merge_manifests: true,
+		}
+
+		android_library {
+			name: "lib",
+			srcs: ["b.java"],
+			sdk_version: "current",
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	merger := foo.Output("manifest_merger/manifest-merger-report.txt")
+	report := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "manifest_merger",
+		"manifest-merger-report.txt")
+	if merger.Args["report"] != report {
+		t.Errorf("expected manifest merger report %q, got %q", report, merger.Args["report"])
+	}
+
+	outputFiles, err := foo.Module().(*AndroidApp).OutputFiles(".manifest_merger_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputFiles) != 1 || outputFiles[0].String() != report {
+		t.Errorf("expected .manifest_merger_report output %q, got %q", report, outputFiles)
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	if bar.MaybeOutput("manifest_merger/AndroidManifest.xml").Rule != nil {
+		t.Errorf("expected manifests of bar not to be merged")
+	}
+
+	outputFiles, err = bar.Module().(*AndroidApp).OutputFiles(".manifest_merger_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputFiles) != 0 {
+		t.Errorf("expected no .manifest_merger_report output, got %q", outputFiles)
+	}
+}
+
+func TestDesugarReport(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			min_sdk_version: "21",
+			desugar_report: {
+				enabled: true,
+			},
+		}
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			optimize: {
+				enabled: false,
+			},
+			desugar_report: {
+				enabled: true,
+				error_on_missing_classes: true,
+			},
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "lib",
+			srcs: ["a.java"],
+			installable: true,
+		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	report := foo.Output("desugar_report.txt")
+	if report.Rule != foo.Rule("r8").Rule {
+		t.Errorf("expected the desugar report of foo to be written by r8, got %v", report.Rule)
+	}
+	if g, w := report.Args["desugarMinSdkVersion"], "21"; g != w {
+		t.Errorf("expected desugar min_sdk_version %q, got %q", w, g)
+	}
+	if g := report.Args["desugarReportFlags"]; g != "" {
+		t.Errorf("expected the desugar report of foo to only report missing classes, got flags %q", g)
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	report = bar.Output("desugar_report.txt")
+	if report.Rule != bar.Rule("d8").Rule {
+		t.Errorf("expected the desugar report of bar to be written by d8, got %v", report.Rule)
+	}
+	if g, w := report.Args["desugarReportFlags"], "--error-on-missing-classes"; g != w {
+		t.Errorf("expected desugar report flags %q, got %q", w, g)
+	}
+	dependsOnReportCmd := func(implicits android.Paths) bool {
+		for _, implicit := range implicits {
+			if strings.HasSuffix(implicit.String(), "/bin/desugar_report") {
+				return true
+			}
+		}
+		return false
+	}
+	if !dependsOnReportCmd(report.Implicits) {
+		t.Errorf("expected the desugar report tool in the implicits of bar, got %q", report.Implicits.Strings())
+	}
+
+	baz := ctx.ModuleForTests("baz", "android_common")
+	if report := baz.MaybeOutput("desugar_report.txt"); report.Rule != nil {
+		t.Errorf("expected no desugar report for baz, got %v", report.Rule)
+	}
+	if implicits := baz.Rule("r8").Implicits; dependsOnReportCmd(implicits) {
+		t.Errorf("expected no dependency on the desugar report tool without a report, got %q", implicits.Strings())
+	}
+
+	lib := ctx.ModuleForTests("lib", "android_common")
+	if report := lib.Rule("d8").Args["desugarReport"]; report != "" {
+		t.Errorf("expected no desugar report for lib, got %q", report)
+	}
+}
+
+func TestUncompressDex(t *testing.T) {
+	testCases := []struct {
+		name      string
+		bp        string
+		unbundled bool
+
+		uncompressed bool
 	}{
 		{
 			name: "default",
@@ -873,306 +3315,311 @@ func TestCertificates(t *testing.T) {
 					srcs: ["a.java"],
 				}
 			`,
-			certificateOverride: "",
-			expected:            "build/make/target/product/security/testkey.x509.pem build/make/target/product/security/testkey.pk8",
-		},
-		{
-			name: "module certificate property",
-			bp: `
-				android_app {
-					name: "foo",
-					srcs: ["a.java"],
-					certificate: ":new_certificate"
-				}
-
-				android_app_certificate {
-					name: "new_certificate",
-			    certificate: "cert/new_cert",
-				}
-			`,
-			certificateOverride: "",
-			expected:            "cert/new_cert.x509.pem cert/new_cert.pk8",
-		},
-		{
-			name: "path certificate property",
-			bp: `
-				android_app {
-					name: "foo",
-					srcs: ["a.java"],
-					certificate: "expiredkey"
-				}
-			`,
-			certificateOverride: "",
-			expected:            "build/make/target/product/security/expiredkey.x509.pem build/make/target/product/security/expiredkey.pk8",
+			uncompressed: true,
 		},
 		{
-			name: "certificate overrides",
+			name: "uncompress_dex false",
 			bp: `
 				android_app {
 					name: "foo",
 					srcs: ["a.java"],
-					certificate: "expiredkey"
-				}
-
-				android_app_certificate {
-					name: "new_certificate",
-			    certificate: "cert/new_cert",
+					uncompress_dex: false,
 				}
 			`,
-			certificateOverride: "foo:new_certificate",
-			expected:            "cert/new_cert.x509.pem cert/new_cert.pk8",
+			uncompressed: false,
 		},
-	}
-
-	for _, test := range testCases {
-		t.Run(test.name, func(t *testing.T) {
-			config := testConfig(nil)
-			if test.certificateOverride != "" {
-				config.TestProductVariables.CertificateOverrides = []string{test.certificateOverride}
-			}
-			ctx := testAppContext(config, test.bp, nil)
-
-			run(t, ctx, config)
-			foo := ctx.ModuleForTests("foo", "android_common")
-
-			signapk := foo.Output("foo.apk")
-			signFlags := signapk.Args["certificates"]
-			if test.expected != signFlags {
-				t.Errorf("Incorrect signing flags, expected: %q, got: %q", test.expected, signFlags)
-			}
-		})
-	}
-}
-
-func TestPackageNameOverride(t *testing.T) {
-	testCases := []struct {
-		name                string
-		bp                  string
-		packageNameOverride string
-		expected            []string
-	}{
 		{
-			name: "default",
+			name: "unbundled",
 			bp: `
 				android_app {
 					name: "foo",
 					srcs: ["a.java"],
 				}
 			`,
-			packageNameOverride: "",
-			expected: []string{
-				buildDir + "/.intermediates/foo/android_common/foo.apk",
-				buildDir + "/target/product/test_device/system/app/foo/foo.apk",
-			},
+			unbundled:    true,
+			uncompressed: false,
 		},
 		{
-			name: "overridden",
+			name: "unbundled uncompress_dex true",
 			bp: `
 				android_app {
 					name: "foo",
 					srcs: ["a.java"],
+					uncompress_dex: true,
 				}
 			`,
-			packageNameOverride: "foo:bar",
-			expected: []string{
-				// The package apk should be still be the original name for test dependencies.
-				buildDir + "/.intermediates/foo/android_common/foo.apk",
-				buildDir + "/target/product/test_device/system/app/bar/bar.apk",
-			},
+			unbundled:    true,
+			uncompressed: true,
 		},
 	}
 
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			config := testConfig(nil)
-			if test.packageNameOverride != "" {
-				config.TestProductVariables.PackageNameOverrides = []string{test.packageNameOverride}
+			config := testConfig(nil)
+			if test.unbundled {
+				config.TestProductVariables.Unbundled_build = proptools.BoolPtr(true)
 			}
-			ctx := testAppContext(config, test.bp, nil)
-
+			ctx := testContext(config, test.bp, nil)
 			run(t, ctx, config)
-			foo := ctx.ModuleForTests("foo", "android_common")
 
-			outputs := foo.AllOutputs()
-			outputMap := make(map[string]bool)
-			for _, o := range outputs {
-				outputMap[o] = true
+			foo := ctx.ModuleForTests("foo", "android_common")
+			if got := foo.Module().(*AndroidApp).deviceProperties.UncompressDex; got != test.uncompressed {
+				t.Errorf("expected uncompressed dex %v, got %v", test.uncompressed, got)
 			}
-			for _, e := range test.expected {
-				if _, exist := outputMap[e]; !exist {
-					t.Errorf("Can't find %q in output files.\nAll outputs:%v", e, outputs)
-				}
+
+			aligned := foo.MaybeOutput("aligned/foo.jar").Rule != nil
+			if aligned != test.uncompressed {
+				t.Errorf("expected aligned dex jar %v, got %v", test.uncompressed, aligned)
 			}
 		})
 	}
+
+	testJavaError(t, `uncompress_dex: must not be false when use_embedded_dex is true`, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			use_embedded_dex: true,
+			uncompress_dex: false,
+		}
+	`)
 }
 
-func TestInstrumentationTargetOverridden(t *testing.T) {
-	bp := `
+func TestAppDefaultsTargetProperties(t *testing.T) {
+	ctx := testApp(t, `
+		java_defaults {
+			name: "app_defaults",
+			target: {
+				android: {
+					use_embedded_native_libs: true,
+				},
+				host: {
+					dex_preopt: {
+						enabled: false,
+					},
+				},
+			},
+		}
+
+		java_defaults {
+			name: "no_dexpreopt_defaults",
+			target: {
+				android: {
+					dex_preopt: {
+						enabled: false,
+					},
+				},
+			},
+		}
+
 		android_app {
 			name: "foo",
 			srcs: ["a.java"],
+			defaults: ["app_defaults"],
 		}
 
-		android_test {
+		android_app {
 			name: "bar",
-			instrumentation_for: "foo",
+			srcs: ["a.java"],
+			defaults: ["no_dexpreopt_defaults"],
 		}
-		`
-	config := testConfig(nil)
-	config.TestProductVariables.ManifestPackageNameOverrides = []string{"foo:org.dandroid.bp"}
-	ctx := testAppContext(config, bp, nil)
+	`)
 
-	run(t, ctx, config)
+	foo := ctx.ModuleForTests("foo", "android_common")
+	if !Bool(foo.Module().(*AndroidApp).appProperties.Use_embedded_native_libs) {
+		t.Errorf("expected target.android properties from defaults to set use_embedded_native_libs")
+	}
+	if foo.MaybeDescription("dexpreopt").Rule == nil {
+		t.Errorf("expected target.host properties from defaults not to disable dexpreopt")
+	}
 
 	bar := ctx.ModuleForTests("bar", "android_common")
-	res := bar.Output("package-res.apk")
-	aapt2Flags := res.Args["flags"]
-	e := "--rename-instrumentation-target-package org.dandroid.bp"
-	if !strings.Contains(aapt2Flags, e) {
-		t.Errorf("target package renaming flag, %q is missing in aapt2 link flags, %q", e, aapt2Flags)
+	if bar.MaybeDescription("dexpreopt").Rule != nil {
+		t.Errorf("expected target.android properties from defaults to disable dexpreopt")
 	}
 }
 
-func TestOverrideAndroidApp(t *testing.T) {
-	ctx := testJava(t, `
+func TestAppManifestFixerArgs(t *testing.T) {
+	ctx := testApp(t, `
 		android_app {
 			name: "foo",
 			srcs: ["a.java"],
-			certificate: "expiredkey",
-			overrides: ["qux"],
+			sdk_version: "current",
+			logging_parent: "com.android.bar",
+			test_only: true,
+			override_min_sdk_version: true,
 		}
 
-		override_android_app {
+		android_app {
 			name: "bar",
-			base: "foo",
-			certificate: ":new_certificate",
+			srcs: ["a.java"],
+			sdk_version: "current",
 		}
+	`)
 
-		android_app_certificate {
-			name: "new_certificate",
-			certificate: "cert/new_cert",
-		}
+	checks := []string{
+		"--logging-parent com.android.bar",
+		"--test-only",
+		"--override-min-sdk-version",
+	}
 
-		override_android_app {
-			name: "baz",
-			base: "foo",
-			package_name: "org.dandroid.bp",
+	foo := ctx.ModuleForTests("foo", "android_common").Rule("manifestFixer").RuleParams.Command
+	for _, w := range checks {
+		if !strings.Contains(foo, w) {
+			t.Errorf("expected %q in manifest_fixer command of foo, got %q", w, foo)
 		}
-		`)
+	}
 
-	expectedVariants := []struct {
-		moduleName  string
-		variantName string
-		apkName     string
-		apkPath     string
-		signFlag    string
-		overrides   []string
-		aaptFlag    string
-	}{
-		{
-			moduleName:  "foo",
-			variantName: "android_common",
-			apkPath:     "/target/product/test_device/system/app/foo/foo.apk",
-			signFlag:    "build/make/target/product/security/expiredkey.x509.pem build/make/target/product/security/expiredkey.pk8",
-			overrides:   []string{"qux"},
-			aaptFlag:    "",
-		},
-		{
-			moduleName:  "bar",
-			variantName: "android_common_bar",
-			apkPath:     "/target/product/test_device/system/app/bar/bar.apk",
-			signFlag:    "cert/new_cert.x509.pem cert/new_cert.pk8",
-			overrides:   []string{"qux", "foo"},
-			aaptFlag:    "",
-		},
-		{
-			moduleName:  "baz",
-			variantName: "android_common_baz",
-			apkPath:     "/target/product/test_device/system/app/baz/baz.apk",
-			signFlag:    "build/make/target/product/security/expiredkey.x509.pem build/make/target/product/security/expiredkey.pk8",
-			overrides:   []string{"qux", "foo"},
-			aaptFlag:    "--rename-manifest-package org.dandroid.bp",
-		},
+	bar := ctx.ModuleForTests("bar", "android_common").Rule("manifestFixer").RuleParams.Command
+	for _, w := range checks {
+		if strings.Contains(bar, w) {
+			t.Errorf("unexpected %q in manifest_fixer command of bar, got %q", w, bar)
+		}
 	}
-	for _, expected := range expectedVariants {
-		variant := ctx.ModuleForTests("foo", expected.variantName)
+}
 
-		// Check the final apk name
-		outputs := variant.AllOutputs()
-		expectedApkPath := buildDir + expected.apkPath
-		found := false
-		for _, o := range outputs {
-			if o == expectedApkPath {
-				found = true
-				break
-			}
+func TestProguardUsage(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
 		}
-		if !found {
-			t.Errorf("Can't find %q in output files.\nAll outputs:%v", expectedApkPath, outputs)
+
+		android_app {
+			name: "bar",
+			srcs: ["a.java"],
+			optimize: {
+				shrink: false,
+			},
 		}
 
-		// Check the certificate paths
-		signapk := variant.Output("foo.apk")
-		signFlag := signapk.Args["certificates"]
-		if expected.signFlag != signFlag {
-			t.Errorf("Incorrect signing flags, expected: %q, got: %q", expected.signFlag, signFlag)
+		android_app {
+			name: "baz",
+			srcs: ["a.java"],
+			optimize: {
+				enabled: false,
+			},
 		}
 
-		// Check if the overrides field values are correctly aggregated.
-		mod := variant.Module().(*AndroidApp)
-		if !reflect.DeepEqual(expected.overrides, mod.appProperties.Overrides) {
-			t.Errorf("Incorrect overrides property value, expected: %q, got: %q",
-				expected.overrides, mod.appProperties.Overrides)
+		android_app {
+			name: "qux",
+			srcs: ["a.java"],
 		}
 
-		// Check the package renaming flag, if exists.
-		res := variant.Output("package-res.apk")
-		aapt2Flags := res.Args["flags"]
-		if !strings.Contains(aapt2Flags, expected.aaptFlag) {
-			t.Errorf("package renaming flag, %q is missing in aapt2 link flags, %q", expected.aaptFlag, aapt2Flags)
+		override_android_app {
+			name: "quux",
+			base: "qux",
 		}
+	`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	r8 := foo.Rule("r8")
+	fooUsage := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "proguard_usage", "foo", "unused.txt")
+	if r8.Args["outUsage"] != fooUsage {
+		t.Errorf("expected r8 usage output %q, got %q", fooUsage, r8.Args["outUsage"])
+	}
+
+	if bar := ctx.ModuleForTests("bar", "android_common"); bar.Rule("r8").Args["outUsage"] == "" {
+		t.Errorf("expected r8 usage output for bar")
+	}
+
+	quux := ctx.ModuleForTests("qux", "android_common_quux")
+	quuxUsage := filepath.Join(buildDir, ".intermediates", "qux", "android_common_quux", "proguard_usage", "quux", "unused.txt")
+	if g := quux.Rule("r8").Args["outUsage"]; g != quuxUsage {
+		t.Errorf("expected r8 usage output %q for the override variant, got %q", quuxUsage, g)
+	}
+
+	merge := ctx.SingletonForTests("proguard_usage").Output("proguard_usage/proguard_usage.zip")
+	expectedInputs := []string{
+		filepath.Join(buildDir, ".intermediates", "foo", "android_common", "proguard_usage.zip"),
+		filepath.Join(buildDir, ".intermediates", "qux", "android_common", "proguard_usage.zip"),
+		filepath.Join(buildDir, ".intermediates", "qux", "android_common_quux", "proguard_usage.zip"),
+	}
+	if !reflect.DeepEqual(expectedInputs, merge.Inputs.Strings()) {
+		t.Errorf("expected proguard usage inputs %q, got %q", expectedInputs, merge.Inputs.Strings())
 	}
 }
 
-func TestOverrideAndroidAppDependency(t *testing.T) {
-	ctx := testJava(t, `
+func TestAppProguardFlags(t *testing.T) {
+	bp := `
 		android_app {
 			name: "foo",
 			srcs: ["a.java"],
+			static_libs: ["android_lib"],
+			optimize: {
+				proguard_flags_files: ["foo.flags"],
+			},
 		}
 
-		override_android_app {
+		android_app {
 			name: "bar",
-			base: "foo",
-			package_name: "org.dandroid.bp",
+			srcs: ["a.java"],
+			optimize: {
+				no_aapt_flags: true,
+				proguard_compatibility: false,
+			},
 		}
 
-		android_test {
-			name: "baz",
+		android_library {
+			name: "android_lib",
 			srcs: ["b.java"],
-			instrumentation_for: "foo",
+			static_libs: ["java_lib"],
+			optimize: {
+				proguard_flags_files: ["android_lib.flags"],
+			},
 		}
 
-		android_test {
-			name: "qux",
-			srcs: ["b.java"],
-			instrumentation_for: "bar",
+		java_library {
+			name: "java_lib",
+			srcs: ["c.java"],
+			optimize: {
+				proguard_flags_files: ["java_lib.flags"],
+			},
 		}
-		`)
+	`
 
-	// Verify baz, which depends on the overridden module foo, has the correct classpath javac arg.
-	javac := ctx.ModuleForTests("baz", "android_common").Rule("javac")
-	fooTurbine := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "turbine-combined", "foo.jar")
-	if !strings.Contains(javac.Args["classpath"], fooTurbine) {
-		t.Errorf("baz classpath %v does not contain %q", javac.Args["classpath"], fooTurbine)
+	config := testConfig(nil)
+	ctx := testAppContext(config, bp, map[string][]byte{
+		"foo.flags":         nil,
+		"android_lib.flags": nil,
+		"java_lib.flags":    nil,
+	})
+	run(t, ctx, config)
+
+	aaptFlags := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "gen", "proguard.options")
+
+	fooR8 := ctx.ModuleForTests("foo", "android_common").Rule("r8")
+	for _, expected := range []string{
+		"-include " + aaptFlags,
+		"-include foo.flags",
+		"-include android_lib.flags",
+		"-include java_lib.flags",
+		"--force-proguard-compatibility",
+	} {
+		if !strings.Contains(fooR8.Args["r8Flags"], expected) {
+			t.Errorf("expected %q in foo r8 flags %q", expected, fooR8.Args["r8Flags"])
+		}
+	}
+	if !inList("java_lib.flags", fooR8.Implicits.Strings()) {
+		t.Errorf("expected java_lib.flags in foo r8 implicits %q", fooR8.Implicits.Strings())
 	}
 
-	// Verify qux, which depends on the overriding module bar, has the correct classpath javac arg.
-	javac = ctx.ModuleForTests("qux", "android_common").Rule("javac")
-	barTurbine := filepath.Join(buildDir, ".intermediates", "foo", "android_common_bar", "turbine-combined", "foo.jar")
-	if !strings.Contains(javac.Args["classpath"], barTurbine) {
-		t.Errorf("qux classpath %v does not contain %q", javac.Args["classpath"], barTurbine)
+	barR8 := ctx.ModuleForTests("bar", "android_common").Rule("r8")
+	for _, unexpected := range []string{
+		"proguard.options",
+		"--force-proguard-compatibility",
+	} {
+		if strings.Contains(barR8.Args["r8Flags"], unexpected) {
+			t.Errorf("unexpected %q in bar r8 flags %q", unexpected, barR8.Args["r8Flags"])
+		}
+	}
+
+	outputFiles, err := ctx.ModuleForTests("foo", "android_common").Module().(*AndroidApp).OutputFiles(".proguard_map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dictionary := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "proguard_dictionary")
+	if len(outputFiles) != 1 || outputFiles[0].String() != dictionary {
+		t.Errorf("expected .proguard_map output files [%q], got %q", dictionary, outputFiles.Strings())
 	}
 }
 
@@ -1196,6 +3643,20 @@ func TestAndroidAppImport(t *testing.T) {
 		t.Errorf("can't find dexpreopt outputs")
 	}
 
+	// Check that the native libraries of the ABIs the device can't run are removed.
+	processed := variant.Output("processed/foo.apk")
+	if g, w := processed.Args["excludeAbis"], "x86 x86_64 mips mips64"; g != w {
+		t.Errorf("expected excluded ABIs %q, got %q", w, g)
+	}
+	if g, w := processed.Args["flags"], "-x 'lib/mips64/**'"; !strings.Contains(g, w) {
+		t.Errorf("expected processing flags %q to contain %q", g, w)
+	}
+	// The dex files are stripped once they are dexpreopted, whether the apk stores them compressed or not.
+	if processed.Args["stripDex"] == "" || processed.Args["uncompressDex"] != "" {
+		t.Errorf("expected the dex files to be stripped, got stripDex %q and uncompressDex %q",
+			processed.Args["stripDex"], processed.Args["uncompressDex"])
+	}
+
 	// Check cert signing flag.
 	signedApk := variant.Output("signed/foo.apk")
 	signingFlag := signedApk.Args["certificates"]
@@ -1245,19 +3706,120 @@ func TestAndroidAppImport_Presigned(t *testing.T) {
 		variant.MaybeOutput("dexpreopt/oat/arm64/package.odex").Rule == nil {
 		t.Errorf("can't find dexpreopt outputs")
 	}
-	// Make sure stripping wasn't done.
-	stripRule := variant.Output("dexpreopt/foo.apk")
-	if !strings.HasPrefix(stripRule.RuleParams.Command, "cp -f") {
-		t.Errorf("unexpected, non-skipping strip command: %q", stripRule.RuleParams.Command)
+	// Make sure stripping wasn't done, and that the apk keeps the libraries of all ABIs.
+	processed := variant.Output("processed/foo.apk")
+	if processed.Args["stripDex"] != "" {
+		t.Errorf("presigned apk shouldn't have its dex stripped")
+	}
+	if g := processed.Args["excludeAbis"]; g != "" {
+		t.Errorf("unexpected excluded ABIs %q", g)
+	}
+
+	// Make sure signing was skipped and aligning was done instead.
+	if variant.MaybeOutput("signed/foo.apk").Rule != nil {
+		t.Errorf("signing rule shouldn't be included.")
+	}
+	if processed.Args["align"] == "" {
+		t.Errorf("presigned apk should be aligned")
+	}
+}
+
+func TestAndroidAppImport_UncompressedDex(t *testing.T) {
+	config := testConfig(nil)
+	config.TestProductVariables.UncompressPrivAppDex = proptools.BoolPtr(true)
+	ctx := testContext(config, `
+		android_app_import {
+			name: "foo",
+			apk: "prebuilts/apk/app.apk",
+			certificate: "platform",
+			privileged: true,
+			dex_preopt: {
+				enabled: true,
+			},
+		}
+		`, nil)
+	run(t, ctx, config)
+
+	// The dex files of privileged apps are kept, and stored uncompressed when the apk compresses them.
+	processed := ctx.ModuleForTests("foo", "android_common").Output("processed/foo.apk")
+	if processed.Args["stripDex"] != "" || processed.Args["uncompressDex"] == "" {
+		t.Errorf("expected the dex files to be uncompressed, got stripDex %q and uncompressDex %q",
+			processed.Args["stripDex"], processed.Args["uncompressDex"])
+	}
+}
+
+func TestAndroidAppImport_PresignedVerification(t *testing.T) {
+	ctx := testJava(t, `
+		android_app_import {
+			name: "foo",
+			apk: "prebuilts/apk/app.apk",
+			presigned: true,
+		}
+
+		android_app_import {
+			name: "bar",
+			apk: "prebuilts/apk/app.apk",
+			presigned: true,
+			min_sdk_version: "30",
+		}
+
+		android_app_import {
+			name: "baz",
+			apk: "prebuilts/apk/app.apk",
+			certificate: "platform",
+		}
+		`)
+
+	testCases := []struct {
+		name          string
+		expectedFlags string
+		minSdkVersion string
+	}{
+		{
+			// The min sdk version in the manifest of the apk is checked at build time.
+			name:          "foo",
+			expectedFlags: "",
+			minSdkVersion: "",
+		},
+		{
+			name:          "bar",
+			expectedFlags: "--min-sdk-version 30",
+			minSdkVersion: "30",
+		},
 	}
 
-	// Make sure signing was skipped and aligning was done instead.
-	if variant.MaybeOutput("signed/foo.apk").Rule != nil {
-		t.Errorf("signing rule shouldn't be included.")
+	for _, test := range testCases {
+		variant := ctx.ModuleForTests(test.name, "android_common")
+		verify := variant.Output("verify_presigned/" + test.name + ".apk")
+		if g, w := verify.Input.String(), "prebuilts/apk/app.apk"; g != w {
+			t.Errorf("%s: expected verify input %q, got %q", test.name, w, g)
+		}
+		if g := verify.Args["flags"]; g != test.expectedFlags {
+			t.Errorf("%s: expected verify flags %q, got %q", test.name, test.expectedFlags, g)
+		}
+		if g := verify.Args["minSdkVersion"]; g != test.minSdkVersion {
+			t.Errorf("%s: expected minSdkVersion %q, got %q", test.name, test.minSdkVersion, g)
+		}
+
+		processed := variant.Output("processed/" + test.name + ".apk")
+		if g, w := processed.Input.String(), verify.Output.String(); g != w {
+			t.Errorf("%s: expected processed input %q, got %q", test.name, w, g)
+		}
 	}
-	if variant.MaybeOutput("zip-aligned/foo.apk").Rule == nil {
-		t.Errorf("can't find aligning rule")
+
+	baz := ctx.ModuleForTests("baz", "android_common")
+	if baz.MaybeOutput("verify_presigned/baz.apk").Rule != nil {
+		t.Errorf("apps that are signed by the build shouldn't be verified")
 	}
+
+	testJavaError(t, `min_sdk_version: min_sdk_version can only be specified for presigned modules`, `
+		android_app_import {
+			name: "foo",
+			apk: "prebuilts/apk/app.apk",
+			certificate: "platform",
+			min_sdk_version: "30",
+		}
+		`)
 }
 
 func TestAndroidAppImport_DpiVariants(t *testing.T) {
@@ -1317,7 +3879,6 @@ func TestAndroidAppImport_DpiVariants(t *testing.T) {
 		},
 	}
 
-	jniRuleRe := regexp.MustCompile("^if \\(zipinfo (\\S+)")
 	for _, test := range testCases {
 		config := testConfig(nil)
 		config.TestProductVariables.AAPTPreferredConfig = test.aaptPreferredConfig
@@ -1327,14 +3888,258 @@ func TestAndroidAppImport_DpiVariants(t *testing.T) {
 		run(t, ctx, config)
 
 		variant := ctx.ModuleForTests("foo", "android_common")
-		jniRuleCommand := variant.Output("jnis-uncompressed/foo.apk").RuleParams.Command
-		matches := jniRuleRe.FindStringSubmatch(jniRuleCommand)
-		if len(matches) != 2 {
-			t.Errorf("failed to extract the src apk path from %q", jniRuleCommand)
+		if g := variant.Output("processed/foo.apk").Input.String(); g != test.expected {
+			t.Errorf("wrong src apk, expected: %q got: %q", test.expected, g)
+		}
+	}
+}
+
+func TestAndroidAppImport_ArchVariants(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bp       string
+		dpi      []string
+		expected string
+	}{
+		{
+			name: "arch matches",
+			bp: `
+				android_app_import {
+					name: "foo",
+					apk: "prebuilts/apk/app.apk",
+					arch: {
+						arm64: {
+							apk: "prebuilts/apk/app_arm64.apk",
+						},
+						x86_64: {
+							apk: "prebuilts/apk/app_x86_64.apk",
+						},
+					},
+					certificate: "PRESIGNED",
+				}
+			`,
+			expected: "prebuilts/apk/app_arm64.apk",
+		},
+		{
+			name: "no matches",
+			bp: `
+				android_app_import {
+					name: "foo",
+					apk: "prebuilts/apk/app.apk",
+					arch: {
+						x86_64: {
+							apk: "prebuilts/apk/app_x86_64.apk",
+						},
+					},
+					certificate: "PRESIGNED",
+				}
+			`,
+			expected: "prebuilts/apk/app.apk",
+		},
+		{
+			name: "dpi variant takes precedence",
+			bp: `
+				android_app_import {
+					name: "foo",
+					apk: "prebuilts/apk/app.apk",
+					arch: {
+						arm64: {
+							apk: "prebuilts/apk/app_arm64.apk",
+						},
+					},
+					dpi_variants: {
+						xhdpi: {
+							apk: "prebuilts/apk/app_xhdpi.apk",
+						},
+					},
+					certificate: "PRESIGNED",
+				}
+			`,
+			dpi:      []string{"xhdpi"},
+			expected: "prebuilts/apk/app_xhdpi.apk",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(nil)
+			config.TestProductVariables.AAPTPrebuiltDPI = test.dpi
+			ctx := testAppContext(config, test.bp, nil)
+
+			run(t, ctx, config)
+
+			variant := ctx.ModuleForTests("foo", "android_common")
+			if g := variant.Output("processed/foo.apk").Input.String(); g != test.expected {
+				t.Errorf("wrong src apk, expected: %q got: %q", test.expected, g)
+			}
+		})
+	}
+}
+
+func TestAndroidAppImport_Splits(t *testing.T) {
+	ctx := testJava(t, `
+		android_app_import {
+			name: "foo",
+			apk: "prebuilts/apk/app.apk",
+			split_apks: [
+				"prebuilts/apk/config.xxhdpi.apk",
+				"prebuilts/apk/config.arm64_v8a.apk",
+			],
+			certificate: "platform",
+		}
+
+		android_app_import {
+			name: "bar",
+			apk: "prebuilts/apk/app.apk",
+			split_apks: ["prebuilts/apk/config.xxhdpi.apk"],
+			presigned: true,
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	for _, suffix := range []string{"config.xxhdpi", "config.arm64_v8a"} {
+		processed := foo.Output("processed/foo_" + suffix + ".apk")
+		if g, w := processed.Input.String(), "prebuilts/apk/"+suffix+".apk"; g != w {
+			t.Errorf("expected split processed input %q, got %q", w, g)
+		}
+
+		signed := foo.Output("signed/foo_" + suffix + ".apk")
+		expected := "build/make/target/product/security/platform.x509.pem build/make/target/product/security/platform.pk8"
+		if g := signed.Args["certificates"]; g != expected {
+			t.Errorf("Incorrect signing flags for split %q, expected: %q, got: %q", suffix, expected, g)
+		}
+
+		installed := filepath.Join(buildDir, "target/product/test_device/system/app/foo/foo_"+suffix+".apk")
+		if !android.InList(installed, foo.AllOutputs()) {
+			t.Errorf("Can't find %q in output files.\nAll outputs:%v", installed, foo.AllOutputs())
+		}
+
+		// Check that the config split, which has no code, is not dexpreopted.
+		if foo.MaybeOutput("dexpreopt/"+suffix+"/oat/arm64/package.odex").Rule != nil {
+			t.Errorf("config split %q shouldn't be dexpreopted", suffix)
+		}
+		builtInstalled := foo.Module().(*AndroidAppImport).dexpreopter.builtInstalled
+		if w := "foo_" + suffix + ".odex"; strings.Contains(builtInstalled, w) {
+			t.Errorf("expected no %q in the installed dexpreopt files, got %q", w, builtInstalled)
+		}
+	}
+	if foo.MaybeOutput("dexpreopt/oat/arm64/package.odex").Rule == nil {
+		t.Errorf("can't find dexpreopt outputs of the base apk")
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	if bar.MaybeOutput("signed/bar_config.xxhdpi.apk").Rule != nil {
+		t.Errorf("presigned split shouldn't be signed")
+	}
+	if bar.Output("processed/bar_config.xxhdpi.apk").Args["align"] == "" {
+		t.Errorf("presigned split should be aligned")
+	}
+	if g, w := bar.Output("verify_presigned/bar_config.xxhdpi.apk").Input.String(),
+		"prebuilts/apk/config.xxhdpi.apk"; g != w {
+		t.Errorf("expected presigned split verify input %q, got %q", w, g)
+	}
+
+	testJavaError(t, `split_apks: item 0: split "a.java" must be an .apk file`, `
+		android_app_import {
+			name: "foo",
+			apk: "prebuilts/apk/app.apk",
+			split_apks: ["a.java"],
+			certificate: "platform",
+		}
+		`)
+}
+
+func TestAndroidAppImport_Overrides(t *testing.T) {
+	bp := `
+		android_app_import {
+			name: "foo",
+			apk: "prebuilts/apk/app.apk",
+			certificate: "platform",
+			overrides: ["baz"],
+		}
+
+		android_app_import {
+			name: "presigned",
+			apk: "prebuilts/apk/app.apk",
+			presigned: true,
 		}
-		if test.expected != matches[1] {
-			t.Errorf("wrong src apk, expected: %q got: %q", test.expected, matches[1])
+
+		android_app_certificate {
+			name: "new_certificate",
+			certificate: "cert/new_cert",
 		}
+		`
+
+	testCases := []struct {
+		name                 string
+		module               string
+		packageNameOverride  string
+		certificateOverride  string
+		expectedInstall      string
+		expectedCertificates string
+		expectedOverrides    []string
+	}{
+		{
+			name:                 "default",
+			module:               "foo",
+			expectedInstall:      "system/app/foo/foo.apk",
+			expectedCertificates: "build/make/target/product/security/platform.x509.pem build/make/target/product/security/platform.pk8",
+			expectedOverrides:    []string{"baz"},
+		},
+		{
+			name:                 "package name override",
+			module:               "foo",
+			packageNameOverride:  "foo:bar",
+			expectedInstall:      "system/app/bar/bar.apk",
+			expectedCertificates: "build/make/target/product/security/platform.x509.pem build/make/target/product/security/platform.pk8",
+			expectedOverrides:    []string{"baz", "foo"},
+		},
+		{
+			name:                 "certificate override",
+			module:               "foo",
+			certificateOverride:  "foo:new_certificate",
+			expectedInstall:      "system/app/foo/foo.apk",
+			expectedCertificates: "cert/new_cert.x509.pem cert/new_cert.pk8",
+			expectedOverrides:    []string{"baz"},
+		},
+		{
+			name:                 "presigned certificate override",
+			module:               "presigned",
+			certificateOverride:  "presigned:new_certificate",
+			expectedInstall:      "system/app/presigned/presigned.apk",
+			expectedCertificates: "cert/new_cert.x509.pem cert/new_cert.pk8",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig(nil)
+			if test.packageNameOverride != "" {
+				config.TestProductVariables.PackageNameOverrides = []string{test.packageNameOverride}
+			}
+			if test.certificateOverride != "" {
+				config.TestProductVariables.CertificateOverrides = []string{test.certificateOverride}
+			}
+			ctx := testAppContext(config, bp, nil)
+
+			run(t, ctx, config)
+			variant := ctx.ModuleForTests(test.module, "android_common")
+
+			expectedInstall := filepath.Join(buildDir, "target/product/test_device", test.expectedInstall)
+			if !android.InList(expectedInstall, variant.AllOutputs()) {
+				t.Errorf("Can't find %q in output files.\nAll outputs:%v", expectedInstall, variant.AllOutputs())
+			}
+
+			signedApk := variant.Output("signed/" + test.module + ".apk")
+			if signFlags := signedApk.Args["certificates"]; test.expectedCertificates != signFlags {
+				t.Errorf("Incorrect signing flags, expected: %q, got: %q", test.expectedCertificates, signFlags)
+			}
+
+			overrides := variant.Module().(*AndroidAppImport).getOverriddenPackages()
+			if !reflect.DeepEqual(test.expectedOverrides, overrides) {
+				t.Errorf("Incorrect overrides, expected: %q, got: %q", test.expectedOverrides, overrides)
+			}
+		})
 	}
 }
 
@@ -1415,6 +4220,13 @@ func TestUsesLibraries(t *testing.T) {
 			name: "bar",
 			srcs: ["a.java"],
 			api_packages: ["bar"],
+			libs: ["qux"],
+		}
+
+		java_sdk_library {
+			name: "qux",
+			srcs: ["a.java"],
+			api_packages: ["qux"],
 		}
 
 		android_app {
@@ -1469,17 +4281,98 @@ func TestUsesLibraries(t *testing.T) {
 		t.Errorf("wanted %q in %q", w, cmd)
 	}
 
-	// Test that only present libraries are preopted
-	cmd = app.Rule("dexpreopt").RuleParams.Command
+	// Test that only present libraries are preopted, with the nested contexts of the libraries they use
+	wantContexts := []string{
+		`--target-context-for-sdk any foo 'PCL[/system/framework/foo.jar]'`,
+		`--target-context-for-sdk any bar 'PCL[/system/framework/bar.jar]{PCL[/system/framework/qux.jar]}'`,
+		`--target-context-for-sdk 28 org.apache.http.legacy 'PCL[/system/framework/org.apache.http.legacy.impl.jar]'`,
+		`--target-context-for-sdk 29 android.hidl.base-V1.0-java 'PCL[/system/framework/android.hidl.base-V1.0-java.jar]'`,
+	}
 
-	if w := `dex_preopt_target_libraries="/system/framework/foo.jar /system/framework/bar.jar"`; !strings.Contains(cmd, w) {
-		t.Errorf("wanted %q in %q", w, cmd)
+	for _, m := range []android.TestingModule{app, prebuilt} {
+		cmd = m.Rule("dexpreopt").RuleParams.Command
+		for _, w := range wantContexts {
+			if !strings.Contains(cmd, w) {
+				t.Errorf("wanted %q in %q", w, cmd)
+			}
+		}
+		if w := "baz"; strings.Contains(cmd, "--target-context-for-sdk any "+w) {
+			t.Errorf("unexpected context for missing library %q in %q", w, cmd)
+		}
 	}
+}
 
-	cmd = prebuilt.Rule("dexpreopt").RuleParams.Command
+func TestExtractUsesLibraries(t *testing.T) {
+	bp := `
+		java_sdk_library {
+			name: "foo",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+		}
 
-	if w := `dex_preopt_target_libraries="/system/framework/foo.jar /system/framework/bar.jar"`; !strings.Contains(cmd, w) {
-		t.Errorf("wanted %q in %q", w, cmd)
+		java_sdk_library {
+			name: "bar",
+			srcs: ["a.java"],
+			api_packages: ["bar"],
+		}
+
+		java_sdk_library {
+			name: "baz",
+			srcs: ["a.java"],
+			api_packages: ["baz"],
+		}
+
+		android_app_import {
+			name: "prebuilt",
+			apk: "prebuilts/apk/app.apk",
+			certificate: "platform",
+			extract_uses_libs: true,
+			uses_libs: ["foo"],
+			optional_uses_libs: ["bar"],
+		}
+	`
+
+	config := testConfig(nil)
+	ctx := testAppContext(config, bp, nil)
+	run(t, ctx, config)
+
+	prebuilt := ctx.ModuleForTests("prebuilt", "android_common")
+
+	if verify := prebuilt.MaybeRule("verify_uses_libraries"); verify.Rule != nil {
+		t.Errorf("verify_uses_libraries should not run when extract_uses_libs is set")
+	}
+
+	extract := prebuilt.Rule("extract_uses_libraries")
+	cmd := extract.RuleParams.Command
+	for _, w := range []string{"--known-uses-library bar", "--known-uses-library foo",
+		"--known-uses-library org.apache.http.legacy"} {
+		if !strings.Contains(cmd, w) {
+			t.Errorf("wanted %q in %q", w, cmd)
+		}
+	}
+	// Only the libraries listed in uses_libs and optional_uses_libs can be used by the apk.
+	if w := "--known-uses-library baz"; strings.Contains(cmd, w) {
+		t.Errorf("unexpected %q in %q", w, cmd)
+	}
+
+	usesLibsFile := extract.Output.String()
+	dexpreopt := prebuilt.Rule("dexpreopt")
+	cmd = dexpreopt.RuleParams.Command
+	for _, w := range []string{
+		"--uses-libraries-file " + usesLibsFile,
+		`--target-context-for-sdk any foo 'PCL[/system/framework/foo.jar]'`,
+		`--target-context-for-sdk any bar 'PCL[/system/framework/bar.jar]'`,
+		`--target-context-for-sdk any org.apache.http.legacy 'PCL[/system/framework/org.apache.http.legacy.jar]'`,
+	} {
+		if !strings.Contains(cmd, w) {
+			t.Errorf("wanted %q in %q", w, cmd)
+		}
+	}
+	if w := "--target-context-for-sdk any baz"; strings.Contains(cmd, w) {
+		t.Errorf("unexpected %q in %q", w, cmd)
+	}
+	if !inList(usesLibsFile, dexpreopt.Implicits.Strings()) {
+		t.Errorf("dexpreopt implicits %v does not contain %q", dexpreopt.Implicits.Strings(), usesLibsFile)
 	}
 }
 
@@ -1535,7 +4428,80 @@ func TestCodelessApp(t *testing.T) {
 					name: "lib",
 				}
 			`,
-			// TODO(jungjw): this should probably be true
+			noCode: true,
+		},
+		{
+			name: "app with transitive sourceless libraries",
+			bp: `
+				android_app {
+					name: "foo",
+					static_libs: ["lib"],
+				}
+
+				java_library {
+					name: "lib",
+					static_libs: ["lib2"],
+				}
+
+				java_library {
+					name: "lib2",
+				}
+			`,
+			noCode: true,
+		},
+		{
+			name: "app with kotlin libraries",
+			bp: `
+				android_app {
+					name: "foo",
+					static_libs: ["lib"],
+				}
+
+				java_library {
+					name: "lib",
+					srcs: ["b.kt"],
+				}
+			`,
+			noCode: false,
+		},
+		{
+			name: "app with sourceless libraries with kotlin code",
+			bp: `
+				android_app {
+					name: "foo",
+					static_libs: ["lib"],
+				}
+
+				java_library {
+					name: "lib",
+					static_libs: ["lib2"],
+				}
+
+				java_library {
+					name: "lib2",
+					srcs: ["b.kt"],
+				}
+			`,
+			noCode: false,
+		},
+		{
+			name: "app with sourceless libraries with code",
+			bp: `
+				android_app {
+					name: "foo",
+					static_libs: ["lib"],
+				}
+
+				java_library {
+					name: "lib",
+					static_libs: ["lib2"],
+				}
+
+				java_library {
+					name: "lib2",
+					srcs: ["a.java"],
+				}
+			`,
 			noCode: false,
 		},
 	}
@@ -1545,7 +4511,7 @@ func TestCodelessApp(t *testing.T) {
 			ctx := testApp(t, test.bp)
 
 			foo := ctx.ModuleForTests("foo", "android_common")
-			manifestFixerArgs := foo.Output("manifest_fixer/AndroidManifest.xml").Args["args"]
+			manifestFixerArgs := foo.Output("manifest_fixer/AndroidManifest.xml").RuleParams.Command
 			if strings.Contains(manifestFixerArgs, "--has-no-code") != test.noCode {
 				t.Errorf("unexpected manifest_fixer args: %q", manifestFixerArgs)
 			}
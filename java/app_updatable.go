@@ -0,0 +1,105 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"strings"
+
+	"android/soong/android"
+)
+
+// Apps that set updatable: true are updated independently of the platform, for example as part of
+// a Mainline module, and run on releases other than the one they are built with.  They are checked
+// against all of the updatableAppChecks at once, so that a single error lists everything that has
+// to be fixed.
+//
+// There is no check for a stable apex_available list: apps are not APEX modules and don't have the
+// apex_available property, so the APEXes that include an updatable app are not restricted here.
+
+// updatableAppCheck returns a description of how an updatable app violates one of the constraints
+// on updatable apps, or an empty string if it doesn't.
+type updatableAppCheck func(ctx android.ModuleContext, a *AndroidApp) string
+
+var updatableAppChecks = []updatableAppCheck{
+	checkUpdatableAppMinSdkVersion,
+	checkUpdatableAppSdkVersion,
+	checkUpdatableAppSigning,
+	checkUpdatableAppNativeLibs,
+}
+
+// The first release that verifies APK signature scheme v2 signatures.  Older releases only verify
+// the v1 (JAR) signature.
+const apkSignatureSchemeV2MinSdk = 24
+
+func (a *AndroidApp) checkUpdatable(ctx android.ModuleContext) {
+	if !Bool(a.appProperties.Updatable) {
+		return
+	}
+
+	var violations []string
+	for _, check := range updatableAppChecks {
+		if violation := check(ctx, a); violation != "" {
+			violations = append(violations, violation)
+		}
+	}
+
+	if len(violations) > 0 {
+		ctx.PropertyErrorf("updatable", "updatable apps must fix the following:\n  %s",
+			strings.Join(violations, "\n  "))
+	}
+}
+
+func checkUpdatableAppMinSdkVersion(ctx android.ModuleContext, a *AndroidApp) string {
+	if a.deviceProperties.Min_sdk_version == nil {
+		return "min_sdk_version must be set to the oldest release the app is installed on"
+	}
+	return ""
+}
+
+func checkUpdatableAppSdkVersion(ctx android.ModuleContext, a *AndroidApp) string {
+	if Bool(a.deviceProperties.Platform_apis) {
+		return "platform_apis must not be set, the platform APIs change between releases"
+	}
+	switch v := a.sdkVersion(); v {
+	case "", "core_platform", "test_current":
+		return fmt.Sprintf("sdk_version must be set to a stable SDK, found %q", v)
+	}
+	return ""
+}
+
+func checkUpdatableAppSigning(ctx android.ModuleContext, a *AndroidApp) string {
+	if a.deviceProperties.Min_sdk_version == nil {
+		// Reported by checkUpdatableAppMinSdkVersion.
+		return ""
+	}
+	minSdkVersion, err := sdkVersionToNumber(ctx, a.minSdkVersion())
+	if err != nil {
+		// Reported by the manifest fixer.
+		return ""
+	}
+	if minSdkVersion < apkSignatureSchemeV2MinSdk {
+		return fmt.Sprintf("min_sdk_version must be at least %d so that the APK is verified with the APK "+
+			"signature scheme v2 or later, found %q", apkSignatureSchemeV2MinSdk, a.minSdkVersion())
+	}
+	return ""
+}
+
+func checkUpdatableAppNativeLibs(ctx android.ModuleContext, a *AndroidApp) string {
+	if len(a.appProperties.Jni_libs) > 0 && !Bool(a.appProperties.Use_embedded_native_libs) {
+		return "use_embedded_native_libs must be true so that jni_libs are stored uncompressed in the APK"
+	}
+	return ""
+}
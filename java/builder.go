@@ -49,6 +49,8 @@ var (
 				`-source $javaVersion -target $javaVersion ` +
 				`-d $outDir -s $annoDir @$out.rsp @$srcJarDir/list ; fi ) && ` +
 				`${config.SoongZipCmd} -jar -o $out -C $outDir -D $outDir && ` +
+				`(if [ -n "$annoSrcJar" ] ; then ` +
+				`${config.SoongZipCmd} -jar -o $annoSrcJar -C $annoDir -D $annoDir ; fi ) && ` +
 				`rm -rf "$srcJarDir"`,
 			CommandDeps: []string{
 				"${config.JavacCmd}",
@@ -60,7 +62,36 @@ var (
 			RspfileContent:   "$in",
 		},
 		"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
-		"outDir", "annoDir", "javaVersion")
+		"outDir", "annoDir", "annoSrcJar", "javaVersion")
+
+	// errorprone compiles java sources like javac with the Error Prone plugin, and also writes the
+	// output of javac, which contains the Error Prone findings, to $findings.
+	errorprone = pctx.AndroidStaticRule("errorprone",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" "$annoDir" "$srcJarDir" && mkdir -p "$outDir" "$annoDir" "$srcJarDir" && ` +
+				`${config.ZipSyncCmd} -d $srcJarDir -l $srcJarDir/list -f "*.java" $srcJars && ` +
+				`(if [ -s $srcJarDir/list ] || [ -s $out.rsp ] ; then ` +
+				`${config.SoongJavacWrapper} ${config.JavacWrapper}${config.JavacCmd} ` +
+				`${config.JavacHeapFlags} ${config.JavacVmFlags} ${config.CommonJdkFlags} ` +
+				`$processorpath $processor $javacFlags $bootClasspath $classpath ` +
+				`-source $javaVersion -target $javaVersion ` +
+				`-d $outDir -s $annoDir @$out.rsp @$srcJarDir/list > $findings 2>&1 ; ` +
+				`ret=$$? ; cat $findings ; exit $$ret ; else rm -f $findings && touch $findings ; fi ) && ` +
+				`${config.SoongZipCmd} -jar -o $out -C $outDir -D $outDir && ` +
+				`(if [ -n "$annoSrcJar" ] ; then ` +
+				`${config.SoongZipCmd} -jar -o $annoSrcJar -C $annoDir -D $annoDir ; fi ) && ` +
+				`rm -rf "$srcJarDir"`,
+			CommandDeps: []string{
+				"${config.JavacCmd}",
+				"${config.SoongZipCmd}",
+				"${config.ZipSyncCmd}",
+			},
+			CommandOrderOnly: []string{"${config.SoongJavacWrapper}"},
+			Rspfile:          "$out.rsp",
+			RspfileContent:   "$in",
+		},
+		"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
+		"outDir", "annoDir", "annoSrcJar", "javaVersion", "findings")
 
 	turbine = pctx.AndroidStaticRule("turbine",
 		blueprint.RuleParams{
@@ -82,6 +113,17 @@ var (
 		},
 		"javacFlags", "bootClasspath", "classpath", "srcJars", "outDir", "javaVersion")
 
+	ijar = pctx.AndroidStaticRule("ijar",
+		blueprint.RuleParams{
+			Command: `${config.IjarCmd} $in $out.tmp && ${config.Ziptime} $out.tmp && ` +
+				`(if cmp -s $out.tmp $out ; then rm $out.tmp ; else mv $out.tmp $out ; fi )`,
+			CommandDeps: []string{
+				"${config.IjarCmd}",
+				"${config.Ziptime}",
+			},
+			Restat: true,
+		})
+
 	jar = pctx.AndroidStaticRule("jar",
 		blueprint.RuleParams{
 			Command:        `${config.SoongZipCmd} -jar -o $out @$out.rsp`,
@@ -162,14 +204,17 @@ type javaBuilderFlags struct {
 	errorProneExtraJavacFlags string
 	errorProneProcessorPath   classpath
 
-	kotlincFlags     string
-	kotlincClasspath classpath
+	kotlincFlags         string
+	kotlincClasspath     classpath
+	kaptProcessorOptions string
 
 	proto android.ProtoFlags
 }
 
+// TransformJavaToClasses compiles java sources into .class files.  If any annotation processors are
+// used it also returns a srcjar containing the sources they generated, otherwise it returns nil.
 func TransformJavaToClasses(ctx android.ModuleContext, outputFile android.WritablePath, shardIdx int,
-	srcFiles, srcJars android.Paths, flags javaBuilderFlags, deps android.Paths) {
+	srcFiles, srcJars android.Paths, flags javaBuilderFlags, deps android.Paths) android.Path {
 
 	// Compile java sources into .class files
 	desc := "javac"
@@ -177,10 +222,11 @@ func TransformJavaToClasses(ctx android.ModuleContext, outputFile android.Writab
 		desc += strconv.Itoa(shardIdx)
 	}
 
-	transformJavaToClasses(ctx, outputFile, shardIdx, srcFiles, srcJars, flags, deps, "javac", desc)
+	return transformJavaToClasses(ctx, outputFile, shardIdx, srcFiles, srcJars, flags, deps, nil, "javac", desc)
 }
 
-func RunErrorProne(ctx android.ModuleContext, outputFile android.WritablePath,
+// RunErrorProne compiles java sources with the Error Prone plugin, and writes its findings to findings.
+func RunErrorProne(ctx android.ModuleContext, outputFile, findings android.WritablePath,
 	srcFiles, srcJars android.Paths, flags javaBuilderFlags) {
 
 	flags.processorPath = append(flags.errorProneProcessorPath, flags.processorPath...)
@@ -193,10 +239,26 @@ func RunErrorProne(ctx android.ModuleContext, outputFile android.WritablePath,
 		}
 	}
 
-	transformJavaToClasses(ctx, outputFile, -1, srcFiles, srcJars, flags, nil,
+	transformJavaToClasses(ctx, outputFile, -1, srcFiles, srcJars, flags, nil, findings,
 		"errorprone", "errorprone")
 }
 
+// RunAnnotationProcessors runs the annotation processors over java sources without compiling them.
+// It returns a srcjar containing the sources generated by the processors, and writes the other
+// files they generate, like META-INF/services entries, to outputFile.
+func RunAnnotationProcessors(ctx android.ModuleContext, outputFile android.WritablePath,
+	srcFiles, srcJars android.Paths, flags javaBuilderFlags, deps android.Paths) android.Path {
+
+	if len(flags.javacFlags) > 0 {
+		flags.javacFlags += " -proc:only"
+	} else {
+		flags.javacFlags = "-proc:only"
+	}
+
+	return transformJavaToClasses(ctx, outputFile, -1, srcFiles, srcJars, flags, deps, nil,
+		"javac-apt", "javac-apt")
+}
+
 func TransformJavaToHeaderClasses(ctx android.ModuleContext, outputFile android.WritablePath,
 	srcFiles, srcJars android.Paths, flags javaBuilderFlags) {
 
@@ -231,6 +293,18 @@ func TransformJavaToHeaderClasses(ctx android.ModuleContext, outputFile android.
 	})
 }
 
+// TransformJarToHeaderJar strips the method bodies and private members from the classes in a jar
+// compiled by javac.  The output is only updated when the API of the classes changes, so modules
+// compiling against it are not rebuilt for implementation-only changes.
+func TransformJarToHeaderJar(ctx android.ModuleContext, outputFile android.WritablePath, jar android.Path) {
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        ijar,
+		Description: "ijar",
+		Output:      outputFile,
+		Input:       jar,
+	})
+}
+
 // transformJavaToClasses takes source files and converts them to a jar containing .class files.
 // srcFiles is a list of paths to sources, srcJars is a list of paths to jar files that contain
 // sources.  flags contains various command line flags to be passed to the compiler.
@@ -242,25 +316,13 @@ func TransformJavaToHeaderClasses(ctx android.ModuleContext, outputFile android.
 // this function is called twice in the same module directory.
 func transformJavaToClasses(ctx android.ModuleContext, outputFile android.WritablePath,
 	shardIdx int, srcFiles, srcJars android.Paths,
-	flags javaBuilderFlags, deps android.Paths,
-	intermediatesDir, desc string) {
+	flags javaBuilderFlags, deps android.Paths, errorProneFindings android.WritablePath,
+	intermediatesDir, desc string) android.Path {
 
 	deps = append(deps, srcJars...)
 
-	var bootClasspath string
-	if flags.javaVersion == "1.9" {
-		deps = append(deps, flags.systemModulesDeps...)
-		bootClasspath = flags.systemModules.FormJavaSystemModulesPath("--system=", ctx.Device())
-	} else {
-		deps = append(deps, flags.bootClasspath...)
-		if len(flags.bootClasspath) == 0 && ctx.Device() {
-			// explicitly specify -bootclasspath "" if the bootclasspath is empty to
-			// ensure java does not fall back to the default bootclasspath.
-			bootClasspath = `-bootclasspath ""`
-		} else {
-			bootClasspath = flags.bootClasspath.FormJavaClassPath("-bootclasspath")
-		}
-	}
+	bootClasspath, bootClasspathDeps := javacBootClasspath(ctx, flags)
+	deps = append(deps, bootClasspathDeps...)
 
 	deps = append(deps, flags.classpath...)
 	deps = append(deps, flags.processorPath...)
@@ -279,25 +341,64 @@ func transformJavaToClasses(ctx android.ModuleContext, outputFile android.Writab
 		outDir = filepath.Join(shardDir, outDir)
 		annoDir = filepath.Join(shardDir, annoDir)
 	}
+
+	// Keep the sources generated by annotation processors in a srcjar so that they can be inspected.
+	var annoSrcJar android.Path
+	var annoSrcJarArg string
+	var implicitOutputs android.WritablePaths
+	if flags.processor != "" {
+		annoSrcJarPath := android.PathForModuleOut(ctx, intermediatesDir, annoDir+".srcjar")
+		implicitOutputs = append(implicitOutputs, annoSrcJarPath)
+		annoSrcJar = annoSrcJarPath
+		annoSrcJarArg = annoSrcJarPath.String()
+	}
+
+	args := map[string]string{
+		"javacFlags":    flags.javacFlags,
+		"bootClasspath": bootClasspath,
+		"classpath":     flags.classpath.FormJavaClassPath("-classpath"),
+		"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
+		"processor":     processor,
+		"srcJars":       strings.Join(srcJars.Strings(), " "),
+		"srcJarDir":     android.PathForModuleOut(ctx, intermediatesDir, srcJarDir).String(),
+		"outDir":        android.PathForModuleOut(ctx, intermediatesDir, outDir).String(),
+		"annoDir":       android.PathForModuleOut(ctx, intermediatesDir, annoDir).String(),
+		"annoSrcJar":    annoSrcJarArg,
+		"javaVersion":   flags.javaVersion,
+	}
+
+	rule := javac
+	if errorProneFindings != nil {
+		rule = errorprone
+		implicitOutputs = append(implicitOutputs, errorProneFindings)
+		args["findings"] = errorProneFindings.String()
+	}
+
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        javac,
-		Description: desc,
-		Output:      outputFile,
-		Inputs:      srcFiles,
-		Implicits:   deps,
-		Args: map[string]string{
-			"javacFlags":    flags.javacFlags,
-			"bootClasspath": bootClasspath,
-			"classpath":     flags.classpath.FormJavaClassPath("-classpath"),
-			"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
-			"processor":     processor,
-			"srcJars":       strings.Join(srcJars.Strings(), " "),
-			"srcJarDir":     android.PathForModuleOut(ctx, intermediatesDir, srcJarDir).String(),
-			"outDir":        android.PathForModuleOut(ctx, intermediatesDir, outDir).String(),
-			"annoDir":       android.PathForModuleOut(ctx, intermediatesDir, annoDir).String(),
-			"javaVersion":   flags.javaVersion,
-		},
+		Rule:            rule,
+		Description:     desc,
+		Output:          outputFile,
+		ImplicitOutputs: implicitOutputs,
+		Inputs:          srcFiles,
+		Implicits:       deps,
+		Args:            args,
 	})
+
+	return annoSrcJar
+}
+
+// javacBootClasspath returns the javac argument that sets the bootclasspath or system modules, and
+// the files it depends on.
+func javacBootClasspath(ctx android.ModuleContext, flags javaBuilderFlags) (string, android.Paths) {
+	if flags.javaVersion == "1.9" {
+		return flags.systemModules.FormJavaSystemModulesPath("--system=", ctx.Device()), flags.systemModulesDeps
+	}
+	if len(flags.bootClasspath) == 0 && ctx.Device() {
+		// explicitly specify -bootclasspath "" if the bootclasspath is empty to
+		// ensure java does not fall back to the default bootclasspath.
+		return `-bootclasspath ""`, nil
+	}
+	return flags.bootClasspath.FormJavaClassPath("-bootclasspath"), android.Paths(flags.bootClasspath)
 }
 
 func TransformResourcesToJar(ctx android.ModuleContext, outputFile android.WritablePath,
@@ -0,0 +1,165 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file generates the runtime resource overlays of the apps that set
+// generate_product_characteristics_rro.  Resources can have values that are specific to a class of
+// device, selected with the product attribute of the resource values:
+//
+//   <bool name="is_large_screen" product="tablet">true</bool>
+//   <bool name="is_large_screen" product="default">false</bool>
+//
+// Apps are normally linked with --product PRODUCT_CHARACTERISTICS, which bakes the values of the
+// device class into the APK.  An app that sets generate_product_characteristics_rro is linked with
+// all the values instead, and a runtime resource overlay of the app is linked from the same
+// resources with --product PRODUCT_CHARACTERISTICS, so the APK is the same for every device class
+// and only the overlay installed on the product partition differs.
+//
+// The overlay only contains the resources of the app that have product specific values, extracted
+// from the values files of each resource directory of the app, so that it doesn't overlay the
+// resources that are the same on every device class.  The static libraries of the app are linked
+// as shared libraries of the overlay so that the overlaid values can reference their resources.
+// The overlay is installed in the overlay directory of the product partition, the only place static
+// runtime resource overlays are scanned from.
+//
+// The modules are created by a mutator that runs after the defaults have been applied, so that
+// resource_dirs, static_libs and sdk_version set by java_defaults modules are taken into account.
+
+import (
+	"fmt"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+	"android/soong/genrule"
+)
+
+func init() {
+	android.PreArchMutators(RegisterCharacteristicsRROMutator)
+}
+
+func RegisterCharacteristicsRROMutator(ctx android.RegisterMutatorsContext) {
+	ctx.TopDown("characteristics_rro", characteristicsRROMutator).Parallel()
+}
+
+func characteristicsRROModuleName(appName string) string {
+	return appName + "__auto_generated_characteristics_rro"
+}
+
+// characteristicsRROFactory creates the runtime resource overlay module of an app, an android_app
+// without code that is installed in the overlay directory instead of the app directory.  It is not
+// registered as a module type, the modules are only created by characteristicsRROMutator.
+func characteristicsRROFactory() android.Module {
+	module := AndroidAppFactory().(*AndroidApp)
+	module.characteristicsRRO = true
+	return module
+}
+
+func characteristicsRROMutator(ctx android.TopDownMutatorContext) {
+	if a, ok := ctx.Module().(*AndroidApp); ok && !a.characteristicsRRO {
+		a.generateProductCharacteristicsRRO(ctx)
+	}
+}
+
+// generateProductCharacteristicsRRO creates the runtime resource overlay module of the app, and
+// the genrule modules that generate its manifest from the package name of the app and its
+// resources from the resource directories of the app.
+func (a *AndroidApp) generateProductCharacteristicsRRO(ctx android.TopDownMutatorContext) {
+	if !Bool(a.appProperties.Generate_product_characteristics_rro) {
+		return
+	}
+
+	characteristics := ctx.Config().ProductAAPTCharacteristics()
+	if characteristics == "" {
+		// Without product characteristics the app is linked with the default values, and there
+		// is nothing to overlay.
+		return
+	}
+
+	rroName := characteristicsRROModuleName(a.Name())
+	manifestName := rroName + "_manifest"
+
+	// The package name of the app is read from the APK, as it may be renamed by package_name or
+	// PRODUCT_MANIFEST_PACKAGE_NAME_OVERRIDES.
+	manifestProps := struct {
+		Name  *string
+		Tools []string
+		Srcs  []string
+		Cmd   *string
+		Out   []string
+	}{}
+	manifestProps.Name = proptools.StringPtr(manifestName)
+	manifestProps.Tools = []string{"characteristics_rro_manifest", "aapt2"}
+	manifestProps.Srcs = []string{":" + a.Name() + "{.apk}"}
+	manifestProps.Cmd = proptools.StringPtr(
+		"$(location characteristics_rro_manifest) --aapt $(location aapt2) $(in) $(out)")
+	manifestProps.Out = []string{"AndroidManifest.xml"}
+	ctx.CreateModule(android.ModuleFactoryAdaptor(genrule.GenRuleFactory), &manifestProps)
+
+	// Resource_dirs defaults to res like in aapt2Flags, a missing directory matches no files.
+	resourceDirs := a.aaptProperties.Resource_dirs
+	if resourceDirs == nil {
+		resourceDirs = []string{"res"}
+	}
+
+	// Each resource directory of the app becomes a resource zip of the overlay, in the same order
+	// so that the later directories still overlay the earlier ones.
+	var resourceZips []string
+	for i, dir := range resourceDirs {
+		resourceName := fmt.Sprintf("%s_res_%d", rroName, i)
+		resourceProps := struct {
+			Name  *string
+			Tools []string
+			Srcs  []string
+			Cmd   *string
+			Out   []string
+		}{}
+		resourceProps.Name = proptools.StringPtr(resourceName)
+		resourceProps.Tools = []string{"characteristics_rro_resources"}
+		resourceProps.Srcs = []string{dir + "/values*/*.xml"}
+		resourceProps.Cmd = proptools.StringPtr("$(location characteristics_rro_resources) -o $(out) $(in)")
+		resourceProps.Out = []string{"resources.zip"}
+		ctx.CreateModule(android.ModuleFactoryAdaptor(genrule.GenRuleFactory), &resourceProps)
+
+		resourceZips = append(resourceZips, ":"+resourceName)
+	}
+
+	rroProps := struct {
+		Name             *string
+		Manifest         *string
+		Resource_dirs    []string
+		Resource_zips    []string
+		Libs             []string
+		Aaptflags        []string
+		Sdk_version      *string
+		Product_specific *bool
+	}{}
+	rroProps.Name = proptools.StringPtr(rroName)
+	rroProps.Manifest = proptools.StringPtr(":" + manifestName)
+	rroProps.Resource_dirs = []string{}
+	rroProps.Resource_zips = resourceZips
+	rroProps.Libs = a.properties.Static_libs
+	rroProps.Aaptflags = []string{"--product", characteristics}
+	rroProps.Sdk_version = a.deviceProperties.Sdk_version
+	rroProps.Product_specific = proptools.BoolPtr(true)
+	ctx.CreateModule(android.ModuleFactoryAdaptor(characteristicsRROFactory), &rroProps)
+
+	// Install the overlay whenever the app is installed.
+	ctx.AppendProperties(&struct {
+		Required []string
+	}{
+		Required: []string{rroName},
+	})
+}
@@ -0,0 +1,227 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"android/soong/android"
+	"android/soong/dexpreopt"
+)
+
+// This singleton writes the classpath fragments of the jars installed on the device, in the text format of the
+// classpaths.proto messages read by derive_classpath, so that the runtime classpaths can be derived from what the
+// build installed instead of from lists maintained by hand.  Each partition gets a fragment per classpath the
+// jars installed to it contribute to:
+//
+//   /<partition>/etc/classpaths/bootclasspath.textproto
+//   /<partition>/etc/classpaths/systemserverclasspath.textproto
+//   /<partition>/etc/classpaths/sharedlibraries.textproto
+//
+// and every app with uses_libs or optional_uses_libs gets a fragment listing the locations of its shared libraries
+// next to the other fragments of its partition:
+//
+//   /<partition>/etc/classpaths/apps/<app>.textproto
+//
+// The fragments are installed by Make, which copies the files listed in SOONG_CLASSPATHS_FRAGMENTS.
+
+func init() {
+	android.RegisterSingletonType("classpaths", classpathsSingletonFactory)
+}
+
+const (
+	bootclasspath         = "BOOTCLASSPATH"
+	systemserverclasspath = "SYSTEMSERVERCLASSPATH"
+)
+
+var classpathFragmentFileNames = map[string]string{
+	bootclasspath:         "bootclasspath.textproto",
+	systemserverclasspath: "systemserverclasspath.textproto",
+}
+
+// classpathJar is a jar on a classpath of the device.
+type classpathJar struct {
+	name      string
+	path      string
+	classpath string
+}
+
+// classpathApp is an app with shared libraries.
+type classpathApp struct {
+	name             string
+	path             string
+	usesLibs         []string
+	optionalUsesLibs []string
+}
+
+func classpathsSingletonFactory() android.Singleton {
+	return &classpathsSingleton{}
+}
+
+type classpathsSingleton struct {
+	fragments android.RuleBuilderInstalls
+}
+
+func (c *classpathsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if ctx.Config().UnbundledBuild() {
+		return
+	}
+
+	global := dexpreoptGlobalConfig(ctx)
+
+	// The fragments of each partition, keyed by the name of their file.
+	partitions := make(map[string]map[string][]string)
+	addFragment := func(path, fileName string, entry []string) {
+		partition := classpathPartition(path)
+		if partitions[partition] == nil {
+			partitions[partition] = make(map[string][]string)
+		}
+		partitions[partition][fileName] = append(partitions[partition][fileName], entry...)
+	}
+
+	var jars []classpathJar
+	sharedLibraries := make(map[string]string)
+	// The on-device locations of the libraries that can be in uses_libs, which aren't all java_sdk_library modules.
+	usesLibLocations := make(map[string]string)
+	var apps []classpathApp
+	ctx.VisitAllModules(func(module android.Module) {
+		// Of a source module and its prebuilt, only the one that is installed contributes to the classpaths.
+		if !module.Enabled() || module.Host() || module.IsSkipInstall() {
+			return
+		}
+		if dep, ok := module.(UsesLibraryDependency); ok && dep.DexJarInstallLocation() != "" {
+			usesLibLocations[ctx.ModuleName(module)] = dep.DexJarInstallLocation()
+		}
+		switch m := module.(type) {
+		case *SdkLibrary:
+			if m.installFile != nil {
+				sharedLibraries[ctx.ModuleName(m)] = m.DexJarInstallLocation()
+			}
+		case *Library:
+			if m.installFile == nil {
+				return
+			}
+			name := ctx.ModuleName(m)
+			if android.InList(name, global.BootJars) {
+				jars = append(jars, classpathJar{name, m.DexJarInstallLocation(), bootclasspath})
+			} else if android.InList(name, global.SystemServerJars) {
+				jars = append(jars, classpathJar{name, m.DexJarInstallLocation(), systemserverclasspath})
+			}
+		case *AndroidApp:
+			apps = appendClasspathApp(ctx, apps, m.installApkName, m.dexpreopter.installPath, &m.usesLibrary)
+		case *AndroidAppImport:
+			apps = appendClasspathApp(ctx, apps, m.installApkName, m.dexpreopter.installPath, &m.usesLibrary)
+		}
+	})
+
+	// Keep the order of the jars in the global config, which is the order of the classpaths.
+	sort.SliceStable(jars, func(i, j int) bool {
+		return classpathJarIndex(global, jars[i]) < classpathJarIndex(global, jars[j])
+	})
+	for _, jar := range jars {
+		addFragment(jar.path, classpathFragmentFileNames[jar.classpath], []string{
+			"jars {",
+			fmt.Sprintf("  path: %q", jar.path),
+			"  classpath: " + jar.classpath,
+			"}",
+		})
+	}
+
+	for _, name := range android.SortedStringKeys(sharedLibraries) {
+		path := sharedLibraries[name]
+		addFragment(path, "sharedlibraries.textproto", []string{
+			"shared_libraries {",
+			fmt.Sprintf("  name: %q", name),
+			fmt.Sprintf("  path: %q", path),
+			"}",
+		})
+	}
+
+	for _, app := range apps {
+		entry := []string{fmt.Sprintf("app_path: %q", app.path)}
+		for _, lib := range app.usesLibs {
+			entry = append(entry, usesLibraryEntry(lib, usesLibLocations, false)...)
+		}
+		for _, lib := range app.optionalUsesLibs {
+			entry = append(entry, usesLibraryEntry(lib, usesLibLocations, true)...)
+		}
+		addFragment(app.path, "apps/"+app.name+".textproto", entry)
+	}
+
+	c.fragments = nil
+	for _, partition := range android.SortedStringKeys(partitions) {
+		fragments := partitions[partition]
+		for _, fileName := range android.SortedStringKeys(fragments) {
+			file := android.PathForOutput(ctx, "classpaths", partition, fileName)
+			android.WriteFileRule(ctx, file, strings.Join(fragments[fileName], "\n")+"\n")
+			c.fragments = append(c.fragments, android.RuleBuilderInstall{
+				From: file,
+				To:   "/" + partition + "/etc/classpaths/" + fileName,
+			})
+		}
+	}
+}
+
+func (c *classpathsSingleton) MakeVars(ctx android.MakeVarsContext) {
+	ctx.Strict("SOONG_CLASSPATHS_FRAGMENTS", c.fragments.String())
+}
+
+func appendClasspathApp(ctx android.SingletonContext, apps []classpathApp, name string,
+	installPath android.OutputPath, u *usesLibrary) []classpathApp {
+
+	usesLibs := u.usesLibraryProperties.Uses_libs
+	optionalUsesLibs, _ := android.FilterList(u.usesLibraryProperties.Optional_uses_libs,
+		ctx.Config().MissingUsesLibraries())
+	if len(usesLibs) == 0 && len(optionalUsesLibs) == 0 {
+		return apps
+	}
+	return append(apps, classpathApp{
+		name:             name,
+		path:             android.InstallPathToOnDevicePath(ctx, installPath),
+		usesLibs:         usesLibs,
+		optionalUsesLibs: optionalUsesLibs,
+	})
+}
+
+// usesLibraryEntry returns the entry of the classpath fragment of an app for one of its shared libraries.  The
+// dependencies on uses_libs already fail when a library doesn't exist, a library without a location on the device
+// is left out.
+func usesLibraryEntry(lib string, locations map[string]string, optional bool) []string {
+	path, ok := locations[lib]
+	if !ok {
+		return nil
+	}
+	return []string{
+		"uses_libraries {",
+		fmt.Sprintf("  name: %q", lib),
+		fmt.Sprintf("  path: %q", path),
+		fmt.Sprintf("  optional: %t", optional),
+		"}",
+	}
+}
+
+// classpathPartition returns the partition of an on-device path, like system for /system/framework/foo.jar.
+func classpathPartition(path string) string {
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+func classpathJarIndex(global dexpreopt.GlobalConfig, jar classpathJar) int {
+	if jar.classpath == bootclasspath {
+		return android.IndexList(jar.name, global.BootJars)
+	}
+	return len(global.BootJars) + android.IndexList(jar.name, global.SystemServerJars)
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+	"android/soong/dexpreopt"
+)
+
+func TestClasspathFragments(t *testing.T) {
+	bp := `
+		java_library {
+			name: "framework-foo",
+			srcs: ["a.java"],
+			installable: true,
+		}
+
+		java_library {
+			name: "framework-bar",
+			srcs: ["a.java"],
+			installable: true,
+		}
+
+		java_library {
+			name: "services-foo",
+			srcs: ["a.java"],
+			installable: true,
+			product_specific: true,
+		}
+
+		java_library {
+			name: "other",
+			srcs: ["a.java"],
+			installable: true,
+		}
+
+		java_sdk_library {
+			name: "foo.shared",
+			srcs: ["a.java"],
+			api_packages: ["foo"],
+		}
+
+		android_app {
+			name: "app",
+			srcs: ["a.java"],
+			uses_libs: ["foo.shared"],
+			optional_uses_libs: ["missing"],
+			product_specific: true,
+		}
+
+		android_app {
+			name: "app_without_libs",
+			srcs: ["a.java"],
+		}
+	`
+
+	config := testConfig(nil)
+	config.TestProductVariables.MissingUsesLibraries = []string{"missing"}
+
+	pathCtx := android.PathContextForTesting(config, nil)
+	dexpreoptConfig := dexpreopt.GlobalConfigForTests(pathCtx)
+	dexpreoptConfig.BootJars = []string{"framework-bar", "framework-foo"}
+	dexpreoptConfig.SystemServerJars = []string{"services-foo"}
+	setDexpreoptTestGlobalConfig(config, dexpreoptConfig)
+
+	ctx := testContext(config, bp, nil)
+	ctx.RegisterSingletonType("classpaths", android.SingletonFactoryAdaptor(classpathsSingletonFactory))
+	run(t, ctx, config)
+
+	classpaths := ctx.SingletonForTests("classpaths")
+
+	testCases := []struct {
+		fragment string
+		want     []string
+	}{
+		{
+			fragment: "classpaths/system/bootclasspath.textproto",
+			want: []string{
+				`jars {`,
+				`  path: "/system/framework/framework-bar.jar"`,
+				`  classpath: BOOTCLASSPATH`,
+				`}`,
+				`jars {`,
+				`  path: "/system/framework/framework-foo.jar"`,
+				`  classpath: BOOTCLASSPATH`,
+				`}`,
+			},
+		},
+		{
+			fragment: "classpaths/product/systemserverclasspath.textproto",
+			want: []string{
+				`jars {`,
+				`  path: "/product/framework/services-foo.jar"`,
+				`  classpath: SYSTEMSERVERCLASSPATH`,
+				`}`,
+			},
+		},
+		{
+			fragment: "classpaths/product/apps/app.textproto",
+			want: []string{
+				`app_path: "/product/app/app/app.apk"`,
+				`uses_libraries {`,
+				`  name: "foo.shared"`,
+				`  path: "/system/framework/foo.shared.jar"`,
+				`  optional: false`,
+				`}`,
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		fragment := classpaths.Output(test.fragment)
+		if g, w := android.ContentFromFileRuleForTests(t, fragment), strings.Join(test.want, "\n")+"\n"; g != w {
+			t.Errorf("expected %s content %q, got %q", test.fragment, w, g)
+		}
+	}
+
+	shared := android.ContentFromFileRuleForTests(t, classpaths.Output("classpaths/system/sharedlibraries.textproto"))
+	if w := "  name: \"foo.shared\"\n  path: \"/system/framework/foo.shared.jar\""; !strings.Contains(shared, w) {
+		t.Errorf("expected shared libraries %q to contain %q", shared, w)
+	}
+
+	if fragment := classpaths.MaybeOutput("classpaths/system/apps/app_without_libs.textproto"); fragment.Rule != nil {
+		t.Errorf("expected no classpath fragment for app_without_libs")
+	}
+
+	fragments := classpaths.Singleton().(*classpathsSingleton).fragments.String()
+	for _, test := range testCases {
+		file := classpaths.Output(test.fragment).Output.String()
+		install := strings.TrimPrefix(test.fragment, "classpaths/")
+		install = "/" + strings.Replace(install, "/", "/etc/classpaths/", 1)
+		if w := file + ":" + install; !strings.Contains(fragments, w) {
+			t.Errorf("expected fragments installed by Make %q to contain %q", fragments, w)
+		}
+	}
+}
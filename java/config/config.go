@@ -16,7 +16,6 @@ package config
 
 import (
 	"path/filepath"
-	"runtime"
 	"strings"
 
 	_ "github.com/google/blueprint/bootstrap"
@@ -89,6 +88,7 @@ func init() {
 	pctx.SourcePathVariable("JmodCmd", "${JavaToolchain}/jmod")
 	pctx.SourcePathVariable("JrtFsJar", "${JavaHome}/lib/jrt-fs.jar")
 	pctx.SourcePathVariable("Ziptime", "prebuilts/build-tools/${hostPrebuiltTag}/bin/ziptime")
+	pctx.SourcePathVariable("IjarCmd", "prebuilts/build-tools/${hostPrebuiltTag}/bin/ijar")
 
 	pctx.SourcePathVariable("GenKotlinBuildFileCmd", "build/soong/scripts/gen-kotlin-build-file.sh")
 
@@ -119,6 +119,7 @@ func init() {
 	pctx.HostJavaToolVariable("MetalavaJar", "metalava.jar")
 	pctx.HostJavaToolVariable("DokkaJar", "dokka.jar")
 	pctx.HostJavaToolVariable("JetifierJar", "jetifier.jar")
+	pctx.HostJavaToolVariable("ResourceProcessorBusyBox", "resourceprocessorbusybox.jar")
 
 	pctx.HostBinToolVariable("SoongJavacWrapper", "soong_javac_wrapper")
 	pctx.HostBinToolVariable("DexpreoptGen", "dexpreopt_gen")
@@ -132,12 +133,14 @@ func init() {
 
 	pctx.HostJavaToolVariable("JacocoCLIJar", "jacoco-cli.jar")
 
+	pctx.SourcePathVariable("JavaKytheExtractorJar", "prebuilts/build-tools/common/framework/javac_extractor.jar")
+
 	hostBinToolVariableWithPrebuilt := func(name, prebuiltDir, tool string) {
 		pctx.VariableFunc(name, func(ctx android.PackageVarContext) string {
 			if ctx.Config().UnbundledBuild() || ctx.Config().IsPdkBuild() {
-				return filepath.Join(prebuiltDir, runtime.GOOS, "bin", tool)
+				return filepath.Join(prebuiltDir, ctx.Config().PrebuiltSdkToolsOS(), "bin", tool)
 			} else {
-				return pctx.HostBinToolPath(ctx, tool).String()
+				return pctx.HostNativeBinToolPath(ctx, tool).String()
 			}
 		})
 	}
@@ -146,10 +149,18 @@ func init() {
 
 	pctx.HostBinToolVariable("ManifestCheckCmd", "manifest_check")
 	pctx.HostBinToolVariable("ManifestFixerCmd", "manifest_fixer")
+	pctx.HostBinToolVariable("SuggestJavaDepsCmd", "suggest_java_deps")
+	pctx.HostBinToolVariable("LintProjectXmlCmd", "lint_project_xml")
+	pctx.HostBinToolVariable("GenApkProvenanceCmd", "gen_apk_provenance")
+	pctx.HostBinToolVariable("ResourceApiCmd", "resource_api")
+	pctx.HostBinToolVariable("CheckOverlayableCmd", "check_overlayable")
+	pctx.HostBinToolVariable("CheckResourceConflictsCmd", "check_resource_conflicts")
+	pctx.HostBinToolVariable("DesugarReportCmd", "desugar_report")
+	pctx.SourcePathVariable("LintCmd", "prebuilts/cmdline-tools/tools/bin/lint")
 
 	pctx.HostBinToolVariable("ManifestMergerCmd", "manifest-merger")
 
-	pctx.HostBinToolVariable("ZipAlign", "zipalign")
+	pctx.HostNativeBinToolVariable("ZipAlign", "zipalign")
 
 	pctx.HostBinToolVariable("Class2Greylist", "class2greylist")
 	pctx.HostBinToolVariable("HiddenAPI", "hiddenapi")
@@ -0,0 +1,72 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"sort"
+	"strings"
+
+	"android/soong/android"
+)
+
+// This singleton lists the android_test modules that don't set test_suites and are only installed into the
+// product's default test suites, so that the tests relying on the default can be tracked down and given
+// explicit suites.
+
+func init() {
+	android.RegisterSingletonType("default_test_suites", defaultTestSuitesSingletonFactory)
+}
+
+func defaultTestSuitesSingletonFactory() android.Singleton {
+	return &defaultTestSuitesSingleton{}
+}
+
+type defaultTestSuitesSingleton struct {
+	report android.Path
+}
+
+func (d *defaultTestSuitesSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if len(ctx.Config().DefaultTestSuites()) == 0 {
+		return
+	}
+
+	var modules []string
+	ctx.VisitAllModules(func(module android.Module) {
+		if test, ok := module.(*AndroidTest); ok && test.Enabled() && test.usesDefaultTestSuites {
+			modules = append(modules, ctx.ModuleName(module))
+		}
+	})
+	modules = android.FirstUniqueStrings(modules)
+	sort.Strings(modules)
+
+	report := android.PathForOutput(ctx, "default_test_suites", "default_test_suites.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        android.WriteFile,
+		Description: "default test suites report",
+		Output:      report,
+		Args: map[string]string{
+			"content": strings.Join(modules, "\\n"),
+		},
+	})
+
+	d.report = report
+}
+
+// Export the path to Make so that it can be added to dist.
+func (d *defaultTestSuitesSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if d.report != nil {
+		ctx.Strict("SOONG_DEFAULT_TEST_SUITES_REPORT", d.report.String())
+	}
+}
@@ -22,10 +22,41 @@ import (
 	"android/soong/android"
 )
 
+// singleDexCheck fails the d8 and r8 rules if $singleDex is set and the classes were split into
+// more than one dex file.
+const singleDexCheck = `(if [ -n "$singleDex" ] && [ -e "$outDir/classes2.dex" ] ; then ` +
+	`echo "error: the classes of $singleDex don't fit in a single dex file, but single_dex is set" 1>&2 ; ` +
+	`exit 1 ; fi) && `
+
+// legacyMultidexCheck warns from the d8 and r8 rules if $legacyMultidex is set and the classes fit in
+// classes.dex, in which case the module doesn't need legacy multidex and its main dex rules.
+const legacyMultidexCheck = `(if [ -n "$legacyMultidex" ] && [ ! -e "$outDir/classes2.dex" ] ; then ` +
+	`echo "warning: the classes of $legacyMultidex fit in a single dex file, multidex and main_dex_rules aren't needed" 1>&2 ; ` +
+	`fi) && `
+
+// dexerLog ends the d8 or r8 command that precedes it, after an opening parenthesis, by writing its
+// warnings to $outDir/dexer.log for desugarCheck while still printing them.
+const dexerLog = `2>"$outDir/dexer.log" ; status=$$? ; cat "$outDir/dexer.log" 1>&2 ; exit $$status) && `
+
+// desugarCheck writes the desugar report $desugarReport from the classes that were dexed and the warnings of
+// the dexer.  With --error-on-missing-classes in $desugarReportFlags it fails the d8 and r8 rules if the
+// desugaring of default or static interface methods for $desugarMinSdkVersion needed classes that weren't
+// available, as the desugared classes would fail at runtime.  The report tool is only an implicit dependency
+// of the modules that write a report.
+const desugarCheck = `(if [ -n "$desugarReport" ] ; then ` +
+	`${config.DesugarReportCmd} --min-sdk-version $desugarMinSdkVersion $desugarReportFlags ` +
+	`--classes $in --log "$outDir/dexer.log" --output "$desugarReport" ; fi) && `
+
+// The first API level that loads the dex files after classes.dex natively.
+const nativeMultidexMinSdkVersion = 21
+
 var d8 = pctx.AndroidStaticRule("d8",
 	blueprint.RuleParams{
 		Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
-			`${config.D8Cmd} ${config.DexFlags} --output $outDir $d8Flags $in && ` +
+			`(${config.D8Cmd} ${config.DexFlags} --output $outDir $d8Flags $in ` + dexerLog +
+			desugarCheck +
+			singleDexCheck +
+			legacyMultidexCheck +
 			`${config.SoongZipCmd} $zipFlags -o $outDir/classes.dex.jar -C $outDir -f "$outDir/classes*.dex" && ` +
 			`${config.MergeZipsCmd} -D -stripFile "**/*.class" $out $outDir/classes.dex.jar $in`,
 		CommandDeps: []string{
@@ -34,18 +65,23 @@ var d8 = pctx.AndroidStaticRule("d8",
 			"${config.MergeZipsCmd}",
 		},
 	},
-	"outDir", "d8Flags", "zipFlags")
+	"outDir", "d8Flags", "zipFlags", "singleDex", "legacyMultidex", "desugarReport", "desugarReportFlags",
+	"desugarMinSdkVersion")
 
 var r8 = pctx.AndroidStaticRule("r8",
 	blueprint.RuleParams{
 		Command: `rm -rf "$outDir" && mkdir -p "$outDir" && ` +
 			`rm -f "$outDict" && ` +
-			`${config.R8Cmd} ${config.DexFlags} -injars $in --output $outDir ` +
-			`--force-proguard-compatibility ` +
+			`(${config.R8Cmd} ${config.DexFlags} -injars $in --output $outDir ` +
 			`--no-data-resources ` +
 			`-printmapping $outDict ` +
-			`$r8Flags && ` +
-			`touch "$outDict" && ` +
+			`-printusage $outUsage ` +
+			`$r8Flags ` + dexerLog +
+			`touch "$outDict" "$outUsage" && ` +
+			desugarCheck +
+			singleDexCheck +
+			legacyMultidexCheck +
+			`${config.SoongZipCmd} -o $outUsageZip -C $outUsageDir -f $outUsage && ` +
 			`${config.SoongZipCmd} $zipFlags -o $outDir/classes.dex.jar -C $outDir -f "$outDir/classes*.dex" && ` +
 			`${config.MergeZipsCmd} -D -stripFile "**/*.class" $out $outDir/classes.dex.jar $in`,
 		CommandDeps: []string{
@@ -54,7 +90,8 @@ var r8 = pctx.AndroidStaticRule("r8",
 			"${config.MergeZipsCmd}",
 		},
 	},
-	"outDir", "outDict", "r8Flags", "zipFlags")
+	"outDir", "outDict", "outUsage", "outUsageZip", "outUsageDir", "r8Flags", "zipFlags", "singleDex", "legacyMultidex",
+	"desugarReport", "desugarReportFlags", "desugarMinSdkVersion")
 
 func (j *Module) dexCommonFlags(ctx android.ModuleContext) []string {
 	flags := j.deviceProperties.Dxflags
@@ -82,15 +119,81 @@ func (j *Module) dexCommonFlags(ctx android.ModuleContext) []string {
 	return flags
 }
 
+// usesLegacyMultidex returns true if multidex is enabled and min_sdk_version is below 21, so that the
+// platform only loads classes.dex and the module has to load its other dex files itself.
+func (j *Module) usesLegacyMultidex(ctx android.ModuleContext) bool {
+	multidex := Bool(j.deviceProperties.Multidex.Enabled) ||
+		android.InList("--multi-dex", j.deviceProperties.Dxflags)
+	if !multidex || Bool(j.deviceProperties.Single_dex) {
+		return false
+	}
+
+	minSdkVersion, err := sdkVersionToNumber(ctx, j.minSdkVersion())
+	// The error is reported by dexCommonFlags.
+	return err == nil && minSdkVersion < nativeMultidexMinSdkVersion
+}
+
+// multidexFlags returns the flags that select the classes of classes.dex when the module uses legacy
+// multidex.  Main dex rules that wouldn't be used are reported, they usually mean that min_sdk_version
+// or multidex isn't set the way the module expects.
+func (j *Module) multidexFlags(ctx android.ModuleContext) ([]string, android.Paths) {
+	multidex := Bool(j.deviceProperties.Multidex.Enabled) ||
+		android.InList("--multi-dex", j.deviceProperties.Dxflags)
+	hasMainDexRules := len(j.deviceProperties.Multidex.Main_dex_rules) > 0
+
+	if Bool(j.deviceProperties.Single_dex) {
+		if multidex {
+			ctx.PropertyErrorf("single_dex", "can't be combined with multidex")
+		}
+		return nil, nil
+	}
+
+	if !multidex {
+		if hasMainDexRules {
+			ctx.PropertyErrorf("multidex.main_dex_rules", "can't be set unless multidex is enabled")
+		}
+		return nil, nil
+	}
+
+	minSdkVersion, err := sdkVersionToNumber(ctx, j.minSdkVersion())
+	if err != nil {
+		// The error is reported by dexCommonFlags.
+		return nil, nil
+	}
+
+	if minSdkVersion >= nativeMultidexMinSdkVersion {
+		if hasMainDexRules {
+			ctx.PropertyErrorf("multidex.main_dex_rules",
+				"are only used for legacy multidex, min_sdk_version %d is not below %d", minSdkVersion,
+				nativeMultidexMinSdkVersion)
+		}
+		return nil, nil
+	}
+
+	if len(j.deviceProperties.Multidex.Main_dex_rules) == 0 {
+		ctx.PropertyErrorf("multidex.main_dex_rules",
+			"must be set for legacy multidex, min_sdk_version %d is below %d", minSdkVersion,
+			nativeMultidexMinSdkVersion)
+		return nil, nil
+	}
+
+	mainDexRules := android.PathsForModuleSrc(ctx, j.deviceProperties.Multidex.Main_dex_rules)
+	return []string{android.JoinWithPrefix(mainDexRules.Strings(), "--main-dex-rules ")}, mainDexRules
+}
+
 func (j *Module) d8Flags(ctx android.ModuleContext, flags javaBuilderFlags) ([]string, android.Paths) {
 	d8Flags := j.dexCommonFlags(ctx)
 
 	d8Flags = append(d8Flags, flags.bootClasspath.FormTurbineClasspath("--lib ")...)
 	d8Flags = append(d8Flags, flags.classpath.FormTurbineClasspath("--lib ")...)
 
+	multidexFlags, multidexDeps := j.multidexFlags(ctx)
+	d8Flags = append(d8Flags, multidexFlags...)
+
 	var d8Deps android.Paths
 	d8Deps = append(d8Deps, flags.bootClasspath...)
 	d8Deps = append(d8Deps, flags.classpath...)
+	d8Deps = append(d8Deps, multidexDeps...)
 
 	return d8Flags, d8Deps
 }
@@ -117,9 +220,13 @@ func (j *Module) r8Flags(ctx android.ModuleContext, flags javaBuilderFlags) (r8F
 	r8Flags = append(r8Flags, flags.classpath.FormJavaClassPath("-libraryjars"))
 	r8Flags = append(r8Flags, "-forceprocessing")
 
+	multidexFlags, multidexDeps := j.multidexFlags(ctx)
+	r8Flags = append(r8Flags, multidexFlags...)
+
 	r8Deps = append(r8Deps, proguardRaiseDeps...)
 	r8Deps = append(r8Deps, flags.bootClasspath...)
 	r8Deps = append(r8Deps, flags.classpath...)
+	r8Deps = append(r8Deps, multidexDeps...)
 
 	flagFiles := android.Paths{
 		android.PathForSource(ctx, "build/make/core/proguard.flags"),
@@ -131,9 +238,10 @@ func (j *Module) r8Flags(ctx android.ModuleContext, flags javaBuilderFlags) (r8F
 	}
 
 	flagFiles = append(flagFiles, j.extraProguardFlagFiles...)
-	// TODO(ccross): static android library proguard files
 
-	flagFiles = append(flagFiles, android.PathsForModuleSrc(ctx, j.deviceProperties.Optimize.Proguard_flags_files)...)
+	// The proguard flag files of the module and its static_libs.
+	flagFiles = append(flagFiles, j.exportedProguardFlagFiles...)
+	flagFiles = android.FirstUniquePaths(flagFiles)
 
 	r8Flags = append(r8Flags, android.JoinWithPrefix(flagFiles.Strings(), "-include "))
 	r8Deps = append(r8Deps, flagFiles...)
@@ -144,6 +252,10 @@ func (j *Module) r8Flags(ctx android.ModuleContext, flags javaBuilderFlags) (r8F
 
 	r8Flags = append(r8Flags, j.deviceProperties.Optimize.Proguard_flags...)
 
+	if BoolDefault(opt.Proguard_compatibility, true) {
+		r8Flags = append(r8Flags, "--force-proguard-compatibility")
+	}
+
 	// TODO(ccross): Don't shrink app instrumentation tests by default.
 	if !Bool(opt.Shrink) {
 		r8Flags = append(r8Flags, "-dontshrink")
@@ -182,36 +294,95 @@ func (j *Module) compileDex(ctx android.ModuleContext, flags javaBuilderFlags,
 		zipFlags += " -L 0"
 	}
 
+	singleDex := ""
+	if Bool(j.deviceProperties.Single_dex) {
+		singleDex = ctx.ModuleName()
+	}
+
+	legacyMultidex := ""
+	if j.usesLegacyMultidex(ctx) {
+		legacyMultidex = ctx.ModuleName()
+	}
+
+	var desugarReport android.WritablePath
+	var desugarReportDeps android.Paths
+	desugarReportArg, desugarReportFlags, desugarMinSdkVersion := "", "", ""
+	if j.deviceProperties.DesugarReport {
+		// An invalid min_sdk_version is reported by dexCommonFlags, the report is skipped instead of running the
+		// report tool without one.
+		if minSdkVersion, err := sdkVersionToNumberAsString(ctx, j.minSdkVersion()); err == nil {
+			desugarReport = android.PathForModuleOut(ctx, "desugar_report.txt")
+			desugarReportDeps = append(desugarReportDeps, ctx.Config().HostToolPath(ctx, "desugar_report"))
+			desugarReportArg = desugarReport.String()
+			desugarMinSdkVersion = minSdkVersion
+			if j.deviceProperties.DesugarReportErrors {
+				desugarReportFlags = "--error-on-missing-classes"
+			}
+		}
+	}
+
 	if useR8 {
 		proguardDictionary := android.PathForModuleOut(ctx, "proguard_dictionary")
 		j.proguardDictionary = proguardDictionary
+		// The usage file lists the code removed by R8.  It is zipped under a directory named after the
+		// module so that the zips of all modules can be merged into a single proguard_usage.zip.  The
+		// name of the module is used instead of ctx.ModuleName() so that the variants created by
+		// override modules get their own directories.
+		proguardUsageDir := android.PathForModuleOut(ctx, "proguard_usage")
+		proguardUsage := android.PathForModuleOut(ctx, "proguard_usage", j.Name(), "unused.txt")
+		proguardUsageZip := android.PathForModuleOut(ctx, "proguard_usage.zip")
+		if Bool(j.deviceProperties.Optimize.Shrink) {
+			j.proguardUsageZip = proguardUsageZip
+		}
 		r8Flags, r8Deps := j.r8Flags(ctx, flags)
+		implicitOutputs := android.WritablePaths{proguardDictionary, proguardUsage, proguardUsageZip}
+		if desugarReport != nil {
+			implicitOutputs = append(implicitOutputs, desugarReport)
+		}
 		ctx.Build(pctx, android.BuildParams{
-			Rule:           r8,
-			Description:    "r8",
-			Output:         javalibJar,
-			ImplicitOutput: proguardDictionary,
-			Input:          classesJar,
-			Implicits:      r8Deps,
+			Rule:            r8,
+			Description:     "r8",
+			Output:          javalibJar,
+			ImplicitOutputs: implicitOutputs,
+			Input:           classesJar,
+			Implicits:       append(r8Deps, desugarReportDeps...),
 			Args: map[string]string{
-				"r8Flags":  strings.Join(r8Flags, " "),
-				"zipFlags": zipFlags,
-				"outDict":  j.proguardDictionary.String(),
-				"outDir":   outDir.String(),
+				"r8Flags":              strings.Join(r8Flags, " "),
+				"zipFlags":             zipFlags,
+				"outDict":              j.proguardDictionary.String(),
+				"outUsageDir":          proguardUsageDir.String(),
+				"outUsage":             proguardUsage.String(),
+				"outUsageZip":          proguardUsageZip.String(),
+				"outDir":               outDir.String(),
+				"singleDex":            singleDex,
+				"legacyMultidex":       legacyMultidex,
+				"desugarReport":        desugarReportArg,
+				"desugarReportFlags":   desugarReportFlags,
+				"desugarMinSdkVersion": desugarMinSdkVersion,
 			},
 		})
 	} else {
 		d8Flags, d8Deps := j.d8Flags(ctx, flags)
+		var implicitOutputs android.WritablePaths
+		if desugarReport != nil {
+			implicitOutputs = append(implicitOutputs, desugarReport)
+		}
 		ctx.Build(pctx, android.BuildParams{
-			Rule:        d8,
-			Description: "d8",
-			Output:      javalibJar,
-			Input:       classesJar,
-			Implicits:   d8Deps,
+			Rule:            d8,
+			Description:     "d8",
+			Output:          javalibJar,
+			ImplicitOutputs: implicitOutputs,
+			Input:           classesJar,
+			Implicits:       append(d8Deps, desugarReportDeps...),
 			Args: map[string]string{
-				"d8Flags":  strings.Join(d8Flags, " "),
-				"zipFlags": zipFlags,
-				"outDir":   outDir.String(),
+				"d8Flags":              strings.Join(d8Flags, " "),
+				"zipFlags":             zipFlags,
+				"outDir":               outDir.String(),
+				"singleDex":            singleDex,
+				"legacyMultidex":       legacyMultidex,
+				"desugarReport":        desugarReportArg,
+				"desugarReportFlags":   desugarReportFlags,
+				"desugarMinSdkVersion": desugarMinSdkVersion,
 			},
 		})
 	}
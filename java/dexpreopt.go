@@ -15,6 +15,9 @@
 package java
 
 import (
+	"path/filepath"
+	"strings"
+
 	"android/soong/android"
 	"android/soong/dexpreopt"
 )
@@ -28,13 +31,14 @@ type dexpreopter struct {
 	isTest              bool
 	isInstallable       bool
 	isPresignedPrebuilt bool
+	isInRamdisk         bool
 
-	manifestFile     android.Path
-	usesLibs         []string
-	optionalUsesLibs []string
-	enforceUsesLibs  bool
-	libraryPaths     map[string]android.Path
+	manifestFile        android.Path
+	enforceUsesLibs     bool
+	usesLibsFile        android.Path
+	classLoaderContexts dexpreopt.ClassLoaderContextMap
 
+	builtInstalls  android.RuleBuilderInstalls
 	builtInstalled string
 }
 
@@ -42,24 +46,27 @@ type DexpreoptProperties struct {
 	Dex_preopt struct {
 		// If false, prevent dexpreopting and stripping the dex file from the final jar.  Defaults to
 		// true.
-		Enabled *bool
+		Enabled *bool `android:"arch_variant"`
 
 		// If true, never strip the dex files from the final jar when dexpreopting.  Defaults to false.
-		No_stripping *bool
+		No_stripping *bool `android:"arch_variant"`
 
 		// If true, generate an app image (.art file) for this module.
-		App_image *bool
+		App_image *bool `android:"arch_variant"`
 
 		// If true, use a checked-in profile to guide optimization.  Defaults to false unless
 		// a matching profile is set or a profile is found in PRODUCT_DEX_PREOPT_PROFILE_DIR
 		// that matches the name of this module, in which case it is defaulted to true.
-		Profile_guided *bool
+		Profile_guided *bool `android:"arch_variant"`
 
 		// If set, provides the path to profile relative to the Android.bp file.  If not set,
 		// defaults to searching for a file that matches the name of this module in the default
 		// profile location set by PRODUCT_DEX_PREOPT_PROFILE_DIR, or empty if not found.
-		Profile *string `android:"path"`
-	}
+		// The profile is a text listing of classes and methods that is converted to a binary
+		// profile with profman, used to compile the module with the speed-profile compiler
+		// filter, and installed next to the jar or apk with a .prof suffix.
+		Profile *string `android:"path,arch_variant"`
+	} `android:"arch_variant"`
 }
 
 func (d *dexpreopter) dexpreoptDisabled(ctx android.ModuleContext) bool {
@@ -89,6 +96,11 @@ func (d *dexpreopter) dexpreoptDisabled(ctx android.ModuleContext) bool {
 		return true
 	}
 
+	// The boot image to preopt against is only on the system image.
+	if ctx.InstallInRecovery() || d.isInRamdisk {
+		return true
+	}
+
 	// TODO: contains no java code
 
 	return false
@@ -103,6 +115,61 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Mo
 		return dexJarFile
 	}
 
+	strippedDexJarFile := android.PathForModuleOut(ctx, "dexpreopt", dexJarFile.Base())
+
+	global, dexpreoptConfig, ok := d.buildDexpreoptRule(ctx, dexJarFile, strippedDexJarFile.OutputPath)
+	if !ok {
+		return dexJarFile
+	}
+
+	stripRule, err := dexpreopt.GenerateStripRule(global, dexpreoptConfig)
+	if err != nil {
+		ctx.ModuleErrorf("error generating dexpreopt strip rule: %s", err.Error())
+		return dexJarFile
+	}
+
+	stripRule.Build(pctx, ctx, "dexpreopt_strip", "dexpreopt strip")
+
+	return strippedDexJarFile
+}
+
+// installBuiltFiles installs the files generated by dexpreopt to their on-device paths, so that they
+// are part of the <module>-install phony target.  When Soong is embedded in Make the installs are
+// skipped, and Make installs the files from LOCAL_SOONG_BUILT_INSTALLED instead.
+func (d *dexpreopter) installBuiltFiles(ctx android.ModuleContext) {
+	productOut := android.PathForOutput(ctx, "target", "product", ctx.Config().DeviceName())
+	for _, install := range d.builtInstalls {
+		to := strings.TrimPrefix(install.To, "/")
+		ctx.InstallFile(productOut.Join(ctx, filepath.Dir(to)), filepath.Base(to), install.From)
+	}
+}
+
+// dexpreoptApk dexpreopts the dex files of a prebuilt apk, and returns whether they should be
+// stripped from it.  The caller strips them while it rewrites the apk for other reasons, instead of
+// copying the whole apk in a separate strip rule.
+func (d *dexpreopter) dexpreoptApk(ctx android.ModuleContext, apk android.Path) bool {
+	if d.dexpreoptDisabled(ctx) {
+		return false
+	}
+
+	global, dexpreoptConfig, ok := d.buildDexpreoptRule(ctx, apk, nil)
+	if !ok {
+		return false
+	}
+
+	strip, err := dexpreopt.ShouldStripDex(global, dexpreoptConfig)
+	if err != nil {
+		ctx.ModuleErrorf("%s", err.Error())
+		return false
+	}
+	return strip
+}
+
+// buildDexpreoptRule builds the rule that dexpreopts dexJarFile, and returns the configs that decide
+// whether the dex files are stripped from it into strippedDexJarFile.
+func (d *dexpreopter) buildDexpreoptRule(ctx android.ModuleContext, dexJarFile android.Path,
+	strippedDexJarFile android.WritablePath) (dexpreopt.GlobalConfig, dexpreopt.ModuleConfig, bool) {
+
 	global := dexpreoptGlobalConfig(ctx)
 	bootImage := defaultBootImageConfig(ctx)
 	defaultBootImage := bootImage
@@ -140,8 +207,6 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Mo
 
 	dexLocation := android.InstallPathToOnDevicePath(ctx, d.installPath)
 
-	strippedDexJarFile := android.PathForModuleOut(ctx, "dexpreopt", dexJarFile.Base())
-
 	var profileClassListing android.OptionalPath
 	profileIsTextListing := false
 	if BoolDefault(d.dexpreoptProperties.Dex_preopt.Profile_guided, true) {
@@ -170,10 +235,9 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Mo
 		ProfileClassListing:  profileClassListing,
 		ProfileIsTextListing: profileIsTextListing,
 
-		EnforceUsesLibraries:         d.enforceUsesLibs,
-		PresentOptionalUsesLibraries: d.optionalUsesLibs,
-		UsesLibraries:                d.usesLibs,
-		LibraryPaths:                 d.libraryPaths,
+		EnforceUsesLibraries: d.enforceUsesLibs,
+		ClassLoaderContexts:  d.classLoaderContexts,
+		UsesLibrariesFile:    d.usesLibsFile,
 
 		Archs:               archs,
 		DexPreoptImages:     images,
@@ -194,26 +258,19 @@ func (d *dexpreopter) dexpreopt(ctx android.ModuleContext, dexJarFile android.Mo
 
 		NoStripping:     Bool(d.dexpreoptProperties.Dex_preopt.No_stripping),
 		StripInputPath:  dexJarFile,
-		StripOutputPath: strippedDexJarFile.OutputPath,
+		StripOutputPath: strippedDexJarFile,
 	}
 
 	dexpreoptRule, err := dexpreopt.GenerateDexpreoptRule(ctx, global, dexpreoptConfig)
 	if err != nil {
 		ctx.ModuleErrorf("error generating dexpreopt rule: %s", err.Error())
-		return dexJarFile
+		return global, dexpreoptConfig, false
 	}
 
 	dexpreoptRule.Build(pctx, ctx, "dexpreopt", "dexpreopt")
 
-	d.builtInstalled = dexpreoptRule.Installs().String()
+	d.builtInstalls = dexpreoptRule.Installs()
+	d.builtInstalled = d.builtInstalls.String()
 
-	stripRule, err := dexpreopt.GenerateStripRule(global, dexpreoptConfig)
-	if err != nil {
-		ctx.ModuleErrorf("error generating dexpreopt strip rule: %s", err.Error())
-		return dexJarFile
-	}
-
-	stripRule.Build(pctx, ctx, "dexpreopt_strip", "dexpreopt strip")
-
-	return strippedDexJarFile
+	return global, dexpreoptConfig, true
 }
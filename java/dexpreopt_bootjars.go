@@ -255,6 +255,7 @@ func buildBootImageRuleForArch(ctx android.SingletonContext, image *bootImage,
 	rule.MissingDeps(missingDeps)
 
 	rule.Command().Text("mkdir").Flag("-p").Flag(symbolsDir.String())
+	rule.Command().Text("mkdir").Flag("-p").Flag(global.EmptyDirectory)
 	rule.Command().Text("rm").Flag("-f").
 		Flag(symbolsDir.Join(ctx, "*.art").String()).
 		Flag(symbolsDir.Join(ctx, "*.oat").String()).
@@ -18,10 +18,13 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	"android/soong/android"
 	"android/soong/dexpreopt"
+
+	"github.com/google/blueprint/proptools"
 )
 
 func TestDexpreoptBootJars(t *testing.T) {
@@ -111,3 +114,77 @@ func TestDexpreoptBootJars(t *testing.T) {
 		t.Errorf("want outputs %q\n got outputs %q", expectedOutputs, outputs)
 	}
 }
+
+func TestDexpreoptInSoong(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			installable: true,
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			installable: true,
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["a.java"],
+			installable: true,
+		}
+	`
+
+	config := testConfig(nil)
+	config.TestProductVariables.DexpreoptInSoong = proptools.BoolPtr(true)
+	config.TestProductVariables.BootJars = []string{"foo", "bar"}
+	config.TestProductVariables.RuntimeApexJars = []string{"foo"}
+
+	ctx := testContext(config, bp, nil)
+
+	ctx.RegisterSingletonType("dex_bootjars", android.SingletonFactoryAdaptor(dexpreoptBootJarsFactory))
+
+	run(t, ctx, config)
+
+	bootArt := ctx.SingletonForTests("dex_bootjars").Output("boot.art")
+
+	for _, flag := range []string{
+		"--dex-location=/apex/com.android.runtime/javalib/foo.jar",
+		"--dex-location=/system/framework/bar.jar",
+		"--instruction-set=arm64",
+		"--instruction-set-variant=generic",
+		"--instruction-set-features=default",
+	} {
+		if !strings.Contains(bootArt.RuleParams.Command, flag) {
+			t.Errorf("expected boot image command to contain %q, got %q", flag, bootArt.RuleParams.Command)
+		}
+	}
+
+	// Only baz is dexpreopted on its own, against the boot image built by Soong.
+	odex := func(name string) android.TestingBuildParams {
+		for _, p := range ctx.ModuleForTests(name, "android_common").Module().BuildParamsForTests() {
+			for _, output := range append(android.WritablePaths{p.Output}, p.ImplicitOutputs...) {
+				if output != nil && strings.HasSuffix(output.String(), "oat/arm64/javalib.odex") {
+					return android.TestingBuildParams{BuildParams: p}
+				}
+			}
+		}
+		return android.TestingBuildParams{}
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		if odex(name).Rule != nil {
+			t.Errorf("expected boot jar %q not to be dexpreopted on its own", name)
+		}
+	}
+
+	bazOdex := odex("baz")
+	if bazOdex.Rule == nil {
+		t.Fatalf("expected baz to be dexpreopted")
+	}
+	if !android.InList(bootArt.Output.String(), bazOdex.Implicits.Strings()) {
+		t.Errorf("expected baz to be dexpreopted against %q, got implicits %q",
+			bootArt.Output.String(), bazOdex.Implicits.Strings())
+	}
+}
@@ -15,6 +15,7 @@
 package java
 
 import (
+	"encoding/json"
 	"path/filepath"
 	"strings"
 
@@ -42,9 +43,21 @@ func dexpreoptGlobalConfigRaw(ctx android.PathContext) globalConfigAndRaw {
 			if err != nil {
 				panic(err)
 			}
+			// The construct_context tool set by Make uses an older protocol than the dexpreopt rules, use the one
+			// built by Soong instead, also in the copy of the config that dexpreopt_gen reads for Make modules.
+			globalConfig.Tools.ConstructContext = ctx.Config().HostToolPath(ctx, "construct_context")
+			data, err = setGlobalConfigTool(data, "ConstructContext", globalConfig.Tools.ConstructContext.String())
+			if err != nil {
+				panic(err)
+			}
 			return globalConfigAndRaw{globalConfig, data}
 		}
 
+		// No global config filename set, construct one from the product variables if the product asked for it
+		if ctx.Config().DexpreoptInSoong() {
+			return globalConfigAndRaw{soongDexpreoptGlobalConfig(ctx), nil}
+		}
+
 		// No global config filename set, see if there is a test config set
 		return ctx.Config().Once(dexpreoptTestGlobalConfigKey, func() interface{} {
 			// Nope, return a config with preopting disabled
@@ -55,12 +68,80 @@ func dexpreoptGlobalConfigRaw(ctx android.PathContext) globalConfigAndRaw {
 	}).(globalConfigAndRaw)
 }
 
+// setGlobalConfigTool replaces the path of a tool in the raw global dexpreopt.config.
+func setGlobalConfigTool(data []byte, tool string, path string) ([]byte, error) {
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	tools, _ := config["Tools"].(map[string]interface{})
+	if tools == nil {
+		tools = make(map[string]interface{})
+		config["Tools"] = tools
+	}
+	tools[tool] = path
+	return json.Marshal(config)
+}
+
 // setDexpreoptTestGlobalConfig sets a GlobalConfig that future calls to dexpreoptGlobalConfig will return.  It must
 // be called before the first call to dexpreoptGlobalConfig for the config.
 func setDexpreoptTestGlobalConfig(config android.Config, globalConfig dexpreopt.GlobalConfig) {
 	config.Once(dexpreoptTestGlobalConfigKey, func() interface{} { return globalConfigAndRaw{globalConfig, nil} })
 }
 
+// soongDexpreoptGlobalConfig returns a GlobalConfig constructed from the product variables, for builds where Make
+// doesn't write a dexpreopt.config.  The boot jars and the runtime apex jars come from the product, the tools are the
+// host tools built by Soong and the remaining settings use the defaults of the Make dexpreopt configuration.
+func soongDexpreoptGlobalConfig(ctx android.PathContext) dexpreopt.GlobalConfig {
+	cpuVariant := make(map[android.ArchType]string)
+	instructionSetFeatures := make(map[android.ArchType]string)
+	for _, target := range dexpreoptTargets(ctx) {
+		arch := target.Arch
+		cpuVariant[arch.ArchType] = arch.CpuVariant
+		if cpuVariant[arch.ArchType] == "" {
+			cpuVariant[arch.ArchType] = "generic"
+		}
+		instructionSetFeatures[arch.ArchType] = strings.Join(arch.ArchFeatures, ",")
+		if instructionSetFeatures[arch.ArchType] == "" {
+			instructionSetFeatures[arch.ArchType] = "default"
+		}
+	}
+
+	hostTool := func(tool string) android.Path {
+		return ctx.Config().HostToolPath(ctx, tool)
+	}
+	hostNativeTool := func(tool string) android.Path {
+		return ctx.Config().HostNativeToolPath(ctx, tool)
+	}
+
+	return dexpreopt.GlobalConfig{
+		BootJars:        ctx.Config().BootJars(),
+		RuntimeApexJars: ctx.Config().RuntimeApexJars(),
+
+		IsEng: ctx.Config().Eng(),
+
+		Dex2oatXms:      "64m",
+		Dex2oatXmx:      "512m",
+		Dex2oatImageXms: "64m",
+		Dex2oatImageXmx: "64m",
+
+		EmptyDirectory: android.PathForOutput(ctx, "empty").String(),
+
+		CpuVariant:             cpuVariant,
+		InstructionSetFeatures: instructionSetFeatures,
+
+		Tools: dexpreopt.Tools{
+			Profman:          hostNativeTool("profman"),
+			Dex2oat:          hostNativeTool("dex2oat"),
+			Aapt:             hostNativeTool("aapt"),
+			SoongZip:         hostTool("soong_zip"),
+			Zip2zip:          hostTool("zip2zip"),
+			ManifestCheck:    hostTool("manifest_check"),
+			ConstructContext: hostTool("construct_context"),
+		},
+	}
+}
+
 var dexpreoptGlobalConfigKey = android.NewOnceKey("DexpreoptGlobalConfig")
 var dexpreoptTestGlobalConfigKey = android.NewOnceKey("TestDexpreoptGlobalConfig")
 
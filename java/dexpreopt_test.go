@@ -15,6 +15,8 @@
 package java
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -155,6 +157,26 @@ func TestDexpreoptEnabled(t *testing.T) {
 	}
 }
 
+func TestDexpreoptInstalled(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			installable: true,
+			srcs: ["a.java"],
+		}`)
+
+	// The dexpreopt files of libraries are installed through the module context like the ones of apps, so
+	// that they are listed in installed_files.json.
+	variant := ctx.ModuleForTests("foo", "android_common")
+	builtInstalls := variant.Module().(*Library).dexpreopter.builtInstalls
+	if len(builtInstalls) == 0 {
+		t.Fatalf("expected dexpreopt files to install")
+	}
+	for _, install := range builtInstalls {
+		variant.Output(filepath.Join("target/product/test_device", install.To))
+	}
+}
+
 func enabledString(enabled bool) string {
 	if enabled {
 		return "enabled"
@@ -162,3 +184,72 @@ func enabledString(enabled bool) string {
 		return "disabled"
 	}
 }
+
+func TestDexpreoptProfile(t *testing.T) {
+	ctx := testJava(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			dex_preopt: {
+				profile: "art-profile",
+			},
+		}
+
+		android_app_import {
+			name: "bar",
+			apk: "prebuilts/apk/app.apk",
+			presigned: true,
+			dex_preopt: {
+				profile: "art-profile",
+			},
+		}
+
+		android_app {
+			name: "baz",
+			srcs: ["a.java"],
+			dex_preopt: {
+				profile: "art-profile",
+				profile_guided: false,
+			},
+		}
+	`)
+
+	testCases := []struct {
+		name       string
+		installDir string
+		profile    bool
+	}{
+		{"foo", "/system/app/foo/foo.apk", true},
+		{"bar", "/system/app/bar/bar.apk", true},
+		{"baz", "/system/app/baz/baz.apk", false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			variant := ctx.ModuleForTests(test.name, "android_common")
+			cmd := variant.Rule("dexpreopt").RuleParams.Command
+
+			checks := []string{
+				"--create-profile-from=art-profile",
+				"--profile-file=",
+				"--compiler-filter=speed-profile",
+			}
+			for _, w := range checks {
+				if strings.Contains(cmd, w) != test.profile {
+					t.Errorf("want %q in dexpreopt command %v, got %v", w, test.profile, !test.profile)
+				}
+			}
+
+			var builtInstalled string
+			switch m := variant.Module().(type) {
+			case *AndroidApp:
+				builtInstalled = m.dexpreopter.builtInstalled
+			case *AndroidAppImport:
+				builtInstalled = m.dexpreopter.builtInstalled
+			}
+			if w := "profile.prof:" + test.installDir + ".prof"; strings.Contains(builtInstalled, w) != test.profile {
+				t.Errorf("want %q in installed dexpreopt files %v, got %q", w, test.profile, builtInstalled)
+			}
+		})
+	}
+}
@@ -354,6 +354,17 @@ type DroidstubsProperties struct {
 		// do not perform API check against Last_released, in the case that both two specified API
 		// files by Last_released are modules which don't exist.
 		Ignore_missing_latest_api *bool `blueprint:"mutated"`
+
+		Api_lint struct {
+			// if set to true, run the Metalava API lint checks on the sources. Defaults to false.
+			Enabled *bool
+
+			// if set, only the APIs that are not in this API signature file are linted.
+			New_since *string `android:"path"`
+
+			// a file containing the API lint issues that have already been approved.
+			Baseline_file *string `android:"path"`
+		}
 	}
 
 	// user can specify the version of previous released API file in order to do compatibility check.
@@ -456,6 +467,14 @@ type ApiFilePath interface {
 	ApiFilePath() android.Path
 }
 
+// ApiCheckTimestamps is implemented by modules that check the API of their sources against the checked in API
+// files.  The returned paths are nil if the corresponding check is disabled.
+type ApiCheckTimestamps interface {
+	CheckCurrentApiTimestamp() android.Path
+	UpdateCurrentApiTimestamp() android.Path
+	CheckLastReleasedApiTimestamp() android.Path
+}
+
 func transformUpdateApi(ctx android.ModuleContext, destApiFile, destRemovedApiFile,
 	srcApiFile, srcRemovedApiFile android.Path, output android.WritablePath) {
 	ctx.Build(pctx, android.BuildParams{
@@ -763,6 +782,7 @@ func (j *Javadoc) DepsMutator(ctx android.BottomUpMutatorContext) {
 }
 
 func (j *Javadoc) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	deps := j.collectDeps(ctx)
 
 	var implicits android.Paths
@@ -1153,6 +1173,7 @@ func (d *Droiddoc) transformDokka(ctx android.ModuleContext, implicits android.P
 }
 
 func (d *Droiddoc) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	deps := d.Javadoc.collectDeps(ctx)
 
 	jsilver := android.PathForOutput(ctx, "host", ctx.Config().PrebuiltOS(), "framework", "jsilver.jar")
@@ -1255,6 +1276,9 @@ type Droidstubs struct {
 
 	checkNullabilityWarningsTimestamp android.WritablePath
 
+	apiLintTimestamp android.WritablePath
+	apiLintReport    android.WritablePath
+
 	annotationsZip android.WritablePath
 	apiVersionsXml android.WritablePath
 
@@ -1288,6 +1312,29 @@ func (d *Droidstubs) ApiFilePath() android.Path {
 	return d.apiFilePath
 }
 
+func (d *Droidstubs) CheckCurrentApiTimestamp() android.Path {
+	if d.checkCurrentApiTimestamp == nil {
+		return nil
+	}
+	return d.checkCurrentApiTimestamp
+}
+
+func (d *Droidstubs) UpdateCurrentApiTimestamp() android.Path {
+	if d.updateCurrentApiTimestamp == nil {
+		return nil
+	}
+	return d.updateCurrentApiTimestamp
+}
+
+func (d *Droidstubs) CheckLastReleasedApiTimestamp() android.Path {
+	if d.checkLastReleasedApiTimestamp == nil {
+		return nil
+	}
+	return d.checkLastReleasedApiTimestamp
+}
+
+var _ ApiCheckTimestamps = (*Droidstubs)(nil)
+
 func (d *Droidstubs) DepsMutator(ctx android.BottomUpMutatorContext) {
 	d.Javadoc.addDeps(ctx)
 
@@ -1618,6 +1665,7 @@ func (d *Droidstubs) transformJdiff(ctx android.ModuleContext, implicits android
 }
 
 func (d *Droidstubs) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	deps := d.Javadoc.collectDeps(ctx)
 
 	javaVersion := getJavaVersion(ctx, String(d.Javadoc.properties.Java_version), sdkContext(d))
@@ -1709,6 +1757,56 @@ func (d *Droidstubs) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			d.checkLastReleasedApiTimestamp)
 	}
 
+	if Bool(d.properties.Check_api.Api_lint.Enabled) && !ctx.Config().IsPdkBuild() {
+		d.apiLintTimestamp = android.PathForModuleOut(ctx, "api_lint.timestamp")
+		d.apiLintReport = android.PathForModuleOut(ctx, "api_lint_report.txt")
+
+		apiLintImplicits := append(android.Paths(nil), metalavaCheckApiImplicits...)
+		opts := " " + d.Javadoc.args + flags.metalavaInclusionAnnotationsFlags +
+			flags.metalavaMergeAnnoDirFlags + " --api-lint"
+		if newSince := String(d.properties.Check_api.Api_lint.New_since); newSince != "" {
+			newSinceFile := ctx.ExpandSource(newSince, "check_api.api_lint.new_since")
+			apiLintImplicits = append(apiLintImplicits, newSinceFile)
+			opts += " " + newSinceFile.String()
+		}
+		opts += " --report-even-if-suppressed " + d.apiLintReport.String()
+
+		apiLintOutputs := android.WritablePaths{d.apiLintReport}
+		msg := `\n******************************\n` +
+			`Your API changes are triggering API lint errors.\n` +
+			`Fix the code according to the errors listed above, or suppress them with\n` +
+			`@SuppressLint("<id>") if they have been approved.\n`
+		if baselineFile := String(d.properties.Check_api.Api_lint.Baseline_file); baselineFile != "" {
+			baseline := ctx.ExpandSource(baselineFile, "check_api.api_lint.baseline_file")
+			updatedBaseline := android.PathForModuleOut(ctx, "api_lint_baseline.txt")
+			apiLintImplicits = append(apiLintImplicits, baseline)
+			apiLintOutputs = append(apiLintOutputs, updatedBaseline)
+			opts += " --baseline " + baseline.String() + " --update-baseline " + updatedBaseline.String()
+			msg += fmt.Sprintf(`If the errors have been approved, you can instead update the baseline with:\n`+
+				`   cp %s %s\n`, updatedBaseline, baseline)
+		}
+		msg += `******************************\n`
+
+		ctx.Build(pctx, android.BuildParams{
+			Rule:            metalavaApiCheck,
+			Description:     "Metalava API lint",
+			Output:          d.apiLintTimestamp,
+			ImplicitOutputs: apiLintOutputs,
+			Inputs:          d.Javadoc.srcFiles,
+			Implicits:       apiLintImplicits,
+			Args: map[string]string{
+				"srcJarDir":         android.PathForModuleOut(ctx, "api-lint", "srcjars").String(),
+				"srcJars":           strings.Join(d.Javadoc.srcJars.Strings(), " "),
+				"javaVersion":       javaVersion,
+				"bootclasspathArgs": flags.bootClasspathArgs,
+				"classpathArgs":     flags.classpathArgs,
+				"sourcepathArgs":    flags.sourcepathArgs,
+				"opts":              opts + " ",
+				"msg":               msg,
+			},
+		})
+	}
+
 	if String(d.properties.Check_nullability_warnings) != "" {
 		if d.nullabilityWarningsFile == nil {
 			ctx.PropertyErrorf("check_nullability_warnings",
@@ -0,0 +1,113 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file collects the findings of Error Prone, which runs on java modules when RUN_ERROR_PRONE is
+// set to true, into a build-wide report.  The report lists the findings of every module under a
+// header with the path of its findings file, and a summary counts the findings of each check.  Both
+// are built by the errorprone-report target.
+
+import (
+	"sort"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("errorprone", errorProneSingletonFactory)
+}
+
+var errorProneReportRule = pctx.AndroidStaticRule("errorProneReport",
+	blueprint.RuleParams{
+		Command: `rm -f $out $summary && touch $out && ` +
+			`for f in $$(cat $out.rsp) ; do if [ -s $$f ] ; then ` +
+			`echo "# $$f" >> $out && cat $$f >> $out ; fi ; done && ` +
+			`(grep -o -h -E '(warning|error): \[[A-Za-z0-9]+\]' $out | sort | uniq -c | sort -r -n > $summary)`,
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	},
+	"summary")
+
+type errorProneFindingsProvider interface {
+	errorProneFindingsFile() android.Path
+}
+
+func (j *Module) errorProneFindingsFile() android.Path {
+	return j.errorProneFindings
+}
+
+var _ errorProneFindingsProvider = (*Module)(nil)
+
+func errorProneSingletonFactory() android.Singleton {
+	return &errorProneSingleton{}
+}
+
+// errorProneSingleton combines the Error Prone findings of all modules into a report.
+type errorProneSingleton struct {
+	report  android.WritablePath
+	summary android.WritablePath
+}
+
+func (e *errorProneSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().RunErrorProne() {
+		return
+	}
+
+	var findings android.Paths
+	ctx.VisitAllModules(func(m android.Module) {
+		if p, ok := m.(errorProneFindingsProvider); ok && m.Enabled() && p.errorProneFindingsFile() != nil {
+			findings = append(findings, p.errorProneFindingsFile())
+		}
+	})
+
+	if len(findings) == 0 {
+		return
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].String() < findings[j].String()
+	})
+
+	e.report = android.PathForOutput(ctx, "errorprone-report.txt")
+	e.summary = android.PathForOutput(ctx, "errorprone-summary.txt")
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:           errorProneReportRule,
+		Description:    "errorprone report",
+		Inputs:         findings,
+		Output:         e.report,
+		ImplicitOutput: e.summary,
+		Args: map[string]string{
+			"summary": e.summary.String(),
+		},
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:      blueprint.Phony,
+		Output:    android.PathForPhony(ctx, "errorprone-report"),
+		Implicits: android.Paths{e.report, e.summary},
+	})
+}
+
+// Export the paths of the report and the summary to Make so that they can be added to dist.
+func (e *errorProneSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if e.report != nil {
+		ctx.Strict("SOONG_ERROR_PRONE_REPORTS", e.report.String()+" "+e.summary.String())
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*errorProneSingleton)(nil)
@@ -0,0 +1,88 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"android/soong/java/config"
+)
+
+func TestErrorProne(t *testing.T) {
+	defer func(classpath []string) { config.ErrorProneClasspath = classpath }(config.ErrorProneClasspath)
+	config.ErrorProneClasspath = []string{"external/error_prone/error_prone_core.jar"}
+
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			errorprone: {
+				javacflags: ["-Xep:FooCheck:ERROR"],
+			},
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`
+
+	errorProneConfig := testConfig(map[string]string{"RUN_ERROR_PRONE": "true"})
+	ctx := testContext(errorProneConfig, bp, nil)
+	run(t, ctx, errorProneConfig)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	fooErrorProne := foo.Rule("errorprone")
+	if !strings.Contains(fooErrorProne.Args["javacFlags"], "-Xep:FooCheck:ERROR") {
+		t.Errorf("expected foo errorprone javacFlags to contain the errorprone javacflags, got %q",
+			fooErrorProne.Args["javacFlags"])
+	}
+	if javac := foo.Rule("javac"); strings.Contains(javac.Args["javacFlags"], "-Xep:FooCheck:ERROR") {
+		t.Errorf("expected foo javacFlags not to contain the errorprone javacflags, got %q",
+			javac.Args["javacFlags"])
+	}
+
+	fooFindings := foo.Output("errorprone/findings.txt")
+	if fooFindings.Rule != fooErrorProne.Rule {
+		t.Errorf("expected foo findings to be written by the errorprone rule")
+	}
+	barFindings := ctx.ModuleForTests("bar", "android_common").Output("errorprone/findings.txt")
+
+	report := ctx.SingletonForTests("errorprone").Output("errorprone-report.txt")
+	expectedInputs := []string{fooFindings.Args["findings"], barFindings.Args["findings"]}
+	sort.Strings(expectedInputs)
+	if g, w := report.Inputs.Strings(), expectedInputs; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected report inputs %q, got %q", w, g)
+	}
+}
+
+func TestErrorProneDisabled(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+	`)
+
+	if rule := ctx.ModuleForTests("foo", "android_common").MaybeRule("errorprone"); rule.Rule != nil {
+		t.Errorf("expected no errorprone rule without RUN_ERROR_PRONE")
+	}
+	if report := ctx.SingletonForTests("errorprone").MaybeOutput("errorprone-report.txt"); report.Rule != nil {
+		t.Errorf("expected no errorprone report without RUN_ERROR_PRONE")
+	}
+}
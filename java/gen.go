@@ -115,16 +115,20 @@ func (j *Module) genSources(ctx android.ModuleContext, srcFiles android.Paths,
 		case ".aidl":
 			javaFile := genAidl(ctx, srcFile, flags.aidlFlags, flags.aidlDeps)
 			outSrcFiles = append(outSrcFiles, javaFile)
+			j.generatedJavaSrcs = append(j.generatedJavaSrcs, javaFile)
 		case ".logtags":
 			j.logtagsSrcs = append(j.logtagsSrcs, srcFile)
 			javaFile := genLogtags(ctx, srcFile)
 			outSrcFiles = append(outSrcFiles, javaFile)
+			j.generatedJavaSrcs = append(j.generatedJavaSrcs, javaFile)
 		case ".proto":
 			srcJarFile := genProto(ctx, srcFile, flags.proto)
 			outSrcFiles = append(outSrcFiles, srcJarFile)
+			j.generatedSrcJars = append(j.generatedSrcJars, srcJarFile)
 		case ".sysprop":
 			srcJarFile := genSysprop(ctx, srcFile)
 			outSrcFiles = append(outSrcFiles, srcJarFile)
+			j.generatedSrcJars = append(j.generatedSrcJars, srcJarFile)
 		default:
 			outSrcFiles = append(outSrcFiles, srcFile)
 		}
@@ -158,3 +162,31 @@ func (l *logtagsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		Inputs:      allLogtags,
 	})
 }
+
+func (j *Module) addGeneratedSrcJar(srcJar android.Path) {
+	if srcJar != nil {
+		j.generatedSrcJars = append(j.generatedSrcJars, srcJar)
+	}
+}
+
+// buildGeneratedSrcJar packages all the sources generated while compiling the module, including
+// R.java, aidl, proto and annotation processor output, into a single srcjar.  Returns nil if the
+// module did not generate any sources.
+func (j *Module) buildGeneratedSrcJar(ctx android.ModuleContext) android.Path {
+	if len(j.generatedJavaSrcs) == 0 && len(j.generatedSrcJars) == 0 {
+		return nil
+	}
+
+	srcJars := j.generatedSrcJars
+	if len(j.generatedJavaSrcs) > 0 {
+		javaSrcJar := android.PathForModuleOut(ctx, "gen-srcs", "java.srcjar")
+		TransformResourcesToJar(ctx, javaSrcJar, resourcePathsToJarArgs(j.generatedJavaSrcs), j.generatedJavaSrcs)
+		srcJars = append(android.Paths{javaSrcJar}, srcJars...)
+	}
+
+	generatedSrcJar := android.PathForModuleOut(ctx, "gen-srcs", ctx.ModuleName()+"-gen.srcjar")
+	TransformJarsToJar(ctx, generatedSrcJar, "generated sources", srcJars, android.OptionalPath{},
+		false, nil, nil)
+
+	return generatedSrcJar
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file chooses how the header jars of java modules are generated, and reports how many of the
+// classpath dependencies between java modules go through a header jar that only changes with the
+// API of the dependency, which is what lets ninja skip recompiling the dependents of a module after
+// an implementation-only change.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("java_header_jars", javaHeaderJarsSingletonFactory)
+}
+
+const (
+	// The header jar is generated from the sources by turbine, without waiting for javac.
+	headerJarTurbine = "turbine"
+	// The header jar is generated by ijar from the classes compiled by javac.
+	headerJarIjar = "ijar"
+	// The classes compiled by javac are used as the header jar.
+	headerJarJavac = "javac"
+)
+
+var headerJarStrategies = []string{headerJarTurbine, headerJarIjar, headerJarJavac}
+
+const javaHeaderJarsMetricsFileName = "java_header_jars.txt"
+
+// chooseHeaderJarStrategy returns how the header jar of the module is generated, from its
+// header_jar property or SOONG_JAVA_HEADER_JAR.
+func (j *Module) chooseHeaderJarStrategy(ctx android.ModuleContext, deps deps) string {
+	if j.properties.Header_jar != nil {
+		strategy := *j.properties.Header_jar
+		switch {
+		case !android.InList(strategy, headerJarStrategies):
+			ctx.PropertyErrorf("header_jar", "unknown value %q, must be one of %q", strategy,
+				headerJarStrategies)
+		case strategy == headerJarTurbine && !ctx.Device():
+			ctx.PropertyErrorf("header_jar", "turbine is only supported for device modules")
+		case strategy == headerJarTurbine && deps.disableTurbine:
+			ctx.PropertyErrorf("header_jar",
+				"turbine can't run plugins that generate API, use %q or %q", headerJarIjar, headerJarJavac)
+		}
+		return strategy
+	}
+
+	strategy := ctx.Config().DefaultJavaHeaderJar()
+	if strategy != "" && !android.InList(strategy, headerJarStrategies) {
+		ctx.ModuleErrorf("unknown SOONG_JAVA_HEADER_JAR value %q, must be one of %q", strategy,
+			headerJarStrategies)
+		return headerJarJavac
+	}
+	if strategy == "" || strategy == headerJarTurbine {
+		// Turbine can't be used on the host or with plugins that generate API, fall back to the
+		// classes compiled by javac like before header_jar existed.
+		if ctx.Device() && !deps.disableTurbine {
+			return headerJarTurbine
+		}
+		return headerJarJavac
+	}
+	return strategy
+}
+
+type headerJarMetricsProvider interface {
+	javaHeaderJarStrategy() string
+	javaHeaderJarDepStrategies() map[string]int
+}
+
+func (j *Module) javaHeaderJarStrategy() string {
+	return j.headerJarStrategy
+}
+
+func (j *Module) javaHeaderJarDepStrategies() map[string]int {
+	return j.headerJarDepStrategies
+}
+
+var _ headerJarMetricsProvider = (*Module)(nil)
+
+// countHeaderJarDep records how the header jar of a module in the libs or static_libs of this
+// module is generated.
+func (j *Module) countHeaderJarDep(dep Dependency) {
+	if m, ok := dep.(headerJarMetricsProvider); ok && m.javaHeaderJarStrategy() != "" {
+		j.headerJarDepStrategies[m.javaHeaderJarStrategy()]++
+	}
+}
+
+func javaHeaderJarsSingletonFactory() android.Singleton {
+	return &javaHeaderJarsSingleton{}
+}
+
+// javaHeaderJarsSingleton writes the number of java modules using each header jar strategy, and the
+// number of classpath dependencies on the modules using it, to java_header_jars.txt.  It can be
+// built with the java-header-jar-metrics target.
+type javaHeaderJarsSingleton struct{}
+
+func (s *javaHeaderJarsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	modules := make(map[string]int)
+	deps := make(map[string]int)
+	ctx.VisitAllModules(func(m android.Module) {
+		if j, ok := m.(headerJarMetricsProvider); ok && m.Enabled() && j.javaHeaderJarStrategy() != "" {
+			modules[j.javaHeaderJarStrategy()]++
+			for strategy, count := range j.javaHeaderJarDepStrategies() {
+				deps[strategy] += count
+			}
+		}
+	})
+
+	if len(modules) == 0 {
+		return
+	}
+
+	output := android.PathForOutput(ctx, javaHeaderJarsMetricsFileName)
+	android.WriteFileRule(ctx, output, strings.Join(headerJarMetrics(modules, deps), "\n")+"\n")
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   blueprint.Phony,
+		Output: android.PathForPhony(ctx, "java-header-jar-metrics"),
+		Input:  output,
+	})
+}
+
+// headerJarMetrics returns the lines of java_header_jars.txt.  Dependencies on the header jars
+// generated by turbine or ijar are only rebuilt when the API of the dependency changes.
+func headerJarMetrics(modules, deps map[string]int) []string {
+	var lines []string
+	total, avoided := 0, 0
+	for _, strategy := range headerJarStrategies {
+		lines = append(lines, fmt.Sprintf("%s: %d modules, %d classpath dependencies",
+			strategy, modules[strategy], deps[strategy]))
+		total += deps[strategy]
+		if strategy != headerJarJavac {
+			avoided += deps[strategy]
+		}
+	}
+
+	percent := 0
+	if total > 0 {
+		percent = avoided * 100 / total
+	}
+	lines = append(lines, fmt.Sprintf("rebuild avoidance: %d of %d classpath dependencies (%d%%) "+
+		"are only rebuilt when the API of the dependency changes", avoided, total, percent))
+
+	return lines
+}
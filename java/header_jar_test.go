@@ -0,0 +1,172 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestHeaderJar(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			header_jar: "ijar",
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			header_jar: "javac",
+		}
+
+		java_library {
+			name: "qux",
+			srcs: ["a.java"],
+			libs: ["foo", "bar"],
+			static_libs: ["baz"],
+			sdk_version: "none",
+			system_modules: "none",
+		}
+	`
+
+	ctx := testJava(t, bp)
+
+	fooHeaderJar := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "turbine-combined", "foo.jar")
+	barHeaderJar := filepath.Join(buildDir, ".intermediates", "bar", "android_common", "ijar", "bar.jar")
+	bazImplementationJar := ctx.ModuleForTests("baz", "android_common").Module().(*Library).implementationJarFile.String()
+
+	barIjar := ctx.ModuleForTests("bar", "android_common").Rule("ijar")
+	if g, w := barIjar.Output.String(), barHeaderJar; g != w {
+		t.Errorf("expected bar ijar output %q, got %q", w, g)
+	}
+	barImplementationJar := ctx.ModuleForTests("bar", "android_common").Module().(*Library).implementationJarFile
+	if g, w := barIjar.Input.String(), barImplementationJar.String(); g != w {
+		t.Errorf("expected bar ijar input %q, got %q", w, g)
+	}
+
+	if ctx.ModuleForTests("baz", "android_common").MaybeRule("turbine").Rule != nil {
+		t.Errorf("expected no turbine rule for baz")
+	}
+
+	quxJavac := ctx.ModuleForTests("qux", "android_common").Rule("javac")
+	for _, jar := range []string{fooHeaderJar, barHeaderJar, bazImplementationJar} {
+		if !strings.Contains(quxJavac.Args["classpath"], jar) {
+			t.Errorf("expected qux javac classpath %q to contain %q", quxJavac.Args["classpath"], jar)
+		}
+	}
+
+	qux := ctx.ModuleForTests("qux", "android_common").Module().(*Library)
+	expectedDeps := map[string]int{headerJarTurbine: 1, headerJarIjar: 1, headerJarJavac: 1}
+	if g, w := qux.javaHeaderJarDepStrategies(), expectedDeps; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected qux header jar dependencies %v, got %v", w, g)
+	}
+
+	singleton := ctx.SingletonForTests("java_header_jars")
+	metrics := singleton.Output(javaHeaderJarsMetricsFileName)
+	lines := strings.Split(strings.TrimSuffix(android.ContentFromFileRuleForTests(t, metrics), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[3], "rebuild avoidance: ") {
+		t.Errorf("expected the metrics to end with the rebuild avoidance, got %q", lines)
+	}
+	singleton.Output("java-header-jar-metrics")
+}
+
+func TestHeaderJarDefault(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			header_jar: "turbine",
+		}
+	`
+
+	config := testConfig(map[string]string{"SOONG_JAVA_HEADER_JAR": "ijar"})
+	ctx := testContext(config, bp, nil)
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	if foo.MaybeRule("turbine").Rule != nil {
+		t.Errorf("expected no turbine rule for foo")
+	}
+	foo.Rule("ijar")
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	bar.Rule("turbine")
+	if bar.MaybeRule("ijar").Rule != nil {
+		t.Errorf("expected no ijar rule for bar")
+	}
+}
+
+func TestHeaderJarErrors(t *testing.T) {
+	testJavaError(t, `header_jar: unknown value "foo"`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			header_jar: "foo",
+		}
+	`)
+
+	testJavaError(t, `header_jar: turbine is only supported for device modules`, `
+		java_library_host {
+			name: "foo",
+			srcs: ["a.java"],
+			header_jar: "turbine",
+		}
+	`)
+
+	testJavaError(t, `header_jar: turbine can't run plugins that generate API`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			plugins: ["bar"],
+			header_jar: "turbine",
+		}
+
+		java_plugin {
+			name: "bar",
+			generates_api: true,
+		}
+	`)
+}
+
+func TestHeaderJarMetrics(t *testing.T) {
+	lines := headerJarMetrics(map[string]int{headerJarJavac: 1}, nil)
+	if g, w := lines[len(lines)-1], "rebuild avoidance: 0 of 0 classpath dependencies (0%) "+
+		"are only rebuilt when the API of the dependency changes"; g != w {
+		t.Errorf("expected %q, got %q", w, g)
+	}
+
+	lines = headerJarMetrics(map[string]int{headerJarTurbine: 3, headerJarIjar: 1},
+		map[string]int{headerJarTurbine: 3, headerJarIjar: 1})
+	if g, w := lines[len(lines)-1], "rebuild avoidance: 4 of 4 classpath dependencies (100%) "+
+		"are only rebuilt when the API of the dependency changes"; g != w {
+		t.Errorf("expected %q, got %q", w, g)
+	}
+}
@@ -74,12 +74,15 @@ func (h *hiddenAPI) hiddenAPI(ctx android.ModuleContext, dexJar android.ModuleOu
 		// not on the list then that will cause failures in the CtsHiddenApiBlacklist...
 		// tests.
 		if inList(bootJarName, ctx.Config().BootJars()) {
-			// Derive the greylist from classes jar.
-			flagsCSV := android.PathForModuleOut(ctx, "hiddenapi", "flags.csv")
-			metadataCSV := android.PathForModuleOut(ctx, "hiddenapi", "metadata.csv")
-			hiddenAPIGenerateCSV(ctx, flagsCSV, metadataCSV, implementationJar)
-			h.flagsCSVPath = flagsCSV
-			h.metadataCSVPath = metadataCSV
+			// Derive the greylist from classes jar.  Prebuilt dex jars have no classes jar, their flags
+			// only come from the monolithic lists.
+			if implementationJar != nil {
+				flagsCSV := android.PathForModuleOut(ctx, "hiddenapi", "flags.csv")
+				metadataCSV := android.PathForModuleOut(ctx, "hiddenapi", "metadata.csv")
+				hiddenAPIGenerateCSV(ctx, flagsCSV, metadataCSV, implementationJar)
+				h.flagsCSVPath = flagsCSV
+				h.metadataCSVPath = metadataCSV
+			}
 
 			// If this module is actually on the boot jars list and not providing
 			// hiddenapi information for a module on the boot jars list then encode
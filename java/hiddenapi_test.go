@@ -0,0 +1,73 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"testing"
+)
+
+func TestHiddenAPIEncodeDex(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			installable: true,
+		}
+
+		dex_import {
+			name: "bar",
+			jars: ["a.jar"],
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["a.java"],
+			installable: true,
+		}
+	`
+
+	config := testConfig(nil)
+	config.TestProductVariables.BootJars = []string{"foo", "bar"}
+
+	ctx := testContext(config, bp, nil)
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	flagsCSV := foo.Output("hiddenapi/flags.csv")
+	if g, w := flagsCSV.Input.String(), foo.Module().(*Library).implementationJarFile.String(); g != w {
+		t.Errorf("expected foo flags.csv to be generated from %q, got %q", w, g)
+	}
+	fooEncode := foo.Output("hiddenapi/foo.jar")
+	if g, w := foo.Module().(*Library).DexJar().String(), fooEncode.Output.String(); g != w {
+		t.Errorf("expected foo dex jar %q, got %q", w, g)
+	}
+
+	bar := ctx.ModuleForTests("bar", "android_common")
+	if csv := bar.MaybeOutput("hiddenapi/flags.csv"); csv.Rule != nil {
+		t.Errorf("expected no flags.csv for the prebuilt bar")
+	}
+	if g, w := bar.Description("hiddenapi encode dex").Input.String(), bar.Output("bar.jar").Output.String(); g != w {
+		t.Errorf("expected bar to encode %q, got %q", w, g)
+	}
+	barEncode := bar.Output("hiddenapi/bar.jar")
+	if g, w := bar.Module().(*DexImport).DexJar().String(), barEncode.Output.String(); g != w {
+		t.Errorf("expected bar dex jar %q, got %q", w, g)
+	}
+
+	baz := ctx.ModuleForTests("baz", "android_common")
+	if encode := baz.MaybeOutput("hiddenapi/baz.jar"); encode.Rule != nil {
+		t.Errorf("expected baz, which is not a boot jar, not to be encoded")
+	}
+}
@@ -16,9 +16,16 @@ package java
 
 // Rules for instrumenting classes using jacoco
 
+// When EMMA_INSTRUMENT is set to true, the classes of apps and of the framework modules are
+// instrumented, and the uninstrumented classes of each instrumented module are zipped under the
+// name and variant of the module into its coverage metadata zip.  The jacoco_report_classes
+// singleton merges the zips of all modules into jacoco-report-classes-all.jar, which is needed to
+// generate coverage reports from the execution data collected on the device.
+
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/google/blueprint"
@@ -44,8 +51,23 @@ var (
 		},
 	},
 		"strippedJar", "stripSpec", "tmpDir", "tmpJar")
+
+	jacocoReportClassesZip = pctx.AndroidStaticRule("jacocoReportClassesZip", blueprint.RuleParams{
+		Command:     `${config.Zip2ZipCmd} -i $in -o $out -t '**/*.class:$prefix'`,
+		CommandDeps: []string{"${config.Zip2ZipCmd}"},
+	},
+		"prefix")
+
+	jacocoReportClassesAll = pctx.AndroidStaticRule("jacocoReportClassesAll", blueprint.RuleParams{
+		Command:     `${config.MergeZipsCmd} -j $out $in`,
+		CommandDeps: []string{"${config.MergeZipsCmd}"},
+	})
 )
 
+func init() {
+	android.RegisterSingletonType("jacoco_report_classes", jacocoReportClassesSingletonFactory)
+}
+
 // Instruments a jar using the Jacoco command line interface.  Uses stripSpec to extract a subset
 // of the classes in inputJar into strippedJar, instruments strippedJar into tmpJar, and then
 // combines the classes in tmpJar with inputJar (preferring the instrumented classes in tmpJar)
@@ -71,6 +93,22 @@ func jacocoInstrumentJar(ctx android.ModuleContext, instrumentedJar, strippedJar
 	})
 }
 
+// jacocoBuildReportClassesZip zips the uninstrumented classes of the module under its name and
+// variant, so that the classes of every module can be merged into a single jar.
+func jacocoBuildReportClassesZip(ctx android.ModuleContext, reportClassesJar android.Path) android.Path {
+	zip := android.PathForModuleOut(ctx, "jacoco-report-classes.zip")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        jacocoReportClassesZip,
+		Description: "jacoco report classes zip",
+		Output:      zip,
+		Input:       reportClassesJar,
+		Args: map[string]string{
+			"prefix": filepath.Join(ctx.ModuleName(), ctx.ModuleSubDir()),
+		},
+	})
+	return zip
+}
+
 func (j *Module) jacocoModuleToZipCommand(ctx android.ModuleContext) string {
 	includes, err := jacocoFiltersToSpecs(j.properties.Jacoco.Include_filter)
 	if err != nil {
@@ -139,3 +177,68 @@ func jacocoFilterToSpec(filter string) (string, error) {
 
 	return spec, nil
 }
+
+type jacocoReportClassesProvider interface {
+	jacocoReportClassesZipFile() android.Path
+}
+
+func (j *Module) jacocoReportClassesZipFile() android.Path {
+	return j.jacocoReportClassesZip
+}
+
+var _ jacocoReportClassesProvider = (*Module)(nil)
+
+func jacocoReportClassesSingletonFactory() android.Singleton {
+	return &jacocoReportClassesSingleton{}
+}
+
+// jacocoReportClassesSingleton merges the coverage metadata zips of all instrumented modules into
+// jacoco-report-classes-all.jar, built by the jacoco-report-classes-all target.
+type jacocoReportClassesSingleton struct {
+	jacocoReportClassesAll android.WritablePath
+}
+
+func (j *jacocoReportClassesSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().IsEnvTrue("EMMA_INSTRUMENT") {
+		return
+	}
+
+	var zips android.Paths
+	ctx.VisitAllModules(func(m android.Module) {
+		if p, ok := m.(jacocoReportClassesProvider); ok && m.Enabled() && p.jacocoReportClassesZipFile() != nil {
+			zips = append(zips, p.jacocoReportClassesZipFile())
+		}
+	})
+
+	if len(zips) == 0 {
+		return
+	}
+
+	sort.Slice(zips, func(i, j int) bool {
+		return zips[i].String() < zips[j].String()
+	})
+
+	j.jacocoReportClassesAll = android.PathForOutput(ctx, "jacoco", "jacoco-report-classes-all.jar")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        jacocoReportClassesAll,
+		Description: "jacoco report classes",
+		Inputs:      zips,
+		Output:      j.jacocoReportClassesAll,
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   blueprint.Phony,
+		Output: android.PathForPhony(ctx, "jacoco-report-classes-all"),
+		Input:  j.jacocoReportClassesAll,
+	})
+}
+
+// Export the path of the merged jar to Make so that it can be added to dist along with the coverage
+// metadata of the modules built by Make.
+func (j *jacocoReportClassesSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if j.jacocoReportClassesAll != nil {
+		ctx.Strict("SOONG_JACOCO_REPORT_CLASSES_ALL", j.jacocoReportClassesAll.String())
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*jacocoReportClassesSingleton)(nil)
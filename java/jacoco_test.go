@@ -14,7 +14,13 @@
 
 package java
 
-import "testing"
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
 
 func TestJacocoFilterToSpecs(t *testing.T) {
 	testCases := []struct {
@@ -103,3 +109,55 @@ func TestJacocoFiltersToZipCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestJacocoReportClasses(t *testing.T) {
+	bp := `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+
+		java_library {
+			name: "jacocoagent",
+			srcs: ["c.java"],
+			sdk_version: "core_current",
+		}
+	`
+
+	config := testConfig(map[string]string{"EMMA_INSTRUMENT": "true"})
+	ctx := testContext(config, bp, nil)
+	ctx.RegisterSingletonType("jacoco_report_classes", android.SingletonFactoryAdaptor(jacocoReportClassesSingletonFactory))
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	fooReportClasses := filepath.Join(buildDir, ".intermediates", "foo", "android_common",
+		"jacoco-report-classes", "foo.jar")
+	fooZip := foo.Rule("jacocoReportClassesZip")
+	if g, w := fooZip.Input.String(), fooReportClasses; g != w {
+		t.Errorf("expected foo coverage metadata zip input %q, got %q", w, g)
+	}
+	if g, w := fooZip.Args["prefix"], "foo/android_common"; g != w {
+		t.Errorf("expected foo coverage metadata zip prefix %q, got %q", w, g)
+	}
+
+	jacocoagentHeaderJar := filepath.Join(buildDir, ".intermediates", "jacocoagent", "android_common",
+		"turbine-combined", "jacocoagent.jar")
+	if classpath := foo.Rule("javac").Args["classpath"]; !strings.Contains(classpath, jacocoagentHeaderJar) {
+		t.Errorf("expected foo classpath %q to contain %q", classpath, jacocoagentHeaderJar)
+	}
+
+	if ctx.ModuleForTests("bar", "android_common").MaybeRule("jacocoReportClassesZip").Rule != nil {
+		t.Errorf("expected bar not to be instrumented")
+	}
+
+	all := ctx.SingletonForTests("jacoco_report_classes").Rule("jacocoReportClassesAll")
+	if len(all.Inputs) != 1 || all.Inputs[0].String() != fooZip.Output.String() {
+		t.Errorf("expected jacoco-report-classes-all.jar inputs [%q], got %q", fooZip.Output, all.Inputs)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
+	"android/soong/dexpreopt"
 	"android/soong/java/config"
 	"android/soong/tradefed"
 )
@@ -120,9 +121,32 @@ type CompilerProperties struct {
 	// List of modules to use as annotation processors
 	Plugins []string
 
-	// The number of Java source entries each Javac instance can process
+	// The number of java source files each javac instance compiles.  If set, the sources are split into shards
+	// that are compiled in parallel against the header jar of the module and merged into its jar, which speeds
+	// up the compilation of very large libraries.  The annotation processors of the module are run once over all
+	// the sources.  Requires the header jar to be generated by turbine.
 	Javac_shard_size *int64
 
+	// If set, javac only sees the header jars of the modules listed directly in libs and static_libs,
+	// and not the classes those modules pulled in through their own static_libs.  Can be "warning",
+	// which compiles the module normally but reports references to classes that are only available
+	// transitively along with suggested direct dependencies, or "error", which fails the compile with
+	// the same suggestions.
+	Strict_java_deps *string
+
+	// Name of the file in the module directory that lists the existing javac warnings that the
+	// PRODUCT_JAVA_WERROR_POLICIES of the module directory should not turn into errors.  Defaults to
+	// java-werror-baseline.txt.  The file is updated by the <module>-update-werror-baseline target.
+	Werror_baseline_filename *string
+
+	// How the header jar that dependent modules compile against is generated.  Can be "turbine",
+	// which generates it from the sources without compiling them, "ijar", which strips the method
+	// bodies from the classes compiled by javac, or "javac", which uses the classes compiled by javac.
+	// Dependents are only recompiled when the header jar changes, so "turbine" and "ijar" avoid
+	// recompiling them for changes that don't affect the API.  Defaults to SOONG_JAVA_HEADER_JAR if
+	// it is set, or "turbine" for device modules and "javac" for host modules.
+	Header_jar *string
+
 	// Add host jdk tools.jar to bootclasspath
 	Use_tools_jar *bool
 
@@ -173,6 +197,10 @@ type CompilerProperties struct {
 
 	Instrument bool `blueprint:"mutated"`
 
+	// Set by strictJavaDepsMutator when a module that sets strict_java_deps needs the header jar of only the
+	// classes of this module.
+	StrictJavaDepsDirectHeaderJar bool `blueprint:"mutated"`
+
 	// List of files to include in the META-INF/services folder of the resulting jar.
 	Services []string `android:"path,arch_variant"`
 }
@@ -227,6 +255,24 @@ type CompilerDeviceProperties struct {
 	// If set to true, compile dex regardless of installable.  Defaults to false.
 	Compile_dex *bool
 
+	Multidex struct {
+		// If true, allow the classes to be split into multiple dex files when min_sdk_version is below
+		// 21, where only classes.dex is loaded by the platform and the app has to load the other dex
+		// files itself.  The classes are always split into as many dex files as needed when
+		// min_sdk_version is 21 or higher.  Also enabled by --multi-dex in dxflags.  Defaults to false.
+		Enabled *bool
+
+		// Specifies the locations of files containing proguard keep rules that select the classes
+		// that have to be in classes.dex, like the classes that load the other dex files.  d8 and r8
+		// generate the main dex list from them.  Required when multidex is enabled and min_sdk_version
+		// is below 21, and not allowed otherwise.
+		Main_dex_rules []string `android:"path"`
+	}
+
+	// If true, fail the build if the classes don't fit in a single dex file.  Can't be combined with
+	// multidex.  Defaults to false.
+	Single_dex *bool
+
 	Optimize struct {
 		// If false, disable all optimization.  Defaults to true for android_app and android_test
 		// modules, false for java_library and java_test modules.
@@ -251,15 +297,22 @@ type CompilerDeviceProperties struct {
 		// Flags to pass to proguard.
 		Proguard_flags []string
 
-		// Specifies the locations of files containing proguard flags.
+		// Specifies the locations of files containing proguard flags.  They are also used when
+		// optimizing any app or library that has this module in its static_libs.
 		Proguard_flags_files []string `android:"path"`
+
+		// If true, runs R8 in Proguard compatibility mode, otherwise runs R8 in full mode, which
+		// performs more aggressive optimizations.  Defaults to true.
+		Proguard_compatibility *bool
 	}
 
 	// When targeting 1.9, override the modules to use with --system
 	System_modules *string
 
-	UncompressDex bool `blueprint:"mutated"`
-	IsSDKLibrary  bool `blueprint:"mutated"`
+	UncompressDex       bool `blueprint:"mutated"`
+	IsSDKLibrary        bool `blueprint:"mutated"`
+	DesugarReport       bool `blueprint:"mutated"`
+	DesugarReportErrors bool `blueprint:"mutated"`
 }
 
 func (me *CompilerDeviceProperties) EffectiveOptimizeEnabled() bool {
@@ -279,6 +332,14 @@ type Module struct {
 	// inserting into the bootclasspath/classpath of another compile
 	headerJarFile android.Path
 
+	// jar file containing header classes of this module only, without any static library
+	// dependencies, used by modules that set strict_java_deps
+	directHeaderJarFile android.Path
+
+	// modules included through static_libs, directly or transitively, along with their direct
+	// header jars, used to suggest missing direct dependencies for strict_java_deps
+	staticLibCandidates []strictJavaDepsCandidate
+
 	// jar file containing implementation classes including static library dependencies but no
 	// resources
 	implementationJarFile android.Path
@@ -303,9 +364,19 @@ type Module struct {
 	// output file containing uninstrumented classes that will be instrumented by jacoco
 	jacocoReportClassesFile android.Path
 
+	// zip of the uninstrumented classes under the name and variant of the module, merged into
+	// jacoco-report-classes-all.jar
+	jacocoReportClassesZip android.Path
+
+	// .kzip files of the compilations of the module, extracted for code search cross-references
+	kytheFiles android.Paths
+
 	// output file containing mapping of obfuscated names
 	proguardDictionary android.Path
 
+	// zip of the list of code removed by R8, only set if the module is shrunk
+	proguardUsageZip android.Path
+
 	// output file of the module, which may be a classes jar or a dex jar
 	outputFile       android.Path
 	extraOutputFiles android.Paths
@@ -321,6 +392,17 @@ type Module struct {
 	compiledJavaSrcs android.Paths
 	compiledSrcJars  android.Paths
 
+	// list of .kt files that was passed to kotlinc
+	compiledKotlinSrcs android.Paths
+
+	// list of generated .java files and srcjars, including the output of annotation processors
+	generatedJavaSrcs android.Paths
+	generatedSrcJars  android.Paths
+
+	// list of proguard flag files of this module and its static_libs, used when optimizing the
+	// modules that have this module in their static_libs
+	exportedProguardFlagFiles android.Paths
+
 	// list of extra progurad flag files
 	extraProguardFlagFiles android.Paths
 
@@ -330,6 +412,13 @@ type Module struct {
 	// list of SDK lib names that this java moudule is exporting
 	exportedSdkLibs []string
 
+	// class loader contexts of the SDK libs that this java module is exporting, used to dexpreopt apps that
+	// depend on it
+	classLoaderContexts dexpreopt.ClassLoaderContextMap
+
+	// location of the installed dex jar on the device
+	dexJarInstallLocation string
+
 	// list of source files, collected from compiledJavaSrcs and compiledSrcJars
 	// filter out Exclude_srcs, will be used by android.IDEInfo struct
 	expandIDEInfoCompiledSrcs []string
@@ -343,8 +432,29 @@ type Module struct {
 	// Extra files generated by the module type to be added as java resources.
 	extraResources android.Paths
 
+	// Extra jars generated by the module type that are only added to the compile classpath, like libs.
+	extraClasspathJars android.Paths
+
+	// Extra srcjars generated by the module type that are compiled with the sources of the module.
+	extraSrcJars android.Paths
+
+	// output of javac with the Error Prone plugin, containing its findings, when RUN_ERROR_PRONE is set
+	errorProneFindings android.Path
+
+	// the warnings that the java werror policies select, as reported by javac, and the baseline they are
+	// checked against, relative to the top of the source tree
+	werrorWarnings android.Path
+	werrorBaseline string
+
+	// how the header jar of the module is generated, one of the headerJar* constants
+	headerJarStrategy string
+
+	// number of libs and static_libs of the module by the way their header jar is generated
+	headerJarDepStrategies map[string]int
+
 	hiddenAPI
 	dexpreopter
+	linter
 }
 
 func (j *Module) OutputFiles(tag string) (android.Paths, error) {
@@ -353,6 +463,16 @@ func (j *Module) OutputFiles(tag string) (android.Paths, error) {
 		return append(android.Paths{j.outputFile}, j.extraOutputFiles...), nil
 	case ".jar":
 		return android.Paths{j.implementationAndResourcesJar}, nil
+	case ".dex.jar":
+		if j.dexJarFile == nil {
+			return nil, fmt.Errorf("%q has no dex jar, it must be installable or set compile_dex: true", j.Name())
+		}
+		return android.Paths{j.dexJarFile}, nil
+	case ".proguard_map":
+		if j.proguardDictionary == nil {
+			return nil, nil
+		}
+		return android.Paths{j.proguardDictionary}, nil
 	default:
 		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 	}
@@ -380,6 +500,34 @@ type SdkLibraryDependency interface {
 	SdkImplementationJars(ctx android.BaseModuleContext, sdkVersion string) android.Paths
 }
 
+// UsesLibraryDependency is implemented by modules that can be shared libraries of an app, through uses_libs or
+// through a java_sdk_library in libs.
+type UsesLibraryDependency interface {
+	DexJar() android.Path
+	DexJarInstallLocation() string
+	ClassLoaderContexts() dexpreopt.ClassLoaderContextMap
+}
+
+// addClassLoaderContext adds the class loader context of a shared library dependency, which includes the contexts
+// of the shared libraries it uses itself, to a ClassLoaderContextMap.  Dependencies that don't produce a dex jar,
+// like prebuilt SDK libraries, are skipped.
+func addClassLoaderContext(ctx android.ModuleContext, clcMap dexpreopt.ClassLoaderContextMap, sdkVer int,
+	lib string, devicePath string, dep android.Module) {
+
+	usesLib, ok := dep.(UsesLibraryDependency)
+	if !ok || usesLib.DexJar() == nil {
+		return
+	}
+	if devicePath == "" {
+		devicePath = usesLib.DexJarInstallLocation()
+	}
+	err := clcMap.AddContext(sdkVer, lib, usesLib.DexJar(), devicePath,
+		usesLib.ClassLoaderContexts()[dexpreopt.AnySdkVersion])
+	if err != nil {
+		ctx.ModuleErrorf("%s", err.Error())
+	}
+}
+
 type SrcDependency interface {
 	CompiledSrcs() android.Paths
 	CompiledSrcJars() android.Paths
@@ -395,6 +543,18 @@ func (j *Module) CompiledSrcJars() android.Paths {
 
 var _ SrcDependency = (*Module)(nil)
 
+// ProguardFlagFilesDependency is implemented by static_libs dependencies whose proguard flag files
+// must be used when optimizing the modules that include them.
+type ProguardFlagFilesDependency interface {
+	ExportedProguardFlagFiles() android.Paths
+}
+
+func (j *Module) ExportedProguardFlagFiles() android.Paths {
+	return j.exportedProguardFlagFiles
+}
+
+var _ ProguardFlagFilesDependency = (*Module)(nil)
+
 func InitJavaModule(module android.DefaultableModule, hod android.HostOrDeviceSupported) {
 	android.InitAndroidArchModule(module, hod, android.MultilibCommon)
 	android.InitDefaultableModule(module)
@@ -452,6 +612,12 @@ type jniLib struct {
 	name   string
 	path   android.Path
 	target android.Target
+
+	// names of the shared libraries the JNI library links against
+	sharedLibs []string
+
+	// table of contents of the JNI library, listing its dynamic symbols
+	toc android.OptionalPath
 }
 
 func (j *Module) shouldInstrument(ctx android.BaseModuleContext) bool {
@@ -544,6 +710,11 @@ func (j *Module) deps(ctx android.BottomUpMutatorContext) {
 
 	if j.shouldInstrumentStatic(ctx) {
 		ctx.AddVariationDependencies(nil, staticLibTag, "jacocoagent")
+	} else if j.shouldInstrument(ctx) && ctx.Device() {
+		// The instrumented classes reference the jacoco runtime, which is loaded from the
+		// bootclasspath of coverage builds.  Compile against it so that R8 can resolve the
+		// references when it optimizes the module.
+		ctx.AddVariationDependencies(nil, libTag, "jacocoagent")
 	}
 }
 
@@ -633,6 +804,13 @@ type deps struct {
 	kotlinStdlib       android.Paths
 	kotlinAnnotations  android.Paths
 
+	// classpath containing only the direct header jars of libs and static_libs, used for
+	// strict_java_deps
+	strictClasspath classpath
+	// modules that are only reachable through the static_libs of direct dependencies, used to
+	// suggest missing direct dependencies for strict_java_deps
+	strictCandidates []strictJavaDepsCandidate
+
 	disableTurbine bool
 }
 
@@ -725,6 +903,9 @@ func checkLinkType(ctx android.ModuleContext, from *Module, to *Library, tag dep
 func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 	var deps deps
 
+	j.classLoaderContexts = make(dexpreopt.ClassLoaderContextMap)
+	j.headerJarDepStrategies = make(map[string]int)
+
 	if ctx.Device() {
 		sdkDep := decodeSdkDep(ctx, sdkContext(j))
 		if sdkDep.invalidVersion {
@@ -732,6 +913,7 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 		} else if sdkDep.useFiles {
 			// sdkDep.jar is actually equivalent to turbine header.jar.
 			deps.classpath = append(deps.classpath, sdkDep.jars...)
+			deps.strictClasspath = append(deps.strictClasspath, sdkDep.jars...)
 			deps.aidlPreprocess = sdkDep.aidl
 		} else {
 			deps.aidlPreprocess = sdkDep.aidl
@@ -762,8 +944,20 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 			switch tag {
 			case libTag:
 				deps.classpath = append(deps.classpath, dep.SdkHeaderJars(ctx, j.sdkVersion())...)
+				deps.strictClasspath = append(deps.strictClasspath, dep.SdkHeaderJars(ctx, j.sdkVersion())...)
 				// names of sdk libs that are directly depended are exported
 				j.exportedSdkLibs = append(j.exportedSdkLibs, otherName)
+				if ctx.Device() {
+					addClassLoaderContext(ctx, j.classLoaderContexts, dexpreopt.AnySdkVersion, otherName, "", module)
+				}
+			case instrumentationForTag:
+				// A test instruments the implementation of a java_sdk_library, not the stubs of its API.
+				if lib, ok := module.(Dependency); ok {
+					deps.classpath = append(deps.classpath, lib.ImplementationJars()...)
+					deps.strictClasspath = append(deps.strictClasspath, lib.ImplementationJars()...)
+				} else {
+					ctx.PropertyErrorf("instrumentation_for", "%q has no implementation classes to instrument", otherName)
+				}
 			case staticLibTag:
 				ctx.ModuleErrorf("dependency on java_sdk_library %q can only be in libs", otherName)
 			}
@@ -772,18 +966,45 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 			case bootClasspathTag:
 				deps.bootClasspath = append(deps.bootClasspath, dep.HeaderJars()...)
 			case libTag, instrumentationForTag:
-				deps.classpath = append(deps.classpath, dep.HeaderJars()...)
+				if _, isApp := module.(*AndroidApp); tag == instrumentationForTag && !isApp {
+					// The header jar of a library doesn't have the classes generated by its annotation
+					// processors, which its tests commonly use.  The library is installed on the device
+					// separately, so its implementation jar is only on the classpath, not linked statically.
+					deps.classpath = append(deps.classpath, dep.ImplementationJars()...)
+					deps.strictClasspath = append(deps.strictClasspath, dep.ImplementationJars()...)
+				} else {
+					deps.classpath = append(deps.classpath, dep.HeaderJars()...)
+					deps.strictClasspath = append(deps.strictClasspath, directHeaderJars(dep)...)
+				}
+				j.countHeaderJarDep(dep)
+				deps.strictCandidates = append(deps.strictCandidates, staticLibCandidates(dep)...)
 				// sdk lib names from dependencies are re-exported
 				j.exportedSdkLibs = append(j.exportedSdkLibs, dep.ExportedSdkLibs()...)
+				if lib, ok := dep.(UsesLibraryDependency); ok {
+					j.classLoaderContexts.AddContextMap(lib.ClassLoaderContexts())
+				}
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs()...)
 			case staticLibTag:
 				deps.classpath = append(deps.classpath, dep.HeaderJars()...)
+				deps.strictClasspath = append(deps.strictClasspath, directHeaderJars(dep)...)
+				j.countHeaderJarDep(dep)
+				deps.strictCandidates = append(deps.strictCandidates, staticLibCandidates(dep)...)
+				j.staticLibCandidates = append(j.staticLibCandidates,
+					strictJavaDepsCandidate{otherName, directHeaderJars(dep)})
+				j.staticLibCandidates = append(j.staticLibCandidates, staticLibCandidates(dep)...)
 				deps.staticJars = append(deps.staticJars, dep.ImplementationJars()...)
 				deps.staticHeaderJars = append(deps.staticHeaderJars, dep.HeaderJars()...)
 				deps.staticResourceJars = append(deps.staticResourceJars, dep.ResourceJars()...)
 				// sdk lib names from dependencies are re-exported
 				j.exportedSdkLibs = append(j.exportedSdkLibs, dep.ExportedSdkLibs()...)
+				if lib, ok := dep.(UsesLibraryDependency); ok {
+					j.classLoaderContexts.AddContextMap(lib.ClassLoaderContexts())
+				}
 				deps.aidlIncludeDirs = append(deps.aidlIncludeDirs, dep.AidlIncludeDirs()...)
+				if lib, ok := dep.(ProguardFlagFilesDependency); ok {
+					j.exportedProguardFlagFiles = append(j.exportedProguardFlagFiles,
+						lib.ExportedProguardFlagFiles()...)
+				}
 			case pluginTag:
 				if plugin, ok := dep.(*Plugin); ok {
 					deps.processorPath = append(deps.processorPath, dep.ImplementationAndResourcesJars()...)
@@ -823,9 +1044,11 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 			case libTag:
 				checkProducesJars(ctx, dep)
 				deps.classpath = append(deps.classpath, dep.Srcs()...)
+				deps.strictClasspath = append(deps.strictClasspath, dep.Srcs()...)
 			case staticLibTag:
 				checkProducesJars(ctx, dep)
 				deps.classpath = append(deps.classpath, dep.Srcs()...)
+				deps.strictClasspath = append(deps.strictClasspath, dep.Srcs()...)
 				deps.staticJars = append(deps.staticJars, dep.Srcs()...)
 				deps.staticHeaderJars = append(deps.staticHeaderJars, dep.Srcs()...)
 			}
@@ -847,6 +1070,10 @@ func (j *Module) collectDeps(ctx android.ModuleContext) deps {
 
 	j.exportedSdkLibs = android.FirstUniqueStrings(j.exportedSdkLibs)
 
+	j.exportedProguardFlagFiles = append(j.exportedProguardFlagFiles,
+		android.PathsForModuleSrc(ctx, j.deviceProperties.Optimize.Proguard_flags_files)...)
+	j.exportedProguardFlagFiles = android.FirstUniquePaths(j.exportedProguardFlagFiles)
+
 	return deps
 }
 
@@ -982,6 +1209,10 @@ func (j *Module) collectBuilderFlags(ctx android.ModuleContext, deps deps) javaB
 		flags.javacFlags = "$javacFlags"
 	}
 
+	// kapt runs the annotation processors instead of javac when there are kotlin sources, so it
+	// needs the -A annotation processor options that were passed in the javac flags.
+	flags.kaptProcessorOptions = kaptProcessorOptions(javacFlags)
+
 	return flags
 }
 
@@ -990,6 +1221,12 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 	j.exportAidlIncludeDirs = android.PathsForModuleSrc(ctx, j.deviceProperties.Aidl.Export_include_dirs)
 
 	deps := j.collectDeps(ctx)
+	deps.classpath = append(deps.classpath, j.extraClasspathJars...)
+	deps.strictClasspath = append(deps.strictClasspath, j.extraClasspathJars...)
+	strictJavaDeps := j.strictJavaDeps(ctx)
+	if strictJavaDeps == strictJavaDepsError {
+		deps.classpath = deps.strictClasspath
+	}
 	flags := j.collectBuilderFlags(ctx, deps)
 
 	if flags.javaVersion == "1.9" {
@@ -1004,8 +1241,10 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 
 	srcJars := srcFiles.FilterByExt(".srcjar")
 	srcJars = append(srcJars, deps.srcJars...)
+	srcJars = append(srcJars, j.extraSrcJars...)
 	if aaptSrcJar != nil {
 		srcJars = append(srcJars, aaptSrcJar)
+		j.generatedSrcJars = append(j.generatedSrcJars, aaptSrcJar)
 	}
 
 	// Collect source files from compiledJavaSrcs, compiledSrcJars and filter out Exclude_srcs
@@ -1048,9 +1287,11 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 			flags.kotlincFlags += "$kotlincFlags"
 		}
 
+		j.compiledKotlinSrcs = srcFiles.FilterByExt(".kt")
+
 		var kotlinSrcFiles android.Paths
 		kotlinSrcFiles = append(kotlinSrcFiles, uniqueSrcFiles...)
-		kotlinSrcFiles = append(kotlinSrcFiles, srcFiles.FilterByExt(".kt")...)
+		kotlinSrcFiles = append(kotlinSrcFiles, j.compiledKotlinSrcs...)
 
 		flags.classpath = append(flags.classpath, deps.kotlinStdlib...)
 		flags.classpath = append(flags.classpath, deps.kotlinAnnotations...)
@@ -1063,6 +1304,7 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 			kaptSrcJar := android.PathForModuleOut(ctx, "kapt", "kapt-sources.jar")
 			kotlinKapt(ctx, kaptSrcJar, kotlinSrcFiles, srcJars, flags)
 			srcJars = append(srcJars, kaptSrcJar)
+			j.generatedSrcJars = append(j.generatedSrcJars, kaptSrcJar)
 			// Disable annotation processing in javac, it's already been handled by kapt
 			flags.processorPath = nil
 			flags.processor = ""
@@ -1088,15 +1330,15 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 	j.compiledJavaSrcs = uniqueSrcFiles
 	j.compiledSrcJars = srcJars
 
+	j.headerJarStrategy = j.chooseHeaderJarStrategy(ctx, deps)
+
 	enable_sharding := false
-	if ctx.Device() && !ctx.Config().IsEnvFalse("TURBINE_ENABLED") && !deps.disableTurbine {
+	if j.headerJarStrategy == headerJarTurbine {
 		if j.properties.Javac_shard_size != nil && *(j.properties.Javac_shard_size) > 0 {
 			enable_sharding = true
-			// Formerly, there was a check here that prevented annotation processors
-			// from being used when sharding was enabled, as some annotation processors
-			// do not function correctly in sharded environments. It was removed to
-			// allow for the use of annotation processors that do function correctly
-			// with sharding enabled. See: b/77284273.
+			// Annotation processors are run once over all the sources before the shards are
+			// compiled, so that processors that aggregate over the sources of the module keep
+			// working with sharding enabled.
 		}
 		j.headerJarFile = j.compileJavaHeader(ctx, uniqueSrcFiles, srcJars, deps, flags, jarName, kotlinJars)
 		if ctx.Failed() {
@@ -1112,8 +1354,39 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 			// TODO(ccross): Once we always compile with javac9 we may be able to conditionally
 			//    enable error-prone without affecting the output class files.
 			errorprone := android.PathForModuleOut(ctx, "errorprone", jarName)
-			RunErrorProne(ctx, errorprone, uniqueSrcFiles, srcJars, flags)
+			findings := android.PathForModuleOut(ctx, "errorprone", "findings.txt")
+			RunErrorProne(ctx, errorprone, findings, uniqueSrcFiles, srcJars, flags)
 			extraJarDeps = append(extraJarDeps, errorprone)
+			j.errorProneFindings = findings
+		}
+
+		if strictJavaDeps != "" {
+			// Compile the java files a second time against only the direct dependencies, reporting
+			// any classes that are only available transitively along with the dependencies to add.
+			// In error mode the check fails the build before javac runs against the same classpath.
+			// The kotlin jars that were appended to flags.classpath after deps.classpath are kept.
+			strictFlags := flags
+			strictFlags.classpath = append(classpath(nil), deps.strictClasspath...)
+			strictFlags.classpath = append(strictFlags.classpath, flags.classpath[len(deps.classpath):]...)
+			strictCheck := android.PathForModuleOut(ctx, "strict-java-deps", "check.timestamp")
+			checkStrictJavaDeps(ctx, strictCheck, uniqueSrcFiles, srcJars, strictFlags, deps.strictCandidates,
+				strictJavaDeps == strictJavaDepsError)
+			extraJarDeps = append(extraJarDeps, strictCheck)
+		}
+
+		if warnings := ctx.DeviceConfig().JavaWerrorWarningsFor(ctx.ModuleDir()); len(warnings) > 0 {
+			// Compile the java files a second time with the warnings selected by the product enabled,
+			// failing the build if they report any warning that is not in the baseline of the module.
+			werrorCheck := j.checkJavaWerror(ctx, warnings, uniqueSrcFiles, srcJars, flags)
+			extraJarDeps = append(extraJarDeps, werrorCheck)
+		}
+
+		if ctx.Config().EmitXrefRules() {
+			// The extraction is only built by the xref_java target, it is not a dependency of the
+			// classes jar.
+			kzip := android.PathForModuleOut(ctx, "kythe", jarName+".kzip")
+			emitXrefRule(ctx, kzip, uniqueSrcFiles, srcJars, flags, nil)
+			j.kytheFiles = append(j.kytheFiles, kzip)
 		}
 
 		if enable_sharding {
@@ -1122,21 +1395,47 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 			var shardSrcs []android.Paths
 			if len(uniqueSrcFiles) > 0 {
 				shardSrcs = shardPaths(uniqueSrcFiles, shardSize)
-				for idx, shardSrc := range shardSrcs {
-					classes := android.PathForModuleOut(ctx, "javac", jarName+strconv.Itoa(idx))
-					TransformJavaToClasses(ctx, classes, idx, shardSrc, nil, flags, extraJarDeps)
-					jars = append(jars, classes)
-				}
+			}
+			shardCount := len(shardSrcs)
+			if len(srcJars) > 0 {
+				shardCount++
+			}
+			if flags.processor != "" {
+				// Annotation processors may aggregate over all the sources of the module, so they are
+				// run once over all of them instead of once per shard.  The sources they generate are
+				// compiled in an extra shard that the other shards compile against, as they may
+				// reference the generated classes.
+				aptOutputs := android.PathForModuleOut(ctx, "javac-apt", jarName)
+				annoSrcJar := RunAnnotationProcessors(ctx, aptOutputs, uniqueSrcFiles, srcJars, flags, extraJarDeps)
+				jars = append(jars, aptOutputs)
+				j.addGeneratedSrcJar(annoSrcJar)
+
+				flags.processor = ""
+				flags.processorPath = nil
+
+				generatedClasses := android.PathForModuleOut(ctx, "javac", jarName+strconv.Itoa(shardCount))
+				TransformJavaToClasses(ctx, generatedClasses, shardCount, nil, android.Paths{annoSrcJar}, flags,
+					extraJarDeps)
+				jars = append(jars, generatedClasses)
+				flags.classpath = append(flags.classpath, generatedClasses)
+			}
+			for idx, shardSrc := range shardSrcs {
+				classes := android.PathForModuleOut(ctx, "javac", jarName+strconv.Itoa(idx))
+				annoSrcJar := TransformJavaToClasses(ctx, classes, idx, shardSrc, nil, flags, extraJarDeps)
+				jars = append(jars, classes)
+				j.addGeneratedSrcJar(annoSrcJar)
 			}
 			if len(srcJars) > 0 {
 				classes := android.PathForModuleOut(ctx, "javac", jarName+strconv.Itoa(len(shardSrcs)))
-				TransformJavaToClasses(ctx, classes, len(shardSrcs), nil, srcJars, flags, extraJarDeps)
+				annoSrcJar := TransformJavaToClasses(ctx, classes, len(shardSrcs), nil, srcJars, flags, extraJarDeps)
 				jars = append(jars, classes)
+				j.addGeneratedSrcJar(annoSrcJar)
 			}
 		} else {
 			classes := android.PathForModuleOut(ctx, "javac", jarName)
-			TransformJavaToClasses(ctx, classes, -1, uniqueSrcFiles, srcJars, flags, extraJarDeps)
+			annoSrcJar := TransformJavaToClasses(ctx, classes, -1, uniqueSrcFiles, srcJars, flags, extraJarDeps)
 			jars = append(jars, classes)
+			j.addGeneratedSrcJar(annoSrcJar)
 		}
 		if ctx.Failed() {
 			return
@@ -1283,7 +1582,24 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 
 	j.implementationJarFile = outputFile
 	if j.headerJarFile == nil {
-		j.headerJarFile = j.implementationJarFile
+		if j.headerJarStrategy == headerJarIjar {
+			headerJar := android.PathForModuleOut(ctx, "ijar", jarName)
+			TransformJarToHeaderJar(ctx, headerJar, j.implementationJarFile)
+			j.headerJarFile = headerJar
+		} else {
+			j.headerJarFile = j.implementationJarFile
+		}
+	}
+
+	if ctx.Device() {
+		j.linter.name = ctx.ModuleName()
+		j.linter.srcs = append(append(android.Paths(nil), j.compiledKotlinSrcs...), uniqueSrcFiles...)
+		j.linter.srcJars = srcJars
+		j.linter.classpath = append(android.Paths(nil), flags.bootClasspath...)
+		j.linter.classpath = append(j.linter.classpath, flags.classpath...)
+		j.linter.classes = j.implementationJarFile
+		j.linter.updatable = j.deviceProperties.Min_sdk_version != nil
+		j.linter.lint(ctx)
 	}
 
 	if ctx.Config().IsEnvTrue("EMMA_INSTRUMENT_FRAMEWORK") {
@@ -1341,6 +1657,11 @@ func (j *Module) compile(ctx android.ModuleContext, aaptSrcJar android.Path) {
 		j.dexJarFile = dexOutputFile
 
 		// Dexpreopting
+		if j.dexpreopter.enforceUsesLibs {
+			// java_sdk_library dependencies in libs are added to the manifest as <uses-library> tags, so they
+			// are shared libraries of the app too.
+			j.dexpreopter.classLoaderContexts.AddContextMap(j.classLoaderContexts)
+		}
 		dexOutputFile = j.dexpreopt(ctx, dexOutputFile)
 
 		j.maybeStrippedDexJarFile = dexOutputFile
@@ -1413,6 +1734,18 @@ func (j *Module) compileJavaHeader(ctx android.ModuleContext, srcFiles, srcJars
 		false, nil, []string{"META-INF/TRANSITIVE"})
 	headerJar = combinedJar
 
+	// Modules that set strict_java_deps see only the classes of this module, not those of its
+	// static libraries.  Jarjar rules may rename classes across both, so fall back to the
+	// combined jar when they are used.
+	if j.properties.StrictJavaDepsDirectHeaderJar && len(deps.staticHeaderJars) > 0 &&
+		j.expandJarjarRules == nil {
+		directJars := jars[:len(jars)-len(deps.staticHeaderJars)]
+		directJar := android.PathForModuleOut(ctx, "turbine-direct", jarName)
+		TransformJarsToJar(ctx, directJar, "for strict java deps", directJars, android.OptionalPath{},
+			false, nil, []string{"META-INF/TRANSITIVE"})
+		j.directHeaderJarFile = directJar
+	}
+
 	if j.expandJarjarRules != nil {
 		// Transform classes.jar into classes-jarjar.jar
 		jarjarFile := android.PathForModuleOut(ctx, "turbine-jarjar", jarName)
@@ -1437,6 +1770,7 @@ func (j *Module) instrument(ctx android.ModuleContext, flags javaBuilderFlags,
 	jacocoInstrumentJar(ctx, instrumentedJar, jacocoReportClassesFile, classesJar, specs)
 
 	j.jacocoReportClassesFile = jacocoReportClassesFile
+	j.jacocoReportClassesZip = jacocoBuildReportClassesZip(ctx, jacocoReportClassesFile)
 
 	return instrumentedJar
 }
@@ -1485,6 +1819,14 @@ func (j *Module) ExportedSdkLibs() []string {
 	return j.exportedSdkLibs
 }
 
+func (j *Module) DexJarInstallLocation() string {
+	return j.dexJarInstallLocation
+}
+
+func (j *Module) ClassLoaderContexts() dexpreopt.ClassLoaderContextMap {
+	return j.classLoaderContexts
+}
+
 func (j *Module) SrcJarArgs() ([]string, android.Paths) {
 	return j.srcJarArgs, j.srcJarDeps
 }
@@ -1513,11 +1855,53 @@ func (j *Module) CompilerDeps() []string {
 	return jdeps
 }
 
+// hasCode returns true if the module has any sources, or if any module in the transitive closure of its
+// static_libs compiled java or kotlin sources or is not built from sources.
 func (j *Module) hasCode(ctx android.ModuleContext) bool {
 	srcFiles := android.PathsForModuleSrcExcludes(ctx, j.properties.Srcs, j.properties.Exclude_srcs)
-	return len(srcFiles) > 0 || len(ctx.GetDirectDepsWithTag(staticLibTag)) > 0
+	if len(srcFiles) > 0 {
+		return true
+	}
+
+	hasCode := false
+	ctx.WalkDeps(func(child, parent android.Module) bool {
+		if hasCode || ctx.OtherModuleDependencyTag(child) != staticLibTag {
+			return false
+		}
+		if isSourceless(child) {
+			// The static library is sourceless, but its own static_libs may still contain code.
+			return true
+		}
+		hasCode = true
+		return false
+	})
+	return hasCode
+}
+
+// isSourceless returns true if a dependency didn't compile any java or kotlin sources of its own.
+func isSourceless(dep android.Module) bool {
+	srcDep, ok := dep.(SrcDependency)
+	if !ok || len(srcDep.CompiledSrcs()) > 0 || len(srcDep.CompiledSrcJars()) > 0 {
+		return false
+	}
+	if kotlinDep, ok := dep.(kotlinSrcDependency); ok && len(kotlinDep.compiledKotlinSources()) > 0 {
+		return false
+	}
+	return true
+}
+
+// kotlinSrcDependency is implemented by the modules that compile kotlin sources, which are not part of
+// CompiledSrcs since only javac and the doc tools use those.
+type kotlinSrcDependency interface {
+	compiledKotlinSources() android.Paths
+}
+
+func (j *Module) compiledKotlinSources() android.Paths {
+	return j.compiledKotlinSrcs
 }
 
+var _ kotlinSrcDependency = (*Module)(nil)
+
 //
 // Java libraries (.jar file)
 //
@@ -1545,7 +1929,11 @@ func shouldUncompressDex(ctx android.ModuleContext, dexpreopter *dexpreopter) bo
 }
 
 func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	j.dexpreopter.installPath = android.PathForModuleInstall(ctx, "framework", ctx.ModuleName()+".jar")
+	if ctx.Device() {
+		j.dexJarInstallLocation = android.InstallPathToOnDevicePath(ctx, j.dexpreopter.installPath)
+	}
 	j.dexpreopter.isSDKLibrary = j.deviceProperties.IsSDKLibrary
 	j.dexpreopter.isInstallable = Bool(j.properties.Installable)
 	j.dexpreopter.uncompressedDex = shouldUncompressDex(ctx, &j.dexpreopter)
@@ -1555,6 +1943,7 @@ func (j *Library) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	if (Bool(j.properties.Installable) || ctx.Host()) && !android.DirectlyInAnyApex(ctx, ctx.ModuleName()) {
 		j.installFile = ctx.InstallFile(android.PathForModuleInstall(ctx, "framework"),
 			ctx.ModuleName()+".jar", j.outputFile)
+		j.dexpreopter.installBuiltFiles(ctx)
 	}
 }
 
@@ -1580,8 +1969,11 @@ func LibraryFactory() android.Module {
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties)
 
+	module.Module.linter.library = true
+
 	InitJavaModule(module, android.HostAndDeviceSupported)
 	return module
 }
@@ -1625,6 +2017,10 @@ type testProperties struct {
 	// should be installed with the module.
 	Test_config_template *string `android:"path,arch_variant"`
 
+	// if set to false, don't generate a test config when test_config is not set and there is no
+	// AndroidTest.xml in the module directory.  Defaults to true.
+	Auto_gen_config *bool
+
 	// list of files or filegroup modules that provide data that should be installed alongside
 	// the test
 	Data []string `android:"path"`
@@ -1641,6 +2037,8 @@ type Test struct {
 
 	testProperties testProperties
 
+	testOptionsProperties testOptionsProperties
+
 	testConfig android.Path
 	data       android.Paths
 }
@@ -1652,8 +2050,14 @@ type TestHelperLibrary struct {
 }
 
 func (j *Test) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	j.testConfig = tradefed.AutoGenJavaTestConfig(ctx, j.testProperties.Test_config, j.testProperties.Test_config_template, j.testProperties.Test_suites)
+	j.testOptionsProperties.Test_options.validate(ctx)
+	j.testConfig = tradefed.AutoGenJavaTestConfig(ctx, j.testProperties.Test_config, j.testProperties.Test_config_template,
+		j.testProperties.Test_suites, j.testOptionsProperties.Test_options.tradefedConfigs(),
+		j.testProperties.Auto_gen_config)
 	j.data = android.PathsForModuleSrc(ctx, j.testProperties.Data)
+	checkTestData(ctx, j.data)
+	ctx.SetProvider(android.TestInfoProvider,
+		j.testOptionsProperties.Test_options.testInfo(j.testConfig, j.testProperties.Test_suites))
 
 	j.Library.GenerateAndroidBuildActions(ctx)
 }
@@ -1677,11 +2081,14 @@ func TestFactory() android.Module {
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
-		&module.testProperties)
+		&module.testProperties,
+		&module.testOptionsProperties)
 
 	module.Module.properties.Installable = proptools.BoolPtr(true)
 	module.Module.dexpreopter.isTest = true
+	module.Module.linter.test = true
 
 	InitJavaModule(module, android.HostAndDeviceSupported)
 	return module
@@ -1695,11 +2102,13 @@ func TestHelperLibraryFactory() android.Module {
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
 		&module.testHelperLibraryProperties)
 
 	module.Module.properties.Installable = proptools.BoolPtr(true)
 	module.Module.dexpreopter.isTest = true
+	module.Module.linter.test = true
 
 	InitJavaModule(module, android.HostAndDeviceSupported)
 	return module
@@ -1716,7 +2125,8 @@ func TestHostFactory() android.Module {
 	module.AddProperties(
 		&module.Module.properties,
 		&module.Module.protoProperties,
-		&module.testProperties)
+		&module.testProperties,
+		&module.testOptionsProperties)
 
 	module.Module.properties.Installable = proptools.BoolPtr(true)
 
@@ -1767,6 +2177,7 @@ func (j *Binary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	} else {
 		// Handle the binary wrapper
 		j.isWrapperVariant = true
+		ctx.SetProvider(android.MakeClassProvider, "EXECUTABLES")
 
 		if j.binaryProperties.Wrapper != nil {
 			j.wrapperFile = android.PathForModuleSrc(ctx, *j.binaryProperties.Wrapper)
@@ -1804,6 +2215,7 @@ func BinaryFactory() android.Module {
 		&module.Module.properties,
 		&module.Module.deviceProperties,
 		&module.Module.dexpreoptProperties,
+		&module.Module.linter.lintProperties,
 		&module.Module.protoProperties,
 		&module.binaryProperties)
 
@@ -1893,6 +2305,7 @@ func (j *Import) DepsMutator(ctx android.BottomUpMutatorContext) {
 }
 
 func (j *Import) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	jars := android.PathsForModuleSrc(ctx, j.properties.Jars)
 
 	jarName := ctx.ModuleName() + ".jar"
@@ -2050,6 +2463,7 @@ type DexImport struct {
 	dexJarFile              android.Path
 	maybeStrippedDexJarFile android.Path
 
+	hiddenAPI
 	dexpreopter
 }
 
@@ -2070,6 +2484,7 @@ func (j *DexImport) DepsMutator(ctx android.BottomUpMutatorContext) {
 }
 
 func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
 	if len(j.properties.Jars) != 1 {
 		ctx.PropertyErrorf("jars", "exactly one jar must be provided")
 	}
@@ -2080,8 +2495,30 @@ func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	inputJar := ctx.ExpandSource(j.properties.Jars[0], "jars")
 	dexOutputFile := android.PathForModuleOut(ctx, ctx.ModuleName()+".jar")
+	copyDexJar(ctx, inputJar, dexOutputFile, j.dexpreopter.uncompressedDex)
+
+	if !ctx.Config().UnbundledBuild() {
+		// Hidden API dex encoding
+		dexOutputFile = j.hiddenAPI.hiddenAPI(ctx, dexOutputFile, nil, j.dexpreopter.uncompressedDex)
+	}
+
+	j.dexJarFile = dexOutputFile
+
+	dexOutputFile = j.dexpreopt(ctx, dexOutputFile)
 
-	if j.dexpreopter.uncompressedDex {
+	j.maybeStrippedDexJarFile = dexOutputFile
+
+	ctx.InstallFile(android.PathForModuleInstall(ctx, "framework"),
+		ctx.ModuleName()+".jar", dexOutputFile)
+	j.dexpreopter.installBuiltFiles(ctx)
+}
+
+// copyDexJar copies a prebuilt jar containing classes.dex files, storing the dex files uncompressed and aligned if
+// uncompressDex is true.
+func copyDexJar(ctx android.ModuleContext, inputJar android.Path, dexOutputFile android.WritablePath,
+	uncompressDex bool) {
+
+	if uncompressDex {
 		rule := android.NewRuleBuilder()
 
 		temporary := android.PathForModuleOut(ctx, ctx.ModuleName()+".jar.unaligned")
@@ -2096,7 +2533,7 @@ func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 		// use zipalign to align uncompressed classes*.dex files
 		rule.Command().
-			Tool(ctx.Config().HostToolPath(ctx, "zipalign")).
+			Tool(ctx.Config().HostNativeToolPath(ctx, "zipalign")).
 			Flag("-f").
 			Text("4").
 			Input(temporary).
@@ -2112,15 +2549,6 @@ func (j *DexImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 			Output: dexOutputFile,
 		})
 	}
-
-	j.dexJarFile = dexOutputFile
-
-	dexOutputFile = j.dexpreopt(ctx, dexOutputFile)
-
-	j.maybeStrippedDexJarFile = dexOutputFile
-
-	ctx.InstallFile(android.PathForModuleInstall(ctx, "framework"),
-		ctx.ModuleName()+".jar", dexOutputFile)
 }
 
 func (j *DexImport) DexJar() android.Path {
@@ -15,17 +15,19 @@
 package java
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/google/blueprint/proptools"
+
 	"android/soong/android"
-	"android/soong/cc"
 	"android/soong/dexpreopt"
-	"android/soong/genrule"
 )
 
 var buildDir string
@@ -60,162 +62,35 @@ func testConfig(env map[string]string) android.Config {
 func testContext(config android.Config, bp string,
 	fs map[string][]byte) *android.TestContext {
 
-	ctx := android.NewTestArchContext()
-	ctx.RegisterModuleType("android_app", android.ModuleFactoryAdaptor(AndroidAppFactory))
-	ctx.RegisterModuleType("android_app_certificate", android.ModuleFactoryAdaptor(AndroidAppCertificateFactory))
-	ctx.RegisterModuleType("android_app_import", android.ModuleFactoryAdaptor(AndroidAppImportFactory))
-	ctx.RegisterModuleType("android_library", android.ModuleFactoryAdaptor(AndroidLibraryFactory))
-	ctx.RegisterModuleType("android_test", android.ModuleFactoryAdaptor(AndroidTestFactory))
-	ctx.RegisterModuleType("android_test_helper_app", android.ModuleFactoryAdaptor(AndroidTestHelperAppFactory))
-	ctx.RegisterModuleType("java_binary", android.ModuleFactoryAdaptor(BinaryFactory))
-	ctx.RegisterModuleType("java_binary_host", android.ModuleFactoryAdaptor(BinaryHostFactory))
-	ctx.RegisterModuleType("java_device_for_host", android.ModuleFactoryAdaptor(DeviceForHostFactory))
-	ctx.RegisterModuleType("java_host_for_device", android.ModuleFactoryAdaptor(HostForDeviceFactory))
-	ctx.RegisterModuleType("java_library", android.ModuleFactoryAdaptor(LibraryFactory))
-	ctx.RegisterModuleType("java_library_host", android.ModuleFactoryAdaptor(LibraryHostFactory))
-	ctx.RegisterModuleType("java_test", android.ModuleFactoryAdaptor(TestFactory))
-	ctx.RegisterModuleType("java_import", android.ModuleFactoryAdaptor(ImportFactory))
-	ctx.RegisterModuleType("java_import_host", android.ModuleFactoryAdaptor(ImportFactoryHost))
-	ctx.RegisterModuleType("java_defaults", android.ModuleFactoryAdaptor(defaultsFactory))
-	ctx.RegisterModuleType("java_system_modules", android.ModuleFactoryAdaptor(SystemModulesFactory))
-	ctx.RegisterModuleType("java_genrule", android.ModuleFactoryAdaptor(genRuleFactory))
-	ctx.RegisterModuleType("java_plugin", android.ModuleFactoryAdaptor(PluginFactory))
-	ctx.RegisterModuleType("dex_import", android.ModuleFactoryAdaptor(DexImportFactory))
-	ctx.RegisterModuleType("filegroup", android.ModuleFactoryAdaptor(android.FileGroupFactory))
-	ctx.RegisterModuleType("genrule", android.ModuleFactoryAdaptor(genrule.GenRuleFactory))
-	ctx.RegisterModuleType("droiddoc", android.ModuleFactoryAdaptor(DroiddocFactory))
-	ctx.RegisterModuleType("droiddoc_host", android.ModuleFactoryAdaptor(DroiddocHostFactory))
-	ctx.RegisterModuleType("droiddoc_template", android.ModuleFactoryAdaptor(ExportedDroiddocDirFactory))
-	ctx.RegisterModuleType("java_sdk_library", android.ModuleFactoryAdaptor(SdkLibraryFactory))
-	ctx.RegisterModuleType("java_sdk_library_import", android.ModuleFactoryAdaptor(sdkLibraryImportFactory))
-	ctx.RegisterModuleType("override_android_app", android.ModuleFactoryAdaptor(OverrideAndroidAppModuleFactory))
-	ctx.RegisterModuleType("prebuilt_apis", android.ModuleFactoryAdaptor(PrebuiltApisFactory))
-	ctx.PreArchMutators(android.RegisterPrebuiltsPreArchMutators)
-	ctx.PreArchMutators(android.RegisterPrebuiltsPostDepsMutators)
-	ctx.PreArchMutators(android.RegisterDefaultsPreArchMutators)
-	ctx.PreArchMutators(func(ctx android.RegisterMutatorsContext) {
-		ctx.TopDown("prebuilt_apis", PrebuiltApisMutator).Parallel()
-	})
-	ctx.PostDepsMutators(android.RegisterOverridePostDepsMutators)
-	ctx.RegisterPreSingletonType("overlay", android.SingletonFactoryAdaptor(OverlaySingletonFactory))
-	ctx.RegisterPreSingletonType("sdk_versions", android.SingletonFactoryAdaptor(sdkPreSingletonFactory))
-
-	// Register module types and mutators from cc needed for JNI testing
-	ctx.RegisterModuleType("cc_library", android.ModuleFactoryAdaptor(cc.LibraryFactory))
-	ctx.RegisterModuleType("cc_object", android.ModuleFactoryAdaptor(cc.ObjectFactory))
-	ctx.RegisterModuleType("toolchain_library", android.ModuleFactoryAdaptor(cc.ToolchainLibraryFactory))
-	ctx.RegisterModuleType("llndk_library", android.ModuleFactoryAdaptor(cc.LlndkLibraryFactory))
-	ctx.RegisterModuleType("ndk_prebuilt_shared_stl", android.ModuleFactoryAdaptor(cc.NdkPrebuiltSharedStlFactory))
-	ctx.PreDepsMutators(func(ctx android.RegisterMutatorsContext) {
-		ctx.BottomUp("link", cc.LinkageMutator).Parallel()
-		ctx.BottomUp("begin", cc.BeginMutator).Parallel()
-	})
-
-	bp += GatherRequiredDepsForTest()
-
-	mockFS := map[string][]byte{
-		"Android.bp":             []byte(bp),
-		"a.java":                 nil,
-		"b.java":                 nil,
-		"c.java":                 nil,
-		"b.kt":                   nil,
-		"a.jar":                  nil,
-		"b.jar":                  nil,
-		"java-res/a/a":           nil,
-		"java-res/b/b":           nil,
-		"java-res2/a":            nil,
-		"java-fg/a.java":         nil,
-		"java-fg/b.java":         nil,
-		"java-fg/c.java":         nil,
-		"api/current.txt":        nil,
-		"api/removed.txt":        nil,
-		"api/system-current.txt": nil,
-		"api/system-removed.txt": nil,
-		"api/test-current.txt":   nil,
-		"api/test-removed.txt":   nil,
-		"framework/aidl/a.aidl":  nil,
-
-		"prebuilts/ndk/current/sources/cxx-stl/llvm-libc++/libs/arm64-v8a/libc++_shared.so": nil,
-
-		"prebuilts/sdk/14/public/android.jar":         nil,
-		"prebuilts/sdk/14/public/framework.aidl":      nil,
-		"prebuilts/sdk/14/system/android.jar":         nil,
-		"prebuilts/sdk/17/public/android.jar":         nil,
-		"prebuilts/sdk/17/public/framework.aidl":      nil,
-		"prebuilts/sdk/17/system/android.jar":         nil,
-		"prebuilts/sdk/25/public/android.jar":         nil,
-		"prebuilts/sdk/25/public/framework.aidl":      nil,
-		"prebuilts/sdk/25/system/android.jar":         nil,
-		"prebuilts/sdk/current/core/android.jar":      nil,
-		"prebuilts/sdk/current/public/android.jar":    nil,
-		"prebuilts/sdk/current/public/framework.aidl": nil,
-		"prebuilts/sdk/current/public/core.jar":       nil,
-		"prebuilts/sdk/current/system/android.jar":    nil,
-		"prebuilts/sdk/current/test/android.jar":      nil,
-		"prebuilts/sdk/28/public/api/foo.txt":         nil,
-		"prebuilts/sdk/28/system/api/foo.txt":         nil,
-		"prebuilts/sdk/28/test/api/foo.txt":           nil,
-		"prebuilts/sdk/28/public/api/foo-removed.txt": nil,
-		"prebuilts/sdk/28/system/api/foo-removed.txt": nil,
-		"prebuilts/sdk/28/test/api/foo-removed.txt":   nil,
-		"prebuilts/sdk/28/public/api/bar.txt":         nil,
-		"prebuilts/sdk/28/system/api/bar.txt":         nil,
-		"prebuilts/sdk/28/test/api/bar.txt":           nil,
-		"prebuilts/sdk/28/public/api/bar-removed.txt": nil,
-		"prebuilts/sdk/28/system/api/bar-removed.txt": nil,
-		"prebuilts/sdk/28/test/api/bar-removed.txt":   nil,
-		"prebuilts/sdk/tools/core-lambda-stubs.jar":   nil,
-		"prebuilts/sdk/Android.bp":                    []byte(`prebuilt_apis { name: "sdk", api_dirs: ["14", "28", "current"],}`),
-
-		"prebuilts/apk/app.apk":        nil,
-		"prebuilts/apk/app_xhdpi.apk":  nil,
-		"prebuilts/apk/app_xxhdpi.apk": nil,
-
-		// For framework-res, which is an implicit dependency for framework
-		"AndroidManifest.xml":                        nil,
-		"build/make/target/product/security/testkey": nil,
-
-		"build/soong/scripts/jar-wrapper.sh": nil,
-
-		"build/make/core/verify_uses_libraries.sh": nil,
-
-		"build/make/core/proguard.flags":             nil,
-		"build/make/core/proguard_basic_keeps.flags": nil,
-
-		"jdk8/jre/lib/jce.jar": nil,
-		"jdk8/jre/lib/rt.jar":  nil,
-		"jdk8/lib/tools.jar":   nil,
-
-		"bar-doc/a.java":                 nil,
-		"bar-doc/b.java":                 nil,
-		"bar-doc/IFoo.aidl":              nil,
-		"bar-doc/known_oj_tags.txt":      nil,
-		"external/doclava/templates-sdk": nil,
-
-		"cert/new_cert.x509.pem": nil,
-		"cert/new_cert.pk8":      nil,
-	}
-
-	for k, v := range fs {
-		mockFS[k] = v
-	}
-
-	ctx.MockFileSystem(mockFS)
-
-	return ctx
+	return NewTestContext(config, bp, fs)
 }
 
 func run(t *testing.T, ctx *android.TestContext, config android.Config) {
 	t.Helper()
+	RunTestContext(t, ctx, config)
+}
+
+func testJavaError(t *testing.T, pattern string, bp string) {
+	t.Helper()
+	config := testConfig(nil)
+	ctx := testContext(config, bp, nil)
 
 	pathCtx := android.PathContextForTesting(config, nil)
 	setDexpreoptTestGlobalConfig(config, dexpreopt.GlobalConfigForTests(pathCtx))
 
 	ctx.Register()
 	_, errs := ctx.ParseBlueprintsFiles("Android.bp")
-	android.FailIfErrored(t, errs)
+	if len(errs) > 0 {
+		android.FailIfNoMatchingErrors(t, pattern, errs)
+		return
+	}
 	_, errs = ctx.PrepareBuildActions(config)
-	android.FailIfErrored(t, errs)
+	if len(errs) > 0 {
+		android.FailIfNoMatchingErrors(t, pattern, errs)
+		return
+	}
+
+	t.Fatalf("missing expected error %q (0 errors are returned)", pattern)
 }
 
 func testJava(t *testing.T, bp string) *android.TestContext {
@@ -282,6 +157,35 @@ func TestSimple(t *testing.T) {
 	}
 }
 
+func TestNewTestContextRegistrars(t *testing.T) {
+	config := testConfig(nil)
+	ctx := NewTestContext(config, `
+		vendor_java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+		`, nil, func(ctx *android.TestContext) {
+		ctx.RegisterModuleType("vendor_java_library", android.ModuleFactoryAdaptor(func() android.Module {
+			module := LibraryFactory()
+			module.(*Library).properties.Installable = proptools.BoolPtr(false)
+			return module
+		}))
+	})
+	RunTestContext(t, ctx, config)
+
+	javac := ctx.ModuleForTests("foo", "android_common").Rule("javac")
+	barTurbine := filepath.Join(buildDir, ".intermediates", "bar", "android_common", "turbine-combined", "bar.jar")
+	if !strings.Contains(javac.Args["classpath"], barTurbine) {
+		t.Errorf("foo classpath %v does not contain %q", javac.Args["classpath"], barTurbine)
+	}
+}
+
 func TestArchSpecific(t *testing.T) {
 	ctx := testJava(t, `
 		java_library {
@@ -456,6 +360,131 @@ func TestDefaults(t *testing.T) {
 	}
 }
 
+func TestMultidex(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "legacy",
+			srcs: ["a.java"],
+			compile_dex: true,
+			min_sdk_version: "19",
+			multidex: {
+				enabled: true,
+				main_dex_rules: ["main-dex.rules"],
+			},
+		}
+
+		java_library {
+			name: "legacy_dxflags",
+			srcs: ["a.java"],
+			compile_dex: true,
+			min_sdk_version: "19",
+			dxflags: ["--multi-dex"],
+			multidex: {
+				main_dex_rules: ["main-dex.rules"],
+			},
+		}
+
+		java_library {
+			name: "native",
+			srcs: ["a.java"],
+			compile_dex: true,
+			min_sdk_version: "21",
+			multidex: {
+				enabled: true,
+			},
+		}
+
+		java_library {
+			name: "single",
+			srcs: ["a.java"],
+			compile_dex: true,
+			single_dex: true,
+		}
+	`)
+
+	for _, name := range []string{"legacy", "legacy_dxflags"} {
+		d8 := ctx.ModuleForTests(name, "android_common").Rule("d8")
+		if !strings.Contains(d8.Args["d8Flags"], "--main-dex-rules main-dex.rules") {
+			t.Errorf("expected %s d8 flags to contain --main-dex-rules, got %q", name, d8.Args["d8Flags"])
+		}
+		if !android.InList("main-dex.rules", d8.Implicits.Strings()) {
+			t.Errorf("expected %s d8 implicits %q to contain main-dex.rules", name, d8.Implicits.Strings())
+		}
+		if strings.Contains(d8.Args["d8Flags"], "--multi-dex") {
+			t.Errorf("expected --multi-dex to be removed from %s d8 flags, got %q", name, d8.Args["d8Flags"])
+		}
+		if g, w := d8.Args["legacyMultidex"], name; g != w {
+			t.Errorf("expected %s legacyMultidex %q, got %q", name, w, g)
+		}
+	}
+
+	native := ctx.ModuleForTests("native", "android_common").Rule("d8")
+	if strings.Contains(native.Args["d8Flags"], "--main-dex-rules") {
+		t.Errorf("expected no main dex rules above API 21, got %q", native.Args["d8Flags"])
+	}
+	if native.Args["singleDex"] != "" {
+		t.Errorf("expected native multidex not to check for a single dex file")
+	}
+	if native.Args["legacyMultidex"] != "" {
+		t.Errorf("expected native multidex not to check for legacy multidex")
+	}
+
+	if g, w := ctx.ModuleForTests("single", "android_common").Rule("d8").Args["singleDex"], "single"; g != w {
+		t.Errorf("expected singleDex %q, got %q", w, g)
+	}
+}
+
+func TestMultidexErrors(t *testing.T) {
+	testJavaError(t, `multidex.main_dex_rules: must be set for legacy multidex`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			compile_dex: true,
+			min_sdk_version: "19",
+			multidex: {
+				enabled: true,
+			},
+		}
+	`)
+
+	testJavaError(t, `multidex.main_dex_rules: are only used for legacy multidex, min_sdk_version 21 is not below 21`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			compile_dex: true,
+			min_sdk_version: "21",
+			multidex: {
+				enabled: true,
+				main_dex_rules: ["main-dex.rules"],
+			},
+		}
+	`)
+
+	testJavaError(t, `multidex.main_dex_rules: can't be set unless multidex is enabled`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			compile_dex: true,
+			min_sdk_version: "19",
+			multidex: {
+				main_dex_rules: ["main-dex.rules"],
+			},
+		}
+	`)
+
+	testJavaError(t, `single_dex: can't be combined with multidex`, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			compile_dex: true,
+			single_dex: true,
+			multidex: {
+				enabled: true,
+			},
+		}
+	`)
+}
+
 func TestResources(t *testing.T) {
 	var table = []struct {
 		name  string
@@ -719,6 +748,202 @@ func TestSharding(t *testing.T) {
 	}
 }
 
+func TestShardingAnnotationProcessors(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "b.java", "c.java"],
+			plugins: ["bar"],
+			javac_shard_size: 2,
+		}
+
+		java_plugin {
+			name: "bar",
+			processor_class: "com.bar",
+			srcs: ["b.java"],
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+
+	apt := foo.Description("javac-apt")
+	if g, w := apt.Args["processor"], "-processor com.bar"; g != w {
+		t.Errorf("expected annotation processing processor %q, got %q", w, g)
+	}
+	if !strings.Contains(apt.Args["javacFlags"], "-proc:only") {
+		t.Errorf("expected annotation processing javacFlags to contain -proc:only, got %q", apt.Args["javacFlags"])
+	}
+	if g, w := apt.Inputs.Strings(), []string{"a.java", "b.java", "c.java"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected annotation processing inputs %q, got %q", w, g)
+	}
+	annoSrcJar := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "javac-apt", "anno.srcjar")
+	if !android.InList(annoSrcJar, apt.ImplicitOutputs.Strings()) {
+		t.Errorf("expected annotation processing outputs %q to contain %q", apt.ImplicitOutputs, annoSrcJar)
+	}
+
+	generated := foo.Description("javac2")
+	if g, w := generated.Args["srcJars"], annoSrcJar; g != w {
+		t.Errorf("expected generated sources shard srcJars %q, got %q", w, g)
+	}
+	if g, w := generated.Args["processor"], "-proc:none"; g != w {
+		t.Errorf("expected generated sources shard processor %q, got %q", w, g)
+	}
+
+	for i := 0; i < 2; i++ {
+		shard := foo.Description("javac" + strconv.Itoa(i))
+		if g, w := shard.Args["processor"], "-proc:none"; g != w {
+			t.Errorf("expected shard %d processor %q, got %q", i, w, g)
+		}
+		if !strings.Contains(shard.Args["classpath"], generated.Output.String()) {
+			t.Errorf("expected shard %d classpath %q to contain %q", i, shard.Args["classpath"], generated.Output)
+		}
+	}
+
+	combined := foo.Output("combined/foo.jar")
+	for _, jar := range []string{apt.Output.String(), generated.Output.String()} {
+		if !android.InList(jar, combined.Inputs.Strings()) {
+			t.Errorf("expected combined jar inputs %q to contain %q", combined.Inputs, jar)
+		}
+	}
+}
+
+func TestStrictJavaDeps(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+			static_libs: ["foo"],
+		}
+
+		java_library {
+			name: "baz",
+			srcs: ["c.java"],
+			libs: ["bar"],
+			strict_java_deps: "%s",
+		}
+
+		java_library {
+			name: "qux",
+			srcs: ["c.java"],
+			static_libs: ["foo"],
+		}
+		`
+
+	fooHeaderJar := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "turbine-combined", "foo.jar")
+	barHeaderJar := filepath.Join(buildDir, ".intermediates", "bar", "android_common", "turbine-combined", "bar.jar")
+	barDirectHeaderJar := filepath.Join(buildDir, ".intermediates", "bar", "android_common", "turbine-direct", "bar.jar")
+
+	t.Run("error", func(t *testing.T) {
+		ctx := testJava(t, fmt.Sprintf(bp, "error"))
+
+		bazJavac := ctx.ModuleForTests("baz", "android_common").Rule("javac")
+		if !strings.Contains(bazJavac.Args["classpath"], barDirectHeaderJar) {
+			t.Errorf("baz javac classpath %v does not contain %q", bazJavac.Args["classpath"], barDirectHeaderJar)
+		}
+		if strings.Contains(bazJavac.Args["classpath"], barHeaderJar) {
+			t.Errorf("baz javac classpath %v should not contain %q", bazJavac.Args["classpath"], barHeaderJar)
+		}
+
+		barDirect := ctx.ModuleForTests("bar", "android_common").Output("turbine-direct/bar.jar")
+		if android.InList(fooHeaderJar, barDirect.Inputs.Strings()) {
+			t.Errorf("bar direct header jar inputs %v should not contain %q", barDirect.Inputs, fooHeaderJar)
+		}
+
+		// The check reports the missing direct dependencies before javac fails.
+		check := ctx.ModuleForTests("baz", "android_common").Rule("strictJavaDepsCheck")
+		if g, w := check.Args["suggestFlags"], "--error"; g != w {
+			t.Errorf("expected baz strict deps check flags %q, got %q", w, g)
+		}
+		if !android.InList(check.Output.String(), bazJavac.Implicits.Strings()) {
+			t.Errorf("baz javac implicits %v does not contain %q", bazJavac.Implicits, check.Output)
+		}
+
+		// Nothing that sets strict_java_deps depends on qux.
+		if direct := ctx.ModuleForTests("qux", "android_common").MaybeOutput("turbine-direct/qux.jar"); direct.Rule != nil {
+			t.Errorf("expected no direct header jar for qux")
+		}
+	})
+
+	t.Run("warning", func(t *testing.T) {
+		ctx := testJava(t, fmt.Sprintf(bp, "warning"))
+
+		baz := ctx.ModuleForTests("baz", "android_common")
+		bazJavac := baz.Rule("javac")
+		if !strings.Contains(bazJavac.Args["classpath"], barHeaderJar) {
+			t.Errorf("baz javac classpath %v does not contain %q", bazJavac.Args["classpath"], barHeaderJar)
+		}
+
+		check := baz.Rule("strictJavaDepsCheck")
+		if !strings.Contains(check.Args["classpath"], barDirectHeaderJar) {
+			t.Errorf("baz strict deps check classpath %v does not contain %q", check.Args["classpath"], barDirectHeaderJar)
+		}
+		if strings.Contains(check.Args["classpath"], barHeaderJar) {
+			t.Errorf("baz strict deps check classpath %v should not contain %q", check.Args["classpath"], barHeaderJar)
+		}
+		fooCandidate := "--candidate foo:" + fooHeaderJar
+		if !strings.Contains(check.Args["candidates"], fooCandidate) {
+			t.Errorf("baz strict deps check candidates %q does not contain %q", check.Args["candidates"], fooCandidate)
+		}
+		if !android.InList(check.Output.String(), bazJavac.Implicits.Strings()) {
+			t.Errorf("baz javac implicits %v does not contain %q", bazJavac.Implicits, check.Output)
+		}
+		if g := check.Args["suggestFlags"]; g != "" {
+			t.Errorf("expected baz strict deps check not to fail the build, got flags %q", g)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		testJavaError(t, `strict_java_deps: unknown value "foo"`, fmt.Sprintf(bp, "foo"))
+	})
+}
+
+func TestJavaTestOptions(t *testing.T) {
+	ctx := testJava(t, `
+		java_test {
+			name: "foo",
+			srcs: ["a.java"],
+		}
+
+		java_test_host {
+			name: "bar",
+			srcs: ["a.java"],
+			test_options: {
+				shards: 3,
+			},
+		}
+		`)
+
+	fooConfig := ctx.ModuleForTests("foo", "android_common").Output("foo.config")
+	if g := fooConfig.Args["extraConfigs"]; strings.Contains(g, "shard-count") {
+		t.Errorf("expected no shard count for foo, got %q", g)
+	}
+
+	buildOS := android.BuildOs.String()
+	bar := ctx.ModuleForTests("bar", buildOS+"_common")
+	barConfig := bar.Output("bar.config")
+	if g, w := barConfig.Args["extraConfigs"], `'<option name="shard-count" value="3" />'`; g != w {
+		t.Errorf("expected test config extra configs %q, got %q", w, g)
+	}
+	if g := bar.Provider(android.TestInfoProvider).(android.TestInfo).Shards; g != 3 {
+		t.Errorf("expected 3 shards, got %d", g)
+	}
+
+	testJavaError(t, `test_options.shards: must be at least 1`, `
+		java_test_host {
+			name: "bar",
+			srcs: ["a.java"],
+			test_options: {
+				shards: -1,
+			},
+		}
+		`)
+}
+
 func TestDroiddoc(t *testing.T) {
 	ctx := testJava(t, `
 		droiddoc_template {
@@ -762,6 +987,52 @@ func TestDroiddoc(t *testing.T) {
 	}
 }
 
+func TestDroidstubsApiLint(t *testing.T) {
+	config := testConfig(nil)
+	ctx := testContext(config, `
+		droidstubs {
+			name: "foo-stubs",
+			srcs: ["bar-doc/a.java"],
+			check_api: {
+				api_lint: {
+					enabled: true,
+					new_since: "api/last-released.txt",
+					baseline_file: "api/lint-baseline.txt",
+				},
+			},
+		}
+
+		droidstubs {
+			name: "bar-stubs",
+			srcs: ["bar-doc/a.java"],
+		}
+		`, map[string][]byte{
+		"api/last-released.txt": nil,
+		"api/lint-baseline.txt": nil,
+	})
+	run(t, ctx, config)
+
+	apiLint := ctx.ModuleForTests("foo-stubs", "android_common").Output("api_lint.timestamp")
+	opts := apiLint.Args["opts"]
+	for _, flag := range []string{
+		"--api-lint api/last-released.txt",
+		"--baseline api/lint-baseline.txt",
+		"--update-baseline " + filepath.Join(buildDir, ".intermediates", "foo-stubs", "android_common", "api_lint_baseline.txt"),
+		"--report-even-if-suppressed " + filepath.Join(buildDir, ".intermediates", "foo-stubs", "android_common", "api_lint_report.txt"),
+	} {
+		if !strings.Contains(opts, flag) {
+			t.Errorf("api lint opts %q does not contain %q", opts, flag)
+		}
+	}
+	if !android.InList("api/lint-baseline.txt", apiLint.Implicits.Strings()) {
+		t.Errorf("api lint implicits %q does not contain the baseline", apiLint.Implicits.Strings())
+	}
+
+	if apiLint := ctx.ModuleForTests("bar-stubs", "android_common").MaybeOutput("api_lint.timestamp"); apiLint.Rule != nil {
+		t.Errorf("expected no api lint for bar-stubs")
+	}
+}
+
 func TestJarGenrules(t *testing.T) {
 	ctx := testJava(t, `
 		java_library {
@@ -915,6 +1186,21 @@ func TestJavaSdkLibrary(t *testing.T) {
 			"foo.stubs.jar")
 	}
 
+	// test if the API of every scope of foo is checked and can be updated
+	foo := ctx.ModuleForTests("foo", "android_common").Module().(*SdkLibrary)
+	for _, docs := range []string{"foo" + sdkDocsSuffix, "foo" + sdkDocsSuffix + sdkSystemApiSuffix,
+		"foo" + sdkDocsSuffix + sdkTestApiSuffix} {
+		docsModule := ctx.ModuleForTests(docs, "android_common")
+		checkApi := docsModule.Output("check_current_api.timestamp").Output
+		if !android.InList(checkApi.String(), foo.checkApiTimestamps.Strings()) {
+			t.Errorf("foo check api timestamps %v does not contain %q", foo.checkApiTimestamps, checkApi)
+		}
+		updateApi := docsModule.Output("update_current_api.timestamp").Output
+		if !android.InList(updateApi.String(), foo.updateApiTimestamps.Strings()) {
+			t.Errorf("foo update api timestamps %v does not contain %q", foo.updateApiTimestamps, updateApi)
+		}
+	}
+
 	// test if baz has exported SDK lib names foo and bar to qux
 	qux := ctx.ModuleForTests("qux", "android_common")
 	if quxLib, ok := qux.Module().(*Library); ok {
@@ -925,6 +1211,56 @@ func TestJavaSdkLibrary(t *testing.T) {
 	}
 }
 
+func TestJavaSdkLibraryImport(t *testing.T) {
+	ctx := testJava(t, `
+		java_sdk_library_import {
+			name: "sdklib",
+			jars: ["a.jar"],
+			system_jars: ["b.jar"],
+			dex_jar: "b.jar",
+			permissions_xml: "AndroidManifest.xml",
+		}
+
+		java_library {
+			name: "public",
+			srcs: ["a.java"],
+			libs: ["sdklib"],
+			sdk_version: "current",
+		}
+
+		java_library {
+			name: "system",
+			srcs: ["a.java"],
+			libs: ["sdklib"],
+			sdk_version: "system_current",
+		}
+		`)
+
+	publicStubs := ctx.ModuleForTests("sdklib.stubs", "android_common").Rule("combineJar").Output
+	systemStubs := ctx.ModuleForTests("sdklib.stubs.system", "android_common").Rule("combineJar").Output
+
+	publicJavac := ctx.ModuleForTests("public", "android_common").Rule("javac")
+	if !strings.Contains(publicJavac.Args["classpath"], publicStubs.String()) {
+		t.Errorf("public classpath %v does not contain %q", publicJavac.Args["classpath"], publicStubs.String())
+	}
+
+	systemJavac := ctx.ModuleForTests("system", "android_common").Rule("javac")
+	if !strings.Contains(systemJavac.Args["classpath"], systemStubs.String()) {
+		t.Errorf("system classpath %v does not contain %q", systemJavac.Args["classpath"], systemStubs.String())
+	}
+
+	sdklib := ctx.ModuleForTests("sdklib", "android_common")
+	module := sdklib.Module().(*sdkLibraryImport)
+	if module.DexJar() == nil {
+		t.Fatalf("expected sdklib to have a dex jar")
+	}
+	if g, w := module.DexJarInstallLocation(), "/system/framework/sdklib.jar"; g != w {
+		t.Errorf("expected sdklib to be installed to %q, got %q", w, g)
+	}
+
+	ctx.ModuleForTests("sdklib.xml", "android_arm64_armv8-a")
+}
+
 var compilerFlagsTestCases = []struct {
 	in  string
 	out bool
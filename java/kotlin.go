@@ -103,6 +103,7 @@ var kapt = pctx.AndroidGomaStaticRule("kapt",
 			`-P plugin:org.jetbrains.kotlin.kapt3:javacArguments=$encodedJavacFlags ` +
 			`$kaptProcessorPath ` +
 			`$kaptProcessor ` +
+			`$kaptProcessorOptions ` +
 			`-Xbuild-file=$kotlinBuildFile && ` +
 			`${config.SoongZipCmd} -jar -o $out -C $kaptDir/sources -D $kaptDir/sources && ` +
 			`rm -rf "$srcJarDir"`,
@@ -117,7 +118,7 @@ var kapt = pctx.AndroidGomaStaticRule("kapt",
 		Rspfile:        "$out.rsp",
 		RspfileContent: `$in`,
 	},
-	"kotlincFlags", "encodedJavacFlags", "kaptProcessorPath", "kaptProcessor",
+	"kotlincFlags", "encodedJavacFlags", "kaptProcessorPath", "kaptProcessor", "kaptProcessorOptions",
 	"classpath", "srcJars", "srcJarDir", "kaptDir", "kotlinJvmTarget", "kotlinBuildFile", "name")
 
 // kotlinKapt performs Kotlin-compatible annotation processing.  It takes .kt and .java sources and srcjars, and runs
@@ -155,20 +156,41 @@ func kotlinKapt(ctx android.ModuleContext, outputFile android.WritablePath,
 		Inputs:      srcFiles,
 		Implicits:   deps,
 		Args: map[string]string{
-			"classpath":         flags.kotlincClasspath.FormJavaClassPath("-classpath"),
-			"kotlincFlags":      flags.kotlincFlags,
-			"srcJars":           strings.Join(srcJars.Strings(), " "),
-			"srcJarDir":         android.PathForModuleOut(ctx, "kapt", "srcJars").String(),
-			"kotlinBuildFile":   android.PathForModuleOut(ctx, "kapt", "build.xml").String(),
-			"kaptProcessorPath": strings.Join(kaptProcessorPath, " "),
-			"kaptProcessor":     kaptProcessor,
-			"kaptDir":           android.PathForModuleOut(ctx, "kapt/gen").String(),
-			"encodedJavacFlags": encodedJavacFlags,
-			"name":              kotlinName,
+			"classpath":            flags.kotlincClasspath.FormJavaClassPath("-classpath"),
+			"kotlincFlags":         flags.kotlincFlags,
+			"srcJars":              strings.Join(srcJars.Strings(), " "),
+			"srcJarDir":            android.PathForModuleOut(ctx, "kapt", "srcJars").String(),
+			"kotlinBuildFile":      android.PathForModuleOut(ctx, "kapt", "build.xml").String(),
+			"kaptProcessorPath":    strings.Join(kaptProcessorPath, " "),
+			"kaptProcessor":        kaptProcessor,
+			"kaptProcessorOptions": flags.kaptProcessorOptions,
+			"kaptDir":              android.PathForModuleOut(ctx, "kapt/gen").String(),
+			"encodedJavacFlags":    encodedJavacFlags,
+			"name":                 kotlinName,
 		},
 	})
 }
 
+// kaptProcessorOptions returns the kapt plugin option that passes the annotation processor options
+// (-Akey=value) in a list of javac flags to the annotation processors, or "" if there are none.
+func kaptProcessorOptions(javacFlags []string) string {
+	var options [][2]string
+	for _, flag := range javacFlags {
+		if !strings.HasPrefix(flag, "-A") {
+			continue
+		}
+		key, value := strings.TrimPrefix(flag, "-A"), ""
+		if i := strings.Index(key, "="); i >= 0 {
+			key, value = key[:i], key[i+1:]
+		}
+		options = append(options, [2]string{key, value})
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	return "-P plugin:org.jetbrains.kotlin.kapt3:apoptions=" + kaptEncodeFlags(options)
+}
+
 // kapt converts a list of key, value pairs into a base64 encoded Java serialization, which is what kapt expects.
 func kaptEncodeFlags(options [][2]string) string {
 	buf := &bytes.Buffer{}
@@ -16,6 +16,7 @@ package java
 
 import (
 	"android/soong/android"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -154,6 +155,99 @@ func TestKapt(t *testing.T) {
 	}
 }
 
+func TestKotlinApp(t *testing.T) {
+	ctx := testApp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java", "b.kt"],
+			sdk_version: "current",
+			plugins: ["bar"],
+			javacflags: ["-Afoo.option=value", "-Xlint:all"],
+		}
+
+		java_plugin {
+			name: "bar",
+			processor_class: "com.bar",
+			srcs: ["b.java"],
+		}
+		`)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	kapt := foo.Rule("kapt")
+	kotlinc := foo.Rule("kotlinc")
+	javac := foo.Rule("javac")
+	rJar := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "gen", "R.jar")
+
+	// Test that the R.jar generated by aapt2 is visible to kapt, kotlinc and javac
+	for _, rule := range []android.TestingBuildParams{kapt, kotlinc, javac} {
+		if !strings.Contains(rule.Args["srcJars"], rJar) {
+			t.Errorf("expected %q in %s srcJars %q", rJar, rule.Description, rule.Args["srcJars"])
+		}
+	}
+
+	// Test that kotlinc and kapt compile against the android bootclasspath
+	androidStubs := moduleToPath("android_stubs_current")
+	for _, rule := range []android.TestingBuildParams{kapt, kotlinc} {
+		if !strings.Contains(rule.Args["classpath"], androidStubs) {
+			t.Errorf("expected %q in %s classpath %q", androidStubs, rule.Description, rule.Args["classpath"])
+		}
+	}
+	if !strings.Contains(kotlinc.Args["kotlincFlags"], "-no-jdk") {
+		t.Errorf("expected -no-jdk in kotlinc flags %q", kotlinc.Args["kotlincFlags"])
+	}
+
+	// Test that the sources generated by kapt are compiled by javac instead of running the processors again
+	if !strings.Contains(javac.Args["srcJars"], kapt.Output.String()) {
+		t.Errorf("expected %q in javac srcJars %q", kapt.Output.String(), javac.Args["srcJars"])
+	}
+	if javac.Args["processor"] != "-proc:none" {
+		t.Errorf("expected processor '-proc:none', got %q", javac.Args["processor"])
+	}
+
+	// Test that the annotation processor options are passed to kapt
+	expectedProcessorOptions := "-P plugin:org.jetbrains.kotlin.kapt3:apoptions=" +
+		kaptEncodeFlags([][2]string{{"foo.option", "value"}})
+	if kapt.Args["kaptProcessorOptions"] != expectedProcessorOptions {
+		t.Errorf("expected kaptProcessorOptions %q, got %q",
+			expectedProcessorOptions, kapt.Args["kaptProcessorOptions"])
+	}
+}
+
+func TestKaptProcessorOptions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		javacFlags []string
+		options    [][2]string
+	}{
+		{
+			name:       "none",
+			javacFlags: []string{"-Xlint:all"},
+		},
+		{
+			name:       "key and value",
+			javacFlags: []string{"-Afoo=bar", "-Xlint:all", "-Abaz=a=b"},
+			options:    [][2]string{{"foo", "bar"}, {"baz", "a=b"}},
+		},
+		{
+			name:       "key only",
+			javacFlags: []string{"-Afoo"},
+			options:    [][2]string{{"foo", ""}},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			want := ""
+			if test.options != nil {
+				want = "-P plugin:org.jetbrains.kotlin.kapt3:apoptions=" + kaptEncodeFlags(test.options)
+			}
+			if got := kaptProcessorOptions(test.javacFlags); got != want {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
 func TestKaptEncodeFlags(t *testing.T) {
 	// Compares the kaptEncodeFlags against the results of the example implementation at
 	// https://kotlinlang.org/docs/reference/kapt.html#apjavac-options-encoding
@@ -0,0 +1,173 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file extracts the java compilations of the modules for the Kythe indexer, which generates the
+// cross-references of code search.  When XREF_CORPUS is set, the Kythe java extractor is run with
+// the same arguments as javac for every module, and writes the sources, dependencies and arguments
+// of the compilation to a .kzip file.  The xref_java singleton merges the .kzip files of all the
+// modules into xref/java.kzip.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var (
+	_ = pctx.VariableFunc("kytheCorpus", func(ctx android.PackageVarContext) string {
+		return ctx.Config().XrefCorpusName()
+	})
+
+	kytheExtract = pctx.AndroidStaticRule("kythe",
+		blueprint.RuleParams{
+			Command: `rm -rf "$outDir" "$annoDir" "$srcJarDir" && mkdir -p "$outDir" "$annoDir" "$srcJarDir" && ` +
+				`${config.ZipSyncCmd} -d $srcJarDir -l $srcJarDir/list -f "*.java" $srcJars && ` +
+				`KYTHE_ROOT_DIRECTORY=. KYTHE_OUTPUT_FILE=$out KYTHE_CORPUS=${kytheCorpus} ` +
+				`${config.JavaCmd} ${config.JavaVmFlags} -jar ${config.JavaKytheExtractorJar} ` +
+				`${config.JavacHeapFlags} ${config.CommonJdkFlags} ` +
+				`$processorpath $processor $javacFlags $bootClasspath $classpath ` +
+				`-source $javaVersion -target $javaVersion ` +
+				`-d $outDir -s $annoDir @$out.rsp @$srcJarDir/list && ` +
+				`rm -rf "$srcJarDir"`,
+			CommandDeps: []string{
+				"${config.JavaCmd}",
+				"${config.JavaKytheExtractorJar}",
+				"${config.ZipSyncCmd}",
+			},
+			Rspfile:        "$out.rsp",
+			RspfileContent: "$in",
+		},
+		"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
+		"outDir", "annoDir", "javaVersion")
+
+	kytheMerge = pctx.AndroidStaticRule("kytheMerge",
+		blueprint.RuleParams{
+			// The files in a .kzip are named after the hash of their contents, the files that are in
+			// the .kzip of more than one module are identical.
+			Command:     `${config.MergeZipsCmd} --ignore-duplicates $out $in`,
+			CommandDeps: []string{"${config.MergeZipsCmd}"},
+		})
+)
+
+func init() {
+	android.RegisterSingletonType("xref_java", xrefJavaSingletonFactory)
+}
+
+// emitXrefRule extracts the compilation of the java sources into kzip, with the same arguments as
+// TransformJavaToClasses.
+func emitXrefRule(ctx android.ModuleContext, kzip android.WritablePath,
+	srcFiles, srcJars android.Paths, flags javaBuilderFlags, deps android.Paths) {
+
+	deps = append(deps, srcJars...)
+
+	bootClasspath, bootClasspathDeps := javacBootClasspath(ctx, flags)
+	deps = append(deps, bootClasspathDeps...)
+
+	deps = append(deps, flags.classpath...)
+	deps = append(deps, flags.processorPath...)
+
+	processor := "-proc:none"
+	if flags.processor != "" {
+		processor = "-processor " + flags.processor
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        kytheExtract,
+		Description: "kythe java extractor",
+		Output:      kzip,
+		Inputs:      srcFiles,
+		Implicits:   deps,
+		Args: map[string]string{
+			"javacFlags":    flags.javacFlags,
+			"bootClasspath": bootClasspath,
+			"classpath":     flags.classpath.FormJavaClassPath("-classpath"),
+			"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
+			"processor":     processor,
+			"srcJars":       strings.Join(srcJars.Strings(), " "),
+			"srcJarDir":     android.PathForModuleOut(ctx, "kythe", "srcjars").String(),
+			"outDir":        android.PathForModuleOut(ctx, "kythe", "classes").String(),
+			"annoDir":       android.PathForModuleOut(ctx, "kythe", "anno").String(),
+			"javaVersion":   flags.javaVersion,
+		},
+	})
+}
+
+type xrefProvider interface {
+	XrefJavaFiles() android.Paths
+}
+
+func (j *Module) XrefJavaFiles() android.Paths {
+	return j.kytheFiles
+}
+
+var _ xrefProvider = (*Module)(nil)
+
+func xrefJavaSingletonFactory() android.Singleton {
+	return &xrefJavaSingleton{}
+}
+
+// xrefJavaSingleton merges the .kzip files of all the java modules into xref/java.kzip, built by the
+// xref_java target.
+type xrefJavaSingleton struct {
+	kzip android.WritablePath
+}
+
+func (x *xrefJavaSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !ctx.Config().EmitXrefRules() {
+		return
+	}
+
+	var kzips android.Paths
+	ctx.VisitAllModules(func(m android.Module) {
+		if p, ok := m.(xrefProvider); ok && m.Enabled() {
+			kzips = append(kzips, p.XrefJavaFiles()...)
+		}
+	})
+
+	if len(kzips) == 0 {
+		return
+	}
+
+	sort.Slice(kzips, func(i, j int) bool {
+		return kzips[i].String() < kzips[j].String()
+	})
+
+	x.kzip = android.PathForOutput(ctx, "xref", "java.kzip")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        kytheMerge,
+		Description: "merge java kzips",
+		Inputs:      kzips,
+		Output:      x.kzip,
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:   blueprint.Phony,
+		Output: android.PathForPhony(ctx, "xref_java"),
+		Input:  x.kzip,
+	})
+}
+
+// Export the path of the merged .kzip to Make so that it can be added to dist for the indexer.
+func (x *xrefJavaSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if x.kzip != nil {
+		ctx.Strict("SOONG_XREF_JAVA_KZIP", x.kzip.String())
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*xrefJavaSingleton)(nil)
@@ -0,0 +1,73 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestKytheExtraction(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			libs: ["bar"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`
+
+	t.Run("enabled", func(t *testing.T) {
+		config := testConfig(map[string]string{"XREF_CORPUS": "android.googlesource.com/platform/superproject"})
+		ctx := testContext(config, bp, nil)
+		ctx.RegisterSingletonType("xref_java", android.SingletonFactoryAdaptor(xrefJavaSingletonFactory))
+		run(t, ctx, config)
+
+		foo := ctx.ModuleForTests("foo", "android_common")
+		kythe := foo.Rule("kythe")
+		fooKzip := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "kythe", "foo.jar.kzip")
+		if g := kythe.Output.String(); g != fooKzip {
+			t.Errorf("expected foo kzip %q, got %q", fooKzip, g)
+		}
+		if len(kythe.Inputs) != 1 || kythe.Inputs[0].String() != "a.java" {
+			t.Errorf(`expected foo kzip inputs ["a.java"], got %q`, kythe.Inputs)
+		}
+		if g, w := kythe.Args["classpath"], foo.Rule("javac").Args["classpath"]; g != w {
+			t.Errorf("expected the classpath of javac %q, got %q", w, g)
+		}
+
+		merge := ctx.SingletonForTests("xref_java").Rule("kytheMerge")
+		if !android.InList(fooKzip, merge.Inputs.Strings()) {
+			t.Errorf("expected java.kzip inputs %q to contain %q", merge.Inputs.Strings(), fooKzip)
+		}
+		if !strings.HasSuffix(merge.Output.String(), "xref/java.kzip") {
+			t.Errorf("expected merged kzip xref/java.kzip, got %q", merge.Output.String())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx := testJava(t, bp)
+		if ctx.ModuleForTests("foo", "android_common").MaybeRule("kythe").Rule != nil {
+			t.Errorf("expected no kythe extraction without XREF_CORPUS")
+		}
+	})
+}
@@ -0,0 +1,293 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file runs Android Lint on the device java modules that enable it with lint.enabled.  The lint
+// rules are only run when their reports are requested, either directly or through the lint-check
+// target, which also zips the reports of all modules into a single zip per report format.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("lint", lintSingletonFactory)
+}
+
+type LintProperties struct {
+	// Controls for running Android Lint on the module.
+	Lint struct {
+		// If true, run Android Lint on the module.  Defaults to false.
+		Enabled *bool
+
+		// Flags to pass to the Android Lint tool.
+		Flags []string
+
+		// Checks that should be treated as fatal.
+		Fatal_checks []string
+
+		// Checks that should be treated as errors.
+		Error_checks []string
+
+		// Checks that should be treated as warnings.
+		Warning_checks []string
+
+		// Checks that should be skipped.
+		Disabled_checks []string
+
+		// Name of the file in the module directory that lists the existing issues that lint should
+		// not report.  Defaults to lint-baseline.xml if it exists.
+		Baseline_filename *string
+	}
+}
+
+// updatabilityChecks are lint checks that are escalated to fatal for modules that set
+// min_sdk_version, as they may run on older platforms than the one they are built with.
+var updatabilityChecks = []string{"NewApi"}
+
+const defaultLintBaselineFilename = "lint-baseline.xml"
+
+var lint = pctx.AndroidStaticRule("lint",
+	blueprint.RuleParams{
+		Command: `rm -rf "$srcJarDir" && mkdir -p "$srcJarDir" && ` +
+			`${config.ZipSyncCmd} -d $srcJarDir -l $srcJarDir/list -f "*.java" $srcJars && ` +
+			`${config.LintProjectXmlCmd} --project_out $projectXml --config_out $configXml --name $name ` +
+			`--srcs $out.rsp --srcs $srcJarDir/list $projectArgs && ` +
+			`( ${config.LintCmd} --quiet --project $projectXml --config $configXml ` +
+			`--html $html --text $out --xml $xml --exitcode $lintFlags || ` +
+			`( echo "lint found errors in $name, see $out" && cat $out && exit 1 ) ) && ` +
+			`rm -rf "$srcJarDir"`,
+		CommandDeps: []string{
+			"${config.ZipSyncCmd}",
+			"${config.LintProjectXmlCmd}",
+			"${config.LintCmd}",
+		},
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	},
+	"srcJarDir", "srcJars", "projectXml", "configXml", "name", "projectArgs", "html", "xml", "lintFlags")
+
+type lintOutputs struct {
+	html android.Path
+	text android.Path
+	xml  android.Path
+}
+
+// linter collects the inputs of Android Lint while a module is built.
+type linter struct {
+	name      string
+	manifest  android.Path
+	resources android.Paths
+	srcs      android.Paths
+	srcJars   android.Paths
+	classpath android.Paths
+	classes   android.Path
+	library   bool
+	test      bool
+	// true if the module sets min_sdk_version, see updatabilityChecks
+	updatable bool
+
+	lintProperties LintProperties
+
+	outputs lintOutputs
+}
+
+func (l *linter) lintEnabled() bool {
+	return Bool(l.lintProperties.Lint.Enabled)
+}
+
+// lintChecks returns the lint arguments that override the severities of checks, in increasing
+// order of precedence.
+func (l *linter) lintChecks(ctx android.ModuleContext) []string {
+	var args []string
+	args = append(args, android.JoinWithPrefix(l.lintProperties.Lint.Warning_checks, "--warning_check "))
+	args = append(args, android.JoinWithPrefix(l.lintProperties.Lint.Error_checks, "--error_check "))
+	args = append(args, android.JoinWithPrefix(l.lintProperties.Lint.Fatal_checks, "--fatal_check "))
+	args = append(args, android.JoinWithPrefix(l.lintProperties.Lint.Disabled_checks, "--disable_check "))
+
+	if l.updatable {
+		for _, check := range updatabilityChecks {
+			if inList(check, l.lintProperties.Lint.Disabled_checks) {
+				ctx.PropertyErrorf("lint.disabled_checks",
+					"check %q can't be disabled in modules that set min_sdk_version", check)
+			}
+		}
+		args = append(args, android.JoinWithPrefix(updatabilityChecks, "--fatal_check "))
+	}
+
+	return args
+}
+
+func (l *linter) lintBaseline(ctx android.ModuleContext) android.OptionalPath {
+	if l.lintProperties.Lint.Baseline_filename != nil {
+		baseline := android.ExistentPathForSource(ctx, ctx.ModuleDir(), *l.lintProperties.Lint.Baseline_filename)
+		if !baseline.Valid() {
+			ctx.PropertyErrorf("lint.baseline_filename", "%q does not exist in %s",
+				*l.lintProperties.Lint.Baseline_filename, ctx.ModuleDir())
+		}
+		return baseline
+	}
+	return android.ExistentPathForSource(ctx, ctx.ModuleDir(), defaultLintBaselineFilename)
+}
+
+func (l *linter) lint(ctx android.ModuleContext) {
+	if !l.lintEnabled() || len(l.srcs)+len(l.srcJars) == 0 {
+		return
+	}
+
+	var projectArgs []string
+	var deps android.Paths
+
+	deps = append(deps, l.srcJars...)
+
+	if l.manifest != nil {
+		projectArgs = append(projectArgs, "--manifest "+l.manifest.String())
+		deps = append(deps, l.manifest)
+	}
+	for _, res := range l.resources {
+		projectArgs = append(projectArgs, "--resources "+res.String())
+	}
+	deps = append(deps, l.resources...)
+	for _, jar := range l.classpath {
+		projectArgs = append(projectArgs, "--classpath "+jar.String())
+	}
+	deps = append(deps, l.classpath...)
+	if l.classes != nil {
+		projectArgs = append(projectArgs, "--classes "+l.classes.String())
+		deps = append(deps, l.classes)
+	}
+	if l.library {
+		projectArgs = append(projectArgs, "--library")
+	}
+	if l.test {
+		projectArgs = append(projectArgs, "--test")
+	}
+	projectArgs = append(projectArgs, l.lintChecks(ctx)...)
+
+	lintFlags := append([]string(nil), l.lintProperties.Lint.Flags...)
+	if baseline := l.lintBaseline(ctx); baseline.Valid() {
+		lintFlags = append(lintFlags, "--baseline "+baseline.String())
+		deps = append(deps, baseline.Path())
+	}
+
+	html := android.PathForModuleOut(ctx, "lint", "lint-report.html")
+	text := android.PathForModuleOut(ctx, "lint", "lint-report.txt")
+	xml := android.PathForModuleOut(ctx, "lint", "lint-report.xml")
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:            lint,
+		Description:     "lint",
+		Output:          text,
+		ImplicitOutputs: android.WritablePaths{html, xml},
+		Inputs:          l.srcs,
+		Implicits:       deps,
+		Args: map[string]string{
+			"srcJarDir":   android.PathForModuleOut(ctx, "lint", "srcjars").String(),
+			"srcJars":     strings.Join(l.srcJars.Strings(), " "),
+			"projectXml":  android.PathForModuleOut(ctx, "lint", "project.xml").String(),
+			"configXml":   android.PathForModuleOut(ctx, "lint", "lint.xml").String(),
+			"name":        l.name,
+			"projectArgs": strings.Join(projectArgs, " "),
+			"html":        html.String(),
+			"xml":         xml.String(),
+			"lintFlags":   strings.Join(lintFlags, " "),
+		},
+	})
+
+	l.outputs = lintOutputs{
+		html: html,
+		text: text,
+		xml:  xml,
+	}
+}
+
+type lintOutputsProvider interface {
+	lintOutputs() *lintOutputs
+}
+
+func (l *linter) lintOutputs() *lintOutputs {
+	return &l.outputs
+}
+
+var _ lintOutputsProvider = (*linter)(nil)
+
+func lintSingletonFactory() android.Singleton {
+	return &lintSingleton{}
+}
+
+// lintSingleton zips the lint reports of all modules and adds them to the lint-check target.
+type lintSingleton struct {
+	htmlZip android.WritablePath
+	textZip android.WritablePath
+	xmlZip  android.WritablePath
+}
+
+func (l *lintSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var outputs []*lintOutputs
+	ctx.VisitAllModules(func(m android.Module) {
+		if p, ok := m.(lintOutputsProvider); ok && m.Enabled() && p.lintOutputs().text != nil {
+			outputs = append(outputs, p.lintOutputs())
+		}
+	})
+
+	if len(outputs) == 0 {
+		return
+	}
+
+	zip := func(name string, get func(*lintOutputs) android.Path) android.WritablePath {
+		var paths android.Paths
+		for _, output := range outputs {
+			paths = append(paths, get(output))
+		}
+		sort.Slice(paths, func(i, j int) bool {
+			return paths[i].String() < paths[j].String()
+		})
+
+		outputPath := android.PathForOutput(ctx, name)
+		rule := android.NewRuleBuilder()
+		rule.Command().Tool(ctx.Config().HostToolPath(ctx, "soong_zip")).
+			FlagWithOutput("-o ", outputPath).
+			FlagWithArg("-C ", android.PathForIntermediates(ctx).String()).
+			FlagForEachInput("-f ", paths)
+		rule.Build(pctx, ctx, strings.TrimSuffix(name, ".zip"), "zip "+name)
+		return outputPath
+	}
+
+	l.htmlZip = zip("lint-report-html.zip", func(o *lintOutputs) android.Path { return o.html })
+	l.textZip = zip("lint-report-text.zip", func(o *lintOutputs) android.Path { return o.text })
+	l.xmlZip = zip("lint-report-xml.zip", func(o *lintOutputs) android.Path { return o.xml })
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:      blueprint.Phony,
+		Output:    android.PathForPhony(ctx, "lint-check"),
+		Implicits: android.Paths{l.htmlZip, l.textZip, l.xmlZip},
+	})
+}
+
+// Export the paths of the report zips to Make so that they can be added to dist.
+func (l *lintSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if l.htmlZip != nil {
+		ctx.Strict("SOONG_LINT_REPORTS", strings.Join([]string{
+			l.htmlZip.String(), l.textZip.String(), l.xmlZip.String(),
+		}, " "))
+	}
+}
+
+var _ android.SingletonMakeVarsProvider = (*lintSingleton)(nil)
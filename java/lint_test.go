@@ -0,0 +1,181 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	ctx := testJava(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java", "b.kt"],
+			min_sdk_version: "29",
+			lint: {
+				enabled: true,
+				fatal_checks: ["Foo"],
+				disabled_checks: ["Bar"],
+				flags: ["--flag"],
+			},
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["a.java"],
+		}
+
+		java_test {
+			name: "baz",
+			srcs: ["a.java"],
+			lint: {
+				enabled: true,
+			},
+		}
+	`)
+
+	fooModule := ctx.ModuleForTests("foo", "android_common")
+	foo := fooModule.Rule("lint")
+
+	if len(foo.Inputs) != 2 || foo.Inputs[0].String() != "b.kt" || foo.Inputs[1].String() != "a.java" {
+		t.Errorf(`foo lint inputs %v != ["b.kt", "a.java"]`, foo.Inputs)
+	}
+
+	classes := fooModule.Module().(*Library).implementationJarFile.String()
+	if !inList(classes, foo.Implicits.Strings()) {
+		t.Errorf("foo lint implicits %v does not contain %q", foo.Implicits.Strings(), classes)
+	}
+
+	projectArgs := foo.Args["projectArgs"]
+	for _, arg := range []string{"--classes " + classes, "--library", "--fatal_check Foo",
+		"--disable_check Bar", "--fatal_check NewApi"} {
+		if !strings.Contains(projectArgs, arg) {
+			t.Errorf("foo lint projectArgs %q does not contain %q", projectArgs, arg)
+		}
+	}
+	if strings.Contains(projectArgs, "--test") {
+		t.Errorf("foo lint projectArgs %q unexpectedly contains --test", projectArgs)
+	}
+
+	if foo.Args["lintFlags"] != "--flag" {
+		t.Errorf("foo lintFlags %q != %q", foo.Args["lintFlags"], "--flag")
+	}
+
+	if bar := ctx.ModuleForTests("bar", "android_common").MaybeRule("lint"); bar.Rule != nil {
+		t.Errorf("lint should not run on bar, which doesn't enable it")
+	}
+
+	baz := ctx.ModuleForTests("baz", "android_common").Rule("lint")
+	if !strings.Contains(baz.Args["projectArgs"], "--test") {
+		t.Errorf("baz lint projectArgs %q does not contain --test", baz.Args["projectArgs"])
+	}
+	if strings.Contains(baz.Args["projectArgs"], "--fatal_check NewApi") {
+		t.Errorf("baz lint projectArgs %q unexpectedly contains --fatal_check NewApi", baz.Args["projectArgs"])
+	}
+
+	textZip := ctx.SingletonForTests("lint").Output("lint-report-text.zip")
+	fooText := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "lint", "lint-report.txt")
+	if !inList(fooText, textZip.Implicits.Strings()) {
+		t.Errorf("lint-report-text.zip inputs %v does not contain %q", textZip.Implicits.Strings(), fooText)
+	}
+	barText := filepath.Join(buildDir, ".intermediates", "bar", "android_common", "lint", "lint-report.txt")
+	if inList(barText, textZip.Implicits.Strings()) {
+		t.Errorf("lint-report-text.zip inputs %v unexpectedly contains %q", textZip.Implicits.Strings(), barText)
+	}
+
+	ctx.SingletonForTests("lint").Output("lint-check")
+}
+
+func TestLintApp(t *testing.T) {
+	config := testConfig(nil)
+	ctx := testContext(config, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+			lint: {
+				enabled: true,
+			},
+		}
+	`, map[string][]byte{
+		"res/values/strings.xml": nil,
+		"lint-baseline.xml":      nil,
+	})
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common").Rule("lint")
+
+	projectArgs := foo.Args["projectArgs"]
+	manifest := filepath.Join(buildDir, ".intermediates", "foo", "android_common", "manifest_fixer",
+		"AndroidManifest.xml")
+	for _, arg := range []string{"--manifest " + manifest, "--resources res/values/strings.xml"} {
+		if !strings.Contains(projectArgs, arg) {
+			t.Errorf("foo lint projectArgs %q does not contain %q", projectArgs, arg)
+		}
+	}
+	if strings.Contains(projectArgs, "--library") {
+		t.Errorf("foo lint projectArgs %q unexpectedly contains --library", projectArgs)
+	}
+
+	if foo.Args["lintFlags"] != "--baseline lint-baseline.xml" {
+		t.Errorf("foo lintFlags %q != %q", foo.Args["lintFlags"], "--baseline lint-baseline.xml")
+	}
+}
+
+func TestLintErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		bp    string
+		error string
+	}{
+		{
+			name: "disabled updatability check",
+			bp: `
+				java_library {
+					name: "foo",
+					srcs: ["a.java"],
+					min_sdk_version: "29",
+					lint: {
+						enabled: true,
+						disabled_checks: ["NewApi"],
+					},
+				}
+			`,
+			error: `lint.disabled_checks: check "NewApi" can't be disabled in modules that set min_sdk_version`,
+		},
+		{
+			name: "missing baseline",
+			bp: `
+				java_library {
+					name: "foo",
+					srcs: ["a.java"],
+					lint: {
+						enabled: true,
+						baseline_filename: "missing-baseline.xml",
+					},
+				}
+			`,
+			error: `lint.baseline_filename: "missing-baseline.xml" does not exist`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			testJavaError(t, test.error, test.bp)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"sort"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// This singleton merges the lists of code removed by R8 from every app that enables shrinking into a
+// single proguard_usage.zip for platform-wide dead code analysis.  The usage list of each app is stored
+// in the zip as <module>/unused.txt, where the variants of an app created by override_android_app modules
+// use the name of the override module.
+
+func init() {
+	android.RegisterSingletonType("proguard_usage", proguardUsageSingletonFactory)
+}
+
+var mergeProguardUsage = pctx.AndroidStaticRule("mergeProguardUsage",
+	blueprint.RuleParams{
+		Command:     `${config.MergeZipsCmd} -s $out $in`,
+		CommandDeps: []string{"${config.MergeZipsCmd}"},
+	})
+
+func proguardUsageSingletonFactory() android.Singleton {
+	return &proguardUsageSingleton{}
+}
+
+type proguardUsageSingleton struct {
+	proguardUsageZip android.Path
+}
+
+func (p *proguardUsageSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var usageZips android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		if app, ok := module.(*AndroidApp); ok && app.Enabled() && app.proguardUsageZip != nil {
+			usageZips = append(usageZips, app.proguardUsageZip)
+		}
+	})
+
+	if len(usageZips) == 0 {
+		return
+	}
+
+	sort.Slice(usageZips, func(i, j int) bool {
+		return usageZips[i].String() < usageZips[j].String()
+	})
+
+	proguardUsageZip := android.PathForOutput(ctx, "proguard_usage", "proguard_usage.zip")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        mergeProguardUsage,
+		Description: "merge proguard usage",
+		Output:      proguardUsageZip,
+		Inputs:      usageZips,
+	})
+
+	p.proguardUsageZip = proguardUsageZip
+}
+
+// Export the path to Make so that it can be added to dist.
+func (p *proguardUsageSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if p.proguardUsageZip != nil {
+		ctx.Strict("SOONG_PROGUARD_USAGE_ZIP", p.proguardUsageZip.String())
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var (
+	resourceApi = pctx.AndroidStaticRule("resourceApi",
+		blueprint.RuleParams{
+			Command:     `${config.ResourceApiCmd} --output $out $in`,
+			CommandDeps: []string{"${config.ResourceApiCmd}"},
+		})
+
+	resourceApiCheck = pctx.AndroidStaticRule("resourceApiCheck",
+		blueprint.RuleParams{
+			Command: `( diff $expected $actual && touch $out ) || ( echo -e "$msg" ; exit 38 )`,
+		},
+		"expected", "actual", "msg")
+
+	checkOverlayable = pctx.AndroidStaticRule("checkOverlayable",
+		blueprint.RuleParams{
+			Command:     `${config.CheckOverlayableCmd} $flags --output $out $in`,
+			CommandDeps: []string{"${config.CheckOverlayableCmd}"},
+		},
+		"flags")
+
+	checkResourceConflicts = pctx.AndroidStaticRule("checkResourceConflicts",
+		blueprint.RuleParams{
+			Command:     `${config.CheckResourceConflictsCmd} --aapt2 ${config.Aapt2Cmd} $flags --output $out $libraries`,
+			CommandDeps: []string{"${config.CheckResourceConflictsCmd}", "${config.Aapt2Cmd}"},
+		},
+		"flags", "libraries")
+)
+
+// buildResourceApi lists the resources declared public or overlayable in the values files of the
+// resource directories of the module into resourceApiFile, and adds it to the exported resource API
+// files.
+func (a *aapt) buildResourceApi(ctx android.ModuleContext, resourceFiles android.Paths) {
+	var valuesFiles android.Paths
+	for _, f := range resourceFiles {
+		if f.Ext() == ".xml" && strings.HasPrefix(filepath.Base(filepath.Dir(f.String())), "values") {
+			valuesFiles = append(valuesFiles, f)
+		}
+	}
+
+	a.resourceApiFile = android.PathForModuleOut(ctx, "resource_api.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        resourceApi,
+		Description: "resource api",
+		Inputs:      valuesFiles,
+		Output:      a.resourceApiFile,
+	})
+	a.exportedResourceApis = append(a.exportedResourceApis, a.resourceApiFile)
+}
+
+// checkResourceApi returns the timestamp of the rule that compares the resource API of the module to
+// the checked-in file set by checked_in_api_lint.  Runtime resource overlays can only customize the
+// overlayable resources, so adding or removing one has to be approved by updating the checked-in
+// file in the same change.
+func (a *aapt) checkResourceApi(ctx android.ModuleContext) android.Path {
+	checkedIn := android.PathForModuleSrc(ctx, String(a.aaptProperties.Checked_in_api_lint))
+
+	timestamp := android.PathForModuleOut(ctx, "check_resource_api.timestamp")
+	msg := fmt.Sprintf(`\n******************************\n`+
+		`The public or overlayable resources of %s don't match the checked in\n`+
+		`file %s, the diffs are shown above.  If the change is intended, update\n`+
+		`the checked in file by running:\n`+
+		`   cp %s %s\n`+
+		`and submit the updated file as part of your change.\n`+
+		`******************************\n`,
+		ctx.ModuleName(), checkedIn, a.resourceApiFile, checkedIn)
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        resourceApiCheck,
+		Description: "check resource api",
+		Output:      timestamp,
+		Implicits:   android.Paths{checkedIn, a.resourceApiFile},
+		Args: map[string]string{
+			"expected": checkedIn.String(),
+			"actual":   a.resourceApiFile.String(),
+			"msg":      msg,
+		},
+	})
+
+	return timestamp
+}
+
+// staticResourceApis returns the resource API files exported by the static libraries of the module.
+func staticResourceApis(ctx android.ModuleContext) android.Paths {
+	var apis android.Paths
+	ctx.VisitDirectDepsWithTag(staticLibTag, func(module android.Module) {
+		if aarDep, ok := module.(AndroidLibraryDependency); ok {
+			apis = append(apis, aarDep.ExportedResourceApis()...)
+		}
+	})
+	return android.FirstUniquePaths(apis)
+}
+
+// checkRROOverlayable returns the timestamp of the rule that checks that the product overlays of the
+// module that are turned into runtime resource overlays only overlay resources that the module or its
+// static libraries declare overlayable.  Unless the product enforces it, the resources that are not
+// overlayable are only reported as warnings.
+func (a *aapt) checkRROOverlayable(ctx android.ModuleContext, rroDirs []rroDir) android.Path {
+	var overlayFiles android.Paths
+	for _, d := range rroDirs {
+		overlayFiles = append(overlayFiles, d.files...)
+	}
+
+	var flags []string
+	for _, api := range a.exportedResourceApis {
+		flags = append(flags, "--target-api "+api.String())
+	}
+	if !ctx.Config().EnforceRROOverlayable() {
+		flags = append(flags, "--soft")
+	}
+
+	timestamp := android.PathForModuleOut(ctx, "check_rro_overlayable.timestamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkOverlayable,
+		Description: "check rro overlayable",
+		Inputs:      overlayFiles,
+		Implicits:   a.exportedResourceApis,
+		Output:      timestamp,
+		Args: map[string]string{
+			"flags": strings.Join(flags, " "),
+		},
+	})
+
+	return timestamp
+}
+
+// staticResourceLibrary is the resource package of a direct static library of a module, with the packages of the
+// static libraries that are merged into it.
+type staticResourceLibrary struct {
+	exportPackage  android.Path
+	staticPackages android.Paths
+}
+
+// staticResourceLibraries returns the resource packages of the direct static libraries of the module.
+func staticResourceLibraries(ctx android.ModuleContext) []staticResourceLibrary {
+	var libs []staticResourceLibrary
+	ctx.VisitDirectDepsWithTag(staticLibTag, func(module android.Module) {
+		if aarDep, ok := module.(AndroidLibraryDependency); ok && aarDep.ExportPackage() != nil {
+			libs = append(libs, staticResourceLibrary{aarDep.ExportPackage(), aarDep.ExportedStaticPackages()})
+		}
+	})
+	return libs
+}
+
+// checkStaticResourceConflicts returns the timestamp of the rule that checks that the direct static libraries of
+// the module don't define a resource with different values for the same configuration.  aapt2 links them as
+// overlays, so without the check the order of the static libraries silently picks one of the values.  Only the
+// resources that each library defines itself are compared, not the ones merged from its own static libraries,
+// which were checked when it was built, and a library may override the resources of its static libraries.
+func (a *aapt) checkStaticResourceConflicts(ctx android.ModuleContext, libs []staticResourceLibrary) android.Path {
+	var flags []string
+	for _, res := range a.aaptProperties.Allowed_resource_conflicts {
+		if !strings.Contains(res, "/") {
+			ctx.PropertyErrorf("allowed_resource_conflicts", "invalid resource %q, expected <type>/<name>", res)
+			continue
+		}
+		flags = append(flags, "--allow "+res)
+	}
+
+	var inputs android.Paths
+	var libraries []string
+	for _, lib := range libs {
+		inputs = append(inputs, lib.exportPackage)
+		inputs = append(inputs, lib.staticPackages...)
+		libraries = append(libraries, lib.exportPackage.String()+":"+strings.Join(lib.staticPackages.Strings(), ","))
+	}
+
+	timestamp := android.PathForModuleOut(ctx, "check_resource_conflicts.timestamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkResourceConflicts,
+		Description: "check static library resource conflicts",
+		Inputs:      android.FirstUniquePaths(inputs),
+		Output:      timestamp,
+		Args: map[string]string{
+			"flags":     strings.Join(flags, " "),
+			"libraries": strings.Join(libraries, " "),
+		},
+	})
+
+	return timestamp
+}
@@ -16,6 +16,7 @@ package java
 
 import (
 	"android/soong/android"
+	"android/soong/dexpreopt"
 	"android/soong/genrule"
 	"fmt"
 	"io"
@@ -149,6 +150,11 @@ type SdkLibrary struct {
 	publicApiFilePath android.Path
 	systemApiFilePath android.Path
 	testApiFilePath   android.Path
+
+	// Timestamps of the rules that check the API of every scope against the checked in api/*.txt files, and
+	// of the rules that copy the generated API files over them.
+	checkApiTimestamps  android.Paths
+	updateApiTimestamps android.Paths
 }
 
 var _ Dependency = (*SdkLibrary)(nil)
@@ -205,6 +211,16 @@ func (module *SdkLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext)
 				ctx.ModuleErrorf("depends on module %q of unknown tag %q", otherName, tag)
 			}
 		}
+		if doc, ok := to.(ApiCheckTimestamps); ok {
+			for _, timestamp := range []android.Path{doc.CheckCurrentApiTimestamp(), doc.CheckLastReleasedApiTimestamp()} {
+				if timestamp != nil {
+					module.checkApiTimestamps = append(module.checkApiTimestamps, timestamp)
+				}
+			}
+			if timestamp := doc.UpdateCurrentApiTimestamp(); timestamp != nil {
+				module.updateApiTimestamps = append(module.updateApiTimestamps, timestamp)
+			}
+		}
 	})
 }
 
@@ -216,6 +232,19 @@ func (module *SdkLibrary) AndroidMk() android.AndroidMkData {
 		android.WriteAndroidMkData(w, data)
 
 		module.Library.AndroidMkHostDex(w, name, data)
+
+		// Check or update the API files of all scopes of the library at once.
+		if len(module.checkApiTimestamps) > 0 {
+			fmt.Fprintln(w, ".PHONY:", module.BaseModuleName()+"-check-api")
+			fmt.Fprintln(w, module.BaseModuleName()+"-check-api:",
+				strings.Join(module.checkApiTimestamps.Strings(), " "))
+		}
+		if len(module.updateApiTimestamps) > 0 {
+			fmt.Fprintln(w, ".PHONY:", module.BaseModuleName()+"-update-api")
+			fmt.Fprintln(w, module.BaseModuleName()+"-update-api:",
+				strings.Join(module.updateApiTimestamps.Strings(), " "))
+		}
+
 		if !Bool(module.sdkLibraryProperties.No_dist) {
 			// Create a phony module that installs the impl library, for the case when this lib is
 			// in PRODUCT_PACKAGES.
@@ -704,7 +733,7 @@ func (module *SdkLibrary) CreateInternalModules(mctx android.LoadHookContext) {
 
 		mctx.ModuleErrorf("One or more current api files are missing. "+
 			"You can update them by:\n"+
-			"%s %q && m update-api", script, mctx.ModuleDir())
+			"%s %q && m %s-update-api", script, mctx.ModuleDir(), module.BaseModuleName())
 		return
 	}
 
@@ -738,12 +767,14 @@ func (module *SdkLibrary) InitSdkLibraryProperties() {
 		&module.sdkLibraryProperties,
 		&module.Library.Module.properties,
 		&module.Library.Module.dexpreoptProperties,
+		&module.Library.Module.linter.lintProperties,
 		&module.Library.Module.deviceProperties,
 		&module.Library.Module.protoProperties,
 	)
 
 	module.Library.Module.properties.Installable = proptools.BoolPtr(true)
 	module.Library.Module.deviceProperties.IsSDKLibrary = true
+	module.Library.Module.linter.library = true
 }
 
 func SdkLibraryFactory() android.Module {
@@ -759,8 +790,23 @@ func SdkLibraryFactory() android.Module {
 //
 
 type sdkLibraryImportProperties struct {
+	// The stub jars of the public API.
 	Jars []string `android:"path"`
 
+	// The stub jars of the system API.  Defaults to the stub jars of the public API.
+	System_jars []string `android:"path"`
+
+	// The stub jars of the test API.  Defaults to the stub jars of the system API.
+	Test_jars []string `android:"path"`
+
+	// A jar containing the classes.dex files of the implementation library.  It is installed to the framework
+	// directory of the partition and used to dexpreopt the apps that use the library.
+	Dex_jar *string `android:"path"`
+
+	// The XML permissions file that makes the implementation library available to apps through <uses-library>.
+	// It is installed to etc/permissions.
+	Permissions_xml *string `android:"path"`
+
 	Sdk_version *string
 
 	Installable *bool
@@ -782,11 +828,23 @@ type sdkLibraryImport struct {
 
 	properties sdkLibraryImportProperties
 
-	stubsPath android.Paths
+	publicApiStubsPath android.Paths
+	systemApiStubsPath android.Paths
+	testApiStubsPath   android.Paths
+
+	dexJarFile              android.Path
+	maybeStrippedDexJarFile android.Path
+	dexJarInstallLocation   string
+
+	dexpreopter
 }
 
 var _ SdkLibraryDependency = (*sdkLibraryImport)(nil)
+var _ UsesLibraryDependency = (*sdkLibraryImport)(nil)
 
+// java_sdk_library_import imports the prebuilt stub jars of each API scope of a java_sdk_library, and optionally the
+// dex jar of its implementation and its XML permissions file, so that branches without the sources of the library
+// can compile against it, install it, and dexpreopt the apps that use it.
 func sdkLibraryImportFactory() android.Module {
 	module := &sdkLibraryImport{}
 
@@ -807,16 +865,43 @@ func (module *sdkLibraryImport) Name() string {
 	return module.prebuilt.Name(module.ModuleBase.Name())
 }
 
-func (module *sdkLibraryImport) createInternalModules(mctx android.LoadHookContext) {
-	// Creates a java import for the jar with ".stubs" suffix
+func (module *sdkLibraryImport) stubsName(apiScope apiScope) string {
+	stubsName := module.BaseModuleName() + sdkStubsLibrarySuffix
+	switch apiScope {
+	case apiScopeSystem:
+		stubsName = stubsName + sdkSystemApiSuffix
+	case apiScopeTest:
+		stubsName = stubsName + sdkTestApiSuffix
+	}
+	return stubsName
+}
+
+func (module *sdkLibraryImport) xmlFileName() string {
+	return module.BaseModuleName() + sdkXmlFileSuffix
+}
+
+// Creates a java import for the stub jars of an API scope
+func (module *sdkLibraryImport) createStubsLibrary(mctx android.LoadHookContext, apiScope apiScope, jars []string) {
 	props := struct {
 		Name             *string
+		Jars             []string
+		Sdk_version      *string
+		Installable      *bool
+		Libs             []string
+		Exclude_files    []string
+		Exclude_dirs     []string
 		Soc_specific     *bool
 		Device_specific  *bool
 		Product_specific *bool
 	}{}
 
-	props.Name = proptools.StringPtr(module.BaseModuleName() + sdkStubsLibrarySuffix)
+	props.Name = proptools.StringPtr(module.stubsName(apiScope))
+	props.Jars = jars
+	props.Sdk_version = module.properties.Sdk_version
+	props.Installable = module.properties.Installable
+	props.Libs = module.properties.Libs
+	props.Exclude_files = module.properties.Exclude_files
+	props.Exclude_dirs = module.properties.Exclude_dirs
 
 	if module.SocSpecific() {
 		props.Soc_specific = proptools.BoolPtr(true)
@@ -826,7 +911,46 @@ func (module *sdkLibraryImport) createInternalModules(mctx android.LoadHookConte
 		props.Product_specific = proptools.BoolPtr(true)
 	}
 
-	mctx.CreateModule(android.ModuleFactoryAdaptor(ImportFactory), &props, &module.properties)
+	mctx.CreateModule(android.ModuleFactoryAdaptor(ImportFactory), &props)
+}
+
+// Creates a prebuilt_etc module that installs the XML permissions file under <partition>/etc/permissions
+func (module *sdkLibraryImport) createXmlFile(mctx android.LoadHookContext) {
+	etcProps := struct {
+		Name             *string
+		Src              *string
+		Filename         *string
+		Sub_dir          *string
+		Soc_specific     *bool
+		Device_specific  *bool
+		Product_specific *bool
+	}{}
+	etcProps.Name = proptools.StringPtr(module.xmlFileName())
+	etcProps.Src = module.properties.Permissions_xml
+	etcProps.Filename = proptools.StringPtr(module.xmlFileName())
+	etcProps.Sub_dir = proptools.StringPtr("permissions")
+	if module.SocSpecific() {
+		etcProps.Soc_specific = proptools.BoolPtr(true)
+	} else if module.DeviceSpecific() {
+		etcProps.Device_specific = proptools.BoolPtr(true)
+	} else if module.ProductSpecific() {
+		etcProps.Product_specific = proptools.BoolPtr(true)
+	}
+	mctx.CreateModule(android.ModuleFactoryAdaptor(android.PrebuiltEtcFactory), &etcProps)
+}
+
+func (module *sdkLibraryImport) createInternalModules(mctx android.LoadHookContext) {
+	module.createStubsLibrary(mctx, apiScopePublic, module.properties.Jars)
+	if len(module.properties.System_jars) > 0 {
+		module.createStubsLibrary(mctx, apiScopeSystem, module.properties.System_jars)
+	}
+	if len(module.properties.Test_jars) > 0 {
+		module.createStubsLibrary(mctx, apiScopeTest, module.properties.Test_jars)
+	}
+
+	if module.properties.Permissions_xml != nil {
+		module.createXmlFile(mctx)
+	}
 
 	javaSdkLibraries := javaSdkLibraries(mctx.Config())
 	javaSdkLibrariesLock.Lock()
@@ -835,30 +959,91 @@ func (module *sdkLibraryImport) createInternalModules(mctx android.LoadHookConte
 }
 
 func (module *sdkLibraryImport) DepsMutator(ctx android.BottomUpMutatorContext) {
-	// Add dependencies to the prebuilt stubs library
-	ctx.AddVariationDependencies(nil, publicApiStubsTag, module.BaseModuleName()+sdkStubsLibrarySuffix)
+	// Add dependencies to the prebuilt stubs libraries
+	ctx.AddVariationDependencies(nil, publicApiStubsTag, module.stubsName(apiScopePublic))
+	if len(module.properties.System_jars) > 0 {
+		ctx.AddVariationDependencies(nil, systemApiStubsTag, module.stubsName(apiScopeSystem))
+	}
+	if len(module.properties.Test_jars) > 0 {
+		ctx.AddVariationDependencies(nil, testApiStubsTag, module.stubsName(apiScopeTest))
+	}
 }
 
 func (module *sdkLibraryImport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	// Record the paths to the prebuilt stubs library.
+	// Record the paths to the prebuilt stubs libraries.
 	ctx.VisitDirectDeps(func(to android.Module) {
 		tag := ctx.OtherModuleDependencyTag(to)
 
 		switch tag {
 		case publicApiStubsTag:
-			module.stubsPath = to.(Dependency).HeaderJars()
+			module.publicApiStubsPath = to.(Dependency).HeaderJars()
+		case systemApiStubsTag:
+			module.systemApiStubsPath = to.(Dependency).HeaderJars()
+		case testApiStubsTag:
+			module.testApiStubsPath = to.(Dependency).HeaderJars()
 		}
 	})
+
+	if module.properties.Dex_jar != nil && ctx.Device() {
+		jarName := module.BaseModuleName() + ".jar"
+		module.dexpreopter.installPath = android.PathForModuleInstall(ctx, "framework", jarName)
+		module.dexpreopter.isInstallable = true
+		module.dexpreopter.uncompressedDex = shouldUncompressDex(ctx, &module.dexpreopter)
+
+		inputJar := android.PathForModuleSrc(ctx, *module.properties.Dex_jar)
+		dexOutputFile := android.PathForModuleOut(ctx, jarName)
+		copyDexJar(ctx, inputJar, dexOutputFile, module.dexpreopter.uncompressedDex)
+		module.dexJarFile = dexOutputFile
+		// Only the variants with a dex jar are exported to Make.
+		ctx.SetProvider(android.MakeClassProvider, "JAVA_LIBRARIES")
+
+		module.maybeStrippedDexJarFile = module.dexpreopt(ctx, dexOutputFile)
+		module.dexJarInstallLocation = android.InstallPathToOnDevicePath(ctx, module.dexpreopter.installPath)
+
+		ctx.InstallFile(android.PathForModuleInstall(ctx, "framework"), jarName, module.maybeStrippedDexJarFile)
+		module.dexpreopter.installBuiltFiles(ctx)
+	}
+}
+
+// systemApiStubs returns the system stub jars, falling back to the public stub jars if the prebuilt has none.
+func (module *sdkLibraryImport) systemApiStubs() android.Paths {
+	if len(module.systemApiStubsPath) > 0 {
+		return module.systemApiStubsPath
+	}
+	return module.publicApiStubsPath
 }
 
 // to satisfy SdkLibraryDependency interface
 func (module *sdkLibraryImport) SdkHeaderJars(ctx android.BaseModuleContext, sdkVersion string) android.Paths {
 	// This module is just a wrapper for the prebuilt stubs.
-	return module.stubsPath
+	if strings.HasPrefix(sdkVersion, "test_") && len(module.testApiStubsPath) > 0 {
+		return module.testApiStubsPath
+	} else if strings.HasPrefix(sdkVersion, "system_") || strings.HasPrefix(sdkVersion, "test_") ||
+		sdkVersion == "" {
+		return module.systemApiStubs()
+	} else {
+		return module.publicApiStubsPath
+	}
 }
 
 // to satisfy SdkLibraryDependency interface
 func (module *sdkLibraryImport) SdkImplementationJars(ctx android.BaseModuleContext, sdkVersion string) android.Paths {
 	// This module is just a wrapper for the stubs.
-	return module.stubsPath
+	return module.SdkHeaderJars(ctx, sdkVersion)
+}
+
+// to satisfy UsesLibraryDependency interface
+func (module *sdkLibraryImport) DexJar() android.Path {
+	return module.dexJarFile
+}
+
+// to satisfy UsesLibraryDependency interface
+func (module *sdkLibraryImport) DexJarInstallLocation() string {
+	return module.dexJarInstallLocation
+}
+
+// to satisfy UsesLibraryDependency interface
+func (module *sdkLibraryImport) ClassLoaderContexts() dexpreopt.ClassLoaderContextMap {
+	// The prebuilt doesn't know the shared libraries used by the implementation.
+	return nil
 }
@@ -0,0 +1,191 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("android_sdk_repo", SdkRepoFactory)
+}
+
+type sdkRepoDependencyTag struct {
+	blueprint.BaseDependencyTag
+	name string
+}
+
+var (
+	sdkRepoHostToolTag = sdkRepoDependencyTag{name: "host_tool"}
+	sdkRepoStubJarTag  = sdkRepoDependencyTag{name: "stub_jar"}
+)
+
+type SdkRepoProperties struct {
+	// Name of the top level directory of the package in the zip file, for example "platform-tools".  Defaults to the
+	// module name.
+	Base_dir *string
+
+	// List of host tool modules, for example cc_binary_host or java_binary_host modules, that are packaged into
+	// the top level directory.
+	Host_tools []string
+
+	// List of java library modules whose implementation jars, usually API stubs, are packaged into the top level
+	// directory as <module name>.jar.
+	Stub_jars []string
+
+	// List of files, for example app templates, that are packaged into the templates directory, keeping their path
+	// relative to the module directory.
+	Templates []string `android:"path"`
+
+	// Revision of the package, written to Pkg.Revision in source.properties.
+	Revision *string
+
+	// Description of the package, written to Pkg.Desc in source.properties.
+	Description *string
+}
+
+type SdkRepo struct {
+	android.ModuleBase
+
+	properties SdkRepoProperties
+
+	outputFile android.WritablePath
+}
+
+func (r *SdkRepo) baseDir() string {
+	return proptools.StringDefault(r.properties.Base_dir, r.Name())
+}
+
+func (r *SdkRepo) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddFarVariationDependencies([]blueprint.Variation{
+		{Mutator: "arch", Variation: ctx.Config().BuildOsVariant},
+	}, sdkRepoHostToolTag, r.properties.Host_tools...)
+
+	ctx.AddFarVariationDependencies([]blueprint.Variation{
+		{Mutator: "arch", Variation: "android_common"},
+	}, sdkRepoStubJarTag, r.properties.Stub_jars...)
+}
+
+func (r *SdkRepo) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if String(r.properties.Revision) == "" {
+		ctx.PropertyErrorf("revision", "revision is required")
+		return
+	}
+
+	baseDir := r.baseDir()
+	if baseDir != filepath.Clean(baseDir) || baseDir == ".." || strings.HasPrefix(baseDir, "../") ||
+		strings.HasPrefix(baseDir, "/") {
+		ctx.PropertyErrorf("base_dir", "must be a clean relative path, got %q", baseDir)
+		return
+	}
+
+	stagingDir := android.PathForModuleOut(ctx, "sdk_repo")
+	pkgDir := filepath.Join(stagingDir.String(), baseDir)
+
+	r.outputFile = android.PathForModuleOut(ctx, ctx.ModuleName()+".zip")
+
+	rule := android.NewRuleBuilder()
+
+	rule.Command().Text("rm -rf").Text(stagingDir.String())
+	rule.Command().Text("mkdir -p").Text(pkgDir)
+
+	ctx.VisitDirectDepsWithTag(sdkRepoHostToolTag, func(m android.Module) {
+		if t, ok := m.(android.HostToolProvider); ok && t.HostToolPath().Valid() {
+			tool := t.HostToolPath().Path()
+			rule.Command().Text("cp -f").Input(tool).Text(filepath.Join(pkgDir, tool.Base()))
+		} else {
+			ctx.PropertyErrorf("host_tools", "module %q is not a host tool", ctx.OtherModuleName(m))
+		}
+	})
+
+	ctx.VisitDirectDepsWithTag(sdkRepoStubJarTag, func(m android.Module) {
+		if dep, ok := m.(Dependency); ok && len(dep.ImplementationAndResourcesJars()) == 1 {
+			rule.Command().Text("cp -f").
+				Input(dep.ImplementationAndResourcesJars()[0]).
+				Text(filepath.Join(pkgDir, ctx.OtherModuleName(m)+".jar"))
+		} else {
+			ctx.PropertyErrorf("stub_jars", "module %q does not produce a single jar", ctx.OtherModuleName(m))
+		}
+	})
+
+	for _, template := range android.PathsForModuleSrc(ctx, r.properties.Templates) {
+		dest := filepath.Join(pkgDir, "templates", template.Rel())
+		rule.Command().Text("mkdir -p").Text(filepath.Dir(dest))
+		rule.Command().Text("cp -f").Input(template).Text(dest)
+	}
+
+	// source.properties is the manifest that the SDK manager reads from each package.
+	sourceProperties := filepath.Join(pkgDir, "source.properties")
+	rule.Command().Text("echo").
+		Text(proptools.ShellEscape("Pkg.Revision=" + String(r.properties.Revision))).
+		Text(">").Text(sourceProperties)
+	if desc := String(r.properties.Description); desc != "" {
+		rule.Command().Text("echo").
+			Text(proptools.ShellEscape("Pkg.Desc=" + desc)).
+			Text(">>").Text(sourceProperties)
+	}
+
+	rule.Command().
+		Tool(ctx.Config().HostToolPath(ctx, "soong_zip")).
+		FlagWithOutput("-o ", r.outputFile).
+		FlagWithArg("-C ", stagingDir.String()).
+		FlagWithArg("-D ", stagingDir.String())
+
+	rule.Command().Text("rm -rf").Text(stagingDir.String())
+
+	rule.Build(pctx, ctx, "sdk_repo", "sdk repo "+ctx.ModuleName())
+}
+
+func (r *SdkRepo) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{r.outputFile}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+var _ android.OutputFileProducer = (*SdkRepo)(nil)
+
+func (r *SdkRepo) AndroidMk() android.AndroidMkData {
+	return android.AndroidMkData{
+		Custom: func(w io.Writer, name, prefix, moduleDir string, data android.AndroidMkData) {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, ".PHONY:", name)
+			fmt.Fprintln(w, name+":", r.outputFile.String())
+			fmt.Fprintln(w, "$(call dist-for-goals,sdk_repo "+name+","+
+				r.outputFile.String()+":"+r.outputFile.Base()+")")
+		},
+	}
+}
+
+// android_sdk_repo packages host tools, API stub jars and app templates into a zip file with the layout of a package
+// in the SDK repository, with a source.properties file describing the package.
+//
+// The zip file is copied to the dist directory for the sdk_repo goal.
+func SdkRepoFactory() android.Module {
+	module := &SdkRepo{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidModule(module)
+	return module
+}
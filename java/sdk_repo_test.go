@@ -0,0 +1,107 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSdkRepo(t *testing.T) {
+	config := testConfig(nil)
+	ctx := testContext(config, `
+		java_binary_host {
+			name: "sdktool",
+			srcs: ["a.java"],
+		}
+
+		java_library {
+			name: "android_stubs",
+			srcs: ["b.java"],
+		}
+
+		android_sdk_repo {
+			name: "sdk-repo-tools",
+			base_dir: "platform-tools",
+			host_tools: ["sdktool"],
+			stub_jars: ["android_stubs"],
+			templates: ["templates/**/*"],
+			revision: "29.0.1",
+			description: "Android SDK Platform-Tools",
+		}
+	`, map[string][]byte{
+		"templates/Activity/template.xml":      nil,
+		"templates/Activity/root/MainActivity": nil,
+	})
+	run(t, ctx, config)
+
+	repo := ctx.ModuleForTests("sdk-repo-tools", "")
+	rule := repo.Rule("sdk_repo")
+
+	if g, w := rule.Output.String(), "sdk-repo-tools.zip"; !strings.HasSuffix(g, w) {
+		t.Errorf("expected output %q, got %q", w, g)
+	}
+
+	stubJar := ctx.ModuleForTests("android_stubs", "android_common").Module().(*Library).ImplementationAndResourcesJars()[0]
+	expectedInputs := []string{
+		"host/linux-x86/bin/sdktool",
+		stubJar.String(),
+		"templates/Activity/root/MainActivity",
+		"templates/Activity/template.xml",
+	}
+	for _, input := range expectedInputs {
+		found := false
+		for _, implicit := range rule.Implicits.Strings() {
+			if strings.HasSuffix(implicit, input) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected input %q in %q", input, rule.Implicits.Strings())
+		}
+	}
+
+	cmd := rule.RuleParams.Command
+	pkgDir := "sdk-repo-tools/sdk_repo/platform-tools"
+	expectedCmds := []string{
+		pkgDir + "/sdktool",
+		pkgDir + "/android_stubs.jar",
+		pkgDir + "/templates/Activity/template.xml",
+		"Pkg.Revision=29.0.1",
+		"'Pkg.Desc=Android SDK Platform-Tools' >> ",
+		pkgDir + "/source.properties",
+	}
+	for _, c := range expectedCmds {
+		if !strings.Contains(cmd, c) {
+			t.Errorf("expected %q in command %q", c, cmd)
+		}
+	}
+}
+
+func TestSdkRepoErrors(t *testing.T) {
+	testJavaError(t, `revision: revision is required`, `
+		android_sdk_repo {
+			name: "sdk-repo-tools",
+		}
+	`)
+
+	testJavaError(t, `base_dir: must be a clean relative path`, `
+		android_sdk_repo {
+			name: "sdk-repo-tools",
+			base_dir: "../tools",
+			revision: "1",
+		}
+	`)
+}
@@ -0,0 +1,214 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file implements strict_java_deps, which compiles a module against only the header jars of
+// the modules listed directly in its libs and static_libs.  Without it, the header jar of a
+// static_libs dependency also contains the classes of all of that dependency's static_libs, which
+// lets a module silently use classes it never declared a dependency on.
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+const (
+	strictJavaDepsWarning = "warning"
+	strictJavaDepsError   = "error"
+)
+
+func init() {
+	android.PostDepsMutators(RegisterStrictJavaDepsMutator)
+}
+
+func RegisterStrictJavaDepsMutator(ctx android.RegisterMutatorsContext) {
+	// Not parallel, the mutator marks the dependencies of the module, which are shared with other modules.
+	ctx.TopDown("strict_java_deps", strictJavaDepsMutator)
+}
+
+// strictJavaDepsModule is implemented by the modules that can set strict_java_deps or be a dependency
+// of a module that sets it.
+type strictJavaDepsModule interface {
+	strictJavaDepsEnabled() bool
+	setStrictJavaDepsDirectHeaderJar()
+}
+
+var _ strictJavaDepsModule = (*Module)(nil)
+
+func (j *Module) strictJavaDepsEnabled() bool {
+	return String(j.properties.Strict_java_deps) != ""
+}
+
+func (j *Module) setStrictJavaDepsDirectHeaderJar() {
+	j.properties.StrictJavaDepsDirectHeaderJar = true
+}
+
+// strictJavaDepsMutator marks the libs and static_libs of a module that sets strict_java_deps, and the
+// static_libs that they include directly or transitively, which are the candidates suggested as direct
+// dependencies, so that they build the header jar of only their own classes.  The other modules don't
+// need it.
+func strictJavaDepsMutator(ctx android.TopDownMutatorContext) {
+	if m, ok := ctx.Module().(strictJavaDepsModule); !ok || !m.strictJavaDepsEnabled() {
+		return
+	}
+	ctx.WalkDepsWithTags(func(child, parent android.Module, tag blueprint.DependencyTag, firstVisit bool) bool {
+		if parent != ctx.Module() && tag != staticLibTag {
+			return false
+		}
+		if tag != libTag && tag != staticLibTag && tag != instrumentationForTag {
+			return false
+		}
+		if dep, ok := child.(strictJavaDepsModule); ok {
+			dep.setStrictJavaDepsDirectHeaderJar()
+		}
+		return true
+	})
+}
+
+var strictJavaDepsCheck = pctx.AndroidStaticRule("strictJavaDepsCheck",
+	blueprint.RuleParams{
+		Command: `rm -rf "$outDir" "$srcJarDir" && mkdir -p "$outDir" "$srcJarDir" && ` +
+			`${config.ZipSyncCmd} -d $srcJarDir -l $srcJarDir/list -f "*.java" $srcJars && ` +
+			`( ${config.JavacCmd} ${config.JavacHeapFlags} ${config.JavacVmFlags} ${config.CommonJdkFlags} ` +
+			`$processorpath $processor $javacFlags $bootClasspath $classpath ` +
+			`-source $javaVersion -target $javaVersion ` +
+			`-d $outDir -s $outDir @$out.rsp @$srcJarDir/list > $out.log 2>&1 || ` +
+			`${config.SuggestJavaDepsCmd} --module $module --log $out.log $suggestFlags $candidates ) && ` +
+			`rm -rf "$outDir" "$srcJarDir" && touch $out`,
+		CommandDeps: []string{
+			"${config.JavacCmd}",
+			"${config.ZipSyncCmd}",
+			"${config.SuggestJavaDepsCmd}",
+		},
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	},
+	"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
+	"outDir", "javaVersion", "module", "suggestFlags", "candidates")
+
+// strictJavaDepsCandidate is a module that is only reachable through the static_libs of a direct
+// dependency, along with the header jars that contain its own classes.
+type strictJavaDepsCandidate struct {
+	name string
+	jars android.Paths
+}
+
+// strictJavaDepsDependency is implemented by dependencies that can provide the header jars of
+// their own classes separately from the classes of their static_libs.
+type strictJavaDepsDependency interface {
+	DirectHeaderJars() android.Paths
+	StaticLibCandidates() []strictJavaDepsCandidate
+}
+
+var _ strictJavaDepsDependency = (*Module)(nil)
+
+func (j *Module) DirectHeaderJars() android.Paths {
+	if j.directHeaderJarFile == nil {
+		return j.HeaderJars()
+	}
+	return android.Paths{j.directHeaderJarFile}
+}
+
+func (j *Module) StaticLibCandidates() []strictJavaDepsCandidate {
+	return j.staticLibCandidates
+}
+
+// directHeaderJars returns the header jars that contain only the classes of the dependency itself,
+// falling back to all of its header jars for dependencies that cannot separate them.
+func directHeaderJars(dep Dependency) android.Paths {
+	if strictDep, ok := dep.(strictJavaDepsDependency); ok {
+		return strictDep.DirectHeaderJars()
+	}
+	return dep.HeaderJars()
+}
+
+func staticLibCandidates(dep Dependency) []strictJavaDepsCandidate {
+	if strictDep, ok := dep.(strictJavaDepsDependency); ok {
+		return strictDep.StaticLibCandidates()
+	}
+	return nil
+}
+
+// strictJavaDeps returns the validated value of the strict_java_deps property, or "" if it is
+// not set.
+func (j *Module) strictJavaDeps(ctx android.ModuleContext) string {
+	switch mode := String(j.properties.Strict_java_deps); mode {
+	case "", strictJavaDepsWarning, strictJavaDepsError:
+		return mode
+	default:
+		ctx.PropertyErrorf("strict_java_deps", "unknown value %q, must be %q or %q",
+			mode, strictJavaDepsWarning, strictJavaDepsError)
+		return ""
+	}
+}
+
+// checkStrictJavaDeps compiles the sources against the strict classpath in flags, and passes any
+// errors to suggest_java_deps so that it can report which of the candidate modules should be added
+// as direct dependencies.  The build only fails on errors if failOnErrors is set.
+func checkStrictJavaDeps(ctx android.ModuleContext, outputFile android.WritablePath,
+	srcFiles, srcJars android.Paths, flags javaBuilderFlags, candidates []strictJavaDepsCandidate,
+	failOnErrors bool) {
+
+	deps := append(android.Paths(nil), srcJars...)
+
+	bootClasspath, bootClasspathDeps := javacBootClasspath(ctx, flags)
+	deps = append(deps, bootClasspathDeps...)
+
+	deps = append(deps, flags.classpath...)
+	deps = append(deps, flags.processorPath...)
+
+	processor := "-proc:none"
+	if flags.processor != "" {
+		processor = "-processor " + flags.processor
+	}
+
+	suggestFlags := ""
+	if failOnErrors {
+		suggestFlags = "--error"
+	}
+
+	var candidateArgs []string
+	for _, candidate := range candidates {
+		for _, jar := range candidate.jars {
+			candidateArgs = append(candidateArgs, "--candidate "+candidate.name+":"+jar.String())
+			deps = append(deps, jar)
+		}
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        strictJavaDepsCheck,
+		Description: "check strict_java_deps",
+		Output:      outputFile,
+		Inputs:      srcFiles,
+		Implicits:   deps,
+		Args: map[string]string{
+			"javacFlags":    flags.javacFlags,
+			"bootClasspath": bootClasspath,
+			"classpath":     flags.classpath.FormJavaClassPath("-classpath"),
+			"processorpath": flags.processorPath.FormJavaClassPath("-processorpath"),
+			"processor":     processor,
+			"srcJars":       strings.Join(srcJars.Strings(), " "),
+			"srcJarDir":     android.PathForModuleOut(ctx, "strict-java-deps", "srcjars").String(),
+			"outDir":        android.PathForModuleOut(ctx, "strict-java-deps", "classes").String(),
+			"javaVersion":   flags.javaVersion,
+			"module":        ctx.ModuleName(),
+			"suggestFlags":  suggestFlags,
+			"candidates":    strings.Join(candidateArgs, " "),
+		},
+	})
+}
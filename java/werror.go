@@ -0,0 +1,227 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// This file implements the java werror policies, the java counterpart of the cc -Werror ratchet.
+// PRODUCT_JAVA_WERROR_POLICIES selects javac lint warnings, like deprecation or unchecked, that are
+// errors for the modules under a path prefix.  The java sources of those modules are compiled a
+// second time with the selected warnings enabled, and the build fails if javac reports a warning
+// that is not listed in the baseline file of the module.  The <module>-update-werror-baseline
+// target writes the warnings that are currently reported to the baseline, so that existing
+// warnings can be fixed over time while new ones are rejected, the warnings of all the variants of the
+// module are written to the same baseline.  kotlinc doesn't report the
+// category of its warnings, so the policies only apply to the java sources.
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterSingletonType("java_werror", javaWerrorSingletonFactory)
+}
+
+const defaultJavaWerrorBaselineFilename = "java-werror-baseline.txt"
+
+// The warnings are collected by a rule that doesn't fail when they are reported, so that both the
+// check and the update of the baseline can use them.
+var javaWerrorWarnings = pctx.AndroidStaticRule("javaWerrorWarnings",
+	blueprint.RuleParams{
+		Command: `rm -rf "$outDir" "$srcJarDir" && mkdir -p "$outDir" "$srcJarDir" && ` +
+			`${config.ZipSyncCmd} -d $srcJarDir -l $srcJarDir/list -f "*.java" $srcJars && ` +
+			`( ${config.JavacCmd} ${config.JavacHeapFlags} ${config.JavacVmFlags} ${config.CommonJdkFlags} ` +
+			`$processorpath $processor $javacFlags -Xlint:$lintWarnings -Xmaxwarns 100000 ` +
+			`$bootClasspath $classpath -source $javaVersion -target $javaVersion ` +
+			`-d $outDir -s $outDir @$out.rsp @$srcJarDir/list > $out.log 2>&1 || ( cat $out.log ; false ) ) && ` +
+			`( grep -E '^[^ :]+:[0-9]+: warning: \[($warningsPattern)\]' $out.log | ` +
+			`sed -E 's/^([^:]+):[0-9]+: /\1: /' | LC_ALL=C sort -u > $out ; true ) && ` +
+			`rm -rf "$outDir" "$srcJarDir"`,
+		CommandDeps: []string{
+			"${config.JavacCmd}",
+			"${config.ZipSyncCmd}",
+		},
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	},
+	"javacFlags", "bootClasspath", "classpath", "processorpath", "processor", "srcJars", "srcJarDir",
+	"outDir", "javaVersion", "lintWarnings", "warningsPattern")
+
+var javaWerrorCheck = pctx.AndroidStaticRule("javaWerrorCheck",
+	blueprint.RuleParams{
+		Command: `rm -f $out && LC_ALL=C comm -23 $in $baseline > $out.new && if [ -s $out.new ] ; then ` +
+			`echo "error: javac reported warnings that PRODUCT_JAVA_WERROR_POLICIES turns into errors:" ; ` +
+			`cat $out.new ; ` +
+			`echo "Fix them, or run m ${module}-update-werror-baseline to add them to $baselineName" ; ` +
+			`exit 1 ; fi && ` +
+			`rm -f $out.new && touch $out`,
+	},
+	"baseline", "baselineName", "module")
+
+// The baseline is written to the source tree, so the rule only has a timestamp as output and is only
+// run when it is requested through the update target of the module.
+var updateJavaWerrorBaseline = pctx.AndroidStaticRule("updateJavaWerrorBaseline",
+	blueprint.RuleParams{
+		Command: `LC_ALL=C sort -u $in > $baseline && touch $out`,
+	},
+	"baseline")
+
+// checkJavaWerror compiles the sources with the javac lint warnings in warnings enabled, and fails if
+// any of them is reported and not in the baseline of the module.  It returns the timestamp of the
+// check.
+func (j *Module) checkJavaWerror(ctx android.ModuleContext, warnings []string,
+	srcFiles, srcJars android.Paths, flags javaBuilderFlags) android.Path {
+
+	deps := append(android.Paths(nil), srcJars...)
+
+	bootClasspath, bootClasspathDeps := javacBootClasspath(ctx, flags)
+	deps = append(deps, bootClasspathDeps...)
+
+	deps = append(deps, flags.classpath...)
+	deps = append(deps, flags.processorPath...)
+
+	processor := "-proc:none"
+	if flags.processor != "" {
+		processor = "-processor " + flags.processor
+	}
+
+	baselineName := proptools.StringDefault(j.properties.Werror_baseline_filename, defaultJavaWerrorBaselineFilename)
+	baselineArg := "/dev/null"
+	var baselineDeps android.Paths
+	if baseline := android.ExistentPathForSource(ctx, ctx.ModuleDir(), baselineName); baseline.Valid() {
+		baselineArg = baseline.String()
+		baselineDeps = append(baselineDeps, baseline.Path())
+	}
+
+	reported := android.PathForModuleOut(ctx, "werror", "warnings.txt")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        javaWerrorWarnings,
+		Description: "java werror warnings",
+		Output:      reported,
+		Inputs:      srcFiles,
+		Implicits:   deps,
+		Args: map[string]string{
+			"javacFlags":      flags.javacFlags,
+			"bootClasspath":   bootClasspath,
+			"classpath":       flags.classpath.FormJavaClassPath("-classpath"),
+			"processorpath":   flags.processorPath.FormJavaClassPath("-processorpath"),
+			"processor":       processor,
+			"srcJars":         strings.Join(srcJars.Strings(), " "),
+			"srcJarDir":       android.PathForModuleOut(ctx, "werror", "srcjars").String(),
+			"outDir":          android.PathForModuleOut(ctx, "werror", "classes").String(),
+			"javaVersion":     flags.javaVersion,
+			"lintWarnings":    strings.Join(warnings, ","),
+			"warningsPattern": strings.Join(warnings, "|"),
+		},
+	})
+
+	timestamp := android.PathForModuleOut(ctx, "werror", "check.timestamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        javaWerrorCheck,
+		Description: "check java werror policies",
+		Output:      timestamp,
+		Input:       reported,
+		Implicits:   baselineDeps,
+		Args: map[string]string{
+			"baseline":     baselineArg,
+			"baselineName": filepath.Join(ctx.ModuleDir(), baselineName),
+			"module":       ctx.ModuleName(),
+		},
+	})
+
+	j.werrorWarnings = reported
+	j.werrorBaseline = filepath.Join(ctx.ModuleDir(), baselineName)
+
+	return timestamp
+}
+
+type javaWerrorBaselineProvider interface {
+	werrorWarningsAndBaseline() (warnings android.Path, baseline string)
+}
+
+func (j *Module) werrorWarningsAndBaseline() (android.Path, string) {
+	return j.werrorWarnings, j.werrorBaseline
+}
+
+var _ javaWerrorBaselineProvider = (*Module)(nil)
+
+func javaWerrorSingletonFactory() android.Singleton {
+	return &javaWerrorSingleton{}
+}
+
+// javaWerrorSingleton creates the rules that update the baselines from the warnings of all the
+// variants of the modules that use them, the <module>-update-werror-baseline targets, which update
+// the baseline of a module, and the update-werror-baselines target, which updates the baselines of
+// all modules.
+type javaWerrorSingleton struct{}
+
+func (s *javaWerrorSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	warnings := make(map[string]android.Paths)
+	moduleBaselines := make(map[string][]string)
+	ctx.VisitAllModules(func(m android.Module) {
+		if j, ok := m.(javaWerrorBaselineProvider); ok && m.Enabled() {
+			if reported, baseline := j.werrorWarningsAndBaseline(); reported != nil {
+				name := ctx.ModuleName(m)
+				warnings[baseline] = append(warnings[baseline], reported)
+				if !android.InList(baseline, moduleBaselines[name]) {
+					moduleBaselines[name] = append(moduleBaselines[name], baseline)
+				}
+			}
+		}
+	})
+
+	if len(warnings) == 0 {
+		return
+	}
+
+	var all android.Paths
+	updates := make(map[string]android.Path)
+	for _, baseline := range android.SortedStringKeys(warnings) {
+		update := android.PathForOutput(ctx, "werror", baseline+".update.timestamp")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        updateJavaWerrorBaseline,
+			Description: "update java werror baseline " + baseline,
+			Inputs:      warnings[baseline],
+			Output:      update,
+			Args: map[string]string{
+				"baseline": baseline,
+			},
+		})
+		updates[baseline] = update
+		all = append(all, update)
+	}
+
+	for _, name := range android.SortedStringKeys(moduleBaselines) {
+		var moduleUpdates android.Paths
+		for _, baseline := range moduleBaselines[name] {
+			moduleUpdates = append(moduleUpdates, updates[baseline])
+		}
+		ctx.Build(pctx, android.BuildParams{
+			Rule:      blueprint.Phony,
+			Output:    android.PathForPhony(ctx, name+"-update-werror-baseline"),
+			Implicits: moduleUpdates,
+		})
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:      blueprint.Phony,
+		Output:    android.PathForPhony(ctx, "update-werror-baselines"),
+		Implicits: all,
+	})
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"reflect"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestJavaWerror(t *testing.T) {
+	bp := `
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`
+
+	fs := map[string][]byte{
+		"vendor/acme/Android.bp": []byte(`
+			java_library {
+				name: "foo",
+				srcs: ["a.java"],
+			}
+
+			java_library {
+				name: "baz",
+				srcs: ["b.java"],
+				werror_baseline_filename: "baz-baseline.txt",
+			}
+
+			java_library {
+				name: "qux",
+				host_supported: true,
+				srcs: ["a.java"],
+				werror_baseline_filename: "qux-baseline.txt",
+			}
+		`),
+		"vendor/acme/a.java":                   nil,
+		"vendor/acme/b.java":                   nil,
+		"vendor/acme/java-werror-baseline.txt": nil,
+	}
+
+	config := testConfig(nil)
+	config.TestProductVariables.JavaWerrorPolicies = []string{
+		"vendor/acme:deprecation,unchecked",
+		"vendor:deprecation",
+	}
+	ctx := testContext(config, bp, fs)
+	run(t, ctx, config)
+
+	foo := ctx.ModuleForTests("foo", "android_common")
+	warnings := foo.Rule("javaWerrorWarnings")
+	if g, w := warnings.Args["lintWarnings"], "deprecation,unchecked"; g != w {
+		t.Errorf("expected foo lintWarnings %q, got %q", w, g)
+	}
+	check := foo.Rule("javaWerrorCheck")
+	if g, w := check.Input.String(), warnings.Output.String(); g != w {
+		t.Errorf("expected foo check input %q, got %q", w, g)
+	}
+	if g, w := check.Args["baseline"], "vendor/acme/java-werror-baseline.txt"; g != w {
+		t.Errorf("expected foo baseline %q, got %q", w, g)
+	}
+	if javac := foo.Rule("javac"); !android.InList(check.Output.String(), javac.Implicits.Strings()) {
+		t.Errorf("expected foo javac implicits %v to contain %q", javac.Implicits, check.Output)
+	}
+
+	baz := ctx.ModuleForTests("baz", "android_common")
+	bazCheck := baz.Rule("javaWerrorCheck")
+	if g, w := bazCheck.Args["baseline"], "/dev/null"; g != w {
+		t.Errorf("expected baz baseline %q, got %q", w, g)
+	}
+	if g, w := bazCheck.Args["baselineName"], "vendor/acme/baz-baseline.txt"; g != w {
+		t.Errorf("expected baz baselineName %q, got %q", w, g)
+	}
+
+	if ctx.ModuleForTests("bar", "android_common").MaybeRule("javaWerrorCheck").Rule != nil {
+		t.Errorf("expected no java werror check for bar")
+	}
+
+	// The baseline is updated from the warnings, not from the check that fails when there are new ones.
+	singleton := ctx.SingletonForTests("java_werror")
+	fooUpdate := singleton.Output("werror/vendor/acme/java-werror-baseline.txt.update.timestamp")
+	if g, w := fooUpdate.Inputs.Strings(), []string{warnings.Output.String()}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected foo baseline update inputs %q, got %q", w, g)
+	}
+	if g, w := fooUpdate.Args["baseline"], "vendor/acme/java-werror-baseline.txt"; g != w {
+		t.Errorf("expected foo baseline update to write %q, got %q", w, g)
+	}
+	fooPhony := singleton.Output("foo-update-werror-baseline")
+	if !android.InList(fooUpdate.Output.String(), fooPhony.Implicits.Strings()) {
+		t.Errorf("expected foo-update-werror-baseline to depend on %q, got %v", fooUpdate.Output, fooPhony.Implicits)
+	}
+	// The host and device variants update the same baseline with a single rule.
+	quxUpdate := singleton.Output("werror/vendor/acme/qux-baseline.txt.update.timestamp")
+	quxWarnings := []string{
+		ctx.ModuleForTests("qux", "android_common").Output("werror/warnings.txt").Output.String(),
+		ctx.ModuleForTests("qux", config.BuildOsCommonVariant).Output("werror/warnings.txt").Output.String(),
+	}
+	if g := quxUpdate.Inputs.Strings(); len(g) != 2 || !android.InList(quxWarnings[0], g) ||
+		!android.InList(quxWarnings[1], g) {
+		t.Errorf("expected qux baseline update inputs %q, got %q", quxWarnings, g)
+	}
+
+	allPhony := singleton.Output("update-werror-baselines")
+	if len(allPhony.Implicits) != 3 {
+		t.Errorf("expected update-werror-baselines to depend on 3 baseline updates, got %v", allPhony.Implicits)
+	}
+}
@@ -79,6 +79,11 @@ func PythonBinaryHostFactory() android.Module {
 	return module.Init()
 }
 
+func (binary *binaryDecorator) install(ctx android.ModuleContext, file android.Path) {
+	ctx.SetProvider(android.MakeClassProvider, "EXECUTABLES")
+	binary.pythonInstaller.install(ctx, file)
+}
+
 func (binary *binaryDecorator) autorun() bool {
 	return BoolDefault(binary.binaryProperties.Autorun, true)
 }
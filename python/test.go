@@ -34,6 +34,10 @@ type TestProperties struct {
 	// the name of the test configuration template (for example "AndroidTestTemplate.xml") that
 	// should be installed with the module.
 	Test_config_template *string `android:"arch_variant"`
+
+	// if set to false, don't generate a test config when test_config is not set and there is no
+	// AndroidTest.xml in the module directory.  Defaults to true.
+	Auto_gen_config *bool
 }
 
 type testDecorator struct {
@@ -50,7 +54,13 @@ func (test *testDecorator) bootstrapperProps() []interface{} {
 
 func (test *testDecorator) install(ctx android.ModuleContext, file android.Path) {
 	test.testConfig = tradefed.AutoGenPythonBinaryHostTestConfig(ctx, test.testProperties.Test_config,
-		test.testProperties.Test_config_template, test.binaryDecorator.binaryProperties.Test_suites)
+		test.testProperties.Test_config_template, test.binaryDecorator.binaryProperties.Test_suites,
+		test.testProperties.Auto_gen_config)
+	ctx.SetProvider(android.TestInfoProvider, android.TestInfo{
+		Config: test.testConfig,
+		Suites: test.binaryDecorator.binaryProperties.Test_suites,
+	})
+	ctx.SetProvider(android.MakeClassProvider, "NATIVE_TESTS")
 
 	test.binaryDecorator.pythonInstaller.dir = "nativetest"
 	test.binaryDecorator.pythonInstaller.dir64 = "nativetest64"
@@ -0,0 +1,99 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for generating the AndroidManifest.xml of the product characteristics overlay of an APK."""
+
+from __future__ import print_function
+
+import argparse
+import re
+import subprocess
+import sys
+from xml.dom import minidom
+
+from manifest import android_ns
+from manifest import write_xml
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--aapt', dest='aapt', required=True,
+                      help='path to aapt2, used to read the package name of the APK')
+  parser.add_argument('input', help='input APK file that is overlaid')
+  parser.add_argument('output', help='output AndroidManifest.xml file')
+  return parser.parse_args()
+
+
+def parse_package_name(badging):
+  """Returns the package name of an APK from the output of aapt2 dump badging.
+
+  Args:
+    badging: the output of aapt2 dump badging for an APK.
+  Raises:
+    RuntimeError: the output has no package line.
+  """
+
+  m = re.search(r"^package: name='([^']*)'", badging, re.MULTILINE)
+  if not m:
+    raise RuntimeError('no package in aapt2 dump badging output')
+  return m.group(1)
+
+
+def characteristics_rro_manifest(target_package):
+  """Returns the manifest of a static runtime resource overlay of a package that contains no code.
+
+  Args:
+    target_package: the package name of the overlaid APK.
+  """
+
+  doc = minidom.getDOMImplementation().createDocument(None, 'manifest', None)
+  manifest = doc.documentElement
+  manifest.setAttributeNS(minidom.XMLNS_NAMESPACE, 'xmlns:android', android_ns)
+  manifest.setAttribute('package', target_package + '.auto_generated_characteristics_rro')
+
+  application = doc.createElement('application')
+  application.setAttributeNS(android_ns, 'android:hasCode', 'false')
+  manifest.appendChild(application)
+
+  overlay = doc.createElement('overlay')
+  overlay.setAttributeNS(android_ns, 'android:targetPackage', target_package)
+  overlay.setAttributeNS(android_ns, 'android:isStatic', 'true')
+  overlay.setAttributeNS(android_ns, 'android:priority', '0')
+  manifest.appendChild(overlay)
+
+  return doc
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    badging = subprocess.check_output([args.aapt, 'dump', 'badging', args.input])
+    doc = characteristics_rro_manifest(parse_package_name(badging))
+
+    with open(args.output, 'wb') as f:
+      write_xml(f, doc)
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,60 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for characteristics_rro_manifest.py."""
+
+import StringIO
+import sys
+import unittest
+
+import characteristics_rro_manifest
+from manifest import write_xml
+
+sys.dont_write_bytecode = True
+
+
+class ParsePackageNameTest(unittest.TestCase):
+  """Unit tests for parse_package_name function."""
+
+  def test_package(self):
+    badging = ("package: name='com.android.foo' versionCode='29' versionName='Q' platformBuildVersionName=''\n"
+               "sdkVersion:'28'\n")
+    self.assertEqual(characteristics_rro_manifest.parse_package_name(badging), 'com.android.foo')
+
+  def test_no_package(self):
+    with self.assertRaises(RuntimeError):
+      characteristics_rro_manifest.parse_package_name("sdkVersion:'28'\n")
+
+
+class CharacteristicsRroManifestTest(unittest.TestCase):
+  """Unit tests for characteristics_rro_manifest function."""
+
+  def test_manifest(self):
+    doc = characteristics_rro_manifest.characteristics_rro_manifest('com.android.foo')
+    output = StringIO.StringIO()
+    write_xml(output, doc)
+
+    expected = ('<?xml version="1.0" encoding="utf-8"?>\n'
+                '<manifest package="com.android.foo.auto_generated_characteristics_rro" '
+                'xmlns:android="http://schemas.android.com/apk/res/android">'
+                '<application android:hasCode="false"/>'
+                '<overlay android:isStatic="true" android:priority="0" android:targetPackage="com.android.foo"/>'
+                '</manifest>\n')
+    self.assertEqual(output.getvalue(), expected)
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
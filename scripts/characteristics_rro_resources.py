@@ -0,0 +1,96 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for extracting the resources of a product characteristics overlay from resource files.
+
+The overlay only needs the resources that have values specific to a product, selected with the
+product attribute:
+
+  <bool name="is_large_screen" product="tablet">true</bool>
+  <bool name="is_large_screen">false</bool>
+
+The values of those resources are kept, including the ones without a product attribute that
+aapt2 uses when no value matches the product, and every other resource is dropped.  The kept
+values files are written to a resource zip, at their path relative to the resource directory.
+"""
+
+from __future__ import print_function
+
+import argparse
+import os
+import sys
+import zipfile
+from xml.dom import minidom
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('-o', dest='output', required=True, help='output resource zip file')
+  parser.add_argument('inputs', nargs='*', help='values resource files, like res/values/strings.xml')
+  return parser.parse_args()
+
+
+def product_specific_resources(doc):
+  """Removes the resources that have no product specific value from a values resource file.
+
+  Args:
+    doc: the parsed values resource file.
+  Returns:
+    True if the document still has resources.
+  """
+
+  resources = doc.documentElement
+  elements = [node for node in resources.childNodes if node.nodeType == minidom.Node.ELEMENT_NODE]
+  names = set((e.tagName, e.getAttribute('name')) for e in elements if e.hasAttribute('product'))
+
+  for node in list(resources.childNodes):
+    if node.nodeType == minidom.Node.ELEMENT_NODE and (node.tagName, node.getAttribute('name')) in names:
+      continue
+    resources.removeChild(node)
+
+  return len(names) > 0
+
+
+def zip_path(path):
+  """Returns the path of a resource file in the resource zip, like values-fr/strings.xml."""
+
+  return os.path.join(os.path.basename(os.path.dirname(path)), os.path.basename(path))
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    with zipfile.ZipFile(args.output, 'w', zipfile.ZIP_DEFLATED) as z:
+      for path in sorted(args.inputs, key=zip_path):
+        doc = minidom.parse(path)
+        if not product_specific_resources(doc):
+          continue
+        # A fixed timestamp keeps the zip the same when the resources don't change.
+        info = zipfile.ZipInfo(zip_path(path), date_time=(2008, 1, 1, 0, 0, 0))
+        z.writestr(info, doc.toxml(encoding='utf-8'))
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+
+if __name__ == '__main__':
+  main()
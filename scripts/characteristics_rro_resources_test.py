@@ -0,0 +1,64 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for characteristics_rro_resources.py."""
+
+import sys
+import unittest
+from xml.dom import minidom
+
+import characteristics_rro_resources
+
+sys.dont_write_bytecode = True
+
+
+class ProductSpecificResourcesTest(unittest.TestCase):
+  """Unit tests for product_specific_resources function."""
+
+  def test_product_specific(self):
+    doc = minidom.parseString(
+        '<resources>'
+        '<bool name="is_large_screen" product="tablet">true</bool>'
+        '<bool name="is_large_screen">false</bool>'
+        '<string name="app_name">Foo</string>'
+        '<integer name="is_large_screen">1</integer>'
+        '</resources>')
+    self.assertTrue(characteristics_rro_resources.product_specific_resources(doc))
+    self.assertEqual(doc.documentElement.toxml(),
+                     '<resources>'
+                     '<bool name="is_large_screen" product="tablet">true</bool>'
+                     '<bool name="is_large_screen">false</bool>'
+                     '</resources>')
+
+  def test_no_product_specific(self):
+    doc = minidom.parseString(
+        '<resources>\n'
+        '  <string name="app_name">Foo</string>\n'
+        '</resources>')
+    self.assertFalse(characteristics_rro_resources.product_specific_resources(doc))
+    self.assertEqual(doc.documentElement.toxml(), '<resources/>')
+
+
+class ZipPathTest(unittest.TestCase):
+  """Unit tests for zip_path function."""
+
+  def test_zip_path(self):
+    self.assertEqual(characteristics_rro_resources.zip_path('foo/res/values-fr/strings.xml'),
+                     'values-fr/strings.xml')
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
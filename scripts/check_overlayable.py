@@ -0,0 +1,145 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for checking that a runtime resource overlay only overlays overlayable resources.
+
+The overlayable resources of the target are read from the resource API files written by
+resource_api.py, the overlaid resources from the files of the overlay resource directories.
+"""
+
+from __future__ import print_function
+
+import argparse
+import os
+import sys
+from xml.dom import minidom
+
+
+# Values elements whose resource type isn't their tag name.
+VALUES_TYPES = {
+    'declare-styleable': 'styleable',
+    'integer-array': 'array',
+    'string-array': 'array',
+}
+
+# Values elements that don't define a resource.
+VALUES_IGNORED = frozenset(['eat-comment', 'skip', 'public', 'public-group', 'overlayable'])
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--target-api', dest='target_apis', action='append', default=[],
+                      help='resource API file of the target listing its overlayable resources')
+  parser.add_argument('--soft', dest='soft', action='store_true',
+                      help='print the overlaid resources that are not overlayable as warnings')
+  parser.add_argument('--output', dest='output', required=True,
+                      help='file to write when the check passes')
+  parser.add_argument('inputs', nargs='*', help='files of the overlay resource directories')
+  return parser.parse_args()
+
+
+def overlayable_resources(lines):
+  """Returns the set of type/name of the overlayable resources in a resource API file.
+
+  Args:
+    lines: the lines of the resource API file, like
+      "overlayable ThemeResources product|system color/accent".
+  """
+
+  overlayable = set()
+  for line in lines:
+    fields = line.split()
+    if len(fields) == 4 and fields[0] == 'overlayable':
+      overlayable.add(fields[3])
+  return overlayable
+
+
+def values_resources(doc):
+  """Returns the type/name of the resources defined by a parsed values file."""
+
+  resources = []
+  root = doc.documentElement
+  if root.tagName != 'resources':
+    return resources
+  for element in root.childNodes:
+    if element.nodeType != minidom.Node.ELEMENT_NODE or element.tagName in VALUES_IGNORED:
+      continue
+    if element.tagName == 'item':
+      res_type = element.getAttribute('type')
+    else:
+      res_type = VALUES_TYPES.get(element.tagName, element.tagName)
+    name = element.getAttribute('name')
+    if not res_type or not name:
+      raise RuntimeError('<%s> must have a type and a name' % element.tagName)
+    resources.append(res_type + '/' + name)
+  return resources
+
+
+def file_resource(path):
+  """Returns the type/name of the resource defined by a file that is not a values file."""
+
+  res_type = os.path.basename(os.path.dirname(path)).split('-')[0]
+  name = os.path.basename(path).split('.')[0]
+  return res_type + '/' + name
+
+
+def is_values_file(path):
+  return (os.path.basename(os.path.dirname(path)).split('-')[0] == 'values' and
+          path.endswith('.xml'))
+
+
+def not_overlayable(overlaid, overlayable):
+  """Returns the (path, resource) pairs of overlaid that are not in overlayable, sorted."""
+
+  return sorted(set((path, res) for path, res in overlaid if res not in overlayable))
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    overlayable = set()
+    for path in args.target_apis:
+      with open(path) as f:
+        overlayable |= overlayable_resources(f.readlines())
+
+    overlaid = []
+    for path in args.inputs:
+      if is_values_file(path):
+        overlaid.extend((path, res) for res in values_resources(minidom.parse(path)))
+      else:
+        overlaid.append((path, file_resource(path)))
+
+    errors = not_overlayable(overlaid, overlayable)
+    severity = 'warning' if args.soft else 'error'
+    for path, res in errors:
+      print('%s: %s: %s is not overlayable by the target' % (path, severity, res), file=sys.stderr)
+    if errors and not args.soft:
+      sys.exit(1)
+
+    with open(args.output, 'w') as f:
+      f.write('')
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
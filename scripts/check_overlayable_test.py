@@ -0,0 +1,85 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for check_overlayable.py."""
+
+import sys
+import unittest
+from xml.dom import minidom
+
+import check_overlayable
+
+sys.dont_write_bytecode = True
+
+
+class OverlayableResourcesTest(unittest.TestCase):
+  """Unit tests for overlayable_resources function."""
+
+  def test_overlayable(self):
+    lines = ['overlayable ThemeResources product|system color/accent\n',
+             'public string/app_name\n',
+             'overlayable ThemeResources public bool/config_dark\n']
+    self.assertEqual(check_overlayable.overlayable_resources(lines),
+                     set(['color/accent', 'bool/config_dark']))
+
+
+class ValuesResourcesTest(unittest.TestCase):
+  """Unit tests for values_resources function."""
+
+  def values_resources(self, xml):
+    return check_overlayable.values_resources(minidom.parseString(xml))
+
+  def test_values(self):
+    xml = ('<resources>'
+           '<color name="accent">#ff0000</color>'
+           '<string-array name="entries"><item>a</item></string-array>'
+           '<item type="dimen" name="margin">4dp</item>'
+           '<eat-comment/>'
+           '</resources>')
+    self.assertEqual(self.values_resources(xml),
+                     ['color/accent', 'array/entries', 'dimen/margin'])
+
+  def test_missing_name(self):
+    with self.assertRaises(RuntimeError):
+      self.values_resources('<resources><color>#ff0000</color></resources>')
+
+
+class FileResourceTest(unittest.TestCase):
+  """Unit tests for file_resource and is_values_file functions."""
+
+  def test_file_resource(self):
+    self.assertEqual(check_overlayable.file_resource('overlay/res/drawable-hdpi/icon.9.png'),
+                     'drawable/icon')
+
+  def test_is_values_file(self):
+    self.assertTrue(check_overlayable.is_values_file('overlay/res/values-en-rUS/strings.xml'))
+    self.assertFalse(check_overlayable.is_values_file('overlay/res/layout/main.xml'))
+
+
+class NotOverlayableTest(unittest.TestCase):
+  """Unit tests for not_overlayable function."""
+
+  def test_not_overlayable(self):
+    overlaid = [('values/colors.xml', 'color/accent'),
+                ('values/strings.xml', 'string/app_name'),
+                ('values-en/strings.xml', 'string/app_name')]
+    self.assertEqual(check_overlayable.not_overlayable(overlaid, set(['color/accent'])),
+                     [('values-en/strings.xml', 'string/app_name'),
+                      ('values/strings.xml', 'string/app_name')])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
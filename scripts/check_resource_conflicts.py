@@ -0,0 +1,223 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for checking that static resource libraries don't define a resource with different values.
+
+aapt2 links the static libraries of a module as overlays, so when two of them define the same
+resource for the same configuration the value of the last one silently wins.  The resources of
+each library package are read from the output of aapt2 dump resources.
+
+The package of a library also contains the resources of its own static libraries, merged as
+overlays when the library was built and checked then.  Only the resources that a library defines
+itself are compared, the ones that have the same value in the package of one of its static
+libraries are inherited from it.  A library that is a static library of another one can have
+different values for the resources that the other one overrides.
+"""
+
+from __future__ import print_function
+
+import argparse
+import re
+import subprocess
+import sys
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--aapt2', dest='aapt2', required=True, help='path to aapt2')
+  parser.add_argument('--allow', dest='allowed', action='append', default=[],
+                      help='type/name of a resource that the libraries may define with different values')
+  parser.add_argument('--output', dest='output', required=True,
+                      help='file to write when the check passes')
+  parser.add_argument('inputs', nargs='*',
+                      help='static library resource packages, as <package>[:<static library package>,...] '
+                      'with the packages of the static libraries merged into it')
+  return parser.parse_args()
+
+
+RESOURCE_RE = re.compile(r'^resource\s+\S+\s+(\S+)')
+PACKAGE_RE = re.compile(r'^Package name=(\S+)')
+VALUE_RE = re.compile(r'^(\([^)]*\))\s*(.*)$')
+
+
+def indent(line):
+  return len(line) - len(line.lstrip())
+
+
+def dumped_resources(lines):
+  """Returns the values of the resources in the output of aapt2 dump resources.
+
+  References to resources of the dumped package are stripped of the package name, the same
+  resource is referenced with the package name of each library that defines it.
+
+  Args:
+    lines: the lines of the output of aapt2 dump resources, like
+        Package name=com.android.foo id=7f
+          type string id=01 entryCount=1
+            resource 0x7f010000 string/app_name
+              () "Foo"
+              (fr) "Foo FR"
+  Returns:
+    A dict of type/name to a dict of configuration to value.
+  """
+
+  resources = {}
+  package = None
+  res = None
+  res_indent = 0
+  config = None
+  config_indent = 0
+  for line in lines:
+    line = line.rstrip('\n')
+    stripped = line.strip()
+    if not stripped:
+      continue
+
+    match = PACKAGE_RE.match(stripped)
+    if match:
+      package = match.group(1)
+      res = None
+      continue
+
+    match = RESOURCE_RE.match(stripped)
+    if match:
+      res = match.group(1)
+      res_indent = indent(line)
+      resources.setdefault(res, {})
+      config = None
+      continue
+
+    if res is None or indent(line) <= res_indent:
+      res = None
+      continue
+
+    if package:
+      stripped = stripped.replace('@' + package + ':', '@').replace('?' + package + ':', '?')
+
+    match = VALUE_RE.match(stripped)
+    if match and (config is None or indent(line) <= config_indent):
+      config = match.group(1)
+      config_indent = indent(line)
+      resources[res][config] = match.group(2)
+    elif config is not None:
+      # A line of a complex value, like an item of a style.
+      resources[res][config] += '\n' + stripped
+
+  return resources
+
+
+def parse_library(arg):
+  """Returns the (package path, [static library package paths]) of an input argument."""
+
+  split = arg.split(':', 1)
+  if len(split) == 1 or not split[1]:
+    return split[0], []
+  return split[0], split[1].split(',')
+
+
+def own_resources(resources, static_resources):
+  """Returns the resources of a package without the values inherited from its static libraries.
+
+  Args:
+    resources: the resources of the package, as returned by dumped_resources.
+    static_resources: a list of the resources of the static libraries merged into the package.
+  Returns:
+    A dict of type/name to a dict of configuration to value.
+  """
+
+  ret = {}
+  for res, values in resources.items():
+    for config, value in values.items():
+      if any(static.get(res, {}).get(config) == value for static in static_resources):
+        continue
+      ret.setdefault(res, {})[config] = value
+  return ret
+
+
+def conflicts(packages, allowed):
+  """Returns the resources that the packages define with different values.
+
+  Args:
+    packages: a list of (package path, resources, set of static library package paths) tuples,
+      the resources as returned by own_resources.
+    allowed: a set of type/name of resources that may be defined with different values.
+  Returns:
+    A sorted list of (type/name, configuration, [(package path, value)]) tuples.
+  """
+
+  definitions = {}
+  for path, resources, static_libs in packages:
+    for res, values in resources.items():
+      if res in allowed:
+        continue
+      for config, value in values.items():
+        definitions.setdefault((res, config), []).append((path, value, static_libs))
+
+  ret = []
+  for (res, config), defs in sorted(definitions.items()):
+    # A package overrides the values of its static libraries.
+    conflicting = [(path, value) for path, value, static_libs in defs
+                   if any(value != other_value and other_path not in static_libs and
+                          path not in other_static_libs
+                          for other_path, other_value, other_static_libs in defs)]
+    if conflicting:
+      ret.append((res, config, conflicting))
+  return ret
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    dumps = {}
+    def dump(path):
+      if path not in dumps:
+        output = subprocess.check_output([args.aapt2, 'dump', 'resources', path])
+        dumps[path] = dumped_resources(output.decode('utf-8').splitlines())
+      return dumps[path]
+
+    packages = []
+    for arg in args.inputs:
+      path, static_libs = parse_library(arg)
+      resources = own_resources(dump(path), [dump(static_lib) for static_lib in static_libs])
+      packages.append((path, resources, set(static_libs)))
+
+    errors = conflicts(packages, set(args.allowed))
+    for res, config, defs in errors:
+      print('error: %s %s is defined with different values by static libraries:' % (res, config),
+            file=sys.stderr)
+      for path, value in defs:
+        print('  %s: %s' % (path, value), file=sys.stderr)
+    if errors:
+      print('Remove the duplicate definitions, or add the resources to the '
+            'allowed_resource_conflicts property if the order of the static libraries is intended '
+            'to pick the value.', file=sys.stderr)
+      sys.exit(1)
+
+    with open(args.output, 'w') as f:
+      f.write('')
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,109 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for check_resource_conflicts.py."""
+
+import sys
+import unittest
+
+import check_resource_conflicts
+
+sys.dont_write_bytecode = True
+
+
+class DumpedResourcesTest(unittest.TestCase):
+  """Unit tests for dumped_resources function."""
+
+  def test_dump(self):
+    lines = ['Proto APK\n',
+             'Package name=com.android.foo id=7f\n',
+             '  type string id=01 entryCount=2\n',
+             '    resource 0x7f010000 string/app_name\n',
+             '      () "Foo"\n',
+             '      (fr) "Foo FR"\n',
+             '    resource 0x7f010001 string/title PUBLIC\n',
+             '      () @com.android.foo:string/app_name\n',
+             '  type style id=02 entryCount=1\n',
+             '    resource 0x7f020000 style/Theme\n',
+             '      () (style) parent=@android:style/Theme\n',
+             '        android:colorAccent(0x01010435)=?com.android.foo:attr/accent\n']
+    self.assertEqual(check_resource_conflicts.dumped_resources(lines), {
+        'string/app_name': {'()': '"Foo"', '(fr)': '"Foo FR"'},
+        'string/title': {'()': '@string/app_name'},
+        'style/Theme': {'()': '(style) parent=@android:style/Theme\n'
+                              'android:colorAccent(0x01010435)=?attr/accent'},
+    })
+
+
+class ParseLibraryTest(unittest.TestCase):
+  """Unit tests for parse_library function."""
+
+  def test_parse_library(self):
+    self.assertEqual(check_resource_conflicts.parse_library('a.apk'), ('a.apk', []))
+    self.assertEqual(check_resource_conflicts.parse_library('a.apk:'), ('a.apk', []))
+    self.assertEqual(check_resource_conflicts.parse_library('a.apk:b.apk,c.apk'),
+                     ('a.apk', ['b.apk', 'c.apk']))
+
+
+class OwnResourcesTest(unittest.TestCase):
+  """Unit tests for own_resources function."""
+
+  def test_own_resources(self):
+    resources = {'string/app_name': {'()': '"A"', '(fr)': '"B FR"'},
+                 'color/accent': {'()': '#00ff00'}}
+    static_resources = [{'string/app_name': {'()': '"B"', '(fr)': '"B FR"'}},
+                        {'color/accent': {'()': '#00ff00'}}]
+    self.assertEqual(check_resource_conflicts.own_resources(resources, static_resources), {
+        'string/app_name': {'()': '"A"'},
+    })
+
+
+class ConflictsTest(unittest.TestCase):
+  """Unit tests for conflicts function."""
+
+  packages = [
+      ('a.apk', {'string/app_name': {'()': '"A"', '(fr)': '"A FR"'},
+                 'color/accent': {'()': '#ff0000'}}, set()),
+      ('b.apk', {'string/app_name': {'()': '"B"', '(fr)': '"A FR"'},
+                 'color/accent': {'()': '#ff0000'},
+                 'bool/enabled': {'()': 'true'}}, set()),
+      ('c.apk', {'bool/enabled': {'()': 'false'}}, set()),
+  ]
+
+  def test_conflicts(self):
+    self.assertEqual(check_resource_conflicts.conflicts(self.packages, set()), [
+        ('bool/enabled', '()', [('b.apk', 'true'), ('c.apk', 'false')]),
+        ('string/app_name', '()', [('a.apk', '"A"'), ('b.apk', '"B"')]),
+    ])
+
+  def test_allowed(self):
+    self.assertEqual(
+        check_resource_conflicts.conflicts(self.packages, set(['bool/enabled', 'string/app_name'])),
+        [])
+
+  def test_override(self):
+    packages = [
+        ('a.apk', {'bool/enabled': {'()': 'true'}}, set(['c.apk'])),
+        ('b.apk', {'bool/enabled': {'()': 'true'}}, set()),
+        ('c.apk', {'bool/enabled': {'()': 'false'}}, set()),
+    ]
+    self.assertEqual(check_resource_conflicts.conflicts(packages, set()), [
+        ('bool/enabled', '()', [('b.apk', 'true'), ('c.apk', 'false')]),
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,104 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for generating the AndroidManifest.xml of a config split of an APK, for example an ABI split."""
+
+from __future__ import print_function
+
+import argparse
+import re
+import subprocess
+import sys
+from xml.dom import minidom
+
+from manifest import android_ns
+from manifest import write_xml
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--aapt', dest='aapt', required=True,
+                      help='path to aapt2, used to read the package name and version code of the base APK')
+  parser.add_argument('--split', dest='split', required=True,
+                      help='name of the config split, for example config.arm64_v8a')
+  parser.add_argument('input', help='input base APK file')
+  parser.add_argument('output', help='output AndroidManifest.xml file')
+  return parser.parse_args()
+
+
+def parse_package_badging(badging):
+  """Returns the package name and version code of an APK from the output of aapt2 dump badging.
+
+  Args:
+    badging: the output of aapt2 dump badging for an APK.
+  Raises:
+    RuntimeError: the output has no package line.
+  """
+
+  m = re.search(r"^package: name='([^']*)' versionCode='([^']*)'", badging, re.MULTILINE)
+  if not m:
+    raise RuntimeError('no package in aapt2 dump badging output')
+  return m.group(1), m.group(2)
+
+
+def config_split_manifest(package, version_code, split):
+  """Returns the manifest of a config split that contains no code.
+
+  A split must have the same package name and version code as the base APK to be installed together with it.
+
+  Args:
+    package: the package name of the base APK.
+    version_code: the version code of the base APK, or '' if it has none.
+    split: the name of the split.
+  """
+
+  doc = minidom.getDOMImplementation().createDocument(None, 'manifest', None)
+  manifest = doc.documentElement
+  manifest.setAttributeNS(minidom.XMLNS_NAMESPACE, 'xmlns:android', android_ns)
+  manifest.setAttribute('package', package)
+  if version_code:
+    manifest.setAttributeNS(android_ns, 'android:versionCode', version_code)
+  manifest.setAttribute('split', split)
+
+  application = doc.createElement('application')
+  application.setAttributeNS(android_ns, 'android:hasCode', 'false')
+  manifest.appendChild(application)
+
+  return doc
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    badging = subprocess.check_output([args.aapt, 'dump', 'badging', args.input])
+    package, version_code = parse_package_badging(badging)
+
+    doc = config_split_manifest(package, version_code, args.split)
+
+    with open(args.output, 'wb') as f:
+      write_xml(f, doc)
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,71 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for config_split_manifest.py."""
+
+import StringIO
+import sys
+import unittest
+
+import config_split_manifest
+from manifest import write_xml
+
+sys.dont_write_bytecode = True
+
+
+class ParsePackageBadgingTest(unittest.TestCase):
+  """Unit tests for parse_package_badging function."""
+
+  def test_package(self):
+    badging = ("package: name='com.android.foo' versionCode='29' versionName='Q' platformBuildVersionName=''\n"
+               "sdkVersion:'28'\n")
+    self.assertEqual(config_split_manifest.parse_package_badging(badging), ('com.android.foo', '29'))
+
+  def test_no_version_code(self):
+    badging = "package: name='com.android.foo' versionCode='' versionName=''\n"
+    self.assertEqual(config_split_manifest.parse_package_badging(badging), ('com.android.foo', ''))
+
+  def test_no_package(self):
+    with self.assertRaises(RuntimeError):
+      config_split_manifest.parse_package_badging("sdkVersion:'28'\n")
+
+
+class ConfigSplitManifestTest(unittest.TestCase):
+  """Unit tests for config_split_manifest function."""
+
+  def manifest(self, package, version_code, split):
+    doc = config_split_manifest.config_split_manifest(package, version_code, split)
+    output = StringIO.StringIO()
+    write_xml(output, doc)
+    return output.getvalue()
+
+  def test_split(self):
+    expected = ('<?xml version="1.0" encoding="utf-8"?>\n'
+                '<manifest android:versionCode="29" package="com.android.foo" split="config.arm64_v8a" '
+                'xmlns:android="http://schemas.android.com/apk/res/android">'
+                '<application android:hasCode="false"/></manifest>\n')
+    self.assertEqual(self.manifest('com.android.foo', '29', 'config.arm64_v8a'), expected)
+
+  def test_no_version_code(self):
+    expected = ('<?xml version="1.0" encoding="utf-8"?>\n'
+                '<manifest package="com.android.foo" split="config.x86" '
+                'xmlns:android="http://schemas.android.com/apk/res/android">'
+                '<application android:hasCode="false"/></manifest>\n')
+    self.assertEqual(self.manifest('com.android.foo', '', 'config.x86'), expected)
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,127 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for constructing class loader context for dex2oat from the targetSdkVersion of a module."""
+
+from __future__ import print_function
+
+import argparse
+import sys
+
+
+# The SDK version of class loader contexts that are used regardless of the targetSdkVersion.
+ANY_SDK = 'any'
+
+# targetSdkVersion of modules that target a codename instead of a released SDK.
+FUTURE_SDK = 10000
+
+
+class ClassLoaderContextError(Exception):
+  pass
+
+
+def parse_args(args):
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--target-sdk-version', default='', dest='sdk',
+                      help='targetSdkVersion of the module')
+  parser.add_argument('--uses-libraries-file', dest='uses_libraries_file',
+                      help='file listing the <uses-library> names of the module, one per line; only the '
+                      'libraries in the file are used from the contexts for any SDK version')
+  parser.add_argument('--host-context-for-sdk', dest='host_contexts', action='append', nargs=3,
+                      default=[], metavar=('SDK', 'LIBRARY', 'CONTEXT'),
+                      help='class loader context of a library using host paths, used if the '
+                      'targetSdkVersion is lower than SDK or if SDK is "any"')
+  parser.add_argument('--target-context-for-sdk', dest='target_contexts', action='append', nargs=3,
+                      default=[], metavar=('SDK', 'LIBRARY', 'CONTEXT'),
+                      help='class loader context of a library using device paths, used if the '
+                      'targetSdkVersion is lower than SDK or if SDK is "any"')
+  return parser.parse_args(args)
+
+
+def sdk_version(sdk):
+  """Returns a targetSdkVersion as an integer, codenames are newer than any released SDK."""
+
+  try:
+    return int(sdk)
+  except ValueError:
+    return FUTURE_SDK
+
+
+def construct_context(contexts, sdk, uses_libraries):
+  """Returns the class loader context that applies to a targetSdkVersion.
+
+  Args:
+    contexts: a list of [sdk, library, context] entries.
+    sdk: the targetSdkVersion of the module.
+    uses_libraries: the <uses-library> names of the module, or None if all the libraries in the
+        contexts for any SDK version are used.
+  Raises:
+    ClassLoaderContextError: a library in uses_libraries has no context
+  """
+
+  libs = [(lib, ctx) for (ver, lib, ctx) in contexts if ver == ANY_SDK]
+  if uses_libraries is not None:
+    known = dict(libs)
+    unknown = [lib for lib in uses_libraries if lib not in known]
+    if unknown:
+      raise ClassLoaderContextError('no class loader context for <uses-library> "%s"' %
+                                    '", "'.join(unknown))
+    libs = [(lib, known[lib]) for lib in uses_libraries]
+
+  used = [lib for (lib, _) in libs]
+
+  # Compatibility libraries for older SDKs are only added if the module does not use the library
+  # explicitly, and they come before the explicit shared libraries.
+  compat = []
+  for (ver, lib, ctx) in sorted([c for c in contexts if c[0] != ANY_SDK], key=lambda c: int(c[0])):
+    if sdk_version(sdk) < int(ver) and lib not in used:
+      compat.append(ctx)
+
+  context = compat + [ctx for (_, ctx) in libs]
+  if not context:
+    return 'PCL[]'
+  return 'PCL[]{%s}' % '#'.join(context)
+
+
+def read_uses_libraries(path):
+  with open(path) as f:
+    return [line.strip() for line in f if line.strip()]
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args(sys.argv[1:])
+
+    uses_libraries = None
+    if args.uses_libraries_file:
+      uses_libraries = read_uses_libraries(args.uses_libraries_file)
+
+    host_context = construct_context(args.host_contexts, args.sdk, uses_libraries)
+    target_context = construct_context(args.target_contexts, args.sdk, uses_libraries)
+
+    print("class_loader_context_arg='--class-loader-context=%s'" % host_context)
+    print("stored_class_loader_context_arg='--stored-class-loader-context=%s'" % target_context)
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
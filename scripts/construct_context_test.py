@@ -0,0 +1,87 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for construct_context.py."""
+
+import sys
+import unittest
+
+import construct_context as cc
+
+sys.dont_write_bytecode = True
+
+
+class ConstructContextTest(unittest.TestCase):
+  """Unit tests for construct_context function."""
+
+  contexts = [
+      ['28', 'org.apache.http.legacy', 'PCL[/system/framework/org.apache.http.legacy.impl.jar]'],
+      ['29', 'android.hidl.manager-V1.0-java', 'PCL[/system/framework/android.hidl.manager-V1.0-java.jar]'],
+      ['any', 'foo', 'PCL[/system/framework/foo.jar]{PCL[/system/framework/bar.jar]}'],
+      ['any', 'baz', 'PCL[/system/framework/baz.jar]'],
+  ]
+
+  def test_old_sdk(self):
+    self.assertEqual(
+        cc.construct_context(self.contexts, '27', None),
+        'PCL[]{PCL[/system/framework/org.apache.http.legacy.impl.jar]'
+        '#PCL[/system/framework/android.hidl.manager-V1.0-java.jar]'
+        '#PCL[/system/framework/foo.jar]{PCL[/system/framework/bar.jar]}'
+        '#PCL[/system/framework/baz.jar]}')
+
+  def test_between_sdks(self):
+    self.assertEqual(
+        cc.construct_context(self.contexts, '28', None),
+        'PCL[]{PCL[/system/framework/android.hidl.manager-V1.0-java.jar]'
+        '#PCL[/system/framework/foo.jar]{PCL[/system/framework/bar.jar]}'
+        '#PCL[/system/framework/baz.jar]}')
+
+  def test_new_sdk(self):
+    self.assertEqual(
+        cc.construct_context(self.contexts, '29', None),
+        'PCL[]{PCL[/system/framework/foo.jar]{PCL[/system/framework/bar.jar]}'
+        '#PCL[/system/framework/baz.jar]}')
+
+  def test_codename(self):
+    self.assertEqual(
+        cc.construct_context(self.contexts, 'R', None),
+        'PCL[]{PCL[/system/framework/foo.jar]{PCL[/system/framework/bar.jar]}'
+        '#PCL[/system/framework/baz.jar]}')
+
+  def test_empty(self):
+    self.assertEqual(cc.construct_context([], '27', None), 'PCL[]')
+
+  def test_uses_libraries(self):
+    self.assertEqual(
+        cc.construct_context(self.contexts, '29', ['baz']),
+        'PCL[]{PCL[/system/framework/baz.jar]}')
+
+  def test_uses_libraries_skips_compat(self):
+    contexts = self.contexts + [
+        ['any', 'org.apache.http.legacy', 'PCL[/system/framework/org.apache.http.legacy.jar]'],
+    ]
+    self.assertEqual(
+        cc.construct_context(contexts, '27', ['org.apache.http.legacy']),
+        'PCL[]{PCL[/system/framework/android.hidl.manager-V1.0-java.jar]'
+        '#PCL[/system/framework/org.apache.http.legacy.jar]}')
+
+  def test_unknown_uses_library(self):
+    with self.assertRaises(cc.ClassLoaderContextError):
+      cc.construct_context(self.contexts, '29', ['qux'])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,251 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for reporting the desugaring of default and static interface methods of an app.
+
+D8 and R8 desugar the default and static interface methods for API levels below 24.  The report
+lists the interface methods of the app that are desugared, which are read from the class files of
+the jar that was dexed.  Desugaring also needs the interfaces of the desugared classes.  When an
+interface isn't in the app or on its classpath the dexer only prints a warning and assumes that it
+has no default methods, and the desugared classes fail at runtime.  These warnings are collected
+from the output of the dexer into the report, and with --error-on-missing-classes they fail the
+build.
+"""
+
+from __future__ import print_function
+
+import argparse
+import re
+import struct
+import sys
+import zipfile
+
+
+# The first API level that supports default and static interface methods natively.
+NATIVE_INTERFACE_METHODS_MIN_SDK_VERSION = 24
+
+# The warnings of D8 and R8 about the classes that desugaring needed, with the needed class as the
+# first group and the desugared class or method as the second one.
+MISSING_CLASS_WARNINGS = [
+    re.compile(r'Type `([^`]+)` was not found, it is required for default or static interface '
+               r'methods desugaring of `([^`]+)`'),
+    re.compile(r'Interface `([^`]+)` not found\. It\'s needed to make sure desugaring of `([^`]+)` '
+               r'is correct\.'),
+]
+
+
+# The access flags of classes and methods in class files.
+ACC_STATIC = 0x0008
+ACC_INTERFACE = 0x0200
+ACC_ABSTRACT = 0x0400
+
+# The sizes of the entries of the constant pool of class files by tag, without the tag.  Utf8
+# entries (tag 1) have a variable size.
+CONSTANT_POOL_ENTRY_SIZES = {
+    3: 4, 4: 4, 5: 8, 6: 8, 7: 2, 8: 2, 9: 4, 10: 4, 11: 4, 12: 4, 15: 3, 16: 2, 17: 4, 18: 4,
+    19: 2, 20: 2,
+}
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--min-sdk-version', dest='min_sdk_version', required=True, type=int,
+                      help='min_sdk_version the app was dexed for')
+  parser.add_argument('--classes', dest='classes', required=True,
+                      help='jar containing the classes that were dexed')
+  parser.add_argument('--log', dest='log', required=True,
+                      help='file containing the warnings of d8 or r8')
+  parser.add_argument('--error-on-missing-classes', dest='error_on_missing_classes',
+                      action='store_true',
+                      help='fail when desugaring needed classes that weren\'t available')
+  parser.add_argument('--output', dest='output', required=True,
+                      help='file to write the report to')
+  return parser.parse_args()
+
+
+def interface_methods(data):
+  """Returns the default and static methods of a class file if it is an interface.
+
+  Args:
+    data: the contents of the class file.
+
+  Returns:
+    The sorted (method, kind) pairs of the default and static methods, with the method named as
+    <interface>.<name><descriptor> and kind either 'default' or 'static'.
+  """
+
+  class Reader(object):
+    """Reads the big endian values of a class file."""
+
+    def __init__(self, data):
+      self.data = data
+      self.pos = 0
+
+    def skip(self, size):
+      self.pos += size
+
+    def u2(self):
+      value, = struct.unpack_from('>H', self.data, self.pos)
+      self.pos += 2
+      return value
+
+    def u4(self):
+      value, = struct.unpack_from('>I', self.data, self.pos)
+      self.pos += 4
+      return value
+
+    def skip_attributes(self):
+      for _ in range(self.u2()):
+        self.skip(2)
+        self.skip(self.u4())
+
+  reader = Reader(data)
+  if reader.u4() != 0xCAFEBABE:
+    raise ValueError('not a class file')
+  reader.skip(4)
+
+  strings = {}
+  class_names = {}
+  index = 1
+  count = reader.u2()
+  while index < count:
+    tag = ord(data[reader.pos:reader.pos + 1])
+    reader.skip(1)
+    if tag == 1:
+      size = reader.u2()
+      strings[index] = data[reader.pos:reader.pos + size].decode('utf-8', 'replace')
+      reader.skip(size)
+    elif tag == 7:
+      class_names[index] = reader.u2()
+    elif tag in CONSTANT_POOL_ENTRY_SIZES:
+      reader.skip(CONSTANT_POOL_ENTRY_SIZES[tag])
+    else:
+      raise ValueError('unknown constant pool tag %d' % tag)
+    # Long and double entries take two indexes of the constant pool.
+    index += 2 if tag in (5, 6) else 1
+
+  access_flags = reader.u2()
+  if not access_flags & ACC_INTERFACE:
+    return []
+  name = strings[class_names[reader.u2()]].replace('/', '.')
+  reader.skip(2)
+  reader.skip(2 * reader.u2())
+
+  for _ in range(reader.u2()):
+    reader.skip(6)
+    reader.skip_attributes()
+
+  methods = []
+  for _ in range(reader.u2()):
+    method_flags = reader.u2()
+    method_name = strings[reader.u2()]
+    descriptor = strings[reader.u2()]
+    reader.skip_attributes()
+    if method_flags & ACC_ABSTRACT or method_name == '<clinit>':
+      continue
+    kind = 'static' if method_flags & ACC_STATIC else 'default'
+    methods.append(('%s.%s%s' % (name, method_name, descriptor), kind))
+  return sorted(methods)
+
+
+def desugared_methods(classes_jar):
+  """Returns the sorted (method, kind) pairs of the interface methods in a jar that are desugared."""
+
+  methods = []
+  with zipfile.ZipFile(classes_jar) as jar:
+    for entry in jar.namelist():
+      if entry.endswith('.class'):
+        methods.extend(interface_methods(jar.read(entry)))
+  return sorted(methods)
+
+
+def missing_classes(lines):
+  """Returns the sorted (desugared, needed) pairs of the missing class warnings of the dexer.
+
+  Args:
+    lines: the lines of the output of d8 or r8.
+  """
+
+  missing = set()
+  for line in lines:
+    for warning in MISSING_CLASS_WARNINGS:
+      match = warning.search(line)
+      if match:
+        missing.add((match.group(2), match.group(1)))
+        break
+  return sorted(missing)
+
+
+def report(min_sdk_version, desugared, missing):
+  """Returns the lines of the desugar report.
+
+  Args:
+    min_sdk_version: the min_sdk_version the app was dexed for.
+    desugared: the (method, kind) pairs of the interface methods of the app.
+    missing: the (desugared, needed) pairs of the missing class warnings of the dexer.
+  """
+
+  lines = ['# Desugaring of default and static interface methods for min_sdk_version %d' %
+           min_sdk_version]
+  if min_sdk_version >= NATIVE_INTERFACE_METHODS_MIN_SDK_VERSION:
+    lines.append('# Not needed, API level %d supports them natively' %
+                 NATIVE_INTERFACE_METHODS_MIN_SDK_VERSION)
+    return lines
+  if desugared:
+    lines.append('# Desugared interface methods')
+    for method, kind in desugared:
+      lines.append('%s %s' % (kind, method))
+  if missing:
+    lines.append('# Missing classes')
+    for desugared_class, needed in missing:
+      lines.append('%s needs %s' % (desugared_class, needed))
+  return lines
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    desugared = []
+    missing = []
+    if args.min_sdk_version < NATIVE_INTERFACE_METHODS_MIN_SDK_VERSION:
+      desugared = desugared_methods(args.classes)
+      with open(args.log) as f:
+        missing = missing_classes(f.readlines())
+
+    with open(args.output, 'w') as f:
+      f.write('\n'.join(report(args.min_sdk_version, desugared, missing)) + '\n')
+
+    if missing:
+      level = 'error' if args.error_on_missing_classes else 'warning'
+      print('%s: desugaring for min_sdk_version %d needs classes that are neither in the app nor '
+            'on its classpath, the desugared classes would fail at runtime:' %
+            (level, args.min_sdk_version), file=sys.stderr)
+      for desugared_class, needed in missing:
+        print('  %s needs %s' % (desugared_class, needed), file=sys.stderr)
+      if args.error_on_missing_classes:
+        sys.exit(1)
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,134 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for desugar_report.py."""
+
+import struct
+import sys
+import unittest
+
+import desugar_report
+
+sys.dont_write_bytecode = True
+
+
+def class_file(name, access_flags, methods):
+  """Returns a minimal class file with the given (access_flags, name, descriptor) methods."""
+
+  pool = []
+
+  def utf8(value):
+    pool.append(struct.pack('>BH', 1, len(value)) + value.encode('utf-8'))
+    return len(pool)
+
+  this_class = utf8(name)
+  pool.append(struct.pack('>BH', 7, this_class))
+  this_class = len(pool)
+  # A long entry takes two indexes of the constant pool.
+  pool.append(struct.pack('>BQ', 5, 0))
+  pool.append(b'')
+  super_class = utf8('java/lang/Object')
+  pool.append(struct.pack('>BH', 7, super_class))
+  super_class = len(pool)
+
+  method_entries = []
+  for method_flags, method_name, descriptor in methods:
+    method_entries.append(struct.pack('>HHHH', method_flags, utf8(method_name), utf8(descriptor), 0))
+
+  return (struct.pack('>IHHH', 0xCAFEBABE, 0, 52, len(pool) + 1) + b''.join(pool) +
+          struct.pack('>HHHHH', access_flags, this_class, super_class, 0, 0) +
+          struct.pack('>H', len(method_entries)) + b''.join(method_entries) +
+          struct.pack('>H', 0))
+
+
+class InterfaceMethodsTest(unittest.TestCase):
+  """Unit tests for interface_methods function."""
+
+  def test_interface(self):
+    data = class_file('com/foo/Callback', 0x0601, [
+        (0x0401, 'onResult', '(I)V'),
+        (0x0001, 'onError', '()V'),
+        (0x0009, 'create', '()Lcom/foo/Callback;'),
+        (0x0008, '<clinit>', '()V'),
+    ])
+    self.assertEqual(desugar_report.interface_methods(data), [
+        ('com.foo.Callback.create()Lcom/foo/Callback;', 'static'),
+        ('com.foo.Callback.onError()V', 'default'),
+    ])
+
+  def test_class(self):
+    data = class_file('com/foo/Bar', 0x0021, [(0x0001, 'run', '()V')])
+    self.assertEqual(desugar_report.interface_methods(data), [])
+
+  def test_not_a_class_file(self):
+    with self.assertRaises(ValueError):
+      desugar_report.interface_methods(b'PK\x03\x04')
+
+
+class MissingClassesTest(unittest.TestCase):
+  """Unit tests for missing_classes function."""
+
+  def test_missing_classes(self):
+    lines = [
+        'Warning in out/classes.jar:\n',
+        '  Type `java.util.function.Function` was not found, it is required for default or static '
+        'interface methods desugaring of `void com.foo.Bar.apply(java.lang.Object)`\n',
+        'Warning in out/classes.jar:\n',
+        '  Interface `com.lib.Callback` not found. It\'s needed to make sure desugaring of '
+        '`com.foo.Baz` is correct. Desugaring will assume that this interface has no default '
+        'method.\n',
+        'Warning: some other warning\n',
+    ]
+    self.assertEqual(desugar_report.missing_classes(lines), [
+        ('com.foo.Baz', 'com.lib.Callback'),
+        ('void com.foo.Bar.apply(java.lang.Object)', 'java.util.function.Function'),
+    ])
+
+  def test_duplicates(self):
+    line = ('Type `a.B` was not found, it is required for default or static interface methods '
+            'desugaring of `c.D`\n')
+    self.assertEqual(desugar_report.missing_classes([line, line]), [('c.D', 'a.B')])
+
+  def test_no_warnings(self):
+    self.assertEqual(desugar_report.missing_classes(['Warning: some other warning\n']), [])
+
+
+class ReportTest(unittest.TestCase):
+  """Unit tests for report function."""
+
+  def test_report(self):
+    self.assertEqual(desugar_report.report(21, [('a.B.c()V', 'default')], [('c.D', 'a.B')]), [
+        '# Desugaring of default and static interface methods for min_sdk_version 21',
+        '# Desugared interface methods',
+        'default a.B.c()V',
+        '# Missing classes',
+        'c.D needs a.B',
+    ])
+
+  def test_nothing_desugared(self):
+    self.assertEqual(desugar_report.report(21, [], []), [
+        '# Desugaring of default and static interface methods for min_sdk_version 21',
+    ])
+
+  def test_native(self):
+    self.assertEqual(desugar_report.report(24, [], []), [
+        '# Desugaring of default and static interface methods for min_sdk_version 24',
+        '# Not needed, API level 24 supports them natively',
+    ])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
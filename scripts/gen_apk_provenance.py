@@ -0,0 +1,159 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for generating the provenance statement of an installed APK.
+
+The statement is an in-toto statement with a SLSA provenance predicate.  Its
+subject is the final, signed APK as it is installed on the device, its
+materials are the inputs the APK was built from, and its build config records
+the fingerprints of the certificates the APK is signed with and the digests of
+the tools that built it.
+"""
+
+from __future__ import print_function
+
+import argparse
+import base64
+import hashlib
+import json
+
+
+STATEMENT_TYPE = 'https://in-toto.io/Statement/v0.1'
+PREDICATE_TYPE = 'https://slsa.dev/provenance/v0.2'
+BUILD_TYPE = 'https://source.android.com/soong/apk@v1'
+BUILDER_ID = 'https://source.android.com/soong'
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--name', dest='name', required=True,
+                      help='name of the module that built the APK.')
+  parser.add_argument('--apk', dest='apk', required=True,
+                      help='the final APK.')
+  parser.add_argument('--install_path', dest='install_path', required=True,
+                      help='path of the APK on the device.')
+  parser.add_argument('--input', dest='inputs', action='append', default=[],
+                      help='input the APK was built from.')
+  parser.add_argument('--certificate', dest='certificates', action='append', default=[],
+                      help='x509 PEM certificate the APK is signed with.')
+  parser.add_argument('--presigned', dest='presigned', action='store_true',
+                      help='the APK is signed outside the build.')
+  parser.add_argument('--tool', dest='tools', action='append', default=[],
+                      help='<name>=<path> of a tool used to build the APK.')
+  parser.add_argument('--out', dest='out', required=True,
+                      help='file to which the statement will be written.')
+  return parser.parse_args()
+
+
+def file_digest(path):
+  """Returns the hex encoded SHA-256 digest of a file."""
+
+  sha256 = hashlib.sha256()
+  with open(path, 'rb') as f:
+    for chunk in iter(lambda: f.read(1024 * 1024), b''):
+      sha256.update(chunk)
+  return sha256.hexdigest()
+
+
+def certificate_fingerprint(pem):
+  """Returns the hex encoded SHA-256 fingerprint of the DER encoding of a PEM certificate.
+
+  Args:
+    pem: contents of an x509 PEM file.
+  """
+
+  lines = pem.strip().splitlines()
+  try:
+    begin = lines.index('-----BEGIN CERTIFICATE-----')
+    end = lines.index('-----END CERTIFICATE-----', begin)
+  except ValueError:
+    raise RuntimeError('not a PEM certificate')
+  der = base64.b64decode(''.join(lines[begin + 1:end]))
+  return hashlib.sha256(der).hexdigest()
+
+
+def provenance_statement(name, install_path, apk_digest, inputs, certificates,
+                         presigned, tools):
+  """Returns the provenance statement of an APK.
+
+  Args:
+    name: name of the module that built the APK.
+    install_path: path of the APK on the device.
+    apk_digest: SHA-256 digest of the final APK.
+    inputs: list of (path, digest) tuples of the inputs of the APK.
+    certificates: list of (path, fingerprint) tuples of the signing certificates.
+    presigned: whether the APK is signed outside the build.
+    tools: list of (name, digest) tuples of the tools used to build the APK.
+  """
+
+  return {
+      '_type': STATEMENT_TYPE,
+      'subject': [{
+          'name': install_path,
+          'digest': {'sha256': apk_digest},
+      }],
+      'predicateType': PREDICATE_TYPE,
+      'predicate': {
+          'builder': {'id': BUILDER_ID},
+          'buildType': BUILD_TYPE,
+          'invocation': {
+              'parameters': {'module': name},
+          },
+          'buildConfig': {
+              'presigned': presigned,
+              'signingCertificates': [
+                  {'uri': path, 'sha256Fingerprint': fingerprint}
+                  for path, fingerprint in certificates],
+              'tools': [
+                  {'name': tool, 'digest': {'sha256': digest}}
+                  for tool, digest in tools],
+          },
+          'materials': [
+              {'uri': path, 'digest': {'sha256': digest}}
+              for path, digest in inputs],
+      },
+  }
+
+
+def main():
+  """Program entry point."""
+  args = parse_args()
+
+  inputs = [(path, file_digest(path)) for path in args.inputs]
+
+  certificates = []
+  for path in args.certificates:
+    with open(path) as f:
+      certificates.append((path, certificate_fingerprint(f.read())))
+
+  tools = []
+  for tool in args.tools:
+    tool_name, path = tool.split('=', 1)
+    tools.append((tool_name, file_digest(path)))
+
+  statement = provenance_statement(args.name, args.install_path,
+                                   file_digest(args.apk), inputs,
+                                   certificates, args.presigned, tools)
+
+  with open(args.out, 'w') as f:
+    json.dump(statement, f, indent=2, sort_keys=True)
+    f.write('\n')
+
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,88 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for gen_apk_provenance.py."""
+
+import base64
+import hashlib
+import sys
+import unittest
+
+import gen_apk_provenance
+
+sys.dont_write_bytecode = True
+
+
+class CertificateFingerprintTest(unittest.TestCase):
+  """Unit tests for certificate_fingerprint function."""
+
+  def test_fingerprint(self):
+    der = b'not really a certificate'
+    pem = '\n'.join([
+        '-----BEGIN CERTIFICATE-----',
+        base64.b64encode(der).decode('ascii'),
+        '-----END CERTIFICATE-----',
+        ''])
+    self.assertEqual(gen_apk_provenance.certificate_fingerprint(pem),
+                     hashlib.sha256(der).hexdigest())
+
+  def test_not_pem(self):
+    with self.assertRaises(RuntimeError):
+      gen_apk_provenance.certificate_fingerprint('foo')
+
+
+class ProvenanceStatementTest(unittest.TestCase):
+  """Unit tests for provenance_statement function."""
+
+  def test_statement(self):
+    statement = gen_apk_provenance.provenance_statement(
+        'Foo', '/system/app/Foo/Foo.apk', 'apkdigest',
+        [('out/Foo/package-res.apk', 'resdigest')],
+        [('build/target/product/security/testkey.x509.pem', 'certdigest')],
+        False,
+        [('aapt2', 'aapt2digest')])
+
+    self.assertEqual(statement['_type'], gen_apk_provenance.STATEMENT_TYPE)
+    self.assertEqual(statement['subject'], [{
+        'name': '/system/app/Foo/Foo.apk',
+        'digest': {'sha256': 'apkdigest'},
+    }])
+
+    predicate = statement['predicate']
+    self.assertEqual(predicate['invocation']['parameters'], {'module': 'Foo'})
+    self.assertEqual(predicate['materials'], [{
+        'uri': 'out/Foo/package-res.apk',
+        'digest': {'sha256': 'resdigest'},
+    }])
+    self.assertEqual(predicate['buildConfig'], {
+        'presigned': False,
+        'signingCertificates': [{
+            'uri': 'build/target/product/security/testkey.x509.pem',
+            'sha256Fingerprint': 'certdigest',
+        }],
+        'tools': [{'name': 'aapt2', 'digest': {'sha256': 'aapt2digest'}}],
+    })
+
+  def test_presigned(self):
+    statement = gen_apk_provenance.provenance_statement(
+        'Foo', '/system/app/Foo/Foo.apk', 'apkdigest', [], [], True, [])
+    build_config = statement['predicate']['buildConfig']
+    self.assertTrue(build_config['presigned'])
+    self.assertEqual(build_config['signingCertificates'], [])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -0,0 +1,150 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for generating the project.xml and lint.xml files passed to Android Lint.
+
+The project.xml file describes the sources, resources, manifest and classpath of
+a single module, and the lint.xml file overrides the severities of lint checks.
+"""
+
+from __future__ import print_function
+
+import argparse
+from xml.sax.saxutils import quoteattr
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--project_out', dest='project_out', required=True,
+                      help='file to which the project.xml contents will be written.')
+  parser.add_argument('--config_out', dest='config_out', required=True,
+                      help='file to which the lint.xml contents will be written.')
+  parser.add_argument('--name', dest='name', required=True,
+                      help='name of the module.')
+  parser.add_argument('--srcs', dest='srcs', action='append', default=[],
+                      help='file containing whitespace separated list of source files.')
+  parser.add_argument('--classpath', dest='classpath', action='append', default=[],
+                      help='jar file on the classpath of the module.')
+  parser.add_argument('--classes', dest='classes', action='append', default=[],
+                      help='jar file containing the compiled classes of the module.')
+  parser.add_argument('--resources', dest='resources', action='append', default=[],
+                      help='resource file of the module.')
+  parser.add_argument('--manifest', dest='manifest',
+                      help='android manifest of the module.')
+  parser.add_argument('--library', dest='library', action='store_true',
+                      help='mark the module as a library.')
+  parser.add_argument('--test', dest='test', action='store_true',
+                      help='mark the module as a test.')
+  group = parser.add_argument_group('check arguments', 'later arguments override earlier ones.')
+  group.add_argument('--fatal_check', dest='checks', action=_SeverityAction,
+                     severity='fatal', help='treat a lint issue as a fatal error.')
+  group.add_argument('--error_check', dest='checks', action=_SeverityAction,
+                     severity='error', help='treat a lint issue as an error.')
+  group.add_argument('--warning_check', dest='checks', action=_SeverityAction,
+                     severity='warning', help='treat a lint issue as a warning.')
+  group.add_argument('--disable_check', dest='checks', action=_SeverityAction,
+                     severity='ignore', help='disable a lint issue.')
+  return parser.parse_args()
+
+
+class _SeverityAction(argparse.Action):
+  """Collects (issue, severity) pairs in the order they were passed."""
+
+  def __init__(self, option_strings, dest, severity=None, **kwargs):
+    super(_SeverityAction, self).__init__(option_strings, dest, **kwargs)
+    self.severity = severity
+
+  def __call__(self, parser, namespace, values, option_string=None):
+    checks = getattr(namespace, self.dest, None) or []
+    checks.append((values, self.severity))
+    setattr(namespace, self.dest, checks)
+
+
+def read_file_lists(files):
+  """Returns the whitespace separated file names listed in a list of files."""
+
+  ret = []
+  for f in files:
+    with open(f) as lst:
+      ret.extend(lst.read().split())
+  return ret
+
+
+def write_project_xml(f, name, srcs, resources, classes, classpath, manifest,
+                      library, test):
+  """Writes the project.xml describing a single module to a file object."""
+
+  f.write('<?xml version="1.0" encoding="utf-8"?>\n')
+  f.write('<!-- THIS FILE IS GENERATED BY SOONG, DO NOT EDIT -->\n')
+  f.write('<project>\n')
+  f.write('  <root dir="." />\n')
+  f.write('  <module name=%s android="true" library=%s test=%s>\n' % (
+      quoteattr(name), quoteattr(str(library).lower()), quoteattr(str(test).lower())))
+  if manifest:
+    f.write('    <manifest file=%s />\n' % quoteattr(manifest))
+  for src in srcs:
+    f.write('    <src file=%s />\n' % quoteattr(src))
+  for res in resources:
+    f.write('    <resource file=%s />\n' % quoteattr(res))
+  for jar in classes:
+    f.write('    <classes jar=%s />\n' % quoteattr(jar))
+  for jar in classpath:
+    f.write('    <classpath jar=%s />\n' % quoteattr(jar))
+  f.write('  </module>\n')
+  f.write('</project>\n')
+
+
+def write_config_xml(f, checks):
+  """Writes the lint.xml that overrides the severities of lint checks to a file object.
+
+  Args:
+    f: file object to write to.
+    checks: list of (issue, severity) tuples, later entries override earlier ones.
+  """
+
+  severities = {}
+  order = []
+  for issue, severity in checks or []:
+    if issue not in severities:
+      order.append(issue)
+    severities[issue] = severity
+
+  f.write('<?xml version="1.0" encoding="utf-8"?>\n')
+  f.write('<!-- THIS FILE IS GENERATED BY SOONG, DO NOT EDIT -->\n')
+  f.write('<lint>\n')
+  for issue in order:
+    f.write('  <issue id=%s severity=%s />\n' % (quoteattr(issue), quoteattr(severities[issue])))
+  f.write('</lint>\n')
+
+
+def main():
+  """Program entry point."""
+  args = parse_args()
+
+  srcs = read_file_lists(args.srcs)
+
+  with open(args.project_out, 'w') as f:
+    write_project_xml(f, args.name, srcs, args.resources, args.classes,
+                      args.classpath, args.manifest, args.library, args.test)
+
+  with open(args.config_out, 'w') as f:
+    write_config_xml(f, args.checks)
+
+
+if __name__ == '__main__':
+  main()
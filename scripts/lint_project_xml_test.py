@@ -0,0 +1,94 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for lint_project_xml.py."""
+
+import StringIO
+import sys
+import unittest
+from xml.dom import minidom
+
+import lint_project_xml
+
+sys.dont_write_bytecode = True
+
+
+class WriteProjectXmlTest(unittest.TestCase):
+  """Unit tests for write_project_xml function."""
+
+  def write(self, **kwargs):
+    args = dict(name='foo', srcs=[], resources=[], classes=[], classpath=[],
+                manifest=None, library=False, test=False)
+    args.update(kwargs)
+    output = StringIO.StringIO()
+    lint_project_xml.write_project_xml(output, **args)
+    return minidom.parseString(output.getvalue())
+
+  def test_module(self):
+    doc = self.write(library=True)
+    module = doc.getElementsByTagName('module')[0]
+    self.assertEqual(module.getAttribute('name'), 'foo')
+    self.assertEqual(module.getAttribute('library'), 'true')
+    self.assertEqual(module.getAttribute('test'), 'false')
+    self.assertEqual(doc.getElementsByTagName('manifest'), [])
+
+  def test_files(self):
+    doc = self.write(srcs=['a.java', 'b.kt'], resources=['res/values/strings.xml'],
+                     classes=['classes.jar'], classpath=['lib.jar'],
+                     manifest='AndroidManifest.xml')
+
+    def files(tag, attr):
+      return [e.getAttribute(attr) for e in doc.getElementsByTagName(tag)]
+
+    self.assertEqual(files('src', 'file'), ['a.java', 'b.kt'])
+    self.assertEqual(files('resource', 'file'), ['res/values/strings.xml'])
+    self.assertEqual(files('classes', 'jar'), ['classes.jar'])
+    self.assertEqual(files('classpath', 'jar'), ['lib.jar'])
+    self.assertEqual(files('manifest', 'file'), ['AndroidManifest.xml'])
+
+  def test_escaping(self):
+    doc = self.write(name='a"<b>')
+    module = doc.getElementsByTagName('module')[0]
+    self.assertEqual(module.getAttribute('name'), 'a"<b>')
+
+
+class WriteConfigXmlTest(unittest.TestCase):
+  """Unit tests for write_config_xml function."""
+
+  def severities(self, checks):
+    output = StringIO.StringIO()
+    lint_project_xml.write_config_xml(output, checks)
+    doc = minidom.parseString(output.getvalue())
+    return [(e.getAttribute('id'), e.getAttribute('severity'))
+            for e in doc.getElementsByTagName('issue')]
+
+  def test_none(self):
+    self.assertEqual(self.severities(None), [])
+
+  def test_severities(self):
+    self.assertEqual(
+        self.severities([('NewApi', 'fatal'), ('HardcodedText', 'ignore')]),
+        [('NewApi', 'fatal'), ('HardcodedText', 'ignore')])
+
+  def test_override(self):
+    self.assertEqual(
+        self.severities([('NewApi', 'warning'), ('HardcodedText', 'ignore'),
+                         ('NewApi', 'fatal')]),
+        [('NewApi', 'fatal'), ('HardcodedText', 'ignore')])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -19,6 +19,8 @@
 from __future__ import print_function
 
 import argparse
+import re
+import subprocess
 import sys
 from xml.dom import minidom
 
@@ -52,8 +54,18 @@ def parse_args():
                       dest='extract_target_sdk_version',
                       action='store_true',
                       help='print the targetSdkVersion from the manifest')
+  parser.add_argument('--known-uses-library', dest='known_uses_libraries',
+                      action='append',
+                      help='specify a library that the build system can provide for <uses-library> tags')
+  parser.add_argument('--extract-uses-libraries',
+                      dest='extract_uses_libraries',
+                      help='write the uses-library entries of the manifest that are known to the build system '
+                      'to a file, one per line')
+  parser.add_argument('--aapt', dest='aapt',
+                      help='path to aapt2, if set the input is an APK whose <uses-library> tags are read with '
+                      'aapt2 dump badging')
   parser.add_argument('--output', '-o', dest='output', help='output AndroidManifest.xml file')
-  parser.add_argument('input', help='input AndroidManifest.xml file')
+  parser.add_argument('input', help='input AndroidManifest.xml or APK file')
   return parser.parse_args()
 
 
@@ -130,6 +142,44 @@ def parse_uses_library(application):
   return first_unique_elements(uses_libraries), first_unique_elements(optional_uses_libraries)
 
 
+def parse_uses_library_badging(badging):
+  """Extract uses-library entries from the output of aapt2 dump badging.
+
+  Args:
+    badging: the output of aapt2 dump badging for an APK.
+  """
+
+  uses_libraries = re.findall(r"^uses-library:'(.*)'$", badging, re.MULTILINE)
+  optional_uses_libraries = re.findall(r"^uses-library-not-required:'(.*)'$", badging, re.MULTILINE)
+
+  return first_unique_elements(uses_libraries), first_unique_elements(optional_uses_libraries)
+
+
+def extract_uses_libraries(uses_libraries, optional_uses_libraries, known_uses_libraries):
+  """Returns the uses-library entries that the build system provides for dexpreopt.
+
+  Optional libraries that are not known to the build system are skipped, as they may be missing
+  on the device.
+
+  Args:
+    uses_libraries: the names of the required <uses-library> tags in the manifest.
+    optional_uses_libraries: the names of the <uses-library> tags with required="false".
+    known_uses_libraries: the names of the libraries known to the build system.
+  Raises:
+    ManifestMismatchError: a required library is not known to the build system
+  """
+
+  if known_uses_libraries is None:
+    known_uses_libraries = []
+
+  unknown = [x for x in uses_libraries if x not in known_uses_libraries]
+  if unknown:
+    raise ManifestMismatchError('required <uses-library> tags "%s" are not known to the build system' %
+                                ', '.join(unknown))
+
+  return uses_libraries + [x for x in optional_uses_libraries if x in known_uses_libraries]
+
+
 def first_unique_elements(l):
   result = []
   [result.append(x) for x in l if x not in result]
@@ -192,6 +242,21 @@ def main():
   try:
     args = parse_args()
 
+    if args.aapt:
+      # The input is an APK, only its <uses-library> tags are checked.
+      badging = subprocess.check_output([args.aapt, 'dump', 'badging', args.input])
+      uses_libraries, optional_uses_libraries = parse_uses_library_badging(badging)
+
+      if args.extract_uses_libraries:
+        libs = extract_uses_libraries(uses_libraries, optional_uses_libraries,
+                                      args.known_uses_libraries)
+        with open(args.extract_uses_libraries, 'w') as f:
+          f.write(''.join(lib + '\n' for lib in libs))
+      return
+
+    if args.extract_uses_libraries:
+      raise RuntimeError('--extract-uses-libraries requires --aapt')
+
     doc = minidom.parse(args.input)
 
     if args.enforce_uses_libraries:
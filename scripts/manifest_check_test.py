@@ -162,5 +162,34 @@ class ExtractTargetSdkVersionTest(unittest.TestCase):
     target_sdk_version = manifest_check.extract_target_sdk_version(doc)
     self.assertEqual(target_sdk_version, '28')
 
+
+class ExtractUsesLibrariesTest(unittest.TestCase):
+  badging = (
+      "package: name='com.android.foo' versionCode='1' versionName='1.0'\n"
+      "sdkVersion:'28'\n"
+      "uses-library:'foo'\n"
+      "uses-library-not-required:'bar'\n"
+      "uses-library:'baz'\n"
+      "uses-library-not-required:'qux'\n"
+      "application-label:'Foo'\n")
+
+  def test_parse_badging(self):
+    uses_libraries, optional_uses_libraries = manifest_check.parse_uses_library_badging(self.badging)
+    self.assertEqual(uses_libraries, ['foo', 'baz'])
+    self.assertEqual(optional_uses_libraries, ['bar', 'qux'])
+
+  def test_known(self):
+    libs = manifest_check.extract_uses_libraries(['foo', 'baz'], ['bar', 'qux'],
+                                                 ['foo', 'bar', 'baz', 'qux'])
+    self.assertEqual(libs, ['foo', 'baz', 'bar', 'qux'])
+
+  def test_unknown_optional(self):
+    libs = manifest_check.extract_uses_libraries(['foo'], ['bar', 'qux'], ['foo', 'qux'])
+    self.assertEqual(libs, ['foo', 'qux'])
+
+  def test_unknown_required(self):
+    with self.assertRaises(manifest_check.ManifestMismatchError):
+      manifest_check.extract_uses_libraries(['foo', 'baz'], [], ['foo'])
+
 if __name__ == '__main__':
   unittest.main(verbosity=2)
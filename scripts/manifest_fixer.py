@@ -62,12 +62,24 @@ def parse_args():
   parser.add_argument('--has-no-code', dest='has_no_code', action='store_true',
                       help=('adds hasCode="false" attribute to application. Ignored if application elem '
                             'already has a hasCode attribute.'))
+  parser.add_argument('--test-only', dest='test_only', action='store_true',
+                      help=('adds testOnly="true" attribute to application. Assign true value if application elem '
+                            'already has a testOnly attribute.'))
+  parser.add_argument('--override-min-sdk-version', dest='override_min_sdk_version', action='store_true',
+                      help='replace the minimum sdk version in the manifest even if it is higher')
+  parser.add_argument('--logging-parent', dest='logging_parent', default='',
+                      help=('specify logging parent as an additional <meta-data> tag. '
+                            'This value is ignored if the logging_parent meta-data tag is present.'))
+  parser.add_argument('--manifest-value', dest='manifest_values', action='append',
+                      help=('specify a value as <name>=<value> to replace the ${<name>} placeholders in the '
+                            'attributes of the manifest with'))
   parser.add_argument('input', help='input AndroidManifest.xml file')
   parser.add_argument('output', help='output AndroidManifest.xml file')
   return parser.parse_args()
 
 
-def raise_min_sdk_version(doc, min_sdk_version, target_sdk_version, library):
+def raise_min_sdk_version(doc, min_sdk_version, target_sdk_version, library,
+                          override_min_sdk_version=False):
   """Ensure the manifest contains a <uses-sdk> tag with a minSdkVersion.
 
   Args:
@@ -75,6 +87,8 @@ def raise_min_sdk_version(doc, min_sdk_version, target_sdk_version, library):
     min_sdk_version: The requested minSdkVersion attribute.
     target_sdk_version: The requested targetSdkVersion attribute.
     library: True if the manifest is for a library.
+    override_min_sdk_version: True if an existing minSdkVersion attribute should
+      be replaced even if it is higher than the requested value.
   Raises:
     RuntimeError: invalid manifest
   """
@@ -97,14 +111,14 @@ def raise_min_sdk_version(doc, min_sdk_version, target_sdk_version, library):
     manifest.insertBefore(doc.createTextNode(indent), manifest.firstChild)
 
   # Get or insert the minSdkVersion attribute.  If it is already present, make
-  # sure it as least the requested value.
+  # sure it as least the requested value, or replace it when overriding.
   min_attr = element.getAttributeNodeNS(android_ns, 'minSdkVersion')
   if min_attr is None:
     min_attr = doc.createAttributeNS(android_ns, 'android:minSdkVersion')
     min_attr.value = min_sdk_version
     element.setAttributeNode(min_attr)
   else:
-    if compare_version_gt(min_sdk_version, min_attr.value):
+    if override_min_sdk_version or compare_version_gt(min_sdk_version, min_attr.value):
       min_attr.value = min_sdk_version
 
   # Insert the targetSdkVersion attribute if it is missing.  If it is already
@@ -270,6 +284,97 @@ def set_has_code_to_false(doc):
   application.setAttributeNode(attr)
 
 
+def add_logging_parent(doc, logging_parent_value):
+  """Add logging parent as an additional <meta-data> tag.
+
+  Args:
+    doc: The XML document. May be modified by this function.
+    logging_parent_value: A string representing the logging
+      parent value.
+  Raises:
+    RuntimeError: Invalid manifest
+  """
+  manifest = parse_manifest(doc)
+
+  logging_parent_key = 'android.content.pm.LOGGING_PARENT'
+  elems = get_children_with_tag(manifest, 'application')
+  application = elems[0] if len(elems) == 1 else None
+  if len(elems) > 1:
+    raise RuntimeError('found multiple <application> tags')
+  elif not elems:
+    application = doc.createElement('application')
+    indent = get_indent(manifest.firstChild, 1)
+    first = manifest.firstChild
+    manifest.insertBefore(doc.createTextNode(indent), first)
+    manifest.insertBefore(application, first)
+
+  indent = get_indent(application.firstChild, 2)
+
+  last = application.lastChild
+  if last is not None and last.nodeType != minidom.Node.TEXT_NODE:
+    last = None
+
+  if not find_child_with_attribute(application, 'meta-data', android_ns,
+                                   'name', logging_parent_key):
+    ul = doc.createElement('meta-data')
+    ul.setAttributeNS(android_ns, 'android:name', logging_parent_key)
+    ul.setAttributeNS(android_ns, 'android:value', logging_parent_value)
+    application.insertBefore(doc.createTextNode(indent), last)
+    application.insertBefore(ul, last)
+    last = application.lastChild
+
+  # align the closing tag with the opening tag if it's not
+  # indented
+  if last and last.nodeType != minidom.Node.TEXT_NODE:
+    indent = get_indent(application.previousSibling, 1)
+    application.appendChild(doc.createTextNode(indent))
+
+
+def set_test_only_flag_to_true(doc):
+  manifest = parse_manifest(doc)
+  elems = get_children_with_tag(manifest, 'application')
+  application = elems[0] if len(elems) == 1 else None
+  if len(elems) > 1:
+    raise RuntimeError('found multiple <application> tags')
+  elif not elems:
+    application = doc.createElement('application')
+    indent = get_indent(manifest.firstChild, 1)
+    first = manifest.firstChild
+    manifest.insertBefore(doc.createTextNode(indent), first)
+    manifest.insertBefore(application, first)
+
+  attr = application.getAttributeNodeNS(android_ns, 'testOnly')
+  if attr is None:
+    attr = doc.createAttributeNS(android_ns, 'android:testOnly')
+    application.setAttributeNode(attr)
+  attr.value = 'true'
+
+
+def replace_placeholders(doc, manifest_values):
+  """Replace the ${<name>} placeholders in the attributes of the manifest.
+
+  Args:
+    doc: The XML document.  May be modified by this function.
+    manifest_values: A list of <name>=<value> strings.
+  Raises:
+    RuntimeError: invalid manifest value
+  """
+
+  placeholders = {}
+  for manifest_value in manifest_values:
+    name, sep, value = manifest_value.partition('=')
+    if not sep or not name:
+      raise RuntimeError('invalid manifest value %s, expected <name>=<value>' % manifest_value)
+    placeholders['${%s}' % name] = value
+
+  for elem in doc.getElementsByTagName('*'):
+    for attr in elem.attributes.values():
+      value = attr.value
+      for placeholder, replacement in placeholders.items():
+        value = value.replace(placeholder, replacement)
+      attr.value = value
+
+
 def main():
   """Program entry point."""
   try:
@@ -279,8 +384,12 @@ def main():
 
     ensure_manifest_android_ns(doc)
 
+    if args.manifest_values:
+      replace_placeholders(doc, args.manifest_values)
+
     if args.raise_min_sdk_version:
-      raise_min_sdk_version(doc, args.min_sdk_version, args.target_sdk_version, args.library)
+      raise_min_sdk_version(doc, args.min_sdk_version, args.target_sdk_version, args.library,
+                            args.override_min_sdk_version)
 
     if args.uses_libraries:
       add_uses_libraries(doc, args.uses_libraries, True)
@@ -297,6 +406,12 @@ def main():
     if args.has_no_code:
       set_has_code_to_false(doc)
 
+    if args.test_only:
+      set_test_only_flag_to_true(doc)
+
+    if args.logging_parent:
+      add_logging_parent(doc, args.logging_parent)
+
     if args.extract_native_libs is not None:
       add_extract_native_libs(doc, args.extract_native_libs)
 
@@ -55,10 +55,12 @@ class RaiseMinSdkVersionTest(unittest.TestCase):
   """Unit tests for raise_min_sdk_version function."""
 
   def raise_min_sdk_version_test(self, input_manifest, min_sdk_version,
-                                 target_sdk_version, library):
+                                 target_sdk_version, library,
+                                 override_min_sdk_version=False):
     doc = minidom.parseString(input_manifest)
     manifest_fixer.raise_min_sdk_version(doc, min_sdk_version,
-                                         target_sdk_version, library)
+                                         target_sdk_version, library,
+                                         override_min_sdk_version)
     output = StringIO.StringIO()
     manifest_fixer.write_xml(output, doc)
     return output.getvalue()
@@ -121,6 +123,15 @@ class RaiseMinSdkVersionTest(unittest.TestCase):
     output = self.raise_min_sdk_version_test(manifest_input, '27', '27', False)
     self.assertEqual(output, expected)
 
+  def test_override_min(self):
+    """Tests overriding a minSdkVersion that is higher than requested."""
+
+    manifest_input = self.manifest_tmpl % self.uses_sdk(min='28')
+    expected = self.manifest_tmpl % self.uses_sdk(min='27', target='27')
+    output = self.raise_min_sdk_version_test(manifest_input, '27', '27', False,
+                                             override_min_sdk_version=True)
+    self.assertEqual(output, expected)
+
   def test_raise_codename(self):
     """Tests raising a minSdkVersion attribute to a codename."""
 
@@ -466,5 +477,110 @@ class AddNoCodeApplicationTest(unittest.TestCase):
     self.assertEqual(output, manifest_input)
 
 
+class AddTestOnlyApplicationTest(unittest.TestCase):
+  """Unit tests for set_test_only_flag_to_true function."""
+
+  def run_test(self, input_manifest):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.set_test_only_flag_to_true(doc)
+    output = StringIO.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '</manifest>\n')
+
+  def test_no_application(self):
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % '    <application android:testOnly="true"/>\n'
+    output = self.run_test(manifest_input)
+    self.assertEqual(output, expected)
+
+  def test_has_application_no_test_only(self):
+    manifest_input = self.manifest_tmpl % '    <application/>\n'
+    expected = self.manifest_tmpl % '    <application android:testOnly="true"/>\n'
+    output = self.run_test(manifest_input)
+    self.assertEqual(output, expected)
+
+  def test_has_application_test_only_false(self):
+    manifest_input = self.manifest_tmpl % '    <application android:testOnly="false"/>\n'
+    expected = self.manifest_tmpl % '    <application android:testOnly="true"/>\n'
+    output = self.run_test(manifest_input)
+    self.assertEqual(output, expected)
+
+
+class AddLoggingParentTest(unittest.TestCase):
+  """Unit tests for add_logging_parent function."""
+
+  def add_logging_parent_test(self, input_manifest, logging_parent):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.add_logging_parent(doc, logging_parent)
+    output = StringIO.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '%s'
+      '</manifest>\n')
+
+  def logging_parent(self, logging_parent):
+    meta_text = ('<meta-data android:name='
+                 '"android.content.pm.LOGGING_PARENT" '
+                 'android:value="%s"/>\n') % logging_parent
+    return '    <application>\n        %s    </application>\n' % meta_text
+
+  def test_existing_logging_parent(self):
+    """Tests manifest_fixer with a logging_parent already in the manifest."""
+    manifest_input = self.manifest_tmpl % self.logging_parent('BAR')
+    output = self.add_logging_parent_test(manifest_input, 'FOO')
+    self.assertEqual(output, manifest_input)
+
+  def test_logging_parent(self):
+    """Tests manifest_fixer with a logging_parent."""
+    manifest_input = self.manifest_tmpl % ''
+    expected = self.manifest_tmpl % self.logging_parent('FOO')
+    output = self.add_logging_parent_test(manifest_input, 'FOO')
+    self.assertEqual(output, expected)
+
+
+class ReplacePlaceholdersTest(unittest.TestCase):
+  """Unit tests for replace_placeholders function."""
+
+  def run_test(self, input_manifest, manifest_values):
+    doc = minidom.parseString(input_manifest)
+    manifest_fixer.replace_placeholders(doc, manifest_values)
+    output = StringIO.StringIO()
+    manifest_fixer.write_xml(output, doc)
+    return output.getvalue()
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest package="%s" xmlns:android="http://schemas.android.com/apk/res/android">\n'
+      '    <application android:label="%s"/>\n'
+      '</manifest>\n')
+
+  def test_replace(self):
+    manifest_input = self.manifest_tmpl % ('com.android.${brand}', '${brand} ${label}')
+    expected = self.manifest_tmpl % ('com.android.foo', 'foo Foo=Bar')
+    output = self.run_test(manifest_input, ['brand=foo', 'label=Foo=Bar'])
+    self.assertEqual(output, expected)
+
+  def test_unknown_placeholder(self):
+    manifest_input = self.manifest_tmpl % ('com.android.${other}', '${brand}')
+    expected = self.manifest_tmpl % ('com.android.${other}', '')
+    output = self.run_test(manifest_input, ['brand='])
+    self.assertEqual(output, expected)
+
+  def test_invalid_value(self):
+    manifest_input = self.manifest_tmpl % ('com.android.foo', 'Foo')
+    with self.assertRaises(RuntimeError):
+      self.run_test(manifest_input, ['brand'])
+
+
 if __name__ == '__main__':
   unittest.main(verbosity=2)
@@ -0,0 +1,116 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for listing the public and overlayable resources declared in resource values files.
+
+The output has one line per declaration, sorted:
+
+  public string/app_name
+  overlayable ThemeResources product|system color/accent
+"""
+
+from __future__ import print_function
+
+import argparse
+import sys
+from xml.dom import minidom
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--output', dest='output', required=True,
+                      help='output file listing the public and overlayable resources')
+  parser.add_argument('inputs', nargs='*', help='input resource values xml files')
+  return parser.parse_args()
+
+
+def child_elements(node, tag):
+  return [c for c in node.childNodes
+          if c.nodeType == minidom.Node.ELEMENT_NODE and c.tagName == tag]
+
+
+def resource_name(element, default_type=None):
+  """Returns type/name of the resource declared by a public or item element.
+
+  Args:
+    element: the public or item element.
+    default_type: the type of the resource if the element has no type attribute, used for the
+      public elements of a public-group.
+  Raises:
+    RuntimeError: the element has no type or no name.
+  """
+
+  res_type = element.getAttribute('type') or default_type
+  name = element.getAttribute('name')
+  if not res_type or not name:
+    raise RuntimeError('<%s> must have a type and a name' % element.tagName)
+  return res_type + '/' + name
+
+
+def resource_api(doc):
+  """Returns the public and overlayable resources declared in a resource values document.
+
+  Args:
+    doc: the parsed resource values xml file.
+  """
+
+  api = []
+  resources = doc.documentElement
+  if resources.tagName != 'resources':
+    return api
+
+  for public in child_elements(resources, 'public'):
+    api.append('public ' + resource_name(public))
+  for group in child_elements(resources, 'public-group'):
+    for public in child_elements(group, 'public'):
+      api.append('public ' + resource_name(public, group.getAttribute('type')))
+
+  for overlayable in child_elements(resources, 'overlayable'):
+    overlayable_name = overlayable.getAttribute('name')
+    if not overlayable_name:
+      raise RuntimeError('<overlayable> must have a name')
+    for policy in child_elements(overlayable, 'policy'):
+      policy_type = policy.getAttribute('type')
+      if not policy_type:
+        raise RuntimeError('<policy> of overlayable %s must have a type' % overlayable_name)
+      for item in child_elements(policy, 'item'):
+        api.append(' '.join(['overlayable', overlayable_name, policy_type, resource_name(item)]))
+
+  return api
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    api = []
+    for path in args.inputs:
+      api.extend(resource_api(minidom.parse(path)))
+
+    with open(args.output, 'w') as f:
+      for line in sorted(set(api)):
+        f.write(line + '\n')
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: ' + str(err), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,78 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for resource_api.py."""
+
+import sys
+import unittest
+from xml.dom import minidom
+
+import resource_api
+
+sys.dont_write_bytecode = True
+
+
+class ResourceApiTest(unittest.TestCase):
+  """Unit tests for resource_api function."""
+
+  def resource_api(self, xml):
+    return resource_api.resource_api(minidom.parseString(xml))
+
+  def test_public(self):
+    xml = ('<resources>'
+           '<public type="string" name="app_name" id="0x7f010000"/>'
+           '<public-group type="color" first-id="0x7f020000">'
+           '<public name="accent"/>'
+           '<public name="background"/>'
+           '</public-group>'
+           '<string name="private">private</string>'
+           '</resources>')
+    self.assertEqual(self.resource_api(xml),
+                     ['public string/app_name', 'public color/accent', 'public color/background'])
+
+  def test_overlayable(self):
+    xml = ('<resources>'
+           '<overlayable name="ThemeResources" actor="overlay://theme">'
+           '<policy type="product|system">'
+           '<item type="color" name="accent"/>'
+           '</policy>'
+           '<policy type="public">'
+           '<item type="bool" name="config_dark"/>'
+           '</policy>'
+           '</overlayable>'
+           '</resources>')
+    self.assertEqual(self.resource_api(xml),
+                     ['overlayable ThemeResources product|system color/accent',
+                      'overlayable ThemeResources public bool/config_dark'])
+
+  def test_no_declarations(self):
+    xml = '<resources><string name="app_name">Foo</string></resources>'
+    self.assertEqual(self.resource_api(xml), [])
+
+  def test_missing_type(self):
+    with self.assertRaises(RuntimeError):
+      self.resource_api('<resources><public name="app_name"/></resources>')
+
+  def test_missing_policy_type(self):
+    xml = ('<resources>'
+           '<overlayable name="ThemeResources"><policy><item type="color" name="accent"/></policy></overlayable>'
+           '</resources>')
+    with self.assertRaises(RuntimeError):
+      self.resource_api(xml)
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
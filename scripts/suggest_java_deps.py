@@ -0,0 +1,131 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for suggesting missing direct dependencies for strict_java_deps.
+
+Reads the log of a javac invocation that was compiled against only the direct
+dependencies of a module, finds the classes and packages javac could not
+resolve, and reports which of the candidate modules provide them.
+"""
+
+from __future__ import print_function
+
+import argparse
+import re
+import sys
+import zipfile
+
+
+_PACKAGE_DOES_NOT_EXIST = re.compile(r'error: package ([\w.]+) does not exist')
+_SYMBOL = re.compile(r'symbol:\s+class (\w+)')
+_LOCATION_PACKAGE = re.compile(r'location: package ([\w.]+)')
+_STATIC_IMPORT = re.compile(r'error: cannot find symbol\s*\n\s*import static ([\w.]+)\.\w+;')
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--module', dest='module', required=True,
+                      help='name of the module being checked')
+  parser.add_argument('--log', dest='log', required=True,
+                      help='javac output to parse')
+  parser.add_argument('--candidate', dest='candidates', action='append',
+                      default=[],
+                      help='module that could be added as a direct dependency, '
+                      'as <module>:<header jar>')
+  parser.add_argument('--error', dest='error', action='store_true',
+                      help='exit with an error if javac reported any errors')
+  return parser.parse_args()
+
+
+def parse_missing(log):
+  """Returns the sets of packages and fully qualified classes javac could not find."""
+
+  packages = set(_PACKAGE_DOES_NOT_EXIST.findall(log))
+  classes = set(_STATIC_IMPORT.findall(log))
+
+  lines = log.splitlines()
+  for i, line in enumerate(lines):
+    symbol = _SYMBOL.search(line)
+    if symbol and i + 1 < len(lines):
+      location = _LOCATION_PACKAGE.search(lines[i + 1])
+      if location:
+        classes.add(location.group(1) + '.' + symbol.group(1))
+
+  return packages, classes
+
+
+def jar_contents(jar):
+  """Returns the sets of packages and fully qualified classes in a jar."""
+
+  packages = set()
+  classes = set()
+  with zipfile.ZipFile(jar) as z:
+    for name in z.namelist():
+      if not name.endswith('.class'):
+        continue
+      name = name[:-len('.class')].split('$')[0].replace('/', '.')
+      classes.add(name)
+      packages.add(name.rpartition('.')[0])
+  return packages, classes
+
+
+def suggest(missing_packages, missing_classes, candidates):
+  """Returns the names of the candidate modules that provide the missing packages or classes.
+
+  Args:
+    missing_packages: set of packages javac could not find.
+    missing_classes: set of fully qualified classes javac could not find.
+    candidates: list of (module, (packages, classes)) tuples.
+  """
+
+  suggestions = []
+  for module, (packages, classes) in candidates:
+    if missing_packages & packages or missing_classes & classes:
+      if module not in suggestions:
+        suggestions.append(module)
+  return suggestions
+
+
+def main():
+  """Program entry point."""
+  args = parse_args()
+
+  with open(args.log) as f:
+    log = f.read()
+
+  candidates = []
+  for candidate in args.candidates:
+    module, _, jar = candidate.partition(':')
+    candidates.append((module, jar_contents(jar)))
+
+  missing_packages, missing_classes = parse_missing(log)
+  suggestions = suggest(missing_packages, missing_classes, candidates)
+
+  prefix = 'error' if args.error else 'warning'
+  print('%s: module %s does not compile with strict_java_deps:' %
+        (prefix, args.module), file=sys.stderr)
+  print(log, file=sys.stderr)
+  if suggestions:
+    print('%s: module %s should add the following to libs or static_libs: %s' %
+          (prefix, args.module, ' '.join(suggestions)), file=sys.stderr)
+
+  if args.error:
+    sys.exit(1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,84 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for suggest_java_deps.py."""
+
+import sys
+import unittest
+
+import suggest_java_deps
+
+sys.dont_write_bytecode = True
+
+
+class ParseMissingTest(unittest.TestCase):
+  """Unit tests for parse_missing function."""
+
+  def test_package_does_not_exist(self):
+    log = ('a/A.java:3: error: package com.example.b does not exist\n'
+           'import com.example.b.B;\n'
+           '                    ^\n')
+    packages, classes = suggest_java_deps.parse_missing(log)
+    self.assertEqual(packages, set(['com.example.b']))
+    self.assertEqual(classes, set())
+
+  def test_cannot_find_symbol(self):
+    log = ('a/A.java:4: error: cannot find symbol\n'
+           'import com.example.a.C;\n'
+           '                    ^\n'
+           '  symbol:   class C\n'
+           '  location: package com.example.a\n')
+    packages, classes = suggest_java_deps.parse_missing(log)
+    self.assertEqual(packages, set())
+    self.assertEqual(classes, set(['com.example.a.C']))
+
+  def test_symbol_in_class(self):
+    log = ('a/A.java:10: error: cannot find symbol\n'
+           '    C c;\n'
+           '    ^\n'
+           '  symbol:   class C\n'
+           '  location: class A\n')
+    packages, classes = suggest_java_deps.parse_missing(log)
+    self.assertEqual(packages, set())
+    self.assertEqual(classes, set())
+
+
+class SuggestTest(unittest.TestCase):
+  """Unit tests for suggest function."""
+
+  candidates = [
+      ('b', (set(['com.example.b']), set(['com.example.b.B']))),
+      ('c', (set(['com.example.a']), set(['com.example.a.C']))),
+  ]
+
+  def test_package(self):
+    self.assertEqual(
+        suggest_java_deps.suggest(set(['com.example.b']), set(), self.candidates),
+        ['b'])
+
+  def test_class(self):
+    self.assertEqual(
+        suggest_java_deps.suggest(set(), set(['com.example.a.C']), self.candidates),
+        ['c'])
+
+  def test_none(self):
+    self.assertEqual(
+        suggest_java_deps.suggest(set(['com.example.d']), set(), self.candidates),
+        [])
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
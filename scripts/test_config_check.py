@@ -0,0 +1,143 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""A tool for checking that a handwritten test config agrees with the test apk."""
+
+from __future__ import print_function
+
+import argparse
+import shutil
+import sys
+from xml.dom import minidom
+
+
+from manifest import android_ns
+from manifest import get_children_with_tag
+from manifest import parse_manifest
+
+
+class TestConfigMismatchError(Exception):
+  pass
+
+
+def parse_args():
+  """Parse commandline arguments."""
+
+  parser = argparse.ArgumentParser()
+  parser.add_argument('--manifest', dest='manifest', required=True,
+                      help='AndroidManifest.xml of the test apk')
+  parser.add_argument('--apk', dest='apk', required=True,
+                      help='file name of the test apk')
+  parser.add_argument('input', help='input test config file')
+  parser.add_argument('output', help='output test config file, written if the checks pass')
+  return parser.parse_args()
+
+
+def get_options(element, name):
+  """Returns the values of the <option> tags with a name anywhere below an element."""
+
+  return [option.getAttribute('value') for option in element.getElementsByTagName('option')
+          if option.getAttribute('name') == name]
+
+
+def manifest_package_name(manifest):
+  """Returns the package attribute of the <manifest> tag."""
+
+  return manifest.getAttribute('package')
+
+
+def manifest_instrumentation_runners(manifest, package_name):
+  """Returns the fully qualified names of the <instrumentation> tags in the manifest."""
+
+  runners = []
+  for instrumentation in get_children_with_tag(manifest, 'instrumentation'):
+    name = instrumentation.getAttributeNodeNS(android_ns, 'name')
+    if name is None:
+      continue
+    runner = name.value
+    if runner.startswith('.'):
+      runner = package_name + runner
+    elif '.' not in runner:
+      runner = package_name + '.' + runner
+    runners.append(runner)
+  return runners
+
+
+def check_test_config(config, manifest_doc, apk):
+  """Verify that a test config matches the manifest and the name of the test apk.
+
+  The package is checked against the package of the manifest, like in the test configs generated by
+  the build system, even if the build system renames the package of the test apk.
+
+  Args:
+    config: the test config XML document.
+    manifest_doc: the AndroidManifest.xml XML document of the test apk.
+    apk: the file name of the test apk.
+  Raises:
+    RuntimeError: invalid manifest or test config
+    TestConfigMismatchError: test config does not match
+  """
+
+  manifest = parse_manifest(manifest_doc)
+  package_name = manifest_package_name(manifest)
+
+  configuration = config.documentElement
+  if configuration.tagName != 'configuration':
+    raise RuntimeError('expected configuration tag at root')
+
+  err = []
+
+  test_file_names = []
+  for preparer in get_children_with_tag(configuration, 'target_preparer'):
+    test_file_names.extend(get_options(preparer, 'test-file-name'))
+  if test_file_names and apk not in test_file_names:
+    err.append('test config installs "%s" but the test apk is "%s"' %
+               ('", "'.join(test_file_names), apk))
+
+  runners = manifest_instrumentation_runners(manifest, package_name)
+  for test in get_children_with_tag(configuration, 'test'):
+    for package in get_options(test, 'package'):
+      if package != package_name:
+        err.append('test config runs package "%s" but the test apk has package "%s"' %
+                   (package, package_name))
+    for runner in get_options(test, 'runner'):
+      if runners and runner not in runners:
+        err.append('test config uses runner "%s" but the test apk declares instrumentation "%s"' %
+                   (runner, '", "'.join(runners)))
+
+  if err:
+    raise TestConfigMismatchError('\n'.join(err))
+
+
+def main():
+  """Program entry point."""
+  try:
+    args = parse_args()
+
+    config = minidom.parse(args.input)
+    manifest_doc = minidom.parse(args.manifest)
+
+    check_test_config(config, manifest_doc, args.apk)
+
+    shutil.copyfile(args.input, args.output)
+
+  # pylint: disable=broad-except
+  except Exception as err:
+    print('error: %s: %s' % (args.input, str(err)), file=sys.stderr)
+    sys.exit(-1)
+
+if __name__ == '__main__':
+  main()
@@ -0,0 +1,91 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2019 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Unit tests for test_config_check.py."""
+
+import sys
+import unittest
+from xml.dom import minidom
+
+import test_config_check
+
+sys.dont_write_bytecode = True
+
+
+class CheckTestConfigTest(unittest.TestCase):
+  """Unit tests for check_test_config function."""
+
+  manifest_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<manifest xmlns:android="http://schemas.android.com/apk/res/android"\n'
+      '    package="com.android.foo.tests">\n'
+      '    <instrumentation android:name="%s"\n'
+      '        android:targetPackage="com.android.foo" />\n'
+      '</manifest>\n')
+
+  config_tmpl = (
+      '<?xml version="1.0" encoding="utf-8"?>\n'
+      '<configuration description="Runs FooTests">\n'
+      '    <target_preparer class="com.android.tradefed.targetprep.suite.SuiteApkInstaller">\n'
+      '        <option name="test-file-name" value="%s" />\n'
+      '    </target_preparer>\n'
+      '    <test class="com.android.tradefed.testtype.AndroidJUnitTest">\n'
+      '        <option name="package" value="%s" />\n'
+      '        <option name="runner" value="%s" />\n'
+      '    </test>\n'
+      '</configuration>\n')
+
+  runner = 'androidx.test.runner.AndroidJUnitRunner'
+
+  def run_test(self, manifest_runner, apk, package, runner):
+    manifest = minidom.parseString(self.manifest_tmpl % manifest_runner)
+    config = minidom.parseString(self.config_tmpl % (apk, package, runner))
+    try:
+      test_config_check.check_test_config(config, manifest, 'FooTests.apk')
+      return True
+    except test_config_check.TestConfigMismatchError:
+      return False
+
+  def test_matches(self):
+    self.assertTrue(self.run_test(self.runner, 'FooTests.apk', 'com.android.foo.tests', self.runner))
+
+  def test_apk_mismatch(self):
+    self.assertFalse(self.run_test(self.runner, 'BarTests.apk', 'com.android.foo.tests', self.runner))
+
+  def test_package_mismatch(self):
+    self.assertFalse(self.run_test(self.runner, 'FooTests.apk', 'com.android.bar.tests', self.runner))
+
+  def test_runner_mismatch(self):
+    self.assertFalse(self.run_test(self.runner, 'FooTests.apk', 'com.android.foo.tests',
+                                   'android.test.InstrumentationTestRunner'))
+
+  def test_relative_runner(self):
+    self.assertTrue(self.run_test('.FooRunner', 'FooTests.apk', 'com.android.foo.tests',
+                                  'com.android.foo.tests.FooRunner'))
+
+  def test_no_test_file_name(self):
+    manifest = minidom.parseString(self.manifest_tmpl % self.runner)
+    config = minidom.parseString(
+        '<configuration>\n'
+        '    <test class="com.android.tradefed.testtype.AndroidJUnitTest">\n'
+        '        <option name="package" value="com.android.foo.tests" />\n'
+        '    </test>\n'
+        '</configuration>\n')
+    test_config_check.check_test_config(config, manifest, 'FooTests.apk')
+
+
+if __name__ == '__main__':
+  unittest.main(verbosity=2)
@@ -33,4 +33,6 @@ func init() {
 	pctx.SourcePathVariable("PythonBinaryHostTestConfigTemplate", "build/make/core/python_binary_host_test_config_template.xml")
 
 	pctx.SourcePathVariable("EmptyTestConfig", "build/make/core/empty_test_config.xml")
+
+	pctx.HostBinToolVariable("TestConfigCheckCmd", "test_config_check")
 }